@@ -4,15 +4,33 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
 type Config struct {
-	Database DatabaseConfig
-	OpenFGA  OpenFGAConfig
-	Server   ServerConfig
-	Logging  LoggingConfig
+	Database      DatabaseConfig
+	OpenFGA       OpenFGAConfig
+	Authorization AuthorizationConfig
+	Server        ServerConfig
+	Logging       LoggingConfig
+	Password      PasswordPolicyConfig
+	Hashing       PasswordHashConfig
+	Lifecycle     LifecycleConfig
+	Assignment    AssignmentConfig
+	Certification CertificationConfig
+	Session       SessionConfig
+	Impersonation ImpersonationConfig
+	TokenExchange TokenExchangeConfig
+	Federation    FederationConfig
+	KeyRing       KeyRingConfig
+	SAML          SAMLConfig
+	Directory     DirectoryConfig
+	SCIM          SCIMConfig
+	Provisioning  ProvisioningConfig
+	Tenancy       TenancyConfig
 }
 
 type DatabaseConfig struct {
@@ -27,6 +45,40 @@ type OpenFGAConfig struct {
 	APIURL   string
 	StoreID  string
 	APIToken string
+	// StoreName names the store to find or create when StoreID is empty
+	StoreName string
+	// Enabled is set when OpenFGA decides routes or a store is configured;
+	// memberships are then synced to it
+	Enabled bool
+}
+
+// Authorization engines
+const (
+	AuthzEngineOpenFGA = "openfga"
+	AuthzEngineNative  = "native"
+	AuthzEngineNone    = "none"
+)
+
+type AuthorizationConfig struct {
+	// Engine decides route permissions: OpenFGA, the native engine over the
+	// membership tables, or "none" to turn the checks off
+	Engine string
+	// CacheTTL is how long decisions are reused; 0 disables caching
+	CacheTTL time.Duration
+	// CheckTimeout bounds each decision; requests are rejected when the
+	// engine doesn't answer in time
+	CheckTimeout time.Duration
+	// SyncInterval is how often queued membership tuples are written to
+	// OpenFGA
+	SyncInterval time.Duration
+	// AdminRole is the role whose assignees administer the service
+	AdminRole string
+	// PermissionsClaim adds the effective permissions of users to their
+	// access tokens
+	PermissionsClaim bool
+	// AccessCacheTTL is how long resolved effective access is reused;
+	// membership changes clear it right away. 0 disables caching
+	AccessCacheTTL time.Duration
 }
 
 type ServerConfig struct {
@@ -39,6 +91,116 @@ type LoggingConfig struct {
 	FluentEndpoint string
 }
 
+type PasswordPolicyConfig struct {
+	MinLength        int
+	MaxLength        int
+	RequireUppercase bool
+	RequireLowercase bool
+	RequireDigit     bool
+	RequireSymbol    bool
+	MaxAgeDays       int
+	HistorySize      int
+	BreachedListPath string
+}
+
+type PasswordHashConfig struct {
+	Algorithm              string
+	BcryptCost             int
+	Argon2Memory           int
+	Argon2Iterations       int
+	Argon2Parallelism      int
+	LegacyPBKDF2Algorithm  string
+	LegacyPBKDF2Iterations int
+	LegacyPBKDF2SaltLength int
+	LegacyPBKDF2Secret     string
+}
+
+type LifecycleConfig struct {
+	SchedulerInterval time.Duration
+}
+
+type AssignmentConfig struct {
+	SchedulerInterval time.Duration
+	// ExpiryNotice is how long before a time-bound assignment expires its
+	// users are notified; zero disables the notifications
+	ExpiryNotice time.Duration
+}
+
+type CertificationConfig struct {
+	SchedulerInterval time.Duration
+}
+
+type SessionConfig struct {
+	TTL             time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+type TokenExchangeConfig struct {
+	// TTL caps the lifetime of exchanged tokens; they never outlive the
+	// subject token
+	TTL time.Duration
+}
+
+type FederationConfig struct {
+	// ProvidersFile is a JSON file listing the upstream OIDC providers users
+	// may sign in with; federation is disabled when it is empty
+	ProvidersFile string
+	// BaseURL is the public URL of this service, used to build the callback
+	// URL registered with each provider
+	BaseURL string
+}
+
+type KeyRingConfig struct {
+	// Dir holds "<id>.key" / "<id>.crt" PEM pairs; an ephemeral key is
+	// generated when it is empty
+	Dir string
+}
+
+type SAMLConfig struct {
+	// BaseURL is the public URL of this service; the IdP entity ID is
+	// BaseURL + "/saml/metadata"
+	BaseURL string
+}
+
+type DirectoryConfig struct {
+	// ConfigFile is a JSON file describing the LDAP directory; LDAP sign-in
+	// and sync are disabled when it is empty
+	ConfigFile string
+	// SyncInterval is how often the directory is synced; 0 disables
+	// scheduled syncs
+	SyncInterval time.Duration
+}
+
+type SCIMConfig struct {
+	// BaseURL is the public URL of this service, used for resource locations
+	// under BaseURL + "/scim/v2"
+	BaseURL string
+}
+
+type ProvisioningConfig struct {
+	// TargetsFile is a JSON file listing the SCIM targets users and groups
+	// are pushed to; outbound provisioning is disabled when it is empty
+	TargetsFile string
+	// Interval is how often queued deliveries are processed
+	Interval time.Duration
+	// ReconcileInterval is how often targets are compared with the local
+	// state; 0 disables scheduled reconciliation
+	ReconcileInterval time.Duration
+}
+
+type TenancyConfig struct {
+	// RowLevelSecurity adds Postgres policies that keep transactions bound
+	// to one tenant from touching rows of others
+	RowLevelSecurity bool
+}
+
+type ImpersonationConfig struct {
+	// AdminRole is the role that may impersonate users and that protects its
+	// holders from being impersonated
+	AdminRole string
+	TTL       time.Duration
+}
+
 func Load() (*Config, error) {
 	// Load .env file if it exists
 	godotenv.Load()
@@ -57,9 +219,42 @@ func Load() (*Config, error) {
 
 	// OpenFGA config
 	config.OpenFGA = OpenFGAConfig{
-		APIURL:   getEnv("OPENFGA_API_URL", "http://localhost:8080"),
-		StoreID:  getEnv("OPENFGA_STORE_ID", ""),
-		APIToken: getEnv("OPENFGA_API_TOKEN", ""),
+		APIURL:    getEnv("OPENFGA_API_URL", "http://localhost:8080"),
+		StoreID:   getEnv("OPENFGA_STORE_ID", ""),
+		APIToken:  getEnv("OPENFGA_API_TOKEN", ""),
+		StoreName: getEnv("OPENFGA_STORE_NAME", "idmapp"),
+	}
+
+	// Route authorization config
+	authzEngine := getEnv("AUTHZ_ENGINE", AuthzEngineNone)
+	if authzEngine != AuthzEngineOpenFGA && authzEngine != AuthzEngineNative && authzEngine != AuthzEngineNone {
+		return nil, fmt.Errorf("invalid AUTHZ_ENGINE: %q", authzEngine)
+	}
+	config.OpenFGA.Enabled = authzEngine == AuthzEngineOpenFGA || config.OpenFGA.StoreID != ""
+	authzCacheTTL, err := time.ParseDuration(getEnv("AUTHZ_CACHE_TTL", "10s"))
+	if err != nil || authzCacheTTL < 0 {
+		return nil, fmt.Errorf("invalid AUTHZ_CACHE_TTL: %q", getEnv("AUTHZ_CACHE_TTL", "10s"))
+	}
+	authzCheckTimeout, err := time.ParseDuration(getEnv("AUTHZ_CHECK_TIMEOUT", "2s"))
+	if err != nil || authzCheckTimeout <= 0 {
+		return nil, fmt.Errorf("invalid AUTHZ_CHECK_TIMEOUT: %q", getEnv("AUTHZ_CHECK_TIMEOUT", "2s"))
+	}
+	authzSyncInterval, err := time.ParseDuration(getEnv("AUTHZ_SYNC_INTERVAL", "5s"))
+	if err != nil || authzSyncInterval <= 0 {
+		return nil, fmt.Errorf("invalid AUTHZ_SYNC_INTERVAL: %q", getEnv("AUTHZ_SYNC_INTERVAL", "5s"))
+	}
+	accessCacheTTL, err := time.ParseDuration(getEnv("ACCESS_CACHE_TTL", "5m"))
+	if err != nil || accessCacheTTL < 0 {
+		return nil, fmt.Errorf("invalid ACCESS_CACHE_TTL: %q", getEnv("ACCESS_CACHE_TTL", "5m"))
+	}
+	config.Authorization = AuthorizationConfig{
+		Engine:           authzEngine,
+		CacheTTL:         authzCacheTTL,
+		CheckTimeout:     authzCheckTimeout,
+		SyncInterval:     authzSyncInterval,
+		AdminRole:        getEnv("AUTHZ_ADMIN_ROLE", "admin"),
+		PermissionsClaim: getEnv("AUTHZ_PERMISSIONS_CLAIM", "false") == "true",
+		AccessCacheTTL:   accessCacheTTL,
 	}
 
 	// Server config
@@ -75,6 +270,156 @@ func Load() (*Config, error) {
 		FluentEndpoint: getEnv("FLUENT_ENDPOINT", "http://localhost:24224"),
 	}
 
+	// Password policy config
+	minLength, _ := strconv.Atoi(getEnv("PASSWORD_MIN_LENGTH", "8"))
+	maxLength, _ := strconv.Atoi(getEnv("PASSWORD_MAX_LENGTH", "128"))
+	maxAgeDays, _ := strconv.Atoi(getEnv("PASSWORD_MAX_AGE_DAYS", "0"))
+	historySize, _ := strconv.Atoi(getEnv("PASSWORD_HISTORY_SIZE", "5"))
+	config.Password = PasswordPolicyConfig{
+		MinLength:        minLength,
+		MaxLength:        maxLength,
+		RequireUppercase: getEnv("PASSWORD_REQUIRE_UPPERCASE", "false") == "true",
+		RequireLowercase: getEnv("PASSWORD_REQUIRE_LOWERCASE", "false") == "true",
+		RequireDigit:     getEnv("PASSWORD_REQUIRE_DIGIT", "false") == "true",
+		RequireSymbol:    getEnv("PASSWORD_REQUIRE_SYMBOL", "false") == "true",
+		MaxAgeDays:       maxAgeDays,
+		HistorySize:      historySize,
+		BreachedListPath: getEnv("PASSWORD_BREACHED_LIST_PATH", ""),
+	}
+
+	// Password hashing config
+	bcryptCost, _ := strconv.Atoi(getEnv("PASSWORD_BCRYPT_COST", "10"))
+	argon2Memory, _ := strconv.Atoi(getEnv("PASSWORD_ARGON2_MEMORY_KB", "65536"))
+	argon2Iterations, _ := strconv.Atoi(getEnv("PASSWORD_ARGON2_ITERATIONS", "3"))
+	argon2Parallelism, _ := strconv.Atoi(getEnv("PASSWORD_ARGON2_PARALLELISM", "2"))
+	legacyIterations, _ := strconv.Atoi(getEnv("PASSWORD_LEGACY_PBKDF2_ITERATIONS", "185000"))
+	legacySaltLength, _ := strconv.Atoi(getEnv("PASSWORD_LEGACY_PBKDF2_SALT_LENGTH", "8"))
+	config.Hashing = PasswordHashConfig{
+		Algorithm:              getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+		BcryptCost:             bcryptCost,
+		Argon2Memory:           argon2Memory,
+		Argon2Iterations:       argon2Iterations,
+		Argon2Parallelism:      argon2Parallelism,
+		LegacyPBKDF2Algorithm:  getEnv("PASSWORD_LEGACY_PBKDF2_ALGORITHM", "sha1"),
+		LegacyPBKDF2Iterations: legacyIterations,
+		LegacyPBKDF2SaltLength: legacySaltLength,
+		LegacyPBKDF2Secret:     getEnv("PASSWORD_LEGACY_PBKDF2_SECRET", ""),
+	}
+
+	// Lifecycle config
+	schedulerInterval, err := time.ParseDuration(getEnv("LIFECYCLE_SCHEDULER_INTERVAL", "1m"))
+	if err != nil || schedulerInterval <= 0 {
+		return nil, fmt.Errorf("invalid LIFECYCLE_SCHEDULER_INTERVAL: %q", getEnv("LIFECYCLE_SCHEDULER_INTERVAL", "1m"))
+	}
+	config.Lifecycle = LifecycleConfig{
+		SchedulerInterval: schedulerInterval,
+	}
+
+	// Time-bound assignment config
+	assignmentInterval, err := time.ParseDuration(getEnv("ASSIGNMENT_SCHEDULER_INTERVAL", "1m"))
+	if err != nil || assignmentInterval <= 0 {
+		return nil, fmt.Errorf("invalid ASSIGNMENT_SCHEDULER_INTERVAL: %q", getEnv("ASSIGNMENT_SCHEDULER_INTERVAL", "1m"))
+	}
+	expiryNotice, err := time.ParseDuration(getEnv("ASSIGNMENT_EXPIRY_NOTICE", "72h"))
+	if err != nil || expiryNotice < 0 {
+		return nil, fmt.Errorf("invalid ASSIGNMENT_EXPIRY_NOTICE: %q", getEnv("ASSIGNMENT_EXPIRY_NOTICE", "72h"))
+	}
+	config.Assignment = AssignmentConfig{
+		SchedulerInterval: assignmentInterval,
+		ExpiryNotice:      expiryNotice,
+	}
+
+	// Certification campaign config
+	certificationInterval, err := time.ParseDuration(getEnv("CERTIFICATION_SCHEDULER_INTERVAL", "5m"))
+	if err != nil || certificationInterval <= 0 {
+		return nil, fmt.Errorf("invalid CERTIFICATION_SCHEDULER_INTERVAL: %q", getEnv("CERTIFICATION_SCHEDULER_INTERVAL", "5m"))
+	}
+	config.Certification = CertificationConfig{
+		SchedulerInterval: certificationInterval,
+	}
+
+	// Session config
+	sessionTTL, err := time.ParseDuration(getEnv("SESSION_TTL", "168h"))
+	if err != nil || sessionTTL <= 0 {
+		return nil, fmt.Errorf("invalid SESSION_TTL: %q", getEnv("SESSION_TTL", "168h"))
+	}
+	refreshTokenTTL, err := time.ParseDuration(getEnv("REFRESH_TOKEN_TTL", "168h"))
+	if err != nil || refreshTokenTTL <= 0 {
+		return nil, fmt.Errorf("invalid REFRESH_TOKEN_TTL: %q", getEnv("REFRESH_TOKEN_TTL", "168h"))
+	}
+	config.Session = SessionConfig{
+		TTL:             sessionTTL,
+		RefreshTokenTTL: refreshTokenTTL,
+	}
+
+	// Impersonation config
+	impersonationTTL, err := time.ParseDuration(getEnv("IMPERSONATION_TTL", "15m"))
+	if err != nil || impersonationTTL <= 0 {
+		return nil, fmt.Errorf("invalid IMPERSONATION_TTL: %q", getEnv("IMPERSONATION_TTL", "15m"))
+	}
+	config.Impersonation = ImpersonationConfig{
+		AdminRole: getEnv("IMPERSONATION_ADMIN_ROLE", "admin"),
+		TTL:       impersonationTTL,
+	}
+
+	// Token exchange config
+	tokenExchangeTTL, err := time.ParseDuration(getEnv("TOKEN_EXCHANGE_TTL", "15m"))
+	if err != nil || tokenExchangeTTL <= 0 {
+		return nil, fmt.Errorf("invalid TOKEN_EXCHANGE_TTL: %q", getEnv("TOKEN_EXCHANGE_TTL", "15m"))
+	}
+	config.TokenExchange = TokenExchangeConfig{
+		TTL: tokenExchangeTTL,
+	}
+
+	// Federation config
+	config.Federation = FederationConfig{
+		ProvidersFile: getEnv("FEDERATION_PROVIDERS_FILE", ""),
+		BaseURL:       strings.TrimSuffix(getEnv("FEDERATION_CALLBACK_BASE_URL", "http://localhost:8080"), "/"),
+	}
+
+	// Signing key and SAML IdP config
+	config.KeyRing = KeyRingConfig{
+		Dir: getEnv("KEYRING_DIR", ""),
+	}
+	config.SAML = SAMLConfig{
+		BaseURL: strings.TrimSuffix(getEnv("SAML_BASE_URL", "http://localhost:8080"), "/"),
+	}
+
+	// LDAP directory config
+	directorySyncInterval, err := time.ParseDuration(getEnv("LDAP_SYNC_INTERVAL", "1h"))
+	if err != nil || directorySyncInterval < 0 {
+		return nil, fmt.Errorf("invalid LDAP_SYNC_INTERVAL: %q", getEnv("LDAP_SYNC_INTERVAL", "1h"))
+	}
+	config.Directory = DirectoryConfig{
+		ConfigFile:   getEnv("LDAP_CONFIG_FILE", ""),
+		SyncInterval: directorySyncInterval,
+	}
+
+	// SCIM provisioning config
+	config.SCIM = SCIMConfig{
+		BaseURL: strings.TrimSuffix(getEnv("SCIM_BASE_URL", "http://localhost:8080"), "/"),
+	}
+
+	// Outbound provisioning config
+	provisioningInterval, err := time.ParseDuration(getEnv("PROVISIONING_INTERVAL", "10s"))
+	if err != nil || provisioningInterval <= 0 {
+		return nil, fmt.Errorf("invalid PROVISIONING_INTERVAL: %q", getEnv("PROVISIONING_INTERVAL", "10s"))
+	}
+	reconcileInterval, err := time.ParseDuration(getEnv("PROVISIONING_RECONCILE_INTERVAL", "24h"))
+	if err != nil || reconcileInterval < 0 {
+		return nil, fmt.Errorf("invalid PROVISIONING_RECONCILE_INTERVAL: %q", getEnv("PROVISIONING_RECONCILE_INTERVAL", "24h"))
+	}
+	config.Provisioning = ProvisioningConfig{
+		TargetsFile:       getEnv("PROVISIONING_TARGETS_FILE", ""),
+		Interval:          provisioningInterval,
+		ReconcileInterval: reconcileInterval,
+	}
+
+	// Multi-tenancy config
+	config.Tenancy = TenancyConfig{
+		RowLevelSecurity: getEnv("TENANT_ROW_LEVEL_SECURITY", "true") == "true",
+	}
+
 	return config, nil
 }
 
@@ -1,20 +1,94 @@
 package controllers
 
 import (
+	"errors"
 	"html/template"
-	"idmapp-go/database"
+	"idmapp-go/internal/federation"
+	"idmapp-go/internal/impersonation"
+	"idmapp-go/internal/samlidp"
+	"idmapp-go/internal/session"
+	"idmapp-go/internal/tenant"
 	"idmapp-go/internal/user"
 	"net/http"
 	"net/url"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
+type LoginController struct {
+	userService          *user.UserService
+	sessionService       *session.SessionService
+	impersonationService *impersonation.ImpersonationService
+	federationService    *federation.FederationService
+	samlService          *samlidp.SAMLService
+	tenantService        *tenant.TenantService
+	logger               *logrus.Logger
+}
+
+func NewLoginController(userService *user.UserService, sessionService *session.SessionService, impersonationService *impersonation.ImpersonationService, federationService *federation.FederationService, samlService *samlidp.SAMLService, tenantService *tenant.TenantService) *LoginController {
+	return &LoginController{
+		userService:          userService,
+		sessionService:       sessionService,
+		impersonationService: impersonationService,
+		federationService:    federationService,
+		samlService:          samlService,
+		tenantService:        tenantService,
+		logger:               logrus.New(),
+	}
+}
+
+// federationErrorMessages explains why a federated login was refused
+var federationErrorMessages = map[string]string{
+	federation.LoginErrorFailed:      "Sign-in with your identity provider failed. Please try again.",
+	federation.LoginErrorNoAccount:   "There is no account for this identity. Please contact your administrator.",
+	federation.LoginErrorNotVerified: "Your identity provider did not confirm your email address.",
+	federation.LoginErrorDisabled:    "Your account is disabled.",
+}
+
 func loadLoginTemplate() (*template.Template, error) {
 	return template.ParseFiles("templates/login.html")
 }
 
-func ShowLoginForm(c *gin.Context) {
+// impersonationBanner returns the banner shown on rendered pages while the
+// browser session is an impersonation, or nil
+func (lc *LoginController) impersonationBanner(c *gin.Context) gin.H {
+	loginSession, err := lc.sessionService.WithContext(c).SessionFromCookie(c)
+	if err != nil || loginSession.ActorID == nil {
+		return nil
+	}
+
+	banner := gin.H{"User": loginSession.UserID.String(), "Actor": loginSession.ActorID.String()}
+	if impersonatedUser, err := lc.userService.WithContext(c).GetUser(loginSession.UserID); err == nil && impersonatedUser != nil {
+		banner["User"] = impersonatedUser.Email
+	}
+	if actor, err := lc.userService.WithContext(c).GetUser(*loginSession.ActorID); err == nil && actor != nil {
+		banner["Actor"] = actor.Email
+	}
+	return banner
+}
+
+// pageData returns the data of the login page, branded for the request's
+// tenant. Federated sign-in is offered to the default tenant only.
+func (lc *LoginController) pageData(c *gin.Context, redirect string) gin.H {
+	data := gin.H{
+		"redirect":      redirect,
+		"Impersonation": lc.impersonationBanner(c),
+		"BasePath":      tenant.BasePath(c),
+	}
+	current, err := lc.tenantService.FromContext(c)
+	if err != nil {
+		lc.logger.Errorf("Failed to get tenant: %v", err)
+	} else if current != nil {
+		data["Tenant"] = gin.H{"Title": current.Title(), "LogoURL": current.LogoURL, "PrimaryColor": current.PrimaryColor}
+	}
+	if id, ok := tenant.FromContext(c); !ok || id == tenant.DefaultID {
+		data["Providers"] = lc.federationService.Providers()
+	}
+	return data
+}
+
+func (lc *LoginController) ShowLoginForm(c *gin.Context) {
 	redirect := c.Query("redirect")
 
 	// Load template with error handling
@@ -26,29 +100,44 @@ func ShowLoginForm(c *gin.Context) {
 
 	c.Header("Content-Type", "text/html")
 	c.Status(http.StatusOK)
-	if err := tmpl.Execute(c.Writer, gin.H{"redirect": redirect}); err != nil {
+	data := lc.pageData(c, redirect)
+	if message, ok := federationErrorMessages[c.Query("federation_error")]; ok {
+		data["Error"] = message
+	}
+	if err := tmpl.Execute(c.Writer, data); err != nil {
 		c.String(http.StatusInternalServerError, "Error executing template: %v", err)
 	}
 }
 
-func HandleLogin(c *gin.Context) {
+func (lc *LoginController) HandleLogin(c *gin.Context) {
 	email := c.PostForm("email")
 	password := c.PostForm("password")
 	redirect := c.PostForm("redirect")
-	userService := user.NewUserService(database.GetDB())
-	user, err := userService.AuthenticateUser(email, password)
+	authenticatedUser, err := lc.userService.WithContext(c).AuthenticateUser(email, password)
 	if err != nil {
+		message := "Invalid credentials"
+		if errors.Is(err, user.ErrPasswordExpired) {
+			message = "Your password has expired. Please change it before signing in."
+		}
 		c.Status(http.StatusUnauthorized)
 		tmpl, tmplErr := loadLoginTemplate()
 		if tmplErr != nil {
 			c.String(http.StatusInternalServerError, "Error loading template: %v", tmplErr)
 			return
 		}
-		tmpl.Execute(c.Writer, gin.H{"Error": "Invalid credentials", "redirect": redirect})
+		data := lc.pageData(c, redirect)
+		data["Error"] = message
+		tmpl.Execute(c.Writer, data)
 		return
 	}
-	// Set session (for demo, use cookie)
-	c.SetCookie("session_user", user.Email, 3600, "/", "", false, true)
+	// Start a server-side session and hand its ID to the browser
+	loginSession, err := lc.sessionService.WithContext(c).CreateSession(authenticatedUser.ID, session.BrowserClientID, session.ClientInfoFromRequest(c))
+	if err != nil {
+		lc.logger.Errorf("Failed to create session: %v", err)
+		c.String(http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+	session.SetCookie(c, loginSession)
 	if redirect != "" {
 		// URL-decode the redirect parameter to restore the original PKCE authorize URL
 		decodedRedirect, err := url.QueryUnescape(redirect)
@@ -58,13 +147,32 @@ func HandleLogin(c *gin.Context) {
 		}
 		c.Redirect(http.StatusFound, decodedRedirect)
 	} else {
-		c.Redirect(http.StatusFound, "/")
+		c.Redirect(http.StatusFound, tenant.BasePath(c)+"/")
 	}
 }
 
-func Logout(c *gin.Context) {
-	// Clear the session cookie
-	c.SetCookie("session_user", "", -1, "/", "", false, true)
+func (lc *LoginController) Logout(c *gin.Context) {
+	// End the server-side session and clear the cookie
+	if loginSession, err := lc.sessionService.WithContext(c).SessionFromCookie(c); err == nil {
+		// Sessions that reached SAML service providers end with single logout
+		if loginSession.ActorID == nil {
+			if hasParticipants, err := lc.samlService.HasParticipants(loginSession.ID); err != nil {
+				lc.logger.Errorf("Failed to check SAML session participants: %v", err)
+			} else if hasParticipants {
+				c.Redirect(http.StatusFound, "/saml/logout?redirect="+url.QueryEscape(c.Query("redirect")))
+				return
+			}
+		}
+		if loginSession.ActorID != nil {
+			err = lc.impersonationService.WithContext(c).EndImpersonation(loginSession.ID, loginSession.ActorID.String())
+		} else {
+			_, err = lc.sessionService.WithContext(c).RevokeSession(loginSession.UserID, loginSession.ID, "logout", loginSession.UserID.String())
+		}
+		if err != nil {
+			lc.logger.Errorf("Failed to revoke session on logout: %v", err)
+		}
+	}
+	session.ClearCookie(c)
 	redirect := c.Query("redirect")
 	if redirect != "" {
 		c.Redirect(http.StatusFound, redirect)
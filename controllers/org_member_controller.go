@@ -1,9 +1,13 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 
 	"idmapp-go/dto"
+	"idmapp-go/internal/sod"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/models"
 	"idmapp-go/services"
 
 	"github.com/gin-gonic/gin"
@@ -31,7 +35,14 @@ func (c *OrgMemberController) HandleMemberOperation(ctx *gin.Context) {
 	}
 
 	if req.Op == 1 { // ADD
-		orgMember, err := c.service.AddMember(req.OrgID, req.EntityID, req.Type)
+		orgMember, err := c.service.WithContext(ctx).AddMember(req.OrgID, req.EntityID, req.Type, req.Cascade)
+		if errors.Is(err, tenant.ErrNotOwned) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if sod.RespondViolation(ctx, err) {
+			return
+		}
 		if err != nil {
 			c.logger.Errorf("Failed to add org member: %v", err)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -40,7 +51,7 @@ func (c *OrgMemberController) HandleMemberOperation(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, orgMember)
 		return
 	} else if req.Op == 2 { // REMOVE
-		removed, err := c.service.RemoveMember(req.OrgID, req.EntityID)
+		removed, err := c.service.WithContext(ctx).RemoveMember(req.OrgID, req.EntityID)
 		if err != nil {
 			c.logger.Errorf("Failed to remove org member: %v", err)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -57,7 +68,7 @@ func (c *OrgMemberController) HandleMemberOperation(ctx *gin.Context) {
 }
 
 func (c *OrgMemberController) GetAllMembers(ctx *gin.Context) {
-	members, err := c.service.GetAllMembers()
+	members, err := c.service.WithContext(ctx).GetAllMembers()
 	if err != nil {
 		c.logger.Errorf("Failed to get org members: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get org members"})
@@ -75,7 +86,16 @@ func (c *OrgMemberController) GetMembersByOrgID(ctx *gin.Context) {
 		return
 	}
 
-	members, err := c.service.GetMembersByOrgID(orgID)
+	var members []models.OrgMember
+	switch ctx.Query("expand") {
+	case "":
+		members, err = c.service.WithContext(ctx).GetMembersByOrgID(orgID)
+	case services.ExpandInherited:
+		members, err = c.service.WithContext(ctx).GetInheritedMembersByOrgID(orgID)
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expand, expected " + services.ExpandInherited})
+		return
+	}
 	if err != nil {
 		c.logger.Errorf("Failed to get org members by org ID: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get org members"})
@@ -93,7 +113,7 @@ func (c *OrgMemberController) GetMembersByEntityID(ctx *gin.Context) {
 		return
 	}
 
-	members, err := c.service.GetMembersByEntityID(entityID)
+	members, err := c.service.WithContext(ctx).GetMembersByEntityID(entityID)
 	if err != nil {
 		c.logger.Errorf("Failed to get org members by entity ID: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get org members"})
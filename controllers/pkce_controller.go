@@ -1,12 +1,16 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
 
 	"idmapp-go/dto"
+	"idmapp-go/internal/session"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/internal/tokenexchange"
 	"idmapp-go/internal/user"
 	"idmapp-go/services"
 
@@ -15,42 +19,59 @@ import (
 )
 
 type PKCEController struct {
-	pkceService *services.PKCEService
-	userService *user.UserService
-	logger      *logrus.Logger
+	pkceService          *services.PKCEService
+	userService          *user.UserService
+	sessionService       *session.SessionService
+	tokenExchangeService *tokenexchange.TokenExchangeService
+	logger               *logrus.Logger
 }
 
-func NewPKCEController(pkceService *services.PKCEService, userService *user.UserService) *PKCEController {
+func NewPKCEController(pkceService *services.PKCEService, userService *user.UserService, sessionService *session.SessionService, tokenExchangeService *tokenexchange.TokenExchangeService) *PKCEController {
 	return &PKCEController{
-		pkceService: pkceService,
-		userService: userService,
-		logger:      logrus.New(),
+		pkceService:          pkceService,
+		userService:          userService,
+		sessionService:       sessionService,
+		tokenExchangeService: tokenExchangeService,
+		logger:               logrus.New(),
 	}
 }
 
+// sessionUser resolves the signed-in user from the login session cookie
+func (c *PKCEController) sessionUser(ctx *gin.Context) (*user.User, *session.Session, error) {
+	loginSession, err := c.sessionService.WithContext(ctx).SessionFromCookie(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := c.userService.WithContext(ctx).ValidateTokenSubject(loginSession.UserID.String(), loginSession.CreatedAt); err != nil {
+		return nil, nil, err
+	}
+	// Users of other tenants aren't signed in here
+	sessionUser, err := c.userService.WithContext(ctx).GetUser(loginSession.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sessionUser == nil {
+		return nil, nil, fmt.Errorf("user not found")
+	}
+	return sessionUser, loginSession, nil
+}
+
 // Shared handler for PKCE authorization logic
 func (c *PKCEController) handlePKCEAuth(ctx *gin.Context, req dto.PKCEAuthRequest) {
-	if err := c.pkceService.ValidatePKCEFlow(req); err != nil {
+	if err := c.pkceService.WithContext(ctx).ValidatePKCEFlow(req); err != nil {
 		c.logger.Errorf("PKCE validation failed: %v", err)
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var user *user.User
-	userEmail, err := ctx.Cookie("session_user")
-	if err != nil || userEmail == "" {
-		c.logger.Errorf("No authenticated user in session for PKCE authorize")
+	user, loginSession, err := c.sessionUser(ctx)
+	if err != nil {
+		c.logger.Errorf("No authenticated user in session for PKCE authorize: %v", err)
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
-	user, err = c.userService.GetUserByEmail(userEmail)
-	if err != nil || user == nil {
-		c.logger.Errorf("Failed to get user for PKCE authorize: %v", err)
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-		return
-	}
 	// Use the real user ID for PKCE code generation
-	code, state, codeVerifier, err := c.pkceService.CreateAuthorizationCode(req, &user.ID)
+	code, state, codeVerifier, err := c.pkceService.WithContext(ctx).CreateAuthorizationCode(req, &user.ID, &loginSession.ID, loginSession.ActorID)
 	if err != nil {
 		c.logger.Errorf("Failed to create authorization code: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create authorization code"})
@@ -79,12 +100,10 @@ func (c *PKCEController) InitiatePKCEAuth(ctx *gin.Context) {
 
 // GET handler for OIDC compliance
 func (c *PKCEController) InitiatePKCEAuthGET(ctx *gin.Context) {
-	// Check for session cookie
-	userEmail, err := ctx.Cookie("session_user")
-	c.logger.Debug("Session user in Initiate PKCE AuthGET " + userEmail)
-	c.logger.Debug("All cookies: " + fmt.Sprintf("%v", ctx.Request.Cookies()))
-
-	if err != nil || userEmail == "" {
+	// Check for a login session
+	user, loginSession, err := c.sessionUser(ctx)
+	if err != nil {
+		c.logger.Debugf("No login session in Initiate PKCE AuthGET: %v", err)
 		// Check if this is a browser request or API request
 		acceptHeader := ctx.GetHeader("Accept")
 		userAgent := ctx.GetHeader("User-Agent")
@@ -108,7 +127,7 @@ func (c *PKCEController) InitiatePKCEAuthGET(ctx *gin.Context) {
 
 		if isBrowserRequest {
 			// Browser request - redirect to login
-			redirectURL := "/login?redirect=" + url.QueryEscape(ctx.Request.RequestURI)
+			redirectURL := tenant.BasePath(ctx) + "/login?redirect=" + url.QueryEscape(ctx.Request.RequestURI)
 			c.logger.Debugf("Redirecting to login: %s", redirectURL)
 			ctx.Redirect(http.StatusFound, redirectURL)
 			return
@@ -117,7 +136,7 @@ func (c *PKCEController) InitiatePKCEAuthGET(ctx *gin.Context) {
 			ctx.JSON(http.StatusUnauthorized, gin.H{
 				"error":             "authentication_required",
 				"error_description": "User authentication required",
-				"login_url":         "/login?redirect=" + url.QueryEscape(ctx.Request.RequestURI),
+				"login_url":         tenant.BasePath(ctx) + "/login?redirect=" + url.QueryEscape(ctx.Request.RequestURI),
 			})
 			return
 		}
@@ -133,27 +152,14 @@ func (c *PKCEController) InitiatePKCEAuthGET(ctx *gin.Context) {
 	}
 
 	// Validate PKCE flow
-	if err := c.pkceService.ValidatePKCEFlow(req); err != nil {
+	if err := c.pkceService.WithContext(ctx).ValidatePKCEFlow(req); err != nil {
 		c.logger.Errorf("PKCE validation failed: %v", err)
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var user *user.User
-	userEmail, err = ctx.Cookie("session_user")
-	if err != nil || userEmail == "" {
-		c.logger.Errorf("No authenticated user in session for PKCE authorize")
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
-		return
-	}
-	user, err = c.userService.GetUserByEmail(userEmail)
-	if err != nil || user == nil {
-		c.logger.Errorf("Failed to get user for PKCE authorize: %v", err)
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
-		return
-	}
 	// Use the real user ID for PKCE code generation
-	code, state, codeVerifier, err := c.pkceService.CreateAuthorizationCode(req, &user.ID)
+	code, state, codeVerifier, err := c.pkceService.WithContext(ctx).CreateAuthorizationCode(req, &user.ID, &loginSession.ID, loginSession.ActorID)
 	if err != nil {
 		c.logger.Errorf("Failed to create authorization code: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create authorization code"})
@@ -211,8 +217,16 @@ func (c *PKCEController) ExchangeCodeForToken(ctx *gin.Context) {
 	c.logger.Debugf("Parsed token request: %+v", req)
 
 	// Validate required fields
-	if req.GrantType != "authorization_code" {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "grant_type must be 'authorization_code'"})
+	switch req.GrantType {
+	case "authorization_code":
+	case "refresh_token":
+		c.refreshToken(ctx, req.RefreshToken, req.ClientID)
+		return
+	case tokenexchange.GrantType, tokenexchange.ClientCredentialsGrantType:
+		c.exchangeToken(ctx, req)
+		return
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "grant_type must be 'authorization_code', 'refresh_token', 'client_credentials' or '" + tokenexchange.GrantType + "'"})
 		return
 	}
 
@@ -222,31 +236,129 @@ func (c *PKCEController) ExchangeCodeForToken(ctx *gin.Context) {
 	}
 
 	// Exchange code for token
-	tokenResponse, err := c.pkceService.ExchangeCodeForToken(req)
+	tokenResponse, sessionID, err := c.pkceService.WithContext(ctx).ExchangeCodeForToken(req)
 	if err != nil {
 		c.logger.Errorf("Token exchange failed: %v", err)
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// Issue a refresh token bound to the login session the code came from
+	if sessionID != nil {
+		loginSession, err := c.sessionService.WithContext(ctx).GetActiveSession(*sessionID)
+		if err != nil {
+			c.logger.Errorf("Login session %s is no longer active: %v", sessionID, err)
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "login session is no longer active"})
+			return
+		}
+		refreshToken, err := c.sessionService.WithContext(ctx).IssueRefreshToken(loginSession, req.ClientID, tokenResponse.Scope, session.ClientInfoFromRequest(ctx))
+		if err != nil {
+			c.logger.Errorf("Failed to issue refresh token: %v", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue refresh token"})
+			return
+		}
+		tokenResponse.RefreshToken = refreshToken
+	}
+
+	ctx.JSON(http.StatusOK, tokenResponse)
+}
+
+// exchangeToken handles the RFC 8693 token exchange grant
+func (c *PKCEController) exchangeToken(ctx *gin.Context, req dto.PKCETokenRequest) {
+	// Confidential clients may authenticate with HTTP Basic instead of form parameters
+	if clientID, clientSecret, ok := ctx.Request.BasicAuth(); ok {
+		req.ClientID, req.ClientSecret = clientID, clientSecret
+	}
+
+	var tokenResponse *dto.PKCETokenResponse
+	var err error
+	if req.GrantType == tokenexchange.ClientCredentialsGrantType {
+		tokenResponse, err = c.tokenExchangeService.WithContext(ctx).ClientCredentials(req)
+	} else {
+		tokenResponse, err = c.tokenExchangeService.WithContext(ctx).Exchange(req)
+	}
+	if err != nil {
+		var oauthErr *tokenexchange.Error
+		if errors.As(err, &oauthErr) {
+			c.logger.Warnf("Token exchange for client %s refused: %v", req.ClientID, err)
+			ctx.JSON(oauthErr.Status, dto.PKCEErrorResponse{
+				Error:            oauthErr.Code,
+				ErrorDescription: oauthErr.Description,
+			})
+			return
+		}
+		c.logger.Errorf("Token exchange failed: %v", err)
+		ctx.JSON(http.StatusInternalServerError, dto.PKCEErrorResponse{Error: "server_error"})
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
 	ctx.JSON(http.StatusOK, tokenResponse)
 }
 
 // RefreshToken refreshes an access token
 func (c *PKCEController) RefreshToken(ctx *gin.Context) {
 	var req struct {
-		RefreshToken string `json:"refresh_token" binding:"required"`
-		ClientID     string `json:"client_id" binding:"required"`
+		RefreshToken string `json:"refresh_token" form:"refresh_token" binding:"required"`
+		ClientID     string `json:"client_id" form:"client_id" binding:"required"`
 	}
 
-	if err := ctx.ShouldBindJSON(&req); err != nil {
+	if err := ctx.ShouldBind(&req); err != nil {
 		c.logger.Errorf("Invalid refresh token request: %v", err)
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// For now, return an error since we haven't implemented refresh tokens yet
-	ctx.JSON(http.StatusNotImplemented, gin.H{"error": "Refresh tokens not implemented yet"})
+	c.refreshToken(ctx, req.RefreshToken, req.ClientID)
+}
+
+// refreshToken rotates the refresh token and issues a new access token for its session
+func (c *PKCEController) refreshToken(ctx *gin.Context, refreshToken, clientID string) {
+	if refreshToken == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token is required"})
+		return
+	}
+
+	newRefreshToken, grant, loginSession, err := c.sessionService.WithContext(ctx).RotateRefreshToken(refreshToken, clientID, session.ClientInfoFromRequest(ctx))
+	if err != nil {
+		if errors.Is(err, session.ErrInvalidRefreshToken) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+			return
+		}
+		c.logger.Errorf("Failed to rotate refresh token: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	if err := c.userService.WithContext(ctx).ValidateTokenSubject(grant.UserID.String(), loginSession.CreatedAt); err != nil {
+		c.logger.Warnf("Refresh rejected for user %s: %v", grant.UserID, err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+		return
+	}
+	refreshUser, err := c.userService.WithContext(ctx).GetUser(grant.UserID)
+	if err != nil || refreshUser == nil {
+		c.logger.Errorf("Failed to get user for refresh: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid_grant"})
+		return
+	}
+
+	accessToken, err := c.pkceService.WithContext(ctx).GenerateAccessToken(refreshUser.ID.String(), refreshUser.Email, services.AccessTokenOptions{
+		SessionID: loginSession.ID.String(),
+		Actor:     loginSession.Actor(),
+	})
+	if err != nil {
+		c.logger.Errorf("Failed to generate access token: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.PKCETokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    3600,
+		RefreshToken: newRefreshToken,
+		Scope:        grant.Scope,
+	})
 }
 
 // GetPKCEConfig returns PKCE configuration for clients
@@ -299,7 +411,8 @@ func (c *PKCEController) GetOIDCConfig(ctx *gin.Context) {
 		"subject_types_supported":               []string{"public"},
 		"id_token_signing_alg_values_supported": []string{"HS256"},
 		"scopes_supported":                      []string{"openid", "profile", "email"},
-		"token_endpoint_auth_methods_supported": []string{"none"},
+		"token_endpoint_auth_methods_supported": []string{"none", "client_secret_basic", "client_secret_post"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", tokenexchange.ClientCredentialsGrantType, tokenexchange.GrantType},
 		"claims_supported":                      []string{"sub", "email"},
 	})
 }
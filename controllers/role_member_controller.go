@@ -1,9 +1,13 @@
 package controllers
 
 import (
+	"errors"
 	"net/http"
 
 	"idmapp-go/dto"
+	"idmapp-go/internal/sod"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/models"
 	"idmapp-go/services"
 
 	"github.com/gin-gonic/gin"
@@ -31,7 +35,18 @@ func (c *RoleMemberController) HandleMemberOperation(ctx *gin.Context) {
 	}
 
 	if req.Op == 1 { // ADD
-		roleMember, err := c.service.AddMember(req.RoleID, req.EntityID, req.Type)
+		roleMember, err := c.service.WithContext(ctx).AddMember(req.RoleID, req.EntityID, req.Type, req.ValidFrom, req.ValidUntil)
+		if errors.Is(err, tenant.ErrNotOwned) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, models.ErrInvalidValidity) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if sod.RespondViolation(ctx, err) {
+			return
+		}
 		if err != nil {
 			c.logger.Errorf("Failed to add role member: %v", err)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -40,7 +55,7 @@ func (c *RoleMemberController) HandleMemberOperation(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, roleMember)
 		return
 	} else if req.Op == 2 { // REMOVE
-		removed, err := c.service.RemoveMember(req.RoleID, req.EntityID)
+		removed, err := c.service.WithContext(ctx).RemoveMember(req.RoleID, req.EntityID)
 		if err != nil {
 			c.logger.Errorf("Failed to remove role member: %v", err)
 			ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -57,7 +72,7 @@ func (c *RoleMemberController) HandleMemberOperation(ctx *gin.Context) {
 }
 
 func (c *RoleMemberController) GetAllMembers(ctx *gin.Context) {
-	members, err := c.service.GetAllMembers()
+	members, err := c.service.WithContext(ctx).GetAllMembers()
 	if err != nil {
 		c.logger.Errorf("Failed to get role members: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role members"})
@@ -75,7 +90,7 @@ func (c *RoleMemberController) GetMembersByRoleID(ctx *gin.Context) {
 		return
 	}
 
-	members, err := c.service.GetMembersByRoleID(roleID)
+	members, err := c.service.WithContext(ctx).GetMembersByRoleID(roleID)
 	if err != nil {
 		c.logger.Errorf("Failed to get role members by role ID: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role members"})
@@ -93,7 +108,7 @@ func (c *RoleMemberController) GetMembersByEntityID(ctx *gin.Context) {
 		return
 	}
 
-	members, err := c.service.GetMembersByEntityID(entityID)
+	members, err := c.service.WithContext(ctx).GetMembersByEntityID(entityID)
 	if err != nil {
 		c.logger.Errorf("Failed to get role members by entity ID: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role members"})
@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"idmapp-go/internal/sod"
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type SoDPolicyController struct {
+	service *sod.PolicyService
+	logger  *logrus.Logger
+}
+
+func NewSoDPolicyController(service *sod.PolicyService) *SoDPolicyController {
+	return &SoDPolicyController{
+		service: service,
+		logger:  logrus.New(),
+	}
+}
+
+func (c *SoDPolicyController) GetPolicies(ctx *gin.Context) {
+	policies, err := c.service.WithContext(ctx).GetPolicies()
+	if err != nil {
+		c.handleError(ctx, "Failed to get policies", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, policies)
+}
+
+func (c *SoDPolicyController) GetPolicy(ctx *gin.Context) {
+	id, ok := parsePathID(ctx, "id", "policy")
+	if !ok {
+		return
+	}
+	policy, err := c.service.WithContext(ctx).GetPolicy(id)
+	if err != nil {
+		c.handleError(ctx, "Failed to get policy", err)
+		return
+	}
+	if policy == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": sod.ErrPolicyNotFound.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, policy)
+}
+
+func (c *SoDPolicyController) CreatePolicy(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	var req sod.PolicyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := c.service.WithContext(ctx).CreatePolicy(userID, req)
+	if err != nil {
+		c.handleError(ctx, "Failed to create policy", err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, policy)
+}
+
+func (c *SoDPolicyController) UpdatePolicy(ctx *gin.Context) {
+	id, ok := parsePathID(ctx, "id", "policy")
+	if !ok {
+		return
+	}
+	var req sod.PolicyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	policy, err := c.service.WithContext(ctx).UpdatePolicy(id, req)
+	if err != nil {
+		c.handleError(ctx, "Failed to update policy", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, policy)
+}
+
+func (c *SoDPolicyController) DeletePolicy(ctx *gin.Context) {
+	id, ok := parsePathID(ctx, "id", "policy")
+	if !ok {
+		return
+	}
+	if err := c.service.WithContext(ctx).DeletePolicy(id); err != nil {
+		c.handleError(ctx, "Failed to delete policy", err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// GetExceptions lists the exceptions of a policy, expired ones included
+func (c *SoDPolicyController) GetExceptions(ctx *gin.Context) {
+	id, ok := parsePathID(ctx, "id", "policy")
+	if !ok {
+		return
+	}
+	exceptions, err := c.service.WithContext(ctx).GetExceptions(id)
+	if err != nil {
+		c.handleError(ctx, "Failed to get exceptions", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, exceptions)
+}
+
+// GrantException lets a user break a policy until the exception expires;
+// the signed-in user approves it
+func (c *SoDPolicyController) GrantException(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	id, ok := parsePathID(ctx, "id", "policy")
+	if !ok {
+		return
+	}
+	var req sod.ExceptionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	exception, err := c.service.WithContext(ctx).GrantException(id, userID, req)
+	if err != nil {
+		c.handleError(ctx, "Failed to grant exception", err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, exception)
+}
+
+func (c *SoDPolicyController) RevokeException(ctx *gin.Context) {
+	id, ok := parsePathID(ctx, "id", "policy")
+	if !ok {
+		return
+	}
+	exceptionID, ok := parsePathID(ctx, "exceptionId", "exception")
+	if !ok {
+		return
+	}
+	if err := c.service.WithContext(ctx).RevokeException(id, exceptionID); err != nil {
+		c.handleError(ctx, "Failed to revoke exception", err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// GetViolations scans for the users breaking policies now; pass policyId
+// to scan a single policy
+func (c *SoDPolicyController) GetViolations(ctx *gin.Context) {
+	var policyID *uuid.UUID
+	if value := ctx.Query("policyId"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid policy ID"})
+			return
+		}
+		policyID = &id
+	}
+	violations, err := c.service.WithContext(ctx).Scan(policyID)
+	if err != nil {
+		c.handleError(ctx, "Failed to scan for violations", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, violations)
+}
+
+func (c *SoDPolicyController) handleError(ctx *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, sod.ErrPolicyNotFound), errors.Is(err, sod.ErrExceptionNotFound), errors.Is(err, sod.ErrRoleNotFound),
+		errors.Is(err, sod.ErrUserNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, sod.ErrInvalidPolicy), errors.Is(err, sod.ErrInvalidExpiry):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.logger.Errorf("%s: %v", message, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+func parsePathID(ctx *gin.Context, param, name string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(ctx.Param(param))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " ID"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
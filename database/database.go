@@ -1,17 +1,34 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"idmapp-go/config"
+	"idmapp-go/internal/accessrequest"
+	"idmapp-go/internal/accesstoken"
 	"idmapp-go/internal/client"
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/certification"
+	"idmapp-go/internal/directory"
+	"idmapp-go/internal/federation"
 	"idmapp-go/internal/group"
 	"idmapp-go/internal/member"
+	"idmapp-go/internal/notification"
 	"idmapp-go/internal/org"
+	"idmapp-go/internal/password"
+	"idmapp-go/internal/permission"
 	"idmapp-go/internal/pkce"
+	"idmapp-go/internal/provisioning"
 	"idmapp-go/internal/role"
+	"idmapp-go/internal/samlidp"
+	"idmapp-go/internal/session"
+	"idmapp-go/internal/sod"
+	"idmapp-go/internal/tenant"
 	"idmapp-go/internal/user"
+	"idmapp-go/models"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -32,21 +49,110 @@ func InitDB(cfg *config.Config) error {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	// Statements are scoped to the tenant of their context
+	if err := DB.Use(&tenant.Plugin{RowLevelSecurity: cfg.Tenancy.RowLevelSecurity}); err != nil {
+		return fmt.Errorf("failed to register tenant scoping: %w", err)
+	}
+
+	// Emails were unique across the service before tenants; they are unique
+	// per tenant now
+	for _, constraint := range []string{"users_email_key", "uni_users_email"} {
+		if err := DB.Exec("ALTER TABLE IF EXISTS users DROP CONSTRAINT IF EXISTS " + constraint).Error; err != nil {
+			return fmt.Errorf("failed to drop constraint %s: %w", constraint, err)
+		}
+	}
+
 	// Auto migrate the schema
 	err = DB.AutoMigrate(
+		&tenant.Tenant{},
 		&user.User{},
 		&group.Group{},
 		&role.Role{},
 		&org.Org{},
 		&member.Member{},
+		&member.NestedGroup{},
 		&pkce.PKCECode{},
 		&client.Client{},
+		&password.History{},
+		&user.LifecycleSchedule{},
+		&session.Session{},
+		&session.RefreshToken{},
+		&accesstoken.PersonalAccessToken{},
+		&models.RoleMember{},
+		&models.OrgMember{},
+		&federation.AccountLink{},
+		&federation.LoginState{},
+		&samlidp.ServiceProvider{},
+		&samlidp.PendingRequest{},
+		&samlidp.Participant{},
+		&directory.Object{},
+		&directory.SyncRun{},
+		&provisioning.Link{},
+		&provisioning.Job{},
+		&provisioning.ReconcileRun{},
+		&authz.TupleChange{},
+		&authz.AuthorizationModel{},
+		&authz.StoredTuple{},
+		&permission.Permission{},
+		&permission.RolePermission{},
+		&notification.Notification{},
+		&accessrequest.AccessRequest{},
+		&accessrequest.RequestStage{},
+		&accessrequest.ApprovalStage{},
+		&accessrequest.AuditEntry{},
+		&certification.Campaign{},
+		&certification.CampaignScope{},
+		&certification.Reviewer{},
+		&certification.ReviewItem{},
+		&sod.Policy{},
+		&sod.PolicyRole{},
+		&sod.Exception{},
 	)
 
 	if err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	// Data migrations change the rows of every tenant, which row level
+	// security from earlier runs would hide
+	migrations := DB.WithContext(tenant.Bypass(context.Background()))
+
+	// Users deactivated before lifecycle states existed are treated as suspended
+	if err := migrations.Model(&user.User{}).
+		Where("is_active = ? AND status = ?", false, user.StatusActive).
+		Update("status", user.StatusSuspended).Error; err != nil {
+		return fmt.Errorf("failed to migrate user status: %w", err)
+	}
+
+	// Assignments made before validity windows existed took effect when made
+	for _, table := range []string{"members", "role_members"} {
+		if err := migrations.Exec("UPDATE " + table + " SET activated_at = created_at WHERE activated_at IS NULL AND valid_from IS NULL").Error; err != nil {
+			return fmt.Errorf("failed to migrate %s activation: %w", table, err)
+		}
+	}
+
+	// Passwords set before changes were tracked age from the upgrade rather
+	// than from when their users were created
+	if err := migrations.Model(&user.User{}).
+		Where("password_changed_at IS NULL").
+		UpdateColumn("password_changed_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to migrate password change times: %w", err)
+	}
+
+	if err := DB.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_tenant_email ON users (tenant_id, email)").Error; err != nil {
+		return fmt.Errorf("failed to create user email index: %w", err)
+	}
+
+	// Row level security backs the scoping of statements by the plugin
+	if cfg.Tenancy.RowLevelSecurity {
+		err = tenant.EnableRowLevelSecurity(DB, tenant.ScopedTables...)
+	} else {
+		err = tenant.DisableRowLevelSecurity(DB, tenant.ScopedTables...)
+	}
+	if err != nil {
+		return err
+	}
+
 	log.Println("Database connected and migrated successfully")
 	return nil
 }
@@ -0,0 +1,11 @@
+package dto
+
+// APIAudience names IDMApp's own API in the aud claim of access tokens
+const APIAudience = "idmapp"
+
+// Actor is the RFC 8693 "act" claim. It names the party acting on behalf of
+// the token subject; a nested Act names whoever that party acted for in turn.
+type Actor struct {
+	Sub string `json:"sub"`
+	Act *Actor `json:"act,omitempty"`
+}
@@ -1,6 +1,8 @@
 package dto
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 )
 
@@ -30,6 +32,10 @@ type MemberOpRequest struct {
 	Op      OpType    `json:"op" binding:"required"`
 	GroupID uuid.UUID `json:"groupId" binding:"required"`
 	UserID  uuid.UUID `json:"userId" binding:"required"`
+	// ADD only: the membership takes effect at ValidFrom and ends at
+	// ValidUntil
+	ValidFrom  *time.Time `json:"validFrom"`
+	ValidUntil *time.Time `json:"validUntil"`
 }
 
 type MemberOpResponse struct {
@@ -39,3 +45,10 @@ type MemberOpResponse struct {
 	CreatedAt string `json:"createdAt"`
 	UpdatedAt string `json:"updatedAt"`
 }
+
+// NestedGroupOpRequest adds MemberGroupID to or removes it from GroupID
+type NestedGroupOpRequest struct {
+	Op            OpType    `json:"op" binding:"required"`
+	GroupID       uuid.UUID `json:"groupId" binding:"required"`
+	MemberGroupID uuid.UUID `json:"memberGroupId" binding:"required"`
+}
@@ -10,6 +10,7 @@ type OrgMemberOpRequest struct {
 	Type     string    `json:"type" binding:"required"` // USER, GROUP, or ROLE
 	OrgID    uuid.UUID `json:"orgId" binding:"required"`
 	EntityID uuid.UUID `json:"entityId" binding:"required"`
+	Cascade  bool      `json:"cascade"` // ADD only: also a member of every org below
 }
 
 type OrgMemberResponse struct {
@@ -25,16 +25,26 @@ type PKCETokenRequest struct {
 	RedirectURI  string `form:"redirect_uri" json:"redirect_uri"`
 	CodeVerifier string `form:"code_verifier" json:"code_verifier"`
 	State        string `form:"state" json:"state"`
+	RefreshToken string `form:"refresh_token" json:"refresh_token"`
+	ClientSecret string `form:"client_secret" json:"client_secret"`
+	// Token exchange (RFC 8693) parameters
+	SubjectToken     string   `form:"subject_token" json:"subject_token"`
+	SubjectTokenType string   `form:"subject_token_type" json:"subject_token_type"`
+	ActorToken       string   `form:"actor_token" json:"actor_token"`
+	ActorTokenType   string   `form:"actor_token_type" json:"actor_token_type"`
+	Audience         []string `form:"audience" json:"audience"`
+	Scope            string   `form:"scope" json:"scope"`
 }
 
 // PKCE Token Response
 type PKCETokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	TokenType    string `json:"token_type"`
-	ExpiresIn    int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	Scope        string `json:"scope,omitempty"`
-	IDToken      string `json:"id_token,omitempty"`
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	Scope           string `json:"scope,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
 }
 
 // PKCE Error Response
@@ -7,9 +7,13 @@ import (
 
 type RoleMemberOpRequest struct {
 	Op       int       `json:"op" binding:"required"` // 1 for ADD, 2 for REMOVE
-	Type     string    `json:"type" binding:"required"` // USER or GROUP
+	Type     string    `json:"type" binding:"required"` // USER, GROUP or ORG
 	RoleID   uuid.UUID `json:"roleId" binding:"required"`
 	EntityID uuid.UUID `json:"entityId" binding:"required"`
+	// ADD only: the assignment takes effect at ValidFrom and ends at
+	// ValidUntil
+	ValidFrom  *time.Time `json:"validFrom"`
+	ValidUntil *time.Time `json:"validUntil"`
 }
 
 type RoleMemberResponse struct {
@@ -0,0 +1,34 @@
+package access
+
+import "time"
+
+// AccessStep is a group, role or org on the way from a user to a grant
+type AccessStep struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// AccessPath explains a grant: the memberships leading from the user to it,
+// ending with the grant itself. Via is the type of the step before the
+// grant, or "direct" when the user holds it themselves.
+type AccessPath struct {
+	Via   string       `json:"via"`
+	Steps []AccessStep `json:"steps"`
+}
+
+// AccessGrant is a group, role or org the user has, with every way they
+// have it
+type AccessGrant struct {
+	ID    string       `json:"id"`
+	Name  string       `json:"name"`
+	Paths []AccessPath `json:"paths"`
+}
+
+type EffectiveAccessResponse struct {
+	UserID     string        `json:"userId"`
+	Groups     []AccessGrant `json:"groups"`
+	Roles      []AccessGrant `json:"roles"`
+	Orgs       []AccessGrant `json:"orgs"`
+	ResolvedAt time.Time     `json:"resolvedAt"`
+}
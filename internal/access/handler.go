@@ -0,0 +1,59 @@
+package access
+
+import (
+	"net/http"
+
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type AccessController struct {
+	resolver *Resolver
+	logger   *logrus.Logger
+}
+
+func NewAccessController(resolver *Resolver) *AccessController {
+	return &AccessController{
+		resolver: resolver,
+		logger:   logrus.New(),
+	}
+}
+
+// GetUserAccess returns the groups, roles and orgs of a user, with how the
+// user has each of them
+func (c *AccessController) GetUserAccess(ctx *gin.Context) {
+	userID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	c.effectiveAccess(ctx, userID)
+}
+
+// GetMyAccess returns the effective access of the signed-in user
+func (c *AccessController) GetMyAccess(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	c.effectiveAccess(ctx, userID)
+}
+
+func (c *AccessController) effectiveAccess(ctx *gin.Context, userID uuid.UUID) {
+	access, err := c.resolver.WithContext(ctx).Resolve(userID)
+	if err != nil {
+		c.logger.Errorf("Failed to resolve effective access: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve effective access"})
+		return
+	}
+	if access == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, access)
+}
@@ -0,0 +1,441 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	TypeUser  = "user"
+	TypeGroup = "group"
+	TypeRole  = "role"
+	TypeOrg   = "org"
+
+	// ViaDirect marks paths where the user holds the grant themselves
+	ViaDirect = "direct"
+)
+
+// defaultMaxEntries bounds the number of users whose access is cached
+const defaultMaxEntries = 10000
+
+// node is a user, group, role or org in the membership graph
+type node struct {
+	Type string
+	ID   uuid.UUID
+}
+
+func (n node) less(other node) bool {
+	if n.Type != other.Type {
+		return n.Type < other.Type
+	}
+	return n.ID.String() < other.ID.String()
+}
+
+// edge is a membership: From is a member or assignee of To
+type edge struct {
+	From node
+	To   node
+}
+
+// memberTypes maps the type column of org_members and role_members to nodes
+var memberTypes = map[string]string{"USER": TypeUser, "GROUP": TypeGroup, "ROLE": TypeRole, "ORG": TypeOrg}
+
+// nameTables holds the names of the nodes a user can reach
+var nameTables = map[string]string{TypeGroup: "groups", TypeRole: "roles", TypeOrg: "orgs"}
+
+type cachedAccess struct {
+	access    *EffectiveAccessResponse
+	expiresAt time.Time
+}
+
+// Resolver computes the groups, roles and orgs a user has transitively:
+// groups they are in, directly or through nested groups, orgs they, their
+// groups or their roles are members of, directly or through a cascading
+// membership of an org above, and roles assigned to them, their groups or
+// their orgs. Group memberships and role assignments outside their window
+// don't count. Results are cached for ttl and dropped whenever
+// memberships change.
+type Resolver struct {
+	db     *gorm.DB
+	cache  *accessCache
+	logger *logrus.Logger
+}
+
+// accessCache holds the resolved access shared by the copies of a resolver
+type accessCache struct {
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.Mutex
+	entries    map[uuid.UUID]cachedAccess
+}
+
+func NewResolver(db *gorm.DB, ttl time.Duration) *Resolver {
+	return &Resolver{
+		db: db,
+		cache: &accessCache{
+			ttl:        ttl,
+			maxEntries: defaultMaxEntries,
+			entries:    make(map[uuid.UUID]cachedAccess),
+		},
+		logger: logrus.New(),
+	}
+}
+
+// WithContext returns a copy of the resolver whose statements run with ctx,
+// scoped to its tenant. The copies share their cache.
+func (r *Resolver) WithContext(ctx context.Context) *Resolver {
+	scoped := *r
+	scoped.db = r.db.WithContext(ctx)
+	return &scoped
+}
+
+// Resolve returns the effective access of the user, or nil if the user
+// doesn't exist in the tenant
+func (r *Resolver) Resolve(userID uuid.UUID) (*EffectiveAccessResponse, error) {
+	// Cached access is only returned once the user is known to be the
+	// tenant's
+	var count int64
+	if err := r.db.Table("users").Where("id = ?", userID).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if count == 0 {
+		return nil, nil
+	}
+	if owned, err := tenant.Owns(r.db, "user", userID); err != nil || !owned {
+		return nil, err
+	}
+
+	now := time.Now()
+	c := r.cache
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.access, nil
+	}
+
+	start := node{Type: TypeUser, ID: userID}
+	edges, err := r.loadEdges(start)
+	if err != nil {
+		return nil, err
+	}
+	paths := explain(start, edges)
+	names, err := r.loadNames(paths)
+	if err != nil {
+		return nil, err
+	}
+	access := buildResponse(userID, paths, names)
+	access.ResolvedAt = now
+
+	if c.ttl > 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if len(c.entries) >= c.maxEntries {
+			c.evictExpired(now)
+		}
+		if len(c.entries) >= c.maxEntries {
+			c.entries = make(map[uuid.UUID]cachedAccess)
+		}
+		c.entries[userID] = cachedAccess{access: access, expiresAt: now.Add(c.ttl)}
+	}
+	return access, nil
+}
+
+// Invalidate forgets all resolved access. A membership change can affect
+// every user below it, so nothing is kept.
+func (r *Resolver) Invalidate() {
+	r.cache.mu.Lock()
+	defer r.cache.mu.Unlock()
+	r.cache.entries = make(map[uuid.UUID]cachedAccess)
+}
+
+// HandleMembershipEvent invalidates the cache when memberships, or the
+// groups, roles and orgs holding them, change
+func (r *Resolver) HandleMembershipEvent(event events.Event) {
+	r.Invalidate()
+}
+
+func (c *accessCache) evictExpired(now time.Time) {
+	for userID, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, userID)
+		}
+	}
+}
+
+// loadEdges walks the membership tables breadth first from start and returns
+// every membership of the nodes it reaches
+func (r *Resolver) loadEdges(start node) ([]edge, error) {
+	var edges []edge
+	seen := map[node]bool{start: true}
+	frontier := []node{start}
+	for len(frontier) > 0 {
+		found, err := r.outgoing(frontier)
+		if err != nil {
+			return nil, err
+		}
+		frontier = nil
+		for _, e := range found {
+			edges = append(edges, e)
+			if !seen[e.To] {
+				seen[e.To] = true
+				frontier = append(frontier, e.To)
+			}
+		}
+	}
+	return edges, nil
+}
+
+// outgoing returns the memberships of the given nodes
+func (r *Resolver) outgoing(nodes []node) ([]edge, error) {
+	inFrontier := make(map[node]bool, len(nodes))
+	var ids, userIDs, groupIDs []uuid.UUID
+	for _, n := range nodes {
+		inFrontier[n] = true
+		ids = append(ids, n.ID)
+		switch n.Type {
+		case TypeUser:
+			userIDs = append(userIDs, n.ID)
+		case TypeGroup:
+			groupIDs = append(groupIDs, n.ID)
+		}
+	}
+
+	var edges []edge
+	if len(userIDs) > 0 {
+		var rows []struct {
+			UserID  uuid.UUID
+			GroupID uuid.UUID
+		}
+		err := r.db.Table("members").Select("user_id, group_id").
+			Where("user_id IN ?", userIDs).
+			Where(models.InEffectSQL("members")).
+			Find(&rows).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to load group memberships: %w", err)
+		}
+		for _, row := range rows {
+			edges = append(edges, edge{From: node{Type: TypeUser, ID: row.UserID}, To: node{Type: TypeGroup, ID: row.GroupID}})
+		}
+	}
+	if len(groupIDs) > 0 {
+		var rows []struct {
+			MemberGroupID uuid.UUID
+			GroupID       uuid.UUID
+		}
+		if err := r.db.Table("nested_groups").Select("member_group_id, group_id").Where("member_group_id IN ?", groupIDs).Find(&rows).Error; err != nil {
+			return nil, fmt.Errorf("failed to load nested groups: %w", err)
+		}
+		for _, row := range rows {
+			edges = append(edges, edge{From: node{Type: TypeGroup, ID: row.MemberGroupID}, To: node{Type: TypeGroup, ID: row.GroupID}})
+		}
+	}
+
+	cascading := make(map[uuid.UUID][]node)
+	for _, table := range []struct {
+		name, column, cascade, to string
+		windowed                  bool
+	}{
+		{"org_members", "org_id", `"cascade"`, TypeOrg, false},
+		{"role_members", "role_id", "FALSE", TypeRole, true},
+	} {
+		var rows []struct {
+			EntityID uuid.UUID
+			Type     string
+			TargetID uuid.UUID
+			Cascade  bool
+		}
+		query := r.db.Table(table.name).
+			Select("entity_id, type, "+table.column+" AS target_id, "+table.cascade+" AS cascade").
+			Where("entity_id IN ?", ids)
+		if table.windowed {
+			query = query.Where(models.InEffectSQL(table.name))
+		}
+		err := query.Find(&rows).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", table.name, err)
+		}
+		for _, row := range rows {
+			from := node{Type: memberTypes[strings.ToUpper(row.Type)], ID: row.EntityID}
+			if !inFrontier[from] {
+				continue
+			}
+			edges = append(edges, edge{From: from, To: node{Type: table.to, ID: row.TargetID}})
+			if row.Cascade {
+				cascading[row.TargetID] = append(cascading[row.TargetID], from)
+			}
+		}
+	}
+
+	// Cascading org members are members of every org below too
+	if len(cascading) > 0 {
+		orgIDs := make([]uuid.UUID, 0, len(cascading))
+		for id := range cascading {
+			orgIDs = append(orgIDs, id)
+		}
+		var rows []struct {
+			AncestorID uuid.UUID
+			OrgID      uuid.UUID
+		}
+		err := r.db.Table("orgs a").
+			Select("a.id AS ancestor_id, o.id AS org_id").
+			Joins("JOIN orgs o ON o.path LIKE a.path || '%' AND o.id <> a.id").
+			Where("a.id IN ?", orgIDs).
+			Find(&rows).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to load org subtrees: %w", err)
+		}
+		for _, row := range rows {
+			for _, from := range cascading[row.AncestorID] {
+				edges = append(edges, edge{From: from, To: node{Type: TypeOrg, ID: row.OrgID}})
+			}
+		}
+	}
+	return edges, nil
+}
+
+// loadNames returns the names of the reached groups, roles and orgs
+func (r *Resolver) loadNames(paths map[node][][]node) (map[node]string, error) {
+	idsByType := make(map[string][]uuid.UUID)
+	for n := range paths {
+		idsByType[n.Type] = append(idsByType[n.Type], n.ID)
+	}
+	names := make(map[node]string)
+	for nodeType, ids := range idsByType {
+		table, ok := nameTables[nodeType]
+		if !ok {
+			continue
+		}
+		var rows []struct {
+			ID   uuid.UUID
+			Name string
+		}
+		if err := r.db.Table(table).Select("id, name").Where("id IN ?", ids).Find(&rows).Error; err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", table, err)
+		}
+		for _, row := range rows {
+			names[node{Type: nodeType, ID: row.ID}] = row.Name
+		}
+	}
+	return names, nil
+}
+
+// explain returns, for every node reachable from start, the paths leading to
+// it: one through each membership it is reached by, continuing the shortest
+// path to that member. Paths that would pass through the node itself are
+// left out, so cycles terminate.
+func explain(start node, edges []edge) map[node][][]node {
+	adjacency := make(map[node][]node)
+	incoming := make(map[node][]node)
+	seenEdge := make(map[edge]bool)
+	for _, e := range edges {
+		if seenEdge[e] || e.To == start {
+			continue
+		}
+		seenEdge[e] = true
+		adjacency[e.From] = append(adjacency[e.From], e.To)
+		incoming[e.To] = append(incoming[e.To], e.From)
+	}
+	for _, next := range adjacency {
+		sort.Slice(next, func(i, j int) bool { return next[i].less(next[j]) })
+	}
+
+	shortest := map[node][]node{start: {}}
+	queue := []node{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, next := range adjacency[current] {
+			if _, ok := shortest[next]; ok {
+				continue
+			}
+			shortest[next] = append(append([]node{}, shortest[current]...), next)
+			queue = append(queue, next)
+		}
+	}
+
+	paths := make(map[node][][]node)
+	for n := range shortest {
+		if n == start {
+			continue
+		}
+		for _, from := range incoming[n] {
+			base, ok := shortest[from]
+			if !ok || containsNode(base, n) {
+				continue
+			}
+			paths[n] = append(paths[n], append(append([]node{}, base...), n))
+		}
+		sort.Slice(paths[n], func(i, j int) bool {
+			a, b := paths[n][i], paths[n][j]
+			if len(a) != len(b) {
+				return len(a) < len(b)
+			}
+			for k := range a {
+				if a[k] != b[k] {
+					return a[k].less(b[k])
+				}
+			}
+			return false
+		})
+	}
+	return paths
+}
+
+func containsNode(path []node, n node) bool {
+	for _, p := range path {
+		if p == n {
+			return true
+		}
+	}
+	return false
+}
+
+// buildResponse renders the paths of explain, grants sorted by name
+func buildResponse(userID uuid.UUID, paths map[node][][]node, names map[node]string) *EffectiveAccessResponse {
+	grants := map[string][]AccessGrant{TypeGroup: {}, TypeRole: {}, TypeOrg: {}}
+	for n, nodePaths := range paths {
+		if _, ok := grants[n.Type]; !ok {
+			continue
+		}
+		grant := AccessGrant{ID: n.ID.String(), Name: names[n]}
+		for _, path := range nodePaths {
+			via := ViaDirect
+			if len(path) > 1 {
+				via = path[len(path)-2].Type
+			}
+			steps := make([]AccessStep, len(path))
+			for i, step := range path {
+				steps[i] = AccessStep{Type: step.Type, ID: step.ID.String(), Name: names[step]}
+			}
+			grant.Paths = append(grant.Paths, AccessPath{Via: via, Steps: steps})
+		}
+		grants[n.Type] = append(grants[n.Type], grant)
+	}
+	for _, list := range grants {
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Name != list[j].Name {
+				return list[i].Name < list[j].Name
+			}
+			return list[i].ID < list[j].ID
+		})
+	}
+	return &EffectiveAccessResponse{
+		UserID: userID.String(),
+		Groups: grants[TypeGroup],
+		Roles:  grants[TypeRole],
+		Orgs:   grants[TypeOrg],
+	}
+}
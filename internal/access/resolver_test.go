@@ -0,0 +1,72 @@
+package access
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplain(t *testing.T) {
+	user := node{Type: TypeUser, ID: uuid.New()}
+	staff := node{Type: TypeGroup, ID: uuid.New()}
+	acme := node{Type: TypeOrg, ID: uuid.New()}
+	auditor := node{Type: TypeRole, ID: uuid.New()}
+	billing := node{Type: TypeRole, ID: uuid.New()}
+
+	paths := explain(user, []edge{
+		{From: user, To: staff},
+		{From: acme, To: billing},
+		{From: user, To: auditor},
+		{From: staff, To: auditor},
+		// The auditor role is in acme, which is assigned the auditor role
+		{From: auditor, To: acme},
+		{From: acme, To: auditor},
+		// Duplicate rows are one membership
+		{From: user, To: staff},
+	})
+
+	assert.Equal(t, [][]node{{staff}}, paths[staff])
+	assert.Equal(t, [][]node{{auditor, acme}}, paths[acme])
+	assert.Equal(t, [][]node{{auditor, acme, billing}}, paths[billing])
+	// The path through acme would pass through the auditor role again
+	assert.Equal(t, [][]node{{auditor}, {staff, auditor}}, paths[auditor])
+	assert.NotContains(t, paths, user)
+}
+
+func TestBuildResponse(t *testing.T) {
+	userID := uuid.New()
+	user := node{Type: TypeUser, ID: userID}
+	staff := node{Type: TypeGroup, ID: uuid.New()}
+	acme := node{Type: TypeOrg, ID: uuid.New()}
+	admin := node{Type: TypeRole, ID: uuid.New()}
+	billing := node{Type: TypeRole, ID: uuid.New()}
+	names := map[node]string{staff: "staff", acme: "acme", admin: "admin", billing: "billing"}
+
+	access := buildResponse(userID, explain(user, []edge{
+		{From: user, To: staff},
+		{From: staff, To: acme},
+		{From: acme, To: billing},
+		{From: user, To: admin},
+	}), names)
+
+	assert.Equal(t, userID.String(), access.UserID)
+	require.Len(t, access.Roles, 2)
+	assert.Equal(t, "admin", access.Roles[0].Name)
+	assert.Equal(t, []AccessPath{{Via: ViaDirect, Steps: []AccessStep{
+		{Type: TypeRole, ID: admin.ID.String(), Name: "admin"},
+	}}}, access.Roles[0].Paths)
+	assert.Equal(t, []AccessPath{{Via: TypeOrg, Steps: []AccessStep{
+		{Type: TypeGroup, ID: staff.ID.String(), Name: "staff"},
+		{Type: TypeOrg, ID: acme.ID.String(), Name: "acme"},
+		{Type: TypeRole, ID: billing.ID.String(), Name: "billing"},
+	}}}, access.Roles[1].Paths)
+	require.Len(t, access.Orgs, 1)
+	assert.Equal(t, TypeGroup, access.Orgs[0].Paths[0].Via)
+	require.Len(t, access.Groups, 1)
+
+	empty := buildResponse(userID, explain(user, nil), nil)
+	assert.Empty(t, empty.Groups)
+	assert.NotNil(t, empty.Roles, "lists are rendered as []")
+}
@@ -0,0 +1,32 @@
+package accessrequest
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type AccessRequestCreateRequest struct {
+	ResourceType  string     `json:"resourceType" binding:"required,oneof=group role"`
+	ResourceID    uuid.UUID  `json:"resourceId" binding:"required"`
+	Justification string     `json:"justification" binding:"required,max=2000"`
+	ValidFrom     *time.Time `json:"validFrom"`
+	ValidUntil    *time.Time `json:"validUntil"`
+}
+
+// DecisionRequest approves, denies or escalates the current stage of a
+// request
+type DecisionRequest struct {
+	Comment string `json:"comment" binding:"max=2000"`
+}
+
+type ApprovalStageRequest struct {
+	Approver   string     `json:"approver" binding:"required,oneof=owner manager org_admin group user"`
+	ApproverID *uuid.UUID `json:"approverId"`
+}
+
+// ApprovalChainRequest replaces the approval chain of a group or role; no
+// stages restores the default chain
+type ApprovalChainRequest struct {
+	Stages []ApprovalStageRequest `json:"stages" binding:"dive"`
+}
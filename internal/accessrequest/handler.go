@@ -0,0 +1,281 @@
+package accessrequest
+
+import (
+	"errors"
+	"net/http"
+
+	"idmapp-go/middleware"
+	"idmapp-go/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type AccessRequestController struct {
+	service *AccessRequestService
+	logger  *logrus.Logger
+}
+
+func NewAccessRequestController(service *AccessRequestService) *AccessRequestController {
+	return &AccessRequestController{
+		service: service,
+		logger:  logrus.New(),
+	}
+}
+
+// CreateRequest files an access request of the signed-in user
+func (c *AccessRequestController) CreateRequest(ctx *gin.Context) {
+	userID, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+	var req AccessRequestCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	request, err := c.service.WithContext(ctx).CreateRequest(userID, req)
+	if err != nil {
+		c.handleError(ctx, "Failed to create access request", err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, request)
+}
+
+// GetRequests lists every access request; pass state to filter them
+func (c *AccessRequestController) GetRequests(ctx *gin.Context) {
+	requests, err := c.service.WithContext(ctx).GetRequests(ctx.Query("state"))
+	if err != nil {
+		c.handleError(ctx, "Failed to get access requests", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, requests)
+}
+
+// GetMyRequests lists the access requests of the signed-in user
+func (c *AccessRequestController) GetMyRequests(ctx *gin.Context) {
+	userID, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+	requests, err := c.service.WithContext(ctx).GetRequestsByRequester(userID)
+	if err != nil {
+		c.handleError(ctx, "Failed to get access requests", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, requests)
+}
+
+// GetMyApprovals lists the pending requests the signed-in user may decide
+func (c *AccessRequestController) GetMyApprovals(ctx *gin.Context) {
+	userID, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+	requests, err := c.service.WithContext(ctx).GetPendingApprovals(userID)
+	if err != nil {
+		c.handleError(ctx, "Failed to get pending approvals", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, requests)
+}
+
+// GetRequest returns a request with its approval chain to its requester,
+// its approvers and system readers
+func (c *AccessRequestController) GetRequest(ctx *gin.Context) {
+	if request := c.viewableRequest(ctx); request != nil {
+		ctx.JSON(http.StatusOK, request)
+	}
+}
+
+// GetAudit returns the audit trail of a request
+func (c *AccessRequestController) GetAudit(ctx *gin.Context) {
+	request := c.viewableRequest(ctx)
+	if request == nil {
+		return
+	}
+	entries, err := c.service.WithContext(ctx).GetAudit(request.ID)
+	if err != nil {
+		c.handleError(ctx, "Failed to get access request audit", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, entries)
+}
+
+func (c *AccessRequestController) Approve(ctx *gin.Context) {
+	c.decide(ctx, DecisionApprove)
+}
+
+func (c *AccessRequestController) Deny(ctx *gin.Context) {
+	c.decide(ctx, DecisionDeny)
+}
+
+func (c *AccessRequestController) Escalate(ctx *gin.Context) {
+	c.decide(ctx, DecisionEscalate)
+}
+
+func (c *AccessRequestController) decide(ctx *gin.Context, decision string) {
+	userID, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid access request ID"})
+		return
+	}
+	var req DecisionRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	request, err := c.service.WithContext(ctx).Decide(id, userID, decision, req.Comment)
+	if err != nil {
+		c.handleError(ctx, "Failed to decide access request", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, request)
+}
+
+// CancelRequest withdraws a pending request of the signed-in user
+func (c *AccessRequestController) CancelRequest(ctx *gin.Context) {
+	userID, ok := currentUser(ctx)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid access request ID"})
+		return
+	}
+
+	request, err := c.service.WithContext(ctx).CancelRequest(id, userID)
+	if err != nil {
+		c.handleError(ctx, "Failed to cancel access request", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, request)
+}
+
+// GetGroupChain returns the approval chain of a group
+func (c *AccessRequestController) GetGroupChain(ctx *gin.Context) {
+	c.getChain(ctx, ResourceGroup)
+}
+
+// SetGroupChain replaces the approval chain of a group
+func (c *AccessRequestController) SetGroupChain(ctx *gin.Context) {
+	c.setChain(ctx, ResourceGroup)
+}
+
+// GetRoleChain returns the approval chain of a role
+func (c *AccessRequestController) GetRoleChain(ctx *gin.Context) {
+	c.getChain(ctx, ResourceRole)
+}
+
+// SetRoleChain replaces the approval chain of a role
+func (c *AccessRequestController) SetRoleChain(ctx *gin.Context) {
+	c.setChain(ctx, ResourceRole)
+}
+
+func (c *AccessRequestController) getChain(ctx *gin.Context, resourceType string) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + resourceType + " ID"})
+		return
+	}
+	stages, err := c.service.WithContext(ctx).GetChain(resourceType, id)
+	if err != nil {
+		c.handleError(ctx, "Failed to get approval chain", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, stages)
+}
+
+func (c *AccessRequestController) setChain(ctx *gin.Context, resourceType string) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + resourceType + " ID"})
+		return
+	}
+	var req ApprovalChainRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stages, err := c.service.WithContext(ctx).SetChain(resourceType, id, req)
+	if err != nil {
+		c.handleError(ctx, "Failed to set approval chain", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, stages)
+}
+
+// viewableRequest loads the request of the path, answering and returning
+// nil unless the signed-in user may see it. Requests the user can't see are
+// reported missing.
+func (c *AccessRequestController) viewableRequest(ctx *gin.Context) *AccessRequest {
+	userID, ok := currentUser(ctx)
+	if !ok {
+		return nil
+	}
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid access request ID"})
+		return nil
+	}
+
+	service := c.service.WithContext(ctx)
+	request, err := service.GetRequest(id)
+	if err != nil {
+		c.handleError(ctx, "Failed to get access request", err)
+		return nil
+	}
+	if request != nil {
+		visible, err := service.CanView(request, userID)
+		if err != nil {
+			c.handleError(ctx, "Failed to get access request", err)
+			return nil
+		}
+		if !visible {
+			request = nil
+		}
+	}
+	if request == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrRequestNotFound.Error()})
+		return nil
+	}
+	return request
+}
+
+func (c *AccessRequestController) handleError(ctx *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, ErrRequestNotFound), errors.Is(err, ErrResourceNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotApprover), errors.Is(err, ErrSelfApproval), errors.Is(err, ErrNotRequester),
+		errors.Is(err, ErrRepeatApprover):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrDuplicateRequest), errors.Is(err, ErrAlreadyGranted), errors.Is(err, ErrNotPending),
+		errors.Is(err, ErrAlreadyEscalated):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidChain), errors.Is(err, models.ErrInvalidValidity):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.logger.Errorf("%s: %v", message, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+func currentUser(ctx *gin.Context) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return uuid.Nil, false
+	}
+	return userID, true
+}
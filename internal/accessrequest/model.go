@@ -0,0 +1,163 @@
+package accessrequest
+
+import (
+	"time"
+
+	"idmapp-go/internal/tenant"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Resource types that can be requested
+const (
+	ResourceGroup = "group"
+	ResourceRole  = "role"
+)
+
+// Request states
+const (
+	StatePending   = "pending"
+	StateDenied    = "denied"
+	StateCancelled = "cancelled"
+	// StateApproved requests passed every stage and are being granted
+	StateApproved = "approved"
+	StateGranted  = "granted"
+	// StateFailed requests were approved but the grant failed
+	StateFailed = "failed"
+)
+
+// Approvers of a stage
+const (
+	// ApproverOwner stages are decided by the owners of the requested group
+	ApproverOwner = "owner"
+	// ApproverManager stages are decided by those who manage the requested
+	// group or role: group owners and system admins
+	ApproverManager = "manager"
+	// ApproverOrgAdmin stages are decided by the admins of ApproverID's org
+	// or of an org above it
+	ApproverOrgAdmin = "org_admin"
+	// ApproverGroup stages are decided by any member of ApproverID's group
+	ApproverGroup = "group"
+	// ApproverUser stages are decided by the user ApproverID
+	ApproverUser = "user"
+)
+
+// Audit actions
+const (
+	ActionRequested = "requested"
+	ActionApproved  = "approved"
+	ActionDenied    = "denied"
+	ActionEscalated = "escalated"
+	ActionCancelled = "cancelled"
+	ActionGranted   = "granted"
+	ActionFailed    = "grant_failed"
+)
+
+// AccessRequest is a user's request to join a group or be assigned a role
+type AccessRequest struct {
+	tenant.Scoped
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	RequesterID   uuid.UUID  `json:"requesterId" gorm:"type:uuid;not null;index"`
+	ResourceType  string     `json:"resourceType" gorm:"type:varchar(32);not null;index:idx_access_requests_resource"`
+	ResourceID    uuid.UUID  `json:"resourceId" gorm:"type:uuid;not null;index:idx_access_requests_resource"`
+	Justification string     `json:"justification" gorm:"type:text;not null"`
+	ValidFrom     *time.Time `json:"validFrom,omitempty"`
+	ValidUntil    *time.Time `json:"validUntil,omitempty"`
+	State         string     `json:"state" gorm:"type:varchar(32);not null;index"`
+	// Stage is the position of the stage awaiting a decision, and Stages
+	// how many the chain had when the request was made
+	Stage  int `json:"stage" gorm:"not null"`
+	Stages int `json:"stages" gorm:"not null"`
+	// Escalated requests wait for a system admin to decide the stage
+	Escalated bool       `json:"escalated" gorm:"not null;default:false"`
+	Error     string     `json:"error,omitempty"`
+	DecidedAt *time.Time `json:"decidedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	// Chain is the approval chain the request goes through
+	Chain []RequestStage `json:"chain,omitempty" gorm:"-"`
+}
+
+func (r *AccessRequest) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (r *AccessRequest) TableName() string {
+	return "access_requests"
+}
+
+// ApprovalStage is a stage of the approval chain of a group or role. The
+// stages are decided in Position order; a resource without stages has a
+// single ApproverManager stage.
+type ApprovalStage struct {
+	tenant.Scoped
+	ID           uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	ResourceType string     `json:"resourceType" gorm:"type:varchar(32);not null;uniqueIndex:idx_approval_stages_position"`
+	ResourceID   uuid.UUID  `json:"resourceId" gorm:"type:uuid;not null;uniqueIndex:idx_approval_stages_position"`
+	Position     int        `json:"position" gorm:"not null;uniqueIndex:idx_approval_stages_position"`
+	Approver     string     `json:"approver" gorm:"type:varchar(32);not null"`
+	ApproverID   *uuid.UUID `json:"approverId,omitempty" gorm:"type:uuid"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+func (s *ApprovalStage) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+func (s *ApprovalStage) TableName() string {
+	return "approval_stages"
+}
+
+// RequestStage is a stage of the approval chain of a request, copied from
+// the chain of the requested resource when the request is made
+type RequestStage struct {
+	tenant.Scoped
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	RequestID  uuid.UUID  `json:"requestId" gorm:"type:uuid;not null;uniqueIndex:idx_access_request_stages_position"`
+	Position   int        `json:"position" gorm:"not null;uniqueIndex:idx_access_request_stages_position"`
+	Approver   string     `json:"approver" gorm:"type:varchar(32);not null"`
+	ApproverID *uuid.UUID `json:"approverId,omitempty" gorm:"type:uuid"`
+	ApprovedBy *uuid.UUID `json:"approvedBy,omitempty" gorm:"type:uuid"`
+	ApprovedAt *time.Time `json:"approvedAt,omitempty"`
+}
+
+func (s *RequestStage) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+func (s *RequestStage) TableName() string {
+	return "access_request_stages"
+}
+
+// AuditEntry records something that happened to an access request
+type AuditEntry struct {
+	tenant.Scoped
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	RequestID uuid.UUID  `json:"requestId" gorm:"type:uuid;not null;index"`
+	ActorID   *uuid.UUID `json:"actorId,omitempty" gorm:"type:uuid"`
+	Action    string     `json:"action" gorm:"type:varchar(32);not null"`
+	Stage     int        `json:"stage" gorm:"not null"`
+	Comment   string     `json:"comment,omitempty" gorm:"type:text"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func (e *AuditEntry) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+func (e *AuditEntry) TableName() string {
+	return "access_request_audit"
+}
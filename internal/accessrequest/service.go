@@ -0,0 +1,708 @@
+package accessrequest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"idmapp-go/dto"
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/notification"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/middleware"
+	"idmapp-go/models"
+	"idmapp-go/services"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxStages caps the length of an approval chain
+const MaxStages = 5
+
+var (
+	ErrRequestNotFound  = errors.New("access request not found")
+	ErrResourceNotFound = errors.New("group or role not found")
+	ErrDuplicateRequest = errors.New("a pending request for this access already exists")
+	ErrAlreadyGranted   = errors.New("the requester already has this access")
+	ErrNotPending       = errors.New("access request is not pending")
+	ErrNotApprover      = errors.New("not an approver of the current stage")
+	ErrSelfApproval     = errors.New("requesters can't decide their own requests")
+	ErrRepeatApprover   = errors.New("an approver can only approve one stage of a request")
+	ErrAlreadyEscalated = errors.New("access request is already escalated")
+	ErrNotRequester     = errors.New("only the requester can cancel a request")
+	ErrInvalidChain     = errors.New("invalid approval chain")
+)
+
+// Decisions on the current stage of a request
+const (
+	DecisionApprove  = "approve"
+	DecisionDeny     = "deny"
+	DecisionEscalate = "escalate"
+)
+
+// defaultChain is the chain of resources without stages of their own
+var defaultChain = []ApprovalStage{{Approver: ApproverManager}}
+
+// AccessRequestService lets users request groups and roles, and routes the
+// requests through the approval chain of the resource. Approved requests are
+// granted through the member and role member services.
+type AccessRequestService struct {
+	db          *gorm.DB
+	authorizer  middleware.Authorizer
+	adminRole   string
+	members     *member.MemberService
+	roleMembers *services.RoleMemberService
+	logger      *logrus.Logger
+}
+
+// NewAccessRequestService returns the service. Without an authorizer route
+// authorization is disabled, and only the assignees of adminRole decide the
+// stages other than user stages.
+func NewAccessRequestService(db *gorm.DB, authorizer middleware.Authorizer, adminRole string, members *member.MemberService, roleMembers *services.RoleMemberService) *AccessRequestService {
+	return &AccessRequestService{
+		db:          db,
+		authorizer:  authorizer,
+		adminRole:   adminRole,
+		members:     members,
+		roleMembers: roleMembers,
+		logger:      logrus.New(),
+	}
+}
+
+// WithContext returns a copy of the service whose statements and checks run
+// with ctx, scoped to its tenant
+func (s *AccessRequestService) WithContext(ctx context.Context) *AccessRequestService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
+func (s *AccessRequestService) ctx() context.Context {
+	return s.db.Statement.Context
+}
+
+// CreateRequest files a request of the user for a group or role and copies
+// the approval chain of the resource to it
+func (s *AccessRequestService) CreateRequest(requesterID uuid.UUID, req AccessRequestCreateRequest) (*AccessRequest, error) {
+	now := time.Now()
+	if _, err := models.NewValidity(req.ValidFrom, req.ValidUntil, now); err != nil {
+		return nil, err
+	}
+
+	request := AccessRequest{
+		RequesterID:   requesterID,
+		ResourceType:  req.ResourceType,
+		ResourceID:    req.ResourceID,
+		Justification: req.Justification,
+		ValidFrom:     req.ValidFrom,
+		ValidUntil:    req.ValidUntil,
+		State:         StatePending,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkResource(tx, req.ResourceType, req.ResourceID); err != nil {
+			return err
+		}
+		if err := checkNotHeld(tx, requesterID, req.ResourceType, req.ResourceID); err != nil {
+			return err
+		}
+
+		chain, err := getChain(tx, req.ResourceType, req.ResourceID)
+		if err != nil {
+			return err
+		}
+		request.Stages = len(chain)
+		if err := tx.Create(&request).Error; err != nil {
+			return fmt.Errorf("failed to create access request: %w", err)
+		}
+		request.Chain = make([]RequestStage, len(chain))
+		for i, stage := range chain {
+			request.Chain[i] = RequestStage{
+				RequestID:  request.ID,
+				Position:   i,
+				Approver:   stage.Approver,
+				ApproverID: stage.ApproverID,
+			}
+		}
+		if err := tx.Create(&request.Chain).Error; err != nil {
+			return fmt.Errorf("failed to create access request stages: %w", err)
+		}
+		return audit(tx, request, &requesterID, ActionRequested, req.Justification)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.publish("access_request.created", request)
+	return &request, nil
+}
+
+// checkResource returns ErrResourceNotFound unless the group or role exists
+// in the tenant
+func checkResource(tx *gorm.DB, resourceType string, id uuid.UUID) error {
+	owner, err := tenant.Of(tx, resourceType, id)
+	if err != nil {
+		return err
+	}
+	if owner == uuid.Nil {
+		return ErrResourceNotFound
+	}
+	if err := tenant.CheckOwns(tx, resourceType, id); err != nil {
+		if errors.Is(err, tenant.ErrNotOwned) {
+			return ErrResourceNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// checkNotHeld rejects requests for access the user has, directly, or has a
+// pending request for
+func checkNotHeld(tx *gorm.DB, userID uuid.UUID, resourceType string, id uuid.UUID) error {
+	var count int64
+	if err := tx.Model(&AccessRequest{}).
+		Where("requester_id = ? AND resource_type = ? AND resource_id = ? AND state IN ?", userID, resourceType, id, []string{StatePending, StateApproved}).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check pending requests: %w", err)
+	}
+	if count > 0 {
+		return ErrDuplicateRequest
+	}
+
+	var held *gorm.DB
+	switch resourceType {
+	case ResourceGroup:
+		held = tx.Model(&member.Member{}).Where("group_id = ? AND user_id = ?", id, userID)
+	default:
+		held = tx.Model(&models.RoleMember{}).Where("role_id = ? AND entity_id = ? AND UPPER(type) = 'USER'", id, userID)
+	}
+	if err := held.Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check existing access: %w", err)
+	}
+	if count > 0 {
+		return ErrAlreadyGranted
+	}
+	return nil
+}
+
+// GetRequest returns the request with its chain, or nil if there is none
+func (s *AccessRequestService) GetRequest(id uuid.UUID) (*AccessRequest, error) {
+	var request AccessRequest
+	result := s.db.First(&request, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get access request: %w", result.Error)
+	}
+	if err := s.db.Where("request_id = ?", id).Order("position").Find(&request.Chain).Error; err != nil {
+		return nil, fmt.Errorf("failed to get access request stages: %w", err)
+	}
+	return &request, nil
+}
+
+// GetRequests lists the requests, newest first, optionally only those in a
+// state
+func (s *AccessRequestService) GetRequests(state string) ([]AccessRequest, error) {
+	query := s.db.Order("created_at DESC")
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	var requests []AccessRequest
+	if err := query.Find(&requests).Error; err != nil {
+		return nil, fmt.Errorf("failed to get access requests: %w", err)
+	}
+	return requests, nil
+}
+
+// GetRequestsByRequester lists the requests of a user, newest first
+func (s *AccessRequestService) GetRequestsByRequester(userID uuid.UUID) ([]AccessRequest, error) {
+	var requests []AccessRequest
+	if err := s.db.Where("requester_id = ?", userID).Order("created_at DESC").Find(&requests).Error; err != nil {
+		return nil, fmt.Errorf("failed to get access requests: %w", err)
+	}
+	return requests, nil
+}
+
+// GetPendingApprovals lists the pending requests whose current stage the
+// user may decide, oldest first
+func (s *AccessRequestService) GetPendingApprovals(userID uuid.UUID) ([]AccessRequest, error) {
+	var pending []AccessRequest
+	if err := s.db.Where("state = ? AND requester_id <> ?", StatePending, userID).Order("created_at").Find(&pending).Error; err != nil {
+		return nil, fmt.Errorf("failed to get pending access requests: %w", err)
+	}
+
+	approvals := []AccessRequest{}
+	for _, request := range pending {
+		stage, err := s.currentStage(request)
+		if err != nil {
+			return nil, err
+		}
+		allowed, err := s.canDecide(request, stage, userID)
+		if err != nil {
+			return nil, err
+		}
+		if allowed {
+			approvals = append(approvals, request)
+		}
+	}
+	return approvals, nil
+}
+
+// GetAudit returns the audit trail of a request, oldest first
+func (s *AccessRequestService) GetAudit(requestID uuid.UUID) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	if err := s.db.Where("request_id = ?", requestID).Order("created_at").Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to get access request audit: %w", err)
+	}
+	return entries, nil
+}
+
+// CanView reports whether the user may see the request and its audit trail:
+// its requester, its approvers and system readers
+func (s *AccessRequestService) CanView(request *AccessRequest, userID uuid.UUID) (bool, error) {
+	if request.RequesterID == userID {
+		return true, nil
+	}
+	for _, stage := range request.Chain {
+		if stage.ApprovedBy != nil && *stage.ApprovedBy == userID {
+			return true, nil
+		}
+	}
+	if request.State == StatePending {
+		stage, err := s.currentStage(*request)
+		if err != nil {
+			return false, err
+		}
+		allowed, err := s.canDecide(*request, stage, userID)
+		if err != nil || allowed {
+			return allowed, err
+		}
+	}
+	return s.check(userID, middleware.RelationRead, middleware.SystemObject)
+}
+
+// Decide approves, denies or escalates the current stage of a request.
+// Approving the last stage grants the access; the request is returned in
+// its final state.
+func (s *AccessRequestService) Decide(id, actorID uuid.UUID, decision, comment string) (*AccessRequest, error) {
+	request, err := s.GetRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, ErrRequestNotFound
+	}
+	if request.State != StatePending {
+		return nil, ErrNotPending
+	}
+	if request.RequesterID == actorID {
+		return nil, ErrSelfApproval
+	}
+	stage, err := s.currentStage(*request)
+	if err != nil {
+		return nil, err
+	}
+	allowed, err := s.canDecide(*request, stage, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrNotApprover
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"updated_at": now}
+	var action, eventType string
+	switch decision {
+	case DecisionApprove:
+		for _, earlier := range request.Chain[:request.Stage] {
+			if earlier.ApprovedBy != nil && *earlier.ApprovedBy == actorID {
+				return nil, ErrRepeatApprover
+			}
+		}
+		action, eventType = ActionApproved, "access_request.stage_approved"
+		updates["stage"] = request.Stage + 1
+		updates["escalated"] = false
+		if request.Stage+1 == request.Stages {
+			updates["state"] = StateApproved
+			updates["decided_at"] = now
+			eventType = "access_request.approved"
+		}
+	case DecisionDeny:
+		action, eventType = ActionDenied, "access_request.denied"
+		updates["state"] = StateDenied
+		updates["decided_at"] = now
+	case DecisionEscalate:
+		if request.Escalated {
+			return nil, ErrAlreadyEscalated
+		}
+		action, eventType = ActionEscalated, "access_request.escalated"
+		updates["escalated"] = true
+	default:
+		return nil, fmt.Errorf("invalid decision: %s", decision)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// The request only changes if no one decided the stage meanwhile
+		result := tx.Model(&AccessRequest{}).
+			Where("id = ? AND state = ? AND stage = ? AND escalated = ?", request.ID, StatePending, request.Stage, request.Escalated).
+			Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update access request: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotPending
+		}
+		if decision == DecisionApprove {
+			if err := tx.Model(&RequestStage{}).Where("id = ?", stage.ID).
+				Updates(map[string]interface{}{"approved_by": actorID, "approved_at": now}).Error; err != nil {
+				return fmt.Errorf("failed to update access request stage: %w", err)
+			}
+		}
+		return audit(tx, *request, &actorID, action, comment)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err = s.GetRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(eventType, *request)
+	switch request.State {
+	case StateApproved:
+		return s.grant(request)
+	case StateDenied:
+		s.notify(*request, fmt.Sprintf("Your request for %s %s was denied", request.ResourceType, request.ResourceID))
+	}
+	return request, nil
+}
+
+// grant applies an approved request and records the outcome
+func (s *AccessRequestService) grant(request *AccessRequest) (*AccessRequest, error) {
+	var err error
+	switch request.ResourceType {
+	case ResourceGroup:
+		_, err = s.members.WithContext(s.ctx()).AddMember(dto.MemberOpRequest{
+			Op:         dto.OpTypeAdd,
+			GroupID:    request.ResourceID,
+			UserID:     request.RequesterID,
+			ValidFrom:  request.ValidFrom,
+			ValidUntil: request.ValidUntil,
+		})
+	default:
+		_, err = s.roleMembers.WithContext(s.ctx()).AddMember(request.ResourceID, request.RequesterID, "USER", request.ValidFrom, request.ValidUntil)
+	}
+
+	state, action, eventType := StateGranted, ActionGranted, "access_request.granted"
+	message := fmt.Sprintf("Your request for %s %s was approved", request.ResourceType, request.ResourceID)
+	if err != nil {
+		s.logger.Errorf("Failed to grant access request %s: %v", request.ID, err)
+		state, action, eventType = StateFailed, ActionFailed, "access_request.failed"
+		message = fmt.Sprintf("Your request for %s %s was approved but could not be applied", request.ResourceType, request.ResourceID)
+		request.Error = err.Error()
+	}
+	request.State = state
+	request.UpdatedAt = time.Now()
+
+	txErr := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&AccessRequest{}).Where("id = ?", request.ID).
+			Updates(map[string]interface{}{"state": state, "error": request.Error, "updated_at": request.UpdatedAt}).Error; err != nil {
+			return fmt.Errorf("failed to update access request: %w", err)
+		}
+		return audit(tx, *request, nil, action, request.Error)
+	})
+	if txErr != nil {
+		return nil, txErr
+	}
+
+	s.publish(eventType, *request)
+	s.notify(*request, message)
+	return request, nil
+}
+
+// CancelRequest withdraws a pending request of the user
+func (s *AccessRequestService) CancelRequest(id, userID uuid.UUID) (*AccessRequest, error) {
+	request, err := s.GetRequest(id)
+	if err != nil {
+		return nil, err
+	}
+	if request == nil {
+		return nil, ErrRequestNotFound
+	}
+	if request.RequesterID != userID {
+		return nil, ErrNotRequester
+	}
+
+	now := time.Now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&AccessRequest{}).
+			Where("id = ? AND state = ?", id, StatePending).
+			Updates(map[string]interface{}{"state": StateCancelled, "decided_at": now, "updated_at": now})
+		if result.Error != nil {
+			return fmt.Errorf("failed to cancel access request: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotPending
+		}
+		return audit(tx, *request, &userID, ActionCancelled, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request.State = StateCancelled
+	request.DecidedAt = &now
+	request.UpdatedAt = now
+	s.publish("access_request.cancelled", *request)
+	return request, nil
+}
+
+// GetChain returns the approval chain of a group or role
+func (s *AccessRequestService) GetChain(resourceType string, id uuid.UUID) ([]ApprovalStage, error) {
+	return getChain(s.db, resourceType, id)
+}
+
+func getChain(db *gorm.DB, resourceType string, id uuid.UUID) ([]ApprovalStage, error) {
+	var stages []ApprovalStage
+	if err := db.Where("resource_type = ? AND resource_id = ?", resourceType, id).Order("position").Find(&stages).Error; err != nil {
+		return nil, fmt.Errorf("failed to get approval chain: %w", err)
+	}
+	if len(stages) == 0 {
+		return defaultChain, nil
+	}
+	return stages, nil
+}
+
+// SetChain replaces the approval chain of a group or role. Pending requests
+// keep the chain they were made with.
+func (s *AccessRequestService) SetChain(resourceType string, id uuid.UUID, req ApprovalChainRequest) ([]ApprovalStage, error) {
+	if len(req.Stages) > MaxStages {
+		return nil, fmt.Errorf("%w: at most %d stages", ErrInvalidChain, MaxStages)
+	}
+	stages := make([]ApprovalStage, len(req.Stages))
+	for i, stage := range req.Stages {
+		if err := validateStage(resourceType, stage); err != nil {
+			return nil, err
+		}
+		stages[i] = ApprovalStage{
+			ResourceType: resourceType,
+			ResourceID:   id,
+			Position:     i,
+			Approver:     stage.Approver,
+			ApproverID:   stage.ApproverID,
+			CreatedAt:    time.Now(),
+		}
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkResource(tx, resourceType, id); err != nil {
+			return err
+		}
+		for _, stage := range stages {
+			if approverType := stageObjectType(stage.Approver); approverType != "" {
+				if err := checkStageObject(tx, approverType, *stage.ApproverID); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Where("resource_type = ? AND resource_id = ?", resourceType, id).Delete(&ApprovalStage{}).Error; err != nil {
+			return fmt.Errorf("failed to clear approval chain: %w", err)
+		}
+		if len(stages) == 0 {
+			return nil
+		}
+		if err := tx.Create(&stages).Error; err != nil {
+			return fmt.Errorf("failed to set approval chain: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stages) == 0 {
+		return defaultChain, nil
+	}
+	return stages, nil
+}
+
+func validateStage(resourceType string, stage ApprovalStageRequest) error {
+	needsID := stageObjectType(stage.Approver) != ""
+	switch {
+	case stage.Approver == ApproverOwner && resourceType != ResourceGroup:
+		return fmt.Errorf("%w: only groups have owners", ErrInvalidChain)
+	case needsID && stage.ApproverID == nil:
+		return fmt.Errorf("%w: %s stages need an approverId", ErrInvalidChain, stage.Approver)
+	case !needsID && stage.ApproverID != nil:
+		return fmt.Errorf("%w: %s stages take no approverId", ErrInvalidChain, stage.Approver)
+	}
+	return nil
+}
+
+// stageObjectType is the type of the object ApproverID names in stages of
+// the approver
+func stageObjectType(approver string) string {
+	switch approver {
+	case ApproverOrgAdmin:
+		return "org"
+	case ApproverGroup:
+		return "group"
+	case ApproverUser:
+		return "user"
+	}
+	return ""
+}
+
+func checkStageObject(tx *gorm.DB, objectType string, id uuid.UUID) error {
+	owner, err := tenant.Of(tx, objectType, id)
+	if err != nil {
+		return err
+	}
+	owned, err := tenant.Owns(tx, objectType, id)
+	if err != nil {
+		return err
+	}
+	if owner == uuid.Nil || !owned {
+		return fmt.Errorf("%w: %s %s not found", ErrInvalidChain, objectType, id)
+	}
+	return nil
+}
+
+func (s *AccessRequestService) currentStage(request AccessRequest) (RequestStage, error) {
+	var stage RequestStage
+	result := s.db.Where("request_id = ? AND position = ?", request.ID, request.Stage).First(&stage)
+	if result.Error != nil {
+		return RequestStage{}, fmt.Errorf("failed to get access request stage: %w", result.Error)
+	}
+	return stage, nil
+}
+
+// canDecide reports whether the user may decide the stage of the request.
+// System admins decide any stage, and alone decide escalated ones.
+func (s *AccessRequestService) canDecide(request AccessRequest, stage RequestStage, userID uuid.UUID) (bool, error) {
+	if request.RequesterID == userID {
+		return false, nil
+	}
+	admin, err := s.check(userID, middleware.RelationManage, middleware.SystemObject)
+	if err != nil || admin || request.Escalated {
+		return admin, err
+	}
+
+	switch stage.Approver {
+	case ApproverUser:
+		return stage.ApproverID != nil && *stage.ApproverID == userID, nil
+	case ApproverOwner:
+		return s.check(userID, "owner", "group:"+request.ResourceID.String())
+	case ApproverManager:
+		return s.check(userID, middleware.RelationManage, request.ResourceType+":"+request.ResourceID.String())
+	case ApproverOrgAdmin:
+		return s.check(userID, middleware.RelationManage, "org:"+stage.ApproverID.String())
+	case ApproverGroup:
+		return s.check(userID, "member", "group:"+stage.ApproverID.String())
+	}
+	return false, nil
+}
+
+// check asks the authorizer whether the user has relation to object. When
+// authorization is disabled only admins hold relations, so approvals don't
+// fall open to every user.
+func (s *AccessRequestService) check(userID uuid.UUID, relation, object string) (bool, error) {
+	if s.authorizer == nil {
+		return member.HasRole(s.db, userID, s.adminRole)
+	}
+	allowed, err := s.authorizer.Check(s.ctx(), "user:"+userID.String(), relation, object)
+	if err != nil {
+		return false, fmt.Errorf("failed to check approver: %w", err)
+	}
+	return allowed, nil
+}
+
+// HandleResourceDeleted drops the approval chain of a deleted group or role
+// and cancels the pending requests for it
+func (s *AccessRequestService) HandleResourceDeleted(event events.Event) {
+	resourceID, err := uuid.Parse(event.Subject)
+	if err != nil {
+		return
+	}
+	resourceType := ResourceRole
+	if event.Type == "group.deleted" {
+		resourceType = ResourceGroup
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("resource_type = ? AND resource_id = ?", resourceType, resourceID).Delete(&ApprovalStage{}).Error; err != nil {
+			return err
+		}
+		var pending []AccessRequest
+		now := time.Now()
+		if err := tx.Model(&pending).Clauses(clause.Returning{}).
+			Where("resource_type = ? AND resource_id = ? AND state = ?", resourceType, resourceID, StatePending).
+			Updates(map[string]interface{}{"state": StateCancelled, "decided_at": now, "updated_at": now}).Error; err != nil {
+			return err
+		}
+		for _, request := range pending {
+			if err := audit(tx, request, nil, ActionCancelled, resourceType+" deleted"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to clean up access requests of deleted %s %s: %v", resourceType, resourceID, err)
+	}
+}
+
+func audit(tx *gorm.DB, request AccessRequest, actorID *uuid.UUID, action, comment string) error {
+	entry := AuditEntry{
+		RequestID: request.ID,
+		ActorID:   actorID,
+		Action:    action,
+		Stage:     request.Stage,
+		Comment:   comment,
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to record access request audit: %w", err)
+	}
+	return nil
+}
+
+func (s *AccessRequestService) publish(eventType string, request AccessRequest) {
+	events.Publish(events.Event{
+		Type:    eventType,
+		Subject: request.ID.String(),
+		Data: map[string]interface{}{
+			"requesterId":  request.RequesterID.String(),
+			"resourceType": request.ResourceType,
+			"resourceId":   request.ResourceID.String(),
+			"state":        request.State,
+			"stage":        request.Stage,
+		},
+	})
+}
+
+// notify tells the requester about the outcome of their request
+func (s *AccessRequestService) notify(request AccessRequest, message string) {
+	resourceID := request.ResourceID
+	n := notification.Notification{
+		UserID:       request.RequesterID,
+		Type:         notification.TypeAccessRequestDecided,
+		Message:      message,
+		ResourceType: request.ResourceType,
+		ResourceID:   &resourceID,
+		CreatedAt:    time.Now(),
+	}
+	n.TenantID = request.TenantID
+	if err := notification.Notify(s.db, n); err != nil {
+		s.logger.Errorf("Failed to notify requester of access request %s: %v", request.ID, err)
+	}
+}
@@ -0,0 +1,126 @@
+package accessrequest
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeAuthorizer grants the relations listed per user
+type fakeAuthorizer map[string]map[string]bool
+
+func (f fakeAuthorizer) Check(ctx context.Context, user, relation, object string) (bool, error) {
+	return f[user][relation+" "+object], nil
+}
+
+func newTestService(t *testing.T, authorizer fakeAuthorizer) *AccessRequestService {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "accessrequest.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	if authorizer == nil {
+		return NewAccessRequestService(db, nil, "admin", nil, nil)
+	}
+	return NewAccessRequestService(db, authorizer, "admin", nil, nil)
+}
+
+func TestCanDecide(t *testing.T) {
+	requester, owner, orgAdmin, admin, other := uuid.New(), uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	group, org := uuid.New(), uuid.New()
+	user := func(id uuid.UUID) string { return "user:" + id.String() }
+	service := newTestService(t, fakeAuthorizer{
+		user(owner):    {"owner group:" + group.String(): true, "manage group:" + group.String(): true},
+		user(orgAdmin): {"manage org:" + org.String(): true},
+		user(admin):    {"manage system:idmapp": true},
+	})
+
+	request := AccessRequest{RequesterID: requester, ResourceType: ResourceGroup, ResourceID: group}
+	cases := []struct {
+		name    string
+		stage   RequestStage
+		user    uuid.UUID
+		allowed bool
+	}{
+		{"owner", RequestStage{Approver: ApproverOwner}, owner, true},
+		{"not owner", RequestStage{Approver: ApproverOwner}, orgAdmin, false},
+		{"manager", RequestStage{Approver: ApproverManager}, owner, true},
+		{"org admin", RequestStage{Approver: ApproverOrgAdmin, ApproverID: &org}, orgAdmin, true},
+		{"named user", RequestStage{Approver: ApproverUser, ApproverID: &other}, other, true},
+		{"other user", RequestStage{Approver: ApproverUser, ApproverID: &other}, owner, false},
+		{"system admin", RequestStage{Approver: ApproverUser, ApproverID: &other}, admin, true},
+		// Requesters never decide their own requests
+		{"requester", RequestStage{Approver: ApproverUser, ApproverID: &requester}, requester, false},
+	}
+	for _, tc := range cases {
+		allowed, err := service.canDecide(request, tc.stage, tc.user)
+		require.NoError(t, err, tc.name)
+		assert.Equal(t, tc.allowed, allowed, tc.name)
+	}
+
+	// Escalated stages are left to system admins
+	request.Escalated = true
+	allowed, err := service.canDecide(request, RequestStage{Approver: ApproverOwner}, owner)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	allowed, err = service.canDecide(request, RequestStage{Approver: ApproverOwner}, admin)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+}
+
+func TestCanDecideWithoutAuthorizer(t *testing.T) {
+	requester, admin, other := uuid.New(), uuid.New(), uuid.New()
+	adminRole, group := uuid.New(), uuid.New()
+	service := newTestService(t, nil)
+	for _, ddl := range []string{
+		"CREATE TABLE members (id TEXT PRIMARY KEY, group_id TEXT NOT NULL, user_id TEXT NOT NULL, valid_from DATETIME, valid_until DATETIME)",
+		"CREATE TABLE nested_groups (id TEXT PRIMARY KEY, group_id TEXT NOT NULL, member_group_id TEXT NOT NULL)",
+		"CREATE TABLE roles (id TEXT PRIMARY KEY, name TEXT NOT NULL)",
+		"CREATE TABLE role_members (id TEXT PRIMARY KEY, role_id TEXT NOT NULL, entity_id TEXT NOT NULL, type TEXT NOT NULL, valid_from DATETIME, valid_until DATETIME)",
+	} {
+		require.NoError(t, service.db.Exec(ddl).Error)
+	}
+	require.NoError(t, service.db.Exec("INSERT INTO roles (id, name) VALUES (?, 'admin')", adminRole).Error)
+	require.NoError(t, service.db.Exec("INSERT INTO role_members (id, role_id, entity_id, type) VALUES (?, ?, ?, 'USER')", uuid.New(), adminRole, admin).Error)
+
+	// Without route authorization only admins hold relations, so other
+	// users can't approve
+	request := AccessRequest{RequesterID: requester, ResourceType: ResourceGroup, ResourceID: group}
+	for _, stage := range []RequestStage{
+		{Approver: ApproverOwner},
+		{Approver: ApproverManager},
+		{Approver: ApproverGroup, ApproverID: &group},
+	} {
+		allowed, err := service.canDecide(request, stage, other)
+		require.NoError(t, err, stage.Approver)
+		assert.False(t, allowed, stage.Approver)
+
+		allowed, err = service.canDecide(request, stage, admin)
+		require.NoError(t, err, stage.Approver)
+		assert.True(t, allowed, stage.Approver)
+	}
+
+	allowed, err := service.canDecide(request, RequestStage{Approver: ApproverUser, ApproverID: &other}, other)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+
+	visible, err := service.CanView(&AccessRequest{RequesterID: requester, State: StateDenied}, other)
+	require.NoError(t, err)
+	assert.False(t, visible)
+}
+
+func TestValidateStage(t *testing.T) {
+	id := uuid.New()
+	assert.NoError(t, validateStage(ResourceGroup, ApprovalStageRequest{Approver: ApproverOwner}))
+	assert.NoError(t, validateStage(ResourceRole, ApprovalStageRequest{Approver: ApproverOrgAdmin, ApproverID: &id}))
+	assert.ErrorIs(t, validateStage(ResourceRole, ApprovalStageRequest{Approver: ApproverOwner}), ErrInvalidChain)
+	assert.ErrorIs(t, validateStage(ResourceGroup, ApprovalStageRequest{Approver: ApproverUser}), ErrInvalidChain)
+	assert.ErrorIs(t, validateStage(ResourceGroup, ApprovalStageRequest{Approver: ApproverManager, ApproverID: &id}), ErrInvalidChain)
+}
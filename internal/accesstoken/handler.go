@@ -0,0 +1,132 @@
+package accesstoken
+
+import (
+	"net/http"
+
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type TokenController struct {
+	tokenService *TokenService
+	logger       *logrus.Logger
+}
+
+func NewTokenController(tokenService *TokenService) *TokenController {
+	return &TokenController{
+		tokenService: tokenService,
+		logger:       logrus.New(),
+	}
+}
+
+func (c *TokenController) CreateMyToken(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// A leaked token must not be able to mint new ones, and impersonators
+	// must not outlive their impersonation session
+	if middleware.GetAuthMethod(ctx) == middleware.AuthMethodPAT {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Personal access tokens cannot create other tokens"})
+		return
+	}
+	if middleware.GetActor(ctx) != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Personal access tokens cannot be created while impersonating"})
+		return
+	}
+
+	var req TokenCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	token, plaintext, err := c.tokenService.WithContext(ctx).CreateToken(userID, req)
+	if err != nil {
+		c.logger.Errorf("Failed to create personal access token: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create personal access token"})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, TokenCreateResponse{
+		TokenResponse: toTokenResponse(token),
+		Token:         plaintext,
+	})
+}
+
+func (c *TokenController) GetMyTokens(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	c.listTokens(ctx, userID)
+}
+
+func (c *TokenController) RevokeMyToken(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	c.revokeToken(ctx, userID)
+}
+
+func (c *TokenController) GetUserTokens(ctx *gin.Context) {
+	userID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	c.listTokens(ctx, userID)
+}
+
+func (c *TokenController) RevokeUserToken(ctx *gin.Context) {
+	userID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	c.revokeToken(ctx, userID)
+}
+
+func (c *TokenController) listTokens(ctx *gin.Context, userID uuid.UUID) {
+	tokens, err := c.tokenService.WithContext(ctx).ListTokens(userID)
+	if err != nil {
+		c.logger.Errorf("Failed to get personal access tokens: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get personal access tokens"})
+		return
+	}
+
+	response := make([]TokenResponse, len(tokens))
+	for i := range tokens {
+		response[i] = toTokenResponse(&tokens[i])
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *TokenController) revokeToken(ctx *gin.Context, userID uuid.UUID) {
+	tokenID, err := uuid.Parse(ctx.Param("tokenId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	revoked, err := c.tokenService.WithContext(ctx).RevokeToken(userID, tokenID, middleware.GetUserID(ctx))
+	if err != nil {
+		c.logger.Errorf("Failed to revoke personal access token: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !revoked {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Personal access token not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
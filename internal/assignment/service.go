@@ -0,0 +1,268 @@
+package assignment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/notification"
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AssignmentService applies the windows of time-bound group memberships and
+// role assignments. Reads already ignore grants outside their window; the
+// service writes the tuples of grants as they take effect, removes expired
+// grants and warns the users about to lose one.
+//
+// It runs across tenants, so it must be given a database without a tenant.
+type AssignmentService struct {
+	db     *gorm.DB
+	notice time.Duration
+	logger *logrus.Logger
+}
+
+// NewAssignmentService returns a service that notifies users notice before
+// their grants expire; zero disables the notifications
+func NewAssignmentService(db *gorm.DB, notice time.Duration) *AssignmentService {
+	return &AssignmentService{
+		db:     db,
+		notice: notice,
+		logger: logrus.New(),
+	}
+}
+
+// ProcessDue activates the grants whose window has started, removes those
+// whose window has ended and sends the expiry notifications that are due.
+// Rows are claimed by the statements that change them, so several
+// instances can run it.
+func (s *AssignmentService) ProcessDue() (int, error) {
+	now := time.Now()
+	activated, err := s.activate(now)
+	if err != nil {
+		return 0, err
+	}
+	expired, err := s.expire(now)
+	if err != nil {
+		return 0, err
+	}
+	notified, err := s.notifyExpiring(now)
+	if err != nil {
+		return 0, err
+	}
+	return activated + expired + notified, nil
+}
+
+// activate writes the tuples of the grants whose window has started
+func (s *AssignmentService) activate(now time.Time) (int, error) {
+	var members []member.Member
+	var roleMembers []models.RoleMember
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		due := "activated_at IS NULL AND valid_from <= ? AND (valid_until IS NULL OR valid_until > ?)"
+		if err := tx.Model(&members).Clauses(clause.Returning{}).
+			Where(due, now, now).
+			Update("activated_at", now).Error; err != nil {
+			return fmt.Errorf("failed to activate members: %w", err)
+		}
+		if err := tx.Model(&roleMembers).Clauses(clause.Returning{}).
+			Where(due, now, now).
+			Update("activated_at", now).Error; err != nil {
+			return fmt.Errorf("failed to activate role members: %w", err)
+		}
+		return authz.Record(tx, authz.OpWrite, tuples(members, roleMembers)...)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	publish("activated", members, roleMembers)
+	return len(members) + len(roleMembers), nil
+}
+
+// expire removes the grants whose window has ended, and their tuples
+func (s *AssignmentService) expire(now time.Time) (int, error) {
+	var members []member.Member
+	var roleMembers []models.RoleMember
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Returning{}).Where("valid_until <= ?", now).Delete(&members).Error; err != nil {
+			return fmt.Errorf("failed to expire members: %w", err)
+		}
+		if err := tx.Clauses(clause.Returning{}).Where("valid_until <= ?", now).Delete(&roleMembers).Error; err != nil {
+			return fmt.Errorf("failed to expire role members: %w", err)
+		}
+		// Grants that never took effect have no tuple, but deleting a
+		// missing tuple is harmless
+		return authz.Record(tx, authz.OpDelete, tuples(members, roleMembers)...)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	publish("expired", members, roleMembers)
+	return len(members) + len(roleMembers), nil
+}
+
+// notifyExpiring tells the users of the grants that expire within the
+// notice period that they are about to lose them. Users of a group assigned
+// a role are each told; org assignments are too broad to notify.
+func (s *AssignmentService) notifyExpiring(now time.Time) (int, error) {
+	if s.notice <= 0 {
+		return 0, nil
+	}
+
+	var members []member.Member
+	var roleMembers []models.RoleMember
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		due := "expiry_notified_at IS NULL AND activated_at IS NOT NULL AND valid_until > ? AND valid_until <= ?"
+		if err := tx.Model(&members).Clauses(clause.Returning{}).
+			Where(due, now, now.Add(s.notice)).
+			Update("expiry_notified_at", now).Error; err != nil {
+			return fmt.Errorf("failed to claim expiring members: %w", err)
+		}
+		if err := tx.Model(&roleMembers).Clauses(clause.Returning{}).
+			Where(due, now, now.Add(s.notice)).
+			Update("expiry_notified_at", now).Error; err != nil {
+			return fmt.Errorf("failed to claim expiring role members: %w", err)
+		}
+
+		notifications, err := expiryNotifications(tx, members, roleMembers)
+		if err != nil {
+			return err
+		}
+		return notification.Notify(tx, notifications...)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(members) + len(roleMembers), nil
+}
+
+func expiryNotifications(tx *gorm.DB, members []member.Member, roleMembers []models.RoleMember) ([]notification.Notification, error) {
+	var groupIDs, roleIDs []uuid.UUID
+	for _, m := range members {
+		groupIDs = append(groupIDs, m.GroupID)
+	}
+	for _, m := range roleMembers {
+		roleIDs = append(roleIDs, m.RoleID)
+	}
+	groupNames, err := names(tx, "groups", groupIDs)
+	if err != nil {
+		return nil, err
+	}
+	roleNames, err := names(tx, "roles", roleIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var notifications []notification.Notification
+	for _, m := range members {
+		notifications = append(notifications, expiring(m.TenantID, m.UserID, "group", m.GroupID, m.ValidUntil,
+			fmt.Sprintf("Your membership of group %s expires on %s", groupNames[m.GroupID], m.ValidUntil.Format(time.RFC1123))))
+	}
+	for _, m := range roleMembers {
+		message := fmt.Sprintf("Your assignment to role %s expires on %s", roleNames[m.RoleID], m.ValidUntil.Format(time.RFC1123))
+		var users []uuid.UUID
+		switch strings.ToUpper(m.Type) {
+		case "USER":
+			users = []uuid.UUID{m.EntityID}
+		case "GROUP":
+			if err := tx.Table("members").
+				Where("group_id = ? AND "+models.InEffectSQL("members"), m.EntityID).
+				Pluck("user_id", &users).Error; err != nil {
+				return nil, fmt.Errorf("failed to get group members: %w", err)
+			}
+		}
+		for _, userID := range users {
+			notifications = append(notifications, expiring(m.TenantID, userID, "role", m.RoleID, m.ValidUntil, message))
+		}
+	}
+	return notifications, nil
+}
+
+func expiring(tenantID, userID uuid.UUID, resourceType string, resourceID uuid.UUID, expiresAt *time.Time, message string) notification.Notification {
+	n := notification.Notification{
+		UserID:       userID,
+		Type:         notification.TypeAssignmentExpiring,
+		Message:      message,
+		ResourceType: resourceType,
+		ResourceID:   &resourceID,
+		ExpiresAt:    expiresAt,
+		CreatedAt:    time.Now(),
+	}
+	n.TenantID = tenantID
+	return n
+}
+
+// names maps the IDs to the names of the rows of table
+func names(tx *gorm.DB, table string, ids []uuid.UUID) (map[uuid.UUID]string, error) {
+	named := make(map[uuid.UUID]string)
+	if len(ids) == 0 {
+		return named, nil
+	}
+	var rows []struct {
+		ID   uuid.UUID
+		Name string
+	}
+	if err := tx.Table(table).Select("id, name").Where("id IN ?", ids).Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get %s names: %w", table, err)
+	}
+	for _, row := range rows {
+		named[row.ID] = row.Name
+	}
+	return named, nil
+}
+
+func tuples(members []member.Member, roleMembers []models.RoleMember) []authz.Tuple {
+	tuples := authz.RoleMemberTuples(roleMembers)
+	for _, m := range members {
+		tuples = append(tuples, authz.GroupMemberTuple(m.GroupID, m.UserID))
+	}
+	return tuples
+}
+
+// publish announces the member.<change> and role_member.<change> events of
+// the grants
+func publish(change string, members []member.Member, roleMembers []models.RoleMember) {
+	for _, m := range members {
+		events.Publish(events.Event{
+			Type:    "member." + change,
+			Subject: m.GroupID.String(),
+			Data:    m.Validity.EventData(map[string]interface{}{"userId": m.UserID.String()}),
+		})
+	}
+	for _, m := range roleMembers {
+		events.Publish(events.Event{
+			Type:    "role_member." + change,
+			Subject: m.RoleID.String(),
+			Data:    m.Validity.EventData(map[string]interface{}{"entityId": m.EntityID.String(), "type": m.Type}),
+		})
+	}
+}
+
+// RunScheduler processes due assignments every interval until ctx is done
+func (s *AssignmentService) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			processed, err := s.ProcessDue()
+			if err != nil {
+				s.logger.Errorf("Assignment scheduler run failed: %v", err)
+			} else if processed > 0 {
+				s.logger.Infof("Assignment scheduler processed %d assignments", processed)
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package assignment
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/notification"
+	"idmapp-go/models"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "assignment.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&authz.TupleChange{}))
+	validity := "valid_from DATETIME, valid_until DATETIME, activated_at DATETIME, expiry_notified_at DATETIME"
+	for _, ddl := range []string{
+		"CREATE TABLE groups (id TEXT PRIMARY KEY, name TEXT)",
+		"CREATE TABLE roles (id TEXT PRIMARY KEY, name TEXT)",
+		"CREATE TABLE members (id TEXT PRIMARY KEY, tenant_id TEXT, group_id TEXT, user_id TEXT, created_at DATETIME, updated_at DATETIME, " + validity + ")",
+		"CREATE TABLE role_members (id TEXT PRIMARY KEY, tenant_id TEXT, role_id TEXT, entity_id TEXT, type TEXT, created_at DATETIME, " + validity + ")",
+		"CREATE TABLE notifications (id TEXT PRIMARY KEY, tenant_id TEXT, user_id TEXT, type TEXT, message TEXT, resource_type TEXT, resource_id TEXT, expires_at DATETIME, read_at DATETIME, created_at DATETIME)",
+	} {
+		require.NoError(t, db.Exec(ddl).Error)
+	}
+	return db
+}
+
+func outbox(t *testing.T, db *gorm.DB) []authz.TupleChange {
+	var changes []authz.TupleChange
+	require.NoError(t, db.Order("seq").Find(&changes).Error)
+	require.NoError(t, db.Where("1 = 1").Delete(&authz.TupleChange{}).Error)
+	return changes
+}
+
+func TestProcessDue(t *testing.T) {
+	db := newTestDB(t)
+	service := NewAssignmentService(db, 72*time.Hour)
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		v := now.Add(d)
+		return &v
+	}
+
+	staff, auditor := uuid.New(), uuid.New()
+	require.NoError(t, db.Exec("INSERT INTO groups (id, name) VALUES (?, ?)", staff, "staff").Error)
+	require.NoError(t, db.Exec("INSERT INTO roles (id, name) VALUES (?, ?)", auditor, "auditor").Error)
+	jane, john, joe := uuid.New(), uuid.New(), uuid.New()
+
+	// jane's membership has started but has no tuple yet, john's ends
+	// within the notice period and joe's has ended
+	started := member.Member{GroupID: staff, UserID: jane, Validity: models.Validity{ValidFrom: at(-time.Minute)}}
+	ending := member.Member{GroupID: staff, UserID: john, Validity: models.Validity{ValidUntil: at(time.Hour), ActivatedAt: at(-time.Hour)}}
+	ended := member.Member{GroupID: uuid.New(), UserID: joe, Validity: models.Validity{ValidUntil: at(-time.Minute), ActivatedAt: at(-time.Hour)}}
+	// The staff group's auditor role ends soon too, and joe's starts later
+	roleEnding := models.RoleMember{RoleID: auditor, EntityID: staff, Type: "GROUP", Validity: models.Validity{ValidUntil: at(time.Hour), ActivatedAt: at(-time.Hour)}}
+	roleLater := models.RoleMember{RoleID: auditor, EntityID: joe, Type: "USER", Validity: models.Validity{ValidFrom: at(time.Hour)}}
+	for _, row := range []interface{}{&started, &ending, &ended, &roleEnding, &roleLater} {
+		require.NoError(t, db.Create(row).Error)
+	}
+
+	processed, err := service.ProcessDue()
+	require.NoError(t, err)
+	assert.Equal(t, 4, processed)
+
+	changes := outbox(t, db)
+	require.Len(t, changes, 2)
+	assert.Equal(t, authz.OpWrite, changes[0].Op)
+	assert.Equal(t, authz.GroupMemberTuple(staff, jane).User, changes[0].User)
+	assert.Equal(t, authz.OpDelete, changes[1].Op)
+	assert.Equal(t, authz.GroupMemberTuple(ended.GroupID, joe).Object, changes[1].Object)
+
+	var remaining []member.Member
+	require.NoError(t, db.Order("user_id").Find(&remaining).Error)
+	assert.Len(t, remaining, 2)
+	var activated member.Member
+	require.NoError(t, db.First(&activated, "id = ?", started.ID).Error)
+	assert.NotNil(t, activated.ActivatedAt)
+
+	// john is told about his membership and, as a member of staff, about
+	// the role; jane only about the role
+	var notifications []notification.Notification
+	require.NoError(t, db.Find(&notifications).Error)
+	require.Len(t, notifications, 3)
+	byUser := make(map[uuid.UUID][]string)
+	for _, n := range notifications {
+		byUser[n.UserID] = append(byUser[n.UserID], n.ResourceType)
+		assert.Equal(t, notification.TypeAssignmentExpiring, n.Type)
+	}
+	assert.ElementsMatch(t, []string{"group", "role"}, byUser[john])
+	assert.Equal(t, []string{"role"}, byUser[jane])
+
+	// Nothing is done twice
+	processed, err = service.ProcessDue()
+	require.NoError(t, err)
+	assert.Zero(t, processed)
+	assert.Empty(t, outbox(t, db))
+}
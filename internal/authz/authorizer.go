@@ -0,0 +1,104 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+)
+
+// Authorizer answers relationship queries. Users and objects are written
+// "<type>:<id>", e.g. "user:<id>" and "group:<id>"; user sets such as
+// "group:<id>#member" are accepted as users.
+type Authorizer interface {
+	// Check reports whether user has relation to object
+	Check(ctx context.Context, user, relation, object string) (bool, error)
+	// ListObjects returns the objects of objectType that user has relation
+	// to, sorted
+	ListObjects(ctx context.Context, user, relation, objectType string) ([]string, error)
+	// ListUsers returns the users of userType that have relation to object,
+	// sorted. User sets and wildcards aren't returned.
+	ListUsers(ctx context.Context, object, relation, userType string) ([]string, error)
+}
+
+// relationRule defines a relation computed from others, as the model does:
+// relations of the object itself, relations on SystemObject that reach the
+// object through its system link, and, with fromParent, the same relation on
+// the object's parent ("<relation> from parent")
+type relationRule struct {
+	computed   []string
+	fromSystem []string
+	fromParent bool
+}
+
+// directRelations are the relations stored as tuples, by object type, with
+// the user types they take
+var directRelations = map[string]map[string][]string{
+	"user":   {RelationSystem: {"system"}},
+	"system": {"admin": {"user", "group#member", "role#assignee"}, "viewer": {"user", "group#member", "role#assignee"}},
+	"group":  {RelationSystem: {"system"}, "owner": {"user"}, RelationMember: {"user", "group#member"}},
+	"role":   {RelationSystem: {"system"}, RelationAssignee: {"user", "group#member", "org#member"}},
+	"org": {
+		RelationSystem:        {"system"},
+		RelationParent:        {"org"},
+		"admin":               {"user"},
+		RelationCascadeMember: {"user", "group#member", "role#assignee"},
+		RelationMember:        {"user", "group#member", "role#assignee"},
+	},
+}
+
+// computedRelations mirror the computed relations of the current model.
+// Keep them in step with model/v<N>.fga; the conformance tests compare the
+// engines.
+var computedRelations = map[string]map[string]relationRule{
+	"user":   {"manage": {fromSystem: []string{"manage"}}, "read": {computed: []string{"manage"}, fromSystem: []string{"read"}}},
+	"system": {"manage": {computed: []string{"admin"}}, "read": {computed: []string{"viewer", "manage"}}},
+	"group":  {"manage": {computed: []string{"owner"}, fromSystem: []string{"manage"}}, "read": {computed: []string{RelationMember, "manage"}, fromSystem: []string{"read"}}},
+	"role":   {"manage": {fromSystem: []string{"manage"}}, "read": {computed: []string{RelationAssignee, "manage"}, fromSystem: []string{"read"}}},
+	"org":    {"manage": {computed: []string{"admin"}, fromSystem: []string{"manage"}, fromParent: true}, "read": {computed: []string{RelationMember, "manage"}, fromSystem: []string{"read"}}},
+}
+
+// expandRelation resolves a relation of an object type to the direct
+// relations that grant it: on the object itself (local), on the object or any
+// of its ancestors (inherited), and on SystemObject for objects linked to it.
+// Direct relations inherited through the parent, such as cascade_member, are
+// edges of the membership graph instead.
+func expandRelation(objectType, relation string) (local, inherited, system []string, err error) {
+	if _, ok := directRelations[objectType][relation]; ok {
+		return []string{relation}, nil, nil, nil
+	}
+	rule, ok := computedRelations[objectType][relation]
+	if !ok {
+		return nil, nil, nil, fmt.Errorf("unknown relation %q on type %q", relation, objectType)
+	}
+	seenLocal, seenInherited, seenSystem := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	add := func(seen map[string]bool, list *[]string, relations []string) {
+		for _, r := range relations {
+			if !seen[r] {
+				seen[r] = true
+				*list = append(*list, r)
+			}
+		}
+	}
+	for _, computed := range rule.computed {
+		l, i, s, err := expandRelation(objectType, computed)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		add(seenLocal, &local, l)
+		add(seenInherited, &inherited, i)
+		add(seenSystem, &system, s)
+	}
+	for _, fromSystem := range rule.fromSystem {
+		l, _, _, err := expandRelation("system", fromSystem)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		add(seenSystem, &system, l)
+	}
+	// Whatever grants the relation on the parent grants it here, so the
+	// parent's own parent too
+	if rule.fromParent {
+		add(seenInherited, &inherited, local)
+		local = nil
+	}
+	return local, inherited, system, nil
+}
@@ -0,0 +1,359 @@
+package authz_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"idmapp-go/internal/authz"
+	"idmapp-go/models"
+	"idmapp-go/services"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	httpmiddleware "github.com/openfga/openfga/pkg/middleware/http"
+	"github.com/openfga/openfga/pkg/server"
+	serverErrors "github.com/openfga/openfga/pkg/server/errors"
+	"github.com/openfga/openfga/pkg/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// engine is what the conformance suite needs of an authorization engine
+type engine interface {
+	authz.Authorizer
+	authz.TupleStore
+}
+
+// world is the fixture every engine is loaded with
+type world struct {
+	users, groups, roles, orgs map[string]uuid.UUID
+	members                    [][2]string // group, user
+	nestedGroups               [][2]string // group, member group
+	orgParents                 [][2]string // parent org, org
+	orgMembers                 []models.OrgMember
+	roleMembers                []models.RoleMember
+	grants                     []authz.Tuple
+}
+
+func newWorld() *world {
+	ids := func(names ...string) map[string]uuid.UUID {
+		result := make(map[string]uuid.UUID, len(names))
+		for _, name := range names {
+			result[name] = uuid.New()
+		}
+		return result
+	}
+	w := &world{
+		users:  ids("alice", "victor", "olivia", "jane", "john", "dave", "oscar", "mallory", "carl", "paula"),
+		groups: ids("staff", "ops", "contractors"),
+		roles:  ids("admin", "auditor", "billing"),
+		orgs:   ids("acme", "beta", "acme-eu"),
+		members: [][2]string{
+			{"staff", "jane"},
+			{"ops", "john"},
+			{"contractors", "carl"},
+		},
+		nestedGroups: [][2]string{
+			{"ops", "contractors"},
+		},
+		orgParents: [][2]string{
+			{"acme", "acme-eu"},
+		},
+	}
+	w.orgMembers = []models.OrgMember{
+		{OrgID: w.orgs["acme"], EntityID: w.groups["ops"], Type: "GROUP"},
+		{OrgID: w.orgs["acme"], EntityID: w.roles["auditor"], Type: "ROLE"},
+		{OrgID: w.orgs["acme"], EntityID: w.users["dave"], Type: "USER"},
+		{OrgID: w.orgs["acme"], EntityID: w.users["paula"], Type: "USER", Cascade: true},
+	}
+	w.roleMembers = []models.RoleMember{
+		{RoleID: w.roles["admin"], EntityID: w.groups["staff"], Type: "GROUP"},
+		{RoleID: w.roles["auditor"], EntityID: w.users["john"], Type: "USER"},
+		{RoleID: w.roles["billing"], EntityID: w.orgs["acme"], Type: "ORG"},
+	}
+	w.grants = []authz.Tuple{
+		{User: w.user("alice"), Relation: "admin", Object: authz.SystemObject},
+		{User: w.user("victor"), Relation: "viewer", Object: authz.SystemObject},
+		{User: "role:" + w.roles["admin"].String() + "#assignee", Relation: "admin", Object: authz.SystemObject},
+		{User: w.user("olivia"), Relation: "owner", Object: w.group("ops")},
+		{User: w.user("oscar"), Relation: "admin", Object: w.org("acme")},
+	}
+	return w
+}
+
+func (w *world) user(name string) string  { return "user:" + w.users[name].String() }
+func (w *world) group(name string) string { return "group:" + w.groups[name].String() }
+func (w *world) role(name string) string  { return "role:" + w.roles[name].String() }
+func (w *world) org(name string) string   { return "org:" + w.orgs[name].String() }
+
+// sorted maps names with the given function and sorts the result
+func sorted(name func(string) string, names ...string) []string {
+	result := make([]string, len(names))
+	for i, n := range names {
+		result[i] = name(n)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// newOpenFGAEngine serves an in-memory OpenFGA server over HTTP and connects
+// AuthorizationService to it, with the world written as tuples the way the
+// outbox would
+func newOpenFGAEngine(t *testing.T, w *world) engine {
+	datastore := memory.New()
+	t.Cleanup(datastore.Close)
+	fga := server.MustNewServerWithOpts(server.WithDatastore(datastore))
+	t.Cleanup(fga.Close)
+	// Errors are encoded like the OpenFGA server does, so refused writes are
+	// validation errors rather than internal ones
+	mux := runtime.NewServeMux(runtime.WithErrorHandler(
+		func(ctx context.Context, _ *runtime.ServeMux, _ runtime.Marshaler, rw http.ResponseWriter, r *http.Request, err error) {
+			code := serverErrors.ConvertToEncodedErrorCode(status.Convert(err))
+			httpmiddleware.CustomHTTPErrorHandler(ctx, rw, r, serverErrors.NewEncodedError(code, err.Error()))
+		}))
+	require.NoError(t, openfgav1.RegisterOpenFGAServiceHandlerServer(context.Background(), mux, fga))
+	httpServer := httptest.NewServer(mux)
+	t.Cleanup(httpServer.Close)
+
+	ctx := context.Background()
+	service, err := services.NewAuthorizationService(httpServer.URL, "", "")
+	require.NoError(t, err)
+	storeID, err := service.CreateStore(ctx, "idmapp-test")
+	require.NoError(t, err)
+	require.NoError(t, service.UseStore(storeID, ""))
+	data, err := authz.LoadModel(authz.CurrentModelVersion())
+	require.NoError(t, err)
+	modelID, err := service.WriteModel(ctx, data)
+	require.NoError(t, err)
+	require.NoError(t, service.UseStore(storeID, modelID))
+
+	var tuples []authz.Tuple
+	for _, m := range w.members {
+		tuples = append(tuples, authz.GroupMemberTuple(w.groups[m[0]], w.users[m[1]]))
+	}
+	for _, n := range w.nestedGroups {
+		tuples = append(tuples, authz.NestedGroupTuple(w.groups[n[0]], w.groups[n[1]]))
+	}
+	for _, p := range w.orgParents {
+		tuples = append(tuples, authz.OrgParentTuple(w.orgs[p[1]], w.orgs[p[0]]))
+	}
+	tuples = append(tuples, authz.OrgMemberTuples(w.orgMembers)...)
+	tuples = append(tuples, authz.RoleMemberTuples(w.roleMembers)...)
+	for objectType, ids := range map[string]map[string]uuid.UUID{"user": w.users, "group": w.groups, "role": w.roles, "org": w.orgs} {
+		for _, id := range ids {
+			tuples = append(tuples, authz.SystemLink(objectType, id))
+		}
+	}
+	tuples = append(tuples, w.grants...)
+	for _, tuple := range tuples {
+		require.NoError(t, service.GrantPermission(tuple.User, tuple.Relation, tuple.Object))
+	}
+	return service
+}
+
+// newNativeEngine loads the world into the membership tables of an SQLite
+// database
+func newNativeEngine(t *testing.T, w *world) engine {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "authz.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	for _, ddl := range []string{
+		"CREATE TABLE users (id TEXT PRIMARY KEY)",
+		"CREATE TABLE groups (id TEXT PRIMARY KEY)",
+		"CREATE TABLE roles (id TEXT PRIMARY KEY)",
+		"CREATE TABLE orgs (id TEXT PRIMARY KEY, parent_id TEXT)",
+		"CREATE TABLE members (group_id TEXT, user_id TEXT, valid_from DATETIME, valid_until DATETIME)",
+		"CREATE TABLE nested_groups (group_id TEXT, member_group_id TEXT)",
+		"CREATE TABLE org_members (org_id TEXT, entity_id TEXT, type TEXT, \"cascade\" BOOLEAN NOT NULL DEFAULT FALSE)",
+		"CREATE TABLE role_members (role_id TEXT, entity_id TEXT, type TEXT, valid_from DATETIME, valid_until DATETIME)",
+	} {
+		require.NoError(t, db.Exec(ddl).Error)
+	}
+	require.NoError(t, db.AutoMigrate(&authz.StoredTuple{}))
+
+	for table, ids := range map[string]map[string]uuid.UUID{"users": w.users, "groups": w.groups, "roles": w.roles, "orgs": w.orgs} {
+		for _, id := range ids {
+			require.NoError(t, db.Exec("INSERT INTO "+table+" (id) VALUES (?)", id).Error)
+		}
+	}
+	for _, m := range w.members {
+		require.NoError(t, db.Exec("INSERT INTO members (group_id, user_id) VALUES (?, ?)", w.groups[m[0]], w.users[m[1]]).Error)
+	}
+	for _, n := range w.nestedGroups {
+		require.NoError(t, db.Exec("INSERT INTO nested_groups (group_id, member_group_id) VALUES (?, ?)", w.groups[n[0]], w.groups[n[1]]).Error)
+	}
+	for _, p := range w.orgParents {
+		require.NoError(t, db.Exec("UPDATE orgs SET parent_id = ? WHERE id = ?", w.orgs[p[0]], w.orgs[p[1]]).Error)
+	}
+	for _, m := range w.orgMembers {
+		require.NoError(t, db.Exec(`INSERT INTO org_members (org_id, entity_id, type, "cascade") VALUES (?, ?, ?, ?)`, m.OrgID, m.EntityID, m.Type, m.Cascade).Error)
+	}
+	for _, m := range w.roleMembers {
+		require.NoError(t, db.Exec("INSERT INTO role_members (role_id, entity_id, type) VALUES (?, ?, ?)", m.RoleID, m.EntityID, m.Type).Error)
+	}
+
+	native := authz.NewNativeEngine(db)
+	for _, grant := range w.grants {
+		require.NoError(t, native.GrantPermission(grant.User, grant.Relation, grant.Object))
+	}
+	// Grants are idempotent
+	require.NoError(t, native.GrantPermission(w.grants[0].User, w.grants[0].Relation, w.grants[0].Object))
+	return native
+}
+
+func TestConformance(t *testing.T) {
+	engines := map[string]func(*testing.T, *world) engine{
+		"openfga": newOpenFGAEngine,
+		"native":  newNativeEngine,
+	}
+	for name, newEngine := range engines {
+		t.Run(name, func(t *testing.T) {
+			w := newWorld()
+			testConformance(t, w, newEngine(t, w))
+		})
+	}
+}
+
+func testConformance(t *testing.T, w *world, e engine) {
+	ctx := context.Background()
+	missing := "group:" + uuid.New().String()
+
+	checks := []struct {
+		user, relation, object string
+		allowed                bool
+	}{
+		// System admins, directly or through the admin role of a group
+		{w.user("alice"), "manage", authz.SystemObject, true},
+		{w.user("alice"), "manage", w.group("ops"), true},
+		{w.user("alice"), "manage", w.user("john"), true},
+		{w.user("jane"), "manage", w.org("beta"), true},
+		{w.user("jane"), "read", w.role("auditor"), true},
+		// Objects that don't exist have no system link
+		{w.user("alice"), "manage", missing, false},
+		// Viewers read everything but manage nothing
+		{w.user("victor"), "read", w.user("jane"), true},
+		{w.user("victor"), "read", w.org("acme"), true},
+		{w.user("victor"), "manage", w.group("staff"), false},
+		// Owners manage their group only
+		{w.user("olivia"), "manage", w.group("ops"), true},
+		{w.user("olivia"), "manage", w.group("staff"), false},
+		{w.user("olivia"), "read", authz.SystemObject, false},
+		// Members read their group
+		{w.user("john"), "member", w.group("ops"), true},
+		{w.user("john"), "read", w.group("ops"), true},
+		{w.user("john"), "manage", w.group("ops"), false},
+		{w.user("john"), "read", w.group("staff"), false},
+		// Members of nested groups are members of the groups containing them,
+		// and of their orgs and roles
+		{w.user("carl"), "member", w.group("contractors"), true},
+		{w.user("carl"), "member", w.group("ops"), true},
+		{w.user("carl"), "member", w.org("acme"), true},
+		{w.user("carl"), "assignee", w.role("billing"), true},
+		{w.user("john"), "member", w.group("contractors"), false},
+		// Org members directly, through a group or through a role
+		{w.user("dave"), "member", w.org("acme"), true},
+		{w.user("john"), "member", w.org("acme"), true},
+		{w.user("john"), "read", w.org("acme"), true},
+		{w.user("jane"), "member", w.org("acme"), false},
+		{w.user("oscar"), "manage", w.org("acme"), true},
+		{w.user("oscar"), "manage", w.org("beta"), false},
+		// Org admins manage the orgs below, and cascading members are members
+		// of them too
+		{w.org("acme"), "parent", w.org("acme-eu"), true},
+		{w.user("oscar"), "manage", w.org("acme-eu"), true},
+		{w.user("oscar"), "read", w.org("acme-eu"), true},
+		{w.user("paula"), "member", w.org("acme"), true},
+		{w.user("paula"), "member", w.org("acme-eu"), true},
+		{w.user("paula"), "assignee", w.role("billing"), true},
+		{w.user("dave"), "member", w.org("acme-eu"), false},
+		{w.user("paula"), "manage", w.org("acme-eu"), false},
+		// Role assignees directly, through a group or through an org
+		{w.user("john"), "assignee", w.role("auditor"), true},
+		{w.user("jane"), "assignee", w.role("admin"), true},
+		{w.user("john"), "assignee", w.role("admin"), false},
+		{w.user("dave"), "assignee", w.role("billing"), true},
+		{w.user("john"), "assignee", w.role("billing"), true},
+		{w.user("jane"), "assignee", w.role("billing"), false},
+		// User sets are users too
+		{w.group("ops") + "#member", "member", w.org("acme"), true},
+		{w.group("staff") + "#member", "manage", authz.SystemObject, true},
+		// Links themselves
+		{authz.SystemObject, "system", w.group("ops"), true},
+		{authz.SystemObject, "system", missing, false},
+		{w.user("mallory"), "read", authz.SystemObject, false},
+		{w.user("mallory"), "read", w.user("mallory"), false},
+	}
+	for _, c := range checks {
+		allowed, err := e.Check(ctx, c.user, c.relation, c.object)
+		require.NoError(t, err, "%s %s %s", c.user, c.relation, c.object)
+		assert.Equal(t, c.allowed, allowed, "check %s %s %s", c.user, c.relation, c.object)
+	}
+
+	listObjects := []struct {
+		user, relation, objectType string
+		objects                    []string
+	}{
+		{w.user("john"), "read", "group", sorted(w.group, "ops")},
+		{w.user("john"), "member", "org", sorted(w.org, "acme")},
+		{w.user("john"), "read", "role", sorted(w.role, "auditor", "billing")},
+		{w.user("dave"), "assignee", "role", sorted(w.role, "billing")},
+		{w.user("olivia"), "manage", "group", sorted(w.group, "ops")},
+		{w.user("oscar"), "manage", "org", sorted(w.org, "acme", "acme-eu")},
+		{w.user("paula"), "member", "org", sorted(w.org, "acme", "acme-eu")},
+		{w.user("dave"), "member", "org", sorted(w.org, "acme")},
+		{w.user("jane"), "manage", "group", sorted(w.group, "ops", "staff", "contractors")},
+		{w.user("carl"), "member", "group", sorted(w.group, "ops", "contractors")},
+		{w.user("victor"), "read", "org", sorted(w.org, "acme", "beta", "acme-eu")},
+		{w.user("victor"), "manage", "org", []string{}},
+		{w.user("mallory"), "read", "user", []string{}},
+	}
+	for _, c := range listObjects {
+		objects, err := e.ListObjects(ctx, c.user, c.relation, c.objectType)
+		require.NoError(t, err, "%s %s %s", c.user, c.relation, c.objectType)
+		assert.ElementsMatch(t, c.objects, objects, "list objects %s %s %s", c.user, c.relation, c.objectType)
+	}
+
+	listUsers := []struct {
+		object, relation string
+		users            []string
+	}{
+		{w.group("ops"), "member", sorted(w.user, "john", "carl")},
+		{w.group("contractors"), "member", sorted(w.user, "carl")},
+		{w.group("ops"), "read", sorted(w.user, "alice", "jane", "victor", "olivia", "john", "carl")},
+		{w.group("ops"), "manage", sorted(w.user, "alice", "jane", "olivia")},
+		{w.org("acme"), "member", sorted(w.user, "dave", "john", "carl", "paula")},
+		{w.org("acme-eu"), "member", sorted(w.user, "paula")},
+		{w.org("acme-eu"), "manage", sorted(w.user, "alice", "jane", "oscar")},
+		{w.org("beta"), "read", sorted(w.user, "alice", "jane", "victor")},
+		{w.role("auditor"), "assignee", sorted(w.user, "john")},
+		{w.role("billing"), "assignee", sorted(w.user, "dave", "john", "carl", "paula")},
+		{authz.SystemObject, "admin", sorted(w.user, "alice", "jane")},
+		{missing, "read", []string{}},
+	}
+	for _, c := range listUsers {
+		users, err := e.ListUsers(ctx, c.object, c.relation, "user")
+		require.NoError(t, err, "%s %s", c.object, c.relation)
+		assert.ElementsMatch(t, c.users, users, "list users %s %s", c.object, c.relation)
+	}
+
+	// Revoking a grant takes effect, and revoking it again succeeds
+	owner := w.grants[3]
+	require.NoError(t, e.RevokePermission(owner.User, owner.Relation, owner.Object))
+	require.NoError(t, e.RevokePermission(owner.User, owner.Relation, owner.Object))
+	allowed, err := e.Check(ctx, owner.User, "manage", owner.Object)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+
+	_, err = e.Check(ctx, w.user("john"), "fly", w.group("ops"))
+	assert.Error(t, err, "unknown relations are errors")
+}
@@ -0,0 +1,26 @@
+package authz
+
+import "time"
+
+// SyncStatus summarizes the tuple outbox
+type SyncStatus struct {
+	Pending int64        `json:"pending"`
+	Failed  int64        `json:"failed"`
+	Oldest  *TupleChange `json:"oldest,omitempty"`
+}
+
+// DriftReport lists how the authorization store differs from the tuples the
+// membership tables call for
+type DriftReport struct {
+	Desired   int       `json:"desired"`
+	Missing   []Tuple   `json:"missing"`
+	Extra     []Tuple   `json:"extra"`
+	Applied   bool      `json:"applied"`
+	Errors    []string  `json:"errors,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// InSync reports whether no tuples are missing or extra
+func (r *DriftReport) InSync() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0
+}
@@ -0,0 +1,66 @@
+package authz
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type SyncController struct {
+	syncService *SyncService
+	logger      *logrus.Logger
+}
+
+func NewSyncController(syncService *SyncService) *SyncController {
+	return &SyncController{
+		syncService: syncService,
+		logger:      logrus.New(),
+	}
+}
+
+func (c *SyncController) GetStatus(ctx *gin.Context) {
+	status, err := c.syncService.GetStatus()
+	if err != nil {
+		c.logger.Errorf("Failed to get tuple sync status: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tuple sync status"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, status)
+}
+
+func (c *SyncController) GetFailedChanges(ctx *gin.Context) {
+	changes, err := c.syncService.GetFailedChanges()
+	if err != nil {
+		c.logger.Errorf("Failed to get failed tuple changes: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get failed tuple changes"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, changes)
+}
+
+// Resync rebuilds the authorization store from the membership tables. With
+// ?dryRun=true it only reports the drift.
+func (c *SyncController) Resync(ctx *gin.Context) {
+	var report *DriftReport
+	var err error
+	if ctx.Query("dryRun") == "true" {
+		report, err = c.syncService.Drift(ctx.Request.Context())
+	} else {
+		report, err = c.syncService.Resync(ctx.Request.Context())
+	}
+	if err != nil {
+		if errors.Is(err, ErrSyncInProgress) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.logger.Errorf("Failed to resync authorization store: %v", err)
+		ctx.JSON(http.StatusBadGateway, gin.H{"error": "Failed to resync authorization store"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
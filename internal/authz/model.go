@@ -0,0 +1,277 @@
+package authz
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// SystemObject is the authorization object of the service itself. Every
+// user, group, role and org belongs to it through RelationSystem.
+const SystemObject = "system:idmapp"
+
+// RelationSystem links an object to SystemObject, so system admins and
+// viewers may manage or read it
+const RelationSystem = "system"
+
+// modelLockKey is the advisory lock serializing model setup across instances
+const modelLockKey = 0x617574686d
+
+// modelFiles holds the model versions: v<N>.fga is the DSL, v<N>.json the
+// same model in the JSON form the API takes
+//
+//go:embed model
+var modelFiles embed.FS
+
+// ModelVersion is a version of the authorization model. Migrate, if set,
+// rewrites the tuples of the previous version for this one; it runs once the
+// version is written, and only for stores that had the previous version. It
+// must be safe to run again, as a failed setup is retried from the start.
+type ModelVersion struct {
+	Version int
+	Migrate func(ctx context.Context, store TupleStore) error
+}
+
+// modelVersions lists the model versions in order. Add a version for every
+// model change, with a Migrate step when existing tuples must change.
+var modelVersions = []ModelVersion{
+	{Version: 1},
+	// Roles can be assigned to everyone in an org
+	{Version: 2},
+	// Groups can be members of groups
+	{Version: 3},
+	// Orgs have parents; admins and cascade members apply to the orgs below
+	{Version: 4},
+}
+
+// CurrentModelVersion is the model version the service runs
+func CurrentModelVersion() int {
+	return modelVersions[len(modelVersions)-1].Version
+}
+
+// LoadModel returns the JSON of a model version
+func LoadModel(version int) ([]byte, error) {
+	data, err := modelFiles.ReadFile(fmt.Sprintf("model/v%d.json", version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load authorization model v%d: %w", version, err)
+	}
+	return data, nil
+}
+
+// pendingVersions returns the versions after current, in order
+func pendingVersions(versions []ModelVersion, current int) []ModelVersion {
+	var pending []ModelVersion
+	for _, version := range versions {
+		if version.Version > current {
+			pending = append(pending, version)
+		}
+	}
+	return pending
+}
+
+// AuthorizationModel records a model version written to a store
+type AuthorizationModel struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	StoreID   string    `json:"storeId" gorm:"type:varchar(64);not null;uniqueIndex:idx_authz_models_store_version"`
+	Version   int       `json:"version" gorm:"not null;uniqueIndex:idx_authz_models_store_version"`
+	ModelID   string    `json:"modelId" gorm:"type:varchar(64);not null"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (m *AuthorizationModel) BeforeCreate(tx *gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = uuid.New()
+	}
+	return nil
+}
+
+func (m *AuthorizationModel) TableName() string {
+	return "authz_models"
+}
+
+// ModelStore is the authorization store as seen by model setup
+type ModelStore interface {
+	TupleStore
+	// FindStore returns the ID of the store with the name, or "" if there
+	// is none
+	FindStore(ctx context.Context, name string) (string, error)
+	CreateStore(ctx context.Context, name string) (string, error)
+	// UseStore points later calls at the store, and at the model if set
+	// rather than the store's latest
+	UseStore(storeID, modelID string) error
+	WriteModel(ctx context.Context, model []byte) (string, error)
+}
+
+type ModelService struct {
+	db     *gorm.DB
+	store  ModelStore
+	logger *logrus.Logger
+}
+
+func NewModelService(db *gorm.DB, store ModelStore) *ModelService {
+	return &ModelService{
+		db:     db,
+		store:  store,
+		logger: logrus.New(),
+	}
+}
+
+// Ensure sets the store up to run the current model and points the
+// ModelStore at it. The store is storeID, else the one used before, else the
+// one named storeName, which is created if missing. Model versions the store
+// hasn't seen are written and migrated in order.
+func (s *ModelService) Ensure(ctx context.Context, storeID, storeName string) (*AuthorizationModel, error) {
+	var current *AuthorizationModel
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", modelLockKey).Error; err != nil {
+			return fmt.Errorf("failed to lock authorization models: %w", err)
+		}
+
+		var err error
+		if storeID == "" {
+			storeID, err = s.resolveStore(ctx, tx, storeName)
+			if err != nil {
+				return err
+			}
+		}
+
+		current, err = latestModel(tx, storeID)
+		if err != nil {
+			return err
+		}
+		if err := s.store.UseStore(storeID, ""); err != nil {
+			return err
+		}
+
+		version := 0
+		if current != nil {
+			version = current.Version
+		}
+		for _, next := range pendingVersions(modelVersions, version) {
+			if current, err = s.upgrade(ctx, tx, storeID, version, next); err != nil {
+				return err
+			}
+			version = next.Version
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// After a rollback, keep to the version this build was written for
+	if current.Version > CurrentModelVersion() {
+		s.logger.Warnf("Authorization store %s has model v%d, newer than v%d of this build", current.StoreID, current.Version, CurrentModelVersion())
+		var own AuthorizationModel
+		result := s.db.Where("store_id = ? AND version = ?", current.StoreID, CurrentModelVersion()).Limit(1).Find(&own)
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to get authorization model: %w", result.Error)
+		}
+		if result.RowsAffected > 0 {
+			current = &own
+		}
+	}
+
+	if err := s.store.UseStore(current.StoreID, current.ModelID); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+// resolveStore finds the store used before or by name, creating it if needed
+func (s *ModelService) resolveStore(ctx context.Context, tx *gorm.DB, name string) (string, error) {
+	var recorded AuthorizationModel
+	result := tx.Order("created_at DESC").Limit(1).Find(&recorded)
+	if result.Error != nil {
+		return "", fmt.Errorf("failed to get authorization store: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return recorded.StoreID, nil
+	}
+
+	storeID, err := s.store.FindStore(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to find authorization store: %w", err)
+	}
+	if storeID != "" {
+		return storeID, nil
+	}
+	storeID, err = s.store.CreateStore(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to create authorization store: %w", err)
+	}
+	s.logger.Infof("Created authorization store %s (%s)", name, storeID)
+	return storeID, nil
+}
+
+// upgrade writes a model version, migrates the tuples of the previous one
+// and records it
+func (s *ModelService) upgrade(ctx context.Context, tx *gorm.DB, storeID string, previous int, version ModelVersion) (*AuthorizationModel, error) {
+	data, err := LoadModel(version.Version)
+	if err != nil {
+		return nil, err
+	}
+	modelID, err := s.store.WriteModel(ctx, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write authorization model v%d: %w", version.Version, err)
+	}
+	if err := s.store.UseStore(storeID, modelID); err != nil {
+		return nil, err
+	}
+
+	if version.Migrate != nil && previous > 0 {
+		if err := version.Migrate(ctx, s.store); err != nil {
+			return nil, fmt.Errorf("failed to migrate tuples to authorization model v%d: %w", version.Version, err)
+		}
+	}
+
+	model := &AuthorizationModel{StoreID: storeID, Version: version.Version, ModelID: modelID}
+	if err := tx.Create(model).Error; err != nil {
+		return nil, fmt.Errorf("failed to record authorization model: %w", err)
+	}
+	s.logger.Infof("Wrote authorization model v%d (%s) to store %s", version.Version, modelID, storeID)
+	return model, nil
+}
+
+// GrantAdminRole makes the assignees of the named role system admins, so
+// there are admins from the start. A missing role is skipped.
+func GrantAdminRole(db *gorm.DB, store TupleStore, roleName string) error {
+	if roleName == "" {
+		return nil
+	}
+	var roleIDs []uuid.UUID
+	if err := db.Table("roles").Where("name = ?", roleName).Pluck("id", &roleIDs).Error; err != nil {
+		return fmt.Errorf("failed to get admin role: %w", err)
+	}
+	if len(roleIDs) == 0 {
+		logrus.Warnf("Admin role %q not found; no one administers the service until a system admin tuple is written", roleName)
+		return nil
+	}
+	for _, roleID := range roleIDs {
+		if err := store.GrantPermission("role:"+roleID.String()+"#"+RelationAssignee, "admin", SystemObject); err != nil {
+			return fmt.Errorf("failed to grant admin role: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetCurrentModel returns the latest model recorded for the store, or nil
+func (s *ModelService) GetCurrentModel(storeID string) (*AuthorizationModel, error) {
+	return latestModel(s.db, storeID)
+}
+
+func latestModel(db *gorm.DB, storeID string) (*AuthorizationModel, error) {
+	var model AuthorizationModel
+	result := db.Where("store_id = ?", storeID).Order("version DESC").Limit(1).Find(&model)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get authorization model: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &model, nil
+}
@@ -0,0 +1,214 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	openfgav1 "github.com/openfga/api/proto/openfga/v1"
+	"github.com/openfga/language/pkg/go/transformer"
+	"github.com/openfga/openfga/pkg/server"
+	"github.com/openfga/openfga/pkg/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// testStore is a store of the in-memory OpenFGA server running a model version
+type testStore struct {
+	t       *testing.T
+	server  *server.Server
+	storeID string
+	modelID string
+}
+
+func newTestStore(t *testing.T, version int) *testStore {
+	t.Helper()
+	datastore := memory.New()
+	t.Cleanup(datastore.Close)
+	fga := server.MustNewServerWithOpts(server.WithDatastore(datastore))
+	t.Cleanup(fga.Close)
+
+	ctx := context.Background()
+	store, err := fga.CreateStore(ctx, &openfgav1.CreateStoreRequest{Name: "idmapp-test"})
+	require.NoError(t, err)
+
+	data, err := LoadModel(version)
+	require.NoError(t, err)
+	var request openfgav1.WriteAuthorizationModelRequest
+	require.NoError(t, protojson.Unmarshal(data, &request))
+	request.StoreId = store.GetId()
+	model, err := fga.WriteAuthorizationModel(ctx, &request)
+	require.NoError(t, err)
+
+	return &testStore{t: t, server: fga, storeID: store.GetId(), modelID: model.GetAuthorizationModelId()}
+}
+
+func (s *testStore) write(tuples ...Tuple) {
+	s.t.Helper()
+	keys := make([]*openfgav1.TupleKey, len(tuples))
+	for i, t := range tuples {
+		keys[i] = &openfgav1.TupleKey{User: t.User, Relation: t.Relation, Object: t.Object}
+	}
+	_, err := s.server.Write(context.Background(), &openfgav1.WriteRequest{
+		StoreId:              s.storeID,
+		AuthorizationModelId: s.modelID,
+		Writes:               &openfgav1.WriteRequestWrites{TupleKeys: keys},
+	})
+	require.NoError(s.t, err)
+}
+
+func (s *testStore) check(user, relation, object string) bool {
+	s.t.Helper()
+	resp, err := s.server.Check(context.Background(), &openfgav1.CheckRequest{
+		StoreId:              s.storeID,
+		AuthorizationModelId: s.modelID,
+		TupleKey:             &openfgav1.CheckRequestTupleKey{User: user, Relation: relation, Object: object},
+	})
+	require.NoError(s.t, err)
+	return resp.GetAllowed()
+}
+
+func TestModelFilesMatch(t *testing.T) {
+	for i, version := range modelVersions {
+		require.Equal(t, i+1, version.Version, "versions are numbered from 1 without gaps")
+
+		dsl, err := modelFiles.ReadFile(fmt.Sprintf("model/v%d.fga", version.Version))
+		require.NoError(t, err)
+		fromDSL, err := transformer.TransformDSLToJSON(string(dsl))
+		require.NoError(t, err, "v%d.fga", version.Version)
+		checkedIn, err := LoadModel(version.Version)
+		require.NoError(t, err)
+
+		var want, got interface{}
+		require.NoError(t, json.Unmarshal([]byte(fromDSL), &want))
+		require.NoError(t, json.Unmarshal(checkedIn, &got))
+		assert.Equal(t, want, got, "v%d.json is out of date with v%d.fga", version.Version, version.Version)
+	}
+}
+
+func TestModel(t *testing.T) {
+	store := newTestStore(t, CurrentModelVersion())
+
+	admin, viewer, owner, jane, john, outsider := "user:admin", "user:viewer", "user:owner", uuid.New(), uuid.New(), "user:outsider"
+	staff, ops := uuid.New(), uuid.New()
+	auditor, acme := uuid.New(), uuid.New()
+	group := func(id uuid.UUID) string { return "group:" + id.String() }
+	user := func(id uuid.UUID) string { return "user:" + id.String() }
+
+	orgByGroup, err := OrgMemberTuple(models.OrgMember{OrgID: acme, EntityID: ops, Type: "GROUP"})
+	require.NoError(t, err)
+	orgByRole, err := OrgMemberTuple(models.OrgMember{OrgID: acme, EntityID: auditor, Type: "ROLE"})
+	require.NoError(t, err)
+	roleByUser, err := RoleMemberTuple(models.RoleMember{RoleID: auditor, EntityID: john, Type: "USER"})
+	require.NoError(t, err)
+	adminRole := uuid.New()
+	adminRoleMember, err := RoleMemberTuple(models.RoleMember{RoleID: adminRole, EntityID: staff, Type: "GROUP"})
+	require.NoError(t, err)
+
+	store.write(
+		Tuple{User: admin, Relation: "admin", Object: SystemObject},
+		Tuple{User: viewer, Relation: "viewer", Object: SystemObject},
+		// Like GrantAdminRole
+		Tuple{User: "role:" + adminRole.String() + "#assignee", Relation: "admin", Object: SystemObject},
+		Tuple{User: owner, Relation: "owner", Object: group(ops)},
+		GroupMemberTuple(staff, jane),
+		GroupMemberTuple(ops, john),
+		orgByGroup, orgByRole, roleByUser, adminRoleMember,
+		SystemLink("group", staff), SystemLink("group", ops), SystemLink("org", acme),
+		SystemLink("role", auditor), SystemLink("role", adminRole),
+		SystemLink("user", jane), SystemLink("user", john),
+	)
+
+	cases := []struct {
+		user, relation, object string
+		allowed                bool
+	}{
+		// System admins manage and read everything
+		{admin, "manage", SystemObject, true},
+		{admin, "manage", group(ops), true},
+		{admin, "manage", "org:" + acme.String(), true},
+		{admin, "read", "role:" + auditor.String(), true},
+		{admin, "manage", user(jane), true},
+		// Objects without a system link are out of reach
+		{admin, "manage", group(uuid.New()), false},
+		// Viewers read everything but manage nothing
+		{viewer, "read", SystemObject, true},
+		{viewer, "read", user(jane), true},
+		{viewer, "manage", SystemObject, false},
+		{viewer, "manage", group(ops), false},
+		// Admins through the admin role, assigned to a group
+		{user(jane), "manage", SystemObject, true},
+		{user(jane), "manage", "org:" + acme.String(), true},
+		// Owners manage their group only
+		{owner, "manage", group(ops), true},
+		{owner, "read", group(ops), true},
+		{owner, "manage", group(staff), false},
+		{owner, "read", SystemObject, false},
+		// Members read their group
+		{user(john), "read", group(ops), true},
+		{user(john), "manage", group(ops), false},
+		{user(john), "read", group(staff), false},
+		// Org members through a group or a role
+		{user(john), "member", "org:" + acme.String(), true},
+		{user(john), "read", "org:" + acme.String(), true},
+		{user(john), "manage", "org:" + acme.String(), false},
+		{user(john), "assignee", "role:" + auditor.String(), true},
+		{user(john), "read", "role:" + auditor.String(), true},
+		// Users can't read themselves through the model; /me routes cover that
+		{user(john), "read", user(john), false},
+		{outsider, "read", SystemObject, false},
+		{outsider, "read", group(ops), false},
+		{outsider, "member", "org:" + acme.String(), false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.allowed, store.check(c.user, c.relation, c.object), "%s %s %s", c.user, c.relation, c.object)
+	}
+}
+
+func TestModelAcceptsMembershipTuples(t *testing.T) {
+	store := newTestStore(t, CurrentModelVersion())
+
+	// Every tuple the membership tables map to is valid in the model
+	var tuples []Tuple
+	tuples = append(tuples, GroupMemberTuple(uuid.New(), uuid.New()), NestedGroupTuple(uuid.New(), uuid.New()))
+	for _, memberType := range []string{"USER", "GROUP", "ROLE"} {
+		tuples = append(tuples, OrgMemberTuples([]models.OrgMember{{OrgID: uuid.New(), EntityID: uuid.New(), Type: memberType}})...)
+		tuples = append(tuples, OrgMemberTuples([]models.OrgMember{{OrgID: uuid.New(), EntityID: uuid.New(), Type: memberType, Cascade: true}})...)
+	}
+	tuples = append(tuples, OrgParentTuple(uuid.New(), uuid.New()))
+	for _, memberType := range []string{"USER", "GROUP", "ORG"} {
+		tuples = append(tuples, RoleMemberTuples([]models.RoleMember{{RoleID: uuid.New(), EntityID: uuid.New(), Type: memberType}})...)
+	}
+	require.Len(t, tuples, 12)
+	store.write(tuples...)
+}
+
+func TestPendingVersions(t *testing.T) {
+	versions := []ModelVersion{{Version: 1}, {Version: 2}, {Version: 3}}
+	numbers := func(versions []ModelVersion) []int {
+		var result []int
+		for _, version := range versions {
+			result = append(result, version.Version)
+		}
+		return result
+	}
+
+	assert.Equal(t, []int{1, 2, 3}, numbers(pendingVersions(versions, 0)))
+	assert.Equal(t, []int{3}, numbers(pendingVersions(versions, 2)))
+	assert.Empty(t, pendingVersions(versions, 3))
+	// A store on a newer version than the build knows needs nothing
+	assert.Empty(t, pendingVersions(versions, 4))
+}
+
+func TestSystemLink(t *testing.T) {
+	id := uuid.New()
+	link := SystemLink("group", id)
+	assert.Equal(t, Tuple{User: SystemObject, Relation: "system", Object: "group:" + id.String()}, link)
+	assert.True(t, managed(link))
+	assert.False(t, managed(Tuple{User: "user:u1", Relation: "system", Object: "group:" + id.String()}))
+}
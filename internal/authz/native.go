@@ -0,0 +1,534 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StoredTuple is a tuple of the native engine that no membership table
+// holds, such as system admins and group owners
+type StoredTuple struct {
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	UserType     string    `json:"userType" gorm:"type:varchar(64);not null;uniqueIndex:idx_authz_tuples_key"`
+	UserID       string    `json:"userId" gorm:"type:varchar(255);not null;uniqueIndex:idx_authz_tuples_key"`
+	UserRelation string    `json:"userRelation" gorm:"type:varchar(64);not null;uniqueIndex:idx_authz_tuples_key"`
+	Relation     string    `json:"relation" gorm:"type:varchar(64);not null;uniqueIndex:idx_authz_tuples_key"`
+	ObjectType   string    `json:"objectType" gorm:"type:varchar(64);not null;uniqueIndex:idx_authz_tuples_key"`
+	ObjectID     string    `json:"objectId" gorm:"type:varchar(255);not null;uniqueIndex:idx_authz_tuples_key"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func (t *StoredTuple) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+func (t *StoredTuple) TableName() string {
+	return "authz_tuples"
+}
+
+// edgesSQL maps the membership tables and the stored tuples to the edges of
+// the relationship graph: subject (a user or user set) has relation to
+// object. It mirrors NestedGroupTuple, OrgParentTuple, OrgMemberTuple and
+// RoleMemberTuple, and the relations the model derives from cascade_member. IDs are compared as
+// text so stored tuples, which may hold any ID, join with UUID columns.
+// Group memberships and role assignments outside their window are left out.
+var edgesSQL = `edges(subject_type, subject_id, subject_relation, relation, object_type, object_id) AS (
+	SELECT 'user', CAST(user_id AS TEXT), '', 'member', 'group', CAST(group_id AS TEXT) FROM members WHERE ` + models.InEffectSQL("members") + `
+	UNION ALL
+	SELECT 'group', CAST(member_group_id AS TEXT), 'member', 'member', 'group', CAST(group_id AS TEXT) FROM nested_groups
+	UNION ALL
+	SELECT CASE UPPER(type) WHEN 'USER' THEN 'user' WHEN 'GROUP' THEN 'group' ELSE 'role' END,
+		CAST(entity_id AS TEXT),
+		CASE UPPER(type) WHEN 'USER' THEN '' WHEN 'GROUP' THEN 'member' ELSE 'assignee' END,
+		CASE WHEN "cascade" THEN 'cascade_member' ELSE 'member' END, 'org', CAST(org_id AS TEXT)
+	FROM org_members WHERE UPPER(type) IN ('USER', 'GROUP', 'ROLE')
+	UNION ALL
+	SELECT 'org', CAST(parent_id AS TEXT), '', 'parent', 'org', CAST(id AS TEXT) FROM orgs WHERE parent_id IS NOT NULL
+	UNION ALL
+	SELECT 'org', CAST(parent_id AS TEXT), 'cascade_member', 'cascade_member', 'org', CAST(id AS TEXT) FROM orgs WHERE parent_id IS NOT NULL
+	UNION ALL
+	SELECT 'org', CAST(id AS TEXT), 'cascade_member', 'member', 'org', CAST(id AS TEXT) FROM orgs
+	UNION ALL
+	SELECT CASE UPPER(type) WHEN 'USER' THEN 'user' WHEN 'GROUP' THEN 'group' ELSE 'org' END,
+		CAST(entity_id AS TEXT),
+		CASE UPPER(type) WHEN 'USER' THEN '' ELSE 'member' END,
+		'assignee', 'role', CAST(role_id AS TEXT)
+	FROM role_members WHERE UPPER(type) IN ('USER', 'GROUP', 'ORG') AND ` + models.InEffectSQL("role_members") + `
+	UNION ALL
+	SELECT user_type, user_id, user_relation, relation, object_type, object_id FROM authz_tuples
+)`
+
+// parentTables are the tables of object types arranged in a tree through a
+// parent_id column
+var parentTables = map[string]string{"org": "orgs"}
+
+// node is a relation of an object, which is also the user set of those
+// having it, e.g. group:<id>#member
+type node struct {
+	Type     string
+	ID       string
+	Relation string
+}
+
+// NativeEngine evaluates the authorization model with recursive SQL over the
+// membership tables and authz_tuples, so no OpenFGA server is needed. Every
+// user, group, role and org in its table is linked to SystemObject.
+type NativeEngine struct {
+	db     *gorm.DB
+	logger *logrus.Logger
+}
+
+func NewNativeEngine(db *gorm.DB) *NativeEngine {
+	return &NativeEngine{
+		db:     db,
+		logger: logrus.New(),
+	}
+}
+
+// Check implements Authorizer
+func (e *NativeEngine) Check(ctx context.Context, user, relation, object string) (bool, error) {
+	subject, err := parseUser(user)
+	if err != nil {
+		return false, err
+	}
+	objectType, objectID, err := parseObject(object)
+	if err != nil {
+		return false, err
+	}
+	if relation == RelationSystem {
+		if user != SystemObject {
+			return false, nil
+		}
+		return e.linked(ctx, objectType, objectID)
+	}
+	local, inherited, system, err := expandRelation(objectType, relation)
+	if err != nil {
+		return false, err
+	}
+
+	reach, err := e.reach(ctx, subject)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range local {
+		if reach[node{Type: objectType, ID: objectID, Relation: r}] {
+			return true, nil
+		}
+	}
+	if len(inherited) > 0 {
+		ancestors, err := e.ancestors(ctx, objectType, objectID)
+		if err != nil {
+			return false, err
+		}
+		for _, id := range append(ancestors, objectID) {
+			for _, r := range inherited {
+				if reach[node{Type: objectType, ID: id, Relation: r}] {
+					return true, nil
+				}
+			}
+		}
+	}
+	if reachesSystem(reach, system) {
+		return e.linked(ctx, objectType, objectID)
+	}
+	return false, nil
+}
+
+// ListObjects implements Authorizer
+func (e *NativeEngine) ListObjects(ctx context.Context, user, relation, objectType string) ([]string, error) {
+	subject, err := parseUser(user)
+	if err != nil {
+		return nil, err
+	}
+	if relation == RelationSystem {
+		if user != SystemObject {
+			return []string{}, nil
+		}
+		return e.allObjects(ctx, objectType)
+	}
+	local, inherited, system, err := expandRelation(objectType, relation)
+	if err != nil {
+		return nil, err
+	}
+
+	reach, err := e.reach(ctx, subject)
+	if err != nil {
+		return nil, err
+	}
+	if reachesSystem(reach, system) {
+		return e.allObjects(ctx, objectType)
+	}
+	found := map[string]bool{}
+	var roots []string
+	for n := range reach {
+		if n.Type != objectType {
+			continue
+		}
+		if contains(local, n.Relation) || contains(inherited, n.Relation) {
+			found[n.Type+":"+n.ID] = true
+		}
+		if contains(inherited, n.Relation) {
+			roots = append(roots, n.ID)
+		}
+	}
+	descendants, err := e.descendants(ctx, objectType, roots)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range descendants {
+		found[objectType+":"+id] = true
+	}
+	return sortedKeys(found), nil
+}
+
+// ListUsers implements Authorizer
+func (e *NativeEngine) ListUsers(ctx context.Context, object, relation, userType string) ([]string, error) {
+	objectType, objectID, err := parseObject(object)
+	if err != nil {
+		return nil, err
+	}
+	if relation == RelationSystem {
+		linked, err := e.linked(ctx, objectType, objectID)
+		if err != nil || !linked || userType != "system" {
+			return []string{}, err
+		}
+		return []string{SystemObject}, nil
+	}
+	local, inherited, system, err := expandRelation(objectType, relation)
+	if err != nil {
+		return nil, err
+	}
+
+	var seeds []node
+	for _, r := range local {
+		seeds = append(seeds, node{Type: objectType, ID: objectID, Relation: r})
+	}
+	if len(inherited) > 0 {
+		ancestors, err := e.ancestors(ctx, objectType, objectID)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range append(ancestors, objectID) {
+			for _, r := range inherited {
+				seeds = append(seeds, node{Type: objectType, ID: id, Relation: r})
+			}
+		}
+	}
+	if len(system) > 0 {
+		linked, err := e.linked(ctx, objectType, objectID)
+		if err != nil {
+			return nil, err
+		}
+		if linked {
+			systemType, systemID, _ := strings.Cut(SystemObject, ":")
+			for _, r := range system {
+				seeds = append(seeds, node{Type: systemType, ID: systemID, Relation: r})
+			}
+		}
+	}
+	if len(seeds) == 0 {
+		return []string{}, nil
+	}
+
+	values := make([]string, len(seeds))
+	var args []interface{}
+	for i, seed := range seeds {
+		values[i] = "(CAST(? AS TEXT), CAST(? AS TEXT), CAST(? AS TEXT))"
+		args = append(args, seed.Type, seed.ID, seed.Relation)
+	}
+	args = append(args, userType)
+	query := `WITH RECURSIVE ` + edgesSQL + `,
+seeds(object_type, object_id, relation) AS (VALUES ` + strings.Join(values, ", ") + `),
+usersets(object_type, object_id, relation) AS (
+	SELECT object_type, object_id, relation FROM seeds
+	UNION
+	SELECT e.subject_type, e.subject_id, e.subject_relation FROM edges e
+	JOIN usersets u ON e.object_type = u.object_type AND e.object_id = u.object_id AND e.relation = u.relation
+	WHERE e.subject_relation <> ''
+)
+SELECT DISTINCT e.subject_id FROM edges e
+JOIN usersets u ON e.object_type = u.object_type AND e.object_id = u.object_id AND e.relation = u.relation
+WHERE e.subject_type = ? AND e.subject_relation = ''`
+
+	var ids []string
+	if err := e.db.WithContext(ctx).Raw(query, args...).Find(&ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	users := make([]string, len(ids))
+	for i, id := range ids {
+		users[i] = userType + ":" + id
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// GrantPermission stores a tuple. Relations held by the membership tables
+// are rejected; change the memberships instead.
+func (e *NativeEngine) GrantPermission(user, relation, object string) error {
+	tuple, err := storedTuple(user, relation, object)
+	if err != nil {
+		return err
+	}
+	if err := e.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&tuple).Error; err != nil {
+		return fmt.Errorf("failed to grant permission: %w", err)
+	}
+	e.logger.Infof("Granted permission: %s %s %s", user, relation, object)
+	return nil
+}
+
+// RevokePermission deletes a stored tuple. Deleting a tuple that doesn't
+// exist succeeds.
+func (e *NativeEngine) RevokePermission(user, relation, object string) error {
+	tuple, err := storedTuple(user, relation, object)
+	if err != nil {
+		return err
+	}
+	err = e.db.Where("user_type = ? AND user_id = ? AND user_relation = ? AND relation = ? AND object_type = ? AND object_id = ?",
+		tuple.UserType, tuple.UserID, tuple.UserRelation, tuple.Relation, tuple.ObjectType, tuple.ObjectID).Delete(&StoredTuple{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to revoke permission: %w", err)
+	}
+	e.logger.Infof("Revoked permission: %s %s %s", user, relation, object)
+	return nil
+}
+
+// ReadTuples returns the stored tuples. Tuples of the membership tables
+// aren't included.
+func (e *NativeEngine) ReadTuples(ctx context.Context) ([]Tuple, error) {
+	var stored []StoredTuple
+	if err := e.db.WithContext(ctx).Order("created_at").Find(&stored).Error; err != nil {
+		return nil, fmt.Errorf("failed to read tuples: %w", err)
+	}
+	tuples := make([]Tuple, len(stored))
+	for i, t := range stored {
+		user := t.UserType + ":" + t.UserID
+		if t.UserRelation != "" {
+			user += "#" + t.UserRelation
+		}
+		tuples[i] = Tuple{User: user, Relation: t.Relation, Object: t.ObjectType + ":" + t.ObjectID}
+	}
+	return tuples, nil
+}
+
+// reach returns every relation the subject has, directly or through user
+// sets, to any object
+func (e *NativeEngine) reach(ctx context.Context, subject node) (map[node]bool, error) {
+	query := `WITH RECURSIVE ` + edgesSQL + `,
+reach(object_type, object_id, relation) AS (
+	SELECT object_type, object_id, relation FROM edges
+	WHERE subject_type = ? AND subject_id = ? AND subject_relation = ?
+	UNION
+	SELECT e.object_type, e.object_id, e.relation FROM edges e
+	JOIN reach r ON e.subject_type = r.object_type AND e.subject_id = r.object_id AND e.subject_relation = r.relation
+)
+SELECT object_type AS type, object_id AS id, relation FROM reach`
+
+	var nodes []node
+	if err := e.db.WithContext(ctx).Raw(query, subject.Type, subject.ID, subject.Relation).Find(&nodes).Error; err != nil {
+		return nil, fmt.Errorf("failed to resolve relations: %w", err)
+	}
+	reach := make(map[node]bool, len(nodes)+1)
+	for _, n := range nodes {
+		reach[n] = true
+	}
+	// A user set has its own relation
+	if subject.Relation != "" {
+		reach[subject] = true
+	}
+	return reach, nil
+}
+
+// linked reports whether the object is linked to SystemObject, i.e. exists
+func (e *NativeEngine) linked(ctx context.Context, objectType, objectID string) (bool, error) {
+	table := linkedTable(objectType)
+	if table == "" {
+		return false, nil
+	}
+	id, err := uuid.Parse(objectID)
+	if err != nil {
+		return false, nil
+	}
+	var count int64
+	if err := e.db.WithContext(ctx).Table(table).Where("id = ?", id).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to find %s: %w", objectType, err)
+	}
+	return count > 0, nil
+}
+
+// ancestors returns the IDs of the objects above the object, for types
+// arranged in a tree
+func (e *NativeEngine) ancestors(ctx context.Context, objectType, objectID string) ([]string, error) {
+	table, ok := parentTables[objectType]
+	if !ok {
+		return nil, nil
+	}
+	id, err := uuid.Parse(objectID)
+	if err != nil {
+		return nil, nil
+	}
+	query := `WITH RECURSIVE up(id) AS (
+	SELECT parent_id FROM ` + table + ` WHERE id = ? AND parent_id IS NOT NULL
+	UNION
+	SELECT t.parent_id FROM ` + table + ` t JOIN up ON t.id = up.id WHERE t.parent_id IS NOT NULL
+)
+SELECT CAST(id AS TEXT) FROM up`
+
+	var ids []string
+	if err := e.db.WithContext(ctx).Raw(query, id).Find(&ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to find ancestors of %s: %w", objectType, err)
+	}
+	return ids, nil
+}
+
+// descendants returns the IDs of the objects below any of the given ones,
+// for types arranged in a tree
+func (e *NativeEngine) descendants(ctx context.Context, objectType string, objectIDs []string) ([]string, error) {
+	table, ok := parentTables[objectType]
+	if !ok || len(objectIDs) == 0 {
+		return nil, nil
+	}
+	var roots []uuid.UUID
+	for _, objectID := range objectIDs {
+		if id, err := uuid.Parse(objectID); err == nil {
+			roots = append(roots, id)
+		}
+	}
+	if len(roots) == 0 {
+		return nil, nil
+	}
+	query := `WITH RECURSIVE down(id) AS (
+	SELECT id FROM ` + table + ` WHERE parent_id IN ?
+	UNION
+	SELECT t.id FROM ` + table + ` t JOIN down ON t.parent_id = down.id
+)
+SELECT CAST(id AS TEXT) FROM down`
+
+	var ids []string
+	if err := e.db.WithContext(ctx).Raw(query, roots).Find(&ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to find descendants of %s: %w", objectType, err)
+	}
+	return ids, nil
+}
+
+// allObjects returns every object of a type linked to SystemObject
+func (e *NativeEngine) allObjects(ctx context.Context, objectType string) ([]string, error) {
+	table := linkedTable(objectType)
+	if table == "" {
+		return []string{}, nil
+	}
+	var ids []uuid.UUID
+	if err := e.db.WithContext(ctx).Table(table).Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to list %s objects: %w", objectType, err)
+	}
+	objects := make([]string, len(ids))
+	for i, id := range ids {
+		objects[i] = objectType + ":" + id.String()
+	}
+	sort.Strings(objects)
+	return objects, nil
+}
+
+// storedTuple checks a tuple against the model and splits it for storage
+func storedTuple(user, relation, object string) (StoredTuple, error) {
+	subject, err := parseUser(user)
+	if err != nil {
+		return StoredTuple{}, err
+	}
+	objectType, objectID, err := parseObject(object)
+	if err != nil {
+		return StoredTuple{}, err
+	}
+	if managed(Tuple{User: user, Relation: relation, Object: object}) {
+		return StoredTuple{}, fmt.Errorf("%w: %s %s is derived from the membership tables", ErrRejected, relation, objectType)
+	}
+	userTypes, ok := directRelations[objectType][relation]
+	if !ok {
+		return StoredTuple{}, fmt.Errorf("%w: %s is not a direct relation of %s", ErrRejected, relation, objectType)
+	}
+	userType := subject.Type
+	if subject.Relation != "" {
+		userType += "#" + subject.Relation
+	}
+	if !contains(userTypes, userType) {
+		return StoredTuple{}, fmt.Errorf("%w: %s can't have %s on %s", ErrRejected, userType, relation, objectType)
+	}
+	return StoredTuple{
+		UserType:     subject.Type,
+		UserID:       subject.ID,
+		UserRelation: subject.Relation,
+		Relation:     relation,
+		ObjectType:   objectType,
+		ObjectID:     objectID,
+	}, nil
+}
+
+// parseUser splits a user such as "user:<id>" or "group:<id>#member"
+func parseUser(user string) (node, error) {
+	object, relation, _ := strings.Cut(user, "#")
+	userType, userID, err := parseObject(object)
+	if err != nil {
+		return node{}, fmt.Errorf("invalid user: %q", user)
+	}
+	return node{Type: userType, ID: userID, Relation: relation}, nil
+}
+
+// parseObject splits an object such as "group:<id>"
+func parseObject(object string) (string, string, error) {
+	objectType, objectID, ok := strings.Cut(object, ":")
+	if !ok || objectType == "" || objectID == "" {
+		return "", "", fmt.Errorf("invalid object: %q", object)
+	}
+	return objectType, objectID, nil
+}
+
+// reachesSystem reports whether any of the relations on SystemObject were
+// reached
+func reachesSystem(reach map[node]bool, relations []string) bool {
+	systemType, systemID, _ := strings.Cut(SystemObject, ":")
+	for _, r := range relations {
+		if reach[node{Type: systemType, ID: systemID, Relation: r}] {
+			return true
+		}
+	}
+	return false
+}
+
+func linkedTable(objectType string) string {
+	for _, linked := range LinkedTypes {
+		if linked.Type == objectType {
+			return linked.Table
+		}
+	}
+	return ""
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,53 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandRelation(t *testing.T) {
+	local, inherited, system, err := expandRelation("group", "read")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"member", "owner"}, local)
+	assert.Empty(t, inherited)
+	assert.ElementsMatch(t, []string{"viewer", "admin"}, system)
+
+	local, inherited, system, err = expandRelation("system", "manage")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, local)
+	assert.Empty(t, inherited)
+	assert.Empty(t, system)
+
+	// Admins of an org manage the orgs below it
+	local, inherited, system, err = expandRelation("org", "read")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"member"}, local)
+	assert.Equal(t, []string{"admin"}, inherited)
+	assert.ElementsMatch(t, []string{"viewer", "admin"}, system)
+
+	_, _, _, err = expandRelation("group", "fly")
+	assert.Error(t, err)
+}
+
+func TestStoredTuple(t *testing.T) {
+	tuple, err := storedTuple("role:r1#assignee", "admin", SystemObject)
+	require.NoError(t, err)
+	assert.Equal(t, StoredTuple{UserType: "role", UserID: "r1", UserRelation: "assignee", Relation: "admin", ObjectType: "system", ObjectID: "idmapp"}, tuple)
+
+	for _, rejected := range []Tuple{
+		// Held by the membership tables
+		{User: "user:u1", Relation: "member", Object: "group:g1"},
+		{User: SystemObject, Relation: "system", Object: "group:g1"},
+		// Not allowed by the model
+		{User: "group:g1#member", Relation: "owner", Object: "group:g2"},
+		{User: "user:u1", Relation: "read", Object: "group:g1"},
+	} {
+		_, err := storedTuple(rejected.User, rejected.Relation, rejected.Object)
+		assert.ErrorIs(t, err, ErrRejected, rejected.String())
+	}
+
+	_, err = storedTuple("u1", "admin", SystemObject)
+	assert.Error(t, err)
+}
@@ -0,0 +1,99 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Outbox operations
+const (
+	OpWrite  = "write"
+	OpDelete = "delete"
+)
+
+const (
+	// outboxBatchSize bounds the changes applied per worker run
+	outboxBatchSize = 100
+	// maxAttempts is how often a change is retried before it is set aside
+	maxAttempts    = 10
+	retryBaseDelay = 5 * time.Second
+	retryMaxDelay  = 5 * time.Minute
+	// outboxLockKey is the advisory lock serializing the worker and resyncs
+	// across instances, so changes are applied once and in order
+	outboxLockKey = 0x617574687a
+)
+
+// ErrRejected marks tuple changes the store refused. Retrying them won't
+// help, so they are set aside right away.
+var ErrRejected = errors.New("tuple change rejected")
+
+// TupleChange is a tuple write or delete waiting to be applied to the
+// authorization store. Changes are recorded in the transaction of the
+// membership change and applied in Seq order.
+type TupleChange struct {
+	Seq           uint64     `json:"seq" gorm:"primaryKey;autoIncrement"`
+	Op            string     `json:"op" gorm:"type:varchar(16);not null"`
+	User          string     `json:"user" gorm:"type:varchar(255);not null"`
+	Relation      string     `json:"relation" gorm:"type:varchar(64);not null"`
+	Object        string     `json:"object" gorm:"type:varchar(255);not null"`
+	Attempts      int        `json:"attempts" gorm:"not null;default:0"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt" gorm:"not null"`
+	LastError     string     `json:"lastError,omitempty" gorm:"type:text"`
+	FailedAt      *time.Time `json:"failedAt,omitempty" gorm:"index"`
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+func (c *TupleChange) TableName() string {
+	return "authz_tuple_outbox"
+}
+
+func (c *TupleChange) Tuple() Tuple {
+	return Tuple{User: c.User, Relation: c.Relation, Object: c.Object}
+}
+
+// Record queues tuple changes. Pass the transaction that changes the
+// membership rows, so the change and its tuples commit or roll back together.
+func Record(tx *gorm.DB, op string, tuples ...Tuple) error {
+	if len(tuples) == 0 {
+		return nil
+	}
+	now := time.Now()
+	changes := make([]TupleChange, len(tuples))
+	for i, t := range tuples {
+		changes[i] = TupleChange{
+			Op:            op,
+			User:          t.User,
+			Relation:      t.Relation,
+			Object:        t.Object,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+	}
+	if err := tx.Create(&changes).Error; err != nil {
+		return fmt.Errorf("failed to record tuple changes: %w", err)
+	}
+	return nil
+}
+
+// RecordSystemLink queues the link of a created or deleted object to
+// SystemObject. It runs in the AfterCreate and AfterDelete hooks of users,
+// groups, roles and orgs, so the link commits with the row.
+func RecordSystemLink(tx *gorm.DB, op, objectType string, id uuid.UUID) error {
+	return Record(tx, op, SystemLink(objectType, id))
+}
+
+// retryDelay is the backoff after the given number of failed attempts
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,325 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"idmapp-go/internal/events"
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// TupleStore is the authorization store tuples are synced to. Granting an
+// existing tuple and revoking a missing one succeed, so changes can be
+// applied more than once.
+type TupleStore interface {
+	GrantPermission(user, relation, object string) error
+	RevokePermission(user, relation, object string) error
+	ReadTuples(ctx context.Context) ([]Tuple, error)
+}
+
+// ErrSyncInProgress is returned when another worker or resync holds the lock
+var ErrSyncInProgress = errors.New("tuple sync is in progress on another instance")
+
+// SyncService applies the tuple outbox to the authorization store and
+// rebuilds the store from the membership tables
+type SyncService struct {
+	db     *gorm.DB
+	store  TupleStore
+	logger *logrus.Logger
+}
+
+func NewSyncService(db *gorm.DB, store TupleStore) *SyncService {
+	return &SyncService{
+		db:     db,
+		store:  store,
+		logger: logrus.New(),
+	}
+}
+
+// ProcessOutbox applies due changes in order and returns how many were
+// applied. A change that fails blocks the ones after it until it succeeds
+// or runs out of attempts, so a tuple is never deleted before its write.
+func (s *SyncService) ProcessOutbox() (int, error) {
+	applied := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := tryLock(tx)
+		if err != nil || !locked {
+			return err
+		}
+
+		var changes []TupleChange
+		if err := tx.Where("failed_at IS NULL").Order("seq").Limit(outboxBatchSize).Find(&changes).Error; err != nil {
+			return fmt.Errorf("failed to load tuple changes: %w", err)
+		}
+		for i := range changes {
+			change := &changes[i]
+			if change.NextAttemptAt.After(time.Now()) {
+				break
+			}
+			if err := s.apply(change); err != nil {
+				return s.reschedule(tx, change, err)
+			}
+			if err := tx.Delete(change).Error; err != nil {
+				return fmt.Errorf("failed to delete tuple change: %w", err)
+			}
+			applied++
+		}
+		return nil
+	})
+	if applied > 0 {
+		events.Publish(events.Event{
+			Type: "authz.tuples_synced",
+			Data: map[string]interface{}{"applied": applied},
+		})
+	}
+	return applied, err
+}
+
+func (s *SyncService) apply(change *TupleChange) error {
+	switch change.Op {
+	case OpWrite:
+		return s.store.GrantPermission(change.User, change.Relation, change.Object)
+	case OpDelete:
+		return s.store.RevokePermission(change.User, change.Relation, change.Object)
+	}
+	return fmt.Errorf("%w: unknown op %q", ErrRejected, change.Op)
+}
+
+// reschedule backs off a failed change, or sets it aside when it was
+// rejected or out of attempts so the changes after it can proceed
+func (s *SyncService) reschedule(tx *gorm.DB, change *TupleChange, cause error) error {
+	now := time.Now()
+	change.Attempts++
+	updates := map[string]interface{}{
+		"attempts":        change.Attempts,
+		"last_error":      cause.Error(),
+		"next_attempt_at": now.Add(retryDelay(change.Attempts)),
+	}
+	if errors.Is(cause, ErrRejected) || change.Attempts >= maxAttempts {
+		updates["failed_at"] = now
+		s.logger.Errorf("Giving up on tuple %s %s after %d attempts: %v", change.Op, change.Tuple(), change.Attempts, cause)
+	} else {
+		s.logger.Warnf("Failed to %s tuple %s (attempt %d): %v", change.Op, change.Tuple(), change.Attempts, cause)
+	}
+	if err := tx.Model(change).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to reschedule tuple change: %w", err)
+	}
+	return nil
+}
+
+// RunOutboxWorker applies the outbox every interval until ctx is done
+func (s *SyncService) RunOutboxWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				applied, err := s.ProcessOutbox()
+				if err != nil {
+					s.logger.Errorf("Failed to process tuple outbox: %v", err)
+				}
+				// Keep going while full batches are being applied
+				if err != nil || applied < outboxBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// GetStatus summarizes the outbox
+func (s *SyncService) GetStatus() (*SyncStatus, error) {
+	status := &SyncStatus{}
+	if err := s.db.Model(&TupleChange{}).Where("failed_at IS NULL").Count(&status.Pending).Error; err != nil {
+		return nil, fmt.Errorf("failed to count pending tuple changes: %w", err)
+	}
+	if err := s.db.Model(&TupleChange{}).Where("failed_at IS NOT NULL").Count(&status.Failed).Error; err != nil {
+		return nil, fmt.Errorf("failed to count failed tuple changes: %w", err)
+	}
+
+	var oldest TupleChange
+	result := s.db.Where("failed_at IS NULL").Order("seq").Limit(1).Find(&oldest)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get oldest tuple change: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		status.Oldest = &oldest
+	}
+	return status, nil
+}
+
+// GetFailedChanges lists the changes that were set aside
+func (s *SyncService) GetFailedChanges() ([]TupleChange, error) {
+	var changes []TupleChange
+	if err := s.db.Where("failed_at IS NOT NULL").Order("seq").Find(&changes).Error; err != nil {
+		return nil, fmt.Errorf("failed to get failed tuple changes: %w", err)
+	}
+	return changes, nil
+}
+
+// Drift compares the store with the tuples the membership tables call for
+func (s *SyncService) Drift(ctx context.Context) (*DriftReport, error) {
+	report, _, err := s.drift(ctx, s.db)
+	return report, err
+}
+
+// Resync makes the store match the membership tables: missing tuples are
+// written and stale ones deleted. Queued changes that the rebuild covers,
+// including failed ones, are dropped.
+func (s *SyncService) Resync(ctx context.Context) (*DriftReport, error) {
+	var report *DriftReport
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := tryLock(tx)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			return ErrSyncInProgress
+		}
+
+		var covered uint64
+		report, covered, err = s.drift(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range report.Missing {
+			if err := s.store.GrantPermission(t.User, t.Relation, t.Object); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("write %s: %v", t, err))
+			}
+		}
+		for _, t := range report.Extra {
+			if err := s.store.RevokePermission(t.User, t.Relation, t.Object); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("delete %s: %v", t, err))
+			}
+		}
+		report.Applied = true
+
+		// With errors some tuples are still off, so queued changes may
+		// yet be needed
+		if len(report.Errors) == 0 && covered > 0 {
+			if err := tx.Where("seq <= ?", covered).Delete(&TupleChange{}).Error; err != nil {
+				return fmt.Errorf("failed to clear tuple outbox: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("Resynced authorization store: %d written, %d deleted, %d errors", len(report.Missing), len(report.Extra), len(report.Errors))
+	events.Publish(events.Event{
+		Type: "authz.tuples_synced",
+		Data: map[string]interface{}{"written": len(report.Missing), "deleted": len(report.Extra), "errors": len(report.Errors)},
+	})
+	return report, nil
+}
+
+// drift computes the report and the last outbox change the desired tuples
+// already reflect
+func (s *SyncService) drift(ctx context.Context, db *gorm.DB) (*DriftReport, uint64, error) {
+	// Read before the memberships, so every change up to it is reflected
+	var covered uint64
+	if err := db.Model(&TupleChange{}).Select("COALESCE(MAX(seq), 0)").Find(&covered).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to get last tuple change: %w", err)
+	}
+
+	desired, err := desiredTuples(db)
+	if err != nil {
+		return nil, 0, err
+	}
+	actual, err := s.store.ReadTuples(ctx)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read tuples: %w", err)
+	}
+
+	missing, extra := diffTuples(desired, actual)
+	return &DriftReport{
+		Desired:   len(desired),
+		Missing:   missing,
+		Extra:     extra,
+		CheckedAt: time.Now(),
+	}, covered, nil
+}
+
+// desiredTuples maps every membership row to its tuple and links every
+// object to SystemObject. Group memberships and role assignments outside
+// their window have no tuple.
+func desiredTuples(db *gorm.DB) ([]Tuple, error) {
+	var tuples []Tuple
+
+	var members []struct {
+		GroupID uuid.UUID
+		UserID  uuid.UUID
+	}
+	if err := db.Table("members").Select("group_id, user_id").Where(models.InEffectSQL("members")).Find(&members).Error; err != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+	for _, m := range members {
+		tuples = append(tuples, GroupMemberTuple(m.GroupID, m.UserID))
+	}
+
+	var nestedGroups []struct {
+		GroupID       uuid.UUID
+		MemberGroupID uuid.UUID
+	}
+	if err := db.Table("nested_groups").Select("group_id, member_group_id").Find(&nestedGroups).Error; err != nil {
+		return nil, fmt.Errorf("failed to get nested groups: %w", err)
+	}
+	for _, n := range nestedGroups {
+		tuples = append(tuples, NestedGroupTuple(n.GroupID, n.MemberGroupID))
+	}
+
+	var orgParents []struct {
+		ID       uuid.UUID
+		ParentID uuid.UUID
+	}
+	if err := db.Table("orgs").Select("id, parent_id").Where("parent_id IS NOT NULL").Find(&orgParents).Error; err != nil {
+		return nil, fmt.Errorf("failed to get org parents: %w", err)
+	}
+	for _, o := range orgParents {
+		tuples = append(tuples, OrgParentTuple(o.ID, o.ParentID))
+	}
+
+	var orgMembers []models.OrgMember
+	if err := db.Find(&orgMembers).Error; err != nil {
+		return nil, fmt.Errorf("failed to get org members: %w", err)
+	}
+	tuples = append(tuples, OrgMemberTuples(orgMembers)...)
+
+	var roleMembers []models.RoleMember
+	if err := db.Where(models.InEffectSQL("role_members")).Find(&roleMembers).Error; err != nil {
+		return nil, fmt.Errorf("failed to get role members: %w", err)
+	}
+	tuples = append(tuples, RoleMemberTuples(roleMembers)...)
+
+	for _, linked := range LinkedTypes {
+		var ids []uuid.UUID
+		if err := db.Table(linked.Table).Pluck("id", &ids).Error; err != nil {
+			return nil, fmt.Errorf("failed to get %s IDs: %w", linked.Type, err)
+		}
+		for _, id := range ids {
+			tuples = append(tuples, SystemLink(linked.Type, id))
+		}
+	}
+	return tuples, nil
+}
+
+func tryLock(tx *gorm.DB) (bool, error) {
+	var locked bool
+	if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", outboxLockKey).Scan(&locked).Error; err != nil {
+		return false, fmt.Errorf("failed to lock tuple outbox: %w", err)
+	}
+	return locked, nil
+}
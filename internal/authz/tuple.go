@@ -0,0 +1,179 @@
+package authz
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+)
+
+// Relations written for membership rows
+const (
+	RelationMember        = "member"
+	RelationAssignee      = "assignee"
+	RelationCascadeMember = "cascade_member"
+	RelationParent        = "parent"
+)
+
+// Tuple is an OpenFGA relationship tuple: user has relation to object
+type Tuple struct {
+	User     string `json:"user"`
+	Relation string `json:"relation"`
+	Object   string `json:"object"`
+}
+
+func (t Tuple) String() string {
+	return t.User + " " + t.Relation + " " + t.Object
+}
+
+// LinkedTypes are the object types linked to SystemObject, with the tables
+// holding their objects
+var LinkedTypes = []struct{ Type, Table string }{
+	{"user", "users"},
+	{"group", "groups"},
+	{"role", "roles"},
+	{"org", "orgs"},
+}
+
+// SystemLink links an object to SystemObject, so system admins and viewers
+// may manage or read it. Links are written when objects are created and
+// deleted with them; see RecordSystemLink.
+func SystemLink(objectType string, id uuid.UUID) Tuple {
+	return Tuple{User: SystemObject, Relation: RelationSystem, Object: objectType + ":" + id.String()}
+}
+
+// GroupMemberTuple makes the user a member of the group
+func GroupMemberTuple(groupID, userID uuid.UUID) Tuple {
+	return Tuple{User: "user:" + userID.String(), Relation: RelationMember, Object: "group:" + groupID.String()}
+}
+
+// NestedGroupTuple makes everyone in the member group a member of the group
+func NestedGroupTuple(groupID, memberGroupID uuid.UUID) Tuple {
+	return Tuple{User: "group:" + memberGroupID.String() + "#" + RelationMember, Relation: RelationMember, Object: "group:" + groupID.String()}
+}
+
+// OrgParentTuple places the org below its parent
+func OrgParentTuple(orgID, parentID uuid.UUID) Tuple {
+	return Tuple{User: "org:" + parentID.String(), Relation: RelationParent, Object: "org:" + orgID.String()}
+}
+
+// OrgMemberTuple maps an org membership. Users are members themselves;
+// group and role members bring in everyone in the group or assigned the role.
+// Cascading memberships extend to the orgs below.
+func OrgMemberTuple(m models.OrgMember) (Tuple, error) {
+	var subject string
+	switch strings.ToUpper(m.Type) {
+	case "USER":
+		subject = "user:" + m.EntityID.String()
+	case "GROUP":
+		subject = "group:" + m.EntityID.String() + "#" + RelationMember
+	case "ROLE":
+		subject = "role:" + m.EntityID.String() + "#" + RelationAssignee
+	default:
+		return Tuple{}, fmt.Errorf("unsupported org member type: %q", m.Type)
+	}
+	relation := RelationMember
+	if m.Cascade {
+		relation = RelationCascadeMember
+	}
+	return Tuple{User: subject, Relation: relation, Object: "org:" + m.OrgID.String()}, nil
+}
+
+// RoleMemberTuple maps a role assignment to a user or to all members of a
+// group or org
+func RoleMemberTuple(m models.RoleMember) (Tuple, error) {
+	var subject string
+	switch strings.ToUpper(m.Type) {
+	case "USER":
+		subject = "user:" + m.EntityID.String()
+	case "GROUP":
+		subject = "group:" + m.EntityID.String() + "#" + RelationMember
+	case "ORG":
+		subject = "org:" + m.EntityID.String() + "#" + RelationMember
+	default:
+		return Tuple{}, fmt.Errorf("unsupported role member type: %q", m.Type)
+	}
+	return Tuple{User: subject, Relation: RelationAssignee, Object: "role:" + m.RoleID.String()}, nil
+}
+
+// OrgMemberTuples maps org memberships, skipping unsupported types
+func OrgMemberTuples(members []models.OrgMember) []Tuple {
+	var tuples []Tuple
+	for _, m := range members {
+		if t, err := OrgMemberTuple(m); err == nil {
+			tuples = append(tuples, t)
+		}
+	}
+	return tuples
+}
+
+// RoleMemberTuples maps role assignments, skipping unsupported types
+func RoleMemberTuples(members []models.RoleMember) []Tuple {
+	var tuples []Tuple
+	for _, m := range members {
+		if t, err := RoleMemberTuple(m); err == nil {
+			tuples = append(tuples, t)
+		}
+	}
+	return tuples
+}
+
+// managed reports whether the tuple is derived from a membership table or
+// is a system link. Other tuples in the store, such as owners or admins, are
+// left alone.
+func managed(t Tuple) bool {
+	objectType, _, _ := strings.Cut(t.Object, ":")
+	switch t.Relation {
+	case RelationMember:
+		return objectType == "group" || objectType == "org"
+	case RelationAssignee:
+		return objectType == "role"
+	case RelationCascadeMember, RelationParent:
+		return objectType == "org"
+	case RelationSystem:
+		for _, linked := range LinkedTypes {
+			if objectType == linked.Type {
+				return t.User == SystemObject
+			}
+		}
+	}
+	return false
+}
+
+// diffTuples returns the desired tuples missing from actual and the managed
+// tuples in actual that aren't desired, both sorted
+func diffTuples(desired, actual []Tuple) ([]Tuple, []Tuple) {
+	want := make(map[Tuple]bool, len(desired))
+	for _, t := range desired {
+		want[t] = true
+	}
+	have := make(map[Tuple]bool, len(actual))
+	for _, t := range actual {
+		have[t] = true
+	}
+
+	missing := []Tuple{}
+	for t := range want {
+		if !have[t] {
+			missing = append(missing, t)
+		}
+	}
+	extra := []Tuple{}
+	for t := range have {
+		if !want[t] && managed(t) {
+			extra = append(extra, t)
+		}
+	}
+	sortTuples(missing)
+	sortTuples(extra)
+	return missing, extra
+}
+
+func sortTuples(tuples []Tuple) {
+	sort.Slice(tuples, func(i, j int) bool {
+		return tuples[i].String() < tuples[j].String()
+	})
+}
@@ -0,0 +1,115 @@
+package authz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMembershipTuples(t *testing.T) {
+	groupID, userID, orgID, roleID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+
+	assert.Equal(t, Tuple{User: "user:" + userID.String(), Relation: "member", Object: "group:" + groupID.String()},
+		GroupMemberTuple(groupID, userID))
+	assert.Equal(t, Tuple{User: "group:" + userID.String() + "#member", Relation: "member", Object: "group:" + groupID.String()},
+		NestedGroupTuple(groupID, userID))
+
+	for memberType, subject := range map[string]string{
+		"USER":  "user:" + userID.String(),
+		"group": "group:" + userID.String() + "#member",
+		"ROLE":  "role:" + userID.String() + "#assignee",
+	} {
+		tuple, err := OrgMemberTuple(models.OrgMember{OrgID: orgID, EntityID: userID, Type: memberType})
+		require.NoError(t, err, memberType)
+		assert.Equal(t, Tuple{User: subject, Relation: "member", Object: "org:" + orgID.String()}, tuple)
+	}
+	_, err := OrgMemberTuple(models.OrgMember{OrgID: orgID, EntityID: userID, Type: "TEAM"})
+	assert.Error(t, err)
+	tuple, err := OrgMemberTuple(models.OrgMember{OrgID: orgID, EntityID: userID, Type: "USER", Cascade: true})
+	require.NoError(t, err)
+	assert.Equal(t, Tuple{User: "user:" + userID.String(), Relation: "cascade_member", Object: "org:" + orgID.String()}, tuple)
+	assert.Equal(t, Tuple{User: "org:" + groupID.String(), Relation: "parent", Object: "org:" + orgID.String()},
+		OrgParentTuple(orgID, groupID))
+
+	tuple, err = RoleMemberTuple(models.RoleMember{RoleID: roleID, EntityID: groupID, Type: "GROUP"})
+	require.NoError(t, err)
+	assert.Equal(t, Tuple{User: "group:" + groupID.String() + "#member", Relation: "assignee", Object: "role:" + roleID.String()}, tuple)
+	tuple, err = RoleMemberTuple(models.RoleMember{RoleID: roleID, EntityID: orgID, Type: "ORG"})
+	require.NoError(t, err)
+	assert.Equal(t, Tuple{User: "org:" + orgID.String() + "#member", Relation: "assignee", Object: "role:" + roleID.String()}, tuple)
+	_, err = RoleMemberTuple(models.RoleMember{RoleID: roleID, EntityID: groupID, Type: "ROLE"})
+	assert.Error(t, err)
+
+	// Rows of unsupported types are skipped
+	assert.Len(t, RoleMemberTuples([]models.RoleMember{
+		{RoleID: roleID, EntityID: userID, Type: "USER"},
+		{RoleID: roleID, EntityID: groupID, Type: "ROLE"},
+	}), 1)
+}
+
+func TestDiffTuples(t *testing.T) {
+	kept := Tuple{User: "user:u1", Relation: "member", Object: "group:g1"}
+	added := Tuple{User: "user:u2", Relation: "member", Object: "group:g1"}
+	assigned := Tuple{User: "group:g1#member", Relation: "assignee", Object: "role:r1"}
+	removed := Tuple{User: "user:u3", Relation: "member", Object: "org:o1"}
+	unassigned := Tuple{User: "user:u3", Relation: "assignee", Object: "role:r1"}
+	admin := Tuple{User: "user:u1", Relation: "admin", Object: "system:idmapp"}
+	owner := Tuple{User: "user:u1", Relation: "owner", Object: "group:g1"}
+
+	missing, extra := diffTuples(
+		[]Tuple{kept, added, assigned, added},
+		[]Tuple{unassigned, kept, removed, admin, owner},
+	)
+	assert.Equal(t, []Tuple{assigned, added}, missing)
+	// Tuples that no membership table maps to are left alone
+	assert.Equal(t, []Tuple{unassigned, removed}, extra)
+
+	missing, extra = diffTuples(nil, nil)
+	assert.Empty(t, missing)
+	assert.Empty(t, extra)
+	assert.True(t, (&DriftReport{Missing: missing, Extra: extra}).InSync())
+}
+
+// fakeStore records the tuple changes applied to it
+type fakeStore struct {
+	applied []string
+}
+
+func (f *fakeStore) GrantPermission(user, relation, object string) error {
+	f.applied = append(f.applied, "write "+user+" "+relation+" "+object)
+	return nil
+}
+
+func (f *fakeStore) RevokePermission(user, relation, object string) error {
+	f.applied = append(f.applied, "delete "+user+" "+relation+" "+object)
+	return nil
+}
+
+func (f *fakeStore) ReadTuples(ctx context.Context) ([]Tuple, error) {
+	return nil, nil
+}
+
+func TestApplyChange(t *testing.T) {
+	store := &fakeStore{}
+	service := NewSyncService(nil, store)
+
+	require.NoError(t, service.apply(&TupleChange{Op: OpWrite, User: "user:u1", Relation: "member", Object: "group:g1"}))
+	require.NoError(t, service.apply(&TupleChange{Op: OpDelete, User: "user:u1", Relation: "member", Object: "group:g1"}))
+	assert.Equal(t, []string{"write user:u1 member group:g1", "delete user:u1 member group:g1"}, store.applied)
+
+	err := service.apply(&TupleChange{Op: "update"})
+	assert.ErrorIs(t, err, ErrRejected)
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, 5*time.Second, retryDelay(1))
+	assert.Equal(t, 10*time.Second, retryDelay(2))
+	assert.Equal(t, 40*time.Second, retryDelay(4))
+	assert.Equal(t, 5*time.Minute, retryDelay(maxAttempts))
+}
@@ -0,0 +1,58 @@
+package certification
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CampaignCreateRequest struct {
+	Name           string      `json:"name" binding:"required,max=255"`
+	Description    string      `json:"description"`
+	ScopeType      string      `json:"scopeType" binding:"required,oneof=role group org"`
+	ScopeIDs       []uuid.UUID `json:"scopeIds" binding:"required,min=1"`
+	Deadline       time.Time   `json:"deadline" binding:"required"`
+	RecurrenceDays int         `json:"recurrenceDays" binding:"min=0,max=3660"`
+}
+
+type DecisionRequest struct {
+	Decision string `json:"decision" binding:"required,oneof=certify revoke"`
+	Comment  string `json:"comment" binding:"max=2000"`
+}
+
+// ReportRow is a review item as it appears in the evidence report
+type ReportRow struct {
+	ResourceType string `json:"resourceType"`
+	ResourceID   string `json:"resourceId"`
+	ResourceName string `json:"resourceName"`
+	MemberType   string `json:"memberType"`
+	MemberID     string `json:"memberId"`
+	MemberName   string `json:"memberName"`
+	Decision     string `json:"decision"`
+	DecidedBy    string `json:"decidedBy,omitempty"`
+	DecidedAt    string `json:"decidedAt,omitempty"`
+	Comment      string `json:"comment,omitempty"`
+	AutoRevoked  bool   `json:"autoRevoked"`
+	RevokeError  string `json:"revokeError,omitempty"`
+}
+
+// Report is the evidence of a campaign: what was reviewed, by whom and with
+// what outcome
+type Report struct {
+	CampaignID  string      `json:"campaignId"`
+	Name        string      `json:"name"`
+	ScopeType   string      `json:"scopeType"`
+	ScopeIDs    []string    `json:"scopeIds"`
+	State       string      `json:"state"`
+	CreatedAt   string      `json:"createdAt"`
+	Deadline    string      `json:"deadline"`
+	CompletedAt string      `json:"completedAt,omitempty"`
+	GeneratedAt string      `json:"generatedAt"`
+	Items       []ReportRow `json:"items"`
+}
+
+// ReportKey is a certificate that verifies evidence reports
+type ReportKey struct {
+	KeyID       string `json:"kid"`
+	Certificate string `json:"certificate"`
+}
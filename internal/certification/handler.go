@@ -0,0 +1,183 @@
+package certification
+
+import (
+	"errors"
+	"net/http"
+
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type CertificationController struct {
+	service *CertificationService
+	logger  *logrus.Logger
+}
+
+func NewCertificationController(service *CertificationService) *CertificationController {
+	return &CertificationController{
+		service: service,
+		logger:  logrus.New(),
+	}
+}
+
+func (c *CertificationController) CreateCampaign(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	var req CampaignCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	campaign, err := c.service.WithContext(ctx).CreateCampaign(userID, req)
+	if err != nil {
+		c.handleError(ctx, "Failed to create campaign", err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, campaign)
+}
+
+// GetCampaigns lists the campaigns; pass state to filter them
+func (c *CertificationController) GetCampaigns(ctx *gin.Context) {
+	campaigns, err := c.service.WithContext(ctx).GetCampaigns(ctx.Query("state"))
+	if err != nil {
+		c.handleError(ctx, "Failed to get campaigns", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, campaigns)
+}
+
+// GetCampaign returns a campaign with its scope and progress
+func (c *CertificationController) GetCampaign(ctx *gin.Context) {
+	id, ok := parseID(ctx, "id", "campaign")
+	if !ok {
+		return
+	}
+	campaign, err := c.service.WithContext(ctx).GetCampaign(id)
+	if err != nil {
+		c.handleError(ctx, "Failed to get campaign", err)
+		return
+	}
+	if campaign == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrCampaignNotFound.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, campaign)
+}
+
+// GetItems lists the review items of a campaign; pass decision to filter
+// them
+func (c *CertificationController) GetItems(ctx *gin.Context) {
+	id, ok := parseID(ctx, "id", "campaign")
+	if !ok {
+		return
+	}
+	items, err := c.service.WithContext(ctx).GetItems(id, ctx.Query("decision"))
+	if err != nil {
+		c.handleError(ctx, "Failed to get review items", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, items)
+}
+
+// DecideItem certifies or revokes a review item
+func (c *CertificationController) DecideItem(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	campaignID, ok := parseID(ctx, "id", "campaign")
+	if !ok {
+		return
+	}
+	itemID, ok := parseID(ctx, "itemId", "review item")
+	if !ok {
+		return
+	}
+	var req DecisionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	item, err := c.service.WithContext(ctx).Decide(campaignID, itemID, userID, req)
+	if err != nil {
+		c.handleError(ctx, "Failed to decide review item", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, item)
+}
+
+// GetMyReviews lists the undecided items the signed-in user reviews
+func (c *CertificationController) GetMyReviews(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	items, err := c.service.WithContext(ctx).GetPendingReviews(userID)
+	if err != nil {
+		c.handleError(ctx, "Failed to get pending reviews", err)
+		return
+	}
+	ctx.JSON(http.StatusOK, items)
+}
+
+// GetReport returns the signed evidence report of a campaign, as JSON or
+// with format=csv as CSV. The signature and the key that made it are in
+// the X-Evidence-* headers.
+func (c *CertificationController) GetReport(ctx *gin.Context) {
+	id, ok := parseID(ctx, "id", "campaign")
+	if !ok {
+		return
+	}
+	format := ctx.DefaultQuery("format", FormatJSON)
+	report, err := c.service.WithContext(ctx).GetReport(id, format)
+	if err != nil {
+		c.handleError(ctx, "Failed to get evidence report", err)
+		return
+	}
+
+	ctx.Header("Content-Disposition", `attachment; filename="certification-`+id.String()+"."+format+`"`)
+	ctx.Header("Digest", report.Digest)
+	ctx.Header("X-Evidence-Signature", report.Signature)
+	ctx.Header("X-Evidence-Key-Id", report.KeyID)
+	ctx.Data(http.StatusOK, report.ContentType, report.Body)
+}
+
+// GetReportKeys returns the certificates that verify evidence reports
+func (c *CertificationController) GetReportKeys(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.service.GetReportKeys())
+}
+
+func (c *CertificationController) handleError(ctx *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, ErrCampaignNotFound), errors.Is(err, ErrItemNotFound), errors.Is(err, ErrResourceNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotReviewer), errors.Is(err, ErrSelfReview):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrCampaignClosed), errors.Is(err, ErrAlreadyDecided):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidDeadline), errors.Is(err, ErrInvalidFormat):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.logger.Errorf("%s: %v", message, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+func parseID(ctx *gin.Context, param, name string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(ctx.Param(param))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name + " ID"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
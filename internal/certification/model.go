@@ -0,0 +1,143 @@
+package certification
+
+import (
+	"time"
+
+	"idmapp-go/internal/tenant"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Scope types of campaigns
+const (
+	ScopeRole  = "role"
+	ScopeGroup = "group"
+	ScopeOrg   = "org"
+)
+
+// Campaign states
+const (
+	StateActive    = "active"
+	StateCompleted = "completed"
+)
+
+// Review decisions
+const (
+	DecisionPending   = "pending"
+	DecisionCertified = "certified"
+	DecisionRevoked   = "revoked"
+)
+
+// Campaign asks reviewers to certify or revoke every membership of a set of
+// roles, groups or orgs before a deadline. Memberships left undecided at the
+// deadline are revoked.
+type Campaign struct {
+	tenant.Scoped
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name        string    `json:"name" gorm:"not null"`
+	Description string    `json:"description"`
+	ScopeType   string    `json:"scopeType" gorm:"type:varchar(32);not null"`
+	State       string    `json:"state" gorm:"type:varchar(32);not null;index"`
+	Deadline    time.Time `json:"deadline" gorm:"not null;index"`
+	// RecurrenceDays starts a new campaign over the same scope that many
+	// days after this one started; zero runs it once
+	RecurrenceDays int        `json:"recurrenceDays" gorm:"not null;default:0"`
+	NextCampaignID *uuid.UUID `json:"nextCampaignId,omitempty" gorm:"type:uuid"`
+	CreatedBy      *uuid.UUID `json:"createdBy,omitempty" gorm:"type:uuid"`
+	CompletedAt    *time.Time `json:"completedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+	// ScopeIDs are the reviewed roles, groups or orgs
+	ScopeIDs []uuid.UUID `json:"scopeIds" gorm:"-"`
+	// Progress counts the items by decision
+	Progress map[string]int64 `json:"progress,omitempty" gorm:"-"`
+}
+
+func (c *Campaign) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+func (c *Campaign) TableName() string {
+	return "certification_campaigns"
+}
+
+// CampaignScope is a role, group or org reviewed by a campaign
+type CampaignScope struct {
+	tenant.Scoped
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CampaignID uuid.UUID `json:"campaignId" gorm:"type:uuid;not null;uniqueIndex:idx_certification_scopes_resource"`
+	ResourceID uuid.UUID `json:"resourceId" gorm:"type:uuid;not null;uniqueIndex:idx_certification_scopes_resource"`
+}
+
+func (s *CampaignScope) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+func (s *CampaignScope) TableName() string {
+	return "certification_scopes"
+}
+
+// Reviewer may decide the items of a resource of a campaign. Reviewers are
+// the users who manage the resource when the campaign starts: group owners,
+// org admins and system admins.
+type Reviewer struct {
+	tenant.Scoped
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CampaignID uuid.UUID `json:"campaignId" gorm:"type:uuid;not null;uniqueIndex:idx_certification_reviewers_user"`
+	ResourceID uuid.UUID `json:"resourceId" gorm:"type:uuid;not null;uniqueIndex:idx_certification_reviewers_user"`
+	UserID     uuid.UUID `json:"userId" gorm:"type:uuid;not null;uniqueIndex:idx_certification_reviewers_user;index"`
+}
+
+func (r *Reviewer) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (r *Reviewer) TableName() string {
+	return "certification_reviewers"
+}
+
+// ReviewItem is a membership of a reviewed role, group or org, and the
+// decision on it
+type ReviewItem struct {
+	tenant.Scoped
+	ID           uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CampaignID   uuid.UUID `json:"campaignId" gorm:"type:uuid;not null;index"`
+	ResourceType string    `json:"resourceType" gorm:"type:varchar(32);not null"`
+	ResourceID   uuid.UUID `json:"resourceId" gorm:"type:uuid;not null;index"`
+	// MemberType is USER, GROUP, ORG or ROLE as in the membership tables
+	MemberType string    `json:"memberType" gorm:"type:varchar(32);not null"`
+	MemberID   uuid.UUID `json:"memberId" gorm:"type:uuid;not null"`
+	// MembershipID is the row of members, nested_groups, role_members or
+	// org_members under review
+	MembershipID uuid.UUID  `json:"membershipId" gorm:"type:uuid;not null"`
+	ValidUntil   *time.Time `json:"validUntil,omitempty"`
+	Decision     string     `json:"decision" gorm:"type:varchar(32);not null;index"`
+	Comment      string     `json:"comment,omitempty" gorm:"type:text"`
+	DecidedBy    *uuid.UUID `json:"decidedBy,omitempty" gorm:"type:uuid"`
+	DecidedAt    *time.Time `json:"decidedAt,omitempty"`
+	// AutoRevoked items were left undecided at the deadline
+	AutoRevoked bool      `json:"autoRevoked" gorm:"not null;default:false"`
+	RevokeError string    `json:"revokeError,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+func (i *ReviewItem) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
+
+func (i *ReviewItem) TableName() string {
+	return "certification_items"
+}
@@ -0,0 +1,220 @@
+package certification
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"idmapp-go/internal/keyring"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Report formats
+const (
+	FormatJSON = "json"
+	FormatCSV  = "csv"
+)
+
+var ErrInvalidFormat = errors.New("format must be csv or json")
+
+// reportColumns are the columns of CSV reports
+var reportColumns = []string{
+	"resource_type", "resource_id", "resource_name", "member_type", "member_id", "member_name",
+	"decision", "decided_by", "decided_at", "comment", "auto_revoked", "revoke_error",
+}
+
+// SignedReport is an evidence report and its detached signature: the
+// base64 RSA PKCS #1 v1.5 signature of the SHA-256 digest of Body, made with
+// the key KeyID
+type SignedReport struct {
+	Body        []byte
+	ContentType string
+	Digest      string
+	Signature   string
+	KeyID       string
+}
+
+// GetReport renders the evidence report of a campaign in the format and
+// signs it
+func (s *CertificationService) GetReport(campaignID uuid.UUID, format string) (*SignedReport, error) {
+	if format != FormatJSON && format != FormatCSV {
+		return nil, ErrInvalidFormat
+	}
+	campaign, err := s.GetCampaign(campaignID)
+	if err != nil {
+		return nil, err
+	}
+	if campaign == nil {
+		return nil, ErrCampaignNotFound
+	}
+	items, err := s.GetItems(campaignID, "")
+	if err != nil {
+		return nil, err
+	}
+	report, err := buildReport(s.db, campaign, items, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	signed := &SignedReport{ContentType: "application/json"}
+	if format == FormatCSV {
+		signed.ContentType = "text/csv"
+		signed.Body, err = renderCSV(report)
+	} else {
+		signed.Body, err = json.MarshalIndent(report, "", "  ")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to render report: %w", err)
+	}
+	if err := sign(signed, s.keys.Active()); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+func buildReport(db *gorm.DB, campaign *Campaign, items []ReviewItem, now time.Time) (*Report, error) {
+	ids := map[string][]uuid.UUID{campaign.ScopeType: campaign.ScopeIDs}
+	for _, item := range items {
+		memberType := objectType(item.MemberType)
+		ids[memberType] = append(ids[memberType], item.MemberID)
+	}
+	names := make(map[string]map[uuid.UUID]string)
+	for objectType, objectIDs := range ids {
+		named, err := lookupNames(db, objectType, objectIDs)
+		if err != nil {
+			return nil, err
+		}
+		names[objectType] = named
+	}
+
+	report := &Report{
+		CampaignID:  campaign.ID.String(),
+		Name:        campaign.Name,
+		ScopeType:   campaign.ScopeType,
+		ScopeIDs:    make([]string, len(campaign.ScopeIDs)),
+		State:       campaign.State,
+		CreatedAt:   campaign.CreatedAt.UTC().Format(time.RFC3339),
+		Deadline:    campaign.Deadline.UTC().Format(time.RFC3339),
+		GeneratedAt: now.UTC().Format(time.RFC3339),
+		Items:       make([]ReportRow, len(items)),
+	}
+	for i, id := range campaign.ScopeIDs {
+		report.ScopeIDs[i] = id.String()
+	}
+	if campaign.CompletedAt != nil {
+		report.CompletedAt = campaign.CompletedAt.UTC().Format(time.RFC3339)
+	}
+	for i, item := range items {
+		row := ReportRow{
+			ResourceType: item.ResourceType,
+			ResourceID:   item.ResourceID.String(),
+			ResourceName: names[item.ResourceType][item.ResourceID],
+			MemberType:   item.MemberType,
+			MemberID:     item.MemberID.String(),
+			MemberName:   names[objectType(item.MemberType)][item.MemberID],
+			Decision:     item.Decision,
+			Comment:      item.Comment,
+			AutoRevoked:  item.AutoRevoked,
+			RevokeError:  item.RevokeError,
+		}
+		if item.DecidedBy != nil {
+			row.DecidedBy = item.DecidedBy.String()
+		}
+		if item.DecidedAt != nil {
+			row.DecidedAt = item.DecidedAt.UTC().Format(time.RFC3339)
+		}
+		report.Items[i] = row
+	}
+	return report, nil
+}
+
+func renderCSV(report *Report) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(reportColumns); err != nil {
+		return nil, err
+	}
+	for _, row := range report.Items {
+		if err := w.Write([]string{
+			row.ResourceType, row.ResourceID, row.ResourceName, row.MemberType, row.MemberID, row.MemberName,
+			row.Decision, row.DecidedBy, row.DecidedAt, row.Comment, strconv.FormatBool(row.AutoRevoked), row.RevokeError,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func sign(report *SignedReport, key *keyring.Key) error {
+	digest := sha256.Sum256(report.Body)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return fmt.Errorf("failed to sign report: %w", err)
+	}
+	report.Digest = "SHA-256=" + base64.StdEncoding.EncodeToString(digest[:])
+	report.Signature = base64.StdEncoding.EncodeToString(signature)
+	report.KeyID = key.ID
+	return nil
+}
+
+// GetReportKeys returns the certificates that verify evidence reports
+func (s *CertificationService) GetReportKeys() []ReportKey {
+	var keys []ReportKey
+	for _, key := range s.keys.Keys() {
+		keys = append(keys, ReportKey{
+			KeyID:       key.ID,
+			Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: key.Certificate.Raw})),
+		})
+	}
+	return keys
+}
+
+// objectType maps a member type of the membership tables to its object type
+func objectType(memberType string) string {
+	switch memberType {
+	case "GROUP":
+		return ScopeGroup
+	case "ROLE":
+		return ScopeRole
+	case "ORG":
+		return ScopeOrg
+	}
+	return "user"
+}
+
+// lookupNames maps the IDs of users, groups, roles or orgs to their names;
+// users are named by email
+func lookupNames(db *gorm.DB, objectType string, ids []uuid.UUID) (map[uuid.UUID]string, error) {
+	named := make(map[uuid.UUID]string)
+	if len(ids) == 0 {
+		return named, nil
+	}
+	table, column := objectType+"s", "name"
+	if objectType == "user" {
+		column = "email"
+	}
+	var rows []struct {
+		ID   uuid.UUID
+		Name string
+	}
+	if err := db.Table(table).Select("id, "+column+" AS name").Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get %s names: %w", objectType, err)
+	}
+	for _, row := range rows {
+		named[row.ID] = row.Name
+	}
+	return named, nil
+}
@@ -0,0 +1,82 @@
+package certification
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"idmapp-go/internal/keyring"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestBuildReport(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "certification.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	for _, ddl := range []string{
+		"CREATE TABLE users (id TEXT PRIMARY KEY, email TEXT)",
+		"CREATE TABLE groups (id TEXT PRIMARY KEY, name TEXT)",
+		"CREATE TABLE roles (id TEXT PRIMARY KEY, name TEXT)",
+	} {
+		require.NoError(t, db.Exec(ddl).Error)
+	}
+	approver, jane, staff, reviewer := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	require.NoError(t, db.Exec("INSERT INTO roles (id, name) VALUES (?, ?)", approver, "payments-approver").Error)
+	require.NoError(t, db.Exec("INSERT INTO users (id, email) VALUES (?, ?)", jane, "jane@example.com").Error)
+	require.NoError(t, db.Exec("INSERT INTO groups (id, name) VALUES (?, ?)", staff, "staff").Error)
+
+	created := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	decided := created.Add(24 * time.Hour)
+	campaign := &Campaign{ID: uuid.New(), Name: "Q1", ScopeType: ScopeRole, State: StateCompleted,
+		CreatedAt: created, Deadline: created.Add(30 * 24 * time.Hour), ScopeIDs: []uuid.UUID{approver}}
+	items := []ReviewItem{
+		{ResourceType: ScopeRole, ResourceID: approver, MemberType: "USER", MemberID: jane,
+			Decision: DecisionCertified, DecidedBy: &reviewer, DecidedAt: &decided, Comment: "still on the team, \"ok\""},
+		{ResourceType: ScopeRole, ResourceID: approver, MemberType: "GROUP", MemberID: staff,
+			Decision: DecisionRevoked, DecidedAt: &decided, AutoRevoked: true},
+	}
+
+	report, err := buildReport(db, campaign, items, decided)
+	require.NoError(t, err)
+	assert.Equal(t, []string{approver.String()}, report.ScopeIDs)
+	require.Len(t, report.Items, 2)
+	assert.Equal(t, "payments-approver", report.Items[0].ResourceName)
+	assert.Equal(t, "jane@example.com", report.Items[0].MemberName)
+	assert.Equal(t, "staff", report.Items[1].MemberName)
+	assert.Equal(t, "2026-01-02T09:00:00Z", report.Items[1].DecidedAt)
+
+	body, err := renderCSV(report)
+	require.NoError(t, err)
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, reportColumns, records[0])
+	assert.Equal(t, `still on the team, "ok"`, records[1][9])
+	assert.Equal(t, "true", records[2][10])
+
+	// The signature verifies against the published certificate
+	key, err := keyring.Generate("evidence", created, time.Hour)
+	require.NoError(t, err)
+	signed := &SignedReport{Body: body}
+	require.NoError(t, sign(signed, key))
+	assert.Equal(t, "evidence", signed.KeyID)
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	require.NoError(t, err)
+	digest := sha256.Sum256(body)
+	publicKey := key.Certificate.PublicKey.(*rsa.PublicKey)
+	assert.NoError(t, rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature))
+	assert.Error(t, rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, make([]byte, sha256.Size), signature))
+}
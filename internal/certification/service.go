@@ -0,0 +1,641 @@
+package certification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/keyring"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/middleware"
+	"idmapp-go/models"
+	"idmapp-go/services"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCampaignNotFound = errors.New("campaign not found")
+	ErrItemNotFound     = errors.New("review item not found")
+	ErrResourceNotFound = errors.New("role, group or org not found")
+	ErrInvalidDeadline  = errors.New("deadline must be in the future")
+	ErrCampaignClosed   = errors.New("campaign is completed")
+	ErrAlreadyDecided   = errors.New("review item is already decided")
+	ErrNotReviewer      = errors.New("not a reviewer of this item")
+	ErrSelfReview       = errors.New("reviewers can't certify their own access")
+)
+
+// CertificationService runs access certification campaigns. Revoked
+// memberships are removed through the member services, so their tuples and
+// events follow as for any removal.
+type CertificationService struct {
+	db          *gorm.DB
+	authorizer  authz.Authorizer
+	members     *member.MemberService
+	roleMembers *services.RoleMemberService
+	orgMembers  *services.OrgMemberService
+	keys        *keyring.KeyRing
+	logger      *logrus.Logger
+}
+
+// NewCertificationService returns the service. The authorizer lists the
+// reviewers of each resource and tells system admins; without one route
+// authorization is disabled and anyone may review. Evidence reports are
+// signed with the active key of keys.
+func NewCertificationService(db *gorm.DB, authorizer authz.Authorizer, members *member.MemberService, roleMembers *services.RoleMemberService, orgMembers *services.OrgMemberService, keys *keyring.KeyRing) *CertificationService {
+	return &CertificationService{
+		db:          db,
+		authorizer:  authorizer,
+		members:     members,
+		roleMembers: roleMembers,
+		orgMembers:  orgMembers,
+		keys:        keys,
+		logger:      logrus.New(),
+	}
+}
+
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *CertificationService) WithContext(ctx context.Context) *CertificationService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
+func (s *CertificationService) ctx() context.Context {
+	return s.db.Statement.Context
+}
+
+// CreateCampaign starts a campaign over the current memberships of the
+// roles, groups or orgs
+func (s *CertificationService) CreateCampaign(createdBy uuid.UUID, req CampaignCreateRequest) (*Campaign, error) {
+	now := time.Now()
+	if !req.Deadline.After(now) {
+		return nil, ErrInvalidDeadline
+	}
+	campaign := &Campaign{
+		Name:           req.Name,
+		Description:    req.Description,
+		ScopeType:      req.ScopeType,
+		State:          StateActive,
+		Deadline:       req.Deadline,
+		RecurrenceDays: req.RecurrenceDays,
+		CreatedBy:      &createdBy,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		ScopeIDs:       unique(req.ScopeIDs),
+	}
+	for _, id := range campaign.ScopeIDs {
+		if err := checkResource(s.db, req.ScopeType, id); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.launch(campaign, nil); err != nil {
+		return nil, err
+	}
+	return s.GetCampaign(campaign.ID)
+}
+
+// launch stores the campaign with its scope, reviewers and items. before,
+// if set, runs first in the same transaction.
+func (s *CertificationService) launch(campaign *Campaign, before func(tx *gorm.DB) error) error {
+	reviewers, err := s.listReviewers(campaign.ScopeType, campaign.ScopeIDs)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if before != nil {
+			if err := before(tx); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(campaign).Error; err != nil {
+			return fmt.Errorf("failed to create campaign: %w", err)
+		}
+
+		var rows []interface{}
+		for _, id := range campaign.ScopeIDs {
+			scope := CampaignScope{CampaignID: campaign.ID, ResourceID: id}
+			scope.TenantID = campaign.TenantID
+			rows = append(rows, &scope)
+			for _, userID := range reviewers[id] {
+				reviewer := Reviewer{CampaignID: campaign.ID, ResourceID: id, UserID: userID}
+				reviewer.TenantID = campaign.TenantID
+				rows = append(rows, &reviewer)
+			}
+		}
+		items, err := generateItems(tx, campaign)
+		if err != nil {
+			return err
+		}
+		for i := range items {
+			rows = append(rows, &items[i])
+		}
+		for _, row := range rows {
+			if err := tx.Create(row).Error; err != nil {
+				return fmt.Errorf("failed to create campaign: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	events.Publish(events.Event{
+		Type:    "certification.started",
+		Subject: campaign.ID.String(),
+		Data:    map[string]interface{}{"name": campaign.Name, "scopeType": campaign.ScopeType},
+	})
+	return nil
+}
+
+// generateItems reviews every membership of the campaign's roles, groups or
+// orgs: the users and groups in a group, and the users, groups, orgs and
+// roles assigned a role or in an org
+func generateItems(tx *gorm.DB, campaign *Campaign) ([]ReviewItem, error) {
+	var items []ReviewItem
+	add := func(resourceID uuid.UUID, memberType string, memberID, membershipID uuid.UUID, validUntil *time.Time) {
+		item := ReviewItem{
+			CampaignID:   campaign.ID,
+			ResourceType: campaign.ScopeType,
+			ResourceID:   resourceID,
+			MemberType:   strings.ToUpper(memberType),
+			MemberID:     memberID,
+			MembershipID: membershipID,
+			ValidUntil:   validUntil,
+			Decision:     DecisionPending,
+			CreatedAt:    campaign.CreatedAt,
+		}
+		item.TenantID = campaign.TenantID
+		items = append(items, item)
+	}
+	if len(campaign.ScopeIDs) == 0 {
+		return items, nil
+	}
+
+	switch campaign.ScopeType {
+	case ScopeRole:
+		var roleMembers []models.RoleMember
+		if err := tx.Where("role_id IN ?", campaign.ScopeIDs).Order("created_at").Find(&roleMembers).Error; err != nil {
+			return nil, fmt.Errorf("failed to get role members: %w", err)
+		}
+		for _, m := range roleMembers {
+			add(m.RoleID, m.Type, m.EntityID, m.ID, m.ValidUntil)
+		}
+	case ScopeGroup:
+		var members []member.Member
+		if err := tx.Where("group_id IN ?", campaign.ScopeIDs).Order("created_at").Find(&members).Error; err != nil {
+			return nil, fmt.Errorf("failed to get group members: %w", err)
+		}
+		for _, m := range members {
+			add(m.GroupID, "USER", m.UserID, m.ID, m.ValidUntil)
+		}
+		var nested []member.NestedGroup
+		if err := tx.Where("group_id IN ?", campaign.ScopeIDs).Order("created_at").Find(&nested).Error; err != nil {
+			return nil, fmt.Errorf("failed to get nested groups: %w", err)
+		}
+		for _, n := range nested {
+			add(n.GroupID, "GROUP", n.MemberGroupID, n.ID, nil)
+		}
+	case ScopeOrg:
+		var orgMembers []models.OrgMember
+		if err := tx.Where("org_id IN ?", campaign.ScopeIDs).Order("created_at").Find(&orgMembers).Error; err != nil {
+			return nil, fmt.Errorf("failed to get org members: %w", err)
+		}
+		for _, m := range orgMembers {
+			add(m.OrgID, m.Type, m.EntityID, m.ID, nil)
+		}
+	}
+	return items, nil
+}
+
+// listReviewers returns the users who manage each resource: group owners,
+// org admins and system admins
+func (s *CertificationService) listReviewers(scopeType string, ids []uuid.UUID) (map[uuid.UUID][]uuid.UUID, error) {
+	reviewers := make(map[uuid.UUID][]uuid.UUID)
+	if s.authorizer == nil {
+		return reviewers, nil
+	}
+	for _, id := range ids {
+		users, err := s.authorizer.ListUsers(s.ctx(), scopeType+":"+id.String(), middleware.RelationManage, "user")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list reviewers: %w", err)
+		}
+		for _, user := range users {
+			if userID, err := uuid.Parse(strings.TrimPrefix(user, "user:")); err == nil {
+				reviewers[id] = append(reviewers[id], userID)
+			}
+		}
+	}
+	return reviewers, nil
+}
+
+// checkResource returns ErrResourceNotFound unless the role, group or org
+// exists in the tenant
+func checkResource(db *gorm.DB, objectType string, id uuid.UUID) error {
+	owner, err := tenant.Of(db, objectType, id)
+	if err != nil {
+		return err
+	}
+	owned, err := tenant.Owns(db, objectType, id)
+	if err != nil {
+		return err
+	}
+	if owner == uuid.Nil || !owned {
+		return fmt.Errorf("%s %s: %w", objectType, id, ErrResourceNotFound)
+	}
+	return nil
+}
+
+// GetCampaign returns the campaign with its scope and progress, or nil if
+// there is none
+func (s *CertificationService) GetCampaign(id uuid.UUID) (*Campaign, error) {
+	var campaign Campaign
+	result := s.db.First(&campaign, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get campaign: %w", result.Error)
+	}
+	if err := s.db.Model(&CampaignScope{}).Where("campaign_id = ?", id).Pluck("resource_id", &campaign.ScopeIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get campaign scope: %w", err)
+	}
+
+	var counts []struct {
+		Decision string
+		Count    int64
+	}
+	if err := s.db.Model(&ReviewItem{}).Select("decision, COUNT(*) AS count").
+		Where("campaign_id = ?", id).Group("decision").Find(&counts).Error; err != nil {
+		return nil, fmt.Errorf("failed to get campaign progress: %w", err)
+	}
+	campaign.Progress = map[string]int64{DecisionPending: 0, DecisionCertified: 0, DecisionRevoked: 0}
+	for _, c := range counts {
+		campaign.Progress[c.Decision] = c.Count
+	}
+	return &campaign, nil
+}
+
+// GetCampaigns lists the campaigns, newest first, optionally only those in
+// a state
+func (s *CertificationService) GetCampaigns(state string) ([]Campaign, error) {
+	query := s.db.Order("created_at DESC")
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	var campaigns []Campaign
+	if err := query.Find(&campaigns).Error; err != nil {
+		return nil, fmt.Errorf("failed to get campaigns: %w", err)
+	}
+	return campaigns, nil
+}
+
+// GetItems lists the items of a campaign, optionally only those with a
+// decision
+func (s *CertificationService) GetItems(campaignID uuid.UUID, decision string) ([]ReviewItem, error) {
+	query := s.db.Where("campaign_id = ?", campaignID).Order("resource_id, created_at, id")
+	if decision != "" {
+		query = query.Where("decision = ?", decision)
+	}
+	var items []ReviewItem
+	if err := query.Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("failed to get review items: %w", err)
+	}
+	return items, nil
+}
+
+// GetPendingReviews lists the undecided items of active campaigns the user
+// reviews, leaving out their own access
+func (s *CertificationService) GetPendingReviews(userID uuid.UUID) ([]ReviewItem, error) {
+	var items []ReviewItem
+	err := s.db.
+		Joins("JOIN certification_reviewers r ON r.campaign_id = certification_items.campaign_id AND r.resource_id = certification_items.resource_id").
+		Joins("JOIN certification_campaigns c ON c.id = certification_items.campaign_id").
+		Where("r.user_id = ? AND c.state = ? AND certification_items.decision = ?", userID, StateActive, DecisionPending).
+		Where("NOT (certification_items.member_type = 'USER' AND certification_items.member_id = ?)", userID).
+		Order("c.deadline, certification_items.created_at").
+		Find(&items).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending reviews: %w", err)
+	}
+	return items, nil
+}
+
+// Decide certifies or revokes a review item. Revoking removes the
+// membership right away.
+func (s *CertificationService) Decide(campaignID, itemID, reviewerID uuid.UUID, req DecisionRequest) (*ReviewItem, error) {
+	var item ReviewItem
+	result := s.db.First(&item, "id = ? AND campaign_id = ?", itemID, campaignID)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrItemNotFound
+		}
+		return nil, fmt.Errorf("failed to get review item: %w", result.Error)
+	}
+	var campaign Campaign
+	if err := s.db.First(&campaign, "id = ?", campaignID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get campaign: %w", err)
+	}
+	if campaign.State != StateActive {
+		return nil, ErrCampaignClosed
+	}
+	if item.Decision != DecisionPending {
+		return nil, ErrAlreadyDecided
+	}
+	if item.MemberType == "USER" && item.MemberID == reviewerID {
+		return nil, ErrSelfReview
+	}
+	allowed, err := s.canReview(item, reviewerID)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrNotReviewer
+	}
+
+	decision := DecisionCertified
+	if req.Decision == "revoke" {
+		decision = DecisionRevoked
+	}
+	now := time.Now()
+	claimed, err := s.claim(&item, map[string]interface{}{
+		"decision":   decision,
+		"comment":    req.Comment,
+		"decided_by": reviewerID,
+		"decided_at": now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !claimed {
+		return nil, ErrAlreadyDecided
+	}
+	item.Decision, item.Comment, item.DecidedBy, item.DecidedAt = decision, req.Comment, &reviewerID, &now
+	if decision == DecisionRevoked {
+		s.revoke(&item)
+	}
+
+	events.Publish(events.Event{
+		Type:    "certification.item_" + decision,
+		Subject: campaignID.String(),
+		Data: map[string]interface{}{
+			"itemId":       item.ID.String(),
+			"resourceType": item.ResourceType,
+			"resourceId":   item.ResourceID.String(),
+			"memberType":   item.MemberType,
+			"memberId":     item.MemberID.String(),
+		},
+	})
+	if err := s.completeIfDone(campaign); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// canReview reports whether the user was made a reviewer of the item's
+// resource or is a system admin
+func (s *CertificationService) canReview(item ReviewItem, userID uuid.UUID) (bool, error) {
+	if s.authorizer == nil {
+		return true, nil
+	}
+	var count int64
+	if err := s.db.Model(&Reviewer{}).
+		Where("campaign_id = ? AND resource_id = ? AND user_id = ?", item.CampaignID, item.ResourceID, userID).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check reviewer: %w", err)
+	}
+	if count > 0 {
+		return true, nil
+	}
+	admin, err := s.authorizer.Check(s.ctx(), "user:"+userID.String(), middleware.RelationManage, middleware.SystemObject)
+	if err != nil {
+		return false, fmt.Errorf("failed to check reviewer: %w", err)
+	}
+	return admin, nil
+}
+
+// claim records the decision on a pending item and reports whether it was
+// still pending
+func (s *CertificationService) claim(item *ReviewItem, updates map[string]interface{}) (bool, error) {
+	result := s.db.Model(&ReviewItem{}).Where("id = ? AND decision = ?", item.ID, DecisionPending).Updates(updates)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to decide review item: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// revoke removes the membership of a revoked item. Memberships removed since
+// the campaign started are left alone; failures are recorded on the item.
+func (s *CertificationService) revoke(item *ReviewItem) {
+	table := membershipTable(item.ResourceType, item.MemberType)
+	var count int64
+	err := s.db.Table(table).Where("id = ?", item.MembershipID).Count(&count).Error
+	if err == nil && count > 0 {
+		switch {
+		case item.ResourceType == ScopeGroup && item.MemberType == "GROUP":
+			err = s.members.WithContext(s.ctx()).RemoveNestedGroup(item.ResourceID, item.MemberID)
+		case item.ResourceType == ScopeGroup:
+			err = s.members.WithContext(s.ctx()).RemoveMember(item.ResourceID, item.MemberID)
+		case item.ResourceType == ScopeRole:
+			_, err = s.roleMembers.WithContext(s.ctx()).RemoveMember(item.ResourceID, item.MemberID)
+		case item.ResourceType == ScopeOrg:
+			_, err = s.orgMembers.WithContext(s.ctx()).RemoveMember(item.ResourceID, item.MemberID)
+		}
+	}
+	if err == nil {
+		return
+	}
+
+	s.logger.Errorf("Failed to revoke %s %s of %s %s: %v", item.MemberType, item.MemberID, item.ResourceType, item.ResourceID, err)
+	item.RevokeError = err.Error()
+	if err := s.db.Model(&ReviewItem{}).Where("id = ?", item.ID).Update("revoke_error", item.RevokeError).Error; err != nil {
+		s.logger.Errorf("Failed to record revocation failure of review item %s: %v", item.ID, err)
+	}
+}
+
+// membershipTable is the table holding the memberships of the resource and
+// member types
+func membershipTable(resourceType, memberType string) string {
+	switch resourceType {
+	case ScopeRole:
+		return "role_members"
+	case ScopeOrg:
+		return "org_members"
+	}
+	if memberType == "GROUP" {
+		return "nested_groups"
+	}
+	return "members"
+}
+
+// completeIfDone completes an active campaign without pending items
+func (s *CertificationService) completeIfDone(campaign Campaign) error {
+	var pending int64
+	if err := s.db.Model(&ReviewItem{}).Where("campaign_id = ? AND decision = ?", campaign.ID, DecisionPending).Count(&pending).Error; err != nil {
+		return fmt.Errorf("failed to check campaign progress: %w", err)
+	}
+	if pending > 0 {
+		return nil
+	}
+
+	now := time.Now()
+	result := s.db.Model(&Campaign{}).Where("id = ? AND state = ?", campaign.ID, StateActive).
+		Updates(map[string]interface{}{"state": StateCompleted, "completed_at": now, "updated_at": now})
+	if result.Error != nil {
+		return fmt.Errorf("failed to complete campaign: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		events.Publish(events.Event{
+			Type:    "certification.completed",
+			Subject: campaign.ID.String(),
+			Data:    map[string]interface{}{"name": campaign.Name},
+		})
+	}
+	return nil
+}
+
+// ProcessDue revokes the undecided items of campaigns past their deadline,
+// completing them, and starts the next run of recurring campaigns. Items and
+// campaigns are claimed by the statements that change them, so several
+// instances can run it. It runs across tenants, so it must be called on a
+// service without a tenant.
+func (s *CertificationService) ProcessDue() (int, error) {
+	now := time.Now()
+	var due []Campaign
+	if err := s.db.Where("state = ? AND deadline <= ?", StateActive, now).Find(&due).Error; err != nil {
+		return 0, fmt.Errorf("failed to get due campaigns: %w", err)
+	}
+	processed := 0
+	for _, campaign := range due {
+		var pending []ReviewItem
+		if err := s.db.Where("campaign_id = ? AND decision = ?", campaign.ID, DecisionPending).Find(&pending).Error; err != nil {
+			return processed, fmt.Errorf("failed to get pending review items: %w", err)
+		}
+		for i := range pending {
+			claimed, err := s.claim(&pending[i], map[string]interface{}{
+				"decision":     DecisionRevoked,
+				"auto_revoked": true,
+				"decided_at":   now,
+			})
+			if err != nil {
+				return processed, err
+			}
+			if claimed {
+				s.revoke(&pending[i])
+			}
+		}
+		if err := s.completeIfDone(campaign); err != nil {
+			return processed, err
+		}
+		processed++
+	}
+
+	var recurring []Campaign
+	if err := s.db.Where("state = ? AND recurrence_days > 0 AND next_campaign_id IS NULL", StateCompleted).Find(&recurring).Error; err != nil {
+		return processed, fmt.Errorf("failed to get recurring campaigns: %w", err)
+	}
+	for _, campaign := range recurring {
+		if campaign.CreatedAt.AddDate(0, 0, campaign.RecurrenceDays).After(now) {
+			continue
+		}
+		if err := s.startNext(campaign, now); err != nil {
+			if errors.Is(err, errAlreadyStarted) {
+				continue
+			}
+			return processed, err
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// startNext starts the next run of a recurring campaign over the resources
+// of its scope that still exist, with as long to review
+func (s *CertificationService) startNext(previous Campaign, now time.Time) error {
+	var scope []uuid.UUID
+	if err := s.db.Model(&CampaignScope{}).Where("campaign_id = ?", previous.ID).Pluck("resource_id", &scope).Error; err != nil {
+		return fmt.Errorf("failed to get campaign scope: %w", err)
+	}
+	var existing []uuid.UUID
+	for _, id := range scope {
+		owner, err := tenant.Of(s.db, previous.ScopeType, id)
+		if err != nil {
+			return err
+		}
+		if owner != uuid.Nil {
+			existing = append(existing, id)
+		}
+	}
+
+	next := &Campaign{
+		ID:             uuid.New(),
+		Name:           previous.Name,
+		Description:    previous.Description,
+		ScopeType:      previous.ScopeType,
+		State:          StateActive,
+		Deadline:       now.Add(previous.Deadline.Sub(previous.CreatedAt)),
+		RecurrenceDays: previous.RecurrenceDays,
+		CreatedBy:      previous.CreatedBy,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		ScopeIDs:       existing,
+	}
+	next.TenantID = previous.TenantID
+	return s.launch(next, func(tx *gorm.DB) error {
+		result := tx.Model(&Campaign{}).Where("id = ? AND next_campaign_id IS NULL", previous.ID).
+			Updates(map[string]interface{}{"next_campaign_id": next.ID, "updated_at": now})
+		if result.Error != nil {
+			return fmt.Errorf("failed to link recurring campaign: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return errAlreadyStarted
+		}
+		return nil
+	})
+}
+
+// errAlreadyStarted rolls back the start of a run another instance started
+var errAlreadyStarted = errors.New("next campaign already started")
+
+// RunScheduler processes due campaigns every interval until ctx is done
+func (s *CertificationService) RunScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			processed, err := s.ProcessDue()
+			if err != nil {
+				s.logger.Errorf("Certification scheduler run failed: %v", err)
+			} else if processed > 0 {
+				s.logger.Infof("Certification scheduler processed %d campaigns", processed)
+			}
+		}
+	}
+}
+
+func unique(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool)
+	var out []uuid.UUID
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
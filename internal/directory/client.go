@@ -0,0 +1,215 @@
+package directory
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/google/uuid"
+)
+
+// requestTimeout bounds every LDAP operation
+const requestTimeout = 30 * time.Second
+
+// uacAccountDisable is the ACCOUNTDISABLE flag of Active Directory's
+// userAccountControl attribute
+const uacAccountDisable = 0x2
+
+var ErrInvalidCredentials = errors.New("invalid directory credentials")
+
+// Entry is a user read from the directory
+type Entry struct {
+	DN         string `json:"dn"`
+	ExternalID string `json:"externalId"`
+	Email      string `json:"email"`
+	Name       string `json:"name"`
+	FirstName  string `json:"firstName"`
+	LastName   string `json:"lastName"`
+	Disabled   bool   `json:"disabled"`
+}
+
+// GroupEntry is a group read from the directory
+type GroupEntry struct {
+	DN          string   `json:"dn"`
+	ExternalID  string   `json:"externalId"`
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName"`
+	Description string   `json:"description"`
+	Members     []string `json:"-"`
+}
+
+// Snapshot is the content of the directory at the start of a sync
+type Snapshot struct {
+	Users  []Entry
+	Groups []GroupEntry
+}
+
+type Client struct {
+	config Config
+}
+
+func NewClient(config Config) *Client {
+	return &Client{config: config}
+}
+
+// Bind checks a user's password by binding as them
+func (c *Client) Bind(dn, password string) error {
+	// Most servers treat a bind with an empty password as an anonymous bind
+	// that always succeeds
+	if dn == "" || password == "" {
+		return ErrInvalidCredentials
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Bind(dn, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return ErrInvalidCredentials
+		}
+		return fmt.Errorf("failed to bind to directory: %w", err)
+	}
+	return nil
+}
+
+// Fetch reads every user and group matching the configured filters
+func (c *Client) Fetch() (*Snapshot, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := c.bindServiceAccount(conn); err != nil {
+		return nil, err
+	}
+
+	userAttributes := c.config.Users.Attributes
+	attributes := []string{userAttributes.ID, userAttributes.Email, userAttributes.Name, userAttributes.FirstName, userAttributes.LastName}
+	if c.config.Type == TypeActiveDirectory {
+		attributes = append(attributes, "userAccountControl")
+	}
+	userResult, err := c.search(conn, c.config.Users.BaseDN, c.config.Users.Filter, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search directory users: %w", err)
+	}
+
+	groupAttributes := c.config.Groups.Attributes
+	attributes = []string{groupAttributes.ID, groupAttributes.Name, groupAttributes.DisplayName, groupAttributes.Description, groupAttributes.Member}
+	groupResult, err := c.search(conn, c.config.Groups.BaseDN, c.config.Groups.Filter, attributes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search directory groups: %w", err)
+	}
+
+	snapshot := &Snapshot{}
+	for _, entry := range userResult.Entries {
+		snapshot.Users = append(snapshot.Users, Entry{
+			DN:         entry.DN,
+			ExternalID: externalID(entry, userAttributes.ID),
+			Email:      strings.TrimSpace(entry.GetAttributeValue(userAttributes.Email)),
+			Name:       entry.GetAttributeValue(userAttributes.Name),
+			FirstName:  entry.GetAttributeValue(userAttributes.FirstName),
+			LastName:   entry.GetAttributeValue(userAttributes.LastName),
+			Disabled:   c.disabled(entry),
+		})
+	}
+	for _, entry := range groupResult.Entries {
+		snapshot.Groups = append(snapshot.Groups, GroupEntry{
+			DN:          entry.DN,
+			ExternalID:  externalID(entry, groupAttributes.ID),
+			Name:        entry.GetAttributeValue(groupAttributes.Name),
+			DisplayName: entry.GetAttributeValue(groupAttributes.DisplayName),
+			Description: entry.GetAttributeValue(groupAttributes.Description),
+			Members:     entry.GetAttributeValues(groupAttributes.Member),
+		})
+	}
+	return snapshot, nil
+}
+
+func (c *Client) dial() (*ldap.Conn, error) {
+	serverURL, err := url.Parse(c.config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid directory URL: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         serverURL.Hostname(),
+		InsecureSkipVerify: c.config.InsecureSkipVerify,
+	}
+
+	conn, err := ldap.DialURL(c.config.URL, ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to directory: %w", err)
+	}
+	conn.SetTimeout(requestTimeout)
+	if c.config.StartTLS && serverURL.Scheme == "ldap" {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS with directory: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *Client) bindServiceAccount(conn *ldap.Conn) error {
+	if c.config.BindDN == "" {
+		if err := conn.UnauthenticatedBind(""); err != nil {
+			return fmt.Errorf("failed to bind to directory anonymously: %w", err)
+		}
+		return nil
+	}
+	if err := conn.Bind(c.config.BindDN, c.config.BindPassword); err != nil {
+		return fmt.Errorf("failed to bind to directory as %s: %w", c.config.BindDN, err)
+	}
+	return nil
+}
+
+func (c *Client) search(conn *ldap.Conn, baseDN, filter string, attributes []string) (*ldap.SearchResult, error) {
+	request := ldap.NewSearchRequest(
+		baseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		0,
+		int(requestTimeout.Seconds()),
+		false,
+		filter,
+		attributes,
+		nil,
+	)
+	return conn.SearchWithPaging(request, uint32(c.config.PageSize))
+}
+
+func (c *Client) disabled(entry *ldap.Entry) bool {
+	if c.config.Type != TypeActiveDirectory {
+		return false
+	}
+	flags, err := strconv.ParseInt(entry.GetAttributeValue("userAccountControl"), 10, 64)
+	return err == nil && flags&uacAccountDisable != 0
+}
+
+// externalID returns the stable identifier of an entry. Active Directory's
+// binary objectGUID is formatted the way AD tools display it.
+func externalID(entry *ldap.Entry, attribute string) string {
+	if strings.EqualFold(attribute, "objectGUID") {
+		raw := entry.GetRawAttributeValue(attribute)
+		if len(raw) != 16 {
+			return ""
+		}
+		// The first three GUID fields are stored little-endian
+		b := []byte{raw[3], raw[2], raw[1], raw[0], raw[5], raw[4], raw[7], raw[6]}
+		b = append(b, raw[8:]...)
+		id, err := uuid.FromBytes(b)
+		if err != nil {
+			return ""
+		}
+		return id.String()
+	}
+	return entry.GetAttributeValue(attribute)
+}
@@ -0,0 +1,291 @@
+package directory
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimlambrt/gldap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBindDN       = "cn=sync,dc=example,dc=org"
+	testBindPassword = "sync-secret"
+)
+
+// testEntry is an entry of the in-process test directory. Bind checks the
+// password against userPassword.
+type testEntry struct {
+	dn         string
+	attributes map[string][]string
+}
+
+// startTestDirectory runs an in-process LDAP server holding entries until the
+// test ends. It supports simple binds and subtree searches with &, |, !,
+// equality and presence filters.
+func startTestDirectory(t *testing.T, entries ...testEntry) string {
+	t.Helper()
+	entries = append(entries, testEntry{dn: testBindDN, attributes: map[string][]string{
+		"objectClass":  {"person"},
+		"userPassword": {testBindPassword},
+	}})
+
+	server, err := gldap.NewServer()
+	require.NoError(t, err)
+	mux, err := gldap.NewMux()
+	require.NoError(t, err)
+	require.NoError(t, mux.Bind(func(w *gldap.ResponseWriter, r *gldap.Request) {
+		response := r.NewBindResponse(gldap.WithResponseCode(gldap.ResultInvalidCredentials))
+		defer func() { _ = w.Write(response) }()
+		m, err := r.GetSimpleBindMessage()
+		if err != nil {
+			return
+		}
+		if m.UserName == "" && m.Password == "" {
+			response.SetResultCode(gldap.ResultSuccess)
+			return
+		}
+		for _, entry := range entries {
+			if strings.EqualFold(entry.dn, m.UserName) && contains(entry.attributes["userPassword"], string(m.Password)) {
+				response.SetResultCode(gldap.ResultSuccess)
+				return
+			}
+		}
+	}))
+	require.NoError(t, mux.Search(func(w *gldap.ResponseWriter, r *gldap.Request) {
+		done := r.NewSearchDoneResponse(gldap.WithResponseCode(gldap.ResultSuccess))
+		defer func() { _ = w.Write(done) }()
+		m, err := r.GetSearchMessage()
+		if err != nil {
+			done.SetResultCode(gldap.ResultProtocolError)
+			return
+		}
+		for _, entry := range entries {
+			if !strings.HasSuffix(strings.ToLower(entry.dn), strings.ToLower(m.BaseDN)) {
+				continue
+			}
+			matched, err := matchFilter(m.Filter, entry.attributes)
+			if err != nil {
+				done.SetResultCode(gldap.ResultProtocolError)
+				return
+			}
+			if !matched {
+				continue
+			}
+			result := r.NewSearchResponseEntry(entry.dn)
+			for name, values := range entry.attributes {
+				if name != "userPassword" && (len(m.Attributes) == 0 || containsFold(m.Attributes, name)) {
+					result.AddAttribute(name, values)
+				}
+			}
+			_ = w.Write(result)
+		}
+	}))
+	require.NoError(t, server.Router(mux))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	go func() { _ = server.Run(addr) }()
+	t.Cleanup(func() { _ = server.Stop() })
+	require.Eventually(t, server.Ready, 5*time.Second, 10*time.Millisecond)
+	return "ldap://" + addr
+}
+
+// matchFilter evaluates the subset of RFC 4515 filters the tests use
+func matchFilter(filter string, attributes map[string][]string) (bool, error) {
+	matched, rest, err := evalFilter(filter, attributes)
+	if err == nil && rest != "" {
+		err = fmt.Errorf("trailing filter input %q", rest)
+	}
+	return matched, err
+}
+
+func evalFilter(filter string, attributes map[string][]string) (bool, string, error) {
+	if !strings.HasPrefix(filter, "(") || len(filter) < 2 {
+		return false, "", fmt.Errorf("invalid filter %q", filter)
+	}
+	switch filter[1] {
+	case '&', '|', '!':
+		op := filter[1]
+		rest := filter[2:]
+		var results []bool
+		for strings.HasPrefix(rest, "(") {
+			matched, remaining, err := evalFilter(rest, attributes)
+			if err != nil {
+				return false, "", err
+			}
+			results = append(results, matched)
+			rest = remaining
+		}
+		if !strings.HasPrefix(rest, ")") {
+			return false, "", fmt.Errorf("unterminated filter %q", filter)
+		}
+		result := op == '&'
+		for _, matched := range results {
+			switch op {
+			case '&':
+				result = result && matched
+			case '|':
+				result = result || matched
+			case '!':
+				result = !matched
+			}
+		}
+		return result, rest[1:], nil
+	}
+
+	end := strings.Index(filter, ")")
+	if end < 0 {
+		return false, "", fmt.Errorf("unterminated filter %q", filter)
+	}
+	name, value, ok := strings.Cut(filter[1:end], "=")
+	if !ok {
+		return false, "", fmt.Errorf("invalid filter %q", filter)
+	}
+	values := lookup(attributes, name)
+	if value == "*" {
+		return len(values) > 0, filter[end+1:], nil
+	}
+	return containsFold(values, value), filter[end+1:], nil
+}
+
+func lookup(attributes map[string][]string, name string) []string {
+	for key, values := range attributes {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func openLDAPEntries() []testEntry {
+	return []testEntry{
+		{dn: "uid=jdoe,ou=people,dc=example,dc=org", attributes: map[string][]string{
+			"objectClass":  {"inetOrgPerson"},
+			"entryUUID":    {"4f6b1c2e-0000-4000-8000-000000000001"},
+			"mail":         {"jane@example.org"},
+			"cn":           {"Jane Doe"},
+			"givenName":    {"Jane"},
+			"sn":           {"Doe"},
+			"userPassword": {"jane-secret"},
+		}},
+		{dn: "uid=rroe,ou=people,dc=example,dc=org", attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"entryUUID":   {"4f6b1c2e-0000-4000-8000-000000000002"},
+			"mail":        {"richard@example.org"},
+			"cn":          {"Richard Roe"},
+		}},
+		{dn: "cn=engineering,ou=groups,dc=example,dc=org", attributes: map[string][]string{
+			"objectClass": {"groupOfNames"},
+			"entryUUID":   {"4f6b1c2e-0000-4000-8000-0000000000a1"},
+			"cn":          {"engineering"},
+			"description": {"Engineers"},
+			"member":      {"uid=jdoe,ou=people,dc=example,dc=org", "uid=rroe,ou=people,dc=example,dc=org"},
+		}},
+	}
+}
+
+func testConfig(t *testing.T, url, directoryType string) Config {
+	config := Config{
+		URL:          url,
+		Type:         directoryType,
+		BindDN:       testBindDN,
+		BindPassword: testBindPassword,
+		Users:        UserConfig{BaseDN: "ou=people,dc=example,dc=org"},
+		Groups:       GroupConfig{BaseDN: "ou=groups,dc=example,dc=org"},
+	}
+	require.NoError(t, config.applyDefaults())
+	return config
+}
+
+func TestClientFetch(t *testing.T) {
+	client := NewClient(testConfig(t, startTestDirectory(t, openLDAPEntries()...), TypeOpenLDAP))
+
+	snapshot, err := client.Fetch()
+	require.NoError(t, err)
+
+	users := sortedUsers(snapshot.Users)
+	require.Len(t, users, 2)
+	assert.Equal(t, Entry{
+		DN:         "uid=jdoe,ou=people,dc=example,dc=org",
+		ExternalID: "4f6b1c2e-0000-4000-8000-000000000001",
+		Email:      "jane@example.org",
+		Name:       "Jane Doe",
+		FirstName:  "Jane",
+		LastName:   "Doe",
+	}, users[0])
+	assert.Equal(t, "richard@example.org", users[1].Email)
+
+	require.Len(t, snapshot.Groups, 1)
+	assert.Equal(t, "engineering", snapshot.Groups[0].Name)
+	assert.Equal(t, "Engineers", snapshot.Groups[0].Description)
+	assert.Len(t, snapshot.Groups[0].Members, 2)
+}
+
+func TestClientFetchWrongServiceAccount(t *testing.T) {
+	config := testConfig(t, startTestDirectory(t, openLDAPEntries()...), TypeOpenLDAP)
+	config.BindPassword = "wrong"
+
+	_, err := NewClient(config).Fetch()
+	assert.Error(t, err)
+}
+
+func TestClientBind(t *testing.T) {
+	client := NewClient(testConfig(t, startTestDirectory(t, openLDAPEntries()...), TypeOpenLDAP))
+
+	assert.NoError(t, client.Bind("uid=jdoe,ou=people,dc=example,dc=org", "jane-secret"))
+	assert.ErrorIs(t, client.Bind("uid=jdoe,ou=people,dc=example,dc=org", "wrong"), ErrInvalidCredentials)
+	// The test server, like most real ones, accepts empty passwords as
+	// anonymous binds
+	assert.ErrorIs(t, client.Bind("uid=jdoe,ou=people,dc=example,dc=org", ""), ErrInvalidCredentials)
+}
+
+func TestClientFetchActiveDirectory(t *testing.T) {
+	guid := string([]byte{0x33, 0x22, 0x11, 0x00, 0x55, 0x44, 0x77, 0x66, 0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff})
+	url := startTestDirectory(t,
+		testEntry{dn: "CN=Jane Doe,OU=People,DC=example,DC=org", attributes: map[string][]string{
+			"objectClass":        {"user"},
+			"objectCategory":     {"person"},
+			"objectGUID":         {guid},
+			"mail":               {"jane@example.org"},
+			"displayName":        {"Jane Doe"},
+			"userAccountControl": {"514"},
+		}},
+		testEntry{dn: "CN=Printer,OU=People,DC=example,DC=org", attributes: map[string][]string{
+			"objectClass":    {"user"},
+			"objectCategory": {"computer"},
+		}},
+	)
+
+	snapshot, err := NewClient(testConfig(t, url, TypeActiveDirectory)).Fetch()
+	require.NoError(t, err)
+	require.Len(t, snapshot.Users, 1)
+	assert.Equal(t, "00112233-4455-6677-8899-aabbccddeeff", snapshot.Users[0].ExternalID)
+	assert.Equal(t, "Jane Doe", snapshot.Users[0].Name)
+	assert.True(t, snapshot.Users[0].Disabled)
+}
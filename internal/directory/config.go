@@ -0,0 +1,132 @@
+package directory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Directory types with their own default filters and attribute mappings
+const (
+	TypeActiveDirectory = "activedirectory"
+	TypeOpenLDAP        = "openldap"
+)
+
+// Config describes the LDAP directory users and groups are synchronized from
+type Config struct {
+	// URL is an ldap:// or ldaps:// URL
+	URL                string `json:"url"`
+	StartTLS           bool   `json:"startTls"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	Type               string `json:"type"`
+	BindDN             string `json:"bindDn"`
+	// BindPassword may reference environment variables, e.g. "${LDAP_BIND_PASSWORD}"
+	BindPassword string      `json:"bindPassword"`
+	PageSize     int         `json:"pageSize"`
+	Users        UserConfig  `json:"users"`
+	Groups       GroupConfig `json:"groups"`
+}
+
+// UserConfig selects the entries imported as users
+type UserConfig struct {
+	BaseDN     string         `json:"baseDn"`
+	Filter     string         `json:"filter"`
+	Attributes UserAttributes `json:"attributes"`
+}
+
+// UserAttributes names the LDAP attributes that hold each user field
+type UserAttributes struct {
+	ID        string `json:"id"`
+	Email     string `json:"email"`
+	Name      string `json:"name"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+}
+
+// GroupConfig selects the entries imported as groups
+type GroupConfig struct {
+	BaseDN     string          `json:"baseDn"`
+	Filter     string          `json:"filter"`
+	Attributes GroupAttributes `json:"attributes"`
+}
+
+// GroupAttributes names the LDAP attributes that hold each group field. Member
+// holds the DNs of the group's members.
+type GroupAttributes struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+	Description string `json:"description"`
+	Member      string `json:"member"`
+}
+
+// LoadConfig reads the directory configuration from a JSON file. An empty
+// path disables LDAP authentication and sync.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory config: %w", err)
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse directory config: %w", err)
+	}
+	config.BindPassword = os.ExpandEnv(config.BindPassword)
+	if err := config.applyDefaults(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (c *Config) applyDefaults() error {
+	if c.URL == "" || c.Users.BaseDN == "" {
+		return fmt.Errorf("directory config: url and users.baseDn are required")
+	}
+	if c.Type == "" {
+		c.Type = TypeOpenLDAP
+	}
+	if c.Type != TypeActiveDirectory && c.Type != TypeOpenLDAP {
+		return fmt.Errorf("directory config: unknown type %q", c.Type)
+	}
+	if c.PageSize <= 0 {
+		c.PageSize = 500
+	}
+	if c.Groups.BaseDN == "" {
+		c.Groups.BaseDN = c.Users.BaseDN
+	}
+
+	ad := c.Type == TypeActiveDirectory
+	users := &c.Users
+	groups := &c.Groups
+	setDefault(&users.Filter, pick(ad, "(&(objectCategory=person)(objectClass=user))", "(objectClass=inetOrgPerson)"))
+	setDefault(&users.Attributes.ID, pick(ad, "objectGUID", "entryUUID"))
+	setDefault(&users.Attributes.Email, "mail")
+	setDefault(&users.Attributes.Name, pick(ad, "displayName", "cn"))
+	setDefault(&users.Attributes.FirstName, "givenName")
+	setDefault(&users.Attributes.LastName, "sn")
+	setDefault(&groups.Filter, pick(ad, "(objectClass=group)", "(objectClass=groupOfNames)"))
+	setDefault(&groups.Attributes.ID, pick(ad, "objectGUID", "entryUUID"))
+	setDefault(&groups.Attributes.Name, "cn")
+	setDefault(&groups.Attributes.DisplayName, pick(ad, "displayName", "cn"))
+	setDefault(&groups.Attributes.Description, "description")
+	setDefault(&groups.Attributes.Member, "member")
+	return nil
+}
+
+func setDefault(value *string, defaultValue string) {
+	if strings.TrimSpace(*value) == "" {
+		*value = defaultValue
+	}
+}
+
+func pick(ad bool, activeDirectory, openLDAP string) string {
+	if ad {
+		return activeDirectory
+	}
+	return openLDAP
+}
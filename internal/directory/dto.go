@@ -0,0 +1,16 @@
+package directory
+
+// SyncRunResponse describes a sync run. Summary counts its changes by
+// "<kind>.<action>", plus "failed" for changes that could not be applied.
+type SyncRunResponse struct {
+	ID         string         `json:"id"`
+	DryRun     bool           `json:"dryRun"`
+	Trigger    string         `json:"trigger"`
+	Actor      string         `json:"actor,omitempty"`
+	State      string         `json:"state"`
+	Error      string         `json:"error,omitempty"`
+	Summary    map[string]int `json:"summary"`
+	Changes    []Change       `json:"changes,omitempty"`
+	StartedAt  string         `json:"startedAt"`
+	FinishedAt string         `json:"finishedAt"`
+}
@@ -0,0 +1,80 @@
+package directory
+
+import (
+	"errors"
+	"net/http"
+
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type DirectoryController struct {
+	directoryService *DirectoryService
+	logger           *logrus.Logger
+}
+
+func NewDirectoryController(directoryService *DirectoryService) *DirectoryController {
+	return &DirectoryController{
+		directoryService: directoryService,
+		logger:           logrus.New(),
+	}
+}
+
+// Sync runs a directory sync now. With ?dryRun=true it only reports the
+// changes it would make.
+func (c *DirectoryController) Sync(ctx *gin.Context) {
+	dryRun := ctx.Query("dryRun") == "true"
+	run, err := c.directoryService.WithContext(ctx).Sync(dryRun, TriggerManual, middleware.GetUserID(ctx))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotConfigured):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Directory is not configured"})
+		case errors.Is(err, ErrSyncInProgress):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case run != nil:
+			c.logger.Errorf("Directory sync failed: %v", err)
+			ctx.JSON(http.StatusBadGateway, run)
+		default:
+			c.logger.Errorf("Directory sync failed: %v", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync directory"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, run)
+}
+
+func (c *DirectoryController) GetSyncRuns(ctx *gin.Context) {
+	runs, err := c.directoryService.WithContext(ctx).GetSyncRuns()
+	if err != nil {
+		c.logger.Errorf("Failed to get sync runs: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sync runs"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, runs)
+}
+
+func (c *DirectoryController) GetSyncRun(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sync run ID"})
+		return
+	}
+
+	run, err := c.directoryService.WithContext(ctx).GetSyncRun(id)
+	if err != nil {
+		c.logger.Errorf("Failed to get sync run: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sync run"})
+		return
+	}
+	if run == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Sync run not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, run)
+}
@@ -0,0 +1,74 @@
+package directory
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Kinds of synchronized objects
+const (
+	KindUser  = "user"
+	KindGroup = "group"
+)
+
+// Object ties a local user or group to the directory entry it was synced
+// from. Attributes holds the mapped values seen at the last sync, which is
+// what later syncs compare against to detect changes.
+type Object struct {
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Kind       string    `json:"kind" gorm:"type:varchar(16);not null;uniqueIndex:idx_directory_objects_kind_external_id"`
+	ExternalID string    `json:"externalId" gorm:"not null;uniqueIndex:idx_directory_objects_kind_external_id;column:external_id"`
+	LocalID    uuid.UUID `json:"localId" gorm:"type:uuid;not null;index;column:local_id"`
+	DN         string    `json:"dn" gorm:"not null;column:dn"`
+	Attributes string    `json:"-" gorm:"type:text"`
+	// SuspendedBySync is set on users the sync suspended, so that only they
+	// are reactivated when they come back
+	SuspendedBySync bool      `json:"suspendedBySync" gorm:"column:suspended_by_sync"`
+	SyncedAt        time.Time `json:"syncedAt"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+func (o *Object) BeforeCreate(tx *gorm.DB) error {
+	if o.ID == uuid.Nil {
+		o.ID = uuid.New()
+	}
+	return nil
+}
+
+func (o *Object) TableName() string {
+	return "directory_objects"
+}
+
+// Sync run states
+const (
+	RunStateCompleted = "completed"
+	RunStatePartial   = "partial"
+	RunStateFailed    = "failed"
+)
+
+// SyncRun records a directory sync and the changes it made, or would have
+// made for a dry run
+type SyncRun struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	DryRun     bool      `gorm:"not null"`
+	Trigger    string    `gorm:"type:varchar(16);not null"`
+	Actor      string
+	State      string `gorm:"type:varchar(16);not null;index"`
+	Error      string
+	Changes    string    `gorm:"type:text"`
+	StartedAt  time.Time `gorm:"not null;index"`
+	FinishedAt time.Time
+}
+
+func (r *SyncRun) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (r *SyncRun) TableName() string {
+	return "directory_sync_runs"
+}
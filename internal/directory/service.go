@@ -0,0 +1,469 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"idmapp-go/dto"
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/group"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/user"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Sync triggers
+const (
+	TriggerScheduled = "scheduled"
+	TriggerManual    = "manual"
+)
+
+// syncRunListLimit caps how many runs GetSyncRuns returns
+const syncRunListLimit = 50
+
+// syncActor is recorded on lifecycle transitions made by the sync
+const syncActor = "directory-sync"
+
+var (
+	ErrNotConfigured  = errors.New("directory is not configured")
+	ErrSyncInProgress = errors.New("a directory sync is already running")
+)
+
+type DirectoryService struct {
+	db            *gorm.DB
+	client        *Client
+	userService   *user.UserService
+	groupService  *group.GroupService
+	memberService *member.MemberService
+	running       *sync.Mutex
+	logger        *logrus.Logger
+}
+
+// NewDirectoryService creates the service for the configured directory. A
+// nil config disables it.
+func NewDirectoryService(db *gorm.DB, config *Config, userService *user.UserService, groupService *group.GroupService, memberService *member.MemberService) *DirectoryService {
+	s := &DirectoryService{
+		db:            db,
+		userService:   userService,
+		groupService:  groupService,
+		memberService: memberService,
+		running:       &sync.Mutex{},
+		logger:        logrus.New(),
+	}
+	if config != nil {
+		s.client = NewClient(*config)
+	}
+	return s
+}
+
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant. The copies share the lock held while syncing.
+func (s *DirectoryService) WithContext(ctx context.Context) *DirectoryService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	scoped.userService = s.userService.WithContext(ctx)
+	scoped.groupService = s.groupService.WithContext(ctx)
+	scoped.memberService = s.memberService.WithContext(ctx)
+	return &scoped
+}
+
+func (s *DirectoryService) Enabled() bool {
+	return s.client != nil
+}
+
+// Authenticate implements user.Authenticator by binding to the directory as
+// the synced user
+func (s *DirectoryService) Authenticate(u *user.User, password string) error {
+	if !s.Enabled() {
+		return user.ErrNotManaged
+	}
+	var object Object
+	if err := s.db.Where("kind = ? AND local_id = ?", KindUser, u.ID).First(&object).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user.ErrNotManaged
+		}
+		return fmt.Errorf("failed to get directory object: %w", err)
+	}
+	return s.client.Bind(object.DN, password)
+}
+
+// Sync reads the directory and brings local users, groups and memberships in
+// line with it. A dry run only reports the changes. The run is recorded even
+// when it fails.
+func (s *DirectoryService) Sync(dryRun bool, trigger, actor string) (*SyncRunResponse, error) {
+	if !s.Enabled() {
+		return nil, ErrNotConfigured
+	}
+	if !s.running.TryLock() {
+		return nil, ErrSyncInProgress
+	}
+	defer s.running.Unlock()
+
+	run := SyncRun{
+		DryRun:    dryRun,
+		Trigger:   trigger,
+		Actor:     actor,
+		StartedAt: time.Now(),
+	}
+	changes, err := s.sync(dryRun)
+	run.FinishedAt = time.Now()
+	run.State = RunStateCompleted
+	if err != nil {
+		run.State = RunStateFailed
+		run.Error = err.Error()
+	} else if summarize(changes)["failed"] > 0 {
+		run.State = RunStatePartial
+	}
+	encoded, encodeErr := json.Marshal(changes)
+	if encodeErr != nil {
+		return nil, fmt.Errorf("failed to encode sync changes: %w", encodeErr)
+	}
+	run.Changes = string(encoded)
+	if err := s.db.Create(&run).Error; err != nil {
+		return nil, fmt.Errorf("failed to record sync run: %w", err)
+	}
+
+	response := toResponse(&run, true)
+	if !dryRun && run.State != RunStateFailed {
+		events.Publish(events.Event{
+			Type:    "directory.synced",
+			Subject: run.ID.String(),
+			Actor:   actor,
+			Data:    map[string]interface{}{"state": run.State, "summary": response.Summary},
+		})
+	}
+	return response, err
+}
+
+func (s *DirectoryService) sync(dryRun bool) ([]Change, error) {
+	snapshot, err := s.client.Fetch()
+	if err != nil {
+		return nil, err
+	}
+	st, err := s.loadState()
+	if err != nil {
+		return nil, err
+	}
+	changes, err := plan(snapshot, st)
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		s.apply(changes, st)
+	}
+	return changes, nil
+}
+
+func (s *DirectoryService) loadState() (*state, error) {
+	st := newState()
+
+	var objects []Object
+	if err := s.db.Find(&objects).Error; err != nil {
+		return nil, fmt.Errorf("failed to get directory objects: %w", err)
+	}
+	userIDs := make(map[uuid.UUID]string)
+	groupIDs := make(map[uuid.UUID]string)
+	for i := range objects {
+		object := &objects[i]
+		if object.Kind == KindUser {
+			st.users[object.ExternalID] = object
+			userIDs[object.LocalID] = object.ExternalID
+		} else {
+			st.groups[object.ExternalID] = object
+			groupIDs[object.LocalID] = object.ExternalID
+		}
+	}
+
+	var users []user.User
+	if err := s.db.Select("id", "email", "status").Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	for _, u := range users {
+		if _, synced := userIDs[u.ID]; synced {
+			st.statuses[u.ID] = u.Status
+		} else {
+			st.localUsers[strings.ToLower(u.Email)] = u.ID
+		}
+	}
+
+	var groups []group.Group
+	if err := s.db.Select("id", "name").Find(&groups).Error; err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+	for _, g := range groups {
+		if _, synced := groupIDs[g.ID]; !synced {
+			st.localGroups[g.Name] = g.ID
+		}
+	}
+
+	var members []member.Member
+	if err := s.db.Find(&members).Error; err != nil {
+		return nil, fmt.Errorf("failed to get members: %w", err)
+	}
+	for _, m := range members {
+		groupID, groupSynced := groupIDs[m.GroupID]
+		userID, userSynced := userIDs[m.UserID]
+		if groupSynced && userSynced {
+			st.members[membership{group: groupID, user: userID}] = true
+		}
+	}
+	return st, nil
+}
+
+// apply makes the planned changes one at a time. A failed change is recorded
+// on it and doesn't stop the others.
+func (s *DirectoryService) apply(changes []Change, st *state) {
+	userIDs := make(map[string]uuid.UUID)
+	for externalID, object := range st.users {
+		userIDs[externalID] = object.LocalID
+	}
+	groupIDs := make(map[string]uuid.UUID)
+	for externalID, object := range st.groups {
+		groupIDs[externalID] = object.LocalID
+	}
+
+	for i := range changes {
+		change := &changes[i]
+		var err error
+		switch change.Kind {
+		case KindUser:
+			err = s.applyUserChange(change, st)
+			if err == nil && change.LocalID != "" {
+				userIDs[change.ExternalID] = uuid.MustParse(change.LocalID)
+			}
+		case KindGroup:
+			err = s.applyGroupChange(change, st)
+			if err == nil && change.LocalID != "" {
+				groupIDs[change.ExternalID] = uuid.MustParse(change.LocalID)
+			}
+		case KindMembership:
+			err = s.applyMembershipChange(change, groupIDs, userIDs)
+		}
+		if err != nil {
+			s.logger.Errorf("Directory sync failed to %s %s %s: %v", change.Action, change.Kind, change.Name, err)
+			change.Error = err.Error()
+		}
+	}
+}
+
+func (s *DirectoryService) applyUserChange(change *Change, st *state) error {
+	switch change.Action {
+	case ActionCreate:
+		entry := change.entry
+		created, err := s.userService.CreateFederatedUser(user.FederatedUserRequest{
+			ProviderID: "ldap",
+			Name:       entry.Name,
+			FirstName:  entry.FirstName,
+			LastName:   entry.LastName,
+			Email:      entry.Email,
+		})
+		if err != nil {
+			return err
+		}
+		change.LocalID = created.ID.String()
+		return s.saveObject(&Object{Kind: KindUser, ExternalID: entry.ExternalID, LocalID: created.ID}, entry.DN, userAttributes(entry))
+	case ActionLink, ActionUpdate:
+		entry := change.entry
+		localID := uuid.MustParse(change.LocalID)
+		if change.Action == ActionLink || hasDataFields(change.Fields) {
+			updated, err := s.userService.UpdateUser(localID, user.UserUpdateRequest{
+				Name:      entry.Name,
+				FirstName: entry.FirstName,
+				LastName:  entry.LastName,
+				Email:     entry.Email,
+			})
+			if err != nil {
+				return err
+			}
+			if updated == nil {
+				return errors.New("user not found")
+			}
+		}
+		object, ok := st.users[entry.ExternalID]
+		if !ok {
+			object = &Object{Kind: KindUser, ExternalID: entry.ExternalID, LocalID: localID}
+		}
+		return s.saveObject(object, entry.DN, userAttributes(entry))
+	case ActionSuspend, ActionActivate:
+		action := user.ActionSuspend
+		if change.Action == ActionActivate {
+			action = user.ActionActivate
+		}
+		object := st.users[change.ExternalID]
+		if _, _, err := s.userService.TransitionUser(object.LocalID, action, user.LifecycleTransitionRequest{
+			Reason: "Directory sync: " + change.Reason,
+		}, syncActor); err != nil {
+			return err
+		}
+		object.SuspendedBySync = action == user.ActionSuspend
+		if err := s.db.Model(object).Update("suspended_by_sync", object.SuspendedBySync).Error; err != nil {
+			return fmt.Errorf("failed to update directory object: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *DirectoryService) applyGroupChange(change *Change, st *state) error {
+	switch change.Action {
+	case ActionCreate:
+		entry := change.groupEntry
+		created, err := s.groupService.CreateGroup(group.GroupCreateRequest{
+			Name:        entry.Name,
+			DisplayName: entry.DisplayName,
+			Description: entry.Description,
+		})
+		if err != nil {
+			return err
+		}
+		change.LocalID = created.ID.String()
+		return s.saveObject(&Object{Kind: KindGroup, ExternalID: entry.ExternalID, LocalID: created.ID}, entry.DN, groupAttributes(entry))
+	case ActionLink, ActionUpdate:
+		entry := change.groupEntry
+		localID := uuid.MustParse(change.LocalID)
+		if change.Action == ActionLink || hasDataFields(change.Fields) {
+			updated, err := s.groupService.UpdateGroup(localID, group.GroupUpdateRequest{
+				Name:        entry.Name,
+				DisplayName: entry.DisplayName,
+				Description: entry.Description,
+			})
+			if err != nil {
+				return err
+			}
+			if updated == nil {
+				return errors.New("group not found")
+			}
+		}
+		object, ok := st.groups[entry.ExternalID]
+		if !ok {
+			object = &Object{Kind: KindGroup, ExternalID: entry.ExternalID, LocalID: localID}
+		}
+		return s.saveObject(object, entry.DN, groupAttributes(entry))
+	case ActionDelete:
+		object := st.groups[change.ExternalID]
+		return s.db.Transaction(func(tx *gorm.DB) error {
+			if err := member.DeleteGroupMembers(tx, object.LocalID); err != nil {
+				return err
+			}
+			if err := tx.Delete(&group.Group{ID: object.LocalID}).Error; err != nil {
+				return fmt.Errorf("failed to delete group: %w", err)
+			}
+			if err := tx.Delete(object).Error; err != nil {
+				return fmt.Errorf("failed to delete directory object: %w", err)
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+func (s *DirectoryService) applyMembershipChange(change *Change, groupIDs, userIDs map[string]uuid.UUID) error {
+	groupID, ok := groupIDs[change.groupID]
+	if !ok {
+		return errors.New("group was not synced")
+	}
+	userID, ok := userIDs[change.userID]
+	if !ok {
+		return errors.New("user was not synced")
+	}
+	if change.Action == ActionRemove {
+		return s.memberService.RemoveMember(groupID, userID)
+	}
+	_, err := s.memberService.AddMember(dto.MemberOpRequest{Op: dto.OpTypeAdd, GroupID: groupID, UserID: userID})
+	return err
+}
+
+func (s *DirectoryService) saveObject(object *Object, dn string, attributes map[string]string) error {
+	now := time.Now()
+	object.DN = dn
+	object.Attributes = encodeAttributes(attributes)
+	object.SyncedAt = now
+	if object.CreatedAt.IsZero() {
+		object.CreatedAt = now
+	}
+	if err := s.db.Save(object).Error; err != nil {
+		return fmt.Errorf("failed to save directory object: %w", err)
+	}
+	return nil
+}
+
+// hasDataFields reports whether anything besides the DN changed
+func hasDataFields(fields []string) bool {
+	for _, field := range fields {
+		if field != "dn" {
+			return true
+		}
+	}
+	return false
+}
+
+// RunSyncScheduler syncs every interval until ctx is done
+func (s *DirectoryService) RunSyncScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run, err := s.Sync(false, TriggerScheduled, syncActor)
+			if err != nil {
+				s.logger.Errorf("Directory sync failed: %v", err)
+			} else {
+				s.logger.Infof("Directory sync %s: %v", run.State, run.Summary)
+			}
+		}
+	}
+}
+
+// GetSyncRuns lists the most recent sync runs without their changes
+func (s *DirectoryService) GetSyncRuns() ([]SyncRunResponse, error) {
+	var runs []SyncRun
+	if err := s.db.Order("started_at DESC").Limit(syncRunListLimit).Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get sync runs: %w", err)
+	}
+	responses := make([]SyncRunResponse, 0, len(runs))
+	for i := range runs {
+		responses = append(responses, *toResponse(&runs[i], false))
+	}
+	return responses, nil
+}
+
+func (s *DirectoryService) GetSyncRun(id uuid.UUID) (*SyncRunResponse, error) {
+	var run SyncRun
+	if err := s.db.First(&run, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get sync run: %w", err)
+	}
+	return toResponse(&run, true), nil
+}
+
+func toResponse(run *SyncRun, withChanges bool) *SyncRunResponse {
+	var changes []Change
+	_ = json.Unmarshal([]byte(run.Changes), &changes)
+	response := &SyncRunResponse{
+		ID:         run.ID.String(),
+		DryRun:     run.DryRun,
+		Trigger:    run.Trigger,
+		Actor:      run.Actor,
+		State:      run.State,
+		Error:      run.Error,
+		Summary:    summarize(changes),
+		StartedAt:  run.StartedAt.Format(time.RFC3339),
+		FinishedAt: run.FinishedAt.Format(time.RFC3339),
+	}
+	if withChanges {
+		response.Changes = changes
+	}
+	return response
+}
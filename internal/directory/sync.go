@@ -0,0 +1,365 @@
+package directory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"idmapp-go/internal/user"
+
+	"github.com/google/uuid"
+)
+
+// KindMembership is the kind of changes to group memberships
+const KindMembership = "membership"
+
+// Change actions
+const (
+	ActionCreate   = "create"
+	ActionLink     = "link"
+	ActionUpdate   = "update"
+	ActionSuspend  = "suspend"
+	ActionActivate = "activate"
+	ActionDelete   = "delete"
+	ActionAdd      = "add"
+	ActionRemove   = "remove"
+	ActionSkip     = "skip"
+)
+
+// ErrEmptyDirectory stops a sync that would suspend every synced user, which
+// usually means a misconfigured base DN or filter rather than an empty directory
+var ErrEmptyDirectory = errors.New("directory returned no users; refusing to suspend all synced users")
+
+// Change is one step of a sync. Memberships name the user in Name and the
+// group in Group.
+type Change struct {
+	Action     string   `json:"action"`
+	Kind       string   `json:"kind"`
+	Name       string   `json:"name"`
+	Group      string   `json:"group,omitempty"`
+	DN         string   `json:"dn,omitempty"`
+	ExternalID string   `json:"externalId,omitempty"`
+	LocalID    string   `json:"localId,omitempty"`
+	Fields     []string `json:"fields,omitempty"`
+	Reason     string   `json:"reason,omitempty"`
+	Error      string   `json:"error,omitempty"`
+
+	entry      *Entry
+	groupEntry *GroupEntry
+	// External IDs of the group and user of a membership
+	groupID string
+	userID  string
+}
+
+// membership is a group membership between synced objects, by external ID
+type membership struct {
+	group string
+	user  string
+}
+
+// state is what earlier syncs and local changes left in the database
+type state struct {
+	users  map[string]*Object
+	groups map[string]*Object
+	// statuses of the synced users
+	statuses map[uuid.UUID]user.Status
+	// unlinked local users by lowercase email and groups by name, which are
+	// linked instead of duplicated when a directory entry matches them
+	localUsers  map[string]uuid.UUID
+	localGroups map[string]uuid.UUID
+	members     map[membership]bool
+}
+
+func newState() *state {
+	return &state{
+		users:       make(map[string]*Object),
+		groups:      make(map[string]*Object),
+		statuses:    make(map[uuid.UUID]user.Status),
+		localUsers:  make(map[string]uuid.UUID),
+		localGroups: make(map[string]uuid.UUID),
+		members:     make(map[membership]bool),
+	}
+}
+
+// plan compares the directory with the local state and lists the changes
+// that bring the local users, groups and memberships in line with it. The
+// directory is authoritative for the memberships between synced users and
+// groups only; local users added to synced groups are left alone.
+func plan(snapshot *Snapshot, st *state) ([]Change, error) {
+	if len(snapshot.Users) == 0 && len(st.users) > 0 {
+		return nil, ErrEmptyDirectory
+	}
+
+	var changes []Change
+	var removals []Change
+
+	// Users
+	usersByDN := make(map[string]*Entry)
+	seen := make(map[string]bool)
+	for i := range sortedUsers(snapshot.Users) {
+		entry := &snapshot.Users[i]
+		skip := Change{Action: ActionSkip, Kind: KindUser, Name: entry.Email, DN: entry.DN, ExternalID: entry.ExternalID}
+		switch {
+		case entry.ExternalID == "":
+			skip.Reason = "missing id attribute"
+		case seen[entry.ExternalID]:
+			skip.Reason = "duplicate id attribute"
+		case entry.Email == "":
+			skip.Reason = "missing email attribute"
+		}
+		if skip.Reason != "" {
+			changes = append(changes, skip)
+			continue
+		}
+		seen[entry.ExternalID] = true
+
+		change := Change{Kind: KindUser, Name: entry.Email, DN: entry.DN, ExternalID: entry.ExternalID, entry: entry}
+		object, linked := st.users[entry.ExternalID]
+		if !linked {
+			localID, exists := st.localUsers[strings.ToLower(entry.Email)]
+			switch {
+			case exists:
+				change.Action = ActionLink
+				change.LocalID = localID.String()
+			case entry.Disabled:
+				skip.Reason = "disabled in directory"
+				changes = append(changes, skip)
+				continue
+			default:
+				change.Action = ActionCreate
+			}
+			usersByDN[strings.ToLower(entry.DN)] = entry
+			changes = append(changes, change)
+			continue
+		}
+
+		usersByDN[strings.ToLower(entry.DN)] = entry
+		change.LocalID = object.LocalID.String()
+		if fields := changedFields(object.Attributes, userAttributes(entry)); len(fields) > 0 {
+			change.Action = ActionUpdate
+			change.Fields = fields
+			changes = append(changes, change)
+		}
+		status := st.statuses[object.LocalID]
+		if entry.Disabled && status == user.StatusActive {
+			change.Action = ActionSuspend
+			change.Fields = nil
+			change.Reason = "disabled in directory"
+			removals = append(removals, change)
+		} else if !entry.Disabled && object.SuspendedBySync && status == user.StatusSuspended {
+			change.Action = ActionActivate
+			change.Fields = nil
+			change.Reason = "enabled in directory"
+			changes = append(changes, change)
+		}
+	}
+	for _, externalID := range sortedKeys(st.users) {
+		object := st.users[externalID]
+		if seen[externalID] || st.statuses[object.LocalID] != user.StatusActive {
+			continue
+		}
+		removals = append(removals, Change{
+			Action:     ActionSuspend,
+			Kind:       KindUser,
+			Name:       object.name(),
+			DN:         object.DN,
+			ExternalID: externalID,
+			LocalID:    object.LocalID.String(),
+			Reason:     "removed from directory",
+		})
+	}
+
+	// Groups
+	var memberChanges []Change
+	seen = make(map[string]bool)
+	for i := range sortedGroups(snapshot.Groups) {
+		entry := &snapshot.Groups[i]
+		skip := Change{Action: ActionSkip, Kind: KindGroup, Name: entry.Name, DN: entry.DN, ExternalID: entry.ExternalID}
+		switch {
+		case entry.ExternalID == "":
+			skip.Reason = "missing id attribute"
+		case seen[entry.ExternalID]:
+			skip.Reason = "duplicate id attribute"
+		case entry.Name == "":
+			skip.Reason = "missing name attribute"
+		}
+		if skip.Reason != "" {
+			changes = append(changes, skip)
+			continue
+		}
+		seen[entry.ExternalID] = true
+
+		change := Change{Kind: KindGroup, Name: entry.Name, DN: entry.DN, ExternalID: entry.ExternalID, groupEntry: entry}
+		if object, linked := st.groups[entry.ExternalID]; linked {
+			change.LocalID = object.LocalID.String()
+			if fields := changedFields(object.Attributes, groupAttributes(entry)); len(fields) > 0 {
+				change.Action = ActionUpdate
+				change.Fields = fields
+				changes = append(changes, change)
+			}
+		} else if localID, exists := st.localGroups[entry.Name]; exists {
+			change.Action = ActionLink
+			change.LocalID = localID.String()
+			changes = append(changes, change)
+		} else {
+			change.Action = ActionCreate
+			changes = append(changes, change)
+		}
+
+		desired := make(map[string]bool)
+		for _, dn := range entry.Members {
+			member, ok := usersByDN[strings.ToLower(dn)]
+			if !ok || desired[member.ExternalID] {
+				continue
+			}
+			desired[member.ExternalID] = true
+			if !st.members[membership{group: entry.ExternalID, user: member.ExternalID}] {
+				memberChanges = append(memberChanges, Change{
+					Action:  ActionAdd,
+					Kind:    KindMembership,
+					Name:    member.Email,
+					Group:   entry.Name,
+					groupID: entry.ExternalID,
+					userID:  member.ExternalID,
+				})
+			}
+		}
+		for _, m := range sortedMemberships(st.members) {
+			if m.group != entry.ExternalID || desired[m.user] {
+				continue
+			}
+			memberChanges = append(memberChanges, Change{
+				Action:  ActionRemove,
+				Kind:    KindMembership,
+				Name:    st.users[m.user].name(),
+				Group:   entry.Name,
+				groupID: m.group,
+				userID:  m.user,
+			})
+		}
+	}
+	for _, externalID := range sortedKeys(st.groups) {
+		if seen[externalID] {
+			continue
+		}
+		object := st.groups[externalID]
+		removals = append(removals, Change{
+			Action:     ActionDelete,
+			Kind:       KindGroup,
+			Name:       object.name(),
+			DN:         object.DN,
+			ExternalID: externalID,
+			LocalID:    object.LocalID.String(),
+			Reason:     "removed from directory",
+		})
+	}
+
+	changes = append(changes, memberChanges...)
+	return append(changes, removals...), nil
+}
+
+// userAttributes are the synced values of a user, as stored on its Object
+func userAttributes(entry *Entry) map[string]string {
+	return map[string]string{
+		"dn":        entry.DN,
+		"email":     entry.Email,
+		"name":      entry.Name,
+		"firstName": entry.FirstName,
+		"lastName":  entry.LastName,
+	}
+}
+
+func groupAttributes(entry *GroupEntry) map[string]string {
+	return map[string]string{
+		"dn":          entry.DN,
+		"name":        entry.Name,
+		"displayName": entry.DisplayName,
+		"description": entry.Description,
+	}
+}
+
+func encodeAttributes(attributes map[string]string) string {
+	data, _ := json.Marshal(attributes)
+	return string(data)
+}
+
+func decodeAttributes(encoded string) map[string]string {
+	attributes := make(map[string]string)
+	_ = json.Unmarshal([]byte(encoded), &attributes)
+	return attributes
+}
+
+// changedFields lists the attributes that differ from those stored at the
+// last sync
+func changedFields(stored string, current map[string]string) []string {
+	previous := decodeAttributes(stored)
+	var fields []string
+	for name, value := range current {
+		if previous[name] != value {
+			fields = append(fields, name)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// name is how an object appears in reports once it has left the directory
+func (o *Object) name() string {
+	attributes := decodeAttributes(o.Attributes)
+	if o.Kind == KindUser && attributes["email"] != "" {
+		return attributes["email"]
+	}
+	if attributes["name"] != "" {
+		return attributes["name"]
+	}
+	return o.DN
+}
+
+// sortedUsers orders entries by DN so reports are stable between runs. It
+// sorts in place and returns its argument.
+func sortedUsers(entries []Entry) []Entry {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].DN < entries[j].DN })
+	return entries
+}
+
+func sortedGroups(entries []GroupEntry) []GroupEntry {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].DN < entries[j].DN })
+	return entries
+}
+
+func sortedKeys(objects map[string]*Object) []string {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedMemberships(members map[membership]bool) []membership {
+	sorted := make([]membership, 0, len(members))
+	for m := range members {
+		sorted = append(sorted, m)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].group != sorted[j].group {
+			return sorted[i].group < sorted[j].group
+		}
+		return sorted[i].user < sorted[j].user
+	})
+	return sorted
+}
+
+// summarize counts changes by "<kind>.<action>", plus failed ones
+func summarize(changes []Change) map[string]int {
+	summary := make(map[string]int)
+	for _, change := range changes {
+		summary[fmt.Sprintf("%s.%s", change.Kind, change.Action)]++
+		if change.Error != "" {
+			summary["failed"]++
+		}
+	}
+	return summary
+}
@@ -0,0 +1,167 @@
+package directory
+
+import (
+	"testing"
+
+	"idmapp-go/internal/user"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func janeEntry() Entry {
+	return Entry{
+		DN:         "uid=jdoe,ou=people,dc=example,dc=org",
+		ExternalID: "jane",
+		Email:      "jane@example.org",
+		Name:       "Jane Doe",
+		FirstName:  "Jane",
+		LastName:   "Doe",
+	}
+}
+
+func engineeringEntry(members ...string) GroupEntry {
+	return GroupEntry{
+		DN:         "cn=engineering,ou=groups,dc=example,dc=org",
+		ExternalID: "engineering",
+		Name:       "engineering",
+		Members:    members,
+	}
+}
+
+// syncedState is the state after jane and engineering were synced, with jane
+// a member of engineering
+func syncedState() *state {
+	st := newState()
+	jane := janeEntry()
+	engineering := engineeringEntry()
+	st.users["jane"] = &Object{Kind: KindUser, ExternalID: "jane", LocalID: uuid.New(), DN: jane.DN, Attributes: encodeAttributes(userAttributes(&jane))}
+	st.statuses[st.users["jane"].LocalID] = user.StatusActive
+	st.groups["engineering"] = &Object{Kind: KindGroup, ExternalID: "engineering", LocalID: uuid.New(), DN: engineering.DN, Attributes: encodeAttributes(groupAttributes(&engineering))}
+	st.members[membership{group: "engineering", user: "jane"}] = true
+	return st
+}
+
+type changeKey struct {
+	action string
+	kind   string
+	name   string
+}
+
+func keys(changes []Change) []changeKey {
+	result := make([]changeKey, 0, len(changes))
+	for _, change := range changes {
+		result = append(result, changeKey{change.Action, change.Kind, change.Name})
+	}
+	return result
+}
+
+func TestPlanInitialSync(t *testing.T) {
+	st := newState()
+	existing := uuid.New()
+	st.localUsers["richard@example.org"] = existing
+
+	snapshot := &Snapshot{
+		Users: []Entry{
+			janeEntry(),
+			{DN: "uid=rroe,ou=people,dc=example,dc=org", ExternalID: "richard", Email: "Richard@example.org"},
+			{DN: "uid=old,ou=people,dc=example,dc=org", ExternalID: "old", Email: "old@example.org", Disabled: true},
+			{DN: "uid=nomail,ou=people,dc=example,dc=org", ExternalID: "nomail"},
+		},
+		Groups: []GroupEntry{engineeringEntry("UID=JDOE,ou=people,dc=example,dc=org", "uid=rroe,ou=people,dc=example,dc=org", "uid=old,ou=people,dc=example,dc=org")},
+	}
+
+	changes, err := plan(snapshot, st)
+	require.NoError(t, err)
+	assert.Equal(t, []changeKey{
+		{ActionCreate, KindUser, "jane@example.org"},
+		{ActionSkip, KindUser, ""},
+		{ActionSkip, KindUser, "old@example.org"},
+		{ActionLink, KindUser, "Richard@example.org"},
+		{ActionCreate, KindGroup, "engineering"},
+		{ActionAdd, KindMembership, "jane@example.org"},
+		{ActionAdd, KindMembership, "Richard@example.org"},
+	}, keys(changes))
+	assert.Equal(t, "missing email attribute", changes[1].Reason)
+	assert.Equal(t, "disabled in directory", changes[2].Reason)
+	assert.Equal(t, existing.String(), changes[3].LocalID)
+	assert.Equal(t, "engineering", changes[5].Group)
+}
+
+func TestPlanNoChanges(t *testing.T) {
+	changes, err := plan(&Snapshot{
+		Users:  []Entry{janeEntry()},
+		Groups: []GroupEntry{engineeringEntry("uid=jdoe,ou=people,dc=example,dc=org")},
+	}, syncedState())
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestPlanDetectsChanges(t *testing.T) {
+	jane := janeEntry()
+	jane.LastName = "Smith"
+	jane.DN = "uid=jsmith,ou=people,dc=example,dc=org"
+	engineering := engineeringEntry()
+	engineering.Description = "Engineers"
+
+	changes, err := plan(&Snapshot{Users: []Entry{jane}, Groups: []GroupEntry{engineering}}, syncedState())
+	require.NoError(t, err)
+	assert.Equal(t, []changeKey{
+		{ActionUpdate, KindUser, "jane@example.org"},
+		{ActionUpdate, KindGroup, "engineering"},
+		{ActionRemove, KindMembership, "jane@example.org"},
+	}, keys(changes))
+	assert.Equal(t, []string{"dn", "lastName"}, changes[0].Fields)
+	assert.Equal(t, []string{"description"}, changes[1].Fields)
+}
+
+func TestPlanSuspendsAndReactivates(t *testing.T) {
+	st := syncedState()
+	bob := Entry{DN: "uid=bob,ou=people,dc=example,dc=org", ExternalID: "bob", Email: "bob@example.org"}
+	st.users["bob"] = &Object{Kind: KindUser, ExternalID: "bob", LocalID: uuid.New(), DN: bob.DN, Attributes: encodeAttributes(userAttributes(&bob))}
+	st.statuses[st.users["bob"].LocalID] = user.StatusActive
+
+	jane := janeEntry()
+	jane.Disabled = true
+	changes, err := plan(&Snapshot{Users: []Entry{jane}, Groups: []GroupEntry{engineeringEntry(jane.DN)}}, st)
+	require.NoError(t, err)
+	assert.Equal(t, []changeKey{
+		{ActionSuspend, KindUser, "jane@example.org"},
+		{ActionSuspend, KindUser, "bob@example.org"},
+	}, keys(changes))
+	assert.Equal(t, "disabled in directory", changes[0].Reason)
+	assert.Equal(t, "removed from directory", changes[1].Reason)
+
+	// Only users the sync suspended are reactivated
+	st.statuses[st.users["jane"].LocalID] = user.StatusSuspended
+	st.statuses[st.users["bob"].LocalID] = user.StatusSuspended
+	st.users["bob"].SuspendedBySync = true
+	changes, err = plan(&Snapshot{Users: []Entry{janeEntry(), bob}, Groups: []GroupEntry{engineeringEntry(jane.DN)}}, st)
+	require.NoError(t, err)
+	assert.Equal(t, []changeKey{{ActionActivate, KindUser, "bob@example.org"}}, keys(changes))
+}
+
+func TestPlanDeletesRemovedGroups(t *testing.T) {
+	changes, err := plan(&Snapshot{Users: []Entry{janeEntry()}}, syncedState())
+	require.NoError(t, err)
+	assert.Equal(t, []changeKey{{ActionDelete, KindGroup, "engineering"}}, keys(changes))
+}
+
+func TestPlanRefusesEmptyDirectory(t *testing.T) {
+	_, err := plan(&Snapshot{}, syncedState())
+	assert.ErrorIs(t, err, ErrEmptyDirectory)
+
+	changes, err := plan(&Snapshot{}, newState())
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestSummarize(t *testing.T) {
+	summary := summarize([]Change{
+		{Action: ActionCreate, Kind: KindUser},
+		{Action: ActionCreate, Kind: KindUser, Error: "email taken"},
+		{Action: ActionAdd, Kind: KindMembership},
+	})
+	assert.Equal(t, map[string]int{"user.create": 2, "membership.add": 1, "failed": 1}, summary)
+}
@@ -0,0 +1,100 @@
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Event is a domain event published after a state change has been committed
+type Event struct {
+	ID         uuid.UUID              `json:"id"`
+	Type       string                 `json:"type"`
+	Subject    string                 `json:"subject"`
+	Actor      string                 `json:"actor,omitempty"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	OccurredAt time.Time              `json:"occurredAt"`
+}
+
+// Handler receives published events. Handlers run synchronously on the
+// publishing goroutine and should hand off slow work.
+type Handler func(Event)
+
+// Bus dispatches events to the handlers subscribed to their type
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	logger   *logrus.Logger
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		handlers: make(map[string][]Handler),
+		logger:   logrus.New(),
+	}
+}
+
+// Subscribe registers handler for eventType. "*" matches every event and a
+// trailing ".*" matches every event type with that prefix, e.g. "user.*".
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish fills in the event ID and timestamp if missing and dispatches it
+func (b *Bus) Publish(event Event) {
+	if event.ID == uuid.Nil {
+		event.ID = uuid.New()
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	b.mu.RLock()
+	var matched []Handler
+	for pattern, handlers := range b.handlers {
+		if matches(pattern, event.Type) {
+			matched = append(matched, handlers...)
+		}
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range matched {
+		b.dispatch(handler, event)
+	}
+}
+
+func (b *Bus) dispatch(handler Handler, event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			b.logger.Errorf("Event handler for %s panicked: %v", event.Type, r)
+		}
+	}()
+	handler(event)
+}
+
+func matches(pattern, eventType string) bool {
+	if pattern == "*" || pattern == eventType {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(eventType, prefix)
+	}
+	return false
+}
+
+var defaultBus = NewBus()
+
+// Subscribe registers handler on the default bus
+func Subscribe(eventType string, handler Handler) {
+	defaultBus.Subscribe(eventType, handler)
+}
+
+// Publish dispatches event on the default bus
+func Publish(event Event) {
+	defaultBus.Publish(event)
+}
@@ -0,0 +1,25 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus_Publish(t *testing.T) {
+	bus := NewBus()
+
+	var exact, prefixed, all []string
+	bus.Subscribe("user.suspended", func(e Event) { exact = append(exact, e.Type) })
+	bus.Subscribe("user.*", func(e Event) { prefixed = append(prefixed, e.Type) })
+	bus.Subscribe("*", func(e Event) { all = append(all, e.Type) })
+	bus.Subscribe("user.locked", func(e Event) { panic("handler failure") })
+
+	bus.Publish(Event{Type: "user.suspended"})
+	bus.Publish(Event{Type: "user.locked"})
+	bus.Publish(Event{Type: "group.created"})
+
+	assert.Equal(t, []string{"user.suspended"}, exact)
+	assert.Equal(t, []string{"user.suspended", "user.locked"}, prefixed)
+	assert.Equal(t, []string{"user.suspended", "user.locked", "group.created"}, all)
+}
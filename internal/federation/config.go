@@ -0,0 +1,88 @@
+package federation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ProviderConfig describes an upstream OIDC identity provider
+type ProviderConfig struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	DiscoveryURL string `json:"discoveryUrl"`
+	ClientID     string `json:"clientId"`
+	// ClientSecret may reference environment variables, e.g. "${CORP_SSO_SECRET}"
+	ClientSecret string       `json:"clientSecret"`
+	Scopes       []string     `json:"scopes"`
+	ClaimMapping ClaimMapping `json:"claimMapping"`
+	// AutoProvision creates local users on first sign-in instead of only
+	// linking existing ones
+	AutoProvision bool `json:"autoProvision"`
+}
+
+// ClaimMapping names the upstream claims that hold each user attribute
+type ClaimMapping struct {
+	Email         string `json:"email"`
+	EmailVerified string `json:"emailVerified"`
+	Name          string `json:"name"`
+	FirstName     string `json:"firstName"`
+	LastName      string `json:"lastName"`
+}
+
+// LoadProviders reads the provider list from a JSON file. An empty path
+// disables federation.
+func LoadProviders(path string) ([]ProviderConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity providers: %w", err)
+	}
+	var providers []ProviderConfig
+	if err := json.Unmarshal(data, &providers); err != nil {
+		return nil, fmt.Errorf("failed to parse identity providers: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range providers {
+		p := &providers[i]
+		if p.ID == "" || p.DiscoveryURL == "" || p.ClientID == "" {
+			return nil, fmt.Errorf("identity provider %d: id, discoveryUrl and clientId are required", i)
+		}
+		if seen[p.ID] {
+			return nil, fmt.Errorf("duplicate identity provider %q", p.ID)
+		}
+		seen[p.ID] = true
+		p.ClientSecret = os.ExpandEnv(p.ClientSecret)
+		p.applyDefaults()
+	}
+	return providers, nil
+}
+
+func (p *ProviderConfig) applyDefaults() {
+	if p.Name == "" {
+		p.Name = p.ID
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = []string{"openid", "profile", "email"}
+	}
+	m := &p.ClaimMapping
+	if m.Email == "" {
+		m.Email = "email"
+	}
+	if m.EmailVerified == "" {
+		m.EmailVerified = "email_verified"
+	}
+	if m.Name == "" {
+		m.Name = "name"
+	}
+	if m.FirstName == "" {
+		m.FirstName = "given_name"
+	}
+	if m.LastName == "" {
+		m.LastName = "family_name"
+	}
+}
@@ -0,0 +1,153 @@
+package federation
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"idmapp-go/internal/session"
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// Error codes passed to the login page when a federated login fails
+const (
+	LoginErrorFailed      = "federation_failed"
+	LoginErrorNoAccount   = "no_account"
+	LoginErrorNotVerified = "email_not_verified"
+	LoginErrorDisabled    = "account_disabled"
+)
+
+type FederationController struct {
+	federationService *FederationService
+	sessionService    *session.SessionService
+	logger            *logrus.Logger
+}
+
+func NewFederationController(federationService *FederationService, sessionService *session.SessionService) *FederationController {
+	return &FederationController{
+		federationService: federationService,
+		sessionService:    sessionService,
+		logger:            logrus.New(),
+	}
+}
+
+// StartLogin sends the browser to the upstream provider
+func (c *FederationController) StartLogin(ctx *gin.Context) {
+	providerID := ctx.Param("provider")
+	authURL, err := c.federationService.WithContext(ctx).BeginLogin(ctx.Request.Context(), providerID, ctx.Query("redirect"))
+	if err != nil {
+		if errors.Is(err, ErrUnknownProvider) {
+			ctx.String(http.StatusNotFound, "Unknown identity provider")
+			return
+		}
+		c.logger.Errorf("Failed to start login with %s: %v", providerID, err)
+		c.redirectToLogin(ctx, LoginErrorFailed)
+		return
+	}
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+// Callback completes the upstream login and starts a local session
+func (c *FederationController) Callback(ctx *gin.Context) {
+	providerID := ctx.Param("provider")
+	if upstreamError := ctx.Query("error"); upstreamError != "" {
+		c.logger.Warnf("Identity provider %s returned error %s: %s", providerID, upstreamError, ctx.Query("error_description"))
+		c.redirectToLogin(ctx, LoginErrorFailed)
+		return
+	}
+
+	authenticatedUser, redirect, err := c.federationService.WithContext(ctx).CompleteLogin(ctx.Request.Context(), providerID, ctx.Query("code"), ctx.Query("state"))
+	if err != nil {
+		c.logger.Warnf("Federated login with %s failed: %v", providerID, err)
+		switch {
+		case errors.Is(err, ErrUnknownProvider):
+			ctx.String(http.StatusNotFound, "Unknown identity provider")
+		case errors.Is(err, ErrProvisioningDisabled):
+			c.redirectToLogin(ctx, LoginErrorNoAccount)
+		case errors.Is(err, ErrEmailNotVerified):
+			c.redirectToLogin(ctx, LoginErrorNotVerified)
+		case errors.Is(err, ErrAccountDisabled):
+			c.redirectToLogin(ctx, LoginErrorDisabled)
+		default:
+			c.redirectToLogin(ctx, LoginErrorFailed)
+		}
+		return
+	}
+
+	loginSession, err := c.sessionService.WithContext(ctx).CreateSession(authenticatedUser.ID, session.BrowserClientID, session.ClientInfoFromRequest(ctx))
+	if err != nil {
+		c.logger.Errorf("Failed to create session: %v", err)
+		ctx.String(http.StatusInternalServerError, "Failed to create session")
+		return
+	}
+	session.SetCookie(ctx, loginSession)
+
+	// Only follow local redirects
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/"
+	}
+	ctx.Redirect(http.StatusFound, redirect)
+}
+
+func (c *FederationController) redirectToLogin(ctx *gin.Context, code string) {
+	ctx.Redirect(http.StatusFound, "/login?federation_error="+url.QueryEscape(code))
+}
+
+func (c *FederationController) GetMyAccountLinks(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	c.listAccountLinks(ctx, userID)
+}
+
+func (c *FederationController) GetUserAccountLinks(ctx *gin.Context) {
+	userID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	c.listAccountLinks(ctx, userID)
+}
+
+func (c *FederationController) DeleteUserAccountLink(ctx *gin.Context) {
+	userID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	linkID, err := uuid.Parse(ctx.Param("linkId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account link ID"})
+		return
+	}
+
+	deleted, err := c.federationService.WithContext(ctx).DeleteAccountLink(userID, linkID, middleware.GetUserID(ctx))
+	if err != nil {
+		c.logger.Errorf("Failed to delete account link: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Account link not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *FederationController) listAccountLinks(ctx *gin.Context, userID uuid.UUID) {
+	links, err := c.federationService.WithContext(ctx).GetAccountLinks(userID)
+	if err != nil {
+		c.logger.Errorf("Failed to get account links: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get account links"})
+		return
+	}
+	ctx.JSON(http.StatusOK, links)
+}
@@ -0,0 +1,54 @@
+package federation
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AccountLink ties a local user to their identity at an upstream provider
+type AccountLink struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID      uuid.UUID  `json:"userId" gorm:"type:uuid;not null;index;column:user_id"`
+	ProviderID  string     `json:"providerId" gorm:"not null;uniqueIndex:idx_account_links_provider_subject"`
+	Subject     string     `json:"subject" gorm:"not null;uniqueIndex:idx_account_links_provider_subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+}
+
+func (l *AccountLink) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+func (l *AccountLink) TableName() string {
+	return "account_links"
+}
+
+// LoginState keeps the state, nonce and PKCE verifier of a pending upstream
+// login until the provider redirects back
+type LoginState struct {
+	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	State        string    `gorm:"uniqueIndex;not null"`
+	ProviderID   string    `gorm:"not null"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	Redirect     string
+	ExpiresAt    time.Time `gorm:"not null"`
+	CreatedAt    time.Time
+}
+
+func (s *LoginState) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+func (s *LoginState) TableName() string {
+	return "federation_login_states"
+}
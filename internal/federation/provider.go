@@ -0,0 +1,163 @@
+package federation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Identity is the user as described by an upstream provider
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	FirstName     string
+	LastName      string
+}
+
+// Provider runs the authorization code flow with PKCE against one upstream
+// OIDC provider. Discovery happens on first use so that an unreachable
+// provider does not prevent startup.
+type Provider struct {
+	config      ProviderConfig
+	redirectURL string
+
+	mu           sync.Mutex
+	oidcProvider *oidc.Provider
+	oauth2Config oauth2.Config
+	verifier     *oidc.IDTokenVerifier
+}
+
+func NewProvider(config ProviderConfig, redirectURL string) *Provider {
+	config.applyDefaults()
+	return &Provider{
+		config:      config,
+		redirectURL: redirectURL,
+	}
+}
+
+func (p *Provider) ID() string {
+	return p.config.ID
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+func (p *Provider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oidcProvider != nil {
+		return nil
+	}
+
+	issuer := strings.TrimSuffix(p.config.DiscoveryURL, "/.well-known/openid-configuration")
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return fmt.Errorf("failed to discover identity provider %s: %w", p.config.ID, err)
+	}
+
+	p.oidcProvider = provider
+	p.oauth2Config = oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  p.redirectURL,
+		Scopes:       p.config.Scopes,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+	return nil
+}
+
+// AuthCodeURL returns the upstream authorization URL for a login attempt
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+	return p.oauth2Config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+// Exchange redeems the authorization code and returns the verified identity
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+
+	token, err := p.oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, errors.New("token response has no id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce does not match")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to read id_token claims: %w", err)
+	}
+
+	// Some providers only release profile claims through the userinfo endpoint
+	if _, ok := claims[p.config.ClaimMapping.Email]; !ok && p.oidcProvider.UserInfoEndpoint() != "" {
+		userInfo, err := p.oidcProvider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get userinfo: %w", err)
+		}
+		if userInfo.Subject != idToken.Subject {
+			return nil, errors.New("userinfo subject does not match id_token")
+		}
+		var userInfoClaims map[string]interface{}
+		if err := userInfo.Claims(&userInfoClaims); err != nil {
+			return nil, fmt.Errorf("failed to read userinfo claims: %w", err)
+		}
+		for name, value := range userInfoClaims {
+			if _, exists := claims[name]; !exists {
+				claims[name] = value
+			}
+		}
+	}
+
+	identity := p.config.ClaimMapping.identity(claims)
+	identity.Subject = idToken.Subject
+	return identity, nil
+}
+
+func (m ClaimMapping) identity(claims map[string]interface{}) *Identity {
+	return &Identity{
+		Email:         strings.ToLower(stringClaim(claims, m.Email)),
+		EmailVerified: boolClaim(claims, m.EmailVerified),
+		Name:          stringClaim(claims, m.Name),
+		FirstName:     stringClaim(claims, m.FirstName),
+		LastName:      stringClaim(claims, m.LastName),
+	}
+}
+
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// boolClaim accepts both JSON booleans and the "true" strings some providers send
+func boolClaim(claims map[string]interface{}, name string) bool {
+	switch value := claims[name].(type) {
+	case bool:
+		return value
+	case string:
+		return strings.EqualFold(value, "true")
+	}
+	return false
+}
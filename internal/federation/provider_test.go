@@ -0,0 +1,169 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIssuer is a minimal OIDC provider that issues an id_token for any
+// authorization code whose PKCE verifier matches the last challenge
+type mockIssuer struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	m := &mockIssuer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                m.server.URL,
+			"authorization_endpoint":                m.server.URL + "/authorize",
+			"token_endpoint":                        m.server.URL + "/token",
+			"jwks_uri":                              m.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   m.server.URL,
+			"aud":   "idm",
+			"sub":   "upstream-user",
+			"nonce": m.nonce,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}
+		for name, value := range m.claims {
+			claims[name] = value
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		idToken, err := token.SignedString(key)
+		require.NoError(t, err)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "upstream-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     idToken,
+		})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize records the PKCE challenge from the authorization URL
+func (m *mockIssuer) authorize(t *testing.T, authURL string) {
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	m.challenge = query.Get("code_challenge")
+}
+
+func newTestProvider(issuer *mockIssuer, mapping ClaimMapping) *Provider {
+	return NewProvider(ProviderConfig{
+		ID:           "corp",
+		DiscoveryURL: issuer.server.URL + "/.well-known/openid-configuration",
+		ClientID:     "idm",
+		ClaimMapping: mapping,
+	}, "http://localhost:8080/login/federated/corp/callback")
+}
+
+func TestExchangeMapsClaims(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.nonce = "nonce-1"
+	issuer.claims = jwt.MapClaims{
+		"mail":           "Jane.Doe@Example.com",
+		"email_verified": "true",
+		"name":           "Jane Doe",
+		"given_name":     "Jane",
+		"family_name":    "Doe",
+	}
+	provider := newTestProvider(issuer, ClaimMapping{Email: "mail"})
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-verifier-verifier-verifier-verifier")
+	require.NoError(t, err)
+	issuer.authorize(t, authURL)
+
+	identity, err := provider.Exchange(ctx, "code", "verifier-verifier-verifier-verifier-verifier", "nonce-1")
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{
+		Subject:       "upstream-user",
+		Email:         "jane.doe@example.com",
+		EmailVerified: true,
+		Name:          "Jane Doe",
+		FirstName:     "Jane",
+		LastName:      "Doe",
+	}, identity)
+}
+
+func TestExchangeRejectsNonceMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.nonce = "other-nonce"
+	provider := newTestProvider(issuer, ClaimMapping{})
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-verifier-verifier-verifier-verifier")
+	require.NoError(t, err)
+	issuer.authorize(t, authURL)
+
+	_, err = provider.Exchange(ctx, "code", "verifier-verifier-verifier-verifier-verifier", "nonce-1")
+	assert.Error(t, err)
+}
+
+func TestExchangeRejectsWrongVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := newTestProvider(issuer, ClaimMapping{})
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", "verifier-verifier-verifier-verifier-verifier")
+	require.NoError(t, err)
+	issuer.authorize(t, authURL)
+
+	_, err = provider.Exchange(ctx, "code", "another-verifier-another-verifier-another", "nonce-1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,282 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/user"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// loginStateTTL is how long a user has to complete the upstream login
+const loginStateTTL = 10 * time.Minute
+
+var (
+	ErrUnknownProvider      = errors.New("unknown identity provider")
+	ErrInvalidLoginState    = errors.New("invalid or expired login state")
+	ErrEmailNotVerified     = errors.New("identity provider did not verify the email address")
+	ErrProvisioningDisabled = errors.New("no account exists for this identity")
+	ErrAccountDisabled      = errors.New("account cannot sign in")
+)
+
+// ProviderInfo is what the login page shows about a provider
+type ProviderInfo struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type FederationService struct {
+	db          *gorm.DB
+	userService *user.UserService
+	providers   map[string]*Provider
+	order       []string
+	logger      *logrus.Logger
+}
+
+// NewFederationService sets up the configured providers. Their callback URL
+// is baseURL + "/login/federated/<id>/callback".
+func NewFederationService(db *gorm.DB, userService *user.UserService, configs []ProviderConfig, baseURL string) *FederationService {
+	s := &FederationService{
+		db:          db,
+		userService: userService,
+		providers:   make(map[string]*Provider),
+		logger:      logrus.New(),
+	}
+	for _, config := range configs {
+		callbackURL := strings.TrimSuffix(baseURL, "/") + "/login/federated/" + config.ID + "/callback"
+		s.providers[config.ID] = NewProvider(config, callbackURL)
+		s.order = append(s.order, config.ID)
+	}
+	return s
+}
+
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *FederationService) WithContext(ctx context.Context) *FederationService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	scoped.userService = s.userService.WithContext(ctx)
+	return &scoped
+}
+
+// Providers lists the configured providers in configuration order
+func (s *FederationService) Providers() []ProviderInfo {
+	providers := make([]ProviderInfo, 0, len(s.order))
+	for _, id := range s.order {
+		providers = append(providers, ProviderInfo{ID: id, Name: s.providers[id].Name()})
+	}
+	return providers
+}
+
+// BeginLogin records a pending login and returns the upstream authorization URL
+func (s *FederationService) BeginLogin(ctx context.Context, providerID, redirect string) (string, error) {
+	provider, ok := s.providers[providerID]
+	if !ok {
+		return "", ErrUnknownProvider
+	}
+
+	state, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	loginState := LoginState{
+		State:        state,
+		ProviderID:   providerID,
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		Redirect:     redirect,
+		ExpiresAt:    time.Now().Add(loginStateTTL),
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, loginState.State, loginState.Nonce, loginState.CodeVerifier)
+	if err != nil {
+		return "", err
+	}
+	if err := s.db.Create(&loginState).Error; err != nil {
+		return "", fmt.Errorf("failed to store login state: %w", err)
+	}
+
+	// Clean up abandoned login attempts
+	if err := s.db.Where("expires_at < ?", time.Now()).Delete(&LoginState{}).Error; err != nil {
+		s.logger.Warnf("Failed to purge expired login states: %v", err)
+	}
+	return authURL, nil
+}
+
+// CompleteLogin redeems the upstream authorization code and returns the local
+// user, linking or provisioning them as needed, together with the redirect
+// saved when the login began
+func (s *FederationService) CompleteLogin(ctx context.Context, providerID, code, state string) (*user.User, string, error) {
+	provider, ok := s.providers[providerID]
+	if !ok {
+		return nil, "", ErrUnknownProvider
+	}
+
+	// Each state can only be used once
+	var loginState LoginState
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("state = ? AND provider_id = ?", state, providerID).First(&loginState).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&loginState).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrInvalidLoginState
+		}
+		return nil, "", fmt.Errorf("failed to get login state: %w", err)
+	}
+	if time.Now().After(loginState.ExpiresAt) {
+		return nil, "", ErrInvalidLoginState
+	}
+
+	identity, err := provider.Exchange(ctx, code, loginState.CodeVerifier, loginState.Nonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	localUser, err := s.resolveUser(providerID, provider.config.AutoProvision, identity)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := localUser.StatusError(); err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrAccountDisabled, err)
+	}
+	return localUser, loginState.Redirect, nil
+}
+
+// resolveUser finds the user linked to the identity. Unlinked identities are
+// linked to the user with the same email, or provisioned, but only when the
+// provider verified the email address.
+func (s *FederationService) resolveUser(providerID string, autoProvision bool, identity *Identity) (*user.User, error) {
+	now := time.Now()
+
+	var link AccountLink
+	err := s.db.Where("provider_id = ? AND subject = ?", providerID, identity.Subject).First(&link).Error
+	if err == nil {
+		if err := s.db.Model(&link).Update("last_login_at", now).Error; err != nil {
+			s.logger.Warnf("Failed to update last login of account link %s: %v", link.ID, err)
+		}
+		linkedUser, err := s.userService.GetUser(link.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if linkedUser == nil {
+			return nil, fmt.Errorf("linked user %s no longer exists", link.UserID)
+		}
+		return linkedUser, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get account link: %w", err)
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	var localUser user.User
+	err = s.db.Where("LOWER(email) = ?", identity.Email).First(&localUser).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if !autoProvision {
+			return nil, ErrProvisioningDisabled
+		}
+		provisioned, err := s.userService.CreateFederatedUser(user.FederatedUserRequest{
+			ProviderID: providerID,
+			Name:       identity.Name,
+			FirstName:  identity.FirstName,
+			LastName:   identity.LastName,
+			Email:      identity.Email,
+		})
+		if err != nil {
+			return nil, err
+		}
+		localUser = *provisioned
+	default:
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	link = AccountLink{
+		UserID:      localUser.ID,
+		ProviderID:  providerID,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		LastLoginAt: &now,
+	}
+	if err := s.db.Create(&link).Error; err != nil {
+		return nil, fmt.Errorf("failed to create account link: %w", err)
+	}
+
+	events.Publish(events.Event{
+		Type:    "user.linked",
+		Subject: localUser.ID.String(),
+		Data: map[string]interface{}{
+			"providerId": providerID,
+			"linkId":     link.ID.String(),
+		},
+	})
+
+	return &localUser, nil
+}
+
+// GetAccountLinks returns the upstream identities linked to a user
+func (s *FederationService) GetAccountLinks(userID uuid.UUID) ([]AccountLink, error) {
+	var links []AccountLink
+	if err := s.db.Where("user_id = ?", userID).Order("created_at").Find(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to get account links: %w", err)
+	}
+	return links, nil
+}
+
+// DeleteAccountLink unlinks an upstream identity from a user
+func (s *FederationService) DeleteAccountLink(userID, linkID uuid.UUID, actor string) (bool, error) {
+	result := s.db.Where("id = ? AND user_id = ?", linkID, userID).Delete(&AccountLink{})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to delete account link: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+
+	events.Publish(events.Event{
+		Type:    "user.unlinked",
+		Subject: userID.String(),
+		Actor:   actor,
+		Data:    map[string]interface{}{"linkId": linkID.String()},
+	})
+	return true, nil
+}
+
+// HandleUserDeprovisioned removes the account links of a deprovisioned user
+func (s *FederationService) HandleUserDeprovisioned(event events.Event) {
+	userID, err := uuid.Parse(event.Subject)
+	if err != nil {
+		return
+	}
+	if err := s.db.Where("user_id = ?", userID).Delete(&AccountLink{}).Error; err != nil {
+		s.logger.Errorf("Failed to delete account links of user %s: %v", userID, err)
+	}
+}
+
+func randomString() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -21,7 +21,7 @@ func NewGroupController(groupService *GroupService) *GroupController {
 }
 
 func (c *GroupController) GetAllGroups(ctx *gin.Context) {
-	groups, err := c.groupService.GetAllGroups()
+	groups, err := c.groupService.WithContext(ctx).GetAllGroups()
 	if err != nil {
 		c.logger.Errorf("Failed to get groups: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get groups"})
@@ -39,7 +39,7 @@ func (c *GroupController) GetGroup(ctx *gin.Context) {
 		return
 	}
 
-	group, err := c.groupService.GetGroup(id)
+	group, err := c.groupService.WithContext(ctx).GetGroup(id)
 	if err != nil {
 		c.logger.Errorf("Failed to get group: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get group"})
@@ -61,7 +61,7 @@ func (c *GroupController) CreateGroup(ctx *gin.Context) {
 		return
 	}
 
-	group, err := c.groupService.CreateGroup(req)
+	group, err := c.groupService.WithContext(ctx).CreateGroup(req)
 	if err != nil {
 		c.logger.Errorf("Failed to create group: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -85,7 +85,7 @@ func (c *GroupController) UpdateGroup(ctx *gin.Context) {
 		return
 	}
 
-	group, err := c.groupService.UpdateGroup(id, req)
+	group, err := c.groupService.WithContext(ctx).UpdateGroup(id, req)
 	if err != nil {
 		c.logger.Errorf("Failed to update group: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -108,7 +108,7 @@ func (c *GroupController) DeleteGroup(ctx *gin.Context) {
 		return
 	}
 
-	err = c.groupService.DeleteGroup(id)
+	err = c.groupService.WithContext(ctx).DeleteGroup(id)
 	if err != nil {
 		c.logger.Errorf("Failed to delete group: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -3,15 +3,20 @@ package group
 import (
 	"time"
 
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/tenant"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type Group struct {
+	tenant.Scoped
 	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	Name        string    `json:"name" gorm:"not null"`
 	DisplayName string    `json:"displayName" gorm:"column:displayname"`
 	Description string    `json:"description"`
+	ExternalID  string    `json:"externalId,omitempty" gorm:"column:external_id;index"`
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 }
@@ -23,6 +28,17 @@ func (g *Group) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// AfterCreate links the group to the authorization system object
+func (g *Group) AfterCreate(tx *gorm.DB) error {
+	return authz.RecordSystemLink(tx, authz.OpWrite, "group", g.ID)
+}
+
+// AfterDelete removes the link of the group to the authorization system
+// object. Delete with the ID set, e.g. Delete(&Group{ID: id}), for it to run.
+func (g *Group) AfterDelete(tx *gorm.DB) error {
+	return authz.RecordSystemLink(tx, authz.OpDelete, "group", g.ID)
+}
+
 func (g *Group) TableName() string {
 	return "groups"
 }
@@ -1,10 +1,13 @@
 package group
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
+	"idmapp-go/internal/events"
+
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -22,6 +25,14 @@ func NewGroupService(db *gorm.DB) *GroupService {
 	}
 }
 
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *GroupService) WithContext(ctx context.Context) *GroupService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
 func (s *GroupService) GetAllGroups() ([]Group, error) {
 	var groups []Group
 	result := s.db.Find(&groups)
@@ -63,6 +74,11 @@ func (s *GroupService) CreateGroup(req GroupCreateRequest) (*Group, error) {
 		return nil, fmt.Errorf("failed to create group: %w", result.Error)
 	}
 
+	events.Publish(events.Event{
+		Type:    "group.created",
+		Subject: group.ID.String(),
+	})
+
 	return &group, nil
 }
 
@@ -99,16 +115,26 @@ func (s *GroupService) UpdateGroup(id uuid.UUID, req GroupUpdateRequest) (*Group
 		return nil, fmt.Errorf("failed to update group: %w", result.Error)
 	}
 
+	events.Publish(events.Event{
+		Type:    "group.updated",
+		Subject: group.ID.String(),
+	})
+
 	return &group, nil
 }
 
 func (s *GroupService) DeleteGroup(id uuid.UUID) error {
-	result := s.db.Delete(&Group{}, "id = ?", id)
+	result := s.db.Delete(&Group{ID: id})
 	if result.Error != nil {
 		return fmt.Errorf("failed to delete group: %w", result.Error)
 	}
 	if result.RowsAffected == 0 {
 		return errors.New("group not found")
 	}
+
+	events.Publish(events.Event{
+		Type:    "group.deleted",
+		Subject: id.String(),
+	})
 	return nil
 }
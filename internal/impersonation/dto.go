@@ -0,0 +1,17 @@
+package impersonation
+
+import "time"
+
+type ImpersonationRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+type ImpersonationResponse struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	ExpiresIn   int       `json:"expires_in"`
+	SessionID   string    `json:"sessionId"`
+	UserID      string    `json:"userId"`
+	ActorID     string    `json:"actorId"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
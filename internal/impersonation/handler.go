@@ -0,0 +1,121 @@
+package impersonation
+
+import (
+	"errors"
+	"net/http"
+
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/session"
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type ImpersonationController struct {
+	impersonationService *ImpersonationService
+	logger               *logrus.Logger
+}
+
+func NewImpersonationController(impersonationService *ImpersonationService) *ImpersonationController {
+	return &ImpersonationController{
+		impersonationService: impersonationService,
+		logger:               logrus.New(),
+	}
+}
+
+func (c *ImpersonationController) StartImpersonation(ctx *gin.Context) {
+	targetID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	actorID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	// Impersonation must start from the admin's own interactive login
+	if middleware.GetActor(ctx) != nil || middleware.GetAuthMethod(ctx) != middleware.AuthMethodJWT {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Impersonation requires the admin's own access token"})
+		return
+	}
+
+	var req ImpersonationRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response, err := c.impersonationService.WithContext(ctx).StartImpersonation(actorID, targetID, req.Reason, session.ClientInfoFromRequest(ctx))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrNotAdmin), errors.Is(err, ErrTargetIsAdmin), errors.Is(err, ErrSelfImpersonation):
+			c.logger.Warnf("Impersonation of %s by %s refused: %v", targetID, actorID, err)
+			ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrTargetNotActive):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.logger.Errorf("Failed to start impersonation: %v", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start impersonation"})
+		}
+		return
+	}
+	if response == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Pages rendered by IDMApp in the same browser show the impersonation banner
+	ctx.SetCookie(session.CookieName, response.SessionID, response.ExpiresIn, "/", "", false, true)
+	ctx.JSON(http.StatusCreated, response)
+}
+
+// EndImpersonation ends the impersonation the current token belongs to
+func (c *ImpersonationController) EndImpersonation(ctx *gin.Context) {
+	sessionID, err := uuid.Parse(middleware.GetSessionID(ctx))
+	if err != nil || middleware.GetActor(ctx) == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Not impersonating"})
+		return
+	}
+
+	if err := c.impersonationService.WithContext(ctx).EndImpersonation(sessionID, middleware.GetActorID(ctx)); err != nil {
+		if errors.Is(err, ErrNotImpersonating) || errors.Is(err, session.ErrSessionNotActive) {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "Not impersonating"})
+			return
+		}
+		c.logger.Errorf("Failed to end impersonation: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to end impersonation"})
+		return
+	}
+
+	session.ClearCookie(ctx)
+	ctx.Status(http.StatusNoContent)
+}
+
+// AuditRequests records every request made while impersonating a user. It
+// must run after AuthMiddleware.
+func (c *ImpersonationController) AuditRequests() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		actor := middleware.GetActor(ctx)
+		if actor == nil {
+			return
+		}
+		events.Publish(events.Event{
+			Type:    "impersonation.request",
+			Subject: middleware.GetUserID(ctx),
+			Actor:   actor.Sub,
+			Data: map[string]interface{}{
+				"sessionId": middleware.GetSessionID(ctx),
+				"method":    ctx.Request.Method,
+				"path":      ctx.Request.URL.Path,
+				"status":    ctx.Writer.Status(),
+				"ipAddress": ctx.ClientIP(),
+			},
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package keyring
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Key is an RSA signing key and the certificate that publishes it
+type Key struct {
+	ID          string
+	PrivateKey  *rsa.PrivateKey
+	Certificate *x509.Certificate
+}
+
+// KeyRing holds the signing keys of this service. Every key is published so
+// that relying parties can verify signatures made before a rotation; the
+// newest key whose certificate is already valid signs.
+type KeyRing struct {
+	keys []*Key
+}
+
+// Load reads "<id>.key" / "<id>.crt" PEM pairs from dir. Keys whose
+// certificate has expired are skipped. An empty dir generates a throwaway key,
+// which is only suitable for development because signatures do not survive a
+// restart.
+func Load(dir string) (*KeyRing, error) {
+	if dir == "" {
+		logrus.Warn("KEYRING_DIR is not set; using an ephemeral signing key")
+		key, err := Generate("ephemeral", time.Now(), 365*24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		return New(key), nil
+	}
+
+	keyFiles, err := filepath.Glob(filepath.Join(dir, "*.key"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list signing keys: %w", err)
+	}
+	var keys []*Key
+	for _, keyFile := range keyFiles {
+		id := strings.TrimSuffix(filepath.Base(keyFile), ".key")
+		key, err := loadKey(id, keyFile, strings.TrimSuffix(keyFile, ".key")+".crt")
+		if err != nil {
+			return nil, err
+		}
+		if time.Now().After(key.Certificate.NotAfter) {
+			logrus.Warnf("Skipping signing key %s: certificate expired on %s", id, key.Certificate.NotAfter)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no valid signing keys in %s", dir)
+	}
+	return New(keys...), nil
+}
+
+// New returns a key ring holding keys
+func New(keys ...*Key) *KeyRing {
+	sorted := append([]*Key(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Certificate.NotBefore.After(sorted[j].Certificate.NotBefore)
+	})
+	return &KeyRing{keys: sorted}
+}
+
+// Active returns the key to sign with: the newest key whose certificate is
+// already valid, or the oldest key if none is valid yet
+func (k *KeyRing) Active() *Key {
+	now := time.Now()
+	for _, key := range k.keys {
+		if !now.Before(key.Certificate.NotBefore) {
+			return key
+		}
+	}
+	return k.keys[len(k.keys)-1]
+}
+
+// Keys returns every key, newest first
+func (k *KeyRing) Keys() []*Key {
+	return k.keys
+}
+
+// Generate creates a key with a self-signed certificate valid from notBefore
+// for validity
+func Generate(id string, notBefore time.Time, validity time.Duration) (*Key, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate serial: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "idmapp-go " + id},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return &Key{ID: id, PrivateKey: privateKey, Certificate: certificate}, nil
+}
+
+func loadKey(id, keyFile, certFile string) (*Key, error) {
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key %s: %w", id, err)
+	}
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate for signing key %s: %w", id, err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", id)
+	}
+	privateKey, err := parsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key %s: %w", id, err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("certificate for signing key %s is not PEM encoded", id)
+	}
+	certificate, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate for signing key %s: %w", id, err)
+	}
+	if !privateKey.PublicKey.Equal(certificate.PublicKey) {
+		return nil, fmt.Errorf("certificate for signing key %s does not match the key", id)
+	}
+
+	return &Key{ID: id, PrivateKey: privateKey, Certificate: certificate}, nil
+}
+
+// parsePrivateKey accepts PKCS#1 and PKCS#8 RSA keys
+func parsePrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("only RSA keys are supported")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,63 @@
+package keyring
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKey(t *testing.T, dir string, key *Key) {
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key.PrivateKey)})
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: key.Certificate.Raw})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, key.ID+".key"), keyPEM, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, key.ID+".crt"), certPEM, 0o644))
+}
+
+func TestLoadSelectsNewestValidKey(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	current, err := Generate("2024", now.Add(-24*time.Hour), 365*24*time.Hour)
+	require.NoError(t, err)
+	upcoming, err := Generate("2025", now.Add(24*time.Hour), 365*24*time.Hour)
+	require.NoError(t, err)
+	expired, err := Generate("2023", now.Add(-48*time.Hour), time.Hour)
+	require.NoError(t, err)
+	for _, key := range []*Key{current, upcoming, expired} {
+		writeKey(t, dir, key)
+	}
+
+	ring, err := Load(dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, "2024", ring.Active().ID)
+	require.Len(t, ring.Keys(), 2)
+	assert.Equal(t, "2025", ring.Keys()[0].ID)
+}
+
+func TestLoadRejectsMismatchedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	first, err := Generate("first", time.Now(), time.Hour)
+	require.NoError(t, err)
+	second, err := Generate("second", time.Now(), time.Hour)
+	require.NoError(t, err)
+
+	writeKey(t, dir, first)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: second.Certificate.Raw})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "first.crt"), certPEM, 0o644))
+
+	_, err = Load(dir)
+	assert.Error(t, err)
+}
+
+func TestLoadWithoutDirGeneratesKey(t *testing.T) {
+	ring, err := Load("")
+	require.NoError(t, err)
+	assert.NotNil(t, ring.Active().PrivateKey)
+}
@@ -1,9 +1,13 @@
 package member
 
 import (
+	"errors"
 	"net/http"
 
 	"idmapp-go/dto"
+	"idmapp-go/internal/sod"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/models"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -29,7 +33,18 @@ func (c *MemberController) AddMember(ctx *gin.Context) {
 		return
 	}
 
-	member, err := c.memberService.ProcessMemberOperation(req)
+	member, err := c.memberService.WithContext(ctx).ProcessMemberOperation(req)
+	if errors.Is(err, tenant.ErrNotOwned) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, models.ErrInvalidValidity) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if sod.RespondViolation(ctx, err) {
+		return
+	}
 	if err != nil {
 		c.logger.Errorf("Failed to process member operation: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -46,7 +61,7 @@ func (c *MemberController) AddMember(ctx *gin.Context) {
 }
 
 func (c *MemberController) GetAllMembers(ctx *gin.Context) {
-	members, err := c.memberService.GetAllMembers()
+	members, err := c.memberService.WithContext(ctx).GetAllMembers()
 	if err != nil {
 		c.logger.Errorf("Failed to get members: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get members"})
@@ -64,7 +79,21 @@ func (c *MemberController) GetMembersByGroupID(ctx *gin.Context) {
 		return
 	}
 
-	members, err := c.memberService.GetMembersByGroupID(groupID)
+	if !validExpand(ctx) {
+		return
+	}
+	if ctx.Query("expand") == ExpandTransitive {
+		members, err := c.memberService.WithContext(ctx).GetTransitiveMembers(groupID)
+		if err != nil {
+			c.logger.Errorf("Failed to get transitive members: %v", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get members"})
+			return
+		}
+		ctx.JSON(http.StatusOK, members)
+		return
+	}
+
+	members, err := c.memberService.WithContext(ctx).GetMembersByGroupID(groupID)
 	if err != nil {
 		c.logger.Errorf("Failed to get members by group ID: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get members"})
@@ -82,7 +111,21 @@ func (c *MemberController) GetMembersByUserID(ctx *gin.Context) {
 		return
 	}
 
-	members, err := c.memberService.GetMembersByUserID(userID)
+	if !validExpand(ctx) {
+		return
+	}
+	if ctx.Query("expand") == ExpandTransitive {
+		groups, err := c.memberService.WithContext(ctx).GetTransitiveGroups(userID)
+		if err != nil {
+			c.logger.Errorf("Failed to get transitive groups: %v", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get members"})
+			return
+		}
+		ctx.JSON(http.StatusOK, groups)
+		return
+	}
+
+	members, err := c.memberService.WithContext(ctx).GetMembersByUserID(userID)
 	if err != nil {
 		c.logger.Errorf("Failed to get members by user ID: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get members"})
@@ -91,3 +134,64 @@ func (c *MemberController) GetMembersByUserID(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, members)
 }
+
+// HandleNestedGroupOperation adds a group to or removes it from another
+// group
+func (c *MemberController) HandleNestedGroupOperation(ctx *gin.Context) {
+	var req dto.NestedGroupOpRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	nested, err := c.memberService.WithContext(ctx).ProcessNestedGroupOperation(req)
+	if sod.RespondViolation(ctx, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, ErrGroupNotFound), errors.Is(err, ErrNestedGroupNotFound), errors.Is(err, tenant.ErrNotOwned):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrNestedGroupExists), errors.Is(err, ErrNestingCycle), errors.Is(err, ErrNestingDepthExceeded):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.logger.Errorf("Failed to process nested group operation: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process nested group operation"})
+		return
+	}
+
+	if nested == nil {
+		ctx.Status(http.StatusNoContent)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, nested)
+}
+
+// GetNestedGroups lists the groups that are direct members of a group
+func (c *MemberController) GetNestedGroups(ctx *gin.Context) {
+	groupID, err := uuid.Parse(ctx.Param("groupId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	nested, err := c.memberService.WithContext(ctx).GetNestedGroups(groupID)
+	if err != nil {
+		c.logger.Errorf("Failed to get nested groups: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get nested groups"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, nested)
+}
+
+// validExpand rejects expand options other than transitive
+func validExpand(ctx *gin.Context) bool {
+	if expand := ctx.Query("expand"); expand != "" && expand != ExpandTransitive {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expand, expected " + ExpandTransitive})
+		return false
+	}
+	return true
+}
@@ -3,16 +3,21 @@ package member
 import (
 	"time"
 
+	"idmapp-go/internal/tenant"
+	"idmapp-go/models"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type Member struct {
+	tenant.Scoped
 	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	GroupID   uuid.UUID `json:"groupId" gorm:"type:uuid;not null;column:group_id"`
 	UserID    uuid.UUID `json:"userId" gorm:"type:uuid;not null;column:user_id"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
+	models.Validity
 }
 
 func (m *Member) BeforeCreate(tx *gorm.DB) error {
@@ -25,3 +30,23 @@ func (m *Member) BeforeCreate(tx *gorm.DB) error {
 func (m *Member) TableName() string {
 	return "members"
 }
+
+// NestedGroup makes every member of MemberGroupID a member of GroupID
+type NestedGroup struct {
+	tenant.Scoped
+	ID            uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	GroupID       uuid.UUID `json:"groupId" gorm:"type:uuid;not null;uniqueIndex:idx_nested_groups_pair"`
+	MemberGroupID uuid.UUID `json:"memberGroupId" gorm:"type:uuid;not null;uniqueIndex:idx_nested_groups_pair;index"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+func (n *NestedGroup) BeforeCreate(tx *gorm.DB) error {
+	if n.ID == uuid.Nil {
+		n.ID = uuid.New()
+	}
+	return nil
+}
+
+func (n *NestedGroup) TableName() string {
+	return "nested_groups"
+}
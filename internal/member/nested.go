@@ -0,0 +1,321 @@
+package member
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"idmapp-go/dto"
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/sod"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxNestingDepth is the most groups a chain of nested groups may pass
+// through below its top group. Adding a group that would make a chain
+// longer is rejected.
+const MaxNestingDepth = 8
+
+// nestingLockKey is the advisory lock serializing changes to nested groups,
+// so concurrent additions can't form a cycle together
+const nestingLockKey = 0x6e657374
+
+var (
+	ErrGroupNotFound        = errors.New("group not found")
+	ErrNestedGroupExists    = errors.New("group is already a member of this group")
+	ErrNestedGroupNotFound  = errors.New("group is not a member of this group")
+	ErrNestingCycle         = errors.New("group would become a member of itself")
+	ErrNestingDepthExceeded = fmt.Errorf("groups can't be nested more than %d deep", MaxNestingDepth)
+)
+
+// ExpandTransitive is the expand option listing members of nested groups
+const ExpandTransitive = "transitive"
+
+// TransitiveMember is a user in a group, directly when Depth is 0, or
+// through ViaGroupID, the group the user is in, nested Depth levels below
+// GroupID
+type TransitiveMember struct {
+	GroupID    uuid.UUID `json:"groupId"`
+	UserID     uuid.UUID `json:"userId"`
+	ViaGroupID uuid.UUID `json:"viaGroupId"`
+	Depth      int       `json:"depth"`
+}
+
+// UserGroupsSQL is a recursive CTE of the groups of a user, directly or
+// through nested groups: the group, the group the user is in and how many
+// levels it is nested below the group. Memberships outside their window are
+// left out. It takes the user ID and MaxNestingDepth as arguments.
+var UserGroupsSQL = `user_groups(group_id, via_group_id, depth) AS (
+	SELECT group_id, group_id, 0 FROM members WHERE user_id = ? AND ` + models.InEffectSQL("members") + `
+	UNION
+	SELECT n.group_id, g.via_group_id, g.depth + 1 FROM nested_groups n
+	JOIN user_groups g ON n.member_group_id = g.group_id
+	WHERE g.depth < ?
+)`
+
+// HasRole reports whether the role named roleName is assigned to the user,
+// directly or through one of their groups, and in effect
+func HasRole(db *gorm.DB, userID uuid.UUID, roleName string) (bool, error) {
+	var count int64
+	err := db.Raw(`WITH RECURSIVE `+UserGroupsSQL+`
+		SELECT COUNT(*) FROM role_members
+		JOIN roles ON roles.id = role_members.role_id
+		WHERE roles.name = ? AND `+models.InEffectSQL("role_members")+`
+		AND ((role_members.type = 'USER' AND role_members.entity_id = ?) OR
+			(role_members.type = 'GROUP' AND role_members.entity_id IN (SELECT group_id FROM user_groups)))`,
+		userID, MaxNestingDepth, roleName, userID).
+		Find(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check role %s: %w", roleName, err)
+	}
+	return count > 0, nil
+}
+
+// groupTreeSQL is a recursive CTE of a group and the groups nested below
+// it, with how deep each is. It takes the group ID and a depth bound.
+const groupTreeSQL = `group_tree(group_id, depth) AS (
+	SELECT id, 0 FROM groups WHERE id = ?
+	UNION
+	SELECT n.member_group_id, t.depth + 1 FROM nested_groups n
+	JOIN group_tree t ON n.group_id = t.group_id
+	WHERE t.depth < ?
+)`
+
+// groupAncestorsSQL is the reverse of groupTreeSQL: a group and the groups
+// it is nested in
+const groupAncestorsSQL = `group_tree(group_id, depth) AS (
+	SELECT id, 0 FROM groups WHERE id = ?
+	UNION
+	SELECT n.group_id, t.depth + 1 FROM nested_groups n
+	JOIN group_tree t ON n.member_group_id = t.group_id
+	WHERE t.depth < ?
+)`
+
+// GetNestedGroups returns the groups that are direct members of the group
+func (s *MemberService) GetNestedGroups(groupID uuid.UUID) ([]NestedGroup, error) {
+	var nested []NestedGroup
+	if err := s.db.Where("group_id = ?", groupID).Order("created_at").Find(&nested).Error; err != nil {
+		return nil, fmt.Errorf("failed to get nested groups: %w", err)
+	}
+	return nested, nil
+}
+
+// GetTransitiveMembers returns the users in the group directly or through
+// nested groups, once for each group they are in. Memberships outside their
+// window are left out.
+func (s *MemberService) GetTransitiveMembers(groupID uuid.UUID) ([]TransitiveMember, error) {
+	if groupID == uuid.Nil {
+		return nil, errors.New("group ID cannot be null")
+	}
+
+	var members []TransitiveMember
+	err := s.db.Raw(`WITH RECURSIVE `+groupTreeSQL+`
+		SELECT CAST(? AS uuid) AS group_id, m.user_id, m.group_id AS via_group_id, MIN(t.depth) AS depth
+		FROM group_tree t JOIN members m ON m.group_id = t.group_id AND `+models.InEffectSQL("m")+`
+		GROUP BY m.user_id, m.group_id
+		ORDER BY MIN(t.depth), m.user_id`, groupID, MaxNestingDepth, groupID).Find(&members).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transitive members: %w", err)
+	}
+	return members, nil
+}
+
+// GetTransitiveGroups returns the groups the user is in directly or through
+// nested groups, once for each group the user is in directly. Memberships
+// outside their window are left out.
+func (s *MemberService) GetTransitiveGroups(userID uuid.UUID) ([]TransitiveMember, error) {
+	if userID == uuid.Nil {
+		return nil, errors.New("user ID cannot be null")
+	}
+
+	var groups []TransitiveMember
+	err := s.db.Raw(`WITH RECURSIVE `+UserGroupsSQL+`
+		SELECT g.group_id, CAST(? AS uuid) AS user_id, g.via_group_id, MIN(g.depth) AS depth
+		FROM user_groups g
+		GROUP BY g.group_id, g.via_group_id
+		ORDER BY MIN(g.depth), g.group_id`, userID, MaxNestingDepth, userID).Find(&groups).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transitive groups: %w", err)
+	}
+	return groups, nil
+}
+
+// AddNestedGroup makes memberGroupID a member of groupID. It is rejected if
+// groupID is already nested in memberGroupID, if the longest chain through
+// the new link would exceed MaxNestingDepth, or if the roles of groupID
+// would make a member break a separation-of-duties policy.
+func (s *MemberService) AddNestedGroup(groupID, memberGroupID uuid.UUID) (*NestedGroup, error) {
+	nested := NestedGroup{
+		GroupID:       groupID,
+		MemberGroupID: memberGroupID,
+		CreatedAt:     time.Now(),
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", nestingLockKey).Error; err != nil {
+			return fmt.Errorf("failed to lock nested groups: %w", err)
+		}
+		if err := tenant.CheckOwns(tx, "group", groupID); err != nil {
+			return err
+		}
+		if err := tenant.CheckOwns(tx, "group", memberGroupID); err != nil {
+			return err
+		}
+		below, err := nestingDepths(tx, groupTreeSQL, memberGroupID)
+		if err != nil {
+			return err
+		}
+		above, err := nestingDepths(tx, groupAncestorsSQL, groupID)
+		if err != nil {
+			return err
+		}
+		if err := checkNesting(groupID, below, above); err != nil {
+			return err
+		}
+
+		var count int64
+		if err := tx.Model(&NestedGroup{}).Where("group_id = ? AND member_group_id = ?", groupID, memberGroupID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check nested group: %w", err)
+		}
+		if count > 0 {
+			return ErrNestedGroupExists
+		}
+		guard, err := sod.Begin(tx)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&nested).Error; err != nil {
+			return fmt.Errorf("failed to add nested group: %w", err)
+		}
+		// The members of memberGroupID inherit the roles of groupID
+		if err := guard.Check(tx); err != nil {
+			return err
+		}
+		return authz.Record(tx, authz.OpWrite, authz.NestedGroupTuple(groupID, memberGroupID))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.Event{
+		Type:    "member.group_added",
+		Subject: groupID.String(),
+		Data:    map[string]interface{}{"memberGroupId": memberGroupID.String()},
+	})
+	return &nested, nil
+}
+
+// RemoveNestedGroup removes memberGroupID from groupID
+func (s *MemberService) RemoveNestedGroup(groupID, memberGroupID uuid.UUID) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("group_id = ? AND member_group_id = ?", groupID, memberGroupID).Delete(&NestedGroup{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to remove nested group: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrNestedGroupNotFound
+		}
+		return authz.Record(tx, authz.OpDelete, authz.NestedGroupTuple(groupID, memberGroupID))
+	})
+	if err != nil {
+		return err
+	}
+
+	events.Publish(events.Event{
+		Type:    "member.group_removed",
+		Subject: groupID.String(),
+		Data:    map[string]interface{}{"memberGroupId": memberGroupID.String()},
+	})
+	return nil
+}
+
+func (s *MemberService) ProcessNestedGroupOperation(req dto.NestedGroupOpRequest) (*NestedGroup, error) {
+	switch req.Op {
+	case dto.OpTypeAdd:
+		return s.AddNestedGroup(req.GroupID, req.MemberGroupID)
+	case dto.OpTypeRemove:
+		return nil, s.RemoveNestedGroup(req.GroupID, req.MemberGroupID)
+	default:
+		return nil, fmt.Errorf("invalid operation type: %s", req.Op)
+	}
+}
+
+// HandleGroupDeleted removes the nesting of a deleted group in both
+// directions
+func (s *MemberService) HandleGroupDeleted(event events.Event) {
+	groupID, err := uuid.Parse(event.Subject)
+	if err != nil {
+		return
+	}
+	if err := DeleteNestedGroups(s.db, groupID); err != nil {
+		s.logger.Errorf("Failed to remove nested groups of deleted group %s: %v", groupID, err)
+	}
+}
+
+// DeleteNestedGroups removes the group from the groups it is in and the
+// groups in it, and queues the removal of their tuples. Pass a transaction
+// to make it part of it.
+func DeleteNestedGroups(db *gorm.DB, groupID uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var removed []NestedGroup
+		if err := tx.Clauses(clause.Returning{}).Where("group_id = ? OR member_group_id = ?", groupID, groupID).Delete(&removed).Error; err != nil {
+			return fmt.Errorf("failed to remove nested groups: %w", err)
+		}
+		tuples := make([]authz.Tuple, len(removed))
+		for i, n := range removed {
+			tuples[i] = authz.NestedGroupTuple(n.GroupID, n.MemberGroupID)
+		}
+		return authz.Record(tx, authz.OpDelete, tuples...)
+	})
+}
+
+// nestingDepths walks treeSQL from the group and returns every group
+// reached with its greatest depth. It returns ErrGroupNotFound if the group
+// doesn't exist.
+func nestingDepths(tx *gorm.DB, treeSQL string, groupID uuid.UUID) (map[uuid.UUID]int, error) {
+	var rows []struct {
+		GroupID uuid.UUID
+		Depth   int
+	}
+	// One level past the limit is enough to tell a chain is too deep
+	err := tx.Raw(`WITH RECURSIVE `+treeSQL+`
+		SELECT group_id, MAX(depth) AS depth FROM group_tree GROUP BY group_id`, groupID, MaxNestingDepth+1).Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk nested groups: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, ErrGroupNotFound
+	}
+	depths := make(map[uuid.UUID]int, len(rows))
+	for _, row := range rows {
+		depths[row.GroupID] = row.Depth
+	}
+	return depths, nil
+}
+
+// checkNesting decides whether a group can be nested in groupID, given the
+// groups below the new member (itself included) and above groupID (itself
+// included) with their depths
+func checkNesting(groupID uuid.UUID, below, above map[uuid.UUID]int) error {
+	if _, ok := below[groupID]; ok {
+		return ErrNestingCycle
+	}
+	deepest := 0
+	for _, depth := range below {
+		deepest = max(deepest, depth)
+	}
+	highest := 0
+	for _, depth := range above {
+		highest = max(highest, depth)
+	}
+	if highest+1+deepest > MaxNestingDepth {
+		return ErrNestingDepthExceeded
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package member
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckNesting(t *testing.T) {
+	parent, child, grandchild := uuid.New(), uuid.New(), uuid.New()
+
+	// child, holding grandchild, goes into a top-level parent
+	assert.NoError(t, checkNesting(parent, map[uuid.UUID]int{child: 0, grandchild: 1}, map[uuid.UUID]int{parent: 0}))
+
+	// A group can't contain itself, directly or through its members
+	assert.ErrorIs(t, checkNesting(parent, map[uuid.UUID]int{parent: 0}, map[uuid.UUID]int{parent: 0}), ErrNestingCycle)
+	assert.ErrorIs(t, checkNesting(parent, map[uuid.UUID]int{child: 0, parent: 1}, map[uuid.UUID]int{parent: 0}), ErrNestingCycle)
+
+	// The chain above the parent, the new link and the chain below the child
+	// add up
+	above := map[uuid.UUID]int{parent: 0, uuid.New(): MaxNestingDepth - 1}
+	assert.NoError(t, checkNesting(parent, map[uuid.UUID]int{child: 0}, above))
+	assert.ErrorIs(t, checkNesting(parent, map[uuid.UUID]int{child: 0, grandchild: 1}, above), ErrNestingDepthExceeded)
+}
@@ -1,15 +1,22 @@
 package member
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
 	"idmapp-go/dto"
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/sod"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/models"
 
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MemberService struct {
@@ -24,6 +31,14 @@ func NewMemberService(db *gorm.DB) *MemberService {
 	}
 }
 
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *MemberService) WithContext(ctx context.Context) *MemberService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
 func (s *MemberService) GetMember(groupID, userID uuid.UUID) (*Member, error) {
 	if groupID == uuid.Nil || userID == uuid.Nil {
 		return nil, errors.New("group ID and user ID cannot be null")
@@ -85,18 +100,58 @@ func (s *MemberService) AddMember(req dto.MemberOpRequest) (*Member, error) {
 		return nil, errors.New("member already exists in this group")
 	}
 
+	now := time.Now()
+	validity, err := models.NewValidity(req.ValidFrom, req.ValidUntil, now)
+	if err != nil {
+		return nil, err
+	}
 	member := Member{
 		GroupID:   req.GroupID,
 		UserID:    req.UserID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Validity:  validity,
 	}
+	scheduled := validity.Scheduled(now)
 
-	result := s.db.Create(&member)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to add member: %w", result.Error)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tenant.CheckOwns(tx, "group", member.GroupID); err != nil {
+			return err
+		}
+		if err := tenant.CheckOwns(tx, "user", member.UserID); err != nil {
+			return err
+		}
+		guard, err := sod.Begin(tx)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(&member).Error; err != nil {
+			return fmt.Errorf("failed to add member: %w", err)
+		}
+		// The roles of the group must not break a policy for the user
+		if err := guard.Check(tx); err != nil {
+			return err
+		}
+		// Scheduled memberships get their tuple when they take effect
+		if scheduled {
+			return nil
+		}
+		return authz.Record(tx, authz.OpWrite, authz.GroupMemberTuple(member.GroupID, member.UserID))
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	eventType := "member.added"
+	if scheduled {
+		eventType = "member.scheduled"
+	}
+	events.Publish(events.Event{
+		Type:    eventType,
+		Subject: member.GroupID.String(),
+		Data:    validity.EventData(map[string]interface{}{"userId": member.UserID.String()}),
+	})
+
 	return &member, nil
 }
 
@@ -105,16 +160,48 @@ func (s *MemberService) RemoveMember(groupID, userID uuid.UUID) error {
 		return errors.New("group ID and user ID cannot be null")
 	}
 
-	result := s.db.Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&Member{})
-	if result.Error != nil {
-		return fmt.Errorf("failed to remove member: %w", result.Error)
-	}
-	if result.RowsAffected == 0 {
-		return errors.New("member not found")
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("group_id = ? AND user_id = ?", groupID, userID).Delete(&Member{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to remove member: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("member not found")
+		}
+		return authz.Record(tx, authz.OpDelete, authz.GroupMemberTuple(groupID, userID))
+	})
+	if err != nil {
+		return err
 	}
+
+	events.Publish(events.Event{
+		Type:    "member.removed",
+		Subject: groupID.String(),
+		Data:    map[string]interface{}{"userId": userID.String()},
+	})
 	return nil
 }
 
+// DeleteGroupMembers removes every member of the group, user or nested
+// group, and the group from the groups it is in, and queues the removal of
+// their tuples. Pass a transaction to make it part of it.
+func DeleteGroupMembers(db *gorm.DB, groupID uuid.UUID) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var removed []Member
+		if err := tx.Clauses(clause.Returning{}).Where("group_id = ?", groupID).Delete(&removed).Error; err != nil {
+			return fmt.Errorf("failed to remove group members: %w", err)
+		}
+		tuples := make([]authz.Tuple, len(removed))
+		for i, m := range removed {
+			tuples[i] = authz.GroupMemberTuple(m.GroupID, m.UserID)
+		}
+		if err := authz.Record(tx, authz.OpDelete, tuples...); err != nil {
+			return err
+		}
+		return DeleteNestedGroups(tx, groupID)
+	})
+}
+
 func (s *MemberService) ProcessMemberOperation(req dto.MemberOpRequest) (*Member, error) {
 	switch req.Op {
 	case dto.OpTypeAdd:
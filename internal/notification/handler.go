@@ -0,0 +1,67 @@
+package notification
+
+import (
+	"errors"
+	"net/http"
+
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type NotificationController struct {
+	service *NotificationService
+	logger  *logrus.Logger
+}
+
+func NewNotificationController(service *NotificationService) *NotificationController {
+	return &NotificationController{
+		service: service,
+		logger:  logrus.New(),
+	}
+}
+
+// GetMyNotifications lists the notifications of the signed-in user; pass
+// unread=true for the unread ones only
+func (c *NotificationController) GetMyNotifications(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	notifications, err := c.service.WithContext(ctx).GetNotifications(userID, ctx.Query("unread") == "true")
+	if err != nil {
+		c.logger.Errorf("Failed to get notifications: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get notifications"})
+		return
+	}
+	ctx.JSON(http.StatusOK, notifications)
+}
+
+// MarkMyNotificationRead marks a notification of the signed-in user as read
+func (c *NotificationController) MarkMyNotificationRead(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID"})
+		return
+	}
+
+	if err := c.service.WithContext(ctx).MarkRead(userID, id); err != nil {
+		if errors.Is(err, ErrNotificationNotFound) {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.logger.Errorf("Failed to mark notification read: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark notification read"})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
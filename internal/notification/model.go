@@ -0,0 +1,43 @@
+package notification
+
+import (
+	"time"
+
+	"idmapp-go/internal/tenant"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Notification types
+const (
+	TypeAssignmentExpiring   = "assignment.expiring"
+	TypeAccessRequestDecided = "access_request.decided"
+)
+
+// Notification is a message for a user, shown in their account
+type Notification struct {
+	tenant.Scoped
+	ID      uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID  uuid.UUID `json:"userId" gorm:"type:uuid;not null;index;column:user_id"`
+	Type    string    `json:"type" gorm:"type:varchar(64);not null"`
+	Message string    `json:"message" gorm:"not null"`
+	// ResourceType and ResourceID name what the notification is about,
+	// such as the group or role of an expiring assignment
+	ResourceType string     `json:"resourceType,omitempty" gorm:"type:varchar(32)"`
+	ResourceID   *uuid.UUID `json:"resourceId,omitempty" gorm:"type:uuid"`
+	ExpiresAt    *time.Time `json:"expiresAt,omitempty"`
+	ReadAt       *time.Time `json:"readAt,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+}
+
+func (n *Notification) BeforeCreate(tx *gorm.DB) error {
+	if n.ID == uuid.Nil {
+		n.ID = uuid.New()
+	}
+	return nil
+}
+
+func (n *Notification) TableName() string {
+	return "notifications"
+}
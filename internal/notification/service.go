@@ -0,0 +1,89 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"idmapp-go/internal/events"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var ErrNotificationNotFound = errors.New("notification not found")
+
+type NotificationService struct {
+	db     *gorm.DB
+	logger *logrus.Logger
+}
+
+func NewNotificationService(db *gorm.DB) *NotificationService {
+	return &NotificationService{
+		db:     db,
+		logger: logrus.New(),
+	}
+}
+
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *NotificationService) WithContext(ctx context.Context) *NotificationService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
+// Notify stores the notifications and publishes a notification.created
+// event for each. Pass a transaction to make it part of it; the events are
+// published even if it is rolled back.
+func Notify(db *gorm.DB, notifications ...Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	if err := db.Create(&notifications).Error; err != nil {
+		return fmt.Errorf("failed to create notifications: %w", err)
+	}
+	for _, n := range notifications {
+		events.Publish(events.Event{
+			Type:    "notification.created",
+			Subject: n.UserID.String(),
+			Data:    map[string]interface{}{"id": n.ID.String(), "type": n.Type, "message": n.Message},
+		})
+	}
+	return nil
+}
+
+// GetNotifications lists the notifications of a user, newest first
+func (s *NotificationService) GetNotifications(userID uuid.UUID, unreadOnly bool) ([]Notification, error) {
+	query := s.db.Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	var notifications []Notification
+	if err := query.Order("created_at DESC").Find(&notifications).Error; err != nil {
+		return nil, fmt.Errorf("failed to get notifications: %w", err)
+	}
+	return notifications, nil
+}
+
+// MarkRead marks a notification of the user as read
+func (s *NotificationService) MarkRead(userID, id uuid.UUID) error {
+	result := s.db.Model(&Notification{}).
+		Where("id = ? AND user_id = ? AND read_at IS NULL", id, userID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to mark notification read: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		var count int64
+		if err := s.db.Model(&Notification{}).Where("id = ? AND user_id = ?", id, userID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to mark notification read: %w", err)
+		}
+		if count == 0 {
+			return ErrNotificationNotFound
+		}
+	}
+	return nil
+}
@@ -1,9 +1,12 @@
 package org
 
+import "github.com/google/uuid"
+
 type OrgCreateRequest struct {
-	Name        string `json:"name" binding:"required"`
-	DisplayName string `json:"displayName"`
-	Description string `json:"description"`
+	Name        string     `json:"name" binding:"required"`
+	DisplayName string     `json:"displayName"`
+	Description string     `json:"description"`
+	ParentID    *uuid.UUID `json:"parentId"`
 }
 
 type OrgUpdateRequest struct {
@@ -12,6 +15,11 @@ type OrgUpdateRequest struct {
 	Description string `json:"description"`
 }
 
+// OrgMoveRequest places an org below another
+type OrgMoveRequest struct {
+	ParentID uuid.UUID `json:"parentId" binding:"required"`
+}
+
 type OrgResponse struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
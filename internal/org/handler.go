@@ -1,6 +1,7 @@
 package org
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -21,7 +22,7 @@ func NewOrgController(orgService *OrgService) *OrgController {
 }
 
 func (c *OrgController) GetAllOrgs(ctx *gin.Context) {
-	orgs, err := c.orgService.GetAllOrgs()
+	orgs, err := c.orgService.WithContext(ctx).GetAllOrgs()
 	if err != nil {
 		c.logger.Errorf("Failed to get organizations: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organizations"})
@@ -39,7 +40,7 @@ func (c *OrgController) GetOrg(ctx *gin.Context) {
 		return
 	}
 
-	org, err := c.orgService.GetOrg(id)
+	org, err := c.orgService.WithContext(ctx).GetOrg(id)
 	if err != nil {
 		c.logger.Errorf("Failed to get organization: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization"})
@@ -61,8 +62,37 @@ func (c *OrgController) CreateOrg(ctx *gin.Context) {
 		return
 	}
 
-	org, err := c.orgService.CreateOrg(req)
+	c.createOrg(ctx, req)
+}
+
+// CreateChildOrg creates an org below the org in the path
+func (c *OrgController) CreateChildOrg(ctx *gin.Context) {
+	parentID, err := uuid.Parse(ctx.Param("id"))
 	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	var req OrgCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ParentID = &parentID
+
+	c.createOrg(ctx, req)
+}
+
+func (c *OrgController) createOrg(ctx *gin.Context, req OrgCreateRequest) {
+	org, err := c.orgService.WithContext(ctx).CreateOrg(req)
+	switch {
+	case errors.Is(err, ErrParentNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrOrgDepthExceeded):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		c.logger.Errorf("Failed to create organization: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -85,7 +115,7 @@ func (c *OrgController) UpdateOrg(ctx *gin.Context) {
 		return
 	}
 
-	org, err := c.orgService.UpdateOrg(id, req)
+	org, err := c.orgService.WithContext(ctx).UpdateOrg(id, req)
 	if err != nil {
 		c.logger.Errorf("Failed to update organization: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -108,7 +138,11 @@ func (c *OrgController) DeleteOrg(ctx *gin.Context) {
 		return
 	}
 
-	err = c.orgService.DeleteOrg(id)
+	err = c.orgService.WithContext(ctx).DeleteOrg(id)
+	if errors.Is(err, ErrOrgHasChildren) {
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.logger.Errorf("Failed to delete organization: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -117,3 +151,104 @@ func (c *OrgController) DeleteOrg(ctx *gin.Context) {
 
 	ctx.Status(http.StatusNoContent)
 }
+
+// GetChildren lists the orgs directly below an org
+func (c *OrgController) GetChildren(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	children, err := c.orgService.WithContext(ctx).GetChildren(id)
+	if err != nil {
+		c.logger.Errorf("Failed to get child organizations: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get child organizations"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, children)
+}
+
+// GetSubtree lists an org and every org below it
+func (c *OrgController) GetSubtree(ctx *gin.Context) {
+	c.listTree(ctx, c.orgService.WithContext(ctx).GetSubtree, "subtree")
+}
+
+// GetAncestors lists the orgs above an org, root first
+func (c *OrgController) GetAncestors(ctx *gin.Context) {
+	c.listTree(ctx, c.orgService.WithContext(ctx).GetAncestors, "ancestors")
+}
+
+func (c *OrgController) listTree(ctx *gin.Context, list func(uuid.UUID) ([]Org, error), what string) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	orgs, err := list(id)
+	if err != nil {
+		c.logger.Errorf("Failed to get organization %s: %v", what, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get organization " + what})
+		return
+	}
+
+	if orgs == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, orgs)
+}
+
+// MoveOrg places an org, and everything below it, below another org
+func (c *OrgController) MoveOrg(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	var req OrgMoveRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.moveOrg(ctx, id, &req.ParentID)
+}
+
+// MakeRootOrg takes an org, and everything below it, out of its parent
+func (c *OrgController) MakeRootOrg(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+		return
+	}
+
+	c.moveOrg(ctx, id, nil)
+}
+
+func (c *OrgController) moveOrg(ctx *gin.Context, id uuid.UUID, parentID *uuid.UUID) {
+	org, err := c.orgService.WithContext(ctx).MoveOrg(id, parentID)
+	switch {
+	case errors.Is(err, ErrParentNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, ErrOrgCycle), errors.Is(err, ErrOrgDepthExceeded):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.logger.Errorf("Failed to move organization: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move organization"})
+		return
+	}
+
+	if org == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Organization not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, org)
+}
@@ -3,17 +3,27 @@ package org
 import (
 	"time"
 
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/tenant"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// Org is an organization. Orgs form a tree: ParentID is the org above, and
+// Path lists the IDs from the root down to the org itself, e.g.
+// "/<root>/<parent>/<id>/", so a subtree is every org whose path starts with
+// the path of its top org.
 type Org struct {
-	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	Name        string    `json:"name" gorm:"not null"`
-	DisplayName string    `json:"displayName" gorm:"column:displayname"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	tenant.Scoped
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name        string     `json:"name" gorm:"not null"`
+	DisplayName string     `json:"displayName" gorm:"column:displayname"`
+	Description string     `json:"description"`
+	ParentID    *uuid.UUID `json:"parentId" gorm:"type:uuid;index"`
+	Path        string     `json:"path" gorm:"not null;default:'';index"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
 }
 
 func (o *Org) BeforeCreate(tx *gorm.DB) error {
@@ -23,6 +33,24 @@ func (o *Org) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// AfterCreate links the org to the authorization system object and to its
+// parent
+func (o *Org) AfterCreate(tx *gorm.DB) error {
+	if err := authz.RecordSystemLink(tx, authz.OpWrite, "org", o.ID); err != nil {
+		return err
+	}
+	if o.ParentID == nil {
+		return nil
+	}
+	return authz.Record(tx, authz.OpWrite, authz.OrgParentTuple(o.ID, *o.ParentID))
+}
+
+// AfterDelete removes the link of the org to the authorization system
+// object. Delete with the ID set, e.g. Delete(&Org{ID: id}), for it to run.
+func (o *Org) AfterDelete(tx *gorm.DB) error {
+	return authz.RecordSystemLink(tx, authz.OpDelete, "org", o.ID)
+}
+
 func (o *Org) TableName() string {
 	return "orgs"
 }
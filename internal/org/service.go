@@ -1,10 +1,14 @@
 package org
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/events"
+
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -22,6 +26,14 @@ func NewOrgService(db *gorm.DB) *OrgService {
 	}
 }
 
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *OrgService) WithContext(ctx context.Context) *OrgService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
 func (s *OrgService) GetAllOrgs() ([]Org, error) {
 	var orgs []Org
 	result := s.db.Find(&orgs)
@@ -51,16 +63,40 @@ func (s *OrgService) CreateOrg(req OrgCreateRequest) (*Org, error) {
 	}
 
 	org := Org{
+		ID:          uuid.New(),
 		Name:        req.Name,
 		DisplayName: req.DisplayName,
 		Description: req.Description,
+		ParentID:    req.ParentID,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	result := s.db.Create(&org)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to create organization: %w", result.Error)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		parentPath := ""
+		if req.ParentID != nil {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", orgTreeLockKey).Error; err != nil {
+				return fmt.Errorf("failed to lock organization tree: %w", err)
+			}
+			parent, err := findParent(tx, *req.ParentID)
+			if err != nil {
+				return err
+			}
+			parentPath = parent.Path
+		}
+		path, err := childPath(parentPath, org.ID)
+		if err != nil {
+			return err
+		}
+		org.Path = path
+
+		if err := tx.Create(&org).Error; err != nil {
+			return fmt.Errorf("failed to create organization: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &org, nil
@@ -102,13 +138,43 @@ func (s *OrgService) UpdateOrg(id uuid.UUID, req OrgUpdateRequest) (*Org, error)
 	return &org, nil
 }
 
+// DeleteOrg deletes an org without orgs below it. It returns
+// ErrOrgHasChildren otherwise.
 func (s *OrgService) DeleteOrg(id uuid.UUID) error {
-	result := s.db.Delete(&Org{}, "id = ?", id)
-	if result.Error != nil {
-		return fmt.Errorf("failed to delete organization: %w", result.Error)
-	}
-	if result.RowsAffected == 0 {
-		return errors.New("organization not found")
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", orgTreeLockKey).Error; err != nil {
+			return fmt.Errorf("failed to lock organization tree: %w", err)
+		}
+		var org Org
+		if err := tx.First(&org, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("organization not found")
+			}
+			return fmt.Errorf("failed to get organization: %w", err)
+		}
+		var children int64
+		if err := tx.Model(&Org{}).Where("parent_id = ?", id).Count(&children).Error; err != nil {
+			return fmt.Errorf("failed to check child organizations: %w", err)
+		}
+		if children > 0 {
+			return ErrOrgHasChildren
+		}
+
+		if err := tx.Delete(&Org{ID: id}).Error; err != nil {
+			return fmt.Errorf("failed to delete organization: %w", err)
+		}
+		if org.ParentID == nil {
+			return nil
+		}
+		return authz.Record(tx, authz.OpDelete, authz.OrgParentTuple(id, *org.ParentID))
+	})
+	if err != nil {
+		return err
 	}
+
+	events.Publish(events.Event{
+		Type:    "org.deleted",
+		Subject: id.String(),
+	})
 	return nil
 }
@@ -0,0 +1,257 @@
+package org
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/events"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MaxOrgDepth is the most levels an org tree may have, its root included.
+// Creating or moving an org that would make a tree deeper is rejected.
+const MaxOrgDepth = 10
+
+// orgTreeLockKey is the advisory lock serializing changes to the org tree,
+// so concurrent moves can't form a cycle together
+const orgTreeLockKey = 0x6f726774
+
+var (
+	ErrParentNotFound   = errors.New("parent organization not found")
+	ErrOrgCycle         = errors.New("organization would be placed below itself")
+	ErrOrgDepthExceeded = fmt.Errorf("organizations can't be nested more than %d deep", MaxOrgDepth)
+	ErrOrgHasChildren   = errors.New("organization has child organizations")
+)
+
+// MembershipsSQL is a CTE of org memberships: the direct ones, and those of
+// the orgs below a cascading membership
+const MembershipsSQL = `org_memberships(org_id, entity_id, type) AS (
+	SELECT org_id, entity_id, type FROM org_members
+	UNION
+	SELECT o.id, m.entity_id, m.type FROM org_members m
+	JOIN orgs a ON a.id = m.org_id
+	JOIN orgs o ON o.path LIKE a.path || '%' AND o.id <> a.id
+	WHERE m."cascade"
+)`
+
+// EnsurePaths sets the path of root orgs created before orgs had parents
+func (s *OrgService) EnsurePaths() error {
+	result := s.db.Model(&Org{}).
+		Where("path = '' AND parent_id IS NULL").
+		Update("path", gorm.Expr("'/' || CAST(id AS TEXT) || '/'"))
+	if result.Error != nil {
+		return fmt.Errorf("failed to set organization paths: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		s.logger.Infof("Set the path of %d organizations", result.RowsAffected)
+	}
+	return nil
+}
+
+// GetChildren returns the orgs directly below the org
+func (s *OrgService) GetChildren(id uuid.UUID) ([]Org, error) {
+	var children []Org
+	if err := s.db.Where("parent_id = ?", id).Order("name").Find(&children).Error; err != nil {
+		return nil, fmt.Errorf("failed to get child organizations: %w", err)
+	}
+	return children, nil
+}
+
+// GetSubtree returns the org and every org below it, each after its parent,
+// or nil if the org doesn't exist
+func (s *OrgService) GetSubtree(id uuid.UUID) ([]Org, error) {
+	org, err := s.GetOrg(id)
+	if err != nil || org == nil {
+		return nil, err
+	}
+	var subtree []Org
+	if err := s.db.Where("path LIKE ?", org.Path+"%").Order("path").Find(&subtree).Error; err != nil {
+		return nil, fmt.Errorf("failed to get organization subtree: %w", err)
+	}
+	return subtree, nil
+}
+
+// GetAncestors returns the orgs above the org, root first, or nil if the org
+// doesn't exist
+func (s *OrgService) GetAncestors(id uuid.UUID) ([]Org, error) {
+	org, err := s.GetOrg(id)
+	if err != nil || org == nil {
+		return nil, err
+	}
+	ids := pathIDs(org.Path)
+	ancestors := []Org{}
+	if len(ids) < 2 {
+		return ancestors, nil
+	}
+	if err := s.db.Where("id IN ?", ids[:len(ids)-1]).Order("LENGTH(path)").Find(&ancestors).Error; err != nil {
+		return nil, fmt.Errorf("failed to get organization ancestors: %w", err)
+	}
+	return ancestors, nil
+}
+
+// MoveOrg places the org below parentID, or makes it a root when parentID is
+// nil, along with everything below it. It returns nil if the org doesn't
+// exist.
+func (s *OrgService) MoveOrg(id uuid.UUID, parentID *uuid.UUID) (*Org, error) {
+	var org Org
+	var previousParentID *uuid.UUID
+	found := true
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", orgTreeLockKey).Error; err != nil {
+			return fmt.Errorf("failed to lock organization tree: %w", err)
+		}
+		if err := tx.First(&org, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				found = false
+				return nil
+			}
+			return fmt.Errorf("failed to get organization: %w", err)
+		}
+		previousParentID = org.ParentID
+		if sameParent(org.ParentID, parentID) {
+			return nil
+		}
+
+		parentPath := ""
+		if parentID != nil {
+			parent, err := findParent(tx, *parentID)
+			if err != nil {
+				return err
+			}
+			parentPath = parent.Path
+		}
+		var deepest int
+		err := tx.Model(&Org{}).
+			Select("COALESCE(MAX(LENGTH(path) - LENGTH(REPLACE(path, '/', ''))), 0) - 1").
+			Where("path LIKE ?", org.Path+"%").
+			Scan(&deepest).Error
+		if err != nil {
+			return fmt.Errorf("failed to measure organization subtree: %w", err)
+		}
+		newPath, err := movedPath(org.Path, parentPath, deepest)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&Org{}).
+			Where("path LIKE ?", org.Path+"%").
+			Update("path", gorm.Expr("? || SUBSTR(path, ?)", newPath, len(org.Path)+1)).Error
+		if err != nil {
+			return fmt.Errorf("failed to move organization subtree: %w", err)
+		}
+		org.ParentID = parentID
+		org.Path = newPath
+		org.UpdatedAt = time.Now()
+		err = tx.Model(&Org{}).Where("id = ?", id).
+			Updates(map[string]interface{}{"parent_id": parentID, "updated_at": org.UpdatedAt}).Error
+		if err != nil {
+			return fmt.Errorf("failed to move organization: %w", err)
+		}
+
+		if previousParentID != nil {
+			if err := authz.Record(tx, authz.OpDelete, authz.OrgParentTuple(id, *previousParentID)); err != nil {
+				return err
+			}
+		}
+		if parentID != nil {
+			return authz.Record(tx, authz.OpWrite, authz.OrgParentTuple(id, *parentID))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	if sameParent(previousParentID, parentID) {
+		return &org, nil
+	}
+
+	data := map[string]interface{}{"parentId": nil, "previousParentId": nil}
+	if parentID != nil {
+		data["parentId"] = parentID.String()
+	}
+	if previousParentID != nil {
+		data["previousParentId"] = previousParentID.String()
+	}
+	events.Publish(events.Event{
+		Type:    "org.moved",
+		Subject: id.String(),
+		Data:    data,
+	})
+	return &org, nil
+}
+
+// findParent returns the org to place another below, or ErrParentNotFound
+func findParent(tx *gorm.DB, parentID uuid.UUID) (*Org, error) {
+	var parent Org
+	if err := tx.First(&parent, "id = ?", parentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrParentNotFound
+		}
+		return nil, fmt.Errorf("failed to get parent organization: %w", err)
+	}
+	return &parent, nil
+}
+
+// childPath is the path of an org placed below parentPath, or of a root org
+// when parentPath is empty. It returns ErrOrgDepthExceeded if the tree would
+// get too deep.
+func childPath(parentPath string, id uuid.UUID) (string, error) {
+	if parentPath == "" {
+		parentPath = "/"
+	}
+	path := parentPath + id.String() + "/"
+	if pathDepth(path) > MaxOrgDepth {
+		return "", ErrOrgDepthExceeded
+	}
+	return path, nil
+}
+
+// movedPath decides where an org at orgPath goes when placed below
+// parentPath, given the depth of the deepest org in its subtree, and returns
+// its new path
+func movedPath(orgPath, parentPath string, deepest int) (string, error) {
+	ids := pathIDs(orgPath)
+	if len(ids) == 0 {
+		return "", fmt.Errorf("invalid organization path %q", orgPath)
+	}
+	if strings.HasPrefix(parentPath, orgPath) {
+		return "", ErrOrgCycle
+	}
+	id, err := uuid.Parse(ids[len(ids)-1])
+	if err != nil {
+		return "", fmt.Errorf("invalid organization path %q", orgPath)
+	}
+	path, err := childPath(parentPath, id)
+	if err != nil {
+		return "", err
+	}
+	if pathDepth(path)+deepest-pathDepth(orgPath) > MaxOrgDepth {
+		return "", ErrOrgDepthExceeded
+	}
+	return path, nil
+}
+
+// pathIDs splits a path into the IDs of the orgs on it, root first
+func pathIDs(path string) []string {
+	return strings.FieldsFunc(path, func(r rune) bool { return r == '/' })
+}
+
+// pathDepth is the level of the org at path, 1 for roots
+func pathDepth(path string) int {
+	return strings.Count(path, "/") - 1
+}
+
+func sameParent(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,72 @@
+package org
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chain returns the path of a tree n levels deep
+func chain(n int) string {
+	path := "/"
+	for i := 0; i < n; i++ {
+		path += uuid.NewString() + "/"
+	}
+	return path
+}
+
+func TestChildPath(t *testing.T) {
+	id := uuid.New()
+
+	path, err := childPath("", id)
+	require.NoError(t, err)
+	assert.Equal(t, "/"+id.String()+"/", path)
+	assert.Equal(t, 1, pathDepth(path))
+
+	parent := chain(2)
+	path, err = childPath(parent, id)
+	require.NoError(t, err)
+	assert.Equal(t, parent+id.String()+"/", path)
+	assert.Equal(t, 3, pathDepth(path))
+	assert.Len(t, pathIDs(path), 3)
+
+	_, err = childPath(chain(MaxOrgDepth-1), id)
+	assert.NoError(t, err)
+	_, err = childPath(chain(MaxOrgDepth), id)
+	assert.ErrorIs(t, err, ErrOrgDepthExceeded)
+}
+
+func TestMovedPath(t *testing.T) {
+	org := chain(2)
+	id := pathIDs(org)[1]
+
+	// To another tree, or out of its own
+	other := chain(3)
+	path, err := movedPath(org, other, 3)
+	require.NoError(t, err)
+	assert.Equal(t, other+id+"/", path)
+	path, err = movedPath(org, "", 2)
+	require.NoError(t, err)
+	assert.Equal(t, "/"+id+"/", path)
+
+	// Below itself or below an org of its subtree
+	_, err = movedPath(org, org, 2)
+	assert.ErrorIs(t, err, ErrOrgCycle)
+	below := org + uuid.NewString() + "/"
+	_, err = movedPath(org, below, 3)
+	assert.ErrorIs(t, err, ErrOrgCycle)
+
+	// Orgs whose IDs share a prefix aren't below each other
+	sibling := strings.TrimSuffix(org, "/") + "0/"
+	_, err = movedPath(org, sibling, 2)
+	assert.NoError(t, err)
+
+	// The deepest org of the subtree must stay within MaxOrgDepth
+	_, err = movedPath(org, chain(MaxOrgDepth-3), 4)
+	assert.NoError(t, err)
+	_, err = movedPath(org, chain(MaxOrgDepth-2), 4)
+	assert.ErrorIs(t, err, ErrOrgDepthExceeded)
+}
@@ -0,0 +1,137 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const hashPrefixLength = 5
+
+// BreachedList is a locally loaded list of SHA-1 hashes of breached passwords.
+//
+// The list is keyed the same way as the Pwned Passwords k-anonymity range API:
+// by the first five hex characters of the SHA-1 hash. Two layouts are supported:
+//   - a single file of "HASH[:COUNT]" lines, loaded into memory at startup
+//   - a directory of range files named after the prefix (e.g. "21BD1" or
+//     "21BD1.txt"), each holding "SUFFIX[:COUNT]" lines, read on demand
+type BreachedList struct {
+	dir    string
+	ranges map[string]map[string]struct{}
+}
+
+// LoadBreachedList loads the breached password list at path. An empty path
+// returns nil, which disables the check.
+func LoadBreachedList(path string) (*BreachedList, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	if info.IsDir() {
+		return &BreachedList{dir: path}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	return ParseBreachedList(file)
+}
+
+// ParseBreachedList reads "HASH[:COUNT]" lines into an in-memory list
+func ParseBreachedList(r io.Reader) (*BreachedList, error) {
+	list := &BreachedList{ranges: make(map[string]map[string]struct{})}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		hash := parseHashLine(scanner.Text())
+		if hash == "" {
+			continue
+		}
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("invalid SHA-1 hash in breached password list: %q", hash)
+		}
+		prefix, suffix := hash[:hashPrefixLength], hash[hashPrefixLength:]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = make(map[string]struct{})
+		}
+		list.ranges[prefix][suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+
+	return list, nil
+}
+
+// Contains reports whether password appears in the breached list
+func (l *BreachedList) Contains(password string) (bool, error) {
+	if l == nil {
+		return false, nil
+	}
+
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:hashPrefixLength], hash[hashPrefixLength:]
+
+	if l.dir == "" {
+		_, found := l.ranges[prefix][suffix]
+		return found, nil
+	}
+
+	return l.searchRangeFile(prefix, suffix)
+}
+
+func (l *BreachedList) searchRangeFile(prefix, suffix string) (bool, error) {
+	var file *os.File
+	var err error
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		file, err = os.Open(filepath.Join(l.dir, name))
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("failed to open breached password range %s: %w", prefix, err)
+		}
+	}
+	if file == nil {
+		// No range file means no breached password shares this prefix
+		return false, nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if parseHashLine(scanner.Text()) == suffix {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read breached password range %s: %w", prefix, err)
+	}
+	return false, nil
+}
+
+// parseHashLine strips the optional ":COUNT" part and normalises the hash to upper case
+func parseHashLine(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.ToUpper(line)
+}
@@ -0,0 +1,497 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"idmapp-go/config"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Algorithm identifiers as they appear in PHC strings
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmPBKDF2   = "pbkdf2"
+)
+
+var (
+	ErrUnsupportedHash = errors.New("unsupported password hash format")
+	ErrVerifyOnly      = errors.New("hash algorithm is supported for verification only")
+)
+
+// Upper bounds on argon2id and PBKDF2 parameters. Stored and imported hashes
+// carry their own parameters, and these keep one from making every login
+// against it cost more memory and CPU time than the server can spare.
+const (
+	maxArgon2Memory      = 256 << 10 // KiB
+	maxArgon2Iterations  = 10
+	maxArgon2Parallelism = 16
+	maxPBKDF2Iterations  = 1000000
+	maxPBKDF2KeyLength   = 64
+)
+
+// phcEncoding is the unpadded standard base64 alphabet used by the PHC string format
+var phcEncoding = base64.RawStdEncoding
+
+// Hasher hashes passwords into self-describing PHC strings and verifies them
+type Hasher interface {
+	// Algorithm returns the identifier used to pick the hasher for an encoded hash
+	Algorithm() string
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// Check validates the format and parameters of encoded without deriving
+	// a key
+	Check(encoded string) error
+	// NeedsRehash reports whether encoded was produced with weaker or different parameters
+	NeedsRehash(encoded string) bool
+}
+
+// HashManager hashes new passwords with the preferred algorithm and verifies
+// hashes produced by any supported algorithm.
+type HashManager struct {
+	preferred Hasher
+	hashers   map[string]Hasher
+	legacy    LegacyPBKDF2Config
+}
+
+// LegacyPBKDF2Config describes how hashes from the Java IDM's Spring Security
+// Pbkdf2PasswordEncoder were produced. Those hashes don't encode their
+// parameters, so they have to be supplied when importing.
+type LegacyPBKDF2Config struct {
+	Algorithm  string
+	Iterations int
+	SaltLength int
+	KeyLength  int
+	Secret     string
+}
+
+// NewHashManager builds the hashers from the loaded configuration
+func NewHashManager(cfg config.PasswordHashConfig) (*HashManager, error) {
+	if cfg.Algorithm == AlgorithmArgon2id {
+		if err := checkArgon2Params(int64(cfg.Argon2Memory), int64(cfg.Argon2Iterations), int64(cfg.Argon2Parallelism)); err != nil {
+			return nil, err
+		}
+	}
+	argon := &Argon2idHasher{
+		Memory:      uint32(cfg.Argon2Memory),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+	bcryptHasher := &BcryptHasher{Cost: cfg.BcryptCost}
+
+	manager := &HashManager{
+		hashers: map[string]Hasher{
+			AlgorithmArgon2id: argon,
+			AlgorithmBcrypt:   bcryptHasher,
+			AlgorithmPBKDF2:   &PBKDF2Hasher{},
+		},
+		legacy: LegacyPBKDF2Config{
+			Algorithm:  cfg.LegacyPBKDF2Algorithm,
+			Iterations: cfg.LegacyPBKDF2Iterations,
+			SaltLength: cfg.LegacyPBKDF2SaltLength,
+			KeyLength:  32,
+			Secret:     cfg.LegacyPBKDF2Secret,
+		},
+	}
+
+	switch cfg.Algorithm {
+	case AlgorithmArgon2id:
+		manager.preferred = argon
+	case AlgorithmBcrypt:
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("invalid bcrypt cost: %d", cfg.BcryptCost)
+		}
+		manager.preferred = bcryptHasher
+	default:
+		return nil, fmt.Errorf("unsupported password hash algorithm: %s", cfg.Algorithm)
+	}
+
+	return manager, nil
+}
+
+// Hash hashes password with the preferred algorithm
+func (m *HashManager) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+// Verify checks password against encoded and reports whether the hash should
+// be replaced by one produced with the preferred algorithm and parameters
+func (m *HashManager) Verify(password, encoded string) (bool, bool, error) {
+	hasher, err := m.hasherFor(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	ok, err := hasher.Verify(password, encoded)
+	if err != nil || !ok {
+		return false, false, err
+	}
+
+	needsRehash := hasher.Algorithm() != m.preferred.Algorithm() || hasher.NeedsRehash(encoded)
+	return true, needsRehash, nil
+}
+
+// Import converts a hash exported from another system into a PHC string this
+// service can verify. Supported inputs are PHC/bcrypt strings, and Spring
+// Security DelegatingPasswordEncoder values ("{bcrypt}...", "{argon2}...",
+// "{pbkdf2}...") as written by the Java IDM.
+func (m *HashManager) Import(legacy string) (string, error) {
+	encoded := strings.TrimSpace(legacy)
+
+	if strings.HasPrefix(encoded, "{") {
+		end := strings.IndexByte(encoded, '}')
+		if end < 0 {
+			return "", ErrUnsupportedHash
+		}
+		id, value := encoded[1:end], encoded[end+1:]
+		switch id {
+		case "bcrypt", "argon2":
+			encoded = value
+		case "pbkdf2":
+			converted, err := m.convertSpringPBKDF2(value)
+			if err != nil {
+				return "", err
+			}
+			encoded = converted
+		default:
+			return "", fmt.Errorf("%w: {%s}", ErrUnsupportedHash, id)
+		}
+	}
+
+	hasher, err := m.hasherFor(encoded)
+	if err != nil {
+		return "", err
+	}
+	if err := hasher.Check(encoded); err != nil {
+		return "", fmt.Errorf("invalid %s hash: %w", hasher.Algorithm(), err)
+	}
+	return encoded, nil
+}
+
+// convertSpringPBKDF2 turns a Spring Pbkdf2PasswordEncoder value (hex or
+// base64 of salt||derived key) into a PHC string. Spring derives the key from
+// salt||secret, so that concatenation is stored as the PHC salt.
+func (m *HashManager) convertSpringPBKDF2(value string) (string, error) {
+	raw, err := decodeHexOrBase64(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid pbkdf2 hash: %w", err)
+	}
+	if len(raw) <= m.legacy.SaltLength {
+		return "", errors.New("invalid pbkdf2 hash: too short")
+	}
+
+	salt := append(append([]byte{}, raw[:m.legacy.SaltLength]...), m.legacy.Secret...)
+	key := raw[m.legacy.SaltLength:]
+
+	return fmt.Sprintf("$pbkdf2-%s$i=%d,l=%d$%s$%s",
+		m.legacy.Algorithm, m.legacy.Iterations, len(key),
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+func (m *HashManager) hasherFor(encoded string) (Hasher, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return m.hashers[AlgorithmArgon2id], nil
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return m.hashers[AlgorithmBcrypt], nil
+	case strings.HasPrefix(encoded, "$pbkdf2-"):
+		return m.hashers[AlgorithmPBKDF2], nil
+	default:
+		return nil, ErrUnsupportedHash
+	}
+}
+
+// Argon2idHasher produces "$argon2id$v=19$m=...,t=...,p=...$salt$hash" strings
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func (h *Argon2idHasher) Algorithm() string {
+	return AlgorithmArgon2id
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+func (h *Argon2idHasher) Check(encoded string) error {
+	_, _, _, err := h.decode(encoded)
+	return err
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, _, key, err := h.decode(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.Memory ||
+		params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		uint32(len(key)) != h.KeyLength
+}
+
+func (h *Argon2idHasher) decode(encoded string) (*Argon2idHasher, []byte, []byte, error) {
+	phc, err := parsePHC(encoded)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if phc.id != AlgorithmArgon2id {
+		return nil, nil, nil, ErrUnsupportedHash
+	}
+	if phc.version != strconv.Itoa(argon2.Version) {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2 version: %s", phc.version)
+	}
+
+	params := &Argon2idHasher{}
+	memory, err := phc.uintParam("m", 32)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	iterations, err := phc.uintParam("t", 32)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	parallelism, err := phc.uintParam("p", 8)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := checkArgon2Params(int64(memory), int64(iterations), int64(parallelism)); err != nil {
+		return nil, nil, nil, err
+	}
+	if len(phc.hash) == 0 {
+		return nil, nil, nil, ErrUnsupportedHash
+	}
+	params.Memory = uint32(memory)
+	params.Iterations = uint32(iterations)
+	params.Parallelism = uint8(parallelism)
+
+	return params, phc.salt, phc.hash, nil
+}
+
+// checkArgon2Params rejects parameters argon2.IDKey can't work with, which
+// it would panic on, and ones beyond the upper bounds
+func checkArgon2Params(memory, iterations, parallelism int64) error {
+	if iterations < 1 || iterations > maxArgon2Iterations {
+		return fmt.Errorf("invalid argon2 iterations: %d", iterations)
+	}
+	if parallelism < 1 || parallelism > maxArgon2Parallelism {
+		return fmt.Errorf("invalid argon2 parallelism: %d", parallelism)
+	}
+	if memory < 8*parallelism || memory > maxArgon2Memory {
+		return fmt.Errorf("invalid argon2 memory: %d", memory)
+	}
+	return nil
+}
+
+// BcryptHasher produces standard "$2a$cost$..." modular crypt strings
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Algorithm() string {
+	return AlgorithmBcrypt
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hashed), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (h *BcryptHasher) Check(encoded string) error {
+	_, err := bcrypt.Cost([]byte(encoded))
+	return err
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// PBKDF2Hasher verifies "$pbkdf2-<digest>$i=...,l=...$salt$hash" strings. It
+// only exists to accept imported legacy hashes, which are always rehashed.
+type PBKDF2Hasher struct{}
+
+func (h *PBKDF2Hasher) Algorithm() string {
+	return AlgorithmPBKDF2
+}
+
+func (h *PBKDF2Hasher) Hash(password string) (string, error) {
+	return "", ErrVerifyOnly
+}
+
+func (h *PBKDF2Hasher) Verify(password, encoded string) (bool, error) {
+	digest, iterations, phc, err := h.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	computed := pbkdf2.Key([]byte(password), phc.salt, iterations, len(phc.hash), digest)
+	return subtle.ConstantTimeCompare(computed, phc.hash) == 1, nil
+}
+
+func (h *PBKDF2Hasher) Check(encoded string) error {
+	_, _, _, err := h.decode(encoded)
+	return err
+}
+
+func (h *PBKDF2Hasher) NeedsRehash(encoded string) bool {
+	return true
+}
+
+func (h *PBKDF2Hasher) decode(encoded string) (func() hash.Hash, int, *phcString, error) {
+	phc, err := parsePHC(encoded)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	digest, err := pbkdf2Digest(strings.TrimPrefix(phc.id, "pbkdf2-"))
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	iterations, err := phc.uintParam("i", 32)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if iterations < 1 || iterations > maxPBKDF2Iterations {
+		return nil, 0, nil, fmt.Errorf("invalid pbkdf2 iterations: %d", iterations)
+	}
+	keyLength, err := phc.uintParam("l", 32)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if len(phc.hash) == 0 || len(phc.hash) > maxPBKDF2KeyLength || keyLength != uint64(len(phc.hash)) {
+		return nil, 0, nil, fmt.Errorf("invalid pbkdf2 key length: %d", keyLength)
+	}
+
+	return digest, int(iterations), phc, nil
+}
+
+func pbkdf2Digest(name string) (func() hash.Hash, error) {
+	switch strings.ToLower(name) {
+	case "sha1":
+		return sha1.New, nil
+	case "sha256":
+		return sha256.New, nil
+	case "sha512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported pbkdf2 digest: %s", name)
+	}
+}
+
+// phcString is a parsed "$id[$v=version][$params]$salt$hash" string
+type phcString struct {
+	id      string
+	version string
+	params  map[string]string
+	salt    []byte
+	hash    []byte
+}
+
+func parsePHC(encoded string) (*phcString, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 4 || parts[0] != "" {
+		return nil, ErrUnsupportedHash
+	}
+
+	phc := &phcString{id: parts[1], params: map[string]string{}}
+	fields := parts[2:]
+
+	if strings.HasPrefix(fields[0], "v=") {
+		phc.version = strings.TrimPrefix(fields[0], "v=")
+		fields = fields[1:]
+	}
+	if len(fields) == 3 {
+		for _, pair := range strings.Split(fields[0], ",") {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, ErrUnsupportedHash
+			}
+			phc.params[key] = value
+		}
+		fields = fields[1:]
+	}
+	if len(fields) != 2 {
+		return nil, ErrUnsupportedHash
+	}
+
+	var err error
+	if phc.salt, err = phcEncoding.DecodeString(fields[0]); err != nil {
+		return nil, fmt.Errorf("invalid salt encoding: %w", err)
+	}
+	if phc.hash, err = phcEncoding.DecodeString(fields[1]); err != nil {
+		return nil, fmt.Errorf("invalid hash encoding: %w", err)
+	}
+	return phc, nil
+}
+
+func (p *phcString) uintParam(name string, bitSize int) (uint64, error) {
+	value, ok := p.params[name]
+	if !ok {
+		return 0, fmt.Errorf("missing %s parameter", name)
+	}
+	parsed, err := strconv.ParseUint(value, 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter: %w", name, err)
+	}
+	return parsed, nil
+}
+
+func decodeHexOrBase64(value string) ([]byte, error) {
+	if decoded, err := hex.DecodeString(value); err == nil {
+		return decoded, nil
+	}
+	return base64.StdEncoding.DecodeString(value)
+}
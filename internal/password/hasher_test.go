@@ -0,0 +1,144 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"idmapp-go/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+func testHashConfig(algorithm string) config.PasswordHashConfig {
+	return config.PasswordHashConfig{
+		Algorithm:              algorithm,
+		BcryptCost:             bcrypt.MinCost,
+		Argon2Memory:           1024,
+		Argon2Iterations:       1,
+		Argon2Parallelism:      1,
+		LegacyPBKDF2Algorithm:  "sha1",
+		LegacyPBKDF2Iterations: 1000,
+		LegacyPBKDF2SaltLength: 8,
+	}
+}
+
+func TestHashManager_HashAndVerify(t *testing.T) {
+	manager, err := NewHashManager(testHashConfig(AlgorithmArgon2id))
+	require.NoError(t, err)
+
+	encoded, err := manager.Hash("s3cret-Passw0rd")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	ok, needsRehash, err := manager.Verify("s3cret-Passw0rd", encoded)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, needsRehash)
+
+	ok, _, err = manager.Verify("wrong", encoded)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestHashManager_NeedsRehash(t *testing.T) {
+	manager, err := NewHashManager(testHashConfig(AlgorithmArgon2id))
+	require.NoError(t, err)
+
+	t.Run("Bcrypt Hash", func(t *testing.T) {
+		legacy, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+		require.NoError(t, err)
+
+		ok, needsRehash, err := manager.Verify("password123", string(legacy))
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, needsRehash)
+	})
+
+	t.Run("Weaker Argon2 Parameters", func(t *testing.T) {
+		weaker := &Argon2idHasher{Memory: 512, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+		encoded, err := weaker.Hash("password123")
+		require.NoError(t, err)
+
+		ok, needsRehash, err := manager.Verify("password123", encoded)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, needsRehash)
+	})
+}
+
+func TestHashManager_Import(t *testing.T) {
+	manager, err := NewHashManager(testHashConfig(AlgorithmArgon2id))
+	require.NoError(t, err)
+
+	t.Run("Spring Pbkdf2PasswordEncoder", func(t *testing.T) {
+		salt := []byte("8bytesal")
+		key := pbkdf2.Key([]byte("password123"), salt, 1000, 32, sha1.New)
+		legacy := "{pbkdf2}" + hex.EncodeToString(append(salt, key...))
+
+		encoded, err := manager.Import(legacy)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(encoded, "$pbkdf2-sha1$i=1000,l=32$"))
+
+		ok, needsRehash, err := manager.Verify("password123", encoded)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		assert.True(t, needsRehash)
+	})
+
+	t.Run("Spring Bcrypt", func(t *testing.T) {
+		hashed, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+		require.NoError(t, err)
+
+		encoded, err := manager.Import("{bcrypt}" + string(hashed))
+		require.NoError(t, err)
+		assert.Equal(t, string(hashed), encoded)
+	})
+
+	t.Run("Argon2 Parameters Out Of Bounds", func(t *testing.T) {
+		salt, key := phcEncoding.EncodeToString([]byte("16bytesaltvalue!")), phcEncoding.EncodeToString(make([]byte, 32))
+		for _, params := range []string{"m=1024,t=0,p=1", "m=1024,t=1,p=0", "m=8,t=1,p=4", "m=1024,t=11,p=1", "m=1024,t=1,p=17", "m=524288,t=1,p=1"} {
+			_, err := manager.Import("$argon2id$v=19$" + params + "$" + salt + "$" + key)
+			assert.Error(t, err, params)
+		}
+		_, err := manager.Import("$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$")
+		assert.Error(t, err)
+	})
+
+	t.Run("PBKDF2 Parameters Out Of Bounds", func(t *testing.T) {
+		salt, key := phcEncoding.EncodeToString([]byte("8bytesal")), phcEncoding.EncodeToString(make([]byte, 32))
+		for _, params := range []string{"i=0,l=32", "i=4294967295,l=32", "i=1000001,l=32", "i=1000,l=16", "i=1000"} {
+			_, err := manager.Import("$pbkdf2-sha1$" + params + "$" + salt + "$" + key)
+			assert.Error(t, err, params)
+		}
+		long := phcEncoding.EncodeToString(make([]byte, 128))
+		_, err := manager.Import("$pbkdf2-sha1$i=1000,l=128$" + salt + "$" + long)
+		assert.Error(t, err)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := manager.Import("{noop}password123")
+		assert.ErrorIs(t, err, ErrUnsupportedHash)
+
+		_, err = manager.Import("5f4dcc3b5aa765d61d8327deb882cf99")
+		assert.ErrorIs(t, err, ErrUnsupportedHash)
+	})
+}
+
+func TestNewHashManager_RejectsInvalidArgon2Params(t *testing.T) {
+	for _, tweak := range []func(*config.PasswordHashConfig){
+		func(cfg *config.PasswordHashConfig) { cfg.Argon2Iterations = 0 },
+		func(cfg *config.PasswordHashConfig) { cfg.Argon2Parallelism = 0 },
+		func(cfg *config.PasswordHashConfig) { cfg.Argon2Parallelism = 256 },
+		func(cfg *config.PasswordHashConfig) { cfg.Argon2Memory = 4 },
+	} {
+		cfg := testHashConfig(AlgorithmArgon2id)
+		tweak(&cfg)
+		_, err := NewHashManager(cfg)
+		assert.Error(t, err)
+	}
+}
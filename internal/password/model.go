@@ -0,0 +1,27 @@
+package password
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// History stores previous password hashes of a user to prevent reuse
+type History struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID `json:"userId" gorm:"type:uuid;not null;index;column:user_id"`
+	Hash      string    `json:"-" gorm:"not null"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (h *History) BeforeCreate(tx *gorm.DB) error {
+	if h.ID == uuid.Nil {
+		h.ID = uuid.New()
+	}
+	return nil
+}
+
+func (h *History) TableName() string {
+	return "password_history"
+}
@@ -0,0 +1,124 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"idmapp-go/config"
+)
+
+// Violation codes returned in PolicyError
+const (
+	ViolationTooShort         = "too_short"
+	ViolationTooLong          = "too_long"
+	ViolationMissingUppercase = "missing_uppercase"
+	ViolationMissingLowercase = "missing_lowercase"
+	ViolationMissingDigit     = "missing_digit"
+	ViolationMissingSymbol    = "missing_symbol"
+	ViolationReused           = "reused"
+	ViolationBreached         = "breached"
+)
+
+// Policy describes the rules a password has to satisfy
+type Policy struct {
+	MinLength        int           `json:"minLength"`
+	MaxLength        int           `json:"maxLength"`
+	RequireUppercase bool          `json:"requireUppercase"`
+	RequireLowercase bool          `json:"requireLowercase"`
+	RequireDigit     bool          `json:"requireDigit"`
+	RequireSymbol    bool          `json:"requireSymbol"`
+	MaxAge           time.Duration `json:"maxAge"`
+	HistorySize      int           `json:"historySize"`
+}
+
+// Violation is a single failed policy rule
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// PolicyError is returned when a password does not satisfy the policy
+type PolicyError struct {
+	Violations []Violation `json:"violations"`
+}
+
+func (e *PolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return "password does not meet policy: " + strings.Join(messages, "; ")
+}
+
+// PolicyFromConfig builds a Policy from the loaded configuration
+func PolicyFromConfig(cfg config.PasswordPolicyConfig) Policy {
+	return Policy{
+		MinLength:        cfg.MinLength,
+		MaxLength:        cfg.MaxLength,
+		RequireUppercase: cfg.RequireUppercase,
+		RequireLowercase: cfg.RequireLowercase,
+		RequireDigit:     cfg.RequireDigit,
+		RequireSymbol:    cfg.RequireSymbol,
+		MaxAge:           time.Duration(cfg.MaxAgeDays) * 24 * time.Hour,
+		HistorySize:      cfg.HistorySize,
+	}
+}
+
+// Validate checks the length and character class rules and returns every violation found
+func (p Policy) Validate(password string) []Violation {
+	var violations []Violation
+
+	length := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && length < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, Violation{
+			Code:    ViolationTooLong,
+			Message: fmt.Sprintf("password must be at most %d characters long", p.MaxLength),
+		})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUppercase && !hasUpper {
+		violations = append(violations, Violation{Code: ViolationMissingUppercase, Message: "password must contain an uppercase letter"})
+	}
+	if p.RequireLowercase && !hasLower {
+		violations = append(violations, Violation{Code: ViolationMissingLowercase, Message: "password must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{Code: ViolationMissingDigit, Message: "password must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{Code: ViolationMissingSymbol, Message: "password must contain a symbol"})
+	}
+
+	return violations
+}
+
+// IsExpired reports whether a password last changed at changedAt has exceeded MaxAge
+func (p Policy) IsExpired(changedAt time.Time) bool {
+	if p.MaxAge <= 0 || changedAt.IsZero() {
+		return false
+	}
+	return time.Since(changedAt) > p.MaxAge
+}
@@ -0,0 +1,100 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// SHA-1 of "password123"
+const breachedHash = "CBFDAC6008F9CAB4083784CBD1874F76618D2A97"
+
+func TestPolicy_Validate(t *testing.T) {
+	policy := Policy{
+		MinLength:        8,
+		MaxLength:        16,
+		RequireUppercase: true,
+		RequireLowercase: true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+	}
+
+	t.Run("Valid Password", func(t *testing.T) {
+		assert.Empty(t, policy.Validate("Str0ng!Pass"))
+	})
+
+	t.Run("Collects Every Violation", func(t *testing.T) {
+		violations := policy.Validate("abc")
+
+		codes := make([]string, 0, len(violations))
+		for _, v := range violations {
+			codes = append(codes, v.Code)
+		}
+		assert.ElementsMatch(t, []string{
+			ViolationTooShort,
+			ViolationMissingUppercase,
+			ViolationMissingDigit,
+			ViolationMissingSymbol,
+		}, codes)
+	})
+
+	t.Run("Too Long", func(t *testing.T) {
+		violations := policy.Validate("Aa1!" + strings.Repeat("x", 20))
+		require.Len(t, violations, 1)
+		assert.Equal(t, ViolationTooLong, violations[0].Code)
+	})
+}
+
+func TestPolicy_IsExpired(t *testing.T) {
+	policy := Policy{MaxAge: 24 * time.Hour}
+
+	assert.False(t, policy.IsExpired(time.Now().Add(-time.Hour)))
+	assert.True(t, policy.IsExpired(time.Now().Add(-48*time.Hour)))
+	assert.False(t, Policy{}.IsExpired(time.Now().Add(-48*time.Hour)))
+}
+
+func TestBreachedList(t *testing.T) {
+	t.Run("Single File", func(t *testing.T) {
+		list, err := ParseBreachedList(strings.NewReader(breachedHash + ":2254650\n"))
+		require.NoError(t, err)
+
+		found, err := list.Contains("password123")
+		require.NoError(t, err)
+		assert.True(t, found)
+
+		found, err = list.Contains("correct horse battery staple")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Range Directory", func(t *testing.T) {
+		dir := t.TempDir()
+		rangeFile := filepath.Join(dir, breachedHash[:5]+".txt")
+		require.NoError(t, os.WriteFile(rangeFile, []byte(breachedHash[5:]+":2254650\r\n"), 0o600))
+
+		list, err := LoadBreachedList(dir)
+		require.NoError(t, err)
+
+		found, err := list.Contains("password123")
+		require.NoError(t, err)
+		assert.True(t, found)
+
+		found, err = list.Contains("correct horse battery staple")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		list, err := LoadBreachedList("")
+		require.NoError(t, err)
+
+		found, err := list.Contains("password123")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+}
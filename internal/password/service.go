@@ -0,0 +1,129 @@
+package password
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+type PolicyService struct {
+	db       *gorm.DB
+	policy   Policy
+	breached *BreachedList
+	hashes   *HashManager
+	logger   *logrus.Logger
+}
+
+func NewPolicyService(db *gorm.DB, policy Policy, breached *BreachedList, hashes *HashManager) *PolicyService {
+	return &PolicyService{
+		db:       db,
+		policy:   policy,
+		breached: breached,
+		hashes:   hashes,
+		logger:   logrus.New(),
+	}
+}
+
+// Policy returns the active password policy
+func (s *PolicyService) Policy() Policy {
+	return s.policy
+}
+
+// Validate checks password against the policy, the breached list and, when
+// userID is set, the user's password history. Policy failures are returned
+// as *PolicyError.
+func (s *PolicyService) Validate(userID uuid.UUID, password string) error {
+	violations := s.policy.Validate(password)
+
+	breached, err := s.breached.Contains(password)
+	if err != nil {
+		return fmt.Errorf("failed to check breached passwords: %w", err)
+	}
+	if breached {
+		violations = append(violations, Violation{
+			Code:    ViolationBreached,
+			Message: "password has appeared in a data breach and cannot be used",
+		})
+	}
+
+	if userID != uuid.Nil && s.policy.HistorySize > 0 {
+		reused, err := s.isReused(userID, password)
+		if err != nil {
+			return err
+		}
+		if reused {
+			violations = append(violations, Violation{
+				Code:    ViolationReused,
+				Message: fmt.Sprintf("password must not match any of the last %d passwords", s.policy.HistorySize),
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// IsExpired reports whether a password last changed at changedAt must be changed
+func (s *PolicyService) IsExpired(changedAt time.Time) bool {
+	return s.policy.IsExpired(changedAt)
+}
+
+// RecordHistory stores hash as the user's latest password and prunes entries
+// beyond the configured history size. It runs on tx so that it commits
+// together with the password change.
+func (s *PolicyService) RecordHistory(tx *gorm.DB, userID uuid.UUID, hash string) error {
+	if s.policy.HistorySize <= 0 {
+		return nil
+	}
+
+	entry := History{
+		UserID:    userID,
+		Hash:      hash,
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to record password history: %w", err)
+	}
+
+	var stale []uuid.UUID
+	if err := tx.Model(&History{}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Offset(s.policy.HistorySize).
+		Pluck("id", &stale).Error; err != nil {
+		return fmt.Errorf("failed to prune password history: %w", err)
+	}
+	if len(stale) > 0 {
+		if err := tx.Where("id IN ?", stale).Delete(&History{}).Error; err != nil {
+			return fmt.Errorf("failed to prune password history: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *PolicyService) isReused(userID uuid.UUID, password string) (bool, error) {
+	var history []History
+	if err := s.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(s.policy.HistorySize).
+		Find(&history).Error; err != nil {
+		return false, fmt.Errorf("failed to load password history: %w", err)
+	}
+
+	for _, entry := range history {
+		matched, _, err := s.hashes.Verify(password, entry.Hash)
+		if err != nil {
+			s.logger.Warnf("Skipping unreadable password history entry %s: %v", entry.ID, err)
+			continue
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
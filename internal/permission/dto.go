@@ -0,0 +1,52 @@
+package permission
+
+type PermissionCreateRequest struct {
+	Name        string `json:"name" binding:"required,max=128"`
+	Description string `json:"description"`
+	// ClientID assigns the permission to a registered OAuth client. Clients
+	// registering their own permissions can't set it.
+	ClientID string `json:"clientId"`
+}
+
+type PermissionUpdateRequest struct {
+	Name        string  `json:"name" binding:"omitempty,max=128"`
+	Description *string `json:"description"`
+}
+
+// RolePermissionsRequest names permissions to add to or set on a role
+type RolePermissionsRequest struct {
+	Permissions []string `json:"permissions" binding:"required"`
+}
+
+// PermissionSource is a role through which a user has a permission. OrgID is
+// set when the role is assigned to an org of the user, and GroupID when the
+// role or org reaches the user through one of their groups.
+type PermissionSource struct {
+	RoleID   string `json:"roleId"`
+	RoleName string `json:"roleName"`
+	GroupID  string `json:"groupId,omitempty"`
+	OrgID    string `json:"orgId,omitempty"`
+}
+
+// rank orders direct sources before group ones and group ones before org
+// ones
+func (s PermissionSource) rank() int {
+	switch {
+	case s.OrgID != "":
+		return 2
+	case s.GroupID != "":
+		return 1
+	}
+	return 0
+}
+
+// EffectivePermission is a permission a user has, with the roles granting it
+type EffectivePermission struct {
+	Name    string             `json:"name"`
+	Sources []PermissionSource `json:"sources"`
+}
+
+type EffectivePermissionsResponse struct {
+	UserID      string                `json:"userId"`
+	Permissions []EffectivePermission `json:"permissions"`
+}
@@ -0,0 +1,281 @@
+package permission
+
+import (
+	"errors"
+	"net/http"
+
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type PermissionController struct {
+	permissionService *PermissionService
+	logger            *logrus.Logger
+}
+
+func NewPermissionController(permissionService *PermissionService) *PermissionController {
+	return &PermissionController{
+		permissionService: permissionService,
+		logger:            logrus.New(),
+	}
+}
+
+func (c *PermissionController) GetAllPermissions(ctx *gin.Context) {
+	c.listPermissions(ctx, ctx.Query("clientId"))
+}
+
+func (c *PermissionController) GetPermission(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission ID"})
+		return
+	}
+
+	permission, err := c.permissionService.WithContext(ctx).GetPermission(id)
+	if err != nil {
+		c.logger.Errorf("Failed to get permission: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permission"})
+		return
+	}
+	if permission == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Permission not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, permission)
+}
+
+func (c *PermissionController) CreatePermission(ctx *gin.Context) {
+	c.createPermission(ctx, "")
+}
+
+func (c *PermissionController) UpdatePermission(ctx *gin.Context) {
+	c.updatePermission(ctx, "")
+}
+
+func (c *PermissionController) DeletePermission(ctx *gin.Context) {
+	c.deletePermission(ctx, "")
+}
+
+// GetClientPermissions lists the permissions the calling client registered
+func (c *PermissionController) GetClientPermissions(ctx *gin.Context) {
+	if clientID := requireClient(ctx); clientID != "" {
+		c.listPermissions(ctx, clientID)
+	}
+}
+
+// RegisterClientPermission lets a client add its own permission to the
+// catalog
+func (c *PermissionController) RegisterClientPermission(ctx *gin.Context) {
+	if clientID := requireClient(ctx); clientID != "" {
+		c.createPermission(ctx, clientID)
+	}
+}
+
+func (c *PermissionController) UpdateClientPermission(ctx *gin.Context) {
+	if clientID := requireClient(ctx); clientID != "" {
+		c.updatePermission(ctx, clientID)
+	}
+}
+
+func (c *PermissionController) DeleteClientPermission(ctx *gin.Context) {
+	if clientID := requireClient(ctx); clientID != "" {
+		c.deletePermission(ctx, clientID)
+	}
+}
+
+func (c *PermissionController) GetRolePermissions(ctx *gin.Context) {
+	roleID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	permissions, err := c.permissionService.WithContext(ctx).GetRolePermissions(roleID)
+	if err != nil {
+		c.respondError(ctx, err, "Failed to get role permissions")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, permissions)
+}
+
+// AddRolePermissions grants the named permissions to the role
+func (c *PermissionController) AddRolePermissions(ctx *gin.Context) {
+	c.changeRolePermissions(ctx, c.permissionService.WithContext(ctx).AddRolePermissions)
+}
+
+// SetRolePermissions replaces the permissions of the role
+func (c *PermissionController) SetRolePermissions(ctx *gin.Context) {
+	c.changeRolePermissions(ctx, c.permissionService.WithContext(ctx).SetRolePermissions)
+}
+
+func (c *PermissionController) RemoveRolePermission(ctx *gin.Context) {
+	roleID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+	permissionID, err := uuid.Parse(ctx.Param("permissionId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission ID"})
+		return
+	}
+
+	if err := c.permissionService.WithContext(ctx).RemoveRolePermission(roleID, permissionID); err != nil {
+		c.respondError(ctx, err, "Failed to remove role permission")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// GetUserPermissions returns the effective permissions of a user
+func (c *PermissionController) GetUserPermissions(ctx *gin.Context) {
+	userID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	c.effectivePermissions(ctx, userID)
+}
+
+// GetMyPermissions returns the effective permissions of the signed-in user
+func (c *PermissionController) GetMyPermissions(ctx *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+	c.effectivePermissions(ctx, userID)
+}
+
+func (c *PermissionController) listPermissions(ctx *gin.Context, clientID string) {
+	permissions, err := c.permissionService.WithContext(ctx).GetAllPermissions(clientID)
+	if err != nil {
+		c.logger.Errorf("Failed to get permissions: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get permissions"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, permissions)
+}
+
+func (c *PermissionController) createPermission(ctx *gin.Context, callerClientID string) {
+	var req PermissionCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	permission, err := c.permissionService.WithContext(ctx).CreatePermission(req, callerClientID)
+	if err != nil {
+		c.respondError(ctx, err, "Failed to create permission")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, permission)
+}
+
+func (c *PermissionController) updatePermission(ctx *gin.Context, callerClientID string) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission ID"})
+		return
+	}
+
+	var req PermissionUpdateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	permission, err := c.permissionService.WithContext(ctx).UpdatePermission(id, req, callerClientID)
+	if err != nil {
+		c.respondError(ctx, err, "Failed to update permission")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, permission)
+}
+
+func (c *PermissionController) deletePermission(ctx *gin.Context, callerClientID string) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid permission ID"})
+		return
+	}
+
+	if err := c.permissionService.WithContext(ctx).DeletePermission(id, callerClientID); err != nil {
+		c.respondError(ctx, err, "Failed to delete permission")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *PermissionController) changeRolePermissions(ctx *gin.Context, change func(uuid.UUID, []string) ([]Permission, error)) {
+	roleID, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid role ID"})
+		return
+	}
+
+	var req RolePermissionsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	permissions, err := change(roleID, req.Permissions)
+	if err != nil {
+		c.respondError(ctx, err, "Failed to change role permissions")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, permissions)
+}
+
+func (c *PermissionController) effectivePermissions(ctx *gin.Context, userID uuid.UUID) {
+	permissions, err := c.permissionService.WithContext(ctx).GetEffectivePermissions(userID)
+	if err != nil {
+		c.logger.Errorf("Failed to get effective permissions: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get effective permissions"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, EffectivePermissionsResponse{
+		UserID:      userID.String(),
+		Permissions: permissions,
+	})
+}
+
+// respondError maps service errors to responses, logging unexpected ones
+func (c *PermissionController) respondError(ctx *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrRoleNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidName), errors.Is(err, ErrUnknownClient), errors.Is(err, ErrUnknownPermission):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNameTaken), errors.Is(err, ErrBuiltin):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotOwner):
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.logger.Errorf("%s: %v", message, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// requireClient returns the client the token was issued to, rejecting the
+// request if it wasn't issued to a client
+func requireClient(ctx *gin.Context) string {
+	clientID := middleware.GetClientID(ctx)
+	if clientID == "" {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Only clients can register their own permissions"})
+	}
+	return clientID
+}
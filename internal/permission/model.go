@@ -0,0 +1,60 @@
+package permission
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Built-in permissions name what the service itself offers. They are created
+// on startup and can't be renamed or deleted.
+var builtinPermissions = []Permission{
+	{Name: "users.read", Description: "Read users"},
+	{Name: "users.manage", Description: "Create, update and delete users"},
+	{Name: "groups.read", Description: "Read groups and their members"},
+	{Name: "groups.manage", Description: "Create, update and delete groups and their members"},
+	{Name: "roles.read", Description: "Read roles and their assignments"},
+	{Name: "roles.manage", Description: "Create, update and delete roles and their assignments"},
+	{Name: "orgs.read", Description: "Read orgs and their members"},
+	{Name: "orgs.manage", Description: "Create, update and delete orgs and their members"},
+	{Name: "permissions.read", Description: "Read the permissions catalog"},
+	{Name: "permissions.manage", Description: "Manage the permissions catalog and the permissions of roles"},
+}
+
+// Permission is an entry of the permissions catalog. Roles carry
+// permissions; users have the permissions of the roles assigned to them or
+// to their groups.
+type Permission struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name        string    `json:"name" gorm:"type:varchar(128);not null;uniqueIndex"`
+	Description string    `json:"description"`
+	// ClientID is the OAuth client that registered the permission; empty for
+	// built-in permissions and those created by administrators
+	ClientID  string    `json:"clientId,omitempty" gorm:"column:client_id;index"`
+	Builtin   bool      `json:"builtin" gorm:"not null;default:false"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (p *Permission) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+func (p *Permission) TableName() string {
+	return "permissions"
+}
+
+// RolePermission grants a permission to everyone assigned the role
+type RolePermission struct {
+	RoleID       uuid.UUID `json:"roleId" gorm:"type:uuid;primaryKey"`
+	PermissionID uuid.UUID `json:"permissionId" gorm:"type:uuid;primaryKey;index"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+func (rp *RolePermission) TableName() string {
+	return "role_permissions"
+}
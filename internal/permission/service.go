@@ -0,0 +1,473 @@
+package permission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/org"
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotFound          = errors.New("permission not found")
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrInvalidName       = errors.New("permission names may only hold lowercase letters, digits, '.', '_', ':' and '-'")
+	ErrNameTaken         = errors.New("a permission with this name already exists")
+	ErrBuiltin           = errors.New("built-in permissions can't be renamed or deleted")
+	ErrNotOwner          = errors.New("permission belongs to another client")
+	ErrUnknownClient     = errors.New("unknown client")
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+// ClaimName is the access token claim listing the user's permissions
+const ClaimName = "permissions"
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._:-]*$`)
+
+type PermissionService struct {
+	db     *gorm.DB
+	logger *logrus.Logger
+}
+
+func NewPermissionService(db *gorm.DB) *PermissionService {
+	return &PermissionService{
+		db:     db,
+		logger: logrus.New(),
+	}
+}
+
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *PermissionService) WithContext(ctx context.Context) *PermissionService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
+// EnsureBuiltins creates the built-in permissions that are missing
+func (s *PermissionService) EnsureBuiltins() error {
+	for _, builtin := range builtinPermissions {
+		permission := builtin
+		permission.Builtin = true
+		err := s.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"builtin": true}),
+		}).Create(&permission).Error
+		if err != nil {
+			return fmt.Errorf("failed to create built-in permission %s: %w", builtin.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetAllPermissions returns the catalog, or the permissions registered by
+// one client if clientID is set
+func (s *PermissionService) GetAllPermissions(clientID string) ([]Permission, error) {
+	query := s.db.Order("name")
+	if clientID != "" {
+		query = query.Where("client_id = ?", clientID)
+	}
+	var permissions []Permission
+	if err := query.Find(&permissions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+func (s *PermissionService) GetPermission(id uuid.UUID) (*Permission, error) {
+	var permission Permission
+	result := s.db.First(&permission, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get permission: %w", result.Error)
+	}
+	return &permission, nil
+}
+
+// CreatePermission adds a permission to the catalog. callerClientID is set
+// when a client registers a permission for itself; the permission then
+// belongs to that client.
+func (s *PermissionService) CreatePermission(req PermissionCreateRequest, callerClientID string) (*Permission, error) {
+	if !namePattern.MatchString(req.Name) {
+		return nil, ErrInvalidName
+	}
+	clientID := req.ClientID
+	if callerClientID != "" {
+		if clientID != "" && clientID != callerClientID {
+			return nil, ErrNotOwner
+		}
+		clientID = callerClientID
+	} else if clientID != "" {
+		var count int64
+		if err := s.db.Table("clients").Where("client_id = ?", clientID).Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("failed to get client: %w", err)
+		}
+		if count == 0 {
+			return nil, ErrUnknownClient
+		}
+	}
+	if err := s.checkNameFree(req.Name, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	permission := Permission{
+		Name:        req.Name,
+		Description: req.Description,
+		ClientID:    clientID,
+	}
+	if err := s.db.Create(&permission).Error; err != nil {
+		return nil, fmt.Errorf("failed to create permission: %w", err)
+	}
+
+	events.Publish(events.Event{
+		Type:    "permission.created",
+		Subject: permission.ID.String(),
+		Data:    map[string]interface{}{"name": permission.Name, "clientId": permission.ClientID},
+	})
+	return &permission, nil
+}
+
+// UpdatePermission renames a permission or changes its description. Clients
+// may only update their own permissions.
+func (s *PermissionService) UpdatePermission(id uuid.UUID, req PermissionUpdateRequest, callerClientID string) (*Permission, error) {
+	permission, err := s.GetPermission(id)
+	if err != nil {
+		return nil, err
+	}
+	if permission == nil {
+		return nil, ErrNotFound
+	}
+	if callerClientID != "" && permission.ClientID != callerClientID {
+		return nil, ErrNotOwner
+	}
+
+	if req.Name != "" && req.Name != permission.Name {
+		if permission.Builtin {
+			return nil, ErrBuiltin
+		}
+		if !namePattern.MatchString(req.Name) {
+			return nil, ErrInvalidName
+		}
+		if err := s.checkNameFree(req.Name, permission.ID); err != nil {
+			return nil, err
+		}
+		permission.Name = req.Name
+	}
+	if req.Description != nil {
+		permission.Description = *req.Description
+	}
+	permission.UpdatedAt = time.Now()
+
+	if err := s.db.Save(permission).Error; err != nil {
+		return nil, fmt.Errorf("failed to update permission: %w", err)
+	}
+	return permission, nil
+}
+
+// DeletePermission removes a permission from the catalog and from every role
+// carrying it. Clients may only delete their own permissions.
+func (s *PermissionService) DeletePermission(id uuid.UUID, callerClientID string) error {
+	permission, err := s.GetPermission(id)
+	if err != nil {
+		return err
+	}
+	if permission == nil {
+		return ErrNotFound
+	}
+	if permission.Builtin {
+		return ErrBuiltin
+	}
+	if callerClientID != "" && permission.ClientID != callerClientID {
+		return ErrNotOwner
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("permission_id = ?", id).Delete(&RolePermission{}).Error; err != nil {
+			return fmt.Errorf("failed to remove permission from roles: %w", err)
+		}
+		if err := tx.Delete(permission).Error; err != nil {
+			return fmt.Errorf("failed to delete permission: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	events.Publish(events.Event{
+		Type:    "permission.deleted",
+		Subject: id.String(),
+		Data:    map[string]interface{}{"name": permission.Name},
+	})
+	return nil
+}
+
+func (s *PermissionService) checkNameFree(name string, except uuid.UUID) error {
+	var count int64
+	if err := s.db.Model(&Permission{}).Where("name = ? AND id <> ?", name, except).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check permission name: %w", err)
+	}
+	if count > 0 {
+		return ErrNameTaken
+	}
+	return nil
+}
+
+// GetRolePermissions returns the permissions a role carries
+func (s *PermissionService) GetRolePermissions(roleID uuid.UUID) ([]Permission, error) {
+	if err := s.checkRole(s.db, roleID); err != nil {
+		return nil, err
+	}
+	var permissions []Permission
+	err := s.db.Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+		Where("role_permissions.role_id = ?", roleID).Order("permissions.name").Find(&permissions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get role permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// AddRolePermissions grants the named permissions to a role. Permissions the
+// role already carries are kept.
+func (s *PermissionService) AddRolePermissions(roleID uuid.UUID, names []string) ([]Permission, error) {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		return s.linkPermissions(tx, roleID, names)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.publishRoleChange(roleID)
+	return s.GetRolePermissions(roleID)
+}
+
+// SetRolePermissions replaces the permissions of a role with the named ones
+func (s *PermissionService) SetRolePermissions(roleID uuid.UUID, names []string) ([]Permission, error) {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkRole(tx, roleID); err != nil {
+			return err
+		}
+		if err := tx.Where("role_id = ?", roleID).Delete(&RolePermission{}).Error; err != nil {
+			return fmt.Errorf("failed to clear role permissions: %w", err)
+		}
+		return s.linkPermissions(tx, roleID, names)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.publishRoleChange(roleID)
+	return s.GetRolePermissions(roleID)
+}
+
+// RemoveRolePermission takes a permission away from a role
+func (s *PermissionService) RemoveRolePermission(roleID, permissionID uuid.UUID) error {
+	result := s.db.Where("role_id = ? AND permission_id = ?", roleID, permissionID).Delete(&RolePermission{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove role permission: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	s.publishRoleChange(roleID)
+	return nil
+}
+
+func (s *PermissionService) linkPermissions(tx *gorm.DB, roleID uuid.UUID, names []string) error {
+	if err := s.checkRole(tx, roleID); err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	var permissions []Permission
+	if err := tx.Where("name IN ?", names).Find(&permissions).Error; err != nil {
+		return fmt.Errorf("failed to get permissions: %w", err)
+	}
+	found := make(map[string]bool, len(permissions))
+	for _, p := range permissions {
+		found[p.Name] = true
+	}
+	var unknown []string
+	for _, name := range names {
+		if !found[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownPermission, strings.Join(unknown, ", "))
+	}
+
+	links := make([]RolePermission, len(permissions))
+	for i, p := range permissions {
+		links[i] = RolePermission{RoleID: roleID, PermissionID: p.ID, CreatedAt: time.Now()}
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&links).Error; err != nil {
+		return fmt.Errorf("failed to add role permissions: %w", err)
+	}
+	return nil
+}
+
+func (s *PermissionService) checkRole(db *gorm.DB, roleID uuid.UUID) error {
+	var count int64
+	if err := db.Table("roles").Where("id = ?", roleID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to get role: %w", err)
+	}
+	if count == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+func (s *PermissionService) publishRoleChange(roleID uuid.UUID) {
+	events.Publish(events.Event{
+		Type:    "role.permissions_changed",
+		Subject: roleID.String(),
+	})
+}
+
+// HandleRoleDeleted drops the permissions of a deleted role
+func (s *PermissionService) HandleRoleDeleted(event events.Event) {
+	roleID, err := uuid.Parse(event.Subject)
+	if err != nil {
+		return
+	}
+	if err := s.db.Where("role_id = ?", roleID).Delete(&RolePermission{}).Error; err != nil {
+		s.logger.Errorf("Failed to remove permissions of deleted role %s: %v", roleID, err)
+	}
+}
+
+// roleGrant is a permission granted through a role, assigned to the user, to
+// one of their groups or to one of their orgs
+type roleGrant struct {
+	Permission string
+	RoleID     uuid.UUID
+	RoleName   string
+	GroupID    *uuid.UUID
+	OrgID      *uuid.UUID
+}
+
+// GetEffectivePermissions returns the permissions of the roles assigned to
+// the user directly, through their groups, nested ones included, or through
+// their orgs, cascading memberships of the orgs above included. Assignments
+// and memberships outside their window are left out.
+func (s *PermissionService) GetEffectivePermissions(userID uuid.UUID) ([]EffectivePermission, error) {
+	inEffect := models.InEffectSQL("rm")
+	var grants []roleGrant
+	err := s.db.Raw(`WITH RECURSIVE `+member.UserGroupsSQL+`, `+org.MembershipsSQL+`
+		SELECT p.name AS permission, r.id AS role_id, r.name AS role_name, NULL AS group_id, NULL AS org_id
+		FROM role_members rm
+		JOIN roles r ON r.id = rm.role_id
+		JOIN role_permissions rp ON rp.role_id = r.id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE UPPER(rm.type) = 'USER' AND rm.entity_id = ? AND `+inEffect+`
+		UNION
+		SELECT p.name, r.id, r.name, g.group_id, NULL
+		FROM user_groups g
+		JOIN role_members rm ON UPPER(rm.type) = 'GROUP' AND rm.entity_id = g.group_id AND `+inEffect+`
+		JOIN roles r ON r.id = rm.role_id
+		JOIN role_permissions rp ON rp.role_id = r.id
+		JOIN permissions p ON p.id = rp.permission_id
+		UNION
+		SELECT p.name, r.id, r.name, NULL, om.org_id
+		FROM org_memberships om
+		JOIN role_members rm ON UPPER(rm.type) = 'ORG' AND rm.entity_id = om.org_id AND `+inEffect+`
+		JOIN roles r ON r.id = rm.role_id
+		JOIN role_permissions rp ON rp.role_id = r.id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE UPPER(om.type) = 'USER' AND om.entity_id = ?
+		UNION
+		SELECT p.name, r.id, r.name, g.group_id, om.org_id
+		FROM user_groups g
+		JOIN org_memberships om ON UPPER(om.type) = 'GROUP' AND om.entity_id = g.group_id
+		JOIN role_members rm ON UPPER(rm.type) = 'ORG' AND rm.entity_id = om.org_id AND `+inEffect+`
+		JOIN roles r ON r.id = rm.role_id
+		JOIN role_permissions rp ON rp.role_id = r.id
+		JOIN permissions p ON p.id = rp.permission_id`, userID, member.MaxNestingDepth, userID, userID).Find(&grants).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get effective permissions: %w", err)
+	}
+	return effectivePermissions(grants), nil
+}
+
+// GetPermissionNames returns the names of the user's effective permissions
+func (s *PermissionService) GetPermissionNames(userID uuid.UUID) ([]string, error) {
+	permissions, err := s.GetEffectivePermissions(userID)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(permissions))
+	for i, p := range permissions {
+		names[i] = p.Name
+	}
+	return names, nil
+}
+
+// TokenClaims adds the user's effective permissions to their access tokens.
+// Subjects that aren't users, such as clients, get no claim.
+func (s *PermissionService) TokenClaims(ctx context.Context, subject string) (map[string]interface{}, error) {
+	userID, err := uuid.Parse(subject)
+	if err != nil {
+		return nil, nil
+	}
+	names, err := s.WithContext(ctx).GetPermissionNames(userID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{ClaimName: names}, nil
+}
+
+// effectivePermissions groups grants by permission, sorted by name, with
+// direct assignments before group ones and group ones before org ones
+func effectivePermissions(grants []roleGrant) []EffectivePermission {
+	byName := make(map[string]*EffectivePermission)
+	for _, g := range grants {
+		permission, ok := byName[g.Permission]
+		if !ok {
+			permission = &EffectivePermission{Name: g.Permission}
+			byName[g.Permission] = permission
+		}
+		source := PermissionSource{RoleID: g.RoleID.String(), RoleName: g.RoleName}
+		if g.GroupID != nil {
+			source.GroupID = g.GroupID.String()
+		}
+		if g.OrgID != nil {
+			source.OrgID = g.OrgID.String()
+		}
+		permission.Sources = append(permission.Sources, source)
+	}
+
+	permissions := make([]EffectivePermission, 0, len(byName))
+	for _, permission := range byName {
+		sort.Slice(permission.Sources, func(i, j int) bool {
+			a, b := permission.Sources[i], permission.Sources[j]
+			if a.rank() != b.rank() {
+				return a.rank() < b.rank()
+			}
+			if a.RoleName != b.RoleName {
+				return a.RoleName < b.RoleName
+			}
+			if a.OrgID != b.OrgID {
+				return a.OrgID < b.OrgID
+			}
+			return a.GroupID < b.GroupID
+		})
+		permissions = append(permissions, *permission)
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i].Name < permissions[j].Name
+	})
+	return permissions
+}
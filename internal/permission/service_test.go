@@ -0,0 +1,46 @@
+package permission
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamePattern(t *testing.T) {
+	for _, name := range []string{"users.read", "billing:invoices.approve", "app-1_reports"} {
+		assert.True(t, namePattern.MatchString(name), name)
+	}
+	for _, name := range []string{"", "Users.read", ".hidden", "users read", "users/read"} {
+		assert.False(t, namePattern.MatchString(name), name)
+	}
+	for _, builtin := range builtinPermissions {
+		assert.True(t, namePattern.MatchString(builtin.Name), builtin.Name)
+	}
+}
+
+func TestEffectivePermissions(t *testing.T) {
+	admin, auditor := uuid.New(), uuid.New()
+	staff, acme := uuid.New(), uuid.New()
+
+	permissions := effectivePermissions([]roleGrant{
+		{Permission: "users.read", RoleID: admin, RoleName: "admin", OrgID: &acme},
+		{Permission: "users.read", RoleID: auditor, RoleName: "auditor", GroupID: &staff},
+		{Permission: "users.manage", RoleID: admin, RoleName: "admin"},
+		{Permission: "users.read", RoleID: admin, RoleName: "admin"},
+	})
+
+	assert.Equal(t, []EffectivePermission{
+		{Name: "users.manage", Sources: []PermissionSource{
+			{RoleID: admin.String(), RoleName: "admin"},
+		}},
+		{Name: "users.read", Sources: []PermissionSource{
+			// Direct assignments come first, then group and org ones
+			{RoleID: admin.String(), RoleName: "admin"},
+			{RoleID: auditor.String(), RoleName: "auditor", GroupID: staff.String()},
+			{RoleID: admin.String(), RoleName: "admin", OrgID: acme.String()},
+		}},
+	}, permissions)
+
+	assert.Empty(t, effectivePermissions(nil))
+}
@@ -16,8 +16,10 @@ type PKCECode struct {
 	RedirectURI         string    `gorm:"not null"`
 	State               *string   `gorm:"default:null"`
 	UserID              *uuid.UUID
-	ExpiresAt           time.Time `gorm:"not null"`
-	Used                bool      `gorm:"not null;default:false"`
+	SessionID           *uuid.UUID `gorm:"type:uuid"`
+	ActorID             *uuid.UUID `gorm:"type:uuid"`
+	ExpiresAt           time.Time  `gorm:"not null"`
+	Used                bool       `gorm:"not null;default:false"`
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
 }
@@ -0,0 +1,182 @@
+package provisioning
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// listPageSize is the count requested per page when listing resources
+const listPageSize = 100
+
+// RemoteError is an error response of a target
+type RemoteError struct {
+	Status int
+	Detail string
+}
+
+func (e *RemoteError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("target responded %d: %s", e.Status, e.Detail)
+	}
+	return fmt.Sprintf("target responded %d", e.Status)
+}
+
+// retryable reports whether a failed delivery may succeed later: transport
+// errors, rate limiting and server errors are retried
+func retryable(err error) bool {
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) {
+		return remoteErr.Status == http.StatusTooManyRequests || remoteErr.Status >= 500
+	}
+	return true
+}
+
+func isNotFound(err error) bool {
+	var remoteErr *RemoteError
+	return errors.As(err, &remoteErr) && remoteErr.Status == http.StatusNotFound
+}
+
+// Client talks SCIM 2.0 to a target. Resources are handled as generic JSON
+// objects so that targets may return attributes this service doesn't know.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+func NewClient(target Target) *Client {
+	return &Client{
+		baseURL:    target.URL,
+		token:      target.Token,
+		httpClient: &http.Client{Timeout: target.timeout},
+	}
+}
+
+// Create posts a resource to an endpoint ("Users" or "Groups") and returns
+// the created resource
+func (c *Client) Create(endpoint string, resource map[string]interface{}) (map[string]interface{}, error) {
+	var created map[string]interface{}
+	err := c.do(http.MethodPost, endpoint, resource, &created)
+	return created, err
+}
+
+// Replace replaces a resource with PUT
+func (c *Client) Replace(endpoint, id string, resource map[string]interface{}) (map[string]interface{}, error) {
+	var replaced map[string]interface{}
+	err := c.do(http.MethodPut, endpoint+"/"+url.PathEscape(id), resource, &replaced)
+	return replaced, err
+}
+
+// Deactivate sets active to false with PATCH
+func (c *Client) Deactivate(endpoint, id string) error {
+	return c.do(http.MethodPatch, endpoint+"/"+url.PathEscape(id), map[string]interface{}{
+		"schemas": []string{schemaPatchOp},
+		"Operations": []map[string]interface{}{
+			{"op": "replace", "path": "active", "value": false},
+		},
+	}, nil)
+}
+
+// Delete deletes a resource; resources that are already gone are ignored
+func (c *Client) Delete(endpoint, id string) error {
+	err := c.do(http.MethodDelete, endpoint+"/"+url.PathEscape(id), nil, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// Find returns the first resource matching an eq filter on attribute, or nil
+func (c *Client) Find(endpoint, attribute, value string) (map[string]interface{}, error) {
+	quoted, _ := json.Marshal(value)
+	query := url.Values{"filter": {attribute + " eq " + string(quoted)}}
+	var list listResponse
+	if err := c.do(http.MethodGet, endpoint+"?"+query.Encode(), nil, &list); err != nil {
+		return nil, err
+	}
+	if len(list.Resources) == 0 {
+		return nil, nil
+	}
+	return list.Resources[0], nil
+}
+
+// List pages through all resources of an endpoint
+func (c *Client) List(endpoint string) ([]map[string]interface{}, error) {
+	var resources []map[string]interface{}
+	startIndex := 1
+	for {
+		query := url.Values{"startIndex": {strconv.Itoa(startIndex)}, "count": {strconv.Itoa(listPageSize)}}
+		var list listResponse
+		if err := c.do(http.MethodGet, endpoint+"?"+query.Encode(), nil, &list); err != nil {
+			return nil, err
+		}
+		resources = append(resources, list.Resources...)
+		startIndex += len(list.Resources)
+		if len(list.Resources) == 0 || len(resources) >= list.TotalResults {
+			return resources, nil
+		}
+	}
+}
+
+type listResponse struct {
+	TotalResults int                      `json:"totalResults"`
+	Resources    []map[string]interface{} `json:"Resources"`
+}
+
+func (c *Client) do(method, path string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.baseURL+"/"+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/scim+json, application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/scim+json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call target: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var scimErr struct {
+			Detail string `json:"detail"`
+		}
+		_ = json.Unmarshal(data, &scimErr)
+		return &RemoteError{Status: resp.StatusCode, Detail: scimErr.Detail}
+	}
+	if result != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, result); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+	return nil
+}
+
+// resourceID returns the id of a remote resource
+func resourceID(resource map[string]interface{}) string {
+	id, _ := resource["id"].(string)
+	return id
+}
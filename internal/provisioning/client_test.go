@@ -0,0 +1,238 @@
+package provisioning
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testToken = "scim-secret"
+
+// fakeTarget is an in-process SCIM server keeping resources in memory. It
+// supports create, replace, patch of active, delete, eq filters on userName
+// and displayName, and paging. Setting fail makes every request respond
+// with that status.
+type fakeTarget struct {
+	mu        sync.Mutex
+	resources map[string]map[string]map[string]interface{}
+	nextID    int
+	fail      int
+	requests  []string
+}
+
+var eqFilter = regexp.MustCompile(`^(\w+) eq "(.*)"$`)
+
+func startFakeTarget(t *testing.T) (*fakeTarget, *Client) {
+	t.Helper()
+	fake := &fakeTarget{resources: map[string]map[string]map[string]interface{}{
+		endpointUsers:  {},
+		endpointGroups: {},
+	}}
+	server := httptest.NewServer(http.HandlerFunc(fake.serve))
+	t.Cleanup(server.Close)
+	return fake, NewClient(Target{ID: "fake", URL: server.URL, Token: testToken, timeout: 5 * time.Second})
+}
+
+func (f *fakeTarget) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+
+	if f.fail != 0 {
+		writeJSON(w, f.fail, map[string]interface{}{"detail": "injected failure"})
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"detail": "invalid token"})
+		return
+	}
+
+	endpoint, id, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	resources, ok := f.resources[endpoint]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, nil)
+		return
+	}
+	var body map[string]interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		f.list(w, r, resources)
+	case r.Method == http.MethodPost && id == "":
+		f.nextID++
+		body["id"] = fmt.Sprintf("remote-%d", f.nextID)
+		resources[body["id"].(string)] = body
+		writeJSON(w, http.StatusCreated, body)
+	case resources[id] == nil:
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"detail": "resource not found"})
+	case r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, resources[id])
+	case r.Method == http.MethodPut:
+		body["id"] = id
+		resources[id] = body
+		writeJSON(w, http.StatusOK, body)
+	case r.Method == http.MethodPatch:
+		for _, op := range body["Operations"].([]interface{}) {
+			op := op.(map[string]interface{})
+			resources[id][op["path"].(string)] = op["value"]
+		}
+		writeJSON(w, http.StatusOK, resources[id])
+	case r.Method == http.MethodDelete:
+		delete(resources, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeTarget) list(w http.ResponseWriter, r *http.Request, resources map[string]map[string]interface{}) {
+	var matched []map[string]interface{}
+	for i := 1; i <= f.nextID; i++ {
+		resource := resources[fmt.Sprintf("remote-%d", i)]
+		if resource == nil {
+			continue
+		}
+		if m := eqFilter.FindStringSubmatch(r.URL.Query().Get("filter")); m != nil {
+			if value, _ := resource[m[1]].(string); !strings.EqualFold(value, m[2]) {
+				continue
+			}
+		}
+		matched = append(matched, resource)
+	}
+
+	startIndex, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if err != nil {
+		count = len(matched)
+	}
+	page := []map[string]interface{}{}
+	for i := startIndex - 1; i < len(matched) && len(page) < count; i++ {
+		page = append(page, matched[i])
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"totalResults": len(matched), "Resources": page})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	if body != nil {
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+func TestClientLifecycle(t *testing.T) {
+	fake, client := startFakeTarget(t)
+
+	created, err := client.Create(endpointUsers, map[string]interface{}{"userName": "jane@example.org", "active": true})
+	require.NoError(t, err)
+	id := resourceID(created)
+	require.NotEmpty(t, id)
+
+	found, err := client.Find(endpointUsers, "userName", "JANE@example.org")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, id, resourceID(found))
+
+	missing, err := client.Find(endpointUsers, "userName", "john@example.org")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	_, err = client.Replace(endpointUsers, id, map[string]interface{}{"userName": "jane.doe@example.org", "active": true})
+	require.NoError(t, err)
+	require.NoError(t, client.Deactivate(endpointUsers, id))
+	assert.Equal(t, false, fake.resources[endpointUsers][id]["active"])
+	assert.Equal(t, "jane.doe@example.org", fake.resources[endpointUsers][id]["userName"])
+
+	require.NoError(t, client.Delete(endpointUsers, id))
+	assert.Empty(t, fake.resources[endpointUsers])
+	// Deleting a resource that is already gone succeeds
+	require.NoError(t, client.Delete(endpointUsers, id))
+
+	_, err = client.Replace(endpointUsers, id, map[string]interface{}{"userName": "jane@example.org"})
+	assert.True(t, isNotFound(err))
+}
+
+func TestClientListPages(t *testing.T) {
+	_, client := startFakeTarget(t)
+	for i := 0; i < 2*listPageSize+5; i++ {
+		_, err := client.Create(endpointUsers, map[string]interface{}{"userName": fmt.Sprintf("user%d@example.org", i)})
+		require.NoError(t, err)
+	}
+
+	users, err := client.List(endpointUsers)
+	require.NoError(t, err)
+	require.Len(t, users, 2*listPageSize+5)
+	assert.Equal(t, "user0@example.org", users[0]["userName"])
+	assert.Equal(t, fmt.Sprintf("user%d@example.org", 2*listPageSize+4), users[len(users)-1]["userName"])
+}
+
+func TestClientErrors(t *testing.T) {
+	fake, client := startFakeTarget(t)
+
+	for status, retry := range map[int]bool{
+		http.StatusBadRequest:          false,
+		http.StatusConflict:            false,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusServiceUnavailable:  true,
+	} {
+		fake.fail = status
+		_, err := client.Create(endpointUsers, map[string]interface{}{"userName": "jane@example.org"})
+		var remoteErr *RemoteError
+		require.ErrorAs(t, err, &remoteErr)
+		assert.Equal(t, status, remoteErr.Status)
+		assert.Equal(t, "injected failure", remoteErr.Detail)
+		assert.Equal(t, retry, retryable(err), status)
+	}
+
+	fake.fail = 0
+	client.token = "wrong"
+	_, err := client.List(endpointUsers)
+	assert.EqualError(t, err, "target responded 401: invalid token")
+	assert.False(t, retryable(err))
+
+	unreachable := NewClient(Target{URL: "http://127.0.0.1:1", timeout: time.Second})
+	_, err = unreachable.List(endpointUsers)
+	require.Error(t, err)
+	assert.True(t, retryable(err))
+}
+
+func TestCreateOrAdopt(t *testing.T) {
+	fake, client := startFakeTarget(t)
+	existing, err := client.Create(endpointUsers, map[string]interface{}{"userName": "jane@example.org", "displayName": "Old"})
+	require.NoError(t, err)
+
+	id, err := createOrAdopt(client, endpointUsers, "userName", "jane@example.org",
+		map[string]interface{}{"userName": "jane@example.org", "displayName": "Jane Doe"})
+	require.NoError(t, err)
+	assert.Equal(t, resourceID(existing), id)
+	assert.Len(t, fake.resources[endpointUsers], 1)
+	assert.Equal(t, "Jane Doe", fake.resources[endpointUsers][id]["displayName"])
+
+	id, err = createOrAdopt(client, endpointGroups, "displayName", "Admins",
+		map[string]interface{}{"displayName": "Admins", "members": []interface{}{map[string]interface{}{"value": id}}})
+	require.NoError(t, err)
+	assert.Len(t, fake.resources[endpointGroups], 1)
+	assert.Len(t, fake.resources[endpointGroups][id]["members"], 1)
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, 30*time.Second, retryDelay(1))
+	assert.Equal(t, time.Minute, retryDelay(2))
+	assert.Equal(t, 4*time.Minute, retryDelay(4))
+	assert.Equal(t, time.Hour, retryDelay(maxAttempts))
+}
@@ -0,0 +1,98 @@
+package provisioning
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Target is a downstream application users and groups are pushed to over
+// SCIM 2.0
+type Target struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// URL is the SCIM base URL, e.g. https://app.example.com/scim/v2
+	URL string `json:"url"`
+	// Token is the bearer token and may reference environment variables,
+	// e.g. "${SLACK_SCIM_TOKEN}"
+	Token string `json:"token"`
+	// Groups enables pushing groups and their memberships
+	Groups bool `json:"groups"`
+	// DeleteUsers deletes remote users when their local user is deleted or
+	// deprovisioned; by default they are deactivated
+	DeleteUsers bool `json:"deleteUsers"`
+	// Mapping maps SCIM attribute paths of the remote user onto local user
+	// fields (see Sources); it defaults to DefaultMapping
+	Mapping map[string]string `json:"mapping"`
+	// Timeout is a duration string such as "30s"
+	Timeout string `json:"timeout"`
+
+	timeout time.Duration
+}
+
+// LoadTargets reads the provisioning targets from a JSON file. An empty path
+// disables outbound provisioning.
+func LoadTargets(path string) ([]Target, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provisioning targets: %w", err)
+	}
+	var targets []Target
+	if err := json.Unmarshal(data, &targets); err != nil {
+		return nil, fmt.Errorf("failed to parse provisioning targets: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range targets {
+		target := &targets[i]
+		target.Token = os.ExpandEnv(target.Token)
+		if err := target.applyDefaults(); err != nil {
+			return nil, fmt.Errorf("provisioning target %q: %w", target.ID, err)
+		}
+		if seen[target.ID] {
+			return nil, fmt.Errorf("duplicate provisioning target %q", target.ID)
+		}
+		seen[target.ID] = true
+	}
+	return targets, nil
+}
+
+func (t *Target) applyDefaults() error {
+	if t.ID == "" || t.URL == "" {
+		return fmt.Errorf("id and url are required")
+	}
+	t.URL = strings.TrimSuffix(t.URL, "/")
+	if t.Name == "" {
+		t.Name = t.ID
+	}
+	if len(t.Mapping) == 0 {
+		t.Mapping = DefaultMapping
+	}
+	for path, source := range t.Mapping {
+		if !validSources[source] {
+			return fmt.Errorf("unknown source %q for %s", source, path)
+		}
+		if _, err := parseAttributePath(path); err != nil {
+			return err
+		}
+	}
+	if _, ok := t.Mapping["userName"]; !ok {
+		return fmt.Errorf("mapping must include userName")
+	}
+
+	t.timeout = 30 * time.Second
+	if t.Timeout != "" {
+		timeout, err := time.ParseDuration(t.Timeout)
+		if err != nil || timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", t.Timeout)
+		}
+		t.timeout = timeout
+	}
+	return nil
+}
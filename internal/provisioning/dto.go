@@ -0,0 +1,54 @@
+package provisioning
+
+// TargetResponse describes a target and its delivery status. The token is
+// never returned.
+type TargetResponse struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	URL         string            `json:"url"`
+	Groups      bool              `json:"groups"`
+	DeleteUsers bool              `json:"deleteUsers"`
+	Mapping     map[string]string `json:"mapping"`
+	Status      TargetStatus      `json:"status"`
+}
+
+// TargetStatus summarizes the queue and links of a target
+type TargetStatus struct {
+	PendingJobs   int64                 `json:"pendingJobs"`
+	FailedJobs    int64                 `json:"failedJobs"`
+	LinkedUsers   int64                 `json:"linkedUsers"`
+	LinkedGroups  int64                 `json:"linkedGroups"`
+	LastSyncedAt  string                `json:"lastSyncedAt,omitempty"`
+	LastError     string                `json:"lastError,omitempty"`
+	LastReconcile *ReconcileRunResponse `json:"lastReconcile,omitempty"`
+}
+
+type JobResponse struct {
+	ID            string `json:"id"`
+	Kind          string `json:"kind"`
+	LocalID       string `json:"localId"`
+	State         string `json:"state"`
+	Force         bool   `json:"force"`
+	Attempts      int    `json:"attempts"`
+	NextAttemptAt string `json:"nextAttemptAt,omitempty"`
+	LastError     string `json:"lastError,omitempty"`
+	CreatedAt     string `json:"createdAt"`
+	UpdatedAt     string `json:"updatedAt"`
+}
+
+// ReconcileRunResponse describes a reconciliation run. Summary counts its
+// changes by "<kind>.<action>", plus "failed" for changes that could not be
+// queued.
+type ReconcileRunResponse struct {
+	ID         string         `json:"id"`
+	TargetID   string         `json:"targetId"`
+	DryRun     bool           `json:"dryRun"`
+	Trigger    string         `json:"trigger"`
+	Actor      string         `json:"actor,omitempty"`
+	State      string         `json:"state"`
+	Error      string         `json:"error,omitempty"`
+	Summary    map[string]int `json:"summary"`
+	Changes    []Change       `json:"changes,omitempty"`
+	StartedAt  string         `json:"startedAt"`
+	FinishedAt string         `json:"finishedAt"`
+}
@@ -0,0 +1,144 @@
+package provisioning
+
+import (
+	"errors"
+	"net/http"
+
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type ProvisioningController struct {
+	provisioningService *ProvisioningService
+	logger              *logrus.Logger
+}
+
+func NewProvisioningController(provisioningService *ProvisioningService) *ProvisioningController {
+	return &ProvisioningController{
+		provisioningService: provisioningService,
+		logger:              logrus.New(),
+	}
+}
+
+func (c *ProvisioningController) GetTargets(ctx *gin.Context) {
+	targets, err := c.provisioningService.WithContext(ctx).GetTargets()
+	if err != nil {
+		c.logger.Errorf("Failed to get provisioning targets: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get provisioning targets"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, targets)
+}
+
+func (c *ProvisioningController) GetTarget(ctx *gin.Context) {
+	target, err := c.provisioningService.WithContext(ctx).GetTarget(ctx.Param("id"))
+	if err != nil {
+		c.logger.Errorf("Failed to get provisioning target: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get provisioning target"})
+		return
+	}
+	if target == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Provisioning target not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, target)
+}
+
+// GetJobs lists the queued deliveries of a target, filtered by ?state=
+func (c *ProvisioningController) GetJobs(ctx *gin.Context) {
+	state := ctx.Query("state")
+	switch state {
+	case "", JobStatePending, JobStateProcessing, JobStateFailed:
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job state"})
+		return
+	}
+
+	jobs, err := c.provisioningService.WithContext(ctx).GetJobs(ctx.Param("id"), state)
+	if err != nil {
+		c.handleError(ctx, err, "Failed to get provisioning jobs")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, jobs)
+}
+
+// Retry queues the failed deliveries of a target again
+func (c *ProvisioningController) Retry(ctx *gin.Context) {
+	retried, err := c.provisioningService.WithContext(ctx).Retry(ctx.Param("id"))
+	if err != nil {
+		c.handleError(ctx, err, "Failed to retry provisioning jobs")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"retried": retried})
+}
+
+// Reconcile compares a target with the local state now. With ?dryRun=true it
+// only reports the differences.
+func (c *ProvisioningController) Reconcile(ctx *gin.Context) {
+	dryRun := ctx.Query("dryRun") == "true"
+	run, err := c.provisioningService.WithContext(ctx).Reconcile(ctx.Param("id"), dryRun, TriggerManual, middleware.GetUserID(ctx))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTargetNotFound):
+			ctx.JSON(http.StatusNotFound, gin.H{"error": "Provisioning target not found"})
+		case errors.Is(err, ErrReconcileInProgress):
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case run != nil:
+			c.logger.Errorf("Provisioning reconciliation failed: %v", err)
+			ctx.JSON(http.StatusBadGateway, run)
+		default:
+			c.logger.Errorf("Provisioning reconciliation failed: %v", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile provisioning target"})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, run)
+}
+
+func (c *ProvisioningController) GetReconcileRuns(ctx *gin.Context) {
+	runs, err := c.provisioningService.WithContext(ctx).GetReconcileRuns(ctx.Param("id"))
+	if err != nil {
+		c.handleError(ctx, err, "Failed to get reconcile runs")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, runs)
+}
+
+func (c *ProvisioningController) GetReconcileRun(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("runId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reconcile run ID"})
+		return
+	}
+
+	run, err := c.provisioningService.WithContext(ctx).GetReconcileRun(ctx.Param("id"), id)
+	if err != nil {
+		c.logger.Errorf("Failed to get reconcile run: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get reconcile run"})
+		return
+	}
+	if run == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Reconcile run not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, run)
+}
+
+func (c *ProvisioningController) handleError(ctx *gin.Context, err error, message string) {
+	if errors.Is(err, ErrTargetNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Provisioning target not found"})
+		return
+	}
+	c.logger.Errorf("%s: %v", message, err)
+	ctx.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
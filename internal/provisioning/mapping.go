@@ -0,0 +1,272 @@
+package provisioning
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"idmapp-go/internal/group"
+	"idmapp-go/internal/user"
+)
+
+// Schema URNs of pushed resources
+const (
+	schemaUser    = "urn:ietf:params:scim:schemas:core:2.0:User"
+	schemaGroup   = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	schemaPatchOp = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+)
+
+// Sources are the local user fields a mapping can read. active is true for
+// active users; externalId is the ID a SCIM client assigned locally.
+const (
+	SourceID         = "id"
+	SourceEmail      = "email"
+	SourceName       = "name"
+	SourceFirstName  = "firstName"
+	SourceLastName   = "lastName"
+	SourceActive     = "active"
+	SourceExternalID = "externalId"
+)
+
+var validSources = map[string]bool{
+	SourceID: true, SourceEmail: true, SourceName: true, SourceFirstName: true,
+	SourceLastName: true, SourceActive: true, SourceExternalID: true,
+}
+
+// DefaultMapping is used for targets without a mapping. The local user ID
+// becomes the remote externalId.
+var DefaultMapping = map[string]string{
+	"userName":                     SourceEmail,
+	"externalId":                   SourceID,
+	"displayName":                  SourceName,
+	"name.givenName":               SourceFirstName,
+	"name.familyName":              SourceLastName,
+	`emails[type eq "work"].value`: SourceEmail,
+	"active":                       SourceActive,
+}
+
+// attributePath is a mapped SCIM path: attr, attr.sub or
+// attr[type eq "<type>"].sub for an element of a multi-valued attribute
+type attributePath struct {
+	attr     string
+	sub      string
+	elemType string
+}
+
+var typedPath = regexp.MustCompile(`^(\w+)\[type eq "([^"]*)"\]\.(\w+)$`)
+
+func parseAttributePath(path string) (attributePath, error) {
+	if m := typedPath.FindStringSubmatch(path); m != nil {
+		return attributePath{attr: m[1], sub: m[3], elemType: m[2]}, nil
+	}
+	attr, sub, _ := strings.Cut(path, ".")
+	if attr == "" || strings.ContainsAny(path, `[]" `) || strings.Contains(sub, ".") {
+		return attributePath{}, fmt.Errorf("unsupported attribute path %q", path)
+	}
+	return attributePath{attr: attr, sub: sub}, nil
+}
+
+func userValues(u *user.User) map[string]interface{} {
+	return map[string]interface{}{
+		SourceID:         u.ID.String(),
+		SourceEmail:      u.Email,
+		SourceName:       u.Name,
+		SourceFirstName:  u.FirstName,
+		SourceLastName:   u.LastName,
+		SourceActive:     u.Status == user.StatusActive,
+		SourceExternalID: u.ExternalID,
+	}
+}
+
+// buildUser renders the remote representation of a local user. Empty
+// values are left out.
+func buildUser(u *user.User, mapping map[string]string) map[string]interface{} {
+	values := userValues(u)
+	resource := map[string]interface{}{"schemas": []interface{}{schemaUser}}
+	for _, path := range sortedKeys(mapping) {
+		value := values[mapping[path]]
+		if value == "" {
+			continue
+		}
+		parsed, _ := parseAttributePath(path)
+		setAttribute(resource, parsed, value)
+	}
+	return resource
+}
+
+// buildGroup renders the remote representation of a local group whose
+// members have the given remote user IDs
+func buildGroup(g *group.Group, remoteMembers []string) map[string]interface{} {
+	members := make([]interface{}, 0, len(remoteMembers))
+	sorted := append([]string(nil), remoteMembers...)
+	sort.Strings(sorted)
+	for _, id := range sorted {
+		members = append(members, map[string]interface{}{"value": id})
+	}
+	return map[string]interface{}{
+		"schemas":     []interface{}{schemaGroup},
+		"displayName": g.Name,
+		"externalId":  g.ID.String(),
+		"members":     members,
+	}
+}
+
+func setAttribute(resource map[string]interface{}, path attributePath, value interface{}) {
+	if path.elemType != "" {
+		elements, _ := resource[path.attr].([]interface{})
+		for _, element := range elements {
+			object := element.(map[string]interface{})
+			if object["type"] == path.elemType {
+				object[path.sub] = value
+				return
+			}
+		}
+		element := map[string]interface{}{"type": path.elemType, path.sub: value}
+		if len(elements) == 0 {
+			element["primary"] = true
+		}
+		resource[path.attr] = append(elements, element)
+		return
+	}
+	if path.sub == "" {
+		resource[path.attr] = value
+		return
+	}
+	object, _ := resource[path.attr].(map[string]interface{})
+	if object == nil {
+		object = make(map[string]interface{})
+		resource[path.attr] = object
+	}
+	object[path.sub] = value
+}
+
+// getAttribute reads a mapped path from a resource. Attribute names are
+// matched case-insensitively. For typed paths it falls back to the primary
+// element, as servers do not always echo the type.
+func getAttribute(resource map[string]interface{}, path attributePath) interface{} {
+	value := lookup(resource, path.attr)
+	if path.elemType != "" {
+		elements, _ := value.([]interface{})
+		var primary map[string]interface{}
+		for _, element := range elements {
+			object, ok := element.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if elemType, _ := lookup(object, "type").(string); strings.EqualFold(elemType, path.elemType) {
+				return lookup(object, path.sub)
+			}
+			if isPrimary, _ := lookup(object, "primary").(bool); isPrimary {
+				primary = object
+			}
+		}
+		if primary != nil {
+			return lookup(primary, path.sub)
+		}
+		return nil
+	}
+	if path.sub == "" {
+		return value
+	}
+	object, _ := value.(map[string]interface{})
+	return lookup(object, path.sub)
+}
+
+func lookup(object map[string]interface{}, name string) interface{} {
+	for key, value := range object {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return nil
+}
+
+// diffUser lists the mapped paths whose remote values differ from the
+// desired ones
+func diffUser(desired, remote map[string]interface{}, mapping map[string]string) []string {
+	var fields []string
+	for _, path := range sortedKeys(mapping) {
+		parsed, _ := parseAttributePath(path)
+		if normalize(getAttribute(desired, parsed)) != normalize(getAttribute(remote, parsed)) {
+			fields = append(fields, path)
+		}
+	}
+	return fields
+}
+
+// diffGroup lists the differing group attributes
+func diffGroup(desired, remote map[string]interface{}) []string {
+	var fields []string
+	if normalize(lookup(desired, "displayName")) != normalize(lookup(remote, "displayName")) {
+		fields = append(fields, "displayName")
+	}
+	if !sameMembers(memberValues(desired), memberValues(remote)) {
+		fields = append(fields, "members")
+	}
+	return fields
+}
+
+func memberValues(resource map[string]interface{}) []string {
+	members, _ := lookup(resource, "members").([]interface{})
+	values := make([]string, 0, len(members))
+	for _, m := range members {
+		if object, ok := m.(map[string]interface{}); ok {
+			if value, ok := lookup(object, "value").(string); ok {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]bool, len(a))
+	for _, v := range a {
+		set[v] = true
+	}
+	for _, v := range b {
+		if !set[v] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalize makes values comparable: nil equals "", and booleans sent as
+// strings equal real ones
+func normalize(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		if strings.EqualFold(v, "true") || strings.EqualFold(v, "false") {
+			return strings.ToLower(v)
+		}
+		return v
+	}
+	return fmt.Sprint(value)
+}
+
+// hashResource fingerprints a pushed resource so unchanged resources are
+// not pushed again
+func hashResource(resource map[string]interface{}) string {
+	data, _ := json.Marshal(resource)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func sortedKeys(mapping map[string]string) []string {
+	keys := make([]string, 0, len(mapping))
+	for key := range mapping {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
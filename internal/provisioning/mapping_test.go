@@ -0,0 +1,132 @@
+package provisioning
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"idmapp-go/internal/group"
+	"idmapp-go/internal/user"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testUser(email string, status user.Status) user.User {
+	return user.User{
+		ID:        uuid.New(),
+		Email:     email,
+		Name:      "Jane Doe",
+		FirstName: "Jane",
+		Status:    status,
+	}
+}
+
+func TestBuildUser(t *testing.T) {
+	u := testUser("jane@example.org", user.StatusActive)
+	resource := buildUser(&u, DefaultMapping)
+
+	assert.Equal(t, map[string]interface{}{
+		"schemas":     []interface{}{schemaUser},
+		"userName":    "jane@example.org",
+		"externalId":  u.ID.String(),
+		"displayName": "Jane Doe",
+		// An empty last name is left out
+		"name": map[string]interface{}{"givenName": "Jane"},
+		"emails": []interface{}{
+			map[string]interface{}{"type": "work", "value": "jane@example.org", "primary": true},
+		},
+		"active": true,
+	}, resource)
+
+	u.Status = user.StatusSuspended
+	assert.Equal(t, false, buildUser(&u, DefaultMapping)["active"])
+}
+
+func TestDiffUser(t *testing.T) {
+	u := testUser("jane@example.org", user.StatusActive)
+	desired := buildUser(&u, DefaultMapping)
+
+	remote := map[string]interface{}{
+		"id":          "r1",
+		"UserName":    "jane@example.org",
+		"externalId":  u.ID.String(),
+		"displayName": "Jane Doe",
+		"name":        map[string]interface{}{"givenName": "Jane", "familyName": ""},
+		// Servers may drop the type; the primary email is compared instead
+		"emails": []interface{}{map[string]interface{}{"value": "jane@example.org", "primary": true}},
+		"active": "True",
+		"groups": []interface{}{},
+	}
+	assert.Empty(t, diffUser(desired, remote, DefaultMapping))
+
+	remote["displayName"] = "Jane"
+	remote["active"] = false
+	delete(remote, "emails")
+	assert.Equal(t, []string{"active", "displayName", `emails[type eq "work"].value`}, diffUser(desired, remote, DefaultMapping))
+}
+
+func TestGroupDiff(t *testing.T) {
+	g := group.Group{ID: uuid.New(), Name: "admins"}
+	desired := buildGroup(&g, []string{"r2", "r1"})
+	assert.Equal(t, []string{"r1", "r2"}, memberValues(desired))
+
+	remote := map[string]interface{}{
+		"displayName": "admins",
+		"members":     []interface{}{map[string]interface{}{"value": "r2", "display": "John"}, map[string]interface{}{"value": "r1"}},
+	}
+	assert.Empty(t, diffGroup(desired, remote))
+
+	remote["members"] = []interface{}{map[string]interface{}{"value": "r1"}}
+	assert.Equal(t, []string{"members"}, diffGroup(desired, remote))
+}
+
+func TestHashResource(t *testing.T) {
+	u := testUser("jane@example.org", user.StatusActive)
+	hash := hashResource(buildUser(&u, DefaultMapping))
+	assert.Equal(t, hash, hashResource(buildUser(&u, DefaultMapping)))
+
+	u.LastName = "Doe"
+	assert.NotEqual(t, hash, hashResource(buildUser(&u, DefaultMapping)))
+}
+
+func TestLoadTargets(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "targets.json")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	t.Setenv("TEST_SCIM_TOKEN", "secret")
+	targets, err := LoadTargets(write(t, `[
+		{"id": "slack", "url": "https://api.slack.com/scim/v2/", "token": "${TEST_SCIM_TOKEN}", "groups": true},
+		{"id": "wiki", "url": "https://wiki.example.com/scim", "timeout": "5s",
+		 "mapping": {"userName": "email", "name.familyName": "lastName", "emails[type eq \"home\"].value": "email"}}
+	]`))
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	assert.Equal(t, "https://api.slack.com/scim/v2", targets[0].URL)
+	assert.Equal(t, "secret", targets[0].Token)
+	assert.Equal(t, "slack", targets[0].Name)
+	assert.Equal(t, DefaultMapping, targets[0].Mapping)
+	assert.Equal(t, "5s", targets[1].timeout.String())
+
+	targets, err = LoadTargets("")
+	require.NoError(t, err)
+	assert.Nil(t, targets)
+
+	for name, content := range map[string]string{
+		"missing url":     `[{"id": "a"}]`,
+		"duplicate":       `[{"id": "a", "url": "https://a"}, {"id": "a", "url": "https://b"}]`,
+		"unknown source":  `[{"id": "a", "url": "https://a", "mapping": {"userName": "email", "title": "jobTitle"}}]`,
+		"bad path":        `[{"id": "a", "url": "https://a", "mapping": {"userName": "email", "emails[primary eq true].value": "email"}}]`,
+		"no userName":     `[{"id": "a", "url": "https://a", "mapping": {"displayName": "name"}}]`,
+		"invalid timeout": `[{"id": "a", "url": "https://a", "timeout": "soon"}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadTargets(write(t, content))
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package provisioning
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Kinds of pushed resources
+const (
+	KindUser  = "user"
+	KindGroup = "group"
+)
+
+// Link ties a local user or group to the resource it was pushed as on a
+// target
+type Link struct {
+	ID       uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TargetID string    `gorm:"not null;uniqueIndex:idx_provisioning_links_local;column:target_id"`
+	Kind     string    `gorm:"type:varchar(16);not null;uniqueIndex:idx_provisioning_links_local"`
+	LocalID  uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_provisioning_links_local;column:local_id"`
+	RemoteID string    `gorm:"not null;column:remote_id"`
+	// Hash fingerprints the resource last pushed, so that unchanged
+	// resources are skipped
+	Hash      string
+	SyncedAt  time.Time
+	CreatedAt time.Time
+}
+
+func (l *Link) BeforeCreate(tx *gorm.DB) error {
+	if l.ID == uuid.Nil {
+		l.ID = uuid.New()
+	}
+	return nil
+}
+
+func (l *Link) TableName() string {
+	return "provisioning_links"
+}
+
+// Job states. Delivered jobs are deleted.
+const (
+	JobStatePending    = "pending"
+	JobStateProcessing = "processing"
+	JobStateFailed     = "failed"
+)
+
+// Job is a queued delivery of a user or group to a target. Jobs carry no
+// payload: delivery pushes the current local state, so at most one pending
+// job per resource is kept.
+type Job struct {
+	ID       uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TargetID string    `gorm:"not null;uniqueIndex:idx_provisioning_jobs_pending,where:state = 'pending';column:target_id"`
+	Kind     string    `gorm:"type:varchar(16);not null;uniqueIndex:idx_provisioning_jobs_pending,where:state = 'pending'"`
+	LocalID  uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_provisioning_jobs_pending,where:state = 'pending';column:local_id"`
+	// Force pushes the resource even if it is unchanged since the last push
+	Force    bool
+	State    string `gorm:"type:varchar(16);not null;index"`
+	Attempts int    `gorm:"not null;default:0"`
+	// NextAttemptAt is when a pending job is due, or when the lease of a
+	// processing job expires
+	NextAttemptAt time.Time `gorm:"not null;index"`
+	LastError     string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (j *Job) BeforeCreate(tx *gorm.DB) error {
+	if j.ID == uuid.Nil {
+		j.ID = uuid.New()
+	}
+	return nil
+}
+
+func (j *Job) TableName() string {
+	return "provisioning_jobs"
+}
+
+// Reconciliation run states
+const (
+	RunStateCompleted = "completed"
+	RunStatePartial   = "partial"
+	RunStateFailed    = "failed"
+)
+
+// ReconcileRun records a comparison of a target's remote state with the
+// local state and the corrections it queued
+type ReconcileRun struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	TargetID   string    `gorm:"not null;index;column:target_id"`
+	DryRun     bool      `gorm:"not null"`
+	Trigger    string    `gorm:"type:varchar(16);not null"`
+	Actor      string
+	State      string `gorm:"type:varchar(16);not null"`
+	Error      string
+	Changes    string    `gorm:"type:text"`
+	StartedAt  time.Time `gorm:"not null;index"`
+	FinishedAt time.Time
+}
+
+func (r *ReconcileRun) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (r *ReconcileRun) TableName() string {
+	return "provisioning_reconcile_runs"
+}
@@ -0,0 +1,260 @@
+package provisioning
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"idmapp-go/internal/group"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/user"
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Remote endpoints
+const (
+	endpointUsers  = "Users"
+	endpointGroups = "Groups"
+)
+
+// pushUser brings the remote user in line with the local one. Users are
+// created remotely once they are active; deleted and deprovisioned users are
+// deleted or deactivated depending on the target.
+func (s *ProvisioningService) pushUser(target *Target, id uuid.UUID, force bool) error {
+	client := s.clients[target.ID]
+	link, err := s.getLink(target.ID, KindUser, id)
+	if err != nil {
+		return err
+	}
+
+	var u user.User
+	exists := true
+	if err := s.db.First(&u, "id = ?", id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		exists = false
+	}
+
+	if !exists || u.Status == user.StatusDeprovisioned {
+		if link == nil {
+			return nil
+		}
+		if target.DeleteUsers || !exists {
+			if target.DeleteUsers {
+				err = client.Delete(endpointUsers, link.RemoteID)
+			} else {
+				err = client.Deactivate(endpointUsers, link.RemoteID)
+			}
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+			if err := s.db.Delete(link).Error; err != nil {
+				return fmt.Errorf("failed to delete provisioning link: %w", err)
+			}
+		} else {
+			if err := client.Deactivate(endpointUsers, link.RemoteID); err != nil && !isNotFound(err) {
+				return err
+			}
+			if err := s.saveLink(link, link.RemoteID, ""); err != nil {
+				return err
+			}
+		}
+		// Memberships of the user are gone, so its groups need a push too
+		return s.enqueueLinkedGroups(target)
+	}
+
+	desired := buildUser(&u, target.Mapping)
+	hash := hashResource(desired)
+	if link != nil && !force && link.Hash == hash {
+		return nil
+	}
+	if link == nil && u.Status != user.StatusActive {
+		return nil
+	}
+
+	remoteID := ""
+	if link != nil {
+		remoteID = link.RemoteID
+		if _, err := client.Replace(endpointUsers, remoteID, desired); err != nil {
+			if !isNotFound(err) {
+				return err
+			}
+			remoteID = ""
+		}
+	}
+	if remoteID == "" {
+		userName, _ := desired["userName"].(string)
+		if remoteID, err = createOrAdopt(client, endpointUsers, "userName", userName, desired); err != nil {
+			return err
+		}
+	}
+
+	created := link == nil
+	if link == nil {
+		link = &Link{TargetID: target.ID, Kind: KindUser, LocalID: id}
+	}
+	if err := s.saveLink(link, remoteID, hash); err != nil {
+		return err
+	}
+	if created && target.Groups {
+		// Groups leave out members that aren't pushed yet
+		return s.enqueueGroupsOf(target, id)
+	}
+	return nil
+}
+
+// pushGroup brings the remote group in line with the local one. Members are
+// the pushed users of the group.
+func (s *ProvisioningService) pushGroup(target *Target, id uuid.UUID, force bool) error {
+	client := s.clients[target.ID]
+	link, err := s.getLink(target.ID, KindGroup, id)
+	if err != nil {
+		return err
+	}
+
+	var g group.Group
+	if err := s.db.First(&g, "id = ?", id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("failed to get group: %w", err)
+		}
+		if link == nil {
+			return nil
+		}
+		if err := client.Delete(endpointGroups, link.RemoteID); err != nil {
+			return err
+		}
+		if err := s.db.Delete(link).Error; err != nil {
+			return fmt.Errorf("failed to delete provisioning link: %w", err)
+		}
+		return nil
+	}
+
+	var remoteMembers []string
+	if err := s.db.Model(&Link{}).
+		Joins("JOIN members ON members.user_id = provisioning_links.local_id").
+		Where("provisioning_links.target_id = ? AND provisioning_links.kind = ? AND members.group_id = ?", target.ID, KindUser, id).
+		Where(models.InEffectSQL("members")).
+		Pluck("provisioning_links.remote_id", &remoteMembers).Error; err != nil {
+		return fmt.Errorf("failed to get group members: %w", err)
+	}
+
+	desired := buildGroup(&g, remoteMembers)
+	hash := hashResource(desired)
+	if link != nil && !force && link.Hash == hash {
+		return nil
+	}
+
+	remoteID := ""
+	if link != nil {
+		remoteID = link.RemoteID
+		if _, err := client.Replace(endpointGroups, remoteID, desired); err != nil {
+			if !isNotFound(err) {
+				return err
+			}
+			remoteID = ""
+		}
+	}
+	if remoteID == "" {
+		if remoteID, err = createOrAdopt(client, endpointGroups, "displayName", g.Name, desired); err != nil {
+			return err
+		}
+	}
+
+	if link == nil {
+		link = &Link{TargetID: target.ID, Kind: KindGroup, LocalID: id}
+	}
+	return s.saveLink(link, remoteID, hash)
+}
+
+// createOrAdopt creates a remote resource, or replaces an existing one with
+// the same unique attribute so that resources created before provisioning
+// was enabled are taken over rather than duplicated
+func createOrAdopt(client *Client, endpoint, attribute, value string, desired map[string]interface{}) (string, error) {
+	existing, err := client.Find(endpoint, attribute, value)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		id := resourceID(existing)
+		if _, err := client.Replace(endpoint, id, desired); err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+
+	created, err := client.Create(endpoint, desired)
+	if err != nil {
+		return "", err
+	}
+	id := resourceID(created)
+	if id == "" {
+		return "", errors.New("target returned a resource without id")
+	}
+	return id, nil
+}
+
+func (s *ProvisioningService) getLink(targetID, kind string, localID uuid.UUID) (*Link, error) {
+	var link Link
+	if err := s.db.Where("target_id = ? AND kind = ? AND local_id = ?", targetID, kind, localID).First(&link).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get provisioning link: %w", err)
+	}
+	return &link, nil
+}
+
+func (s *ProvisioningService) saveLink(link *Link, remoteID, hash string) error {
+	now := time.Now()
+	link.RemoteID = remoteID
+	link.Hash = hash
+	link.SyncedAt = now
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = now
+	}
+	if err := s.db.Save(link).Error; err != nil {
+		return fmt.Errorf("failed to save provisioning link: %w", err)
+	}
+	return nil
+}
+
+// enqueueGroupsOf queues the groups a user is a member of, nested ones
+// included
+func (s *ProvisioningService) enqueueGroupsOf(target *Target, userID uuid.UUID) error {
+	var groupIDs []uuid.UUID
+	err := s.db.Raw(`WITH RECURSIVE `+member.UserGroupsSQL+`
+		SELECT DISTINCT group_id FROM user_groups`,
+		userID, member.MaxNestingDepth).
+		Find(&groupIDs).Error
+	if err != nil {
+		return fmt.Errorf("failed to get user groups: %w", err)
+	}
+	for _, groupID := range groupIDs {
+		if err := s.Enqueue(target.ID, KindGroup, groupID, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// enqueueLinkedGroups queues every group pushed to a target. Unchanged
+// groups are skipped on delivery.
+func (s *ProvisioningService) enqueueLinkedGroups(target *Target) error {
+	if !target.Groups {
+		return nil
+	}
+	var groupIDs []uuid.UUID
+	if err := s.db.Model(&Link{}).Where("target_id = ? AND kind = ?", target.ID, KindGroup).Pluck("local_id", &groupIDs).Error; err != nil {
+		return fmt.Errorf("failed to get provisioning links: %w", err)
+	}
+	for _, groupID := range groupIDs {
+		if err := s.Enqueue(target.ID, KindGroup, groupID, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
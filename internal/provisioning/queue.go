@@ -0,0 +1,198 @@
+package provisioning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// claimBatchSize caps how many jobs one worker run delivers
+	claimBatchSize = 50
+	// claimLease is how long a claimed job stays with its worker before
+	// another instance may pick it up again
+	claimLease = 5 * time.Minute
+	// maxAttempts is how often a delivery is tried before the job fails
+	maxAttempts = 8
+	// retryBaseDelay doubles with every failed attempt up to retryMaxDelay
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = time.Hour
+)
+
+var errUnknownTarget = errors.New("target is no longer configured")
+
+// retryDelay is the backoff after the given number of failed attempts
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
+
+// Enqueue queues a delivery of a user or group to a target. A pending job
+// for the same resource is reused, so bursts of changes are pushed once.
+func (s *ProvisioningService) Enqueue(targetID, kind string, localID uuid.UUID, force bool) error {
+	now := time.Now()
+	err := s.db.Exec(`INSERT INTO provisioning_jobs
+		(id, target_id, kind, local_id, force, state, attempts, next_attempt_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?, ?)
+		ON CONFLICT (target_id, kind, local_id) WHERE state = 'pending'
+		DO UPDATE SET force = provisioning_jobs.force OR EXCLUDED.force, updated_at = EXCLUDED.updated_at`,
+		uuid.New(), targetID, kind, localID, force, JobStatePending, now, now, now).Error
+	if err != nil {
+		return fmt.Errorf("failed to enqueue provisioning job: %w", err)
+	}
+	return nil
+}
+
+// ProcessDueJobs delivers the jobs that are due. Jobs are claimed with SKIP
+// LOCKED and leased, so several instances can run it and jobs of a crashed
+// worker are picked up again once their lease expires.
+func (s *ProvisioningService) ProcessDueJobs() (int, error) {
+	var jobs []Job
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("state IN ? AND next_attempt_at <= ?", []string{JobStatePending, JobStateProcessing}, now).
+			Order("next_attempt_at").
+			Limit(claimBatchSize).
+			Find(&jobs).Error; err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, 0, len(jobs))
+		for _, job := range jobs {
+			ids = append(ids, job.ID)
+		}
+		return tx.Model(&Job{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"state":           JobStateProcessing,
+			"next_attempt_at": now.Add(claimLease),
+			"updated_at":      now,
+		}).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim provisioning jobs: %w", err)
+	}
+
+	for i := range jobs {
+		s.deliver(&jobs[i])
+	}
+	return len(jobs), nil
+}
+
+func (s *ProvisioningService) deliver(job *Job) {
+	var err error
+	target, ok := s.targets[job.TargetID]
+	if !ok {
+		err = errUnknownTarget
+	} else if job.Kind == KindGroup {
+		err = s.pushGroup(target, job.LocalID, job.Force)
+	} else {
+		err = s.pushUser(target, job.LocalID, job.Force)
+	}
+
+	if err == nil {
+		// The resource is in sync now, which settles earlier failures too
+		if err := s.db.Where("(id = ? OR state = ?) AND target_id = ? AND kind = ? AND local_id = ?",
+			job.ID, JobStateFailed, job.TargetID, job.Kind, job.LocalID).Delete(&Job{}).Error; err != nil {
+			s.logger.Errorf("Failed to delete provisioning job %s: %v", job.ID, err)
+		}
+		return
+	}
+
+	job.Attempts++
+	s.logger.Warnf("Provisioning %s %s to %s failed (attempt %d): %v", job.Kind, job.LocalID, job.TargetID, job.Attempts, err)
+	if err := s.reschedule(job, err); err != nil {
+		s.logger.Errorf("Failed to reschedule provisioning job %s: %v", job.ID, err)
+	}
+}
+
+// reschedule retries a failed job after a backoff, or fails it when the error
+// is permanent or the attempts are used up. A newer pending job for the same
+// resource takes over the retry.
+func (s *ProvisioningService) reschedule(job *Job, cause error) error {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"attempts":   job.Attempts,
+		"last_error": cause.Error(),
+		"updated_at": now,
+	}
+	if !retryable(cause) || errors.Is(cause, errUnknownTarget) || job.Attempts >= maxAttempts {
+		updates["state"] = JobStateFailed
+		return s.db.Model(job).Updates(updates).Error
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Job{}).
+			Where("target_id = ? AND kind = ? AND local_id = ? AND state = ?", job.TargetID, job.Kind, job.LocalID, JobStatePending).
+			Update("force", gorm.Expr("force OR ?", job.Force))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			return tx.Delete(job).Error
+		}
+		updates["state"] = JobStatePending
+		updates["next_attempt_at"] = now.Add(retryDelay(job.Attempts))
+		return tx.Model(job).Updates(updates).Error
+	})
+}
+
+// Retry queues the resources of a target's failed jobs again
+func (s *ProvisioningService) Retry(targetID string) (int, error) {
+	if _, ok := s.targets[targetID]; !ok {
+		return 0, ErrTargetNotFound
+	}
+
+	var failed []Job
+	if err := s.db.Where("target_id = ? AND state = ?", targetID, JobStateFailed).Find(&failed).Error; err != nil {
+		return 0, fmt.Errorf("failed to get failed provisioning jobs: %w", err)
+	}
+	for _, job := range failed {
+		if err := s.Enqueue(job.TargetID, job.Kind, job.LocalID, true); err != nil {
+			return 0, err
+		}
+	}
+	if len(failed) > 0 {
+		if err := s.db.Where("target_id = ? AND state = ?", targetID, JobStateFailed).Delete(&Job{}).Error; err != nil {
+			return 0, fmt.Errorf("failed to delete failed provisioning jobs: %w", err)
+		}
+	}
+	return len(failed), nil
+}
+
+// RunDeliveryWorker delivers due jobs every interval until ctx is done
+func (s *ProvisioningService) RunDeliveryWorker(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				delivered, err := s.ProcessDueJobs()
+				if err != nil {
+					s.logger.Errorf("Provisioning delivery failed: %v", err)
+				}
+				// Keep draining while full batches come back
+				if err != nil || delivered < claimBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package provisioning
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"idmapp-go/internal/group"
+	"idmapp-go/internal/user"
+
+	"github.com/google/uuid"
+)
+
+// Change actions
+const (
+	ActionCreate     = "create"
+	ActionLink       = "link"
+	ActionUpdate     = "update"
+	ActionDeactivate = "deactivate"
+	ActionDelete     = "delete"
+	// ActionOrphan reports a remote resource with no local counterpart. It
+	// is left alone, as the target may have users of its own.
+	ActionOrphan = "orphan"
+)
+
+// Change is a difference between a target and the local state. Every change
+// but orphan is corrected by queueing a forced delivery.
+type Change struct {
+	Action   string   `json:"action"`
+	Kind     string   `json:"kind"`
+	Name     string   `json:"name"`
+	LocalID  string   `json:"localId,omitempty"`
+	RemoteID string   `json:"remoteId,omitempty"`
+	Fields   []string `json:"fields,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// state is the local side of a reconciliation
+type state struct {
+	users      []user.User
+	groups     []group.Group
+	members    map[uuid.UUID][]uuid.UUID
+	userLinks  map[uuid.UUID]*Link
+	groupLinks map[uuid.UUID]*Link
+}
+
+// remoteIndex looks up remote resources by id and by their lowercase unique
+// attribute, and tracks which ones are accounted for
+type remoteIndex struct {
+	byID      map[string]map[string]interface{}
+	byName    map[string]map[string]interface{}
+	claimed   map[string]bool
+	attribute string
+}
+
+func newRemoteIndex(resources []map[string]interface{}, attribute string) *remoteIndex {
+	index := &remoteIndex{
+		byID:      make(map[string]map[string]interface{}),
+		byName:    make(map[string]map[string]interface{}),
+		claimed:   make(map[string]bool),
+		attribute: attribute,
+	}
+	for _, resource := range resources {
+		index.byID[resourceID(resource)] = resource
+		if name, ok := lookup(resource, attribute).(string); ok {
+			index.byName[strings.ToLower(name)] = resource
+		}
+	}
+	return index
+}
+
+// match returns the unclaimed remote resource named name
+func (x *remoteIndex) match(name string) map[string]interface{} {
+	resource := x.byName[strings.ToLower(name)]
+	if resource == nil || x.claimed[resourceID(resource)] {
+		return nil
+	}
+	return resource
+}
+
+// orphans lists the unclaimed remote resources
+func (x *remoteIndex) orphans(kind string) []Change {
+	var changes []Change
+	for id, resource := range x.byID {
+		if x.claimed[id] {
+			continue
+		}
+		name, _ := lookup(resource, x.attribute).(string)
+		changes = append(changes, Change{Action: ActionOrphan, Kind: kind, Name: name, RemoteID: id})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// planUsers compares the remote users of a target with the local ones
+func planUsers(target *Target, st *state, remote []map[string]interface{}) []Change {
+	index := newRemoteIndex(remote, "userName")
+	for _, link := range st.userLinks {
+		if _, ok := index.byID[link.RemoteID]; ok {
+			index.claimed[link.RemoteID] = true
+		}
+	}
+
+	var changes []Change
+	local := make(map[uuid.UUID]bool, len(st.users))
+	users := append([]user.User(nil), st.users...)
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+	for i := range users {
+		u := &users[i]
+		local[u.ID] = true
+		desired := buildUser(u, target.Mapping)
+		name, _ := desired["userName"].(string)
+		change := Change{Kind: KindUser, Name: name, LocalID: u.ID.String()}
+
+		link := st.userLinks[u.ID]
+		var existing map[string]interface{}
+		if link != nil {
+			change.RemoteID = link.RemoteID
+			existing = index.byID[link.RemoteID]
+		}
+		switch {
+		case u.Status == user.StatusDeprovisioned:
+			if existing == nil {
+				continue
+			}
+			if target.DeleteUsers {
+				change.Action = ActionDelete
+			} else if normalize(lookup(existing, "active")) != "false" {
+				change.Action = ActionDeactivate
+			} else {
+				continue
+			}
+		case existing != nil:
+			change.Fields = diffUser(desired, existing, target.Mapping)
+			if len(change.Fields) == 0 {
+				continue
+			}
+			change.Action = ActionUpdate
+		default:
+			if match := index.match(name); match != nil {
+				change.Action = ActionLink
+				change.RemoteID = resourceID(match)
+				change.Fields = diffUser(desired, match, target.Mapping)
+				index.claimed[change.RemoteID] = true
+			} else if link != nil || u.Status == user.StatusActive {
+				// Linked users missing remotely are created again
+				change.Action = ActionCreate
+				change.RemoteID = ""
+			} else {
+				continue
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	// Remote users of deleted local users
+	for _, id := range sortedLinkIDs(st.userLinks) {
+		link := st.userLinks[id]
+		if local[id] {
+			continue
+		}
+		existing := index.byID[link.RemoteID]
+		if existing == nil {
+			continue
+		}
+		name, _ := lookup(existing, "userName").(string)
+		change := Change{Action: ActionDelete, Kind: KindUser, Name: name, LocalID: id.String(), RemoteID: link.RemoteID}
+		if !target.DeleteUsers {
+			if normalize(lookup(existing, "active")) == "false" {
+				continue
+			}
+			change.Action = ActionDeactivate
+		}
+		changes = append(changes, change)
+	}
+
+	return append(changes, index.orphans(KindUser)...)
+}
+
+// planGroups compares the remote groups of a target with the local ones.
+// Members are compared as remote user IDs, counting only pushed users.
+func planGroups(st *state, remote []map[string]interface{}) []Change {
+	index := newRemoteIndex(remote, "displayName")
+	for _, link := range st.groupLinks {
+		if _, ok := index.byID[link.RemoteID]; ok {
+			index.claimed[link.RemoteID] = true
+		}
+	}
+
+	var changes []Change
+	local := make(map[uuid.UUID]bool, len(st.groups))
+	groups := append([]group.Group(nil), st.groups...)
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	for i := range groups {
+		g := &groups[i]
+		local[g.ID] = true
+		var remoteMembers []string
+		for _, userID := range st.members[g.ID] {
+			if link, ok := st.userLinks[userID]; ok {
+				remoteMembers = append(remoteMembers, link.RemoteID)
+			}
+		}
+		desired := buildGroup(g, remoteMembers)
+		change := Change{Kind: KindGroup, Name: g.Name, LocalID: g.ID.String()}
+
+		link := st.groupLinks[g.ID]
+		var existing map[string]interface{}
+		if link != nil {
+			change.RemoteID = link.RemoteID
+			existing = index.byID[link.RemoteID]
+		}
+		if existing != nil {
+			change.Fields = diffGroup(desired, existing)
+			if len(change.Fields) == 0 {
+				continue
+			}
+			change.Action = ActionUpdate
+		} else if match := index.match(g.Name); match != nil {
+			change.Action = ActionLink
+			change.RemoteID = resourceID(match)
+			change.Fields = diffGroup(desired, match)
+			index.claimed[change.RemoteID] = true
+		} else {
+			change.Action = ActionCreate
+			change.RemoteID = ""
+		}
+		changes = append(changes, change)
+	}
+
+	for _, id := range sortedLinkIDs(st.groupLinks) {
+		link := st.groupLinks[id]
+		if local[id] {
+			continue
+		}
+		if existing := index.byID[link.RemoteID]; existing != nil {
+			name, _ := lookup(existing, "displayName").(string)
+			changes = append(changes, Change{Action: ActionDelete, Kind: KindGroup, Name: name, LocalID: id.String(), RemoteID: link.RemoteID})
+		}
+	}
+
+	return append(changes, index.orphans(KindGroup)...)
+}
+
+func sortedLinkIDs(links map[uuid.UUID]*Link) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, len(links))
+	for id := range links {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+	return ids
+}
+
+// summarize counts changes by "<kind>.<action>", plus failed ones
+func summarize(changes []Change) map[string]int {
+	summary := make(map[string]int)
+	for _, change := range changes {
+		summary[fmt.Sprintf("%s.%s", change.Kind, change.Action)]++
+		if change.Error != "" {
+			summary["failed"]++
+		}
+	}
+	return summary
+}
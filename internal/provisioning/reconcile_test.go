@@ -0,0 +1,154 @@
+package provisioning
+
+import (
+	"testing"
+
+	"idmapp-go/internal/group"
+	"idmapp-go/internal/user"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestState() *state {
+	return &state{
+		members:    make(map[uuid.UUID][]uuid.UUID),
+		userLinks:  make(map[uuid.UUID]*Link),
+		groupLinks: make(map[uuid.UUID]*Link),
+	}
+}
+
+func remoteUser(target *Target, u *user.User, id string) map[string]interface{} {
+	resource := buildUser(u, target.Mapping)
+	resource["id"] = id
+	return resource
+}
+
+func actions(changes []Change) map[string]string {
+	result := make(map[string]string)
+	for _, change := range changes {
+		result[change.Name] = change.Action
+	}
+	return result
+}
+
+func TestPlanUsers(t *testing.T) {
+	target := &Target{ID: "app", Mapping: DefaultMapping}
+	st := newTestState()
+
+	inSync := testUser("in-sync@example.org", user.StatusActive)
+	changed := testUser("changed@example.org", user.StatusActive)
+	unlinked := testUser("unlinked@example.org", user.StatusActive)
+	missing := testUser("missing@example.org", user.StatusActive)
+	staged := testUser("staged@example.org", user.StatusStaged)
+	deprovisioned := testUser("deprovisioned@example.org", user.StatusDeprovisioned)
+	deletedID := uuid.New()
+	st.users = []user.User{inSync, changed, unlinked, missing, staged, deprovisioned}
+
+	st.userLinks[inSync.ID] = &Link{LocalID: inSync.ID, RemoteID: "r1"}
+	st.userLinks[changed.ID] = &Link{LocalID: changed.ID, RemoteID: "r2"}
+	st.userLinks[missing.ID] = &Link{LocalID: missing.ID, RemoteID: "gone"}
+	st.userLinks[deprovisioned.ID] = &Link{LocalID: deprovisioned.ID, RemoteID: "r4"}
+	st.userLinks[deletedID] = &Link{LocalID: deletedID, RemoteID: "r5"}
+
+	remoteChanged := remoteUser(target, &changed, "r2")
+	remoteChanged["displayName"] = "Someone Else"
+	// Still active remotely, as the user was deprovisioned while the
+	// target was unreachable
+	remoteDeprovisioned := remoteUser(target, &deprovisioned, "r4")
+	remoteDeprovisioned["active"] = true
+	remote := []map[string]interface{}{
+		remoteUser(target, &inSync, "r1"),
+		remoteChanged,
+		remoteUser(target, &unlinked, "r3"),
+		remoteDeprovisioned,
+		{"id": "r5", "userName": "deleted@example.org", "active": true},
+		{"id": "r6", "userName": "remote-only@example.org"},
+	}
+
+	changes := planUsers(target, st, remote)
+	assert.Equal(t, map[string]string{
+		"changed@example.org":       ActionUpdate,
+		"unlinked@example.org":      ActionLink,
+		"missing@example.org":       ActionCreate,
+		"deprovisioned@example.org": ActionDeactivate,
+		"deleted@example.org":       ActionDeactivate,
+		"remote-only@example.org":   ActionOrphan,
+	}, actions(changes))
+
+	for _, change := range changes {
+		switch change.Name {
+		case "changed@example.org":
+			assert.Equal(t, []string{"displayName"}, change.Fields)
+		case "unlinked@example.org":
+			assert.Equal(t, "r3", change.RemoteID)
+			assert.Empty(t, change.Fields)
+		case "missing@example.org":
+			assert.Empty(t, change.RemoteID)
+		case "deleted@example.org":
+			assert.Equal(t, deletedID.String(), change.LocalID)
+		}
+	}
+
+	target.DeleteUsers = true
+	changes = planUsers(target, st, remote)
+	assert.Equal(t, ActionDelete, actions(changes)["deprovisioned@example.org"])
+	assert.Equal(t, ActionDelete, actions(changes)["deleted@example.org"])
+}
+
+func TestPlanUsersAlreadyDeactivated(t *testing.T) {
+	target := &Target{ID: "app", Mapping: DefaultMapping}
+	st := newTestState()
+	deprovisioned := testUser("deprovisioned@example.org", user.StatusDeprovisioned)
+	st.users = []user.User{deprovisioned}
+	st.userLinks[deprovisioned.ID] = &Link{LocalID: deprovisioned.ID, RemoteID: "r1"}
+
+	changes := planUsers(target, st, []map[string]interface{}{{"id": "r1", "userName": "deprovisioned@example.org", "active": false}})
+	assert.Empty(t, changes)
+}
+
+func TestPlanGroups(t *testing.T) {
+	st := newTestState()
+	jane := testUser("jane@example.org", user.StatusActive)
+	john := testUser("john@example.org", user.StatusActive)
+	st.userLinks[jane.ID] = &Link{LocalID: jane.ID, RemoteID: "u1"}
+
+	admins := group.Group{ID: uuid.New(), Name: "admins"}
+	staff := group.Group{ID: uuid.New(), Name: "staff"}
+	ops := group.Group{ID: uuid.New(), Name: "ops"}
+	deletedID := uuid.New()
+	st.groups = []group.Group{admins, staff, ops}
+	// john isn't pushed yet, so he doesn't count as a member
+	st.members[admins.ID] = []uuid.UUID{jane.ID, john.ID}
+	st.members[staff.ID] = []uuid.UUID{jane.ID}
+	st.groupLinks[admins.ID] = &Link{LocalID: admins.ID, RemoteID: "g1"}
+	st.groupLinks[staff.ID] = &Link{LocalID: staff.ID, RemoteID: "g2"}
+	st.groupLinks[deletedID] = &Link{LocalID: deletedID, RemoteID: "g3"}
+
+	remote := []map[string]interface{}{
+		{"id": "g1", "displayName": "admins", "members": []interface{}{map[string]interface{}{"value": "u1"}}},
+		{"id": "g2", "displayName": "staff"},
+		{"id": "g3", "displayName": "deleted"},
+		{"id": "g4", "displayName": "OPS"},
+		{"id": "g5", "displayName": "remote-only"},
+	}
+
+	changes := planGroups(st, remote)
+	assert.Equal(t, map[string]string{
+		"staff":       ActionUpdate,
+		"ops":         ActionLink,
+		"deleted":     ActionDelete,
+		"remote-only": ActionOrphan,
+	}, actions(changes))
+	for _, change := range changes {
+		if change.Name == "staff" {
+			assert.Equal(t, []string{"members"}, change.Fields)
+		}
+		if change.Name == "ops" {
+			assert.Equal(t, "g4", change.RemoteID)
+		}
+	}
+	assert.Equal(t, map[string]int{
+		"group.update": 1, "group.link": 1, "group.delete": 1, "group.orphan": 1,
+	}, summarize(changes))
+}
@@ -0,0 +1,458 @@
+package provisioning
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Reconciliation triggers
+const (
+	TriggerScheduled = "scheduled"
+	TriggerManual    = "manual"
+)
+
+const (
+	// runListLimit caps how many runs GetReconcileRuns returns
+	runListLimit = 50
+	// jobListLimit caps how many jobs GetJobs returns
+	jobListLimit = 100
+)
+
+// reconcileActor is recorded on scheduled reconciliation runs
+const reconcileActor = "provisioning"
+
+var (
+	ErrTargetNotFound      = errors.New("provisioning target not found")
+	ErrReconcileInProgress = errors.New("a reconciliation of this target is already running")
+)
+
+type ProvisioningService struct {
+	db          *gorm.DB
+	targets     map[string]*Target
+	order       []string
+	clients     map[string]*Client
+	reconciling map[string]*sync.Mutex
+	logger      *logrus.Logger
+}
+
+// NewProvisioningService creates the service pushing to the given targets.
+// Without targets it does nothing.
+func NewProvisioningService(db *gorm.DB, targets []Target) *ProvisioningService {
+	s := &ProvisioningService{
+		db:          db,
+		targets:     make(map[string]*Target),
+		clients:     make(map[string]*Client),
+		reconciling: make(map[string]*sync.Mutex),
+		logger:      logrus.New(),
+	}
+	for i := range targets {
+		target := &targets[i]
+		s.targets[target.ID] = target
+		s.order = append(s.order, target.ID)
+		s.clients[target.ID] = NewClient(*target)
+		s.reconciling[target.ID] = &sync.Mutex{}
+	}
+	return s
+}
+
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *ProvisioningService) WithContext(ctx context.Context) *ProvisioningService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
+func (s *ProvisioningService) Enabled() bool {
+	return len(s.targets) > 0
+}
+
+// HandleUserEvent queues a push of the event's user to every target
+func (s *ProvisioningService) HandleUserEvent(event events.Event) {
+	if !isUserEvent(event.Type) {
+		return
+	}
+	s.enqueueEvent(event, KindUser, false)
+}
+
+// HandleGroupEvent queues a push of the event's group to the targets that
+// receive groups. Membership events carry the group as their subject.
+func (s *ProvisioningService) HandleGroupEvent(event events.Event) {
+	s.enqueueEvent(event, KindGroup, true)
+}
+
+func (s *ProvisioningService) enqueueEvent(event events.Event, kind string, groupsOnly bool) {
+	id, err := uuid.Parse(event.Subject)
+	if err != nil {
+		return
+	}
+	// Only the default tenant's users and groups are provisioned; deleted
+	// resources have no tenant left and are queued as before
+	owner, err := tenant.Of(s.db, kind, id)
+	if err != nil {
+		s.logger.Errorf("Failed to check tenant of %s after %s: %v", id, event.Type, err)
+		return
+	}
+	if owner != uuid.Nil && owner != tenant.DefaultID {
+		return
+	}
+	for _, targetID := range s.order {
+		if groupsOnly && !s.targets[targetID].Groups {
+			continue
+		}
+		if err := s.Enqueue(targetID, kind, id, false); err != nil {
+			s.logger.Errorf("Failed to queue %s for %s after %s: %v", id, targetID, event.Type, err)
+		}
+	}
+}
+
+// GetTargets lists the targets with their status
+func (s *ProvisioningService) GetTargets() ([]TargetResponse, error) {
+	responses := make([]TargetResponse, 0, len(s.order))
+	for _, id := range s.order {
+		response, err := s.GetTarget(id)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, *response)
+	}
+	return responses, nil
+}
+
+func (s *ProvisioningService) GetTarget(id string) (*TargetResponse, error) {
+	target, ok := s.targets[id]
+	if !ok {
+		return nil, nil
+	}
+	response := &TargetResponse{
+		ID:          target.ID,
+		Name:        target.Name,
+		URL:         target.URL,
+		Groups:      target.Groups,
+		DeleteUsers: target.DeleteUsers,
+		Mapping:     target.Mapping,
+	}
+	status := &response.Status
+
+	var counts []struct {
+		State string
+		Count int64
+	}
+	if err := s.db.Model(&Job{}).Select("state, COUNT(*) AS count").Where("target_id = ?", id).Group("state").Find(&counts).Error; err != nil {
+		return nil, fmt.Errorf("failed to count provisioning jobs: %w", err)
+	}
+	for _, c := range counts {
+		if c.State == JobStateFailed {
+			status.FailedJobs = c.Count
+		} else {
+			status.PendingJobs += c.Count
+		}
+	}
+
+	var links []struct {
+		Kind     string
+		Count    int64
+		SyncedAt time.Time
+	}
+	if err := s.db.Model(&Link{}).Select("kind, COUNT(*) AS count, MAX(synced_at) AS synced_at").Where("target_id = ?", id).Group("kind").Find(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to count provisioning links: %w", err)
+	}
+	var lastSynced time.Time
+	for _, l := range links {
+		if l.Kind == KindGroup {
+			status.LinkedGroups = l.Count
+		} else {
+			status.LinkedUsers = l.Count
+		}
+		if l.SyncedAt.After(lastSynced) {
+			lastSynced = l.SyncedAt
+		}
+	}
+	if !lastSynced.IsZero() {
+		status.LastSyncedAt = lastSynced.Format(time.RFC3339)
+	}
+
+	var lastFailed Job
+	err := s.db.Where("target_id = ? AND last_error <> ''", id).Order("updated_at DESC").First(&lastFailed).Error
+	if err == nil {
+		status.LastError = lastFailed.LastError
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get provisioning jobs: %w", err)
+	}
+
+	var run ReconcileRun
+	err = s.db.Where("target_id = ?", id).Order("started_at DESC").First(&run).Error
+	if err == nil {
+		status.LastReconcile = toRunResponse(&run, false)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get reconcile run: %w", err)
+	}
+	return response, nil
+}
+
+// GetJobs lists the queued jobs of a target, optionally of one state
+func (s *ProvisioningService) GetJobs(targetID, state string) ([]JobResponse, error) {
+	if _, ok := s.targets[targetID]; !ok {
+		return nil, ErrTargetNotFound
+	}
+	query := s.db.Where("target_id = ?", targetID)
+	if state != "" {
+		query = query.Where("state = ?", state)
+	}
+	var jobs []Job
+	if err := query.Order("updated_at DESC").Limit(jobListLimit).Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get provisioning jobs: %w", err)
+	}
+	responses := make([]JobResponse, 0, len(jobs))
+	for _, job := range jobs {
+		response := JobResponse{
+			ID:        job.ID.String(),
+			Kind:      job.Kind,
+			LocalID:   job.LocalID.String(),
+			State:     job.State,
+			Force:     job.Force,
+			Attempts:  job.Attempts,
+			LastError: job.LastError,
+			CreatedAt: job.CreatedAt.Format(time.RFC3339),
+			UpdatedAt: job.UpdatedAt.Format(time.RFC3339),
+		}
+		if job.State != JobStateFailed {
+			response.NextAttemptAt = job.NextAttemptAt.Format(time.RFC3339)
+		}
+		responses = append(responses, response)
+	}
+	return responses, nil
+}
+
+// Reconcile compares the remote users and groups of a target with the local
+// ones and queues forced deliveries that correct the differences. A dry run
+// only reports them. The run is recorded even when it fails.
+func (s *ProvisioningService) Reconcile(targetID string, dryRun bool, trigger, actor string) (*ReconcileRunResponse, error) {
+	target, ok := s.targets[targetID]
+	if !ok {
+		return nil, ErrTargetNotFound
+	}
+	lock := s.reconciling[targetID]
+	if !lock.TryLock() {
+		return nil, ErrReconcileInProgress
+	}
+	defer lock.Unlock()
+
+	run := ReconcileRun{
+		TargetID:  targetID,
+		DryRun:    dryRun,
+		Trigger:   trigger,
+		Actor:     actor,
+		StartedAt: time.Now(),
+	}
+	changes, err := s.reconcile(target, dryRun)
+	run.FinishedAt = time.Now()
+	run.State = RunStateCompleted
+	if err != nil {
+		run.State = RunStateFailed
+		run.Error = err.Error()
+	} else if summarize(changes)["failed"] > 0 {
+		run.State = RunStatePartial
+	}
+	encoded, encodeErr := json.Marshal(changes)
+	if encodeErr != nil {
+		return nil, fmt.Errorf("failed to encode reconcile changes: %w", encodeErr)
+	}
+	run.Changes = string(encoded)
+	if err := s.db.Create(&run).Error; err != nil {
+		return nil, fmt.Errorf("failed to record reconcile run: %w", err)
+	}
+
+	response := toRunResponse(&run, true)
+	if !dryRun && run.State != RunStateFailed {
+		events.Publish(events.Event{
+			Type:    "provisioning.reconciled",
+			Subject: targetID,
+			Actor:   actor,
+			Data:    map[string]interface{}{"runId": run.ID.String(), "state": run.State, "summary": response.Summary},
+		})
+	}
+	return response, err
+}
+
+func (s *ProvisioningService) reconcile(target *Target, dryRun bool) ([]Change, error) {
+	client := s.clients[target.ID]
+	remoteUsers, err := client.List(endpointUsers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote users: %w", err)
+	}
+	var remoteGroups []map[string]interface{}
+	if target.Groups {
+		if remoteGroups, err = client.List(endpointGroups); err != nil {
+			return nil, fmt.Errorf("failed to list remote groups: %w", err)
+		}
+	}
+	st, err := s.loadState(target)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := planUsers(target, st, remoteUsers)
+	if target.Groups {
+		changes = append(changes, planGroups(st, remoteGroups)...)
+	}
+	if !dryRun {
+		s.apply(target, changes)
+	}
+	return changes, nil
+}
+
+func (s *ProvisioningService) loadState(target *Target) (*state, error) {
+	st := &state{
+		members:    make(map[uuid.UUID][]uuid.UUID),
+		userLinks:  make(map[uuid.UUID]*Link),
+		groupLinks: make(map[uuid.UUID]*Link),
+	}
+
+	var links []Link
+	if err := s.db.Where("target_id = ?", target.ID).Find(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to get provisioning links: %w", err)
+	}
+	for i := range links {
+		link := &links[i]
+		if link.Kind == KindGroup {
+			st.groupLinks[link.LocalID] = link
+		} else {
+			st.userLinks[link.LocalID] = link
+		}
+	}
+
+	if err := s.db.Find(&st.users).Error; err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	if !target.Groups {
+		return st, nil
+	}
+	if err := s.db.Find(&st.groups).Error; err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+	var members []member.Member
+	if err := s.db.Where(models.InEffectSQL("members")).Find(&members).Error; err != nil {
+		return nil, fmt.Errorf("failed to get members: %w", err)
+	}
+	for _, m := range members {
+		st.members[m.GroupID] = append(st.members[m.GroupID], m.UserID)
+	}
+	return st, nil
+}
+
+// apply links matched remote resources and queues forced deliveries for
+// everything but orphans. A failed change is recorded on it and doesn't stop
+// the others.
+func (s *ProvisioningService) apply(target *Target, changes []Change) {
+	for i := range changes {
+		change := &changes[i]
+		if change.Action == ActionOrphan {
+			continue
+		}
+		localID := uuid.MustParse(change.LocalID)
+		var err error
+		if change.Action == ActionLink {
+			// An empty hash makes the delivery push the local state
+			err = s.saveLink(&Link{TargetID: target.ID, Kind: change.Kind, LocalID: localID}, change.RemoteID, "")
+		}
+		if err == nil {
+			err = s.Enqueue(target.ID, change.Kind, localID, true)
+		}
+		if err != nil {
+			s.logger.Errorf("Reconciliation of %s failed to %s %s %s: %v", target.ID, change.Action, change.Kind, change.Name, err)
+			change.Error = err.Error()
+		}
+	}
+}
+
+// RunReconcileScheduler reconciles every target every interval until ctx is
+// done
+func (s *ProvisioningService) RunReconcileScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, id := range s.order {
+				run, err := s.Reconcile(id, false, TriggerScheduled, reconcileActor)
+				if err != nil {
+					s.logger.Errorf("Provisioning reconciliation of %s failed: %v", id, err)
+				} else {
+					s.logger.Infof("Provisioning reconciliation of %s %s: %v", id, run.State, run.Summary)
+				}
+			}
+		}
+	}
+}
+
+// GetReconcileRuns lists the most recent runs of a target without their
+// changes
+func (s *ProvisioningService) GetReconcileRuns(targetID string) ([]ReconcileRunResponse, error) {
+	if _, ok := s.targets[targetID]; !ok {
+		return nil, ErrTargetNotFound
+	}
+	var runs []ReconcileRun
+	if err := s.db.Where("target_id = ?", targetID).Order("started_at DESC").Limit(runListLimit).Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get reconcile runs: %w", err)
+	}
+	responses := make([]ReconcileRunResponse, 0, len(runs))
+	for i := range runs {
+		responses = append(responses, *toRunResponse(&runs[i], false))
+	}
+	return responses, nil
+}
+
+func (s *ProvisioningService) GetReconcileRun(targetID string, id uuid.UUID) (*ReconcileRunResponse, error) {
+	var run ReconcileRun
+	if err := s.db.First(&run, "id = ? AND target_id = ?", id, targetID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get reconcile run: %w", err)
+	}
+	return toRunResponse(&run, true), nil
+}
+
+func toRunResponse(run *ReconcileRun, withChanges bool) *ReconcileRunResponse {
+	var changes []Change
+	_ = json.Unmarshal([]byte(run.Changes), &changes)
+	response := &ReconcileRunResponse{
+		ID:         run.ID.String(),
+		TargetID:   run.TargetID,
+		DryRun:     run.DryRun,
+		Trigger:    run.Trigger,
+		Actor:      run.Actor,
+		State:      run.State,
+		Error:      run.Error,
+		Summary:    summarize(changes),
+		StartedAt:  run.StartedAt.Format(time.RFC3339),
+		FinishedAt: run.FinishedAt.Format(time.RFC3339),
+	}
+	if withChanges {
+		response.Changes = changes
+	}
+	return response
+}
+
+// isUserEvent reports whether an event type may change a pushed user
+func isUserEvent(eventType string) bool {
+	return strings.HasPrefix(eventType, "user.") && eventType != "user.transition_scheduled"
+}
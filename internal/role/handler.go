@@ -21,7 +21,7 @@ func NewRoleController(roleService *RoleService) *RoleController {
 }
 
 func (c *RoleController) GetAllRoles(ctx *gin.Context) {
-	roles, err := c.roleService.GetAllRoles()
+	roles, err := c.roleService.WithContext(ctx).GetAllRoles()
 	if err != nil {
 		c.logger.Errorf("Failed to get roles: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
@@ -39,7 +39,7 @@ func (c *RoleController) GetRole(ctx *gin.Context) {
 		return
 	}
 
-	role, err := c.roleService.GetRole(id)
+	role, err := c.roleService.WithContext(ctx).GetRole(id)
 	if err != nil {
 		c.logger.Errorf("Failed to get role: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get role"})
@@ -61,7 +61,7 @@ func (c *RoleController) CreateRole(ctx *gin.Context) {
 		return
 	}
 
-	role, err := c.roleService.CreateRole(req)
+	role, err := c.roleService.WithContext(ctx).CreateRole(req)
 	if err != nil {
 		c.logger.Errorf("Failed to create role: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -85,7 +85,7 @@ func (c *RoleController) UpdateRole(ctx *gin.Context) {
 		return
 	}
 
-	role, err := c.roleService.UpdateRole(id, req)
+	role, err := c.roleService.WithContext(ctx).UpdateRole(id, req)
 	if err != nil {
 		c.logger.Errorf("Failed to update role: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -108,7 +108,7 @@ func (c *RoleController) DeleteRole(ctx *gin.Context) {
 		return
 	}
 
-	err = c.roleService.DeleteRole(id)
+	err = c.roleService.WithContext(ctx).DeleteRole(id)
 	if err != nil {
 		c.logger.Errorf("Failed to delete role: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
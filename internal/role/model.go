@@ -3,11 +3,15 @@ package role
 import (
 	"time"
 
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/tenant"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type Role struct {
+	tenant.Scoped
 	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	Name        string    `json:"name" gorm:"not null"`
 	DisplayName string    `json:"displayName" gorm:"column:displayname"`
@@ -23,6 +27,17 @@ func (r *Role) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// AfterCreate links the role to the authorization system object
+func (r *Role) AfterCreate(tx *gorm.DB) error {
+	return authz.RecordSystemLink(tx, authz.OpWrite, "role", r.ID)
+}
+
+// AfterDelete removes the link of the role to the authorization system
+// object. Delete with the ID set, e.g. Delete(&Role{ID: id}), for it to run.
+func (r *Role) AfterDelete(tx *gorm.DB) error {
+	return authz.RecordSystemLink(tx, authz.OpDelete, "role", r.ID)
+}
+
 func (r *Role) TableName() string {
 	return "roles"
 }
@@ -1,10 +1,13 @@
 package role
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
+	"idmapp-go/internal/events"
+
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"gorm.io/gorm"
@@ -22,6 +25,14 @@ func NewRoleService(db *gorm.DB) *RoleService {
 	}
 }
 
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *RoleService) WithContext(ctx context.Context) *RoleService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
 func (s *RoleService) GetAllRoles() ([]Role, error) {
 	var roles []Role
 	result := s.db.Find(&roles)
@@ -103,12 +114,17 @@ func (s *RoleService) UpdateRole(id uuid.UUID, req RoleUpdateRequest) (*Role, er
 }
 
 func (s *RoleService) DeleteRole(id uuid.UUID) error {
-	result := s.db.Delete(&Role{}, "id = ?", id)
+	result := s.db.Delete(&Role{ID: id})
 	if result.Error != nil {
 		return fmt.Errorf("failed to delete role: %w", result.Error)
 	}
 	if result.RowsAffected == 0 {
 		return errors.New("role not found")
 	}
+
+	events.Publish(events.Event{
+		Type:    "role.deleted",
+		Subject: id.String(),
+	})
 	return nil
 }
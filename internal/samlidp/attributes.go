@@ -0,0 +1,137 @@
+package samlidp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"idmapp-go/internal/user"
+
+	"github.com/crewjam/saml"
+)
+
+// User attributes that can be released to service providers
+const (
+	AttributeID        = "id"
+	AttributeEmail     = "email"
+	AttributeName      = "name"
+	AttributeFirstName = "firstName"
+	AttributeLastName  = "lastName"
+	AttributeGroups    = "groups"
+	AttributeRoles     = "roles"
+)
+
+// defaultAttributeMapping is used for service providers without a mapping
+var defaultAttributeMapping = map[string]string{
+	"email":     AttributeEmail,
+	"name":      AttributeName,
+	"firstName": AttributeFirstName,
+	"lastName":  AttributeLastName,
+	"groups":    AttributeGroups,
+	"roles":     AttributeRoles,
+}
+
+// NameID formats a service provider can ask for
+const (
+	NameIDFormatEmail       = "email"
+	NameIDFormatPersistent  = "persistent"
+	NameIDFormatTransient   = "transient"
+	NameIDFormatUnspecified = "unspecified"
+)
+
+var nameIDFormatURNs = map[string]string{
+	NameIDFormatEmail:       "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress",
+	NameIDFormatPersistent:  "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent",
+	NameIDFormatTransient:   "urn:oasis:names:tc:SAML:2.0:nameid-format:transient",
+	NameIDFormatUnspecified: "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified",
+}
+
+const basicAttributeNameFormat = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+
+// Subject is everything an assertion can say about a user
+type Subject struct {
+	User   *user.User
+	Groups []string
+	Roles  []string
+}
+
+func validateAttributeMapping(mapping map[string]string) error {
+	for name, source := range mapping {
+		switch source {
+		case AttributeID, AttributeEmail, AttributeName, AttributeFirstName, AttributeLastName, AttributeGroups, AttributeRoles:
+		default:
+			return fmt.Errorf("attribute %q maps to unknown user attribute %q", name, source)
+		}
+	}
+	return nil
+}
+
+// buildAttributes releases the mapped user attributes, sorted by name.
+// Single-valued attributes without a value are left out.
+func buildAttributes(mapping map[string]string, subject Subject) []saml.Attribute {
+	if len(mapping) == 0 {
+		mapping = defaultAttributeMapping
+	}
+
+	names := make([]string, 0, len(mapping))
+	for name := range mapping {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attributes := make([]saml.Attribute, 0, len(names))
+	for _, name := range names {
+		var values []string
+		switch mapping[name] {
+		case AttributeID:
+			values = []string{subject.User.ID.String()}
+		case AttributeEmail:
+			values = []string{subject.User.Email}
+		case AttributeName:
+			values = []string{subject.User.Name}
+		case AttributeFirstName:
+			values = []string{subject.User.FirstName}
+		case AttributeLastName:
+			values = []string{subject.User.LastName}
+		case AttributeGroups:
+			values = subject.Groups
+		case AttributeRoles:
+			values = subject.Roles
+		}
+
+		attribute := saml.Attribute{Name: name, NameFormat: basicAttributeNameFormat}
+		for _, value := range values {
+			if value != "" {
+				attribute.Values = append(attribute.Values, saml.AttributeValue{Type: "xs:string", Value: value})
+			}
+		}
+		isList := mapping[name] == AttributeGroups || mapping[name] == AttributeRoles
+		if len(attribute.Values) == 0 && !isList {
+			continue
+		}
+		attributes = append(attributes, attribute)
+	}
+	return attributes
+}
+
+// nameID returns the NameID value and format URN for a user. Transient
+// identifiers are random for every assertion.
+func nameID(format string, u *user.User) (string, string, error) {
+	urn, ok := nameIDFormatURNs[format]
+	if !ok {
+		return "", "", fmt.Errorf("unknown NameID format %q", format)
+	}
+	switch format {
+	case NameIDFormatPersistent:
+		return u.ID.String(), urn, nil
+	case NameIDFormatTransient:
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return "", "", fmt.Errorf("failed to generate transient NameID: %w", err)
+		}
+		return "_" + hex.EncodeToString(buf), urn, nil
+	default:
+		return u.Email, urn, nil
+	}
+}
@@ -0,0 +1,89 @@
+package samlidp
+
+import (
+	"testing"
+
+	"idmapp-go/internal/user"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSubject() Subject {
+	return Subject{
+		User: &user.User{
+			ID:        uuid.MustParse("7d0c2a4e-2f5b-4c1e-9a57-2f1c1b0f6d11"),
+			Name:      "jdoe",
+			FirstName: "Jane",
+			Email:     "jane@example.com",
+		},
+		Groups: []string{"engineering", "oncall"},
+	}
+}
+
+func attributeValues(attribute saml.Attribute) []string {
+	var values []string
+	for _, value := range attribute.Values {
+		values = append(values, value.Value)
+	}
+	return values
+}
+
+func TestBuildAttributesUsesMapping(t *testing.T) {
+	attributes := buildAttributes(map[string]string{
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress": AttributeEmail,
+		"memberOf": AttributeGroups,
+		"roles":    AttributeRoles,
+		"uid":      AttributeID,
+		"sn":       AttributeLastName,
+	}, testSubject())
+
+	require.Len(t, attributes, 4)
+	assert.Equal(t, "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress", attributes[0].Name)
+	assert.Equal(t, []string{"jane@example.com"}, attributeValues(attributes[0]))
+	assert.Equal(t, "memberOf", attributes[1].Name)
+	assert.Equal(t, []string{"engineering", "oncall"}, attributeValues(attributes[1]))
+	// Empty lists are released so the SP can tell there are no memberships
+	assert.Equal(t, "roles", attributes[2].Name)
+	assert.Empty(t, attributes[2].Values)
+	// Empty single values are left out
+	assert.Equal(t, "uid", attributes[3].Name)
+	assert.Equal(t, []string{"7d0c2a4e-2f5b-4c1e-9a57-2f1c1b0f6d11"}, attributeValues(attributes[3]))
+}
+
+func TestBuildAttributesDefaultMapping(t *testing.T) {
+	var names []string
+	for _, attribute := range buildAttributes(nil, testSubject()) {
+		names = append(names, attribute.Name)
+	}
+	assert.Equal(t, []string{"email", "firstName", "groups", "name", "roles"}, names)
+}
+
+func TestValidateAttributeMapping(t *testing.T) {
+	assert.NoError(t, validateAttributeMapping(map[string]string{"mail": AttributeEmail}))
+	assert.Error(t, validateAttributeMapping(map[string]string{"mail": "password"}))
+}
+
+func TestNameID(t *testing.T) {
+	u := testSubject().User
+
+	value, format, err := nameID(NameIDFormatEmail, u)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com", value)
+	assert.Equal(t, "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress", format)
+
+	value, _, err = nameID(NameIDFormatPersistent, u)
+	require.NoError(t, err)
+	assert.Equal(t, u.ID.String(), value)
+
+	first, _, err := nameID(NameIDFormatTransient, u)
+	require.NoError(t, err)
+	second, _, err := nameID(NameIDFormatTransient, u)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	_, _, err = nameID("x509", u)
+	assert.Error(t, err)
+}
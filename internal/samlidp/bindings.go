@@ -0,0 +1,221 @@
+package samlidp
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"idmapp-go/internal/keyring"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// maxMessageSize bounds inflated HTTP-Redirect messages
+const maxMessageSize = 1 << 20
+
+var redirectSignatureHashes = map[string]crypto.Hash{
+	dsig.RSASHA1SignatureMethod:   crypto.SHA1,
+	dsig.RSASHA256SignatureMethod: crypto.SHA256,
+	dsig.RSASHA512SignatureMethod: crypto.SHA512,
+}
+
+// decodeRedirectMessage undoes the DEFLATE and base64 encoding of the
+// HTTP-Redirect binding
+func decodeRedirectMessage(value string) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
+	reader := flate.NewReader(bytes.NewReader(compressed))
+	defer reader.Close()
+	message, err := io.ReadAll(io.LimitReader(reader, maxMessageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate message: %w", err)
+	}
+	if len(message) > maxMessageSize {
+		return nil, errors.New("message is too large")
+	}
+	return message, nil
+}
+
+func encodeRedirectMessage(message []byte) (string, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// signRedirectQuery returns the HTTP-Redirect binding query string for a
+// message, signed as described in saml-bindings-2.0 §3.4.4.1. param is
+// "SAMLRequest" or "SAMLResponse".
+func signRedirectQuery(key *keyring.Key, param string, message []byte, relayState string) (string, error) {
+	encoded, err := encodeRedirectMessage(message)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode message: %w", err)
+	}
+	query := param + "=" + url.QueryEscape(encoded)
+	if relayState != "" {
+		query += "&RelayState=" + url.QueryEscape(relayState)
+	}
+	query += "&SigAlg=" + url.QueryEscape(dsig.RSASHA256SignatureMethod)
+
+	hash := crypto.SHA256.New()
+	hash.Write([]byte(query))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key.PrivateKey, crypto.SHA256, hash.Sum(nil))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign message: %w", err)
+	}
+	return query + "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(signature)), nil
+}
+
+// verifyRedirectSignature checks the signature of an HTTP-Redirect binding
+// message. The signature covers the parameters exactly as the sender encoded
+// them, so they are taken from the raw query.
+func verifyRedirectSignature(rawQuery, param string, certificates []*x509.Certificate) error {
+	raw := make(map[string]string)
+	for _, part := range strings.Split(rawQuery, "&") {
+		name, value, _ := strings.Cut(part, "=")
+		if _, seen := raw[name]; !seen {
+			raw[name] = value
+		}
+	}
+	if raw["Signature"] == "" {
+		return errors.New("message is not signed")
+	}
+
+	sigAlg, err := url.QueryUnescape(raw["SigAlg"])
+	if err != nil {
+		return fmt.Errorf("invalid SigAlg: %w", err)
+	}
+	hashType, ok := redirectSignatureHashes[sigAlg]
+	if !ok {
+		return fmt.Errorf("unsupported signature algorithm %q", sigAlg)
+	}
+	encodedSignature, err := url.QueryUnescape(raw["Signature"])
+	if err != nil {
+		return fmt.Errorf("invalid Signature: %w", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return fmt.Errorf("invalid Signature: %w", err)
+	}
+
+	signed := param + "=" + raw[param]
+	if relayState, ok := raw["RelayState"]; ok {
+		signed += "&RelayState=" + relayState
+	}
+	signed += "&SigAlg=" + raw["SigAlg"]
+	hash := hashType.New()
+	hash.Write([]byte(signed))
+	digest := hash.Sum(nil)
+
+	for _, certificate := range certificates {
+		publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+		if ok && rsa.VerifyPKCS1v15(publicKey, hashType, digest, signature) == nil {
+			return nil
+		}
+	}
+	return errors.New("message signature does not match any service provider certificate")
+}
+
+// signElement returns an enveloped signature over el made with key
+func signElement(key *keyring.Key, el *etree.Element) (*etree.Element, error) {
+	signingContext := dsig.NewDefaultSigningContext(dsig.TLSCertKeyStore(tls.Certificate{
+		Certificate: [][]byte{key.Certificate.Raw},
+		PrivateKey:  key.PrivateKey,
+		Leaf:        key.Certificate,
+	}))
+	signingContext.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	if err := signingContext.SetSignatureMethod(dsig.RSASHA256SignatureMethod); err != nil {
+		return nil, err
+	}
+	signed, err := signingContext.SignEnveloped(el)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+	children := signed.ChildElements()
+	return children[len(children)-1], nil
+}
+
+// verifyElementSignature checks the enveloped signature of an HTTP-POST
+// binding message and returns the signed element, which is the only part
+// of the message that may be trusted
+func verifyElementSignature(el *etree.Element, certificates []*x509.Certificate) (*etree.Element, error) {
+	validationContext := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{Roots: certificates})
+	validated, err := validationContext.Validate(el)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message signature: %w", err)
+	}
+	return validated, nil
+}
+
+// signingCertificates returns the certificates a service provider signs with
+func signingCertificates(descriptor *saml.EntityDescriptor) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for _, spDescriptor := range descriptor.SPSSODescriptors {
+		for _, keyDescriptor := range spDescriptor.KeyDescriptors {
+			if keyDescriptor.Use != "" && keyDescriptor.Use != "signing" {
+				continue
+			}
+			for _, data := range keyDescriptor.KeyInfo.X509Data.X509Certificates {
+				der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(data.Data), ""))
+				if err != nil {
+					return nil, fmt.Errorf("invalid certificate in metadata: %w", err)
+				}
+				certificate, err := x509.ParseCertificate(der)
+				if err != nil {
+					return nil, fmt.Errorf("invalid certificate in metadata: %w", err)
+				}
+				certificates = append(certificates, certificate)
+			}
+		}
+	}
+	return certificates, nil
+}
+
+// logoutEndpoint returns the service provider's single logout endpoint for
+// the first of the given bindings it supports
+func logoutEndpoint(descriptor *saml.EntityDescriptor, bindings ...string) *saml.Endpoint {
+	for _, binding := range bindings {
+		for _, spDescriptor := range descriptor.SPSSODescriptors {
+			for _, endpoint := range spDescriptor.SingleLogoutServices {
+				if endpoint.Binding == binding {
+					endpoint := endpoint
+					return &endpoint
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// withQuery appends an encoded query to a URL that may already have one
+func withQuery(location, query string) string {
+	if strings.Contains(location, "?") {
+		return location + "&" + query
+	}
+	return location + "?" + query
+}
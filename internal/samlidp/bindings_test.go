@@ -0,0 +1,110 @@
+package samlidp
+
+import (
+	"crypto/x509"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"idmapp-go/internal/keyring"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(t *testing.T) *keyring.Key {
+	key, err := keyring.Generate("test", time.Now().Add(-time.Minute), time.Hour)
+	require.NoError(t, err)
+	return key
+}
+
+func TestRedirectMessageRoundTrip(t *testing.T) {
+	encoded, err := encodeRedirectMessage([]byte("<samlp:LogoutRequest/>"))
+	require.NoError(t, err)
+	message, err := decodeRedirectMessage(encoded)
+	require.NoError(t, err)
+	assert.Equal(t, "<samlp:LogoutRequest/>", string(message))
+}
+
+func TestRedirectSignature(t *testing.T) {
+	key := testKey(t)
+	other := testKey(t)
+
+	query, err := signRedirectQuery(key, "SAMLRequest", []byte("<samlp:LogoutRequest/>"), "state 1")
+	require.NoError(t, err)
+
+	assert.NoError(t, verifyRedirectSignature(query, "SAMLRequest", []*x509.Certificate{other.Certificate, key.Certificate}))
+	assert.Error(t, verifyRedirectSignature(query, "SAMLRequest", []*x509.Certificate{other.Certificate}))
+
+	tampered := strings.Replace(query, "RelayState=state+1", "RelayState=state+2", 1)
+	assert.Error(t, verifyRedirectSignature(tampered, "SAMLRequest", []*x509.Certificate{key.Certificate}))
+
+	unsigned := query[:strings.Index(query, "&Signature=")]
+	assert.Error(t, verifyRedirectSignature(unsigned, "SAMLRequest", []*x509.Certificate{key.Certificate}))
+
+	values, err := url.ParseQuery(query)
+	require.NoError(t, err)
+	message, err := decodeRedirectMessage(values.Get("SAMLRequest"))
+	require.NoError(t, err)
+	assert.Equal(t, "<samlp:LogoutRequest/>", string(message))
+}
+
+func TestElementSignature(t *testing.T) {
+	key := testKey(t)
+	request := saml.LogoutRequest{
+		ID:           newMessageID(),
+		Version:      "2.0",
+		IssueInstant: saml.TimeNow(),
+		Issuer:       &saml.Issuer{Value: "https://sp.example.com"},
+		NameID:       &saml.NameID{Value: "jane@example.com"},
+	}
+	signature, err := signElement(key, request.Element())
+	require.NoError(t, err)
+	request.Signature = signature
+
+	message, err := elementBytes(request.Element())
+	require.NoError(t, err)
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromBytes(message))
+	_, err = verifyElementSignature(doc.Root(), []*x509.Certificate{key.Certificate})
+	assert.NoError(t, err)
+
+	// Changing the signed content breaks the signature
+	tampered := strings.Replace(string(message), "jane@example.com", "john@example.com", 1)
+	doc = etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(tampered))
+	_, err = verifyElementSignature(doc.Root(), []*x509.Certificate{key.Certificate})
+	assert.Error(t, err)
+}
+
+func TestParseMetadata(t *testing.T) {
+	key := testKey(t)
+	metadata := `<EntityDescriptor xmlns="urn:oasis:names:tc:SAML:2.0:metadata" entityID="https://sp.example.com">
+  <SPSSODescriptor protocolSupportEnumeration="urn:oasis:names:tc:SAML:2.0:protocol">
+    <KeyDescriptor use="signing"><KeyInfo xmlns="http://www.w3.org/2000/09/xmldsig#"><X509Data><X509Certificate>` +
+		encodeCertificate(key) + `</X509Certificate></X509Data></KeyInfo></KeyDescriptor>
+    <SingleLogoutService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://sp.example.com/slo"/>
+    <AssertionConsumerService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST" Location="https://sp.example.com/acs" index="0"/>
+  </SPSSODescriptor>
+</EntityDescriptor>`
+
+	descriptor, err := parseMetadata(metadata)
+	require.NoError(t, err)
+	assert.Equal(t, "https://sp.example.com", descriptor.EntityID)
+
+	certificates, err := signingCertificates(descriptor)
+	require.NoError(t, err)
+	require.Len(t, certificates, 1)
+	assert.True(t, certificates[0].Equal(key.Certificate))
+
+	assert.Nil(t, logoutEndpoint(descriptor, saml.HTTPRedirectBinding))
+	endpoint := logoutEndpoint(descriptor, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	require.NotNil(t, endpoint)
+	assert.Equal(t, "https://sp.example.com/slo", endpoint.Location)
+
+	_, err = parseMetadata(strings.Replace(metadata, "AssertionConsumerService", "ArtifactResolutionService", 2))
+	assert.ErrorIs(t, err, ErrInvalidMetadata)
+}
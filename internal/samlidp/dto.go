@@ -0,0 +1,32 @@
+package samlidp
+
+import "time"
+
+type ServiceProviderCreateRequest struct {
+	Name string `json:"name" binding:"required"`
+	// Metadata is the SP's SAML metadata XML; the entity ID is taken from it
+	Metadata         string            `json:"metadata" binding:"required"`
+	NameIDFormat     string            `json:"nameIdFormat" binding:"omitempty,oneof=email persistent transient unspecified"`
+	AttributeMapping map[string]string `json:"attributeMapping"`
+}
+
+type ServiceProviderUpdateRequest struct {
+	Name             string            `json:"name"`
+	Metadata         string            `json:"metadata"`
+	NameIDFormat     string            `json:"nameIdFormat" binding:"omitempty,oneof=email persistent transient unspecified"`
+	AttributeMapping map[string]string `json:"attributeMapping"`
+	IsActive         *bool             `json:"isActive"`
+}
+
+type ServiceProviderResponse struct {
+	ID               string            `json:"id"`
+	EntityID         string            `json:"entityId"`
+	Name             string            `json:"name"`
+	NameIDFormat     string            `json:"nameIdFormat"`
+	AttributeMapping map[string]string `json:"attributeMapping"`
+	IsActive         bool              `json:"isActive"`
+	// LaunchURL starts IdP-initiated sign-in to the SP
+	LaunchURL string    `json:"launchUrl"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
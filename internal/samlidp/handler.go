@@ -0,0 +1,350 @@
+package samlidp
+
+import (
+	"encoding/xml"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"idmapp-go/internal/session"
+
+	"github.com/crewjam/saml"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type SAMLController struct {
+	samlService    *SAMLService
+	sessionService *session.SessionService
+	logger         *logrus.Logger
+}
+
+func NewSAMLController(samlService *SAMLService, sessionService *session.SessionService) *SAMLController {
+	return &SAMLController{
+		samlService:    samlService,
+		sessionService: sessionService,
+		logger:         logrus.New(),
+	}
+}
+
+// Metadata serves the IdP metadata document
+func (c *SAMLController) Metadata(ctx *gin.Context) {
+	metadata, err := xml.MarshalIndent(c.samlService.Metadata(), "", "  ")
+	if err != nil {
+		c.logger.Errorf("Failed to encode SAML metadata: %v", err)
+		ctx.String(http.StatusInternalServerError, "Failed to encode metadata")
+		return
+	}
+	ctx.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// SSO handles SP-initiated sign-in. Users without a session sign in first
+// and come back through ResumeSSO.
+func (c *SAMLController) SSO(ctx *gin.Context) {
+	req, err := saml.NewIdpAuthnRequest(c.samlService.IdentityProvider(), ctx.Request)
+	if err == nil {
+		err = req.Validate()
+	}
+	if err != nil {
+		c.logger.Warnf("Rejected SAML AuthnRequest: %v", err)
+		ctx.String(http.StatusBadRequest, "Invalid SAML request")
+		return
+	}
+
+	c.respond(ctx, req, func() (string, error) {
+		requestID, err := c.samlService.SavePendingRequest(req)
+		if err != nil {
+			return "", err
+		}
+		return "/saml/resume/" + requestID.String(), nil
+	})
+}
+
+// ResumeSSO answers an AuthnRequest that was waiting for the user to sign in
+func (c *SAMLController) ResumeSSO(ctx *gin.Context) {
+	requestID, err := uuid.Parse(ctx.Param("requestId"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid request ID")
+		return
+	}
+
+	req, err := c.samlService.ResumeRequest(requestID, ctx.Request)
+	if err != nil {
+		if errors.Is(err, ErrRequestExpired) {
+			ctx.String(http.StatusBadRequest, "The sign-in request has expired. Please start again from the application.")
+			return
+		}
+		c.logger.Warnf("Failed to resume SAML request: %v", err)
+		ctx.String(http.StatusBadRequest, "Invalid SAML request")
+		return
+	}
+
+	c.respond(ctx, req, func() (string, error) {
+		requestID, err := c.samlService.SavePendingRequest(req)
+		if err != nil {
+			return "", err
+		}
+		return "/saml/resume/" + requestID.String(), nil
+	})
+}
+
+// Launch handles IdP-initiated sign-in to a registered service provider
+func (c *SAMLController) Launch(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, "Invalid service provider ID")
+		return
+	}
+
+	req, err := c.samlService.LaunchRequest(id, ctx.Query("RelayState"), ctx.Request)
+	if err != nil {
+		if errors.Is(err, ErrUnknownServiceProvider) {
+			ctx.String(http.StatusNotFound, "Unknown service provider")
+			return
+		}
+		c.logger.Errorf("Failed to start IdP-initiated sign-in: %v", err)
+		ctx.String(http.StatusInternalServerError, "Failed to start sign-in")
+		return
+	}
+
+	c.respond(ctx, req, func() (string, error) {
+		return ctx.Request.URL.RequestURI(), nil
+	})
+}
+
+// respond posts an assertion for the browser's session to the service
+// provider, or sends the user to the login page and back to loginRedirect
+func (c *SAMLController) respond(ctx *gin.Context, req *saml.IdpAuthnRequest, loginRedirect func() (string, error)) {
+	loginSession, err := c.sessionService.SessionFromCookie(ctx)
+	if err != nil {
+		redirect, err := loginRedirect()
+		if err != nil {
+			c.logger.Errorf("Failed to save SAML request: %v", err)
+			ctx.String(http.StatusInternalServerError, "Failed to start sign-in")
+			return
+		}
+		ctx.Redirect(http.StatusFound, "/login?redirect="+url.QueryEscape(redirect))
+		return
+	}
+	if loginSession.ActorID != nil {
+		ctx.String(http.StatusForbidden, "SAML sign-in is not available while impersonating a user")
+		return
+	}
+
+	if err := c.samlService.MakeAssertion(req, loginSession); err != nil {
+		switch {
+		case errors.Is(err, ErrAccountDisabled):
+			ctx.String(http.StatusForbidden, "Your account is disabled")
+		case errors.Is(err, ErrUnknownServiceProvider):
+			ctx.String(http.StatusNotFound, "Unknown service provider")
+		default:
+			c.logger.Errorf("Failed to make SAML assertion: %v", err)
+			ctx.String(http.StatusInternalServerError, "Failed to sign in")
+		}
+		return
+	}
+
+	ctx.Header("Content-Type", "text/html")
+	ctx.Header("Cache-Control", "no-store")
+	if err := req.WriteResponse(ctx.Writer); err != nil {
+		c.logger.Errorf("Failed to write SAML response: %v", err)
+		ctx.String(http.StatusInternalServerError, "Failed to sign in")
+	}
+}
+
+// SingleLogout handles a LogoutRequest from a service provider: it ends the
+// session, notifies the other service providers and answers the initiator
+func (c *SAMLController) SingleLogout(ctx *gin.Context) {
+	// Replies to the LogoutRequests sent from the logout page need no action
+	if ctx.Query("SAMLResponse") != "" || ctx.PostForm("SAMLResponse") != "" {
+		ctx.Status(http.StatusOK)
+		return
+	}
+
+	req, err := c.samlService.ParseLogoutRequest(ctx.Request)
+	if err != nil {
+		c.logger.Warnf("Rejected SAML LogoutRequest: %v", err)
+		ctx.String(http.StatusBadRequest, "Invalid logout request")
+		return
+	}
+
+	cookieSession, err := c.sessionService.SessionFromCookie(ctx)
+	if err != nil {
+		cookieSession = nil
+	}
+	status := saml.StatusSuccess
+	var logoutURLs []string
+	sessionID, err := c.samlService.FindLogoutSession(req, cookieSession)
+	if err != nil {
+		c.logger.Errorf("Failed to find session for logout: %v", err)
+		status = saml.StatusResponder
+	} else if sessionID != nil {
+		logoutURLs, err = c.samlService.EndSession(*sessionID, &req.ServiceProvider.ID, req.ServiceProvider.EntityID)
+		if err != nil {
+			c.logger.Errorf("Failed to end session on SAML logout: %v", err)
+			status = saml.StatusResponder
+		} else if cookieSession != nil && cookieSession.ID == *sessionID {
+			session.ClearCookie(ctx)
+		}
+	}
+
+	response, err := c.samlService.MakeLogoutResponse(req, status)
+	if err != nil {
+		c.logger.Errorf("Failed to make SAML LogoutResponse: %v", err)
+		ctx.String(http.StatusInternalServerError, "Failed to complete logout")
+		return
+	}
+	c.renderLogout(ctx, logoutURLs, response, "")
+}
+
+// Logout ends the browser's session and signs the user out of every service
+// provider they used during it
+func (c *SAMLController) Logout(ctx *gin.Context) {
+	redirect := ctx.Query("redirect")
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/"
+	}
+
+	var logoutURLs []string
+	if loginSession, err := c.sessionService.SessionFromCookie(ctx); err == nil {
+		if loginSession.ActorID != nil {
+			// Impersonation sessions never reach service providers
+			ctx.Redirect(http.StatusFound, "/logout")
+			return
+		}
+		logoutURLs, err = c.samlService.EndSession(loginSession.ID, nil, loginSession.UserID.String())
+		if err != nil {
+			c.logger.Errorf("Failed to end session on logout: %v", err)
+		}
+	}
+	session.ClearCookie(ctx)
+	c.renderLogout(ctx, logoutURLs, nil, redirect)
+}
+
+// renderLogout shows a page that loads the service providers' logout URLs
+// and then continues to the LogoutResponse or to next
+func (c *SAMLController) renderLogout(ctx *gin.Context, logoutURLs []string, response *LogoutResponse, next string) {
+	tmpl, err := template.ParseFiles("templates/saml_logout.html")
+	if err != nil {
+		ctx.String(http.StatusInternalServerError, "Error loading logout template: %v", err)
+		return
+	}
+
+	data := gin.H{"LogoutURLs": logoutURLs, "Next": next}
+	if response != nil {
+		if response.RedirectURL != "" {
+			data["Next"] = response.RedirectURL
+		} else {
+			data["Form"] = response
+		}
+	}
+
+	ctx.Header("Content-Type", "text/html")
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Status(http.StatusOK)
+	if err := tmpl.Execute(ctx.Writer, data); err != nil {
+		c.logger.Errorf("Failed to render logout page: %v", err)
+	}
+}
+
+// Service provider registration
+
+func (c *SAMLController) GetAllServiceProviders(ctx *gin.Context) {
+	sps, err := c.samlService.GetAllServiceProviders()
+	if err != nil {
+		c.logger.Errorf("Failed to get service providers: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service providers"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, sps)
+}
+
+func (c *SAMLController) GetServiceProvider(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service provider ID"})
+		return
+	}
+
+	sp, err := c.samlService.GetServiceProvider(id)
+	if err != nil {
+		c.logger.Errorf("Failed to get service provider: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service provider"})
+		return
+	}
+	if sp == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Service provider not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, sp)
+}
+
+func (c *SAMLController) CreateServiceProvider(ctx *gin.Context) {
+	var req ServiceProviderCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sp, err := c.samlService.CreateServiceProvider(req)
+	if err != nil {
+		c.logger.Errorf("Failed to create service provider: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, sp)
+}
+
+func (c *SAMLController) UpdateServiceProvider(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service provider ID"})
+		return
+	}
+
+	var req ServiceProviderUpdateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sp, err := c.samlService.UpdateServiceProvider(id, req)
+	if err != nil {
+		c.logger.Errorf("Failed to update service provider: %v", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if sp == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Service provider not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, sp)
+}
+
+func (c *SAMLController) DeleteServiceProvider(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service provider ID"})
+		return
+	}
+
+	deleted, err := c.samlService.DeleteServiceProvider(id)
+	if err != nil {
+		c.logger.Errorf("Failed to delete service provider: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete service provider"})
+		return
+	}
+	if !deleted {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Service provider not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,338 @@
+package samlidp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"idmapp-go/internal/session"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	xrv "github.com/mattermost/xml-roundtrip-validator"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidRequest = errors.New("invalid SAML request")
+
+// LogoutRequest is a LogoutRequest received from a registered service
+// provider. Signed is set when its signature was verified against the SP's
+// metadata.
+type LogoutRequest struct {
+	Request         *saml.LogoutRequest
+	ServiceProvider *ServiceProvider
+	Metadata        *saml.EntityDescriptor
+	RelayState      string
+	Signed          bool
+}
+
+// LogoutResponse is the reply to a service provider's LogoutRequest. It is
+// delivered by redirecting to RedirectURL, or by posting SAMLResponse and
+// RelayState to PostURL.
+type LogoutResponse struct {
+	RedirectURL  string
+	PostURL      string
+	SAMLResponse string
+	RelayState   string
+}
+
+// ParseLogoutRequest reads a LogoutRequest sent with the HTTP-Redirect or
+// HTTP-POST binding. Requests from service providers whose metadata lists a
+// signing certificate must be signed.
+func (s *SAMLService) ParseLogoutRequest(r *http.Request) (*LogoutRequest, error) {
+	var message []byte
+	var relayState string
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		message, err = decodeRedirectMessage(r.URL.Query().Get("SAMLRequest"))
+		relayState = r.URL.Query().Get("RelayState")
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+		message, err = base64.StdEncoding.DecodeString(r.PostForm.Get("SAMLRequest"))
+		relayState = r.PostForm.Get("RelayState")
+	default:
+		return nil, fmt.Errorf("%w: method not allowed", ErrInvalidRequest)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+
+	if err := xrv.Validate(bytes.NewReader(message)); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(message); err != nil || doc.Root() == nil {
+		return nil, fmt.Errorf("%w: malformed XML", ErrInvalidRequest)
+	}
+	var logoutRequest saml.LogoutRequest
+	if err := xml.Unmarshal(message, &logoutRequest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	if logoutRequest.Issuer == nil {
+		return nil, fmt.Errorf("%w: missing Issuer", ErrInvalidRequest)
+	}
+
+	sp, err := s.getActiveServiceProvider(logoutRequest.Issuer.Value)
+	if err != nil {
+		return nil, err
+	}
+	if sp == nil {
+		return nil, ErrUnknownServiceProvider
+	}
+	metadata, err := parseMetadata(sp.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	certificates, err := signingCertificates(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := false
+	if len(certificates) > 0 {
+		if r.Method == http.MethodGet {
+			if err := verifyRedirectSignature(r.URL.RawQuery, "SAMLRequest", certificates); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+			}
+		} else {
+			validated, err := verifyElementSignature(doc.Root(), certificates)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+			}
+			// Only trust what the signature covers
+			validatedDoc := etree.NewDocument()
+			validatedDoc.SetRoot(validated)
+			validatedMessage, err := validatedDoc.WriteToBytes()
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+			}
+			logoutRequest = saml.LogoutRequest{}
+			if err := xml.Unmarshal(validatedMessage, &logoutRequest); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+			}
+		}
+		signed = true
+	}
+
+	if logoutRequest.Destination != "" && logoutRequest.Destination != s.LogoutURL() {
+		return nil, fmt.Errorf("%w: unexpected Destination %q", ErrInvalidRequest, logoutRequest.Destination)
+	}
+	now := saml.TimeNow()
+	if logoutRequest.IssueInstant.Add(saml.MaxIssueDelay + saml.MaxClockSkew).Before(now) {
+		return nil, ErrRequestExpired
+	}
+	if logoutRequest.NotOnOrAfter != nil && !now.Before(*logoutRequest.NotOnOrAfter) {
+		return nil, ErrRequestExpired
+	}
+
+	return &LogoutRequest{
+		Request:         &logoutRequest,
+		ServiceProvider: sp,
+		Metadata:        metadata,
+		RelayState:      relayState,
+		Signed:          signed,
+	}, nil
+}
+
+// FindLogoutSession returns the ID of the login session a LogoutRequest
+// refers to, or nil. Signed requests are matched by their SessionIndex;
+// unsigned ones can only end the browser's own session.
+func (s *SAMLService) FindLogoutSession(req *LogoutRequest, cookieSession *session.Session) (*uuid.UUID, error) {
+	query := s.db.Where("service_provider_id = ?", req.ServiceProvider.ID)
+	switch {
+	case req.Signed && req.Request.SessionIndex != nil:
+		participantID, err := uuid.Parse(strings.TrimSpace(req.Request.SessionIndex.Value))
+		if err != nil {
+			return nil, nil
+		}
+		query = query.Where("id = ?", participantID)
+	case cookieSession != nil:
+		query = query.Where("session_id = ?", cookieSession.ID)
+	default:
+		return nil, nil
+	}
+	if req.Request.NameID != nil {
+		query = query.Where("name_id = ?", strings.TrimSpace(req.Request.NameID.Value))
+	}
+
+	var participant Participant
+	if err := query.First(&participant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session participant: %w", err)
+	}
+	return &participant.SessionID, nil
+}
+
+// HasParticipants reports whether any service provider signed in with the
+// session, in which case logging out should go through single logout
+func (s *SAMLService) HasParticipants(sessionID uuid.UUID) (bool, error) {
+	var count int64
+	if err := s.db.Model(&Participant{}).Where("session_id = ?", sessionID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to count session participants: %w", err)
+	}
+	return count > 0, nil
+}
+
+// EndSession revokes a login session and returns signed front-channel
+// LogoutRequest URLs for every service provider that took part in it except
+// the one that initiated the logout. Service providers without an
+// HTTP-Redirect single logout endpoint are skipped.
+func (s *SAMLService) EndSession(sessionID uuid.UUID, initiatorID *uuid.UUID, endedBy string) ([]string, error) {
+	var participants []Participant
+	if err := s.db.Where("session_id = ?", sessionID).Order("created_at").Find(&participants).Error; err != nil {
+		return nil, fmt.Errorf("failed to get session participants: %w", err)
+	}
+
+	var logoutURLs []string
+	notified := make(map[uuid.UUID]bool)
+	for _, participant := range participants {
+		if initiatorID != nil && participant.ServiceProviderID == *initiatorID {
+			continue
+		}
+		if notified[participant.ServiceProviderID] {
+			continue
+		}
+		notified[participant.ServiceProviderID] = true
+
+		logoutURL, err := s.participantLogoutURL(participant)
+		if err != nil {
+			s.logger.Warnf("Skipping single logout for service provider %s: %v", participant.ServiceProviderID, err)
+			continue
+		}
+		if logoutURL != "" {
+			logoutURLs = append(logoutURLs, logoutURL)
+		}
+	}
+
+	if loginSession, err := s.sessionService.GetActiveSession(sessionID); err == nil {
+		if _, err := s.sessionService.RevokeSession(loginSession.UserID, sessionID, "saml_logout", endedBy); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.db.Where("session_id = ?", sessionID).Delete(&Participant{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete session participants: %w", err)
+	}
+	return logoutURLs, nil
+}
+
+func (s *SAMLService) participantLogoutURL(participant Participant) (string, error) {
+	sp, err := s.getServiceProvider(participant.ServiceProviderID)
+	if err != nil || sp == nil || !sp.IsActive {
+		return "", err
+	}
+	metadata, err := parseMetadata(sp.Metadata)
+	if err != nil {
+		return "", err
+	}
+	endpoint := logoutEndpoint(metadata, saml.HTTPRedirectBinding)
+	if endpoint == nil {
+		return "", nil
+	}
+
+	logoutRequest := saml.LogoutRequest{
+		ID:           newMessageID(),
+		Version:      "2.0",
+		IssueInstant: saml.TimeNow(),
+		Destination:  endpoint.Location,
+		Issuer:       s.issuer(),
+		NameID: &saml.NameID{
+			Format:          participant.NameIDFormat,
+			NameQualifier:   s.MetadataURL(),
+			SPNameQualifier: sp.EntityID,
+			Value:           participant.NameID,
+		},
+		SessionIndex: &saml.SessionIndex{Value: participant.ID.String()},
+	}
+	message, err := elementBytes(logoutRequest.Element())
+	if err != nil {
+		return "", err
+	}
+	query, err := signRedirectQuery(s.keys.Active(), "SAMLRequest", message, "")
+	if err != nil {
+		return "", err
+	}
+	return withQuery(endpoint.Location, query), nil
+}
+
+// MakeLogoutResponse answers a LogoutRequest with the given status, using the
+// service provider's HTTP-Redirect endpoint if it has one and HTTP-POST
+// otherwise
+func (s *SAMLService) MakeLogoutResponse(req *LogoutRequest, status string) (*LogoutResponse, error) {
+	endpoint := logoutEndpoint(req.Metadata, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if endpoint == nil {
+		return nil, fmt.Errorf("service provider %s has no single logout endpoint", req.ServiceProvider.EntityID)
+	}
+	destination := endpoint.Location
+	if endpoint.ResponseLocation != "" {
+		destination = endpoint.ResponseLocation
+	}
+
+	response := saml.LogoutResponse{
+		ID:           newMessageID(),
+		InResponseTo: req.Request.ID,
+		Version:      "2.0",
+		IssueInstant: saml.TimeNow(),
+		Destination:  destination,
+		Issuer:       s.issuer(),
+		Status:       saml.Status{StatusCode: saml.StatusCode{Value: status}},
+	}
+
+	key := s.keys.Active()
+	if endpoint.Binding == saml.HTTPRedirectBinding {
+		message, err := elementBytes(response.Element())
+		if err != nil {
+			return nil, err
+		}
+		query, err := signRedirectQuery(key, "SAMLResponse", message, req.RelayState)
+		if err != nil {
+			return nil, err
+		}
+		return &LogoutResponse{RedirectURL: withQuery(destination, query)}, nil
+	}
+
+	signature, err := signElement(key, response.Element())
+	if err != nil {
+		return nil, err
+	}
+	response.Signature = signature
+	message, err := elementBytes(response.Element())
+	if err != nil {
+		return nil, err
+	}
+	return &LogoutResponse{
+		PostURL:      destination,
+		SAMLResponse: base64.StdEncoding.EncodeToString(message),
+		RelayState:   req.RelayState,
+	}, nil
+}
+
+func (s *SAMLService) issuer() *saml.Issuer {
+	return &saml.Issuer{
+		Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+		Value:  s.MetadataURL(),
+	}
+}
+
+func newMessageID() string {
+	return "id-" + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+func elementBytes(el *etree.Element) ([]byte, error) {
+	doc := etree.NewDocument()
+	doc.SetRoot(el)
+	message, err := doc.WriteToBytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	return message, nil
+}
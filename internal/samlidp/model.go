@@ -0,0 +1,78 @@
+package samlidp
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ServiceProvider is an application registered to sign in through this IdP
+type ServiceProvider struct {
+	ID       uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	EntityID string    `json:"entityId" gorm:"uniqueIndex;not null;column:entity_id"`
+	Name     string    `json:"name" gorm:"not null"`
+	// Metadata is the SP's SAML metadata document as registered
+	Metadata     string `json:"metadata" gorm:"type:text;not null"`
+	NameIDFormat string `json:"nameIdFormat" gorm:"not null;default:'email';column:name_id_format"`
+	// AttributeMapping maps assertion attribute names to user attributes,
+	// stored as JSON
+	AttributeMapping string    `json:"-" gorm:"type:text;column:attribute_mapping"`
+	IsActive         bool      `json:"isActive" gorm:"default:true"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+func (sp *ServiceProvider) BeforeCreate(tx *gorm.DB) error {
+	if sp.ID == uuid.Nil {
+		sp.ID = uuid.New()
+	}
+	return nil
+}
+
+func (sp *ServiceProvider) TableName() string {
+	return "saml_service_providers"
+}
+
+// PendingRequest holds an AuthnRequest while the user signs in
+type PendingRequest struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Request    []byte    `gorm:"not null"`
+	RelayState string
+	ReceivedAt time.Time `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+}
+
+func (r *PendingRequest) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (r *PendingRequest) TableName() string {
+	return "saml_pending_requests"
+}
+
+// Participant records that a service provider received an assertion for a
+// login session, so that single logout can reach it. Its ID is the
+// SessionIndex given to the SP.
+type Participant struct {
+	ID                uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	SessionID         uuid.UUID `gorm:"type:uuid;not null;index;column:session_id"`
+	ServiceProviderID uuid.UUID `gorm:"type:uuid;not null;column:service_provider_id"`
+	NameID            string    `gorm:"not null;column:name_id"`
+	NameIDFormat      string    `gorm:"not null;column:name_id_format"`
+	CreatedAt         time.Time
+}
+
+func (p *Participant) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+func (p *Participant) TableName() string {
+	return "saml_participants"
+}
@@ -0,0 +1,516 @@
+package samlidp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/keyring"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/session"
+	"idmapp-go/internal/user"
+	"idmapp-go/models"
+
+	"github.com/crewjam/saml"
+	"github.com/google/uuid"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// pendingRequestTTL is how long a user has to sign in before an
+// SP-initiated request is dropped
+const pendingRequestTTL = 10 * time.Minute
+
+var (
+	ErrInvalidMetadata        = errors.New("invalid service provider metadata")
+	ErrUnknownServiceProvider = errors.New("unknown service provider")
+	ErrRequestExpired         = errors.New("SAML request expired")
+	ErrAccountDisabled        = errors.New("account cannot sign in")
+)
+
+type SAMLService struct {
+	db             *gorm.DB
+	sessionService *session.SessionService
+	keys           *keyring.KeyRing
+	baseURL        string
+	logger         *logrus.Logger
+}
+
+// NewSAMLService serves the IdP under baseURL + "/saml"; its entity ID is
+// the metadata URL
+func NewSAMLService(db *gorm.DB, sessionService *session.SessionService, keys *keyring.KeyRing, baseURL string) *SAMLService {
+	return &SAMLService{
+		db:             db,
+		sessionService: sessionService,
+		keys:           keys,
+		baseURL:        strings.TrimSuffix(baseURL, "/"),
+		logger:         logrus.New(),
+	}
+}
+
+func (s *SAMLService) MetadataURL() string {
+	return s.baseURL + "/saml/metadata"
+}
+
+func (s *SAMLService) SSOURL() string {
+	return s.baseURL + "/saml/sso"
+}
+
+func (s *SAMLService) LogoutURL() string {
+	return s.baseURL + "/saml/slo"
+}
+
+func (s *SAMLService) LaunchURL(id uuid.UUID) string {
+	return s.baseURL + "/saml/launch/" + id.String()
+}
+
+// IdentityProvider returns the IdP signing with the currently active key
+func (s *SAMLService) IdentityProvider() *saml.IdentityProvider {
+	key := s.keys.Active()
+	metadataURL, _ := url.Parse(s.MetadataURL())
+	ssoURL, _ := url.Parse(s.SSOURL())
+	logoutURL, _ := url.Parse(s.LogoutURL())
+	return &saml.IdentityProvider{
+		Key:                     key.PrivateKey,
+		Certificate:             key.Certificate,
+		Logger:                  s.logger,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		LogoutURL:               *logoutURL,
+		ServiceProviderProvider: metadataProvider{s},
+		SignatureMethod:         dsig.RSASHA256SignatureMethod,
+	}
+}
+
+// Metadata describes the IdP. Every key in the key ring is published so that
+// service providers keep trusting assertions across a key rotation.
+func (s *SAMLService) Metadata() *saml.EntityDescriptor {
+	metadata := s.IdentityProvider().Metadata()
+	descriptor := &metadata.IDPSSODescriptors[0]
+
+	descriptor.KeyDescriptors = nil
+	for _, key := range s.keys.Keys() {
+		descriptor.KeyDescriptors = append(descriptor.KeyDescriptors, saml.KeyDescriptor{
+			Use: "signing",
+			KeyInfo: saml.KeyInfo{
+				X509Data: saml.X509Data{
+					X509Certificates: []saml.X509Certificate{{Data: encodeCertificate(key)}},
+				},
+			},
+		})
+	}
+
+	descriptor.NameIDFormats = nil
+	for _, format := range []string{NameIDFormatEmail, NameIDFormatPersistent, NameIDFormatTransient, NameIDFormatUnspecified} {
+		descriptor.NameIDFormats = append(descriptor.NameIDFormats, saml.NameIDFormat(nameIDFormatURNs[format]))
+	}
+
+	descriptor.SingleLogoutServices = []saml.Endpoint{
+		{Binding: saml.HTTPRedirectBinding, Location: s.LogoutURL()},
+		{Binding: saml.HTTPPostBinding, Location: s.LogoutURL()},
+	}
+	return metadata
+}
+
+// metadataProvider looks up the metadata of active service providers for
+// the saml package
+type metadataProvider struct {
+	s *SAMLService
+}
+
+func (p metadataProvider) GetServiceProvider(r *http.Request, entityID string) (*saml.EntityDescriptor, error) {
+	sp, err := p.s.getActiveServiceProvider(entityID)
+	if err != nil {
+		return nil, err
+	}
+	if sp == nil {
+		return nil, os.ErrNotExist
+	}
+	return parseMetadata(sp.Metadata)
+}
+
+func (s *SAMLService) getActiveServiceProvider(entityID string) (*ServiceProvider, error) {
+	var sp ServiceProvider
+	if err := s.db.Where("entity_id = ? AND is_active = ?", entityID, true).First(&sp).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get service provider: %w", err)
+	}
+	return &sp, nil
+}
+
+// Service provider registration
+
+func (s *SAMLService) GetAllServiceProviders() ([]ServiceProviderResponse, error) {
+	var sps []ServiceProvider
+	if err := s.db.Order("name").Find(&sps).Error; err != nil {
+		return nil, fmt.Errorf("failed to get service providers: %w", err)
+	}
+	responses := make([]ServiceProviderResponse, 0, len(sps))
+	for i := range sps {
+		responses = append(responses, s.toResponse(&sps[i]))
+	}
+	return responses, nil
+}
+
+func (s *SAMLService) GetServiceProvider(id uuid.UUID) (*ServiceProviderResponse, error) {
+	sp, err := s.getServiceProvider(id)
+	if err != nil || sp == nil {
+		return nil, err
+	}
+	response := s.toResponse(sp)
+	return &response, nil
+}
+
+func (s *SAMLService) getServiceProvider(id uuid.UUID) (*ServiceProvider, error) {
+	var sp ServiceProvider
+	if err := s.db.First(&sp, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get service provider: %w", err)
+	}
+	return &sp, nil
+}
+
+func (s *SAMLService) CreateServiceProvider(req ServiceProviderCreateRequest) (*ServiceProviderResponse, error) {
+	metadata, err := parseMetadata(req.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateAttributeMapping(req.AttributeMapping); err != nil {
+		return nil, err
+	}
+	mapping, err := json.Marshal(req.AttributeMapping)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode attribute mapping: %w", err)
+	}
+
+	var existing ServiceProvider
+	if err := s.db.Where("entity_id = ?", metadata.EntityID).First(&existing).Error; err == nil {
+		return nil, errors.New("service provider with this entity ID already exists")
+	}
+
+	sp := ServiceProvider{
+		EntityID:         metadata.EntityID,
+		Name:             req.Name,
+		Metadata:         req.Metadata,
+		NameIDFormat:     req.NameIDFormat,
+		AttributeMapping: string(mapping),
+		IsActive:         true,
+	}
+	if sp.NameIDFormat == "" {
+		sp.NameIDFormat = NameIDFormatEmail
+	}
+	if err := s.db.Create(&sp).Error; err != nil {
+		return nil, fmt.Errorf("failed to create service provider: %w", err)
+	}
+
+	response := s.toResponse(&sp)
+	return &response, nil
+}
+
+func (s *SAMLService) UpdateServiceProvider(id uuid.UUID, req ServiceProviderUpdateRequest) (*ServiceProviderResponse, error) {
+	sp, err := s.getServiceProvider(id)
+	if err != nil || sp == nil {
+		return nil, err
+	}
+
+	if req.Metadata != "" {
+		metadata, err := parseMetadata(req.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		var existing ServiceProvider
+		if err := s.db.Where("entity_id = ? AND id != ?", metadata.EntityID, id).First(&existing).Error; err == nil {
+			return nil, errors.New("entity ID is already registered to another service provider")
+		}
+		sp.EntityID = metadata.EntityID
+		sp.Metadata = req.Metadata
+	}
+	if req.Name != "" {
+		sp.Name = req.Name
+	}
+	if req.NameIDFormat != "" {
+		sp.NameIDFormat = req.NameIDFormat
+	}
+	if req.AttributeMapping != nil {
+		if err := validateAttributeMapping(req.AttributeMapping); err != nil {
+			return nil, err
+		}
+		mapping, err := json.Marshal(req.AttributeMapping)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode attribute mapping: %w", err)
+		}
+		sp.AttributeMapping = string(mapping)
+	}
+	if req.IsActive != nil {
+		sp.IsActive = *req.IsActive
+	}
+
+	if err := s.db.Save(sp).Error; err != nil {
+		return nil, fmt.Errorf("failed to update service provider: %w", err)
+	}
+	response := s.toResponse(sp)
+	return &response, nil
+}
+
+func (s *SAMLService) DeleteServiceProvider(id uuid.UUID) (bool, error) {
+	deleted := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&ServiceProvider{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		deleted = result.RowsAffected > 0
+		return tx.Delete(&Participant{}, "service_provider_id = ?", id).Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete service provider: %w", err)
+	}
+	return deleted, nil
+}
+
+func (s *SAMLService) toResponse(sp *ServiceProvider) ServiceProviderResponse {
+	return ServiceProviderResponse{
+		ID:               sp.ID.String(),
+		EntityID:         sp.EntityID,
+		Name:             sp.Name,
+		NameIDFormat:     sp.NameIDFormat,
+		AttributeMapping: sp.attributeMapping(),
+		IsActive:         sp.IsActive,
+		LaunchURL:        s.LaunchURL(sp.ID),
+		CreatedAt:        sp.CreatedAt,
+		UpdatedAt:        sp.UpdatedAt,
+	}
+}
+
+func (sp *ServiceProvider) attributeMapping() map[string]string {
+	var mapping map[string]string
+	if sp.AttributeMapping != "" {
+		json.Unmarshal([]byte(sp.AttributeMapping), &mapping)
+	}
+	return mapping
+}
+
+// parseMetadata reads an SP metadata document, which must name at least one
+// assertion consumer service
+func parseMetadata(document string) (*saml.EntityDescriptor, error) {
+	var metadata saml.EntityDescriptor
+	if err := xml.Unmarshal([]byte(document), &metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	if metadata.EntityID == "" {
+		return nil, fmt.Errorf("%w: entityID is required", ErrInvalidMetadata)
+	}
+	for _, descriptor := range metadata.SPSSODescriptors {
+		if len(descriptor.AssertionConsumerServices) > 0 {
+			if _, err := signingCertificates(&metadata); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+			}
+			return &metadata, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no AssertionConsumerService", ErrInvalidMetadata)
+}
+
+// Single sign-on
+
+// SavePendingRequest keeps an AuthnRequest until the user has signed in and
+// returns its ID
+func (s *SAMLService) SavePendingRequest(req *saml.IdpAuthnRequest) (uuid.UUID, error) {
+	pending := PendingRequest{
+		Request:    req.RequestBuffer,
+		RelayState: req.RelayState,
+		ReceivedAt: req.Now,
+		ExpiresAt:  time.Now().Add(pendingRequestTTL),
+	}
+	if err := s.db.Create(&pending).Error; err != nil {
+		return uuid.Nil, fmt.Errorf("failed to store SAML request: %w", err)
+	}
+
+	// Clean up requests of users who never signed in
+	if err := s.db.Where("expires_at < ?", time.Now()).Delete(&PendingRequest{}).Error; err != nil {
+		s.logger.Warnf("Failed to purge expired SAML requests: %v", err)
+	}
+	return pending.ID, nil
+}
+
+// ResumeRequest takes a pending AuthnRequest back. It is validated as of the
+// time it was received.
+func (s *SAMLService) ResumeRequest(id uuid.UUID, r *http.Request) (*saml.IdpAuthnRequest, error) {
+	var pending PendingRequest
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&pending, "id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&pending).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrRequestExpired
+		}
+		return nil, fmt.Errorf("failed to get SAML request: %w", err)
+	}
+	if time.Now().After(pending.ExpiresAt) {
+		return nil, ErrRequestExpired
+	}
+
+	req := &saml.IdpAuthnRequest{
+		IDP:           s.IdentityProvider(),
+		HTTPRequest:   r,
+		RequestBuffer: pending.Request,
+		RelayState:    pending.RelayState,
+		Now:           pending.ReceivedAt,
+	}
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+	req.Now = saml.TimeNow()
+	return req, nil
+}
+
+// LaunchRequest starts IdP-initiated sign-in to a registered service
+// provider, posting to its first HTTP-POST assertion consumer service
+func (s *SAMLService) LaunchRequest(id uuid.UUID, relayState string, r *http.Request) (*saml.IdpAuthnRequest, error) {
+	sp, err := s.getServiceProvider(id)
+	if err != nil {
+		return nil, err
+	}
+	if sp == nil || !sp.IsActive {
+		return nil, ErrUnknownServiceProvider
+	}
+	metadata, err := parseMetadata(sp.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &saml.IdpAuthnRequest{
+		IDP:                     s.IdentityProvider(),
+		HTTPRequest:             r,
+		RelayState:              relayState,
+		Now:                     saml.TimeNow(),
+		ServiceProviderMetadata: metadata,
+	}
+	for i := range metadata.SPSSODescriptors {
+		descriptor := &metadata.SPSSODescriptors[i]
+		for j := range descriptor.AssertionConsumerServices {
+			if descriptor.AssertionConsumerServices[j].Binding == saml.HTTPPostBinding {
+				req.SPSSODescriptor = descriptor
+				req.ACSEndpoint = &descriptor.AssertionConsumerServices[j]
+				return req, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("service provider %s has no HTTP-POST assertion consumer service", sp.EntityID)
+}
+
+// MakeAssertion builds the assertion about the session's user for the
+// request's service provider and records the SP as a session participant
+func (s *SAMLService) MakeAssertion(req *saml.IdpAuthnRequest, loginSession *session.Session) error {
+	sp, err := s.getActiveServiceProvider(req.ServiceProviderMetadata.EntityID)
+	if err != nil {
+		return err
+	}
+	if sp == nil {
+		return ErrUnknownServiceProvider
+	}
+
+	subject, err := s.getSubject(loginSession.UserID)
+	if err != nil {
+		return err
+	}
+	if subject.User.StatusError() != nil {
+		return ErrAccountDisabled
+	}
+
+	nameIDValue, nameIDFormat, err := nameID(sp.NameIDFormat, subject.User)
+	if err != nil {
+		return err
+	}
+	participant := Participant{
+		SessionID:         loginSession.ID,
+		ServiceProviderID: sp.ID,
+		NameID:            nameIDValue,
+		NameIDFormat:      nameIDFormat,
+	}
+	if err := s.db.Create(&participant).Error; err != nil {
+		return fmt.Errorf("failed to record session participant: %w", err)
+	}
+
+	samlSession := &saml.Session{
+		ID:           participant.ID.String(),
+		CreateTime:   loginSession.CreatedAt,
+		ExpireTime:   loginSession.ExpiresAt,
+		Index:        participant.ID.String(),
+		NameID:       nameIDValue,
+		NameIDFormat: nameIDFormat,
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, samlSession); err != nil {
+		return err
+	}
+
+	// Release exactly the mapped attributes
+	req.Assertion.AttributeStatements = nil
+	if attributes := buildAttributes(sp.attributeMapping(), *subject); len(attributes) > 0 {
+		req.Assertion.AttributeStatements = []saml.AttributeStatement{{Attributes: attributes}}
+	}
+
+	events.Publish(events.Event{
+		Type:    "saml.assertion.issued",
+		Subject: loginSession.UserID.String(),
+		Actor:   loginSession.UserID.String(),
+		Data: map[string]interface{}{
+			"serviceProvider": sp.EntityID,
+			"sessionId":       loginSession.ID.String(),
+		},
+	})
+	return nil
+}
+
+// getSubject loads the user together with the names of their groups, nested
+// ones included, and of the roles they hold directly or through a group
+func (s *SAMLService) getSubject(userID uuid.UUID) (*Subject, error) {
+	var u user.User
+	if err := s.db.First(&u, "id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	subject := &Subject{User: &u}
+	err := s.db.Raw(`WITH RECURSIVE `+member.UserGroupsSQL+`
+		SELECT DISTINCT groups.name FROM groups
+		JOIN user_groups ON user_groups.group_id = groups.id
+		ORDER BY groups.name`,
+		userID, member.MaxNestingDepth).
+		Find(&subject.Groups).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get groups: %w", err)
+	}
+	err = s.db.Raw(`WITH RECURSIVE `+member.UserGroupsSQL+`
+		SELECT DISTINCT roles.name FROM roles
+		JOIN role_members ON role_members.role_id = roles.id
+		WHERE `+models.InEffectSQL("role_members")+`
+		AND ((role_members.type = 'USER' AND role_members.entity_id = ?) OR
+			(role_members.type = 'GROUP' AND role_members.entity_id IN (SELECT group_id FROM user_groups)))
+		ORDER BY roles.name`,
+		userID, member.MaxNestingDepth, userID).
+		Find(&subject.Roles).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+	return subject, nil
+}
+
+func encodeCertificate(key *keyring.Key) string {
+	return base64.StdEncoding.EncodeToString(key.Certificate.Raw)
+}
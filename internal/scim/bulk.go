@@ -0,0 +1,270 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type BulkRequest struct {
+	Schemas []string `json:"schemas"`
+	// FailOnErrors stops processing after this many errors; 0 processes all
+	// operations
+	FailOnErrors int             `json:"failOnErrors,omitempty"`
+	Operations   []BulkOperation `json:"Operations"`
+}
+
+type BulkOperation struct {
+	Method  string          `json:"method"`
+	BulkID  string          `json:"bulkId,omitempty"`
+	Version string          `json:"version,omitempty"`
+	Path    string          `json:"path"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+type BulkResponse struct {
+	Schemas    []string                `json:"schemas"`
+	Operations []BulkOperationResponse `json:"Operations"`
+}
+
+type BulkOperationResponse struct {
+	Method   string      `json:"method"`
+	BulkID   string      `json:"bulkId,omitempty"`
+	Version  string      `json:"version,omitempty"`
+	Location string      `json:"location,omitempty"`
+	Status   string      `json:"status"`
+	Response interface{} `json:"response,omitempty"`
+}
+
+var bulkIDReference = regexp.MustCompile(`bulkId:([^"/\s]+)`)
+
+// resolveBulkIDs replaces "bulkId:<id>" references to resources created
+// earlier in the request with their IDs
+func resolveBulkIDs(value string, ids map[string]string) (string, error) {
+	var missing string
+	resolved := bulkIDReference.ReplaceAllStringFunc(value, func(reference string) string {
+		bulkID := strings.TrimPrefix(reference, "bulkId:")
+		id, ok := ids[bulkID]
+		if !ok {
+			if missing == "" {
+				missing = bulkID
+			}
+			return reference
+		}
+		return id
+	})
+	if missing != "" {
+		return "", &Error{Status: http.StatusConflict, ScimType: ErrorInvalidValue, Detail: "unresolved reference to bulkId " + missing}
+	}
+	return resolved, nil
+}
+
+// parseBulkPath splits a bulk operation path such as /Users/{id} into the
+// endpoint and resource ID
+func parseBulkPath(path string) (string, string, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) > 2 || (parts[0] != "Users" && parts[0] != "Groups") {
+		return "", "", badRequest(ErrorInvalidPath, "unsupported bulk path %q", path)
+	}
+	if len(parts) == 2 {
+		return parts[0], parts[1], nil
+	}
+	return parts[0], "", nil
+}
+
+// Bulk runs the operations of a bulk request in order (RFC 7644 section
+// 3.7). Later operations may refer to resources created by earlier ones
+// as "bulkId:<bulkId>".
+func (s *SCIMService) Bulk(req *BulkRequest, actor string) (*BulkResponse, error) {
+	if !containsFold(req.Schemas, SchemaBulkRequest) {
+		return nil, badRequest(ErrorInvalidSyntax, "request must use the %s schema", SchemaBulkRequest)
+	}
+	if len(req.Operations) > maxBulkOperations {
+		return nil, &Error{Status: http.StatusRequestEntityTooLarge, Detail: "too many operations; maxOperations is " + strconv.Itoa(maxBulkOperations)}
+	}
+
+	response := &BulkResponse{Schemas: []string{SchemaBulkResponse}, Operations: []BulkOperationResponse{}}
+	ids := make(map[string]string)
+	failures := 0
+	for _, op := range req.Operations {
+		if req.FailOnErrors > 0 && failures >= req.FailOnErrors {
+			break
+		}
+		result := s.runBulkOperation(op, ids, actor)
+		if result.Status[0] != '2' {
+			failures++
+		}
+		response.Operations = append(response.Operations, result)
+	}
+	return response, nil
+}
+
+func (s *SCIMService) runBulkOperation(op BulkOperation, ids map[string]string, actor string) BulkOperationResponse {
+	result := BulkOperationResponse{Method: strings.ToUpper(op.Method), BulkID: op.BulkID}
+	status, location, version, err := s.executeBulkOperation(op, ids, actor)
+	if err != nil {
+		var scimErr *Error
+		if !errors.As(err, &scimErr) {
+			s.logger.Errorf("SCIM bulk %s %s failed: %v", op.Method, op.Path, err)
+			scimErr = &Error{Status: http.StatusInternalServerError, Detail: "internal error"}
+		}
+		result.Status = strconv.Itoa(scimErr.Status)
+		result.Response = scimErr.Response()
+		return result
+	}
+	result.Status = strconv.Itoa(status)
+	result.Location = location
+	result.Version = version
+	return result
+}
+
+func (s *SCIMService) executeBulkOperation(op BulkOperation, ids map[string]string, actor string) (int, string, string, error) {
+	path, err := resolveBulkIDs(op.Path, ids)
+	if err != nil {
+		return 0, "", "", err
+	}
+	endpoint, id, err := parseBulkPath(path)
+	if err != nil {
+		return 0, "", "", err
+	}
+	data, err := resolveBulkIDs(string(op.Data), ids)
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	method := strings.ToUpper(op.Method)
+	if (method == http.MethodPost) != (id == "") {
+		return 0, "", "", badRequest(ErrorInvalidPath, "%s is not supported on %q", method, op.Path)
+	}
+
+	var resource interface{}
+	switch method {
+	case http.MethodPost:
+		if op.BulkID == "" {
+			return 0, "", "", badRequest(ErrorInvalidValue, "bulkId is required for POST")
+		}
+		created, createdID, err := s.bulkCreate(endpoint, data, actor)
+		if err != nil {
+			return 0, "", "", err
+		}
+		ids[op.BulkID] = createdID
+		location, version := meta(created)
+		return http.StatusCreated, location, version, nil
+	case http.MethodPut:
+		resource, err = s.bulkReplace(endpoint, id, data, op.Version, actor)
+	case http.MethodPatch:
+		resource, err = s.bulkPatch(endpoint, id, data, op.Version, actor)
+	case http.MethodDelete:
+		var deleted bool
+		if endpoint == "Users" {
+			deleted, err = s.DeleteUser(id, op.Version, actor)
+		} else {
+			deleted, err = s.DeleteGroup(id, op.Version)
+		}
+		if err == nil && !deleted {
+			err = notFound("resource %s not found", id)
+		}
+		if err != nil {
+			return 0, "", "", err
+		}
+		return http.StatusNoContent, "", "", nil
+	default:
+		return 0, "", "", badRequest(ErrorInvalidSyntax, "unsupported method %q", op.Method)
+	}
+	if err != nil {
+		return 0, "", "", err
+	}
+	if resource == nil {
+		return 0, "", "", notFound("resource %s not found", id)
+	}
+	location, version := meta(resource)
+	return http.StatusOK, location, version, nil
+}
+
+func (s *SCIMService) bulkCreate(endpoint, data, actor string) (interface{}, string, error) {
+	if endpoint == "Users" {
+		var resource User
+		if err := decodeResource(data, &resource); err != nil {
+			return nil, "", err
+		}
+		created, err := s.CreateUser(&resource, actor)
+		if err != nil {
+			return nil, "", err
+		}
+		return created, created.ID, nil
+	}
+	var resource Group
+	if err := decodeResource(data, &resource); err != nil {
+		return nil, "", err
+	}
+	created, err := s.CreateGroup(&resource)
+	if err != nil {
+		return nil, "", err
+	}
+	return created, created.ID, nil
+}
+
+// bulkReplace and bulkPatch return a nil interface, not a typed nil, when
+// the resource does not exist
+func (s *SCIMService) bulkReplace(endpoint, id, data, version, actor string) (interface{}, error) {
+	if endpoint == "Users" {
+		var resource User
+		if err := decodeResource(data, &resource); err != nil {
+			return nil, err
+		}
+		replaced, err := s.ReplaceUser(id, &resource, version, actor)
+		if err != nil || replaced == nil {
+			return nil, err
+		}
+		return replaced, nil
+	}
+	var resource Group
+	if err := decodeResource(data, &resource); err != nil {
+		return nil, err
+	}
+	replaced, err := s.ReplaceGroup(id, &resource, version)
+	if err != nil || replaced == nil {
+		return nil, err
+	}
+	return replaced, nil
+}
+
+func (s *SCIMService) bulkPatch(endpoint, id, data, version, actor string) (interface{}, error) {
+	var req PatchRequest
+	if err := decodeResource(data, &req); err != nil {
+		return nil, err
+	}
+	if endpoint == "Users" {
+		patched, err := s.PatchUser(id, &req, version, actor)
+		if err != nil || patched == nil {
+			return nil, err
+		}
+		return patched, nil
+	}
+	patched, err := s.PatchGroup(id, &req, version)
+	if err != nil || patched == nil {
+		return nil, err
+	}
+	return patched, nil
+}
+
+func decodeResource(data string, target interface{}) error {
+	if err := json.Unmarshal([]byte(data), target); err != nil {
+		return badRequest(ErrorInvalidSyntax, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+// meta returns the location and version of a rendered resource
+func meta(resource interface{}) (string, string) {
+	switch r := resource.(type) {
+	case *User:
+		return r.Meta.Location, r.Meta.Version
+	case *Group:
+		return r.Meta.Location, r.Meta.Version
+	}
+	return "", ""
+}
@@ -0,0 +1,86 @@
+package scim
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveBulkIDs(t *testing.T) {
+	ids := map[string]string{"jane": "5d0b1c2e-0000-4000-8000-000000000001"}
+
+	resolved, err := resolveBulkIDs(`{"members": [{"value": "bulkId:jane"}]}`, ids)
+	require.NoError(t, err)
+	assert.Equal(t, `{"members": [{"value": "5d0b1c2e-0000-4000-8000-000000000001"}]}`, resolved)
+
+	resolved, err = resolveBulkIDs("/Users/bulkId:jane", ids)
+	require.NoError(t, err)
+	assert.Equal(t, "/Users/5d0b1c2e-0000-4000-8000-000000000001", resolved)
+
+	_, err = resolveBulkIDs(`{"value": "bulkId:richard"}`, ids)
+	var scimErr *Error
+	require.ErrorAs(t, err, &scimErr)
+	assert.Equal(t, http.StatusConflict, scimErr.Status)
+}
+
+func TestParseBulkPath(t *testing.T) {
+	endpoint, id, err := parseBulkPath("/Users")
+	require.NoError(t, err)
+	assert.Equal(t, "Users", endpoint)
+	assert.Equal(t, "", id)
+
+	endpoint, id, err = parseBulkPath("/Groups/abc")
+	require.NoError(t, err)
+	assert.Equal(t, "Groups", endpoint)
+	assert.Equal(t, "abc", id)
+
+	for _, path := range []string{"/Roles", "/Users/abc/members", ""} {
+		_, _, err := parseBulkPath(path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestBulkRejectsInvalidRequests(t *testing.T) {
+	s := &SCIMService{}
+
+	_, err := s.Bulk(&BulkRequest{}, "")
+	assert.Error(t, err)
+
+	_, err = s.Bulk(&BulkRequest{
+		Schemas:    []string{SchemaBulkRequest},
+		Operations: make([]BulkOperation, maxBulkOperations+1),
+	}, "")
+	var scimErr *Error
+	require.ErrorAs(t, err, &scimErr)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, scimErr.Status)
+}
+
+func TestProjectAndVersion(t *testing.T) {
+	u := testUser()
+	u.ID = "u1"
+
+	projected, err := project(u, []string{"userName", "name.givenName"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"schemas":  []interface{}{SchemaUser},
+		"id":       "u1",
+		"userName": "jane@example.org",
+		"name":     map[string]interface{}{"givenName": "Jane"},
+	}, projected)
+
+	projected, err = project(u, nil, []string{"emails", "name.formatted", "id"})
+	require.NoError(t, err)
+	fields := projected.(map[string]interface{})
+	assert.NotContains(t, fields, "emails")
+	assert.Equal(t, map[string]interface{}{"givenName": "Jane", "familyName": "Doe"}, fields["name"])
+	assert.Equal(t, "u1", fields["id"])
+
+	v := version(u)
+	assert.True(t, matchesVersion(v, v))
+	assert.True(t, matchesVersion(`"other", `+v[2:], v))
+	assert.True(t, matchesVersion("*", v))
+	u.DisplayName = "Janet"
+	assert.False(t, matchesVersion(v, version(u)))
+}
@@ -0,0 +1,208 @@
+package scim
+
+// Limits advertised in the service provider configuration
+const (
+	maxBulkOperations  = 100
+	maxBulkPayloadSize = 1 << 20
+	maxFilterResults   = 1000
+	defaultCount       = 100
+)
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type bulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type filterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	SpecURI     string `json:"specUri,omitempty"`
+	Primary     bool   `json:"primary"`
+}
+
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	DocumentationURI      string                 `json:"documentationUri,omitempty"`
+	Patch                 supported              `json:"patch"`
+	Bulk                  bulkSupport            `json:"bulk"`
+	Filter                filterSupport          `json:"filter"`
+	ChangePassword        supported              `json:"changePassword"`
+	Sort                  supported              `json:"sort"`
+	ETag                  supported              `json:"etag"`
+	AuthenticationSchemes []authenticationScheme `json:"authenticationSchemes"`
+	Meta                  *Meta                  `json:"meta"`
+}
+
+func (s *SCIMService) ServiceProviderConfig() *ServiceProviderConfig {
+	return &ServiceProviderConfig{
+		Schemas:        []string{SchemaServiceProviderConfig},
+		Patch:          supported{Supported: true},
+		Bulk:           bulkSupport{Supported: true, MaxOperations: maxBulkOperations, MaxPayloadSize: maxBulkPayloadSize},
+		Filter:         filterSupport{Supported: true, MaxResults: maxFilterResults},
+		ChangePassword: supported{Supported: true},
+		Sort:           supported{Supported: true},
+		ETag:           supported{Supported: true},
+		AuthenticationSchemes: []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "OAuth Bearer Token",
+			Description: "Access token issued to a client with the client_credentials grant and the scim scope",
+			SpecURI:     "https://www.rfc-editor.org/rfc/rfc6750",
+			Primary:     true,
+		}},
+		Meta: &Meta{ResourceType: "ServiceProviderConfig", Location: s.location("ServiceProviderConfig", "")},
+	}
+}
+
+type ResourceType struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Endpoint    string   `json:"endpoint"`
+	Description string   `json:"description"`
+	Schema      string   `json:"schema"`
+	Meta        *Meta    `json:"meta"`
+}
+
+func (s *SCIMService) ResourceTypes() []ResourceType {
+	return []ResourceType{
+		{
+			Schemas:     []string{SchemaResourceType},
+			ID:          "User",
+			Name:        "User",
+			Endpoint:    "/Users",
+			Description: "User account",
+			Schema:      SchemaUser,
+			Meta:        &Meta{ResourceType: "ResourceType", Location: s.location("ResourceTypes", "User")},
+		},
+		{
+			Schemas:     []string{SchemaResourceType},
+			ID:          "Group",
+			Name:        "Group",
+			Endpoint:    "/Groups",
+			Description: "Group of users",
+			Schema:      SchemaGroup,
+			Meta:        &Meta{ResourceType: "ResourceType", Location: s.location("ResourceTypes", "Group")},
+		},
+	}
+}
+
+type schemaAttribute struct {
+	Name           string            `json:"name"`
+	Type           string            `json:"type"`
+	MultiValued    bool              `json:"multiValued"`
+	Required       bool              `json:"required"`
+	CaseExact      bool              `json:"caseExact"`
+	Mutability     string            `json:"mutability"`
+	Returned       string            `json:"returned"`
+	Uniqueness     string            `json:"uniqueness"`
+	ReferenceTypes []string          `json:"referenceTypes,omitempty"`
+	SubAttributes  []schemaAttribute `json:"subAttributes,omitempty"`
+}
+
+type Schema struct {
+	Schemas     []string          `json:"schemas"`
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Attributes  []schemaAttribute `json:"attributes"`
+	Meta        *Meta             `json:"meta"`
+}
+
+func attr(name, attrType string) schemaAttribute {
+	return schemaAttribute{Name: name, Type: attrType, Mutability: "readWrite", Returned: "default", Uniqueness: "none"}
+}
+
+func (a schemaAttribute) required() schemaAttribute {
+	a.Required = true
+	return a
+}
+
+func (a schemaAttribute) unique() schemaAttribute {
+	a.Uniqueness = "server"
+	return a
+}
+
+func (a schemaAttribute) caseExact() schemaAttribute {
+	a.CaseExact = true
+	return a
+}
+
+func (a schemaAttribute) mutability(mutability, returned string) schemaAttribute {
+	a.Mutability, a.Returned = mutability, returned
+	return a
+}
+
+func (a schemaAttribute) multi(subAttributes ...schemaAttribute) schemaAttribute {
+	a.MultiValued = true
+	a.SubAttributes = subAttributes
+	return a
+}
+
+func (a schemaAttribute) sub(subAttributes ...schemaAttribute) schemaAttribute {
+	a.SubAttributes = subAttributes
+	return a
+}
+
+func (a schemaAttribute) references(types ...string) schemaAttribute {
+	a.ReferenceTypes = types
+	return a
+}
+
+func (s *SCIMService) Schemas() []Schema {
+	return []Schema{
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          SchemaUser,
+			Name:        "User",
+			Description: "User account",
+			Attributes: []schemaAttribute{
+				attr("userName", "string").required().unique(),
+				attr("name", "complex").sub(
+					attr("formatted", "string"),
+					attr("givenName", "string"),
+					attr("familyName", "string"),
+				),
+				attr("displayName", "string"),
+				attr("emails", "complex").multi(
+					attr("value", "string"),
+					attr("type", "string"),
+					attr("primary", "boolean"),
+				),
+				attr("active", "boolean"),
+				attr("password", "string").caseExact().mutability("writeOnly", "never"),
+				attr("groups", "complex").mutability("readOnly", "default").multi(
+					attr("value", "string").mutability("readOnly", "default"),
+					attr("$ref", "reference").references("Group").mutability("readOnly", "default"),
+					attr("display", "string").mutability("readOnly", "default"),
+				),
+			},
+			Meta: &Meta{ResourceType: "Schema", Location: s.location("Schemas", SchemaUser)},
+		},
+		{
+			Schemas:     []string{SchemaSchema},
+			ID:          SchemaGroup,
+			Name:        "Group",
+			Description: "Group of users",
+			Attributes: []schemaAttribute{
+				attr("displayName", "string").required().unique(),
+				attr("members", "complex").multi(
+					attr("value", "string").mutability("immutable", "default"),
+					attr("$ref", "reference").references("User").mutability("immutable", "default"),
+					attr("display", "string").mutability("readOnly", "default"),
+				),
+			},
+			Meta: &Meta{ResourceType: "Schema", Location: s.location("Schemas", SchemaGroup)},
+		},
+	}
+}
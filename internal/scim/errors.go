@@ -0,0 +1,68 @@
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// SCIM error types (RFC 7644 section 3.12)
+const (
+	ErrorInvalidFilter = "invalidFilter"
+	ErrorTooMany       = "tooMany"
+	ErrorUniqueness    = "uniqueness"
+	ErrorMutability    = "mutability"
+	ErrorInvalidSyntax = "invalidSyntax"
+	ErrorInvalidPath   = "invalidPath"
+	ErrorNoTarget      = "noTarget"
+	ErrorInvalidValue  = "invalidValue"
+	ErrorInvalidVers   = "invalidVers"
+)
+
+// Error is a SCIM error response. Service methods return it for errors the
+// client caused; anything else is reported as an internal error.
+type Error struct {
+	Status   int
+	ScimType string
+	Detail   string
+}
+
+func (e *Error) Error() string {
+	if e.ScimType != "" {
+		return fmt.Sprintf("%s: %s", e.ScimType, e.Detail)
+	}
+	return e.Detail
+}
+
+// ErrorResponse is the JSON representation of Error
+type ErrorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+func (e *Error) Response() ErrorResponse {
+	return ErrorResponse{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(e.Status),
+		ScimType: e.ScimType,
+		Detail:   e.Detail,
+	}
+}
+
+func badRequest(scimType, format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusBadRequest, ScimType: scimType, Detail: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusNotFound, Detail: fmt.Sprintf(format, args...)}
+}
+
+func conflict(format string, args ...interface{}) *Error {
+	return &Error{Status: http.StatusConflict, ScimType: ErrorUniqueness, Detail: fmt.Sprintf(format, args...)}
+}
+
+func preconditionFailed() *Error {
+	return &Error{Status: http.StatusPreconditionFailed, ScimType: ErrorInvalidVers, Detail: "resource version does not match"}
+}
@@ -0,0 +1,450 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expression is a node of a parsed filter (RFC 7644 section 3.4.2.2)
+type expression interface{}
+
+// logicalExpression joins two filters with "and" or "or"
+type logicalExpression struct {
+	operator    string
+	left, right expression
+}
+
+type notExpression struct {
+	expression expression
+}
+
+// attributeExpression compares an attribute with a value; value is nil for
+// "pr" and otherwise a string, bool, float64 or nil for null
+type attributeExpression struct {
+	path     string
+	operator string
+	value    interface{}
+}
+
+// valuePathExpression filters the values of a multi-valued attribute, as in
+// emails[type eq "work"]
+type valuePathExpression struct {
+	path   string
+	filter expression
+}
+
+var comparisonOperators = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true, "pr": true,
+}
+
+type tokenKind int
+
+const (
+	tokenWord tokenKind = iota
+	tokenString
+	tokenLParen
+	tokenRParen
+	tokenLBracket
+	tokenRBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(filter string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(filter); {
+		switch c := filter[i]; c {
+		case ' ', '\t', '\n', '\r':
+			i++
+		case '(':
+			tokens = append(tokens, token{tokenLParen, "("})
+			i++
+		case ')':
+			tokens = append(tokens, token{tokenRParen, ")"})
+			i++
+		case '[':
+			tokens = append(tokens, token{tokenLBracket, "["})
+			i++
+		case ']':
+			tokens = append(tokens, token{tokenRBracket, "]"})
+			i++
+		case '"':
+			end := i + 1
+			for end < len(filter) && filter[end] != '"' {
+				if filter[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(filter) {
+				return nil, badRequest(ErrorInvalidFilter, "unterminated string in filter")
+			}
+			var value string
+			if err := json.Unmarshal([]byte(filter[i:end+1]), &value); err != nil {
+				return nil, badRequest(ErrorInvalidFilter, "invalid string %s in filter", filter[i:end+1])
+			}
+			tokens = append(tokens, token{tokenString, value})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t\n\r()[]\"", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, token{tokenWord, filter[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+}
+
+// parseFilter parses a SCIM filter with the and, or and not operators,
+// grouping, value paths and all comparison operators
+func parseFilter(filter string) (expression, error) {
+	tokens, err := tokenize(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, badRequest(ErrorInvalidFilter, "empty filter")
+	}
+	p := &filterParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, badRequest(ErrorInvalidFilter, "unexpected %q in filter", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+func (p *filterParser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *filterParser) peekKeyword(keyword string) bool {
+	t := p.peek()
+	return t != nil && t.kind == tokenWord && strings.EqualFold(t.text, keyword)
+}
+
+func (p *filterParser) expect(kind tokenKind, text string) error {
+	t := p.peek()
+	if t == nil || t.kind != kind {
+		return badRequest(ErrorInvalidFilter, "expected %q in filter", text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpression{operator: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpression{operator: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (expression, error) {
+	if p.peekKeyword("not") {
+		p.pos++
+		if err := p.expect(tokenLParen, "("); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return &notExpression{expression: expr}, nil
+	}
+
+	t := p.peek()
+	if t == nil {
+		return nil, badRequest(ErrorInvalidFilter, "unexpected end of filter")
+	}
+	if t.kind == tokenLParen {
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRParen, ")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+	if t.kind != tokenWord {
+		return nil, badRequest(ErrorInvalidFilter, "expected attribute, got %q", t.text)
+	}
+	path := t.text
+	p.pos++
+
+	if next := p.peek(); next != nil && next.kind == tokenLBracket {
+		p.pos++
+		filter, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRBracket, "]"); err != nil {
+			return nil, err
+		}
+		return &valuePathExpression{path: path, filter: filter}, nil
+	}
+
+	opToken := p.peek()
+	if opToken == nil || opToken.kind != tokenWord || !comparisonOperators[strings.ToLower(opToken.text)] {
+		return nil, badRequest(ErrorInvalidFilter, "expected operator after %q", path)
+	}
+	operator := strings.ToLower(opToken.text)
+	p.pos++
+	if operator == "pr" {
+		return &attributeExpression{path: path, operator: operator}, nil
+	}
+
+	valueToken := p.peek()
+	if valueToken == nil {
+		return nil, badRequest(ErrorInvalidFilter, "expected value after %q", operator)
+	}
+	p.pos++
+	if valueToken.kind == tokenString {
+		return &attributeExpression{path: path, operator: operator, value: valueToken.text}, nil
+	}
+	if valueToken.kind != tokenWord {
+		return nil, badRequest(ErrorInvalidFilter, "expected value after %q", operator)
+	}
+	var value interface{}
+	switch strings.ToLower(valueToken.text) {
+	case "true":
+		value = true
+	case "false":
+		value = false
+	case "null":
+		value = nil
+	default:
+		number, err := strconv.ParseFloat(valueToken.text, 64)
+		if err != nil {
+			return nil, badRequest(ErrorInvalidFilter, "invalid value %q", valueToken.text)
+		}
+		value = number
+	}
+	return &attributeExpression{path: path, operator: operator, value: value}, nil
+}
+
+// attributeKind decides how an attribute is compared in SQL
+type attributeKind int
+
+const (
+	// kindString is compared case-insensitively
+	kindString attributeKind = iota
+	kindCaseExact
+	kindBoolean
+	kindDateTime
+)
+
+// column maps a filterable attribute onto SQL. When exists is set the
+// condition is wrapped into it, e.g. to match group members.
+type column struct {
+	expr   string
+	kind   attributeKind
+	exists string
+}
+
+// columns maps lower-case attribute paths to columns
+type columns map[string]column
+
+// toSQL compiles a filter into a WHERE clause for the mapped columns
+func toSQL(expr expression, cols columns) (string, []interface{}, error) {
+	return compile(expr, cols, "")
+}
+
+func compile(expr expression, cols columns, prefix string) (string, []interface{}, error) {
+	switch e := expr.(type) {
+	case *logicalExpression:
+		left, leftArgs, err := compile(e.left, cols, prefix)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rightArgs, err := compile(e.right, cols, prefix)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, strings.ToUpper(e.operator), right), append(leftArgs, rightArgs...), nil
+	case *notExpression:
+		inner, args, err := compile(e.expression, cols, prefix)
+		if err != nil {
+			return "", nil, err
+		}
+		return fmt.Sprintf("NOT %s", inner), args, nil
+	case *valuePathExpression:
+		return compile(e.filter, cols, stripSchema(e.path)+".")
+	case *attributeExpression:
+		path := strings.ToLower(prefix + stripSchema(e.path))
+		col, ok := cols[path]
+		if !ok {
+			return "", nil, badRequest(ErrorInvalidFilter, "filtering on %q is not supported", prefix+e.path)
+		}
+		condition, args, err := col.condition(e.operator, e.value)
+		if err != nil {
+			return "", nil, err
+		}
+		if col.exists != "" {
+			condition = fmt.Sprintf(col.exists, condition)
+		}
+		return condition, args, nil
+	}
+	return "", nil, badRequest(ErrorInvalidFilter, "unsupported filter")
+}
+
+var sqlOperators = map[string]string{
+	"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<=",
+}
+
+func (c column) condition(operator string, value interface{}) (string, []interface{}, error) {
+	switch c.kind {
+	case kindBoolean:
+		if operator == "pr" {
+			return "TRUE", nil, nil
+		}
+		b, ok := value.(bool)
+		if !ok || (operator != "eq" && operator != "ne") {
+			return "", nil, badRequest(ErrorInvalidFilter, "boolean attributes support only eq and ne with true or false")
+		}
+		return fmt.Sprintf("(%s) %s ?", c.expr, sqlOperators[operator]), []interface{}{b}, nil
+	case kindDateTime:
+		if operator == "pr" {
+			return fmt.Sprintf("%s IS NOT NULL", c.expr), nil, nil
+		}
+		s, ok := value.(string)
+		sqlOperator, comparable := sqlOperators[operator]
+		if !ok || !comparable {
+			return "", nil, badRequest(ErrorInvalidFilter, "dateTime attributes support only eq, ne, gt, ge, lt and le")
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return "", nil, badRequest(ErrorInvalidFilter, "invalid dateTime %q", s)
+		}
+		return fmt.Sprintf("%s %s ?", c.expr, sqlOperator), []interface{}{t}, nil
+	}
+
+	if operator == "pr" {
+		return fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", c.expr, c.expr), nil, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", nil, badRequest(ErrorInvalidFilter, "string attributes must be compared with a string")
+	}
+	lhs := c.expr
+	if c.kind == kindString {
+		lhs = "LOWER(" + c.expr + ")"
+		s = strings.ToLower(s)
+	}
+	switch operator {
+	case "co":
+		return lhs + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + escapeLike(s) + "%"}, nil
+	case "sw":
+		return lhs + ` LIKE ? ESCAPE '\'`, []interface{}{escapeLike(s) + "%"}, nil
+	case "ew":
+		return lhs + ` LIKE ? ESCAPE '\'`, []interface{}{"%" + escapeLike(s)}, nil
+	}
+	return fmt.Sprintf("%s %s ?", lhs, sqlOperators[operator]), []interface{}{s}, nil
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// matches evaluates a filter against one value of a multi-valued attribute,
+// as PATCH paths like members[value eq "..."] require
+func matches(expr expression, value map[string]interface{}) bool {
+	switch e := expr.(type) {
+	case *logicalExpression:
+		if e.operator == "and" {
+			return matches(e.left, value) && matches(e.right, value)
+		}
+		return matches(e.left, value) || matches(e.right, value)
+	case *notExpression:
+		return !matches(e.expression, value)
+	case *attributeExpression:
+		_, actual, ok := lookupField(value, e.path)
+		if e.operator == "pr" {
+			return ok && actual != nil && actual != ""
+		}
+		if !ok {
+			return e.operator == "ne"
+		}
+		actualString, isString := actual.(string)
+		expectedString, expectString := e.value.(string)
+		if isString && expectString {
+			a, b := strings.ToLower(actualString), strings.ToLower(expectedString)
+			switch e.operator {
+			case "eq":
+				return a == b
+			case "ne":
+				return a != b
+			case "co":
+				return strings.Contains(a, b)
+			case "sw":
+				return strings.HasPrefix(a, b)
+			case "ew":
+				return strings.HasSuffix(a, b)
+			case "gt":
+				return a > b
+			case "ge":
+				return a >= b
+			case "lt":
+				return a < b
+			case "le":
+				return a <= b
+			}
+			return false
+		}
+		switch e.operator {
+		case "eq":
+			return actual == e.value
+		case "ne":
+			return actual != e.value
+		}
+	}
+	return false
+}
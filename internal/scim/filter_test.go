@@ -0,0 +1,103 @@
+package scim
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func compileFilter(t *testing.T, filter string, cols columns) (string, []interface{}) {
+	t.Helper()
+	expr, err := parseFilter(filter)
+	require.NoError(t, err)
+	where, args, err := toSQL(expr, cols)
+	require.NoError(t, err)
+	return where, args
+}
+
+func TestFilterToSQL(t *testing.T) {
+	tests := []struct {
+		filter string
+		where  string
+		args   []interface{}
+	}{
+		{`userName eq "Jane@Example.org"`, "LOWER(users.email) = ?", []interface{}{"jane@example.org"}},
+		{`USERNAME Eq "jane"`, "LOWER(users.email) = ?", []interface{}{"jane"}},
+		{`urn:ietf:params:scim:schemas:core:2.0:User:userName sw "j"`, `LOWER(users.email) LIKE ? ESCAPE '\'`, []interface{}{"j%"}},
+		{`name.familyName co "50%_off"`, `LOWER(users.lastname) LIKE ? ESCAPE '\'`, []interface{}{`%50\%\_off%`}},
+		{`emails[type eq "work" and value ew "@example.org"]`, `(LOWER('work') = ? AND LOWER(users.email) LIKE ? ESCAPE '\')`, []interface{}{"work", "%@example.org"}},
+		{`externalId eq "ABC"`, "users.external_id = ?", []interface{}{"ABC"}},
+		{`active eq false`, "(users.status = 'active') = ?", []interface{}{false}},
+		{`externalId pr`, "(users.external_id IS NOT NULL AND users.external_id <> '')", nil},
+		{
+			`userName eq "a" or userName eq "b" and not (active eq true)`,
+			"(LOWER(users.email) = ? OR (LOWER(users.email) = ? AND NOT (users.status = 'active') = ?))",
+			[]interface{}{"a", "b", true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			where, args := compileFilter(t, tt.filter, userColumns)
+			assert.Equal(t, tt.where, where)
+			assert.Equal(t, tt.args, args)
+		})
+	}
+}
+
+func TestFilterMembers(t *testing.T) {
+	where, args := compileFilter(t, `displayName eq "Admins" and members[value eq "u1"]`, groupColumns)
+	assert.Equal(t, "(LOWER(groups.name) = ? AND EXISTS (SELECT 1 FROM members WHERE members.group_id = groups.id AND members.user_id::text = ?))", where)
+	assert.Equal(t, []interface{}{"admins", "u1"}, args)
+}
+
+func TestFilterDateTime(t *testing.T) {
+	where, args := compileFilter(t, `meta.lastModified gt "2024-05-01T00:00:00Z"`, userColumns)
+	assert.Equal(t, "users.updated_at > ?", where)
+	require.Len(t, args, 1)
+}
+
+func TestFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName xx "a"`,
+		`userName eq "a" and`,
+		`(userName eq "a"`,
+		`userName eq "unterminated`,
+		`nickName eq "a"`,
+		`active eq "yes"`,
+		`active co true`,
+		`meta.created gt "yesterday"`,
+		`userName eq "a" extra`,
+	} {
+		t.Run(filter, func(t *testing.T) {
+			expr, err := parseFilter(filter)
+			if err == nil {
+				_, _, err = toSQL(expr, userColumns)
+			}
+			var scimErr *Error
+			require.ErrorAs(t, err, &scimErr)
+			assert.Equal(t, http.StatusBadRequest, scimErr.Status)
+			assert.Equal(t, ErrorInvalidFilter, scimErr.ScimType)
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	email := (&MultiValued{Value: "jane@example.org", Type: "work", Primary: true}).fields()
+	for filter, want := range map[string]bool{
+		`type eq "WORK"`: true,
+		`type eq "home"`: false,
+		`value ew "@example.org" and primary eq true`: true,
+		`not (type eq "work")`:                        false,
+		`display pr`:                                  false,
+		`type eq "home" or value sw "jane"`:           true,
+	} {
+		expr, err := parseFilter(filter)
+		require.NoError(t, err)
+		assert.Equal(t, want, matches(expr, email), filter)
+	}
+}
@@ -0,0 +1,403 @@
+package scim
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Scope is the OAuth scope a client token needs to use the SCIM API
+const Scope = "scim"
+
+// Authenticate admits client credentials tokens that carry the scim scope.
+// The client ID is stored as the request's user ID and recorded as actor.
+func Authenticate(validators ...middleware.TokenValidator) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		tokenString := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if tokenString == "" || tokenString == ctx.GetHeader("Authorization") {
+			respondError(ctx, &Error{Status: http.StatusUnauthorized, Detail: "bearer token required"})
+			ctx.Abort()
+			return
+		}
+
+		claims, _, err := middleware.VerifyToken(tokenString, nil, validators...)
+		if err != nil {
+			logrus.Warnf("Rejected SCIM token: %v", err)
+			respondError(ctx, &Error{Status: http.StatusUnauthorized, Detail: "invalid token"})
+			ctx.Abort()
+			return
+		}
+		if err := middleware.BindTenant(ctx, claims); err != nil {
+			logrus.Warnf("Rejected SCIM token: %v", err)
+			respondError(ctx, &Error{Status: http.StatusUnauthorized, Detail: "invalid token"})
+			ctx.Abort()
+			return
+		}
+		if !containsFold(strings.Fields(claims.Scope), Scope) {
+			respondError(ctx, &Error{Status: http.StatusForbidden, Detail: "token lacks the " + Scope + " scope"})
+			ctx.Abort()
+			return
+		}
+
+		ctx.Set("user_id", claims.Sub)
+		ctx.Set("auth_method", middleware.AuthMethodJWT)
+		ctx.Next()
+	}
+}
+
+type SCIMController struct {
+	scimService *SCIMService
+	logger      *logrus.Logger
+}
+
+func NewSCIMController(scimService *SCIMService) *SCIMController {
+	return &SCIMController{
+		scimService: scimService,
+		logger:      logrus.New(),
+	}
+}
+
+func (c *SCIMController) GetServiceProviderConfig(ctx *gin.Context) {
+	respond(ctx, http.StatusOK, c.scimService.WithContext(ctx).ServiceProviderConfig())
+}
+
+func (c *SCIMController) GetResourceTypes(ctx *gin.Context) {
+	resourceTypes := c.scimService.WithContext(ctx).ResourceTypes()
+	resources := make([]interface{}, 0, len(resourceTypes))
+	for _, resourceType := range resourceTypes {
+		resources = append(resources, resourceType)
+	}
+	respond(ctx, http.StatusOK, staticList(resources))
+}
+
+func (c *SCIMController) GetResourceType(ctx *gin.Context) {
+	for _, resourceType := range c.scimService.WithContext(ctx).ResourceTypes() {
+		if resourceType.ID == ctx.Param("id") {
+			respond(ctx, http.StatusOK, resourceType)
+			return
+		}
+	}
+	respondError(ctx, notFound("resource type %s not found", ctx.Param("id")))
+}
+
+func (c *SCIMController) GetSchemas(ctx *gin.Context) {
+	schemas := c.scimService.WithContext(ctx).Schemas()
+	resources := make([]interface{}, 0, len(schemas))
+	for _, schema := range schemas {
+		resources = append(resources, schema)
+	}
+	respond(ctx, http.StatusOK, staticList(resources))
+}
+
+func (c *SCIMController) GetSchema(ctx *gin.Context) {
+	for _, schema := range c.scimService.WithContext(ctx).Schemas() {
+		if schema.ID == ctx.Param("id") {
+			respond(ctx, http.StatusOK, schema)
+			return
+		}
+	}
+	respondError(ctx, notFound("schema %s not found", ctx.Param("id")))
+}
+
+func (c *SCIMController) GetUsers(ctx *gin.Context) {
+	query, err := listQuery(ctx)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+	users, err := c.scimService.WithContext(ctx).ListUsers(query)
+	if err != nil {
+		c.respondError(ctx, "Failed to list users", err)
+		return
+	}
+	c.respondList(ctx, users)
+}
+
+func (c *SCIMController) GetUser(ctx *gin.Context) {
+	resource, err := c.scimService.WithContext(ctx).GetUser(ctx.Param("id"))
+	if err != nil {
+		c.respondError(ctx, "Failed to get user", err)
+		return
+	}
+	if resource == nil {
+		respondError(ctx, notFound("user %s not found", ctx.Param("id")))
+		return
+	}
+	c.respondResource(ctx, http.StatusOK, resource, resource.Meta)
+}
+
+func (c *SCIMController) CreateUser(ctx *gin.Context) {
+	var resource User
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		respondError(ctx, badRequest(ErrorInvalidSyntax, "invalid request body: %v", err))
+		return
+	}
+	created, err := c.scimService.WithContext(ctx).CreateUser(&resource, middleware.GetUserID(ctx))
+	if err != nil {
+		c.respondError(ctx, "Failed to create user", err)
+		return
+	}
+	c.respondResource(ctx, http.StatusCreated, created, created.Meta)
+}
+
+func (c *SCIMController) ReplaceUser(ctx *gin.Context) {
+	var resource User
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		respondError(ctx, badRequest(ErrorInvalidSyntax, "invalid request body: %v", err))
+		return
+	}
+	replaced, err := c.scimService.WithContext(ctx).ReplaceUser(ctx.Param("id"), &resource, ctx.GetHeader("If-Match"), middleware.GetUserID(ctx))
+	if err != nil {
+		c.respondError(ctx, "Failed to replace user", err)
+		return
+	}
+	if replaced == nil {
+		respondError(ctx, notFound("user %s not found", ctx.Param("id")))
+		return
+	}
+	c.respondResource(ctx, http.StatusOK, replaced, replaced.Meta)
+}
+
+func (c *SCIMController) PatchUser(ctx *gin.Context) {
+	var req PatchRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		respondError(ctx, badRequest(ErrorInvalidSyntax, "invalid request body: %v", err))
+		return
+	}
+	patched, err := c.scimService.WithContext(ctx).PatchUser(ctx.Param("id"), &req, ctx.GetHeader("If-Match"), middleware.GetUserID(ctx))
+	if err != nil {
+		c.respondError(ctx, "Failed to patch user", err)
+		return
+	}
+	if patched == nil {
+		respondError(ctx, notFound("user %s not found", ctx.Param("id")))
+		return
+	}
+	c.respondResource(ctx, http.StatusOK, patched, patched.Meta)
+}
+
+func (c *SCIMController) DeleteUser(ctx *gin.Context) {
+	deleted, err := c.scimService.WithContext(ctx).DeleteUser(ctx.Param("id"), ctx.GetHeader("If-Match"), middleware.GetUserID(ctx))
+	if err != nil {
+		c.respondError(ctx, "Failed to delete user", err)
+		return
+	}
+	if !deleted {
+		respondError(ctx, notFound("user %s not found", ctx.Param("id")))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *SCIMController) GetGroups(ctx *gin.Context) {
+	query, err := listQuery(ctx)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+	groups, err := c.scimService.WithContext(ctx).ListGroups(query)
+	if err != nil {
+		c.respondError(ctx, "Failed to list groups", err)
+		return
+	}
+	c.respondList(ctx, groups)
+}
+
+func (c *SCIMController) GetGroup(ctx *gin.Context) {
+	resource, err := c.scimService.WithContext(ctx).GetGroup(ctx.Param("id"))
+	if err != nil {
+		c.respondError(ctx, "Failed to get group", err)
+		return
+	}
+	if resource == nil {
+		respondError(ctx, notFound("group %s not found", ctx.Param("id")))
+		return
+	}
+	c.respondResource(ctx, http.StatusOK, resource, resource.Meta)
+}
+
+func (c *SCIMController) CreateGroup(ctx *gin.Context) {
+	var resource Group
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		respondError(ctx, badRequest(ErrorInvalidSyntax, "invalid request body: %v", err))
+		return
+	}
+	created, err := c.scimService.WithContext(ctx).CreateGroup(&resource)
+	if err != nil {
+		c.respondError(ctx, "Failed to create group", err)
+		return
+	}
+	c.respondResource(ctx, http.StatusCreated, created, created.Meta)
+}
+
+func (c *SCIMController) ReplaceGroup(ctx *gin.Context) {
+	var resource Group
+	if err := ctx.ShouldBindJSON(&resource); err != nil {
+		respondError(ctx, badRequest(ErrorInvalidSyntax, "invalid request body: %v", err))
+		return
+	}
+	replaced, err := c.scimService.WithContext(ctx).ReplaceGroup(ctx.Param("id"), &resource, ctx.GetHeader("If-Match"))
+	if err != nil {
+		c.respondError(ctx, "Failed to replace group", err)
+		return
+	}
+	if replaced == nil {
+		respondError(ctx, notFound("group %s not found", ctx.Param("id")))
+		return
+	}
+	c.respondResource(ctx, http.StatusOK, replaced, replaced.Meta)
+}
+
+func (c *SCIMController) PatchGroup(ctx *gin.Context) {
+	var req PatchRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		respondError(ctx, badRequest(ErrorInvalidSyntax, "invalid request body: %v", err))
+		return
+	}
+	patched, err := c.scimService.WithContext(ctx).PatchGroup(ctx.Param("id"), &req, ctx.GetHeader("If-Match"))
+	if err != nil {
+		c.respondError(ctx, "Failed to patch group", err)
+		return
+	}
+	if patched == nil {
+		respondError(ctx, notFound("group %s not found", ctx.Param("id")))
+		return
+	}
+	c.respondResource(ctx, http.StatusOK, patched, patched.Meta)
+}
+
+func (c *SCIMController) DeleteGroup(ctx *gin.Context) {
+	deleted, err := c.scimService.WithContext(ctx).DeleteGroup(ctx.Param("id"), ctx.GetHeader("If-Match"))
+	if err != nil {
+		c.respondError(ctx, "Failed to delete group", err)
+		return
+	}
+	if !deleted {
+		respondError(ctx, notFound("group %s not found", ctx.Param("id")))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *SCIMController) Bulk(ctx *gin.Context) {
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxBulkPayloadSize)
+	var req BulkRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(ctx, &Error{Status: http.StatusRequestEntityTooLarge, Detail: "request exceeds maxPayloadSize of " + strconv.Itoa(maxBulkPayloadSize) + " bytes"})
+			return
+		}
+		respondError(ctx, badRequest(ErrorInvalidSyntax, "invalid request body: %v", err))
+		return
+	}
+	response, err := c.scimService.WithContext(ctx).Bulk(&req, middleware.GetUserID(ctx))
+	if err != nil {
+		c.respondError(ctx, "Failed to process bulk request", err)
+		return
+	}
+	respond(ctx, http.StatusOK, response)
+}
+
+// listQuery reads the paging, sorting and filter parameters
+func listQuery(ctx *gin.Context) (ListQuery, error) {
+	query := ListQuery{
+		Filter:     ctx.Query("filter"),
+		SortBy:     ctx.Query("sortBy"),
+		Descending: ctx.Query("sortOrder") == "descending",
+		StartIndex: 1,
+		Count:      defaultCount,
+	}
+	if value := ctx.Query("startIndex"); value != "" {
+		startIndex, err := strconv.Atoi(value)
+		if err != nil {
+			return query, badRequest(ErrorInvalidValue, "invalid startIndex %q", value)
+		}
+		if startIndex > 1 {
+			query.StartIndex = startIndex
+		}
+	}
+	if value := ctx.Query("count"); value != "" {
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return query, badRequest(ErrorInvalidValue, "invalid count %q", value)
+		}
+		query.Count = min(max(count, 0), maxFilterResults)
+	}
+	return query, nil
+}
+
+// respondResource writes a resource with its ETag and, on creation, its
+// Location. A GET whose If-None-Match names the version gets 304.
+func (c *SCIMController) respondResource(ctx *gin.Context, status int, resource interface{}, meta *Meta) {
+	ctx.Header("ETag", meta.Version)
+	if status == http.StatusCreated {
+		ctx.Header("Location", meta.Location)
+	}
+	if ctx.Request.Method == http.MethodGet {
+		if ifNoneMatch := ctx.GetHeader("If-None-Match"); ifNoneMatch != "" && matchesVersion(ifNoneMatch, meta.Version) {
+			ctx.Status(http.StatusNotModified)
+			return
+		}
+	}
+
+	projected, err := project(resource, splitList(ctx.Query("attributes")), splitList(ctx.Query("excludedAttributes")))
+	if err != nil {
+		c.respondError(ctx, "Failed to render resource", err)
+		return
+	}
+	respond(ctx, status, projected)
+}
+
+func (c *SCIMController) respondList(ctx *gin.Context, list *ListResponse) {
+	attributes, excluded := splitList(ctx.Query("attributes")), splitList(ctx.Query("excludedAttributes"))
+	for i, resource := range list.Resources {
+		projected, err := project(resource, attributes, excluded)
+		if err != nil {
+			c.respondError(ctx, "Failed to render resources", err)
+			return
+		}
+		list.Resources[i] = projected
+	}
+	respond(ctx, http.StatusOK, list)
+}
+
+// respondError writes SCIM errors as they are and logs anything else as an
+// internal error
+func (c *SCIMController) respondError(ctx *gin.Context, message string, err error) {
+	var scimErr *Error
+	if !errors.As(err, &scimErr) {
+		c.logger.Errorf("%s: %v", message, err)
+		scimErr = &Error{Status: http.StatusInternalServerError, Detail: message}
+	}
+	respondError(ctx, scimErr)
+}
+
+func respondError(ctx *gin.Context, err error) {
+	var scimErr *Error
+	if !errors.As(err, &scimErr) {
+		scimErr = &Error{Status: http.StatusInternalServerError, Detail: err.Error()}
+	}
+	respond(ctx, scimErr.Status, scimErr.Response())
+}
+
+func respond(ctx *gin.Context, status int, body interface{}) {
+	ctx.Header("Content-Type", ContentType)
+	ctx.JSON(status, body)
+}
+
+func staticList(resources []interface{}) *ListResponse {
+	return &ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: int64(len(resources)),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
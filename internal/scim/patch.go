@@ -0,0 +1,464 @@
+package scim
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+func (r *PatchRequest) validate() error {
+	if !containsFold(r.Schemas, SchemaPatchOp) {
+		return badRequest(ErrorInvalidSyntax, "request must use the %s schema", SchemaPatchOp)
+	}
+	if len(r.Operations) == 0 {
+		return badRequest(ErrorInvalidSyntax, "no operations")
+	}
+	return nil
+}
+
+// patchPath is a parsed PATCH path: attr[filter].sub
+type patchPath struct {
+	attr   string
+	filter expression
+	sub    string
+}
+
+func parsePath(path string) (*patchPath, error) {
+	path = stripSchema(strings.TrimSpace(path))
+	result := &patchPath{}
+	if open := strings.Index(path, "["); open >= 0 {
+		end := strings.LastIndex(path, "]")
+		if end < open {
+			return nil, badRequest(ErrorInvalidPath, "invalid path %q", path)
+		}
+		filter, err := parseFilter(path[open+1 : end])
+		if err != nil {
+			return nil, badRequest(ErrorInvalidPath, "invalid filter in path %q", path)
+		}
+		result.attr, result.filter = path[:open], filter
+		rest := path[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") {
+				return nil, badRequest(ErrorInvalidPath, "invalid path %q", path)
+			}
+			result.sub = rest[1:]
+		}
+	} else {
+		result.attr, result.sub, _ = strings.Cut(path, ".")
+	}
+	if result.attr == "" {
+		return nil, badRequest(ErrorInvalidPath, "invalid path %q", path)
+	}
+	result.attr = strings.ToLower(result.attr)
+	result.sub = strings.ToLower(result.sub)
+	return result, nil
+}
+
+// patchOp normalizes the operation name, which Azure AD sends capitalized
+func patchOp(op PatchOperation) (string, error) {
+	name := strings.ToLower(op.Op)
+	switch name {
+	case "add", "replace", "remove":
+		return name, nil
+	}
+	return "", badRequest(ErrorInvalidSyntax, "invalid operation %q", op.Op)
+}
+
+// splitPathless expands an add or replace without path into one operation
+// per attribute of its value
+func splitPathless(op string, value json.RawMessage) ([]PatchOperation, error) {
+	if op == "remove" {
+		return nil, badRequest(ErrorNoTarget, "remove requires a path")
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		return nil, badRequest(ErrorInvalidValue, "value must be an object when path is omitted")
+	}
+	operations := make([]PatchOperation, 0, len(fields))
+	for path, fieldValue := range fields {
+		if strings.EqualFold(path, "schemas") {
+			continue
+		}
+		operations = append(operations, PatchOperation{Op: op, Path: path, Value: fieldValue})
+	}
+	return operations, nil
+}
+
+// applyUserPatch applies one PATCH operation to a user
+func applyUserPatch(u *User, operation PatchOperation) error {
+	op, err := patchOp(operation)
+	if err != nil {
+		return err
+	}
+	if operation.Path == "" {
+		operations, err := splitPathless(op, operation.Value)
+		if err != nil {
+			return err
+		}
+		for _, expanded := range operations {
+			if err := applyUserPatch(u, expanded); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parsePath(operation.Path)
+	if err != nil {
+		return err
+	}
+	switch path.attr {
+	case "username":
+		if op == "remove" {
+			return badRequest(ErrorMutability, "userName is required")
+		}
+		return decodeString(operation.Value, &u.UserName)
+	case "displayname":
+		if op == "remove" {
+			u.DisplayName = ""
+			return nil
+		}
+		return decodeString(operation.Value, &u.DisplayName)
+	case "externalid":
+		if op == "remove" {
+			u.ExternalID = ""
+			return nil
+		}
+		return decodeString(operation.Value, &u.ExternalID)
+	case "name":
+		return patchName(u, op, path.sub, operation.Value)
+	case "emails":
+		return patchEmails(u, op, path, operation.Value)
+	case "active":
+		if op == "remove" {
+			return badRequest(ErrorMutability, "active cannot be removed")
+		}
+		active, err := decodeBool(operation.Value)
+		if err != nil {
+			return err
+		}
+		u.Active = &active
+		return nil
+	case "password":
+		if op == "remove" {
+			return badRequest(ErrorMutability, "password cannot be removed")
+		}
+		return decodeString(operation.Value, &u.Password)
+	case "groups":
+		return badRequest(ErrorMutability, "groups is read-only; change group members instead")
+	}
+	return badRequest(ErrorInvalidPath, "unsupported attribute %q", operation.Path)
+}
+
+func patchName(u *User, op, sub string, value json.RawMessage) error {
+	if u.Name == nil {
+		u.Name = &Name{}
+	}
+	if sub == "" {
+		if op == "remove" {
+			u.Name = nil
+			return nil
+		}
+		var name Name
+		if err := json.Unmarshal(value, &name); err != nil {
+			return badRequest(ErrorInvalidValue, "invalid name")
+		}
+		if op == "replace" {
+			u.Name = &name
+			return nil
+		}
+		if name.Formatted != "" {
+			u.Name.Formatted = name.Formatted
+		}
+		if name.GivenName != "" {
+			u.Name.GivenName = name.GivenName
+		}
+		if name.FamilyName != "" {
+			u.Name.FamilyName = name.FamilyName
+		}
+		return nil
+	}
+
+	var target *string
+	switch sub {
+	case "formatted":
+		target = &u.Name.Formatted
+	case "givenname":
+		target = &u.Name.GivenName
+	case "familyname":
+		target = &u.Name.FamilyName
+	default:
+		return badRequest(ErrorInvalidPath, "unsupported attribute name.%s", sub)
+	}
+	if op == "remove" {
+		*target = ""
+		return nil
+	}
+	return decodeString(value, target)
+}
+
+func patchEmails(u *User, op string, path *patchPath, value json.RawMessage) error {
+	if path.filter == nil {
+		if path.sub != "" {
+			return badRequest(ErrorInvalidPath, "emails sub-attributes require a value filter")
+		}
+		if op == "remove" {
+			u.Emails = nil
+			return nil
+		}
+		var emails []MultiValued
+		if err := json.Unmarshal(value, &emails); err != nil {
+			return badRequest(ErrorInvalidValue, "emails must be a list")
+		}
+		if op == "replace" {
+			u.Emails = emails
+		} else {
+			u.Emails = append(u.Emails, emails...)
+		}
+		return nil
+	}
+
+	matched := false
+	kept := u.Emails[:0:0]
+	for i := range u.Emails {
+		email := &u.Emails[i]
+		if !matches(path.filter, email.fields()) {
+			kept = append(kept, *email)
+			continue
+		}
+		matched = true
+		if op == "remove" {
+			if path.sub != "" {
+				if err := email.set(path.sub, nil); err != nil {
+					return err
+				}
+				kept = append(kept, *email)
+			}
+			continue
+		}
+		if err := email.patch(path.sub, value); err != nil {
+			return err
+		}
+		kept = append(kept, *email)
+	}
+	u.Emails = kept
+
+	// Azure AD replaces emails[type eq "work"].value on users without a work
+	// email; add the value as a new email in that case
+	if !matched && op != "remove" {
+		email := MultiValued{Primary: len(u.Emails) == 0}
+		if eq, ok := path.filter.(*attributeExpression); ok && eq.operator == "eq" {
+			if s, ok := eq.value.(string); ok {
+				if err := email.set(strings.ToLower(eq.path), s); err != nil {
+					return err
+				}
+			}
+		}
+		if err := email.patch(path.sub, value); err != nil {
+			return err
+		}
+		u.Emails = append(u.Emails, email)
+	}
+	return nil
+}
+
+// applyGroupPatch applies one PATCH operation to a group
+func applyGroupPatch(g *Group, operation PatchOperation) error {
+	op, err := patchOp(operation)
+	if err != nil {
+		return err
+	}
+	if operation.Path == "" {
+		operations, err := splitPathless(op, operation.Value)
+		if err != nil {
+			return err
+		}
+		for _, expanded := range operations {
+			if err := applyGroupPatch(g, expanded); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parsePath(operation.Path)
+	if err != nil {
+		return err
+	}
+	switch path.attr {
+	case "displayname":
+		if op == "remove" {
+			return badRequest(ErrorMutability, "displayName is required")
+		}
+		return decodeString(operation.Value, &g.DisplayName)
+	case "externalid":
+		if op == "remove" {
+			g.ExternalID = ""
+			return nil
+		}
+		return decodeString(operation.Value, &g.ExternalID)
+	case "members":
+		return patchMembers(g, op, path, operation.Value)
+	}
+	return badRequest(ErrorInvalidPath, "unsupported attribute %q", operation.Path)
+}
+
+func patchMembers(g *Group, op string, path *patchPath, value json.RawMessage) error {
+	if path.sub != "" {
+		return badRequest(ErrorInvalidPath, "members sub-attributes cannot be changed")
+	}
+	if path.filter != nil {
+		if op != "remove" {
+			return badRequest(ErrorInvalidPath, "filtered members paths are only supported for remove")
+		}
+		kept := g.Members[:0:0]
+		for _, m := range g.Members {
+			if !matches(path.filter, m.fields()) {
+				kept = append(kept, m)
+			}
+		}
+		g.Members = kept
+		return nil
+	}
+
+	var members []MultiValued
+	if len(value) > 0 && string(value) != "null" {
+		if err := json.Unmarshal(value, &members); err != nil {
+			return badRequest(ErrorInvalidValue, "members must be a list")
+		}
+	}
+	switch op {
+	case "replace":
+		g.Members = nil
+		fallthrough
+	case "add":
+		for _, m := range members {
+			if m.Value == "" {
+				return badRequest(ErrorInvalidValue, "member value is required")
+			}
+			if !hasMember(g.Members, m.Value) {
+				g.Members = append(g.Members, MultiValued{Value: m.Value})
+			}
+		}
+	case "remove":
+		if len(members) == 0 {
+			g.Members = nil
+			return nil
+		}
+		kept := g.Members[:0:0]
+		for _, m := range g.Members {
+			if !hasMember(members, m.Value) {
+				kept = append(kept, m)
+			}
+		}
+		g.Members = kept
+	}
+	return nil
+}
+
+func hasMember(members []MultiValued, value string) bool {
+	for _, m := range members {
+		if strings.EqualFold(m.Value, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// fields returns the attributes filters of value paths are evaluated against
+func (m *MultiValued) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"value":   m.Value,
+		"display": m.Display,
+		"type":    m.Type,
+		"primary": m.Primary,
+	}
+}
+
+// patch sets the sub-attribute, or the whole value when sub is empty
+func (m *MultiValued) patch(sub string, value json.RawMessage) error {
+	if sub == "" {
+		var replacement MultiValued
+		if err := json.Unmarshal(value, &replacement); err != nil {
+			return badRequest(ErrorInvalidValue, "invalid value")
+		}
+		*m = replacement
+		return nil
+	}
+	if sub == "primary" {
+		primary, err := decodeBool(value)
+		if err != nil {
+			return err
+		}
+		return m.set(sub, primary)
+	}
+	var s string
+	if err := decodeString(value, &s); err != nil {
+		return err
+	}
+	return m.set(sub, s)
+}
+
+// set assigns a sub-attribute; nil clears it
+func (m *MultiValued) set(sub string, value interface{}) error {
+	s, _ := value.(string)
+	switch sub {
+	case "value":
+		m.Value = s
+	case "display":
+		m.Display = s
+	case "type":
+		m.Type = s
+	case "primary":
+		b, _ := value.(bool)
+		m.Primary = b
+	default:
+		return badRequest(ErrorInvalidPath, "unsupported sub-attribute %q", sub)
+	}
+	return nil
+}
+
+func decodeString(value json.RawMessage, target *string) error {
+	if err := json.Unmarshal(value, target); err != nil {
+		return badRequest(ErrorInvalidValue, "value must be a string")
+	}
+	return nil
+}
+
+// decodeBool accepts JSON booleans and, as sent by Azure AD, the strings
+// "True" and "False"
+func decodeBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, badRequest(ErrorInvalidValue, "value must be a boolean")
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
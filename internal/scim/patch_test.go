@@ -0,0 +1,139 @@
+package scim
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func patchRequest(t *testing.T, body string) *PatchRequest {
+	t.Helper()
+	var req PatchRequest
+	require.NoError(t, json.Unmarshal([]byte(body), &req))
+	require.NoError(t, req.validate())
+	return &req
+}
+
+func testUser() *User {
+	active := true
+	return &User{
+		Schemas:     []string{SchemaUser},
+		UserName:    "jane@example.org",
+		DisplayName: "Jane Doe",
+		Name:        &Name{Formatted: "Jane Doe", GivenName: "Jane", FamilyName: "Doe"},
+		Emails:      []MultiValued{{Value: "jane@example.org", Type: "work", Primary: true}},
+		Active:      &active,
+	}
+}
+
+func applyUser(t *testing.T, u *User, body string) error {
+	t.Helper()
+	for _, op := range patchRequest(t, body).Operations {
+		if err := applyUserPatch(u, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestPatchUser(t *testing.T) {
+	u := testUser()
+	require.NoError(t, applyUser(t, u, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "replace", "path": "name.familyName", "value": "Smith"},
+			{"op": "add", "path": "externalId", "value": "00u1"},
+			{"op": "Replace", "path": "emails[type eq \"work\"].value", "value": "jane.smith@example.org"},
+			{"op": "remove", "path": "displayName"}
+		]
+	}`))
+	assert.Equal(t, "Smith", u.Name.FamilyName)
+	assert.Equal(t, "Jane", u.Name.GivenName)
+	assert.Equal(t, "00u1", u.ExternalID)
+	assert.Equal(t, "jane.smith@example.org", u.Emails[0].Value)
+	assert.True(t, u.Emails[0].Primary)
+	assert.Equal(t, "", u.DisplayName)
+	assert.Equal(t, "jane.smith@example.org", u.address("jane@example.org"))
+}
+
+// Azure AD sends capitalized ops, string booleans and pathless replaces
+func TestPatchUserAzureQuirks(t *testing.T) {
+	u := testUser()
+	require.NoError(t, applyUser(t, u, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "Replace", "path": "active", "value": "False"},
+			{"op": "Add", "value": {"name.givenName": "Janet", "urn:ietf:params:scim:schemas:core:2.0:User:displayName": "Janet Doe"}}
+		]
+	}`))
+	assert.False(t, *u.Active)
+	assert.Equal(t, "Janet", u.Name.GivenName)
+	assert.Equal(t, "Janet Doe", u.DisplayName)
+}
+
+func TestPatchUserAddsMissingWorkEmail(t *testing.T) {
+	u := testUser()
+	u.Emails = nil
+	require.NoError(t, applyUser(t, u, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "new@example.org"}]
+	}`))
+	assert.Equal(t, []MultiValued{{Value: "new@example.org", Type: "work", Primary: true}}, u.Emails)
+}
+
+func TestPatchUserErrors(t *testing.T) {
+	for name, op := range map[string]string{
+		"remove userName":   `{"op": "remove", "path": "userName"}`,
+		"read-only groups":  `{"op": "add", "path": "groups", "value": [{"value": "g1"}]}`,
+		"unknown attribute": `{"op": "replace", "path": "nickName", "value": "J"}`,
+		"invalid op":        `{"op": "move", "path": "displayName", "value": "J"}`,
+		"wrong type":        `{"op": "replace", "path": "active", "value": "maybe"}`,
+		"pathless remove":   `{"op": "remove"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := applyUser(t, testUser(), `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [`+op+`]}`)
+			var scimErr *Error
+			assert.ErrorAs(t, err, &scimErr)
+		})
+	}
+}
+
+func TestPatchRequestRequiresSchema(t *testing.T) {
+	req := PatchRequest{Operations: []PatchOperation{{Op: "add", Path: "displayName"}}}
+	assert.Error(t, req.validate())
+}
+
+func TestPatchGroupMembers(t *testing.T) {
+	g := &Group{DisplayName: "Engineering", Members: []MultiValued{{Value: "u1"}, {Value: "u2"}}}
+	apply := func(body string) {
+		t.Helper()
+		for _, op := range patchRequest(t, `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [`+body+`]}`).Operations {
+			require.NoError(t, applyGroupPatch(g, op))
+		}
+	}
+	values := func() []string {
+		result := []string{}
+		for _, m := range g.Members {
+			result = append(result, m.Value)
+		}
+		return result
+	}
+
+	apply(`{"op": "add", "path": "members", "value": [{"value": "u2"}, {"value": "u3"}]}`)
+	assert.Equal(t, []string{"u1", "u2", "u3"}, values())
+
+	apply(`{"op": "remove", "path": "members[value eq \"u1\"]"}`)
+	assert.Equal(t, []string{"u2", "u3"}, values())
+
+	apply(`{"op": "remove", "path": "members", "value": [{"value": "u3"}]}`)
+	assert.Equal(t, []string{"u2"}, values())
+
+	apply(`{"op": "replace", "value": {"displayName": "Platform", "members": [{"value": "u4"}]}}`)
+	assert.Equal(t, "Platform", g.DisplayName)
+	assert.Equal(t, []string{"u4"}, values())
+
+	apply(`{"op": "remove", "path": "members"}`)
+	assert.Empty(t, values())
+}
@@ -0,0 +1,271 @@
+package scim
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// Schema and message URNs (RFC 7643, RFC 7644)
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	SchemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaBulkRequest           = "urn:ietf:params:scim:api:messages:2.0:BulkRequest"
+	SchemaBulkResponse          = "urn:ietf:params:scim:api:messages:2.0:BulkResponse"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses
+const ContentType = "application/scim+json"
+
+type Meta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location,omitempty"`
+	Version      string     `json:"version,omitempty"`
+}
+
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// MultiValued is an element of a multi-valued attribute such as emails
+type MultiValued struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// User is the SCIM representation of user.User. userName and the primary
+// email are both the user's email address.
+type User struct {
+	Schemas     []string      `json:"schemas"`
+	ID          string        `json:"id,omitempty"`
+	ExternalID  string        `json:"externalId,omitempty"`
+	UserName    string        `json:"userName"`
+	Name        *Name         `json:"name,omitempty"`
+	DisplayName string        `json:"displayName,omitempty"`
+	Emails      []MultiValued `json:"emails,omitempty"`
+	Active      *bool         `json:"active,omitempty"`
+	Password    string        `json:"password,omitempty"`
+	Groups      []MultiValued `json:"groups,omitempty"`
+	Meta        *Meta         `json:"meta,omitempty"`
+}
+
+// email returns the address the user is stored under: the primary email,
+// else the first one, else userName
+func (u *User) email() string {
+	for _, email := range u.Emails {
+		if email.Primary && email.Value != "" {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 && u.Emails[0].Value != "" {
+		return u.Emails[0].Value
+	}
+	return u.UserName
+}
+
+// address picks the email address to store given the current one: userName
+// when it changed to an address, else the primary email when that changed
+func (u *User) address(current string) string {
+	if strings.Contains(u.UserName, "@") && !strings.EqualFold(u.UserName, current) {
+		return u.UserName
+	}
+	if primary := u.email(); strings.Contains(primary, "@") && !strings.EqualFold(primary, current) {
+		return primary
+	}
+	return current
+}
+
+// displayName falls back to the formatted or composed name, then userName
+func (u *User) displayName() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+	if u.Name != nil {
+		if u.Name.Formatted != "" {
+			return u.Name.Formatted
+		}
+		if full := strings.TrimSpace(u.Name.GivenName + " " + u.Name.FamilyName); full != "" {
+			return full
+		}
+	}
+	return u.UserName
+}
+
+func (u *User) name() Name {
+	if u.Name == nil {
+		return Name{}
+	}
+	return *u.Name
+}
+
+// Group is the SCIM representation of group.Group; its members are users
+type Group struct {
+	Schemas     []string      `json:"schemas"`
+	ID          string        `json:"id,omitempty"`
+	ExternalID  string        `json:"externalId,omitempty"`
+	DisplayName string        `json:"displayName"`
+	Members     []MultiValued `json:"members,omitempty"`
+	Meta        *Meta         `json:"meta,omitempty"`
+}
+
+type ListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// version computes the weak ETag of a rendered resource from its content,
+// so that it changes with membership as well as attribute changes
+func version(resource interface{}) string {
+	data, _ := json.Marshal(resource)
+	sum := sha256.Sum256(data)
+	return `W/"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// matchesVersion reports whether an If-Match or If-None-Match header value
+// names the version. Weak and strong forms of the same tag match.
+func matchesVersion(header, version string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	want := strings.TrimPrefix(version, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == want {
+			return true
+		}
+	}
+	return false
+}
+
+// alwaysReturned lists attributes returned regardless of projection
+var alwaysReturned = map[string]bool{"schemas": true, "id": true}
+
+// project applies the attributes and excludedAttributes query parameters
+// (RFC 7644 section 3.4.2.5) to a rendered resource. Paths may name
+// top-level attributes or sub-attributes and may carry the schema URN.
+func project(resource interface{}, attributes, excluded []string) (interface{}, error) {
+	if len(attributes) == 0 && len(excluded) == 0 {
+		return resource, nil
+	}
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	if len(attributes) > 0 {
+		result := make(map[string]interface{})
+		for key, value := range fields {
+			if alwaysReturned[key] {
+				result[key] = value
+			}
+		}
+		for _, path := range attributes {
+			attr, sub := splitPath(path)
+			key, value, ok := lookupField(fields, attr)
+			if !ok {
+				continue
+			}
+			if sub == "" {
+				result[key] = value
+				continue
+			}
+			subKey, subValue, ok := lookupSubField(value, sub)
+			if !ok {
+				continue
+			}
+			parent, _ := result[key].(map[string]interface{})
+			if parent == nil {
+				parent = make(map[string]interface{})
+				result[key] = parent
+			}
+			parent[subKey] = subValue
+		}
+		return result, nil
+	}
+
+	for _, path := range excluded {
+		attr, sub := splitPath(path)
+		key, value, ok := lookupField(fields, attr)
+		if !ok || alwaysReturned[key] {
+			continue
+		}
+		if sub == "" {
+			delete(fields, key)
+			continue
+		}
+		if object, ok := value.(map[string]interface{}); ok {
+			if subKey, _, ok := lookupSubField(object, sub); ok {
+				delete(object, subKey)
+			}
+		}
+	}
+	return fields, nil
+}
+
+// splitPath strips a schema URN from an attribute path and splits it into
+// attribute and sub-attribute
+func splitPath(path string) (string, string) {
+	path = stripSchema(strings.TrimSpace(path))
+	attr, sub, _ := strings.Cut(path, ".")
+	return attr, sub
+}
+
+// stripSchema removes a core schema URN prefix from an attribute path
+func stripSchema(path string) string {
+	for _, schema := range []string{SchemaUser, SchemaGroup} {
+		if len(path) > len(schema) && strings.EqualFold(path[:len(schema)], schema) && path[len(schema)] == ':' {
+			return path[len(schema)+1:]
+		}
+	}
+	return path
+}
+
+// lookupField finds a field by case-insensitive name
+func lookupField(fields map[string]interface{}, name string) (string, interface{}, bool) {
+	for key, value := range fields {
+		if strings.EqualFold(key, name) {
+			return key, value, true
+		}
+	}
+	return "", nil, false
+}
+
+func lookupSubField(value interface{}, name string) (string, interface{}, bool) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return "", nil, false
+	}
+	return lookupField(object, name)
+}
+
+// splitList parses a comma-separated query parameter
+func splitList(value string) []string {
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
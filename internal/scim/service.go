@@ -0,0 +1,768 @@
+package scim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"idmapp-go/dto"
+	"idmapp-go/internal/group"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/password"
+	"idmapp-go/internal/user"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// ProviderID is recorded as the provider of users provisioned without a
+// password
+const ProviderID = "scim"
+
+var userColumns = columns{
+	"id":                {expr: "users.id::text", kind: kindCaseExact},
+	"externalid":        {expr: "users.external_id", kind: kindCaseExact},
+	"username":          {expr: "users.email"},
+	"emails":            {expr: "users.email"},
+	"emails.value":      {expr: "users.email"},
+	"emails.type":       {expr: "'work'"},
+	"emails.primary":    {expr: "TRUE", kind: kindBoolean},
+	"displayname":       {expr: "users.name"},
+	"name.formatted":    {expr: "users.name"},
+	"name.givenname":    {expr: "users.firstname"},
+	"name.familyname":   {expr: "users.lastname"},
+	"active":            {expr: "users.status = 'active'", kind: kindBoolean},
+	"meta.created":      {expr: "users.created_at", kind: kindDateTime},
+	"meta.lastmodified": {expr: "users.updated_at", kind: kindDateTime},
+}
+
+var groupColumns = columns{
+	"id":                {expr: "groups.id::text", kind: kindCaseExact},
+	"externalid":        {expr: "groups.external_id", kind: kindCaseExact},
+	"displayname":       {expr: "groups.name"},
+	"members":           {expr: "members.user_id::text", kind: kindCaseExact, exists: groupMemberExists},
+	"members.value":     {expr: "members.user_id::text", kind: kindCaseExact, exists: groupMemberExists},
+	"meta.created":      {expr: "groups.created_at", kind: kindDateTime},
+	"meta.lastmodified": {expr: "groups.updated_at", kind: kindDateTime},
+}
+
+const groupMemberExists = "EXISTS (SELECT 1 FROM members WHERE members.group_id = groups.id AND %s)"
+
+// ListQuery holds the query parameters of a list request (RFC 7644 section 3.4.2)
+type ListQuery struct {
+	Filter     string
+	SortBy     string
+	Descending bool
+	// StartIndex is 1-based
+	StartIndex int
+	Count      int
+}
+
+// SCIMService maps SCIM Users and Groups onto users, groups and group
+// members. Users are keyed by email: userName and the primary email are
+// both the user's address.
+type SCIMService struct {
+	db            *gorm.DB
+	userService   *user.UserService
+	groupService  *group.GroupService
+	memberService *member.MemberService
+	baseURL       string
+	logger        *logrus.Logger
+}
+
+func NewSCIMService(db *gorm.DB, userService *user.UserService, groupService *group.GroupService, memberService *member.MemberService, baseURL string) *SCIMService {
+	return &SCIMService{
+		db:            db,
+		userService:   userService,
+		groupService:  groupService,
+		memberService: memberService,
+		baseURL:       baseURL,
+		logger:        logrus.New(),
+	}
+}
+
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *SCIMService) WithContext(ctx context.Context) *SCIMService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	scoped.userService = s.userService.WithContext(ctx)
+	scoped.groupService = s.groupService.WithContext(ctx)
+	scoped.memberService = s.memberService.WithContext(ctx)
+	return &scoped
+}
+
+// location builds the absolute URL of an endpoint or resource
+func (s *SCIMService) location(endpoint, id string) string {
+	location := s.baseURL + "/scim/v2/" + endpoint
+	if id != "" {
+		location += "/" + id
+	}
+	return location
+}
+
+// list applies the filter, sorting and paging of q to a query
+func list(query *gorm.DB, cols columns, q ListQuery, defaultOrder string) (*gorm.DB, int64, error) {
+	if q.Filter != "" {
+		expr, err := parseFilter(q.Filter)
+		if err != nil {
+			return nil, 0, err
+		}
+		where, args, err := toSQL(expr, cols)
+		if err != nil {
+			return nil, 0, err
+		}
+		query = query.Where(where, args...)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, fmt.Errorf("failed to count resources: %w", err)
+	}
+
+	order := defaultOrder
+	if q.SortBy != "" {
+		col, ok := cols[strings.ToLower(stripSchema(q.SortBy))]
+		if !ok || col.exists != "" {
+			return nil, 0, badRequest(ErrorInvalidValue, "sorting by %q is not supported", q.SortBy)
+		}
+		direction := "ASC"
+		if q.Descending {
+			direction = "DESC"
+		}
+		order = fmt.Sprintf("%s %s, %s", col.expr, direction, defaultOrder)
+	}
+	return query.Order(order).Offset(q.StartIndex - 1).Limit(q.Count), total, nil
+}
+
+func (s *SCIMService) ListUsers(q ListQuery) (*ListResponse, error) {
+	query, total, err := list(s.db.Model(&user.User{}), userColumns, q, "users.created_at, users.id")
+	if err != nil {
+		return nil, err
+	}
+	response := &ListResponse{Schemas: []string{SchemaListResponse}, TotalResults: total, StartIndex: q.StartIndex, Resources: []interface{}{}}
+	if q.Count == 0 {
+		return response, nil
+	}
+
+	var users []user.User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	ids := make([]uuid.UUID, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	groups, err := s.userGroups(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range users {
+		response.Resources = append(response.Resources, s.renderUser(&users[i], groups[users[i].ID]))
+	}
+	response.ItemsPerPage = len(response.Resources)
+	return response, nil
+}
+
+func (s *SCIMService) ListGroups(q ListQuery) (*ListResponse, error) {
+	query, total, err := list(s.db.Model(&group.Group{}), groupColumns, q, "groups.created_at, groups.id")
+	if err != nil {
+		return nil, err
+	}
+	response := &ListResponse{Schemas: []string{SchemaListResponse}, TotalResults: total, StartIndex: q.StartIndex, Resources: []interface{}{}}
+	if q.Count == 0 {
+		return response, nil
+	}
+
+	var groups []group.Group
+	if err := query.Find(&groups).Error; err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	ids := make([]uuid.UUID, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.ID)
+	}
+	members, err := s.groupMembers(ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		response.Resources = append(response.Resources, s.renderGroup(&groups[i], members[groups[i].ID]))
+	}
+	response.ItemsPerPage = len(response.Resources)
+	return response, nil
+}
+
+// GetUser returns the user, or nil if it does not exist
+func (s *SCIMService) GetUser(id string) (*User, error) {
+	u, err := s.findUser(id)
+	if err != nil || u == nil {
+		return nil, err
+	}
+	groups, err := s.userGroups([]uuid.UUID{u.ID})
+	if err != nil {
+		return nil, err
+	}
+	return s.renderUser(u, groups[u.ID]), nil
+}
+
+func (s *SCIMService) findUser(id string) (*user.User, error) {
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, nil
+	}
+	return s.userService.GetUser(userID)
+}
+
+func (s *SCIMService) CreateUser(resource *User, actor string) (*User, error) {
+	email := resource.address("")
+	if email == "" {
+		return nil, badRequest(ErrorInvalidValue, "userName must be an email address")
+	}
+	if err := s.checkEmailAvailable(email, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	name := resource.name()
+	var created *user.User
+	var err error
+	if resource.Password != "" {
+		created, err = s.userService.CreateUser(user.UserCreateRequest{
+			Name:      resource.displayName(),
+			FirstName: name.GivenName,
+			LastName:  name.FamilyName,
+			Email:     email,
+			Password:  resource.Password,
+		})
+	} else {
+		created, err = s.userService.CreateFederatedUser(user.FederatedUserRequest{
+			ProviderID: ProviderID,
+			Name:       resource.displayName(),
+			FirstName:  name.GivenName,
+			LastName:   name.FamilyName,
+			Email:      email,
+		})
+	}
+	if err != nil {
+		return nil, clientError(err)
+	}
+
+	if resource.ExternalID != "" {
+		if err := s.db.Model(created).Update("external_id", resource.ExternalID).Error; err != nil {
+			return nil, fmt.Errorf("failed to set external ID: %w", err)
+		}
+	}
+	if err := s.applyActive(created, resource.Active, actor); err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("SCIM provisioned user %s (%s)", created.ID, email)
+	return s.GetUser(created.ID.String())
+}
+
+// ReplaceUser replaces the user's attributes (PUT). Returns nil if the user
+// does not exist.
+func (s *SCIMService) ReplaceUser(id string, resource *User, ifMatch, actor string) (*User, error) {
+	existing, current, err := s.loadUser(id, ifMatch)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+	if err := s.updateUser(existing, current, resource, actor); err != nil {
+		return nil, err
+	}
+	return s.GetUser(id)
+}
+
+// PatchUser applies PATCH operations to the user. Returns nil if the user
+// does not exist.
+func (s *SCIMService) PatchUser(id string, req *PatchRequest, ifMatch, actor string) (*User, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	existing, current, err := s.loadUser(id, ifMatch)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+
+	patched := *current
+	patched.Meta = nil
+	if current.Name != nil {
+		name := *current.Name
+		patched.Name = &name
+	}
+	patched.Emails = append([]MultiValued(nil), current.Emails...)
+	for _, op := range req.Operations {
+		if err := applyUserPatch(&patched, op); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.updateUser(existing, current, &patched, actor); err != nil {
+		return nil, err
+	}
+	return s.GetUser(id)
+}
+
+// DeleteUser deprovisions the user, which removes its memberships and
+// revokes its sessions, and then deletes it. Returns false if the user does
+// not exist.
+func (s *SCIMService) DeleteUser(id, ifMatch, actor string) (bool, error) {
+	existing, _, err := s.loadUser(id, ifMatch)
+	if err != nil || existing == nil {
+		return false, err
+	}
+	if existing.Status != user.StatusDeprovisioned {
+		if _, _, err := s.userService.TransitionUser(existing.ID, user.ActionDeprovision, user.LifecycleTransitionRequest{
+			Reason: "Deleted through SCIM",
+		}, actor); err != nil {
+			return false, err
+		}
+	}
+	if err := s.userService.DeleteUser(existing.ID); err != nil {
+		return false, err
+	}
+	s.logger.Infof("SCIM deleted user %s (%s)", existing.ID, existing.Email)
+	return true, nil
+}
+
+// loadUser returns the user and its rendering after checking the If-Match
+// precondition
+func (s *SCIMService) loadUser(id, ifMatch string) (*user.User, *User, error) {
+	existing, err := s.findUser(id)
+	if err != nil || existing == nil {
+		return nil, nil, err
+	}
+	groups, err := s.userGroups([]uuid.UUID{existing.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+	current := s.renderUser(existing, groups[existing.ID])
+	if ifMatch != "" && !matchesVersion(ifMatch, current.Meta.Version) {
+		return nil, nil, preconditionFailed()
+	}
+	return existing, current, nil
+}
+
+func (s *SCIMService) updateUser(existing *user.User, current, resource *User, actor string) error {
+	email := resource.address(existing.Email)
+	if !strings.EqualFold(email, existing.Email) {
+		if err := s.checkEmailAvailable(email, existing.ID); err != nil {
+			return err
+		}
+	}
+
+	name := resource.name()
+	updated, err := s.userService.UpdateUser(existing.ID, user.UserUpdateRequest{
+		Name:      resource.displayName(),
+		FirstName: name.GivenName,
+		LastName:  name.FamilyName,
+		Email:     email,
+	})
+	if err != nil {
+		return clientError(err)
+	}
+	if updated == nil {
+		return notFound("user %s not found", existing.ID)
+	}
+
+	if resource.ExternalID != current.ExternalID {
+		if err := s.db.Model(updated).Update("external_id", resource.ExternalID).Error; err != nil {
+			return fmt.Errorf("failed to set external ID: %w", err)
+		}
+	}
+	if resource.Password != "" {
+		if _, err := s.userService.ResetPassword(existing.ID, resource.Password); err != nil {
+			return clientError(err)
+		}
+	}
+	return s.applyActive(updated, resource.Active, actor)
+}
+
+// applyActive moves the user through the lifecycle to match SCIM active.
+// Inactive users other than active ones are left as they are.
+func (s *SCIMService) applyActive(u *user.User, active *bool, actor string) error {
+	if active == nil {
+		return nil
+	}
+	var action user.LifecycleAction
+	switch {
+	case *active && (u.Status == user.StatusStaged || u.Status == user.StatusSuspended):
+		action = user.ActionActivate
+	case *active && u.Status == user.StatusLocked:
+		action = user.ActionUnlock
+	case *active && u.Status == user.StatusDeprovisioned:
+		return badRequest(ErrorMutability, "deprovisioned users cannot be reactivated")
+	case !*active && u.Status == user.StatusActive:
+		action = user.ActionSuspend
+	default:
+		return nil
+	}
+	_, _, err := s.userService.TransitionUser(u.ID, action, user.LifecycleTransitionRequest{Reason: "SCIM provisioning"}, actor)
+	return clientError(err)
+}
+
+func (s *SCIMService) checkEmailAvailable(email string, except uuid.UUID) error {
+	var count int64
+	if err := s.db.Model(&user.User{}).Where("LOWER(email) = LOWER(?) AND id <> ?", email, except).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check email: %w", err)
+	}
+	if count > 0 {
+		return conflict("a user with userName %s already exists", email)
+	}
+	return nil
+}
+
+// GetGroup returns the group, or nil if it does not exist
+func (s *SCIMService) GetGroup(id string) (*Group, error) {
+	g, err := s.findGroup(id)
+	if err != nil || g == nil {
+		return nil, err
+	}
+	members, err := s.groupMembers([]uuid.UUID{g.ID})
+	if err != nil {
+		return nil, err
+	}
+	return s.renderGroup(g, members[g.ID]), nil
+}
+
+func (s *SCIMService) findGroup(id string) (*group.Group, error) {
+	groupID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, nil
+	}
+	return s.groupService.GetGroup(groupID)
+}
+
+func (s *SCIMService) CreateGroup(resource *Group) (*Group, error) {
+	if resource.DisplayName == "" {
+		return nil, badRequest(ErrorInvalidValue, "displayName is required")
+	}
+	if err := s.checkGroupNameAvailable(resource.DisplayName, uuid.Nil); err != nil {
+		return nil, err
+	}
+	memberIDs, err := s.resolveMembers(resource.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	created, err := s.groupService.CreateGroup(group.GroupCreateRequest{
+		Name:        resource.DisplayName,
+		DisplayName: resource.DisplayName,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resource.ExternalID != "" {
+		if err := s.db.Model(created).Update("external_id", resource.ExternalID).Error; err != nil {
+			return nil, fmt.Errorf("failed to set external ID: %w", err)
+		}
+	}
+	if err := s.syncMembers(created.ID, nil, memberIDs); err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("SCIM provisioned group %s (%s)", created.ID, created.Name)
+	return s.GetGroup(created.ID.String())
+}
+
+// ReplaceGroup replaces the group's attributes and members (PUT). Returns nil
+// if the group does not exist.
+func (s *SCIMService) ReplaceGroup(id string, resource *Group, ifMatch string) (*Group, error) {
+	existing, current, err := s.loadGroup(id, ifMatch)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+	if err := s.updateGroup(existing, current, resource); err != nil {
+		return nil, err
+	}
+	return s.GetGroup(id)
+}
+
+// PatchGroup applies PATCH operations to the group. Returns nil if the group
+// does not exist.
+func (s *SCIMService) PatchGroup(id string, req *PatchRequest, ifMatch string) (*Group, error) {
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+	existing, current, err := s.loadGroup(id, ifMatch)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+
+	patched := *current
+	patched.Meta = nil
+	patched.Members = append([]MultiValued(nil), current.Members...)
+	for _, op := range req.Operations {
+		if err := applyGroupPatch(&patched, op); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.updateGroup(existing, current, &patched); err != nil {
+		return nil, err
+	}
+	return s.GetGroup(id)
+}
+
+// DeleteGroup deletes the group and its memberships. Returns false if the
+// group does not exist.
+func (s *SCIMService) DeleteGroup(id, ifMatch string) (bool, error) {
+	existing, _, err := s.loadGroup(id, ifMatch)
+	if err != nil || existing == nil {
+		return false, err
+	}
+	if err := member.DeleteGroupMembers(s.db, existing.ID); err != nil {
+		return false, err
+	}
+	if err := s.groupService.DeleteGroup(existing.ID); err != nil {
+		return false, err
+	}
+	s.logger.Infof("SCIM deleted group %s (%s)", existing.ID, existing.Name)
+	return true, nil
+}
+
+func (s *SCIMService) loadGroup(id, ifMatch string) (*group.Group, *Group, error) {
+	existing, err := s.findGroup(id)
+	if err != nil || existing == nil {
+		return nil, nil, err
+	}
+	members, err := s.groupMembers([]uuid.UUID{existing.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+	current := s.renderGroup(existing, members[existing.ID])
+	if ifMatch != "" && !matchesVersion(ifMatch, current.Meta.Version) {
+		return nil, nil, preconditionFailed()
+	}
+	return existing, current, nil
+}
+
+func (s *SCIMService) updateGroup(existing *group.Group, current, resource *Group) error {
+	if resource.DisplayName == "" {
+		return badRequest(ErrorInvalidValue, "displayName is required")
+	}
+	memberIDs, err := s.resolveMembers(resource.Members)
+	if err != nil {
+		return err
+	}
+
+	if resource.DisplayName != existing.Name {
+		if err := s.checkGroupNameAvailable(resource.DisplayName, existing.ID); err != nil {
+			return err
+		}
+		if _, err := s.groupService.UpdateGroup(existing.ID, group.GroupUpdateRequest{
+			Name:        resource.DisplayName,
+			DisplayName: resource.DisplayName,
+		}); err != nil {
+			return err
+		}
+	}
+	if resource.ExternalID != current.ExternalID {
+		if err := s.db.Model(existing).Update("external_id", resource.ExternalID).Error; err != nil {
+			return fmt.Errorf("failed to set external ID: %w", err)
+		}
+	}
+
+	currentIDs := make([]uuid.UUID, 0, len(current.Members))
+	for _, m := range current.Members {
+		currentIDs = append(currentIDs, uuid.MustParse(m.Value))
+	}
+	return s.syncMembers(existing.ID, currentIDs, memberIDs)
+}
+
+// resolveMembers checks that members refer to existing users
+func (s *SCIMService) resolveMembers(members []MultiValued) ([]uuid.UUID, error) {
+	ids := make([]uuid.UUID, 0, len(members))
+	for _, m := range members {
+		id, err := uuid.Parse(m.Value)
+		if err != nil {
+			return nil, badRequest(ErrorInvalidValue, "member %q is not a user ID", m.Value)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	var count int64
+	if err := s.db.Model(&user.User{}).Where("id IN ?", ids).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to check members: %w", err)
+	}
+	if int(count) != len(uniqueIDs(ids)) {
+		return nil, badRequest(ErrorInvalidValue, "members must refer to existing users")
+	}
+	return uniqueIDs(ids), nil
+}
+
+// syncMembers adds and removes memberships so that the group has exactly
+// the wanted members
+func (s *SCIMService) syncMembers(groupID uuid.UUID, current, wanted []uuid.UUID) error {
+	currentSet := make(map[uuid.UUID]bool, len(current))
+	for _, id := range current {
+		currentSet[id] = true
+	}
+	wantedSet := make(map[uuid.UUID]bool, len(wanted))
+	for _, id := range wanted {
+		wantedSet[id] = true
+		if !currentSet[id] {
+			if _, err := s.memberService.AddMember(dto.MemberOpRequest{Op: dto.OpTypeAdd, GroupID: groupID, UserID: id}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, id := range current {
+		if !wantedSet[id] {
+			if err := s.memberService.RemoveMember(groupID, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *SCIMService) checkGroupNameAvailable(name string, except uuid.UUID) error {
+	var count int64
+	if err := s.db.Model(&group.Group{}).Where("name = ? AND id <> ?", name, except).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check group name: %w", err)
+	}
+	if count > 0 {
+		return conflict("a group with displayName %s already exists", name)
+	}
+	return nil
+}
+
+type membershipRow struct {
+	UserID  uuid.UUID
+	GroupID uuid.UUID
+	Display string
+}
+
+// userGroups returns the groups of each user
+func (s *SCIMService) userGroups(userIDs []uuid.UUID) (map[uuid.UUID][]MultiValued, error) {
+	result := make(map[uuid.UUID][]MultiValued)
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+	var rows []membershipRow
+	err := s.db.Table("members").
+		Select("members.user_id, members.group_id, groups.name AS display").
+		Joins("JOIN groups ON groups.id = members.group_id").
+		Where("members.user_id IN ?", userIDs).
+		Order("groups.name").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user groups: %w", err)
+	}
+	for _, row := range rows {
+		result[row.UserID] = append(result[row.UserID], MultiValued{
+			Value:   row.GroupID.String(),
+			Display: row.Display,
+			Type:    "direct",
+			Ref:     s.location("Groups", row.GroupID.String()),
+		})
+	}
+	return result, nil
+}
+
+// groupMembers returns the members of each group
+func (s *SCIMService) groupMembers(groupIDs []uuid.UUID) (map[uuid.UUID][]MultiValued, error) {
+	result := make(map[uuid.UUID][]MultiValued)
+	if len(groupIDs) == 0 {
+		return result, nil
+	}
+	var rows []membershipRow
+	err := s.db.Table("members").
+		Select("members.user_id, members.group_id, users.name AS display").
+		Joins("JOIN users ON users.id = members.user_id").
+		Where("members.group_id IN ?", groupIDs).
+		Order("users.email").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+	for _, row := range rows {
+		result[row.GroupID] = append(result[row.GroupID], MultiValued{
+			Value:   row.UserID.String(),
+			Display: row.Display,
+			Ref:     s.location("Users", row.UserID.String()),
+		})
+	}
+	return result, nil
+}
+
+func (s *SCIMService) renderUser(u *user.User, groups []MultiValued) *User {
+	active := u.Status == user.StatusActive
+	created, modified := u.CreatedAt, u.UpdatedAt
+	resource := &User{
+		Schemas:     []string{SchemaUser},
+		ID:          u.ID.String(),
+		ExternalID:  u.ExternalID,
+		UserName:    u.Email,
+		DisplayName: u.Name,
+		Name: &Name{
+			Formatted:  u.Name,
+			GivenName:  u.FirstName,
+			FamilyName: u.LastName,
+		},
+		Emails: []MultiValued{{Value: u.Email, Type: "work", Primary: true}},
+		Active: &active,
+		Groups: groups,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      &created,
+			LastModified: &modified,
+			Location:     s.location("Users", u.ID.String()),
+		},
+	}
+	resource.Meta.Version = version(resource)
+	return resource
+}
+
+func (s *SCIMService) renderGroup(g *group.Group, members []MultiValued) *Group {
+	created, modified := g.CreatedAt, g.UpdatedAt
+	resource := &Group{
+		Schemas:     []string{SchemaGroup},
+		ID:          g.ID.String(),
+		ExternalID:  g.ExternalID,
+		DisplayName: g.Name,
+		Members:     members,
+		Meta: &Meta{
+			ResourceType: "Group",
+			Created:      &created,
+			LastModified: &modified,
+			Location:     s.location("Groups", g.ID.String()),
+		},
+	}
+	resource.Meta.Version = version(resource)
+	return resource
+}
+
+// clientError turns errors caused by the request into SCIM errors
+func clientError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var policyErr *password.PolicyError
+	if errors.As(err, &policyErr) {
+		return badRequest(ErrorInvalidValue, "%s", err.Error())
+	}
+	if errors.Is(err, user.ErrInvalidTransition) {
+		return &Error{Status: http.StatusBadRequest, ScimType: ErrorMutability, Detail: err.Error()}
+	}
+	return err
+}
+
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	result := ids[:0:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result
+}
@@ -0,0 +1,33 @@
+package session
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SessionFromCookie returns the active login session referenced by the
+// request's session cookie
+func (s *SessionService) SessionFromCookie(ctx *gin.Context) (*Session, error) {
+	value, err := ctx.Cookie(CookieName)
+	if err != nil || value == "" {
+		return nil, ErrSessionNotActive
+	}
+	id, err := uuid.Parse(value)
+	if err != nil {
+		return nil, ErrSessionNotActive
+	}
+	return s.GetActiveSession(id)
+}
+
+// SetCookie hands the session to the browser
+func SetCookie(ctx *gin.Context, session *Session) {
+	maxAge := int(time.Until(session.ExpiresAt).Seconds())
+	ctx.SetCookie(CookieName, session.ID.String(), maxAge, "/", "", false, true)
+}
+
+// ClearCookie removes the session cookie from the browser
+func ClearCookie(ctx *gin.Context) {
+	ctx.SetCookie(CookieName, "", -1, "/", "", false, true)
+}
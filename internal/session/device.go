@@ -0,0 +1,68 @@
+package session
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ClientInfo describes where a request came from
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+}
+
+// ClientInfoFromRequest extracts the client IP and user agent of a request
+func ClientInfoFromRequest(ctx *gin.Context) ClientInfo {
+	return ClientInfo{
+		IPAddress: ctx.ClientIP(),
+		UserAgent: ctx.GetHeader("User-Agent"),
+	}
+}
+
+// Device returns a short human readable description such as "Chrome on macOS"
+func (c ClientInfo) Device() string {
+	ua := c.UserAgent
+	if ua == "" {
+		return "Unknown device"
+	}
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(ua, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "Safari/"):
+		browser = "Safari"
+	case strings.HasPrefix(ua, "curl/"):
+		browser = "curl"
+	case strings.HasPrefix(ua, "PostmanRuntime/"):
+		browser = "Postman"
+	case strings.HasPrefix(ua, "Go-http-client/"):
+		browser = "Go client"
+	}
+
+	os := ""
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+		os = "iOS"
+	case strings.Contains(ua, "Android"):
+		os = "Android"
+	case strings.Contains(ua, "Windows"):
+		os = "Windows"
+	case strings.Contains(ua, "Mac OS X"), strings.Contains(ua, "Macintosh"):
+		os = "macOS"
+	case strings.Contains(ua, "Linux"):
+		os = "Linux"
+	}
+
+	if os == "" {
+		return browser
+	}
+	return browser + " on " + os
+}
@@ -0,0 +1,210 @@
+package session
+
+import (
+	"net/http"
+
+	"idmapp-go/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type SessionController struct {
+	sessionService *SessionService
+	logger         *logrus.Logger
+}
+
+func NewSessionController(sessionService *SessionService) *SessionController {
+	return &SessionController{
+		sessionService: sessionService,
+		logger:         logrus.New(),
+	}
+}
+
+// currentUserID returns the ID of the authenticated user
+func currentUserID(ctx *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(middleware.GetUserID(ctx))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// pathUserID returns the user ID from the :id path parameter
+func pathUserID(ctx *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// Self-service endpoints
+
+func (c *SessionController) GetMySessions(ctx *gin.Context) {
+	if userID, ok := currentUserID(ctx); ok {
+		c.listSessions(ctx, userID)
+	}
+}
+
+func (c *SessionController) RevokeMySession(ctx *gin.Context) {
+	if userID, ok := currentUserID(ctx); ok {
+		c.revokeSession(ctx, userID)
+	}
+}
+
+func (c *SessionController) RevokeMySessions(ctx *gin.Context) {
+	if userID, ok := currentUserID(ctx); ok {
+		c.revokeAllSessions(ctx, userID)
+	}
+}
+
+func (c *SessionController) GetMyGrants(ctx *gin.Context) {
+	if userID, ok := currentUserID(ctx); ok {
+		c.listGrants(ctx, userID)
+	}
+}
+
+func (c *SessionController) RevokeMyGrant(ctx *gin.Context) {
+	if userID, ok := currentUserID(ctx); ok {
+		c.revokeGrant(ctx, userID)
+	}
+}
+
+func (c *SessionController) RevokeMyGrants(ctx *gin.Context) {
+	if userID, ok := currentUserID(ctx); ok {
+		c.revokeAllGrants(ctx, userID)
+	}
+}
+
+// Admin endpoints
+
+func (c *SessionController) GetUserSessions(ctx *gin.Context) {
+	if userID, ok := pathUserID(ctx); ok {
+		c.listSessions(ctx, userID)
+	}
+}
+
+func (c *SessionController) RevokeUserSession(ctx *gin.Context) {
+	if userID, ok := pathUserID(ctx); ok {
+		c.revokeSession(ctx, userID)
+	}
+}
+
+func (c *SessionController) RevokeUserSessions(ctx *gin.Context) {
+	if userID, ok := pathUserID(ctx); ok {
+		c.revokeAllSessions(ctx, userID)
+	}
+}
+
+func (c *SessionController) GetUserGrants(ctx *gin.Context) {
+	if userID, ok := pathUserID(ctx); ok {
+		c.listGrants(ctx, userID)
+	}
+}
+
+func (c *SessionController) RevokeUserGrant(ctx *gin.Context) {
+	if userID, ok := pathUserID(ctx); ok {
+		c.revokeGrant(ctx, userID)
+	}
+}
+
+func (c *SessionController) RevokeUserGrants(ctx *gin.Context) {
+	if userID, ok := pathUserID(ctx); ok {
+		c.revokeAllGrants(ctx, userID)
+	}
+}
+
+func (c *SessionController) listSessions(ctx *gin.Context, userID uuid.UUID) {
+	sessions, err := c.sessionService.WithContext(ctx).ListSessions(userID)
+	if err != nil {
+		c.logger.Errorf("Failed to get sessions: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	currentSessionID := middleware.GetSessionID(ctx)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID.String() == currentSessionID
+	}
+
+	ctx.JSON(http.StatusOK, sessions)
+}
+
+func (c *SessionController) revokeSession(ctx *gin.Context, userID uuid.UUID) {
+	sessionID, err := uuid.Parse(ctx.Param("sessionId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	revoked, err := c.sessionService.WithContext(ctx).RevokeSession(userID, sessionID, "revoked", middleware.GetUserID(ctx))
+	if err != nil {
+		c.logger.Errorf("Failed to revoke session: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !revoked {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *SessionController) revokeAllSessions(ctx *gin.Context, userID uuid.UUID) {
+	revoked, err := c.sessionService.WithContext(ctx).RevokeAllSessions(userID, "revoked", middleware.GetUserID(ctx))
+	if err != nil {
+		c.logger.Errorf("Failed to revoke sessions: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+func (c *SessionController) listGrants(ctx *gin.Context, userID uuid.UUID) {
+	grants, err := c.sessionService.WithContext(ctx).ListGrants(userID)
+	if err != nil {
+		c.logger.Errorf("Failed to get grants: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get grants"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, grants)
+}
+
+func (c *SessionController) revokeGrant(ctx *gin.Context, userID uuid.UUID) {
+	grantID, err := uuid.Parse(ctx.Param("grantId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid grant ID"})
+		return
+	}
+
+	revoked, err := c.sessionService.WithContext(ctx).RevokeGrant(userID, grantID)
+	if err != nil {
+		c.logger.Errorf("Failed to revoke grant: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !revoked {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Grant not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *SessionController) revokeAllGrants(ctx *gin.Context, userID uuid.UUID) {
+	revoked, err := c.sessionService.WithContext(ctx).RevokeAllGrants(userID)
+	if err != nil {
+		c.logger.Errorf("Failed to revoke grants: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
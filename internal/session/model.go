@@ -0,0 +1,89 @@
+package session
+
+import (
+	"time"
+
+	"idmapp-go/dto"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// CookieName is the browser cookie that carries the login session ID
+	CookieName = "idm_session"
+	// APIClientID is the client ID recorded for sessions started through the
+	// JSON login API
+	APIClientID = "api"
+	// BrowserClientID is the client ID recorded for sessions started through
+	// the login form
+	BrowserClientID = "browser"
+	// ImpersonationClientID is the client ID recorded for impersonation sessions
+	ImpersonationClientID = "impersonation"
+)
+
+// Session is a server-side login session. Access tokens reference it through
+// the "sid" claim so that revoking the session invalidates them.
+type Session struct {
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID        uuid.UUID  `json:"userId" gorm:"type:uuid;not null;index;column:user_id"`
+	ClientID      string     `json:"clientId"`
+	IPAddress     string     `json:"ipAddress" gorm:"column:ip_address"`
+	UserAgent     string     `json:"userAgent"`
+	Device        string     `json:"device"`
+	ActorID       *uuid.UUID `json:"actorId,omitempty" gorm:"type:uuid;index;column:actor_id"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	LastSeenAt    time.Time  `json:"lastSeenAt"`
+	ExpiresAt     time.Time  `json:"expiresAt" gorm:"not null"`
+	RevokedAt     *time.Time `json:"revokedAt,omitempty"`
+	RevokedReason string     `json:"revokedReason,omitempty"`
+	Current       bool       `json:"current" gorm:"-"`
+}
+
+func (s *Session) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+func (s *Session) TableName() string {
+	return "sessions"
+}
+
+// Actor returns the "act" claim for tokens of an impersonation session
+func (s *Session) Actor() *dto.Actor {
+	if s.ActorID == nil {
+		return nil
+	}
+	return &dto.Actor{Sub: s.ActorID.String()}
+}
+
+// RefreshToken is a refresh-token grant issued to a client within a session.
+// Only a hash of the token is stored.
+type RefreshToken struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	SessionID  uuid.UUID  `json:"sessionId" gorm:"type:uuid;not null;index;column:session_id"`
+	UserID     uuid.UUID  `json:"userId" gorm:"type:uuid;not null;index;column:user_id"`
+	ClientID   string     `json:"clientId"`
+	TokenHash  string     `json:"-" gorm:"uniqueIndex;not null"`
+	Scope      string     `json:"scope"`
+	IPAddress  string     `json:"ipAddress" gorm:"column:ip_address"`
+	UserAgent  string     `json:"userAgent"`
+	Device     string     `json:"device"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  time.Time  `json:"expiresAt" gorm:"not null"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+func (t *RefreshToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+func (t *RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
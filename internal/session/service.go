@@ -0,0 +1,389 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"idmapp-go/internal/events"
+	"idmapp-go/middleware"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// lastSeenResolution limits how often LastSeenAt is written for a session
+const lastSeenResolution = time.Minute
+
+var (
+	ErrSessionNotActive    = errors.New("session is not active")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+type SessionService struct {
+	db              *gorm.DB
+	sessionTTL      time.Duration
+	refreshTokenTTL time.Duration
+	logger          *logrus.Logger
+}
+
+func NewSessionService(db *gorm.DB, sessionTTL, refreshTokenTTL time.Duration) *SessionService {
+	return &SessionService{
+		db:              db,
+		sessionTTL:      sessionTTL,
+		refreshTokenTTL: refreshTokenTTL,
+		logger:          logrus.New(),
+	}
+}
+
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *SessionService) WithContext(ctx context.Context) *SessionService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
+// CreateSession starts a new session for the user
+func (s *SessionService) CreateSession(userID uuid.UUID, clientID string, info ClientInfo) (*Session, error) {
+	return s.createSession(userID, nil, clientID, s.sessionTTL, info)
+}
+
+// CreateImpersonationSession starts a session in which actorID acts as the user
+func (s *SessionService) CreateImpersonationSession(userID, actorID uuid.UUID, ttl time.Duration, info ClientInfo) (*Session, error) {
+	return s.createSession(userID, &actorID, ImpersonationClientID, ttl, info)
+}
+
+func (s *SessionService) createSession(userID uuid.UUID, actorID *uuid.UUID, clientID string, ttl time.Duration, info ClientInfo) (*Session, error) {
+	now := time.Now()
+	session := Session{
+		UserID:     userID,
+		ClientID:   clientID,
+		IPAddress:  info.IPAddress,
+		UserAgent:  info.UserAgent,
+		Device:     info.Device(),
+		ActorID:    actorID,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(ttl),
+	}
+	if err := s.db.Create(&session).Error; err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	event := events.Event{
+		Type:    "session.created",
+		Subject: userID.String(),
+		Data: map[string]interface{}{
+			"sessionId": session.ID.String(),
+			"clientId":  clientID,
+			"ipAddress": info.IPAddress,
+			"device":    session.Device,
+		},
+	}
+	if actorID != nil {
+		event.Actor = actorID.String()
+	}
+	events.Publish(event)
+
+	return &session, nil
+}
+
+// GetActiveSession returns the session if it exists and is neither revoked nor expired
+func (s *SessionService) GetActiveSession(id uuid.UUID) (*Session, error) {
+	var session Session
+	result := s.db.Where("id = ? AND revoked_at IS NULL AND expires_at > ?", id, time.Now()).First(&session)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotActive
+		}
+		return nil, fmt.Errorf("failed to get session: %w", result.Error)
+	}
+	return &session, nil
+}
+
+// ListSessions returns the active sessions of a user, most recently used first
+func (s *SessionService) ListSessions(userID uuid.UUID) ([]Session, error) {
+	var sessions []Session
+	result := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get sessions: %w", result.Error)
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes one session of a user together with its refresh tokens
+func (s *SessionService) RevokeSession(userID, sessionID uuid.UUID, reason, actor string) (bool, error) {
+	revoked, err := s.revokeSessions(reason, "id = ? AND user_id = ?", sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+	if revoked > 0 {
+		s.publishRevoked(userID, actor, reason, sessionID.String())
+	}
+	return revoked > 0, nil
+}
+
+// RevokeAllSessions revokes every active session of a user
+func (s *SessionService) RevokeAllSessions(userID uuid.UUID, reason, actor string) (int64, error) {
+	revoked, err := s.revokeSessions(reason, "user_id = ?", userID)
+	if err != nil {
+		return 0, err
+	}
+	if revoked > 0 {
+		s.publishRevoked(userID, actor, reason, "*")
+	}
+	return revoked, nil
+}
+
+// revokeSessions revokes the active sessions matching query and their refresh tokens
+func (s *SessionService) revokeSessions(reason string, query string, args ...interface{}) (int64, error) {
+	var revoked int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var ids []uuid.UUID
+		if err := tx.Model(&Session{}).Where(query, args...).Where("revoked_at IS NULL").Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		result := tx.Model(&Session{}).Where("id IN ?", ids).
+			Updates(map[string]interface{}{"revoked_at": now, "revoked_reason": reason})
+		if result.Error != nil {
+			return result.Error
+		}
+		revoked = result.RowsAffected
+
+		return tx.Model(&RefreshToken{}).
+			Where("session_id IN ? AND revoked_at IS NULL", ids).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return revoked, nil
+}
+
+func (s *SessionService) publishRevoked(userID uuid.UUID, actor, reason, sessionID string) {
+	events.Publish(events.Event{
+		Type:    "session.revoked",
+		Subject: userID.String(),
+		Actor:   actor,
+		Data: map[string]interface{}{
+			"sessionId": sessionID,
+			"reason":    reason,
+		},
+	})
+}
+
+// IssueRefreshToken creates a refresh-token grant for a client within the
+// session and returns the plaintext token
+func (s *SessionService) IssueRefreshToken(session *Session, clientID, scope string, info ClientInfo) (string, error) {
+	token, hash, err := newRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	grant := RefreshToken{
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		ClientID:  clientID,
+		TokenHash: hash,
+		Scope:     scope,
+		IPAddress: info.IPAddress,
+		UserAgent: info.UserAgent,
+		Device:    info.Device(),
+		CreatedAt: time.Now(),
+		ExpiresAt: s.refreshExpiry(session),
+	}
+	if err := s.db.Create(&grant).Error; err != nil {
+		return "", fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one. Presenting an
+// already rotated token revokes the whole session, since it means the token
+// has leaked.
+func (s *SessionService) RotateRefreshToken(token, clientID string, info ClientInfo) (string, *RefreshToken, *Session, error) {
+	var grant RefreshToken
+	if err := s.db.Where("token_hash = ?", hashRefreshToken(token)).First(&grant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", nil, nil, ErrInvalidRefreshToken
+		}
+		return "", nil, nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+
+	if grant.ClientID != clientID {
+		return "", nil, nil, ErrInvalidRefreshToken
+	}
+	if grant.RevokedAt != nil {
+		s.logger.Warnf("Revoked refresh token %s was reused, revoking session %s", grant.ID, grant.SessionID)
+		if _, err := s.RevokeSession(grant.UserID, grant.SessionID, "refresh token reuse", ""); err != nil {
+			s.logger.Errorf("Failed to revoke session %s: %v", grant.SessionID, err)
+		}
+		return "", nil, nil, ErrInvalidRefreshToken
+	}
+	if time.Now().After(grant.ExpiresAt) {
+		return "", nil, nil, ErrInvalidRefreshToken
+	}
+
+	session, err := s.GetActiveSession(grant.SessionID)
+	if err != nil {
+		if errors.Is(err, ErrSessionNotActive) {
+			return "", nil, nil, ErrInvalidRefreshToken
+		}
+		return "", nil, nil, err
+	}
+
+	newToken, hash, err := newRefreshToken()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	now := time.Now()
+	rotated := RefreshToken{
+		SessionID:  grant.SessionID,
+		UserID:     grant.UserID,
+		ClientID:   grant.ClientID,
+		TokenHash:  hash,
+		Scope:      grant.Scope,
+		IPAddress:  info.IPAddress,
+		UserAgent:  info.UserAgent,
+		Device:     info.Device(),
+		CreatedAt:  grant.CreatedAt,
+		LastUsedAt: &now,
+		ExpiresAt:  s.refreshExpiry(session),
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND revoked_at IS NULL", grant.ID).
+			Updates(map[string]interface{}{"revoked_at": now, "last_used_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Lost a race with a concurrent rotation of the same token
+			return ErrInvalidRefreshToken
+		}
+		if err := tx.Create(&rotated).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Session{}).Where("id = ?", session.ID).Update("last_seen_at", now).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return "", nil, nil, err
+		}
+		return "", nil, nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	return newToken, &rotated, session, nil
+}
+
+// ListGrants returns the active refresh-token grants of a user
+func (s *SessionService) ListGrants(userID uuid.UUID) ([]RefreshToken, error) {
+	var grants []RefreshToken
+	result := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("created_at DESC").
+		Find(&grants)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get refresh tokens: %w", result.Error)
+	}
+	return grants, nil
+}
+
+// RevokeGrant revokes one refresh-token grant of a user
+func (s *SessionService) RevokeGrant(userID, grantID uuid.UUID) (bool, error) {
+	result := s.db.Model(&RefreshToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", grantID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to revoke refresh token: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// RevokeAllGrants revokes every refresh-token grant of a user
+func (s *SessionService) RevokeAllGrants(userID uuid.UUID) (int64, error) {
+	result := s.db.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to revoke refresh tokens: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// ValidateToken implements middleware.TokenValidator by rejecting access
+// tokens whose session has been revoked or has expired
+func (s *SessionService) ValidateToken(claims *middleware.Claims) error {
+	if claims.SessionID == "" {
+		return nil
+	}
+
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return errors.New("invalid session ID")
+	}
+	if _, err := s.GetActiveSession(sessionID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if err := s.db.Model(&Session{}).
+		Where("id = ? AND last_seen_at < ?", sessionID, now.Add(-lastSeenResolution)).
+		Update("last_seen_at", now).Error; err != nil {
+		s.logger.Warnf("Failed to update last seen time of session %s: %v", sessionID, err)
+	}
+	return nil
+}
+
+// HandleUserDisabled revokes all sessions when a user can no longer sign in,
+// including the sessions in which the user impersonates someone else
+func (s *SessionService) HandleUserDisabled(event events.Event) {
+	userID, err := uuid.Parse(event.Subject)
+	if err != nil {
+		return
+	}
+	if _, err := s.RevokeAllSessions(userID, event.Type, event.Actor); err != nil {
+		s.logger.Errorf("Failed to revoke sessions of user %s: %v", userID, err)
+	}
+	if _, err := s.revokeSessions(event.Type, "actor_id = ?", userID); err != nil {
+		s.logger.Errorf("Failed to revoke impersonation sessions of user %s: %v", userID, err)
+	}
+}
+
+// refreshExpiry caps refresh tokens at the lifetime of their session
+func (s *SessionService) refreshExpiry(session *Session) time.Time {
+	expiresAt := time.Now().Add(s.refreshTokenTTL)
+	if session.ExpiresAt.Before(expiresAt) {
+		return session.ExpiresAt
+	}
+	return expiresAt
+}
+
+func newRefreshToken() (string, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(bytes)
+	return token, hashRefreshToken(token), nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,370 @@
+package sod
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"idmapp-go/internal/events"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// lockKey is the advisory lock serializing grants while policies exist, so
+// concurrent grants can't break a policy together
+const lockKey = 0x736f64
+
+// Types of the nodes of the membership tables, as in their type columns
+const (
+	TypeUser  = "USER"
+	TypeGroup = "GROUP"
+	TypeRole  = "ROLE"
+	TypeOrg   = "ORG"
+)
+
+// ErrViolation matches every *ViolationError
+var ErrViolation = errors.New("separation-of-duties violation")
+
+// ViolationError rejects a grant that would make users break policies
+type ViolationError struct {
+	Violations []Violation
+}
+
+func (e *ViolationError) Error() string {
+	v := e.Violations[0]
+	names := make([]string, len(v.Roles))
+	for i, role := range v.Roles {
+		names[i] = role.Name
+		if names[i] == "" {
+			names[i] = role.ID.String()
+		}
+		names[i] = strconv.Quote(names[i])
+	}
+	return fmt.Sprintf("separation-of-duties policy %q allows at most %d of roles %s; user %s would hold all of them",
+		v.PolicyName, v.MaxRoles, strings.Join(names, ", "), v.UserID)
+}
+
+func (e *ViolationError) Is(target error) bool {
+	return target == ErrViolation
+}
+
+// Guard keeps a membership or role assignment written in a transaction from
+// making a user break a policy. Violations that already exist don't block a
+// grant; only those it causes, or extends to another role, do.
+//
+//	guard, err := sod.Begin(tx)
+//	... write the grant ...
+//	return guard.Check(tx)
+type Guard struct {
+	active bool
+	now    time.Time
+	before map[violationKey]Violation
+}
+
+type violationKey struct {
+	PolicyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+// Begin records the violations before a grant is written in tx. It does
+// nothing while the tenant has no policies.
+func Begin(tx *gorm.DB) (*Guard, error) {
+	var count int64
+	if err := tx.Model(&Policy{}).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to check separation-of-duties policies: %w", err)
+	}
+	if count == 0 {
+		return &Guard{}, nil
+	}
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockKey).Error; err != nil {
+		return nil, fmt.Errorf("failed to lock separation-of-duties policies: %w", err)
+	}
+	now := time.Now()
+	before, err := FindViolations(tx, now)
+	if err != nil {
+		return nil, err
+	}
+	return &Guard{active: true, now: now, before: index(before)}, nil
+}
+
+// Check returns a *ViolationError if the grant written since Begin makes a
+// user break a policy without an exception
+func (g *Guard) Check(tx *gorm.DB) error {
+	if !g.active {
+		return nil
+	}
+	after, err := FindViolations(tx, g.now)
+	if err != nil {
+		return err
+	}
+	caused := causedViolations(g.before, after)
+	if len(caused) == 0 {
+		return nil
+	}
+	for _, v := range caused {
+		events.Publish(events.Event{
+			Type:    "sod.violation_blocked",
+			Subject: v.PolicyID.String(),
+			Data:    map[string]interface{}{"userId": v.UserID.String(), "roles": roleIDs(v.Roles)},
+		})
+	}
+	return &ViolationError{Violations: caused}
+}
+
+// FindViolations returns every user holding more roles of a policy than it
+// allows at now, ordered by policy name and user. Roles count whether
+// assigned to the user, to a group they are in, nested ones included, or to
+// an org they or their groups are members of, cascading memberships of the
+// orgs above included. Grants count from when they are added, scheduled
+// ones included, until they expire. Violations covered by an exception have
+// it set.
+func FindViolations(db *gorm.DB, now time.Time) ([]Violation, error) {
+	policies, err := loadPolicies(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	held := make(map[uuid.UUID]map[uuid.UUID]bool)
+	var allRoles []uuid.UUID
+	for _, policy := range policies {
+		for _, roleID := range policy.RoleIDs {
+			if _, ok := held[roleID]; ok {
+				continue
+			}
+			users, err := holders(db, roleID, now)
+			if err != nil {
+				return nil, err
+			}
+			held[roleID] = users
+			allRoles = append(allRoles, roleID)
+		}
+	}
+	names, err := roleNames(db, allRoles)
+	if err != nil {
+		return nil, err
+	}
+	var exceptions []Exception
+	if err := db.Where("expires_at > ?", now).Order("expires_at").Find(&exceptions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get separation-of-duties exceptions: %w", err)
+	}
+	return evaluate(policies, held, names, exceptions), nil
+}
+
+// evaluate finds the users holding more roles of each policy than it allows,
+// given the holders of each role. The exception expiring last covers a
+// violation.
+func evaluate(policies []Policy, held map[uuid.UUID]map[uuid.UUID]bool, names map[uuid.UUID]string, exceptions []Exception) []Violation {
+	exempt := make(map[violationKey]Exception)
+	for _, e := range exceptions {
+		key := violationKey{PolicyID: e.PolicyID, UserID: e.UserID}
+		if current, ok := exempt[key]; !ok || e.ExpiresAt.After(current.ExpiresAt) {
+			exempt[key] = e
+		}
+	}
+
+	var violations []Violation
+	for _, policy := range policies {
+		roles := make(map[uuid.UUID][]RoleRef)
+		for _, roleID := range policy.RoleIDs {
+			for userID := range held[roleID] {
+				roles[userID] = append(roles[userID], RoleRef{ID: roleID, Name: names[roleID]})
+			}
+		}
+		for userID, userRoles := range roles {
+			if len(userRoles) <= policy.MaxRoles {
+				continue
+			}
+			sort.Slice(userRoles, func(i, j int) bool { return userRoles[i].ID.String() < userRoles[j].ID.String() })
+			v := Violation{PolicyID: policy.ID, PolicyName: policy.Name, MaxRoles: policy.MaxRoles, UserID: userID, Roles: userRoles}
+			if e, ok := exempt[violationKey{PolicyID: policy.ID, UserID: userID}]; ok {
+				v.ExceptionID = &e.ID
+				v.ExemptUntil = &e.ExpiresAt
+			}
+			violations = append(violations, v)
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].PolicyName != violations[j].PolicyName {
+			return violations[i].PolicyName < violations[j].PolicyName
+		}
+		if violations[i].PolicyID != violations[j].PolicyID {
+			return violations[i].PolicyID.String() < violations[j].PolicyID.String()
+		}
+		return violations[i].UserID.String() < violations[j].UserID.String()
+	})
+	return violations
+}
+
+// causedViolations returns the violations of after without an exception
+// that before didn't have, or had with fewer roles
+func causedViolations(before map[violationKey]Violation, after []Violation) []Violation {
+	var caused []Violation
+	for _, v := range after {
+		if v.ExceptionID != nil {
+			continue
+		}
+		previous, ok := before[violationKey{PolicyID: v.PolicyID, UserID: v.UserID}]
+		if !ok || len(v.Roles) > len(previous.Roles) {
+			caused = append(caused, v)
+		}
+	}
+	return caused
+}
+
+func index(violations []Violation) map[violationKey]Violation {
+	indexed := make(map[violationKey]Violation, len(violations))
+	for _, v := range violations {
+		indexed[violationKey{PolicyID: v.PolicyID, UserID: v.UserID}] = v
+	}
+	return indexed
+}
+
+func roleIDs(roles []RoleRef) []string {
+	ids := make([]string, len(roles))
+	for i, role := range roles {
+		ids[i] = role.ID.String()
+	}
+	return ids
+}
+
+// node is a user, group, role or org of the membership tables
+type node struct {
+	Type string
+	ID   uuid.UUID
+}
+
+// holders walks the membership tables down from the role and returns the
+// users who hold it at now
+func holders(db *gorm.DB, roleID uuid.UUID, now time.Time) (map[uuid.UUID]bool, error) {
+	users := make(map[uuid.UUID]bool)
+	start := node{Type: TypeRole, ID: roleID}
+	seen := map[node]bool{start: true}
+	frontier := []node{start}
+	for len(frontier) > 0 {
+		found, err := below(db, frontier, now)
+		if err != nil {
+			return nil, err
+		}
+		frontier = nil
+		for _, n := range found {
+			if n.Type == TypeUser {
+				users[n.ID] = true
+				continue
+			}
+			if !seen[n] {
+				seen[n] = true
+				frontier = append(frontier, n)
+			}
+		}
+	}
+	return users, nil
+}
+
+// below returns the assignees of the roles, the members of the groups and
+// the user and group members of the orgs, cascading members of the orgs
+// above included
+func below(db *gorm.DB, nodes []node, now time.Time) ([]node, error) {
+	ids := make(map[string][]uuid.UUID)
+	for _, n := range nodes {
+		ids[n.Type] = append(ids[n.Type], n.ID)
+	}
+	unexpired := func(table string) string {
+		return "(" + table + ".valid_until IS NULL OR " + table + ".valid_until > ?)"
+	}
+
+	var found []node
+	scan := func(what string, query *gorm.DB) error {
+		var rows []struct {
+			EntityID uuid.UUID
+			Type     string
+		}
+		if err := query.Find(&rows).Error; err != nil {
+			return fmt.Errorf("failed to load %s: %w", what, err)
+		}
+		for _, row := range rows {
+			// Roles in orgs don't pass the roles of the org on
+			if nodeType := strings.ToUpper(row.Type); nodeType != TypeRole {
+				found = append(found, node{Type: nodeType, ID: row.EntityID})
+			}
+		}
+		return nil
+	}
+	if roles := ids[TypeRole]; len(roles) > 0 {
+		err := scan("role assignments", db.Table("role_members").Select("entity_id, type").
+			Where("role_id IN ?", roles).Where(unexpired("role_members"), now))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if groups := ids[TypeGroup]; len(groups) > 0 {
+		err := scan("group memberships", db.Table("members").Select("user_id AS entity_id, 'USER' AS type").
+			Where("group_id IN ?", groups).Where(unexpired("members"), now))
+		if err != nil {
+			return nil, err
+		}
+		err = scan("nested groups", db.Table("nested_groups").Select("member_group_id AS entity_id, 'GROUP' AS type").
+			Where("group_id IN ?", groups))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if orgs := ids[TypeOrg]; len(orgs) > 0 {
+		err := scan("org memberships", db.Table("org_members m").Select("m.entity_id, m.type").
+			Joins("JOIN orgs a ON a.id = m.org_id").
+			Joins("JOIN orgs o ON o.path LIKE a.path || '%'").
+			Where("o.id IN ?", orgs).
+			Where(`m.org_id = o.id OR m."cascade"`))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// loadPolicies returns the policies with their roles, by name
+func loadPolicies(db *gorm.DB) ([]Policy, error) {
+	var policies []Policy
+	if err := db.Order("name, id").Find(&policies).Error; err != nil {
+		return nil, fmt.Errorf("failed to get separation-of-duties policies: %w", err)
+	}
+	if len(policies) == 0 {
+		return policies, nil
+	}
+	var roles []PolicyRole
+	if err := db.Order("role_id").Find(&roles).Error; err != nil {
+		return nil, fmt.Errorf("failed to get separation-of-duties policy roles: %w", err)
+	}
+	byPolicy := make(map[uuid.UUID][]uuid.UUID)
+	for _, role := range roles {
+		byPolicy[role.PolicyID] = append(byPolicy[role.PolicyID], role.RoleID)
+	}
+	for i := range policies {
+		policies[i].RoleIDs = byPolicy[policies[i].ID]
+	}
+	return policies, nil
+}
+
+func roleNames(db *gorm.DB, ids []uuid.UUID) (map[uuid.UUID]string, error) {
+	names := make(map[uuid.UUID]string)
+	if len(ids) == 0 {
+		return names, nil
+	}
+	var rows []struct {
+		ID   uuid.UUID
+		Name string
+	}
+	if err := db.Table("roles").Select("id, name").Where("id IN ?", ids).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get role names: %w", err)
+	}
+	for _, row := range rows {
+		names[row.ID] = row.Name
+	}
+	return names, nil
+}
@@ -0,0 +1,128 @@
+package sod
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "sod.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	validity := "valid_from DATETIME, valid_until DATETIME, activated_at DATETIME, expiry_notified_at DATETIME"
+	for _, ddl := range []string{
+		"CREATE TABLE roles (id TEXT PRIMARY KEY, name TEXT)",
+		"CREATE TABLE orgs (id TEXT PRIMARY KEY, path TEXT)",
+		"CREATE TABLE members (id TEXT PRIMARY KEY, tenant_id TEXT, group_id TEXT, user_id TEXT, created_at DATETIME, updated_at DATETIME, " + validity + ")",
+		"CREATE TABLE nested_groups (id TEXT PRIMARY KEY, tenant_id TEXT, group_id TEXT, member_group_id TEXT, created_at DATETIME)",
+		"CREATE TABLE org_members (id TEXT PRIMARY KEY, tenant_id TEXT, org_id TEXT, entity_id TEXT, type TEXT, \"cascade\" BOOLEAN, created_at DATETIME)",
+		"CREATE TABLE role_members (id TEXT PRIMARY KEY, tenant_id TEXT, role_id TEXT, entity_id TEXT, type TEXT, created_at DATETIME, " + validity + ")",
+		"CREATE TABLE sod_policies (id TEXT PRIMARY KEY, tenant_id TEXT, name TEXT, description TEXT, max_roles INTEGER, created_by TEXT, created_at DATETIME, updated_at DATETIME)",
+		"CREATE TABLE sod_policy_roles (id TEXT PRIMARY KEY, tenant_id TEXT, policy_id TEXT, role_id TEXT)",
+		"CREATE TABLE sod_exceptions (id TEXT PRIMARY KEY, tenant_id TEXT, policy_id TEXT, user_id TEXT, reason TEXT, approved_by TEXT, expires_at DATETIME, created_at DATETIME)",
+	} {
+		require.NoError(t, db.Exec(ddl).Error)
+	}
+	return db
+}
+
+func TestFindViolations(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now()
+	exec := func(sql string, args ...interface{}) {
+		require.NoError(t, db.Exec(sql, args...).Error)
+	}
+
+	approver, submitter, auditor := uuid.New(), uuid.New(), uuid.New()
+	for id, name := range map[uuid.UUID]string{approver: "payments-approver", submitter: "payments-submitter", auditor: "auditor"} {
+		exec("INSERT INTO roles (id, name) VALUES (?, ?)", id, name)
+	}
+	payments := &Policy{ID: uuid.New(), Name: "payments", MaxRoles: 1, CreatedAt: now, UpdatedAt: now}
+	require.NoError(t, db.Create(payments).Error)
+	for _, roleID := range []uuid.UUID{approver, submitter} {
+		require.NoError(t, db.Create(&PolicyRole{PolicyID: payments.ID, RoleID: roleID}).Error)
+	}
+
+	jane, joe, john := uuid.New(), uuid.New(), uuid.New()
+	staff, team, ops := uuid.New(), uuid.New(), uuid.New()
+	parent, child := uuid.New(), uuid.New()
+	exec("INSERT INTO orgs (id, path) VALUES (?, ?), (?, ?)", parent, "/"+parent.String()+"/", child, "/"+parent.String()+"/"+child.String()+"/")
+	addRole := func(roleID, entityID uuid.UUID, memberType string, validUntil *time.Time) {
+		exec("INSERT INTO role_members (id, role_id, entity_id, type, valid_until) VALUES (?, ?, ?, ?, ?)", uuid.New(), roleID, entityID, memberType, validUntil)
+	}
+
+	// Jane is an approver and a submitter through a group nested in staff
+	addRole(approver, jane, "USER", nil)
+	addRole(submitter, staff, "GROUP", nil)
+	exec("INSERT INTO nested_groups (id, group_id, member_group_id) VALUES (?, ?, ?)", uuid.New(), staff, team)
+	exec("INSERT INTO members (id, group_id, user_id) VALUES (?, ?, ?)", uuid.New(), team, jane)
+
+	// Joe is a submitter and an approver through a cascading org membership
+	addRole(submitter, joe, "USER", nil)
+	addRole(approver, child, "ORG", nil)
+	exec("INSERT INTO org_members (id, org_id, entity_id, type, \"cascade\") VALUES (?, ?, ?, ?, ?)", uuid.New(), parent, ops, "GROUP", true)
+	exec("INSERT INTO members (id, group_id, user_id) VALUES (?, ?, ?)", uuid.New(), ops, joe)
+
+	// John's submitter assignment has expired, and auditor isn't in a policy
+	expired := now.Add(-time.Hour)
+	addRole(approver, john, "user", nil)
+	addRole(submitter, john, "USER", &expired)
+	addRole(auditor, john, "USER", nil)
+
+	exception := &Exception{ID: uuid.New(), PolicyID: payments.ID, UserID: joe, Reason: "migration", ExpiresAt: now.Add(24 * time.Hour), CreatedAt: now}
+	require.NoError(t, db.Create(exception).Error)
+	lapsed := &Exception{ID: uuid.New(), PolicyID: payments.ID, UserID: jane, Reason: "covering", ExpiresAt: now.Add(-time.Minute), CreatedAt: now}
+	require.NoError(t, db.Create(lapsed).Error)
+
+	violations, err := FindViolations(db, now)
+	require.NoError(t, err)
+	require.Len(t, violations, 2)
+	byUser := map[uuid.UUID]Violation{}
+	for _, v := range violations {
+		assert.Equal(t, payments.ID, v.PolicyID)
+		assert.Len(t, v.Roles, 2)
+		byUser[v.UserID] = v
+	}
+	assert.Nil(t, byUser[jane].ExceptionID)
+	require.NotNil(t, byUser[joe].ExceptionID)
+	assert.Equal(t, exception.ID, *byUser[joe].ExceptionID)
+	assert.Contains(t, byUser[jane].Roles, RoleRef{ID: approver, Name: "payments-approver"})
+	_, ok := byUser[john]
+	assert.False(t, ok)
+}
+
+func TestCausedViolations(t *testing.T) {
+	policyID, jane, joe, john := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	a, b, c := RoleRef{ID: uuid.New()}, RoleRef{ID: uuid.New()}, RoleRef{ID: uuid.New()}
+	exceptionID := uuid.New()
+	before := index([]Violation{
+		{PolicyID: policyID, UserID: jane, Roles: []RoleRef{a, b}},
+		{PolicyID: policyID, UserID: joe, Roles: []RoleRef{a, b}},
+	})
+	after := []Violation{
+		// Unchanged
+		{PolicyID: policyID, UserID: jane, Roles: []RoleRef{a, b}},
+		// Extended to a third role
+		{PolicyID: policyID, PolicyName: "payments", MaxRoles: 1, UserID: joe, Roles: []RoleRef{a, b, c}},
+		// New, but covered by an exception
+		{PolicyID: policyID, UserID: john, Roles: []RoleRef{a, c}, ExceptionID: &exceptionID},
+	}
+
+	caused := causedViolations(before, after)
+	require.Len(t, caused, 1)
+	assert.Equal(t, joe, caused[0].UserID)
+
+	err := error(&ViolationError{Violations: caused})
+	assert.True(t, errors.Is(err, ErrViolation))
+	assert.Contains(t, err.Error(), `policy "payments" allows at most 1 of roles`)
+}
@@ -0,0 +1,40 @@
+package sod
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PolicyRequest struct {
+	Name        string      `json:"name" binding:"required,max=255"`
+	Description string      `json:"description" binding:"max=2000"`
+	RoleIDs     []uuid.UUID `json:"roleIds" binding:"required,min=2,max=20"`
+	// MaxRoles defaults to 1
+	MaxRoles int `json:"maxRoles" binding:"min=0"`
+}
+
+type ExceptionRequest struct {
+	UserID    uuid.UUID `json:"userId" binding:"required"`
+	Reason    string    `json:"reason" binding:"required,max=2000"`
+	ExpiresAt time.Time `json:"expiresAt" binding:"required"`
+}
+
+// RoleRef is a role held in breach of a policy
+type RoleRef struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+}
+
+// Violation is a user holding more roles of a policy than it allows
+type Violation struct {
+	PolicyID   uuid.UUID `json:"policyId"`
+	PolicyName string    `json:"policyName"`
+	MaxRoles   int       `json:"maxRoles"`
+	UserID     uuid.UUID `json:"userId"`
+	Roles      []RoleRef `json:"roles"`
+	// ExceptionID and ExemptUntil are set while an exception covers the
+	// violation
+	ExceptionID *uuid.UUID `json:"exceptionId,omitempty"`
+	ExemptUntil *time.Time `json:"exemptUntil,omitempty"`
+}
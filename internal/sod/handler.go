@@ -0,0 +1,19 @@
+package sod
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RespondViolation answers a grant rejected by a policy with 409 and the
+// violations, and reports whether err was such a rejection
+func RespondViolation(ctx *gin.Context, err error) bool {
+	var violation *ViolationError
+	if !errors.As(err, &violation) {
+		return false
+	}
+	ctx.JSON(http.StatusConflict, gin.H{"error": violation.Error(), "violations": violation.Violations})
+	return true
+}
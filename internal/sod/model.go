@@ -0,0 +1,82 @@
+package sod
+
+import (
+	"time"
+
+	"idmapp-go/internal/tenant"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Policy is a separation-of-duties rule: no user may hold more than
+// MaxRoles of its roles at once, whether assigned to them directly or
+// inherited through groups and orgs. A pair of roles with MaxRoles 1 makes
+// them mutually exclusive.
+type Policy struct {
+	tenant.Scoped
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name        string     `json:"name" gorm:"not null"`
+	Description string     `json:"description"`
+	MaxRoles    int        `json:"maxRoles" gorm:"not null;default:1"`
+	CreatedBy   *uuid.UUID `json:"createdBy,omitempty" gorm:"type:uuid"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	// RoleIDs are the roles the policy keeps apart
+	RoleIDs []uuid.UUID `json:"roleIds" gorm:"-"`
+}
+
+func (p *Policy) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == uuid.Nil {
+		p.ID = uuid.New()
+	}
+	return nil
+}
+
+func (p *Policy) TableName() string {
+	return "sod_policies"
+}
+
+// PolicyRole is a role of a policy
+type PolicyRole struct {
+	tenant.Scoped
+	ID       uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PolicyID uuid.UUID `json:"policyId" gorm:"type:uuid;not null;uniqueIndex:idx_sod_policy_roles_role"`
+	RoleID   uuid.UUID `json:"roleId" gorm:"type:uuid;not null;uniqueIndex:idx_sod_policy_roles_role;index"`
+}
+
+func (r *PolicyRole) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (r *PolicyRole) TableName() string {
+	return "sod_policy_roles"
+}
+
+// Exception lets a user break a policy until ExpiresAt. Grants made while
+// it lasts aren't rejected, and the scan reports the user as exempt; once it
+// expires the scan reports the violation again.
+type Exception struct {
+	tenant.Scoped
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	PolicyID   uuid.UUID  `json:"policyId" gorm:"type:uuid;not null;index"`
+	UserID     uuid.UUID  `json:"userId" gorm:"type:uuid;not null;index"`
+	Reason     string     `json:"reason" gorm:"not null"`
+	ApprovedBy *uuid.UUID `json:"approvedBy,omitempty" gorm:"type:uuid"`
+	ExpiresAt  time.Time  `json:"expiresAt" gorm:"not null;index"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func (e *Exception) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
+
+func (e *Exception) TableName() string {
+	return "sod_exceptions"
+}
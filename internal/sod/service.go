@@ -0,0 +1,333 @@
+package sod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/tenant"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPolicyNotFound    = errors.New("separation-of-duties policy not found")
+	ErrExceptionNotFound = errors.New("separation-of-duties exception not found")
+	ErrRoleNotFound      = errors.New("role not found")
+	ErrUserNotFound      = errors.New("user not found")
+	ErrInvalidPolicy     = errors.New("a policy needs distinct roles and maxRoles below their number")
+	ErrInvalidExpiry     = errors.New("expiresAt must be in the future")
+)
+
+// PolicyService manages separation-of-duties policies and their exceptions.
+// The membership and role assignment writes enforce the policies through
+// Guard.
+type PolicyService struct {
+	db     *gorm.DB
+	logger *logrus.Logger
+}
+
+func NewPolicyService(db *gorm.DB) *PolicyService {
+	return &PolicyService{
+		db:     db,
+		logger: logrus.New(),
+	}
+}
+
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *PolicyService) WithContext(ctx context.Context) *PolicyService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
+// GetPolicies returns the policies with their roles, by name
+func (s *PolicyService) GetPolicies() ([]Policy, error) {
+	return loadPolicies(s.db)
+}
+
+// GetPolicy returns the policy with its roles, or nil if it doesn't exist
+func (s *PolicyService) GetPolicy(id uuid.UUID) (*Policy, error) {
+	var policy Policy
+	if err := s.db.First(&policy, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get separation-of-duties policy: %w", err)
+	}
+	if err := s.db.Model(&PolicyRole{}).Where("policy_id = ?", id).Order("role_id").Pluck("role_id", &policy.RoleIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to get separation-of-duties policy roles: %w", err)
+	}
+	return &policy, nil
+}
+
+// CreatePolicy adds a policy. Existing violations of it don't block it; the
+// scan reports them.
+func (s *PolicyService) CreatePolicy(createdBy uuid.UUID, req PolicyRequest) (*Policy, error) {
+	now := time.Now()
+	policy := &Policy{
+		ID:        uuid.New(),
+		CreatedBy: &createdBy,
+		CreatedAt: now,
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := apply(policy, req, now); err != nil {
+			return err
+		}
+		if err := tx.Create(policy).Error; err != nil {
+			return fmt.Errorf("failed to create separation-of-duties policy: %w", err)
+		}
+		return setRoles(tx, policy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.Event{
+		Type:    "sod.policy_created",
+		Subject: policy.ID.String(),
+		Data:    map[string]interface{}{"name": policy.Name, "maxRoles": policy.MaxRoles},
+	})
+	return policy, nil
+}
+
+// UpdatePolicy replaces the name, description, roles and limit of a policy
+func (s *PolicyService) UpdatePolicy(id uuid.UUID, req PolicyRequest) (*Policy, error) {
+	var policy Policy
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&policy, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrPolicyNotFound
+			}
+			return fmt.Errorf("failed to get separation-of-duties policy: %w", err)
+		}
+		if err := apply(&policy, req, time.Now()); err != nil {
+			return err
+		}
+		if err := tx.Save(&policy).Error; err != nil {
+			return fmt.Errorf("failed to update separation-of-duties policy: %w", err)
+		}
+		if err := tx.Where("policy_id = ?", id).Delete(&PolicyRole{}).Error; err != nil {
+			return fmt.Errorf("failed to update separation-of-duties policy roles: %w", err)
+		}
+		return setRoles(tx, &policy)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.Event{
+		Type:    "sod.policy_updated",
+		Subject: policy.ID.String(),
+		Data:    map[string]interface{}{"name": policy.Name, "maxRoles": policy.MaxRoles},
+	})
+	return &policy, nil
+}
+
+// DeletePolicy removes a policy and its exceptions
+func (s *PolicyService) DeletePolicy(id uuid.UUID) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", id).Delete(&Policy{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete separation-of-duties policy: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrPolicyNotFound
+		}
+		if err := tx.Where("policy_id = ?", id).Delete(&PolicyRole{}).Error; err != nil {
+			return fmt.Errorf("failed to delete separation-of-duties policy roles: %w", err)
+		}
+		if err := tx.Where("policy_id = ?", id).Delete(&Exception{}).Error; err != nil {
+			return fmt.Errorf("failed to delete separation-of-duties exceptions: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	events.Publish(events.Event{Type: "sod.policy_deleted", Subject: id.String()})
+	return nil
+}
+
+// GetExceptions returns the exceptions of a policy, expired ones included,
+// latest expiry first
+func (s *PolicyService) GetExceptions(policyID uuid.UUID) ([]Exception, error) {
+	if err := s.checkPolicy(s.db, policyID); err != nil {
+		return nil, err
+	}
+	var exceptions []Exception
+	if err := s.db.Where("policy_id = ?", policyID).Order("expires_at DESC").Find(&exceptions).Error; err != nil {
+		return nil, fmt.Errorf("failed to get separation-of-duties exceptions: %w", err)
+	}
+	return exceptions, nil
+}
+
+// GrantException lets the user break the policy until req.ExpiresAt
+func (s *PolicyService) GrantException(policyID, approvedBy uuid.UUID, req ExceptionRequest) (*Exception, error) {
+	now := time.Now()
+	if !req.ExpiresAt.After(now) {
+		return nil, ErrInvalidExpiry
+	}
+	exception := &Exception{
+		ID:         uuid.New(),
+		PolicyID:   policyID,
+		UserID:     req.UserID,
+		Reason:     req.Reason,
+		ApprovedBy: &approvedBy,
+		ExpiresAt:  req.ExpiresAt,
+		CreatedAt:  now,
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkPolicy(tx, policyID); err != nil {
+			return err
+		}
+		if err := checkExists(tx, "user", req.UserID, ErrUserNotFound); err != nil {
+			return err
+		}
+		if err := tx.Create(exception).Error; err != nil {
+			return fmt.Errorf("failed to create separation-of-duties exception: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.Event{
+		Type:    "sod.exception_granted",
+		Subject: policyID.String(),
+		Data: map[string]interface{}{
+			"exceptionId": exception.ID.String(),
+			"userId":      exception.UserID.String(),
+			"approvedBy":  approvedBy.String(),
+			"expiresAt":   exception.ExpiresAt.Format(time.RFC3339),
+		},
+	})
+	return exception, nil
+}
+
+// RevokeException ends an exception of the policy early
+func (s *PolicyService) RevokeException(policyID, exceptionID uuid.UUID) error {
+	result := s.db.Where("id = ? AND policy_id = ?", exceptionID, policyID).Delete(&Exception{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke separation-of-duties exception: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrExceptionNotFound
+	}
+
+	events.Publish(events.Event{
+		Type:    "sod.exception_revoked",
+		Subject: policyID.String(),
+		Data:    map[string]interface{}{"exceptionId": exceptionID.String()},
+	})
+	return nil
+}
+
+// Scan returns the users breaking policies now, those covered by an
+// exception included, optionally for a single policy
+func (s *PolicyService) Scan(policyID *uuid.UUID) ([]Violation, error) {
+	if policyID != nil {
+		if err := s.checkPolicy(s.db, *policyID); err != nil {
+			return nil, err
+		}
+	}
+	violations, err := FindViolations(s.db, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if policyID == nil {
+		return violations, nil
+	}
+	var filtered []Violation
+	for _, v := range violations {
+		if v.PolicyID == *policyID {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered, nil
+}
+
+// HandleRoleDeleted drops a deleted role from the policies
+func (s *PolicyService) HandleRoleDeleted(event events.Event) {
+	roleID, err := uuid.Parse(event.Subject)
+	if err != nil {
+		return
+	}
+	if err := s.db.Where("role_id = ?", roleID).Delete(&PolicyRole{}).Error; err != nil {
+		s.logger.Errorf("Failed to remove deleted role %s from separation-of-duties policies: %v", roleID, err)
+	}
+}
+
+func (s *PolicyService) checkPolicy(db *gorm.DB, id uuid.UUID) error {
+	var count int64
+	if err := db.Model(&Policy{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to get separation-of-duties policy: %w", err)
+	}
+	if count == 0 {
+		return ErrPolicyNotFound
+	}
+	return nil
+}
+
+// apply sets the fields of the policy from the request
+func apply(policy *Policy, req PolicyRequest, now time.Time) error {
+	maxRoles := req.MaxRoles
+	if maxRoles == 0 {
+		maxRoles = 1
+	}
+	seen := make(map[uuid.UUID]bool, len(req.RoleIDs))
+	for _, id := range req.RoleIDs {
+		if id == uuid.Nil || seen[id] {
+			return ErrInvalidPolicy
+		}
+		seen[id] = true
+	}
+	if maxRoles >= len(req.RoleIDs) {
+		return ErrInvalidPolicy
+	}
+	policy.Name = req.Name
+	policy.Description = req.Description
+	policy.MaxRoles = maxRoles
+	policy.RoleIDs = req.RoleIDs
+	policy.UpdatedAt = now
+	return nil
+}
+
+// setRoles writes the roles of the policy, which must exist in its tenant
+func setRoles(tx *gorm.DB, policy *Policy) error {
+	for _, roleID := range policy.RoleIDs {
+		if err := checkExists(tx, "role", roleID, ErrRoleNotFound); err != nil {
+			return err
+		}
+		if err := tx.Create(&PolicyRole{PolicyID: policy.ID, RoleID: roleID}).Error; err != nil {
+			return fmt.Errorf("failed to add separation-of-duties policy role: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkExists returns notFound unless the user or role exists in the tenant
+// of tx's context
+func checkExists(tx *gorm.DB, objectType string, id uuid.UUID, notFound error) error {
+	owner, err := tenant.Of(tx, objectType, id)
+	if err != nil {
+		return err
+	}
+	if owner == uuid.Nil {
+		return fmt.Errorf("%w: %s", notFound, id)
+	}
+	if err := tenant.CheckOwns(tx, objectType, id); err != nil {
+		if errors.Is(err, tenant.ErrNotOwned) {
+			return fmt.Errorf("%w: %s", notFound, id)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package tenant
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Source is how the tenant of a request was determined
+type Source string
+
+const (
+	// SourceDefault means the request named no tenant
+	SourceDefault Source = "default"
+	SourceHost    Source = "host"
+	SourcePath    Source = "path"
+	// SourceToken means the tenant is the one the access token was issued in
+	SourceToken Source = "token"
+)
+
+type contextKey struct{}
+
+type binding struct {
+	id       uuid.UUID
+	source   Source
+	basePath string
+	// bypass is set for contexts made with Bypass, which have no tenant
+	bypass bool
+}
+
+// NewContext returns a copy of ctx bound to the tenant. Queries run with
+// the context only see the tenant's rows.
+func NewContext(ctx context.Context, id uuid.UUID, source Source) context.Context {
+	return withBinding(ctx, binding{id: id, source: source, basePath: BasePath(ctx)})
+}
+
+func withBinding(ctx context.Context, b binding) context.Context {
+	return context.WithValue(ctx, contextKey{}, b)
+}
+
+func bindingFrom(ctx context.Context) (binding, bool) {
+	if ctx == nil {
+		return binding{}, false
+	}
+	// Gin only looks up custom keys in the request with ContextWithFallback
+	if c, ok := ctx.(*gin.Context); ok {
+		if c.Request == nil {
+			return binding{}, false
+		}
+		ctx = c.Request.Context()
+	}
+	b, ok := ctx.Value(contextKey{}).(binding)
+	return b, ok
+}
+
+// FromContext returns the tenant ctx is bound to. Contexts without a tenant
+// aren't restricted to one by the plugin, but see no rows at all under row
+// level security unless they are made with Bypass.
+func FromContext(ctx context.Context) (uuid.UUID, bool) {
+	b, ok := bindingFrom(ctx)
+	return b.id, ok && !b.bypass
+}
+
+// Bypass returns a copy of ctx bound to no tenant whose statements see the
+// rows of every tenant. It is meant for background jobs, event handlers and
+// the lookups that find the tenant of a request or resource, such as token
+// authentication.
+func Bypass(ctx context.Context) context.Context {
+	return withBinding(ctx, binding{bypass: true})
+}
+
+// Bypassed reports whether ctx was made with Bypass and isn't bound to a
+// tenant since
+func Bypassed(ctx context.Context) bool {
+	b, _ := bindingFrom(ctx)
+	return b.bypass
+}
+
+// SourceFromContext returns how the tenant of ctx was determined
+func SourceFromContext(ctx context.Context) Source {
+	b, _ := bindingFrom(ctx)
+	return b.source
+}
+
+// Explicit reports whether the request named its tenant by host or path
+func Explicit(ctx context.Context) bool {
+	source := SourceFromContext(ctx)
+	return source == SourceHost || source == SourcePath
+}
+
+// BasePath is the path prefix of the tenant's URLs, "/t/<slug>" when the
+// tenant was named in the path and "" otherwise
+func BasePath(ctx context.Context) string {
+	b, _ := bindingFrom(ctx)
+	return b.basePath
+}
@@ -0,0 +1,21 @@
+package tenant
+
+type TenantCreateRequest struct {
+	Slug         string `json:"slug" binding:"required,max=63"`
+	Name         string `json:"name" binding:"required"`
+	Host         string `json:"host"`
+	DisplayName  string `json:"displayName"`
+	LogoURL      string `json:"logoUrl"`
+	PrimaryColor string `json:"primaryColor"`
+}
+
+// TenantUpdateRequest changes the fields that are set. An empty host stops
+// serving the tenant at a host of its own.
+type TenantUpdateRequest struct {
+	Name         string  `json:"name"`
+	Host         *string `json:"host"`
+	DisplayName  *string `json:"displayName"`
+	LogoURL      *string `json:"logoUrl"`
+	PrimaryColor *string `json:"primaryColor"`
+	Active       *bool   `json:"active"`
+}
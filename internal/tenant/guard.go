@@ -0,0 +1,93 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// objectTables are the tables of the tenant resources authorization objects
+// and memberships refer to, by type
+var objectTables = map[string]string{
+	"user":  "users",
+	"group": "groups",
+	"role":  "roles",
+	"org":   "orgs",
+}
+
+// ErrNotOwned is returned when a request refers to a resource of another
+// tenant, which is reported like a missing one
+var ErrNotOwned = errors.New("resource not found")
+
+// Owns reports whether the resource of type objectType (e.g. "user" or
+// "USER") belongs to the tenant db's context is bound to. Without a tenant
+// every resource is owned, and types that aren't tenant resources, such as
+// the system object, are shared.
+func Owns(db *gorm.DB, objectType string, id uuid.UUID) (bool, error) {
+	tenantID, ok := FromContext(db.Statement.Context)
+	table, scoped := objectTables[strings.ToLower(objectType)]
+	if !ok || !scoped {
+		return true, nil
+	}
+	var count int64
+	if err := db.Table(table).Where("id = ? AND tenant_id = ?", id, tenantID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check tenant of %s: %w", objectType, err)
+	}
+	return count > 0, nil
+}
+
+// CheckOwns returns ErrNotOwned unless the resource belongs to the tenant of
+// db's context
+func CheckOwns(db *gorm.DB, objectType string, id uuid.UUID) error {
+	owned, err := Owns(db, objectType, id)
+	if err != nil {
+		return err
+	}
+	if !owned {
+		return fmt.Errorf("%s %s: %w", strings.ToLower(objectType), id, ErrNotOwned)
+	}
+	return nil
+}
+
+// Of returns the tenant the resource of type objectType belongs to, whatever
+// the tenant of db's context, or uuid.Nil if there is no such resource
+func Of(db *gorm.DB, objectType string, id uuid.UUID) (uuid.UUID, error) {
+	table, scoped := objectTables[strings.ToLower(objectType)]
+	if !scoped {
+		return uuid.Nil, nil
+	}
+	var ids []uuid.UUID
+	if err := db.WithContext(Bypass(db.Statement.Context)).Table(table).Where("id = ?", id).Limit(1).Pluck("tenant_id", &ids).Error; err != nil {
+		return uuid.Nil, fmt.Errorf("failed to get tenant of %s: %w", objectType, err)
+	}
+	if len(ids) == 0 {
+		return uuid.Nil, nil
+	}
+	return ids[0], nil
+}
+
+// Guard tells route guards whether the objects of requests belong to their
+// tenant
+type Guard struct {
+	db *gorm.DB
+}
+
+func NewGuard(db *gorm.DB) *Guard {
+	return &Guard{db: db}
+}
+
+// Owns implements middleware.TenantGuard
+func (g *Guard) Owns(ctx context.Context, objectType, id string) (bool, error) {
+	if _, scoped := objectTables[objectType]; !scoped {
+		return true, nil
+	}
+	objectID, err := uuid.Parse(id)
+	if err != nil {
+		return false, nil
+	}
+	return Owns(g.db.WithContext(ctx), objectType, objectID)
+}
@@ -0,0 +1,143 @@
+package tenant
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+type TenantController struct {
+	tenantService *TenantService
+	logger        *logrus.Logger
+}
+
+func NewTenantController(tenantService *TenantService) *TenantController {
+	return &TenantController{
+		tenantService: tenantService,
+		logger:        logrus.New(),
+	}
+}
+
+func (c *TenantController) GetAllTenants(ctx *gin.Context) {
+	tenants, err := c.tenantService.GetAllTenants()
+	if err != nil {
+		c.logger.Errorf("Failed to get tenants: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tenants"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tenants)
+}
+
+func (c *TenantController) GetTenant(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		return
+	}
+
+	tenant, err := c.tenantService.GetTenant(id)
+	if err != nil {
+		c.logger.Errorf("Failed to get tenant: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tenant"})
+		return
+	}
+	if tenant == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tenant)
+}
+
+func (c *TenantController) CreateTenant(ctx *gin.Context) {
+	var req TenantCreateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenant, err := c.tenantService.CreateTenant(req)
+	if err != nil {
+		c.respondError(ctx, err, "Failed to create tenant")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, tenant)
+}
+
+func (c *TenantController) UpdateTenant(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		return
+	}
+
+	var req TenantUpdateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tenant, err := c.tenantService.UpdateTenant(id, req)
+	if err != nil {
+		c.respondError(ctx, err, "Failed to update tenant")
+		return
+	}
+	if tenant == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tenant)
+}
+
+func (c *TenantController) DeleteTenant(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		return
+	}
+
+	if err := c.tenantService.DeleteTenant(id); err != nil {
+		c.respondError(ctx, err, "Failed to delete tenant")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// RotateSigningKey replaces the key of the tenant's access tokens, signing
+// out every token issued with the previous one
+func (c *TenantController) RotateSigningKey(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		return
+	}
+
+	if err := c.tenantService.RotateSigningKey(id); err != nil {
+		c.respondError(ctx, err, "Failed to rotate signing key")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// respondError maps service errors to responses, logging unexpected ones
+func (c *TenantController) respondError(ctx *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrInvalidSlug), errors.Is(err, ErrInvalidColor), errors.Is(err, ErrInvalidLogoURL):
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrSlugTaken), errors.Is(err, ErrHostTaken), errors.Is(err, ErrDefaultTenant), errors.Is(err, ErrTenantNotEmpty):
+		ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.logger.Errorf("%s: %v", message, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
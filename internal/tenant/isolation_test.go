@@ -0,0 +1,358 @@
+package tenant_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"idmapp-go/controllers"
+	"idmapp-go/dto"
+	"idmapp-go/internal/access"
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/client"
+	"idmapp-go/internal/group"
+	"idmapp-go/internal/member"
+	"idmapp-go/internal/org"
+	"idmapp-go/internal/role"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/internal/user"
+	"idmapp-go/middleware"
+	"idmapp-go/models"
+	"idmapp-go/repository"
+	"idmapp-go/services"
+
+	"github.com/gin-gonic/gin"
+	gosqlite "github.com/glebarez/go-sqlite"
+	"github.com/glebarez/sqlite"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var registerLockOnce sync.Once
+
+// fixture is what each tenant of the isolation suite owns
+type fixture struct {
+	tenantID                      uuid.UUID
+	user, group, nested, role, og uuid.UUID
+	client                        string
+}
+
+func newIsolationDB(t *testing.T) *gorm.DB {
+	// Deleting orgs takes the org tree lock
+	registerLockOnce.Do(func() {
+		gosqlite.MustRegisterScalarFunction("pg_advisory_xact_lock", 1, func(_ *gosqlite.FunctionContext, _ []driver.Value) (driver.Value, error) {
+			return nil, nil
+		})
+	})
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "isolation.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(&tenant.Plugin{}))
+	require.NoError(t, db.AutoMigrate(&authz.TupleChange{}))
+	const validity = "valid_from DATETIME, valid_until DATETIME, activated_at DATETIME, expiry_notified_at DATETIME"
+	for _, ddl := range []string{
+		"CREATE TABLE tenants (id TEXT PRIMARY KEY, slug TEXT NOT NULL UNIQUE, name TEXT NOT NULL, host TEXT UNIQUE, active BOOLEAN NOT NULL DEFAULT TRUE, displayname TEXT, logo_url TEXT, primary_color TEXT, signing_key BLOB NOT NULL, created_at DATETIME, updated_at DATETIME)",
+		"CREATE TABLE users (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, name TEXT NOT NULL, firstname TEXT, lastname TEXT, email TEXT NOT NULL, password TEXT NOT NULL, is_active BOOLEAN DEFAULT TRUE, status TEXT NOT NULL DEFAULT 'active', status_reason TEXT, status_changed_at DATETIME, created_at DATETIME, updated_at DATETIME, password_changed_at DATETIME, tokens_revoked_at DATETIME, external_id TEXT)",
+		"CREATE TABLE groups (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, name TEXT NOT NULL, displayname TEXT, description TEXT, external_id TEXT, created_at DATETIME, updated_at DATETIME)",
+		"CREATE TABLE roles (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, name TEXT NOT NULL, displayname TEXT, description TEXT, created_at DATETIME, updated_at DATETIME)",
+		"CREATE TABLE orgs (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, name TEXT NOT NULL, displayname TEXT, description TEXT, parent_id TEXT, path TEXT NOT NULL DEFAULT '', created_at DATETIME, updated_at DATETIME)",
+		"CREATE TABLE members (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, group_id TEXT NOT NULL, user_id TEXT NOT NULL, created_at DATETIME, updated_at DATETIME, " + validity + ")",
+		"CREATE TABLE nested_groups (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, group_id TEXT NOT NULL, member_group_id TEXT NOT NULL, created_at DATETIME)",
+		"CREATE TABLE role_members (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, role_id TEXT NOT NULL, entity_id TEXT NOT NULL, type TEXT NOT NULL, created_at DATETIME, " + validity + ")",
+		"CREATE TABLE org_members (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, org_id TEXT NOT NULL, entity_id TEXT NOT NULL, type TEXT NOT NULL, \"cascade\" BOOLEAN NOT NULL DEFAULT FALSE, created_at DATETIME)",
+		"CREATE TABLE clients (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, client_id TEXT NOT NULL UNIQUE, client_secret TEXT NOT NULL, name TEXT NOT NULL, redirect_uris TEXT, scopes TEXT NOT NULL, token_exchange_audiences TEXT, active BOOLEAN NOT NULL DEFAULT TRUE, created_at DATETIME, updated_at DATETIME)",
+	} {
+		require.NoError(t, db.Exec(ddl).Error)
+	}
+	return db
+}
+
+// seed creates a user, groups, a role, an org, a client and the memberships
+// between them in the tenant
+func seed(t *testing.T, db *gorm.DB, tenantID uuid.UUID, prefix string) fixture {
+	tx := db.WithContext(tenant.NewContext(context.Background(), tenantID, tenant.SourceDefault))
+	f := fixture{tenantID: tenantID, user: uuid.New(), group: uuid.New(), nested: uuid.New(), role: uuid.New(), og: uuid.New(), client: prefix + "-app"}
+	for _, row := range []interface{}{
+		&user.User{ID: f.user, Name: prefix + "-user", Email: prefix + "@example.com", Password: "-", Status: user.StatusActive, IsActive: true},
+		&group.Group{ID: f.group, Name: prefix + "-group"},
+		&group.Group{ID: f.nested, Name: prefix + "-nested"},
+		&role.Role{ID: f.role, Name: prefix + "-role"},
+		&org.Org{ID: f.og, Name: prefix + "-org", Path: "/" + f.og.String() + "/"},
+		&client.Client{ID: uuid.New(), ClientID: f.client, ClientSecret: "-", Name: prefix, RedirectURIs: pq.StringArray{"https://app.example.com/callback"}, Scopes: pq.StringArray{"openid"}, Active: true},
+		&member.Member{GroupID: f.group, UserID: f.user},
+		&member.NestedGroup{GroupID: f.group, MemberGroupID: f.nested},
+		&models.RoleMember{RoleID: f.role, EntityID: f.user, Type: "USER"},
+		&models.OrgMember{OrgID: f.og, EntityID: f.user, Type: "USER"},
+	} {
+		require.NoError(t, tx.Create(row).Error)
+	}
+	return f
+}
+
+// setupIsolation serves the default tenant and acme, at acme.example.com
+// and /t/acme, each with its own fixture
+func setupIsolation(t *testing.T) (*gorm.DB, http.Handler, fixture, fixture) {
+	gin.SetMode(gin.TestMode)
+	db := newIsolationDB(t)
+	tenantService := tenant.NewTenantService(db)
+	require.NoError(t, tenantService.EnsureDefault())
+	acmeTenant, err := tenantService.CreateTenant(tenant.TenantCreateRequest{Slug: "acme", Name: "Acme", Host: "acme.example.com"})
+	require.NoError(t, err)
+	tenant.SetSigningKeys(tenantService)
+	t.Cleanup(func() { tenant.SetSigningKeys(nil) })
+
+	home := seed(t, db, tenant.DefaultID, "home")
+	acme := seed(t, db, acmeTenant.ID, "acme")
+	handler := newIsolationRouter(db, tenantService)
+	return db, handler, home, acme
+}
+
+func newIsolationRouter(db *gorm.DB, tenantService *tenant.TenantService) http.Handler {
+	userService := user.NewUserService(db, nil, nil)
+	orgMemberService := services.NewOrgMemberService(repository.NewOrgMemberRepository(db))
+	roleMemberService := services.NewRoleMemberService(repository.NewRoleMemberRepository(db))
+	userController := user.NewUserController(userService, services.NewPKCEService(db), nil)
+	groupController := group.NewGroupController(group.NewGroupService(db))
+	roleController := role.NewRoleController(role.NewRoleService(db))
+	orgController := org.NewOrgController(org.NewOrgService(db))
+	memberController := member.NewMemberController(member.NewMemberService(db))
+	orgMemberController := controllers.NewOrgMemberController(orgMemberService)
+	roleMemberController := controllers.NewRoleMemberController(roleMemberService)
+	accessController := access.NewAccessController(access.NewResolver(db, time.Minute))
+
+	router := gin.New()
+	router.Use(tenant.Resolve(tenantService))
+	api := router.Group("/api/v1", middleware.AuthMiddleware(nil))
+	api.GET("/users", userController.GetAllUsers)
+	api.GET("/users/:id", userController.GetUser)
+	api.PUT("/users/:id", userController.UpdateUser)
+	api.DELETE("/users/:id", userController.DeleteUser)
+	api.GET("/users/:id/access", accessController.GetUserAccess)
+	api.GET("/groups", groupController.GetAllGroups)
+	api.GET("/groups/:id", groupController.GetGroup)
+	api.PUT("/groups/:id", groupController.UpdateGroup)
+	api.DELETE("/groups/:id", groupController.DeleteGroup)
+	api.GET("/roles", roleController.GetAllRoles)
+	api.GET("/roles/:id", roleController.GetRole)
+	api.PUT("/roles/:id", roleController.UpdateRole)
+	api.DELETE("/roles/:id", roleController.DeleteRole)
+	api.GET("/orgs", orgController.GetAllOrgs)
+	api.GET("/orgs/:id", orgController.GetOrg)
+	api.PUT("/orgs/:id", orgController.UpdateOrg)
+	api.DELETE("/orgs/:id", orgController.DeleteOrg)
+	api.GET("/members", memberController.GetAllMembers)
+	api.GET("/members/group/:groupId", memberController.GetMembersByGroupID)
+	api.GET("/members/user/:userId", memberController.GetMembersByUserID)
+	api.GET("/members/group/:groupId/groups", memberController.GetNestedGroups)
+	api.GET("/org-members", orgMemberController.GetAllMembers)
+	api.GET("/org-members/org/:orgId", orgMemberController.GetMembersByOrgID)
+	api.GET("/role-members", roleMemberController.GetAllMembers)
+	api.GET("/role-members/role/:roleId", roleMemberController.GetMembersByRoleID)
+	api.GET("/role-members/entity/:entityId", roleMemberController.GetMembersByEntityID)
+	return tenant.StripPathPrefix(router)
+}
+
+func tokenFor(t *testing.T, f fixture) string {
+	token, err := tenant.SignToken(jwt.MapClaims{
+		"sub": f.user.String(),
+		"aud": []string{dto.APIAudience},
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}, f.tenantID)
+	require.NoError(t, err)
+	return token
+}
+
+func TestTenantIsolation(t *testing.T) {
+	db, handler, home, acme := setupIsolation(t)
+
+	serve := func(method, host, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Host = host
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	// Requests of acme name it by host, by path or only by their token
+	requests := map[string]func(method, path, body string) *httptest.ResponseRecorder{
+		"host": func(method, path, body string) *httptest.ResponseRecorder {
+			return serve(method, "acme.example.com", path, tokenFor(t, acme), body)
+		},
+		"path": func(method, path, body string) *httptest.ResponseRecorder {
+			return serve(method, "localhost", "/t/acme"+path, tokenFor(t, acme), body)
+		},
+		"token": func(method, path, body string) *httptest.ResponseRecorder {
+			return serve(method, "localhost", path, tokenFor(t, acme), body)
+		},
+	}
+
+	for name, do := range requests {
+		t.Run(name, func(t *testing.T) {
+			// Lists only hold acme's rows
+			for path, own := range map[string]uuid.UUID{
+				"/api/v1/users":        acme.user,
+				"/api/v1/groups":       acme.group,
+				"/api/v1/roles":        acme.role,
+				"/api/v1/orgs":         acme.og,
+				"/api/v1/members":      acme.user,
+				"/api/v1/org-members":  acme.og,
+				"/api/v1/role-members": acme.role,
+				"/api/v1/members/user/" + acme.user.String():        acme.group,
+				"/api/v1/role-members/entity/" + acme.user.String(): acme.role,
+			} {
+				w := do(http.MethodGet, path, "")
+				require.Equal(t, http.StatusOK, w.Code, "%s: %s", path, w.Body.String())
+				assert.Contains(t, w.Body.String(), own.String(), path)
+				assertHidden(t, w, home, path)
+			}
+
+			// acme's own rows are found...
+			for _, path := range []string{
+				"/api/v1/users/" + acme.user.String(),
+				"/api/v1/groups/" + acme.group.String(),
+				"/api/v1/roles/" + acme.role.String(),
+				"/api/v1/orgs/" + acme.og.String(),
+			} {
+				w := do(http.MethodGet, path, "")
+				assert.Equal(t, http.StatusOK, w.Code, "%s: %s", path, w.Body.String())
+			}
+			w := do(http.MethodGet, "/api/v1/users/"+acme.user.String()+"/access", "")
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			var effective access.EffectiveAccessResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &effective))
+			assert.Len(t, effective.Groups, 1)
+			assert.Len(t, effective.Roles, 1)
+			assert.Len(t, effective.Orgs, 1)
+			assertHidden(t, w, home, "access")
+
+			// ...and the default tenant's are missing, whatever the method
+			for _, path := range []string{
+				"/api/v1/users/" + home.user.String(),
+				"/api/v1/users/" + home.user.String() + "/access",
+				"/api/v1/groups/" + home.group.String(),
+				"/api/v1/roles/" + home.role.String(),
+				"/api/v1/orgs/" + home.og.String(),
+			} {
+				w := do(http.MethodGet, path, "")
+				assert.Equal(t, http.StatusNotFound, w.Code, "%s: %s", path, w.Body.String())
+				assertHidden(t, w, home, path)
+			}
+			for _, path := range []string{
+				"/api/v1/members/group/" + home.group.String(),
+				"/api/v1/members/group/" + home.group.String() + "/groups",
+				"/api/v1/members/user/" + home.user.String(),
+				"/api/v1/org-members/org/" + home.og.String(),
+				"/api/v1/role-members/role/" + home.role.String(),
+				"/api/v1/role-members/entity/" + home.user.String(),
+			} {
+				w := do(http.MethodGet, path, "")
+				assert.Equal(t, http.StatusOK, w.Code, "%s: %s", path, w.Body.String())
+				assert.JSONEq(t, "[]", w.Body.String(), path)
+			}
+			for _, path := range []string{
+				"/api/v1/users/" + home.user.String(),
+				"/api/v1/groups/" + home.group.String(),
+				"/api/v1/roles/" + home.role.String(),
+				"/api/v1/orgs/" + home.og.String(),
+			} {
+				w := do(http.MethodPut, path, `{"name":"taken"}`)
+				assert.Equal(t, http.StatusNotFound, w.Code, "PUT %s: %s", path, w.Body.String())
+				assertHidden(t, w, home, path)
+				// Deletes fail as for rows that don't exist
+				w = do(http.MethodDelete, path, "")
+				assert.NotEqual(t, http.StatusNoContent, w.Code, "DELETE %s", path)
+				assert.Contains(t, w.Body.String(), "not found", "DELETE %s", path)
+			}
+		})
+	}
+
+	// The default tenant's rows are untouched
+	for table, id := range map[string]uuid.UUID{"users": home.user, "groups": home.group, "roles": home.role, "orgs": home.og} {
+		var names []string
+		require.NoError(t, db.Table(table).Where("id = ?", id).Pluck("name", &names).Error)
+		assert.Equal(t, []string{"home-" + strings.TrimSuffix(table, "s")}, names, table)
+	}
+
+	// Clients are only known to their tenant
+	pkce := services.NewPKCEService(db)
+	acmeCtx := tenant.NewContext(context.Background(), acme.tenantID, tenant.SourceHost)
+	authRequest := dto.PKCEAuthRequest{RedirectURI: "https://app.example.com/callback", CodeChallenge: "challenge", CodeChallengeMethod: "S256"}
+	authRequest.ClientID = acme.client
+	assert.NoError(t, pkce.WithContext(acmeCtx).ValidatePKCEFlow(authRequest))
+	authRequest.ClientID = home.client
+	assert.Error(t, pkce.WithContext(acmeCtx).ValidatePKCEFlow(authRequest))
+}
+
+func TestTenantIsolationRejectsForeignTokens(t *testing.T) {
+	_, handler, home, acme := setupIsolation(t)
+
+	serve := func(host, path, token string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name   string
+		host   string
+		path   string
+		token  string
+		status int
+	}{
+		{"own host", "acme.example.com", "/api/v1/users", tokenFor(t, acme), http.StatusOK},
+		{"own path", "localhost", "/t/acme/api/v1/users", tokenFor(t, acme), http.StatusOK},
+		{"default token on acme host", "acme.example.com", "/api/v1/users", tokenFor(t, home), http.StatusUnauthorized},
+		{"default token on acme path", "localhost", "/t/acme/api/v1/users", tokenFor(t, home), http.StatusUnauthorized},
+		{"acme token on default path", "localhost", "/t/default/api/v1/users", tokenFor(t, acme), http.StatusUnauthorized},
+		{"acme token on default path at acme host", "acme.example.com", "/t/default/api/v1/users", tokenFor(t, acme), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.status, serve(tt.host, tt.path, tt.token))
+		})
+	}
+
+	// A token naming acme but signed with the default tenant's key is
+	// refused everywhere
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": acme.user.String(),
+		"tid": acme.tenantID.String(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString(mustKey(t, tenant.DefaultID))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, serve("acme.example.com", "/api/v1/users", forged))
+	assert.Equal(t, http.StatusUnauthorized, serve("localhost", "/api/v1/users", forged))
+}
+
+func mustKey(t *testing.T, id uuid.UUID) []byte {
+	key, err := tenant.SigningKey(id)
+	require.NoError(t, err)
+	return key
+}
+
+// assertHidden fails if the response mentions a row of the other tenant
+func assertHidden(t *testing.T, w *httptest.ResponseRecorder, other fixture, context string) {
+	t.Helper()
+	body := w.Body.String()
+	for _, id := range []uuid.UUID{other.user, other.group, other.nested, other.role, other.og} {
+		assert.NotContains(t, body, `"`+id.String()+`"`, context)
+	}
+	assert.NotContains(t, body, other.client, context)
+}
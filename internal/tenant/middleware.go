@@ -0,0 +1,96 @@
+package tenant
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// pathPrefix starts the URLs that name their tenant by slug,
+// "/t/<slug>/..."
+const pathPrefix = "/t/"
+
+type slugKey struct{}
+
+// StripPathPrefix serves requests to "/t/<slug>/<path>" as requests to
+// "/<path>" of the tenant, which Resolve then binds them to
+func StripPathPrefix(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug, path, ok := splitPath(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		stripped := r.Clone(context.WithValue(r.Context(), slugKey{}, slug))
+		stripped.URL.Path = path
+		stripped.URL.RawPath = ""
+		next.ServeHTTP(w, stripped)
+	})
+}
+
+// splitPath splits "/t/<slug>/<path>" into the slug and "/<path>"
+func splitPath(path string) (string, string, bool) {
+	if !strings.HasPrefix(path, pathPrefix) {
+		return "", "", false
+	}
+	slug, rest, _ := strings.Cut(path[len(pathPrefix):], "/")
+	if !slugPattern.MatchString(slug) {
+		return "", "", false
+	}
+	return slug, "/" + rest, true
+}
+
+// Resolve binds each request to a tenant: the one named in the path, else
+// the one served at the request's host, else the default tenant. Requests
+// naming an unknown or inactive tenant get 404.
+func Resolve(service *TenantService) gin.HandlerFunc {
+	logger := logrus.New()
+	return func(c *gin.Context) {
+		var tenant *Tenant
+		var err error
+		b := binding{source: SourceDefault}
+		if slug, ok := c.Request.Context().Value(slugKey{}).(string); ok {
+			tenant, err = service.FindBySlug(slug)
+			b.source = SourcePath
+			b.basePath = pathPrefix + slug
+		} else {
+			tenant, err = service.FindByHost(c.Request.Host)
+			if tenant != nil {
+				b.source = SourceHost
+			} else if err == nil {
+				tenant, err = service.Find(DefaultID)
+			}
+		}
+		if err != nil {
+			logger.Errorf("Failed to resolve tenant: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve tenant"})
+			c.Abort()
+			return
+		}
+		if tenant == nil || !tenant.Active {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+			c.Abort()
+			return
+		}
+
+		b.id = tenant.ID
+		c.Request = c.Request.WithContext(withBinding(c.Request.Context(), b))
+		c.Next()
+	}
+}
+
+// RequireDefault restricts a route to requests of the default tenant, such
+// as the administration of tenants themselves
+func RequireDefault() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if id, ok := FromContext(c); ok && id != DefaultID {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,127 @@
+package tenant
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T) *TenantService {
+	service := NewTenantService(newTestDB(t))
+	require.NoError(t, service.EnsureDefault())
+	return service
+}
+
+func TestTenantService(t *testing.T) {
+	service := newTestService(t)
+
+	acme, err := service.CreateTenant(TenantCreateRequest{Slug: "acme", Name: "Acme", Host: "Acme.Example.com:8443", PrimaryColor: "#1a73e8"})
+	require.NoError(t, err)
+	require.NotNil(t, acme.Host)
+	assert.Equal(t, "acme.example.com", *acme.Host)
+
+	_, err = service.CreateTenant(TenantCreateRequest{Slug: "acme", Name: "Again"})
+	assert.ErrorIs(t, err, ErrSlugTaken)
+	_, err = service.CreateTenant(TenantCreateRequest{Slug: "other", Name: "Other", Host: "acme.example.com"})
+	assert.ErrorIs(t, err, ErrHostTaken)
+	_, err = service.CreateTenant(TenantCreateRequest{Slug: "-bad", Name: "Bad"})
+	assert.ErrorIs(t, err, ErrInvalidSlug)
+	_, err = service.CreateTenant(TenantCreateRequest{Slug: "bad", Name: "Bad", PrimaryColor: "blue"})
+	assert.ErrorIs(t, err, ErrInvalidColor)
+
+	found, err := service.FindByHost("ACME.example.com")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, acme.ID, found.ID)
+
+	// Tenants sign with keys of their own, which rotation replaces
+	key, err := service.SigningKey(acme.ID)
+	require.NoError(t, err)
+	defaultKey, err := service.SigningKey(DefaultID)
+	require.NoError(t, err)
+	assert.NotEqual(t, defaultKey, key)
+	require.NoError(t, service.RotateSigningKey(acme.ID))
+	rotated, err := service.SigningKey(acme.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, key, rotated)
+
+	// Inactive tenants can't verify tokens
+	inactive := false
+	_, err = service.UpdateTenant(acme.ID, TenantUpdateRequest{Active: &inactive})
+	require.NoError(t, err)
+	_, err = service.SigningKey(acme.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = service.UpdateTenant(DefaultID, TenantUpdateRequest{Active: &inactive})
+	assert.ErrorIs(t, err, ErrDefaultTenant)
+	assert.ErrorIs(t, service.DeleteTenant(DefaultID), ErrDefaultTenant)
+}
+
+func TestResolve(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	service := newTestService(t)
+	acme, err := service.CreateTenant(TenantCreateRequest{Slug: "acme", Name: "Acme", Host: "acme.example.com"})
+	require.NoError(t, err)
+	_, err = service.CreateTenant(TenantCreateRequest{Slug: "closed", Name: "Closed"})
+	require.NoError(t, err)
+	closed, err := service.FindBySlug("closed")
+	require.NoError(t, err)
+	inactive := false
+	_, err = service.UpdateTenant(closed.ID, TenantUpdateRequest{Active: &inactive})
+	require.NoError(t, err)
+
+	router := gin.New()
+	router.Use(Resolve(service))
+	router.GET("/whoami", func(c *gin.Context) {
+		id, _ := FromContext(c)
+		c.JSON(http.StatusOK, gin.H{"tenant": id.String(), "source": SourceFromContext(c), "basePath": BasePath(c)})
+	})
+	router.GET("/admin", RequireDefault(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	handler := StripPathPrefix(router)
+
+	serve := func(host, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		status   int
+		tenant   uuid.UUID
+		source   Source
+		basePath string
+	}{
+		{"default", "localhost:8080", "/whoami", http.StatusOK, DefaultID, SourceDefault, ""},
+		{"by host", "acme.example.com", "/whoami", http.StatusOK, acme.ID, SourceHost, ""},
+		{"by path", "localhost", "/t/acme/whoami", http.StatusOK, acme.ID, SourcePath, "/t/acme"},
+		{"path wins over host", "acme.example.com", "/t/default/whoami", http.StatusOK, DefaultID, SourcePath, "/t/default"},
+		{"unknown slug", "localhost", "/t/nobody/whoami", http.StatusNotFound, uuid.Nil, "", ""},
+		{"inactive tenant", "localhost", "/t/closed/whoami", http.StatusNotFound, uuid.Nil, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.host, tt.path)
+			require.Equal(t, tt.status, w.Code, w.Body.String())
+			if tt.status != http.StatusOK {
+				return
+			}
+			assert.Contains(t, w.Body.String(), `"tenant":"`+tt.tenant.String()+`"`)
+			assert.Contains(t, w.Body.String(), `"source":"`+string(tt.source)+`"`)
+			assert.Contains(t, w.Body.String(), `"basePath":"`+tt.basePath+`"`)
+		})
+	}
+
+	assert.Equal(t, http.StatusOK, serve("localhost", "/admin").Code)
+	assert.Equal(t, http.StatusNotFound, serve("acme.example.com", "/admin").Code)
+	assert.Equal(t, http.StatusNotFound, serve("localhost", "/t/acme/admin").Code)
+}
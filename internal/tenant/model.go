@@ -0,0 +1,61 @@
+package tenant
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DefaultID is the tenant that data created before tenants existed belongs
+// to. It serves requests that don't name a tenant.
+var DefaultID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// DefaultSlug is the slug of the default tenant
+const DefaultSlug = "default"
+
+// Tenant is an isolated directory: its users, groups, roles, orgs and
+// clients are invisible to every other tenant, and its access tokens are
+// signed with a key of its own
+type Tenant struct {
+	ID   uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Slug string    `json:"slug" gorm:"type:varchar(63);not null;uniqueIndex"`
+	Name string    `json:"name" gorm:"not null"`
+	// Host is the host name the tenant is served at, e.g.
+	// "acme.idm.example.com"
+	Host   *string `json:"host,omitempty" gorm:"uniqueIndex"`
+	Active bool    `json:"active" gorm:"not null;default:true"`
+	// DisplayName, LogoURL and PrimaryColor brand the login page
+	DisplayName  string `json:"displayName" gorm:"column:displayname"`
+	LogoURL      string `json:"logoUrl" gorm:"column:logo_url"`
+	PrimaryColor string `json:"primaryColor" gorm:"type:varchar(16);column:primary_color"`
+	// SigningKey is the HS256 key of the tenant's access tokens
+	SigningKey []byte    `json:"-" gorm:"not null"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+func (t *Tenant) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+func (t *Tenant) TableName() string {
+	return "tenants"
+}
+
+// Title is the name the login page shows for the tenant
+func (t *Tenant) Title() string {
+	if t.DisplayName != "" {
+		return t.DisplayName
+	}
+	return t.Name
+}
+
+// Scoped is embedded by the models of tenant resources. Rows created
+// without a tenant belong to the default tenant.
+type Scoped struct {
+	TenantID uuid.UUID `json:"tenantId" gorm:"type:uuid;not null;default:'00000000-0000-0000-0000-000000000001';index"`
+}
@@ -0,0 +1,231 @@
+package tenant
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	// ErrCrossTenant is returned when a row of one tenant is created in the
+	// context of another
+	ErrCrossTenant = errors.New("row belongs to another tenant")
+	// ErrUnboundRows is returned for rows read outside a transaction under
+	// row level security, which can't be bound to a tenant; Find reads them
+	// in one
+	ErrUnboundRows = errors.New("rows read outside a transaction can't be bound to a tenant")
+)
+
+// startedKey marks statements the plugin opened a transaction for
+const startedKey = "tenant:started_transaction"
+
+// Plugin scopes statements to the tenant of their context: models with a
+// TenantID field only see and change rows of that tenant, and the rows they
+// create belong to it. Statements without a tenant in their context aren't
+// scoped.
+//
+// Raw SQL and queries of tables without a model aren't scoped by the
+// plugin. With RowLevelSecurity set, the policies of EnableRowLevelSecurity
+// catch those too: statements run in transactions bound to their tenant, or
+// bypassing the policies for contexts made with Bypass, and others see no
+// rows. Statements outside transactions get one of their own, except for
+// rows read with Row, Rows or Scan, which fail with ErrUnboundRows.
+type Plugin struct {
+	RowLevelSecurity bool
+}
+
+func (p *Plugin) Name() string {
+	return "tenant"
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	if err := callbacks.Create().Before("gorm:create").Register("tenant:create", p.create); err != nil {
+		return err
+	}
+	if err := callbacks.Create().After("gorm:after_create").Register("tenant:commit", p.commit); err != nil {
+		return err
+	}
+	if err := callbacks.Query().Before("gorm:query").Register("tenant:query", p.scope); err != nil {
+		return err
+	}
+	if err := callbacks.Query().After("gorm:after_query").Register("tenant:commit", p.commit); err != nil {
+		return err
+	}
+	if err := callbacks.Update().Before("gorm:update").Register("tenant:update", p.scopeChange); err != nil {
+		return err
+	}
+	if err := callbacks.Update().After("gorm:after_update").Register("tenant:commit", p.commit); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().Before("gorm:delete").Register("tenant:delete", p.scopeChange); err != nil {
+		return err
+	}
+	if err := callbacks.Delete().After("gorm:after_delete").Register("tenant:commit", p.commit); err != nil {
+		return err
+	}
+	if err := callbacks.Row().Before("gorm:row").Register("tenant:row", p.scope); err != nil {
+		return err
+	}
+	if err := callbacks.Raw().Before("gorm:raw").Register("tenant:raw", p.bind); err != nil {
+		return err
+	}
+	return callbacks.Raw().After("gorm:raw").Register("tenant:commit", p.commit)
+}
+
+// create assigns new rows to the tenant, rejecting rows of other tenants
+func (p *Plugin) create(db *gorm.DB) {
+	p.bind(db)
+	id, ok := FromContext(db.Statement.Context)
+	if !ok || db.Error != nil {
+		return
+	}
+	field := tenantField(db.Statement)
+	if field == nil {
+		return
+	}
+
+	value := reflect.Indirect(db.Statement.ReflectValue)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			stamp(db, field, value.Index(i), id)
+		}
+	case reflect.Struct:
+		stamp(db, field, value, id)
+	}
+}
+
+func stamp(db *gorm.DB, field *schema.Field, row reflect.Value, id uuid.UUID) {
+	ctx := db.Statement.Context
+	current, zero := field.ValueOf(ctx, row)
+	if zero {
+		if err := field.Set(ctx, row, id); err != nil {
+			db.AddError(fmt.Errorf("failed to set tenant: %w", err))
+		}
+		return
+	}
+	if owner, ok := current.(uuid.UUID); !ok || owner != id {
+		db.AddError(ErrCrossTenant)
+	}
+}
+
+// scope restricts a query to the rows of the tenant
+func (p *Plugin) scope(db *gorm.DB) {
+	p.restrict(db, false)
+}
+
+// scopeChange restricts an update or delete to the rows of the tenant
+func (p *Plugin) scopeChange(db *gorm.DB) {
+	p.restrict(db, true)
+}
+
+func (p *Plugin) restrict(db *gorm.DB, change bool) {
+	p.bind(db)
+	id, ok := FromContext(db.Statement.Context)
+	if !ok || db.Error != nil {
+		return
+	}
+	field := tenantField(db.Statement)
+	if field == nil {
+		return
+	}
+	// Gorm refuses updates and deletes without conditions; the tenant
+	// condition mustn't lift that
+	if change && !db.AllowGlobalUpdate && !hasConditions(db.Statement) {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: id},
+	}})
+}
+
+// bind sets the tenant the row level security policies admit for the rest
+// of the statement's transaction, or lets it bypass them. Statements outside
+// transactions are given one, which commit ends.
+func (p *Plugin) bind(db *gorm.DB) {
+	if !p.RowLevelSecurity || db.Error != nil {
+		return
+	}
+	ctx := db.Statement.Context
+	id, ok := FromContext(ctx)
+	if _, inTx := db.Statement.ConnPool.(gorm.TxCommitter); !inTx {
+		// Without a tenant the statement sees no rows anyway
+		if !ok && !Bypassed(ctx) {
+			return
+		}
+		if _, rows := db.Get("rows"); rows {
+			db.AddError(ErrUnboundRows)
+			return
+		}
+		tx := db.Begin()
+		if tx.Error != nil {
+			db.AddError(fmt.Errorf("failed to begin tenant transaction: %w", tx.Error))
+			return
+		}
+		db.Statement.ConnPool = tx.Statement.ConnPool
+		db.InstanceSet(startedKey, true)
+	}
+
+	// Statements of a transaction may run with different contexts, so each
+	// replaces the binding of the one before
+	tenantID, bypass := "", "off"
+	if ok {
+		tenantID = id.String()
+	} else if Bypassed(ctx) {
+		bypass = "on"
+	}
+	_, err := db.Statement.ConnPool.ExecContext(ctx, "SELECT set_config('app.tenant_id', $1, true), set_config('app.tenant_bypass', $2, true)", tenantID, bypass)
+	if err != nil {
+		db.AddError(fmt.Errorf("failed to bind transaction to tenant: %w", err))
+	}
+}
+
+// commit ends the transaction bind opened for the statement
+func (p *Plugin) commit(db *gorm.DB) {
+	if _, ok := db.InstanceGet(startedKey); !ok {
+		return
+	}
+	if db.Error != nil {
+		db.Rollback()
+	} else {
+		db.Commit()
+	}
+	db.Statement.ConnPool = db.ConnPool
+}
+
+// tenantField returns the TenantID field of the statement's model, if any
+func tenantField(stmt *gorm.Statement) *schema.Field {
+	if stmt.Schema == nil {
+		return nil
+	}
+	return stmt.Schema.LookUpField("TenantID")
+}
+
+// hasConditions reports whether gorm will restrict the statement to some
+// rows, either by its WHERE clause or by the primary key of its model
+func hasConditions(stmt *gorm.Statement) bool {
+	if stmt.SQL.Len() > 0 {
+		return true
+	}
+	if _, ok := stmt.Clauses["WHERE"]; ok {
+		return true
+	}
+	if stmt.Schema.PrioritizedPrimaryField == nil {
+		return false
+	}
+	value := reflect.Indirect(stmt.ReflectValue)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		return value.Len() > 0
+	case reflect.Struct:
+		_, zero := stmt.Schema.PrioritizedPrimaryField.ValueOf(stmt.Context, value)
+		return !zero
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package tenant
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	gosqlite "github.com/glebarez/go-sqlite"
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// widget is a tenant resource
+type widget struct {
+	Scoped
+	ID   uuid.UUID `gorm:"type:uuid;primary_key"`
+	Name string
+}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "tenant.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(&Plugin{}))
+	require.NoError(t, db.AutoMigrate(&widget{}))
+	for _, stmt := range []string{
+		"CREATE TABLE tenants (id TEXT PRIMARY KEY, slug TEXT NOT NULL UNIQUE, name TEXT NOT NULL, host TEXT UNIQUE, active BOOLEAN NOT NULL DEFAULT TRUE, displayname TEXT, logo_url TEXT, primary_color TEXT, signing_key BLOB NOT NULL, created_at DATETIME, updated_at DATETIME)",
+		"CREATE TABLE users (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL)",
+		"CREATE TABLE groups (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL)",
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+	return db
+}
+
+func tenantContext(id uuid.UUID) context.Context {
+	return NewContext(context.Background(), id, SourceToken)
+}
+
+func TestPluginScopesStatements(t *testing.T) {
+	db := newTestDB(t)
+	tenantA, tenantB := uuid.New(), uuid.New()
+	a := db.WithContext(tenantContext(tenantA))
+	b := db.WithContext(tenantContext(tenantB))
+
+	// Rows are stamped with the tenant they are created in
+	widgetA := widget{ID: uuid.New(), Name: "shared"}
+	require.NoError(t, a.Create(&widgetA).Error)
+	assert.Equal(t, tenantA, widgetA.TenantID)
+	widgetsB := []widget{{ID: uuid.New(), Name: "shared"}, {ID: uuid.New(), Name: "other"}}
+	require.NoError(t, b.Create(&widgetsB).Error)
+	assert.Equal(t, tenantB, widgetsB[1].TenantID)
+
+	// ...and can't be created for another tenant
+	foreign := widget{Scoped: Scoped{TenantID: tenantA}, ID: uuid.New()}
+	assert.ErrorIs(t, b.Create(&foreign).Error, ErrCrossTenant)
+
+	var found []widget
+	require.NoError(t, b.Find(&found).Error)
+	assert.Len(t, found, 2)
+	require.NoError(t, b.Where("name = ?", "shared").Find(&found).Error)
+	require.Len(t, found, 1)
+	assert.Equal(t, widgetsB[0].ID, found[0].ID)
+
+	var count int64
+	require.NoError(t, a.Model(&widget{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+
+	var first widget
+	assert.ErrorIs(t, b.First(&first, "id = ?", widgetA.ID).Error, gorm.ErrRecordNotFound)
+
+	// Other tenants' rows are neither updated nor deleted
+	result := b.Model(&widget{}).Where("id = ?", widgetA.ID).Update("name", "taken")
+	require.NoError(t, result.Error)
+	assert.Zero(t, result.RowsAffected)
+	result = b.Delete(&widget{ID: widgetA.ID})
+	require.NoError(t, result.Error)
+	assert.Zero(t, result.RowsAffected)
+	require.NoError(t, a.First(&first, "id = ?", widgetA.ID).Error)
+	assert.Equal(t, "shared", first.Name)
+
+	// Updates without conditions are still refused
+	assert.ErrorIs(t, b.Model(&widget{}).Update("name", "all").Error, gorm.ErrMissingWhereClause)
+
+	// Statements without a tenant see every row
+	require.NoError(t, db.Model(&widget{}).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+}
+
+// settings records the values set_config was called with, standing in for
+// the Postgres settings the row level security policies read
+type settings struct {
+	mu     sync.Mutex
+	values []string
+}
+
+var (
+	recorded     = &settings{}
+	registerOnce sync.Once
+)
+
+func (s *settings) take() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	values := s.values
+	s.values = nil
+	return values
+}
+
+func TestPluginBindsRowLevelSecurity(t *testing.T) {
+	registerOnce.Do(func() {
+		gosqlite.MustRegisterScalarFunction("set_config", 3, func(_ *gosqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+			recorded.mu.Lock()
+			defer recorded.mu.Unlock()
+			recorded.values = append(recorded.values, fmt.Sprintf("%v=%v", args[0], args[1]))
+			return args[1], nil
+		})
+	})
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "rls.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(&Plugin{RowLevelSecurity: true}))
+	require.NoError(t, db.AutoMigrate(&widget{}))
+	recorded.take()
+
+	tenantA := uuid.New()
+	a := db.WithContext(tenantContext(tenantA))
+	jobs := db.WithContext(Bypass(context.Background()))
+
+	// Statements outside transactions get one bound to their tenant...
+	require.NoError(t, a.Create(&widget{ID: uuid.New(), Name: "a"}).Error)
+	var found []widget
+	require.NoError(t, a.Find(&found).Error)
+	require.Len(t, found, 1)
+	require.NoError(t, a.Exec("UPDATE widgets SET name = ?", "renamed").Error)
+	assert.Equal(t, []string{
+		"app.tenant_id=" + tenantA.String(), "app.tenant_bypass=off",
+		"app.tenant_id=" + tenantA.String(), "app.tenant_bypass=off",
+		"app.tenant_id=" + tenantA.String(), "app.tenant_bypass=off",
+	}, recorded.take())
+
+	// ...or bypassing the policies, and others aren't bound at all
+	var count int64
+	require.NoError(t, jobs.Model(&widget{}).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+	assert.Equal(t, []string{"app.tenant_id=", "app.tenant_bypass=on"}, recorded.take())
+	require.NoError(t, db.Model(&widget{}).Count(&count).Error)
+	assert.Empty(t, recorded.take())
+
+	// Rows can only be bound inside a transaction
+	var names []string
+	assert.ErrorIs(t, a.Raw("SELECT name FROM widgets").Scan(&names).Error, ErrUnboundRows)
+	require.NoError(t, a.Transaction(func(tx *gorm.DB) error {
+		return tx.Raw("SELECT name FROM widgets").Scan(&names).Error
+	}))
+	assert.Equal(t, []string{"renamed"}, names)
+	require.NoError(t, a.Raw("SELECT name FROM widgets").Find(&names).Error)
+	assert.Equal(t, []string{"renamed"}, names)
+	recorded.take()
+
+	// Each statement of a transaction replaces the binding of the one before,
+	// and bypassing a tenant's context unbinds it
+	require.NoError(t, a.Transaction(func(tx *gorm.DB) error {
+		if err := tx.WithContext(Bypass(tenantContext(tenantA))).Model(&widget{}).Count(&count).Error; err != nil {
+			return err
+		}
+		return tx.WithContext(context.Background()).Model(&widget{}).Count(&count).Error
+	}))
+	assert.Equal(t, []string{
+		"app.tenant_id=", "app.tenant_bypass=on",
+		"app.tenant_id=", "app.tenant_bypass=off",
+	}, recorded.take())
+}
+
+func TestOwns(t *testing.T) {
+	db := newTestDB(t)
+	tenantA, tenantB := uuid.New(), uuid.New()
+	userA := uuid.New()
+	require.NoError(t, db.Exec("INSERT INTO users (id, tenant_id) VALUES (?, ?)", userA, tenantA).Error)
+
+	owned, err := Owns(db.WithContext(tenantContext(tenantA)), "USER", userA)
+	require.NoError(t, err)
+	assert.True(t, owned)
+	owned, err = Owns(db.WithContext(tenantContext(tenantB)), "user", userA)
+	require.NoError(t, err)
+	assert.False(t, owned)
+	assert.ErrorIs(t, CheckOwns(db.WithContext(tenantContext(tenantB)), "user", userA), ErrNotOwned)
+
+	// Shared objects and statements without a tenant are never refused
+	owned, err = Owns(db.WithContext(tenantContext(tenantB)), "system", uuid.New())
+	require.NoError(t, err)
+	assert.True(t, owned)
+	owned, err = Owns(db, "user", userA)
+	require.NoError(t, err)
+	assert.True(t, owned)
+
+	owner, err := Of(db.WithContext(tenantContext(tenantB)), "user", userA)
+	require.NoError(t, err)
+	assert.Equal(t, tenantA, owner)
+	owner, err = Of(db, "group", uuid.New())
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Nil, owner)
+
+	guard := NewGuard(db)
+	owned, err = guard.Owns(tenantContext(tenantB), "user", userA.String())
+	require.NoError(t, err)
+	assert.False(t, owned)
+	owned, err = guard.Owns(tenantContext(tenantB), "user", "not-a-uuid")
+	require.NoError(t, err)
+	assert.False(t, owned)
+}
+
+func TestPolicySQL(t *testing.T) {
+	stmts := policySQL("users")
+	require.NotEmpty(t, stmts)
+	assert.Contains(t, stmts[0], "ALTER TABLE users ENABLE ROW LEVEL SECURITY")
+	last := stmts[len(stmts)-1]
+	assert.Contains(t, last, "CREATE POLICY tenant_isolation ON users")
+	assert.Contains(t, last, "WITH CHECK")
+}
@@ -0,0 +1,58 @@
+package tenant
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// policyName is the row level security policy isolating tenants
+const policyName = "tenant_isolation"
+
+// policyCondition admits the rows of the tenant a transaction is bound to,
+// and every row to transactions bypassing the policy. Other transactions see
+// no rows.
+const policyCondition = `tenant_id = NULLIF(current_setting('app.tenant_id', true), '')::uuid OR current_setting('app.tenant_bypass', true) = 'on'`
+
+// EnableRowLevelSecurity adds the tenant isolation policy to the tables. It
+// applies to the table owner too, but not to superusers.
+func EnableRowLevelSecurity(db *gorm.DB, tables ...string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range tables {
+			for _, statement := range policySQL(table) {
+				if err := tx.Exec(statement).Error; err != nil {
+					return fmt.Errorf("failed to enable row level security on %s: %w", table, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// DisableRowLevelSecurity removes the tenant isolation policy from the
+// tables
+func DisableRowLevelSecurity(db *gorm.DB, tables ...string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range tables {
+			for _, statement := range []string{
+				fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s", policyName, table),
+				fmt.Sprintf("ALTER TABLE %s NO FORCE ROW LEVEL SECURITY", table),
+				fmt.Sprintf("ALTER TABLE %s DISABLE ROW LEVEL SECURITY", table),
+			} {
+				if err := tx.Exec(statement).Error; err != nil {
+					return fmt.Errorf("failed to disable row level security on %s: %w", table, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func policySQL(table string) []string {
+	return []string{
+		fmt.Sprintf("ALTER TABLE %s ENABLE ROW LEVEL SECURITY", table),
+		fmt.Sprintf("ALTER TABLE %s FORCE ROW LEVEL SECURITY", table),
+		fmt.Sprintf("DROP POLICY IF EXISTS %s ON %s", policyName, table),
+		fmt.Sprintf("CREATE POLICY %s ON %s USING (%s) WITH CHECK (%s)", policyName, table, policyCondition, policyCondition),
+	}
+}
@@ -0,0 +1,406 @@
+package tenant
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotFound       = errors.New("tenant not found")
+	ErrInvalidSlug    = errors.New("tenant slugs may only hold lowercase letters, digits and '-'")
+	ErrSlugTaken      = errors.New("a tenant with this slug already exists")
+	ErrHostTaken      = errors.New("another tenant is served at this host")
+	ErrInvalidColor   = errors.New("primary color must be a hex color such as #1a73e8")
+	ErrInvalidLogoURL = errors.New("logo URL must be an http or https URL")
+	ErrDefaultTenant  = errors.New("the default tenant can't be deactivated or deleted")
+	ErrTenantNotEmpty = errors.New("tenant still has users, groups, roles, orgs or clients")
+)
+
+var (
+	slugPattern  = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	colorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+)
+
+// ScopedTables are the tables of tenant resources, which the row level
+// security policy applies to
+var ScopedTables = []string{
+	"users", "groups", "roles", "orgs", "members", "nested_groups", "org_members", "role_members", "clients",
+	"notifications", "access_requests", "access_request_stages", "approval_stages", "access_request_audit",
+	"certification_campaigns", "certification_scopes", "certification_reviewers", "certification_items",
+	"sod_policies", "sod_policy_roles", "sod_exceptions",
+}
+
+// ownedTables are the tables a tenant must have emptied before it is
+// deleted; the membership tables only link their rows
+var ownedTables = []string{"users", "groups", "roles", "orgs", "clients"}
+
+// signingKeySize is the size of generated HS256 keys
+const signingKeySize = 32
+
+const (
+	// cacheTTL bounds how long tenant changes made by other instances take
+	// to be seen
+	cacheTTL = 30 * time.Second
+	// missReloadAfter keeps lookups of unknown tenants from reloading the
+	// cache more often than this
+	missReloadAfter = time.Second
+)
+
+type TenantService struct {
+	db     *gorm.DB
+	logger *logrus.Logger
+
+	mu    sync.Mutex
+	cache *tenantCache
+}
+
+// tenantCache indexes every tenant; resolving requests and verifying tokens
+// look tenants up on each request
+type tenantCache struct {
+	byID     map[uuid.UUID]*Tenant
+	bySlug   map[string]*Tenant
+	byHost   map[string]*Tenant
+	loadedAt time.Time
+}
+
+func NewTenantService(db *gorm.DB) *TenantService {
+	return &TenantService{
+		db:     db,
+		logger: logrus.New(),
+	}
+}
+
+// EnsureDefault creates the default tenant if it is missing
+func (s *TenantService) EnsureDefault() error {
+	key, err := newSigningKey()
+	if err != nil {
+		return err
+	}
+	defaultTenant := Tenant{
+		ID:         DefaultID,
+		Slug:       DefaultSlug,
+		Name:       "Default",
+		Active:     true,
+		SigningKey: key,
+	}
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&defaultTenant)
+	if result.Error != nil {
+		return fmt.Errorf("failed to create default tenant: %w", result.Error)
+	}
+	if result.RowsAffected > 0 {
+		s.logger.Info("Created the default tenant")
+	}
+	s.invalidate()
+	return nil
+}
+
+func (s *TenantService) GetAllTenants() ([]Tenant, error) {
+	var tenants []Tenant
+	if err := s.db.Order("slug").Find(&tenants).Error; err != nil {
+		return nil, fmt.Errorf("failed to get tenants: %w", err)
+	}
+	return tenants, nil
+}
+
+func (s *TenantService) GetTenant(id uuid.UUID) (*Tenant, error) {
+	var tenant Tenant
+	if err := s.db.First(&tenant, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get tenant: %w", err)
+	}
+	return &tenant, nil
+}
+
+func (s *TenantService) CreateTenant(req TenantCreateRequest) (*Tenant, error) {
+	slug := strings.ToLower(strings.TrimSpace(req.Slug))
+	if !slugPattern.MatchString(slug) {
+		return nil, ErrInvalidSlug
+	}
+	if err := validateBranding(req.LogoURL, req.PrimaryColor); err != nil {
+		return nil, err
+	}
+	key, err := newSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	tenant := Tenant{
+		ID:           uuid.New(),
+		Slug:         slug,
+		Name:         req.Name,
+		Host:         normalizeHost(req.Host),
+		Active:       true,
+		DisplayName:  req.DisplayName,
+		LogoURL:      req.LogoURL,
+		PrimaryColor: req.PrimaryColor,
+		SigningKey:   key,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkUnique(tx, uuid.Nil, "slug", slug, ErrSlugTaken); err != nil {
+			return err
+		}
+		if tenant.Host != nil {
+			if err := checkUnique(tx, uuid.Nil, "host", *tenant.Host, ErrHostTaken); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&tenant).Error; err != nil {
+			return fmt.Errorf("failed to create tenant: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.invalidate()
+	s.logger.Infof("Created tenant %s (%s)", tenant.Slug, tenant.ID)
+	return &tenant, nil
+}
+
+// UpdateTenant changes the tenant and returns it, or nil if it doesn't exist
+func (s *TenantService) UpdateTenant(id uuid.UUID, req TenantUpdateRequest) (*Tenant, error) {
+	if id == DefaultID && req.Active != nil && !*req.Active {
+		return nil, ErrDefaultTenant
+	}
+	var tenant Tenant
+	found := true
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&tenant, "id = ?", id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				found = false
+				return nil
+			}
+			return fmt.Errorf("failed to get tenant: %w", err)
+		}
+
+		if req.Name != "" {
+			tenant.Name = req.Name
+		}
+		if req.Host != nil {
+			tenant.Host = normalizeHost(*req.Host)
+			if tenant.Host != nil {
+				if err := checkUnique(tx, id, "host", *tenant.Host, ErrHostTaken); err != nil {
+					return err
+				}
+			}
+		}
+		if req.DisplayName != nil {
+			tenant.DisplayName = *req.DisplayName
+		}
+		if req.LogoURL != nil {
+			tenant.LogoURL = *req.LogoURL
+		}
+		if req.PrimaryColor != nil {
+			tenant.PrimaryColor = *req.PrimaryColor
+		}
+		if req.Active != nil {
+			tenant.Active = *req.Active
+		}
+		if err := validateBranding(tenant.LogoURL, tenant.PrimaryColor); err != nil {
+			return err
+		}
+		if err := tx.Save(&tenant).Error; err != nil {
+			return fmt.Errorf("failed to update tenant: %w", err)
+		}
+		return nil
+	})
+	if err != nil || !found {
+		return nil, err
+	}
+	s.invalidate()
+	return &tenant, nil
+}
+
+// DeleteTenant deletes an empty tenant. It returns ErrNotFound if the tenant
+// doesn't exist.
+func (s *TenantService) DeleteTenant(id uuid.UUID) error {
+	if id == DefaultID {
+		return ErrDefaultTenant
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range ownedTables {
+			var count int64
+			if err := tx.Table(table).Where("tenant_id = ?", id).Count(&count).Error; err != nil {
+				return fmt.Errorf("failed to count rows of tenant in %s: %w", table, err)
+			}
+			if count > 0 {
+				return ErrTenantNotEmpty
+			}
+		}
+		result := tx.Delete(&Tenant{}, "id = ?", id)
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete tenant: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate()
+	s.logger.Infof("Deleted tenant %s", id)
+	return nil
+}
+
+// RotateSigningKey gives the tenant a new signing key. Access tokens signed
+// with the previous key stop working.
+func (s *TenantService) RotateSigningKey(id uuid.UUID) error {
+	key, err := newSigningKey()
+	if err != nil {
+		return err
+	}
+	result := s.db.Model(&Tenant{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"signing_key": key,
+		"updated_at":  time.Now(),
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to rotate signing key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	s.invalidate()
+	s.logger.Infof("Rotated the signing key of tenant %s", id)
+	return nil
+}
+
+// FindBySlug returns the tenant with the slug, or nil
+func (s *TenantService) FindBySlug(slug string) (*Tenant, error) {
+	return s.lookup(func(c *tenantCache) *Tenant { return c.bySlug[slug] })
+}
+
+// FindByHost returns the tenant served at the host, or nil
+func (s *TenantService) FindByHost(host string) (*Tenant, error) {
+	normalized := normalizeHost(host)
+	if normalized == nil {
+		return nil, nil
+	}
+	return s.lookup(func(c *tenantCache) *Tenant { return c.byHost[*normalized] })
+}
+
+// Find returns the tenant, or nil
+func (s *TenantService) Find(id uuid.UUID) (*Tenant, error) {
+	return s.lookup(func(c *tenantCache) *Tenant { return c.byID[id] })
+}
+
+// FromContext returns the tenant ctx is bound to, or the default tenant
+func (s *TenantService) FromContext(ctx context.Context) (*Tenant, error) {
+	id, ok := FromContext(ctx)
+	if !ok {
+		id = DefaultID
+	}
+	return s.Find(id)
+}
+
+// SigningKey returns the key the tenant signs its access tokens with
+func (s *TenantService) SigningKey(id uuid.UUID) ([]byte, error) {
+	tenant, err := s.Find(id)
+	if err != nil {
+		return nil, err
+	}
+	if tenant == nil || !tenant.Active {
+		return nil, ErrNotFound
+	}
+	return tenant.SigningKey, nil
+}
+
+// lookup finds a tenant in the cache, reloading it when it is stale or
+// doesn't know the tenant
+func (s *TenantService) lookup(find func(*tenantCache) *Tenant) (*Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.cache != nil && now.Sub(s.cache.loadedAt) < cacheTTL {
+		if tenant := find(s.cache); tenant != nil || now.Sub(s.cache.loadedAt) < missReloadAfter {
+			return tenant, nil
+		}
+	}
+
+	var tenants []Tenant
+	if err := s.db.Find(&tenants).Error; err != nil {
+		return nil, fmt.Errorf("failed to load tenants: %w", err)
+	}
+	cache := &tenantCache{
+		byID:     make(map[uuid.UUID]*Tenant, len(tenants)),
+		bySlug:   make(map[string]*Tenant, len(tenants)),
+		byHost:   make(map[string]*Tenant),
+		loadedAt: now,
+	}
+	for i := range tenants {
+		tenant := &tenants[i]
+		cache.byID[tenant.ID] = tenant
+		cache.bySlug[tenant.Slug] = tenant
+		if tenant.Host != nil {
+			cache.byHost[*tenant.Host] = tenant
+		}
+	}
+	s.cache = cache
+	return find(cache), nil
+}
+
+func (s *TenantService) invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cache = nil
+}
+
+// checkUnique returns taken if another tenant than id has the value in
+// column
+func checkUnique(tx *gorm.DB, id uuid.UUID, column, value string, taken error) error {
+	var count int64
+	err := tx.Model(&Tenant{}).Where(column+" = ? AND id <> ?", value, id).Count(&count).Error
+	if err != nil {
+		return fmt.Errorf("failed to check tenant %s: %w", column, err)
+	}
+	if count > 0 {
+		return taken
+	}
+	return nil
+}
+
+func validateBranding(logoURL, primaryColor string) error {
+	if primaryColor != "" && !colorPattern.MatchString(primaryColor) {
+		return ErrInvalidColor
+	}
+	if logoURL != "" && !strings.HasPrefix(logoURL, "https://") && !strings.HasPrefix(logoURL, "http://") {
+		return ErrInvalidLogoURL
+	}
+	return nil
+}
+
+// normalizeHost lowercases host and strips its port, returning nil for an
+// empty host
+func normalizeHost(host string) *string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	if host == "" {
+		return nil
+	}
+	return &host
+}
+
+func newSigningKey() ([]byte, error) {
+	key := make([]byte, signingKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,84 @@
+package tokenexchange
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"idmapp-go/dto"
+	"idmapp-go/internal/client"
+	"idmapp-go/internal/events"
+	"idmapp-go/middleware"
+	"idmapp-go/services"
+
+	"gorm.io/gorm"
+)
+
+const ClientCredentialsGrantType = "client_credentials"
+
+// ClientCredentials implements the client credentials grant (RFC 6749
+// section 4.4). The token's subject and client_id claim are the client ID,
+// and it carries the requested scopes, or all of the client's scopes.
+func (s *TokenExchangeService) ClientCredentials(req dto.PKCETokenRequest) (*dto.PKCETokenResponse, error) {
+	tokenClient, err := s.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokenClient.Scopes) == 0 {
+		return nil, oauthError(http.StatusBadRequest, "unauthorized_client", "client has no scopes")
+	}
+	scope, err := ResolveScope(req.Scope, "", tokenClient.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.pkceService.GenerateAccessToken(tokenClient.ClientID, "", services.AccessTokenOptions{
+		ClientID: tokenClient.ClientID,
+		TTL:      s.ttl,
+		Scope:    scope,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.Event{
+		Type:    "token.issued",
+		Subject: tokenClient.ClientID,
+		Actor:   tokenClient.ClientID,
+		Data: map[string]interface{}{
+			"grantType": ClientCredentialsGrantType,
+			"scope":     scope,
+		},
+	})
+
+	return &dto.PKCETokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(s.ttl.Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+// ClientValidator accepts only client credentials tokens of active clients
+type ClientValidator struct {
+	db *gorm.DB
+}
+
+func NewClientValidator(db *gorm.DB) *ClientValidator {
+	return &ClientValidator{db: db}
+}
+
+// ValidateToken implements middleware.TokenValidator
+func (v *ClientValidator) ValidateToken(claims *middleware.Claims) error {
+	if claims.ClientID == "" || claims.Sub != claims.ClientID {
+		return errors.New("not a client token")
+	}
+	var tokenClient client.Client
+	if err := v.db.Where("client_id = ? AND active = ?", claims.ClientID, true).First(&tokenClient).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("client is not active")
+		}
+		return fmt.Errorf("failed to get client: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package tokenexchange
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"idmapp-go/dto"
+	"idmapp-go/internal/client"
+	"idmapp-go/internal/events"
+	"idmapp-go/middleware"
+	"idmapp-go/services"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	GrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// maxDelegationDepth limits how long act claim chains may grow
+const maxDelegationDepth = 5
+
+// Error is an OAuth error response (RFC 6749 section 5.2, RFC 8693 section 2.2.2)
+type Error struct {
+	Status      int
+	Code        string
+	Description string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Description)
+}
+
+func oauthError(status int, code, description string) *Error {
+	return &Error{Status: status, Code: code, Description: description}
+}
+
+// TokenExchangeService implements the RFC 8693 token exchange grant. Clients
+// swap a user's token for a shorter-lived one restricted to an audience and
+// scopes allowed by the client's policy; the client, or the party named by
+// the actor token, is recorded in the act claim.
+type TokenExchangeService struct {
+	db            *gorm.DB
+	pkceService   *services.PKCEService
+	ttl           time.Duration
+	authenticator middleware.TokenAuthenticator
+	validators    []middleware.TokenValidator
+	logger        *logrus.Logger
+}
+
+func NewTokenExchangeService(db *gorm.DB, pkceService *services.PKCEService, ttl time.Duration, authenticator middleware.TokenAuthenticator, validators ...middleware.TokenValidator) *TokenExchangeService {
+	return &TokenExchangeService{
+		db:            db,
+		pkceService:   pkceService,
+		ttl:           ttl,
+		authenticator: authenticator,
+		validators:    validators,
+		logger:        logrus.New(),
+	}
+}
+
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *TokenExchangeService) WithContext(ctx context.Context) *TokenExchangeService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	scoped.pkceService = s.pkceService.WithContext(ctx)
+	return &scoped
+}
+
+// Exchange validates the subject token and issues the downscoped token
+func (s *TokenExchangeService) Exchange(req dto.PKCETokenRequest) (*dto.PKCETokenResponse, error) {
+	exchangeClient, err := s.authenticateClient(req.ClientID, req.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if len(exchangeClient.TokenExchangeAudiences) == 0 {
+		return nil, oauthError(http.StatusBadRequest, "unauthorized_client", "client may not use token exchange")
+	}
+
+	subject, err := s.verifyToken(req.SubjectToken, req.SubjectTokenType, "subject_token")
+	if err != nil {
+		return nil, err
+	}
+
+	actorSub := exchangeClient.ClientID
+	if req.ActorToken != "" {
+		actorClaims, err := s.verifyToken(req.ActorToken, req.ActorTokenType, "actor_token")
+		if err != nil {
+			return nil, err
+		}
+		actorSub = actorClaims.Sub
+	} else if req.ActorTokenType != "" {
+		return nil, oauthError(http.StatusBadRequest, "invalid_request", "actor_token_type given without actor_token")
+	}
+
+	actor := &dto.Actor{Sub: actorSub, Act: subject.Act}
+	if DelegationDepth(actor) > maxDelegationDepth {
+		return nil, oauthError(http.StatusBadRequest, "invalid_grant", "delegation chain is too long")
+	}
+
+	audience, err := ResolveAudience(req.Audience, exchangeClient.TokenExchangeAudiences)
+	if err != nil {
+		return nil, err
+	}
+	scope, err := ResolveScope(req.Scope, subject.Scope, exchangeClient.Scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := s.ttl
+	if subject.ExpiresAt != nil {
+		if remaining := time.Until(subject.ExpiresAt.Time); remaining < ttl {
+			ttl = remaining
+		}
+	}
+
+	token, err := s.pkceService.GenerateAccessToken(subject.Sub, subject.Email, services.AccessTokenOptions{
+		SessionID: subject.SessionID,
+		Actor:     actor,
+		TTL:       ttl,
+		Audience:  audience,
+		Scope:     scope,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.Event{
+		Type:    "token.exchanged",
+		Subject: subject.Sub,
+		Actor:   actorSub,
+		Data: map[string]interface{}{
+			"clientId":  exchangeClient.ClientID,
+			"audience":  audience,
+			"scope":     scope,
+			"sessionId": subject.SessionID,
+		},
+	})
+
+	return &dto.PKCETokenResponse{
+		AccessToken:     token,
+		IssuedTokenType: TokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int(ttl.Seconds()),
+		Scope:           scope,
+	}, nil
+}
+
+// authenticateClient checks the confidential client's credentials
+func (s *TokenExchangeService) authenticateClient(clientID, clientSecret string) (*client.Client, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, oauthError(http.StatusUnauthorized, "invalid_client", "client authentication is required")
+	}
+
+	var exchangeClient client.Client
+	if err := s.db.Where("client_id = ? AND active = ?", clientID, true).First(&exchangeClient).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, oauthError(http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		}
+		return nil, fmt.Errorf("failed to get client: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(exchangeClient.ClientSecret), []byte(clientSecret)) != 1 {
+		return nil, oauthError(http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	}
+	return &exchangeClient, nil
+}
+
+func (s *TokenExchangeService) verifyToken(token, tokenType, param string) (*middleware.Claims, error) {
+	if token == "" {
+		return nil, oauthError(http.StatusBadRequest, "invalid_request", param+" is required")
+	}
+	if tokenType != TokenTypeAccessToken && tokenType != TokenTypeJWT {
+		return nil, oauthError(http.StatusBadRequest, "invalid_request", "unsupported "+param+"_type")
+	}
+
+	claims, _, err := middleware.VerifyToken(token, s.authenticator, s.validators...)
+	if err != nil {
+		s.logger.Warnf("Rejected %s in token exchange: %v", param, err)
+		return nil, oauthError(http.StatusBadRequest, "invalid_grant", param+" is invalid")
+	}
+	return claims, nil
+}
+
+// ResolveAudience checks that every requested audience is allowed for the client
+func ResolveAudience(requested []string, allowed []string) ([]string, error) {
+	var audience []string
+	for _, value := range requested {
+		audience = append(audience, strings.Fields(value)...)
+	}
+	if len(audience) == 0 {
+		return nil, oauthError(http.StatusBadRequest, "invalid_request", "audience is required")
+	}
+	for _, aud := range audience {
+		if !contains(allowed, aud) {
+			return nil, oauthError(http.StatusBadRequest, "invalid_target", fmt.Sprintf("audience %q is not allowed for this client", aud))
+		}
+	}
+	return audience, nil
+}
+
+// ResolveScope computes the scope of the exchanged token. Scopes can only be
+// narrowed: every scope must be allowed for the client and, when the subject
+// token is scoped, already be held by it. Without a requested scope the
+// token keeps what the subject and client have in common. An empty scope
+// would leave the token unrestricted, so it is an error.
+func ResolveScope(requested, subjectScope string, clientScopes []string) (string, error) {
+	subjectScopes := strings.Fields(subjectScope)
+
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		if len(subjectScopes) == 0 {
+			scopes = clientScopes
+		} else {
+			for _, scope := range subjectScopes {
+				if contains(clientScopes, scope) {
+					scopes = append(scopes, scope)
+				}
+			}
+			if len(scopes) == 0 {
+				return "", oauthError(http.StatusBadRequest, "invalid_scope", "subject token has no scope this client may use")
+			}
+		}
+	}
+
+	for _, scope := range scopes {
+		if !contains(clientScopes, scope) {
+			return "", oauthError(http.StatusBadRequest, "invalid_scope", fmt.Sprintf("scope %q is not allowed for this client", scope))
+		}
+		if len(subjectScopes) > 0 && !contains(subjectScopes, scope) {
+			return "", oauthError(http.StatusBadRequest, "invalid_scope", fmt.Sprintf("scope %q exceeds the subject token", scope))
+		}
+	}
+	if len(scopes) == 0 {
+		return "", oauthError(http.StatusBadRequest, "invalid_scope", "no scope is allowed for this client")
+	}
+	return strings.Join(scopes, " "), nil
+}
+
+// DelegationDepth returns the number of actors in an act claim chain
+func DelegationDepth(actor *dto.Actor) int {
+	depth := 0
+	for ; actor != nil; actor = actor.Act {
+		depth++
+	}
+	return depth
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
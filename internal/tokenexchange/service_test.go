@@ -0,0 +1,65 @@
+package tokenexchange
+
+import (
+	"testing"
+
+	"idmapp-go/dto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveScope(t *testing.T) {
+	clientScopes := []string{"read", "orders:read", "orders:write"}
+
+	scope, err := ResolveScope("orders:read", "", clientScopes)
+	require.NoError(t, err)
+	assert.Equal(t, "orders:read", scope)
+
+	scope, err = ResolveScope("", "", clientScopes)
+	require.NoError(t, err)
+	assert.Equal(t, "read orders:read orders:write", scope)
+
+	scope, err = ResolveScope("", "read write", clientScopes)
+	require.NoError(t, err)
+	assert.Equal(t, "read", scope)
+
+	_, err = ResolveScope("admin", "", clientScopes)
+	assertOAuthError(t, err, "invalid_scope")
+
+	_, err = ResolveScope("orders:write", "read orders:read", clientScopes)
+	assertOAuthError(t, err, "invalid_scope")
+
+	_, err = ResolveScope("", "write", clientScopes)
+	assertOAuthError(t, err, "invalid_scope")
+
+	// Tokens are never issued without a scope
+	_, err = ResolveScope("", "", nil)
+	assertOAuthError(t, err, "invalid_scope")
+}
+
+func TestResolveAudience(t *testing.T) {
+	allowed := []string{"orders-service", "billing-service"}
+
+	audience, err := ResolveAudience([]string{"orders-service billing-service"}, allowed)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"orders-service", "billing-service"}, audience)
+
+	_, err = ResolveAudience(nil, allowed)
+	assertOAuthError(t, err, "invalid_request")
+
+	_, err = ResolveAudience([]string{"payroll-service"}, allowed)
+	assertOAuthError(t, err, "invalid_target")
+}
+
+func TestDelegationDepth(t *testing.T) {
+	assert.Equal(t, 0, DelegationDepth(nil))
+	assert.Equal(t, 2, DelegationDepth(&dto.Actor{Sub: "gateway", Act: &dto.Actor{Sub: "admin"}}))
+}
+
+func assertOAuthError(t *testing.T, err error, code string) {
+	t.Helper()
+	var oauthErr *Error
+	require.ErrorAs(t, err, &oauthErr)
+	assert.Equal(t, code, oauthErr.Code)
+}
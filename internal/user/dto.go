@@ -1,11 +1,23 @@
 package user
 
+import "time"
+
 type UserCreateRequest struct {
 	Name      string `json:"name" binding:"required"`
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 	Email     string `json:"email" binding:"required,email"`
-	Password  string `json:"password" binding:"required,min=6"`
+	Password  string `json:"password" binding:"required"`
+	Status    string `json:"status" binding:"omitempty,oneof=staged active"`
+}
+
+// FederatedUserRequest describes a user provisioned from an upstream identity provider
+type FederatedUserRequest struct {
+	ProviderID string
+	Name       string
+	FirstName  string
+	LastName   string
+	Email      string
 }
 
 type UserUpdateRequest struct {
@@ -13,7 +25,7 @@ type UserUpdateRequest struct {
 	FirstName string `json:"firstName"`
 	LastName  string `json:"lastName"`
 	Email     string `json:"email" binding:"omitempty,email"`
-	Password  string `json:"password" binding:"omitempty,min=6"`
+	Password  string `json:"password"`
 	IsActive  *bool  `json:"isActive"`
 }
 
@@ -24,16 +36,53 @@ type UserResponse struct {
 	LastName  string `json:"lastName"`
 	Email     string `json:"email"`
 	IsActive  bool   `json:"isActive"`
+	Status    string `json:"status"`
 	CreatedAt string `json:"createdAt"`
 	UpdatedAt string `json:"updatedAt"`
 }
 
+// LifecycleTransitionRequest carries the reason for a lifecycle transition
+// and, for activation and suspension, an optional future effective date
+type LifecycleTransitionRequest struct {
+	Reason      string     `json:"reason" binding:"required"`
+	EffectiveAt *time.Time `json:"effectiveAt"`
+}
+
+type PasswordResetRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type PasswordChangeRequest struct {
+	Email           string `json:"email" binding:"required,email"`
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+// UserImportRequest describes a user migrated from another system together
+// with its existing password hash
+type UserImportRequest struct {
+	Name              string     `json:"name" binding:"required"`
+	FirstName         string     `json:"firstName"`
+	LastName          string     `json:"lastName"`
+	Email             string     `json:"email" binding:"required,email"`
+	PasswordHash      string     `json:"passwordHash" binding:"required"`
+	PasswordChangedAt *time.Time `json:"passwordChangedAt"`
+	IsActive          *bool      `json:"isActive"`
+}
+
+type UserImportResult struct {
+	Email string `json:"email"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
 }
 
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refreshToken,omitempty"`
+	User         UserResponse `json:"user"`
 }
@@ -1,10 +1,14 @@
 package user
 
 import (
+	"errors"
 	"net/http"
 
 	"net/url"
 
+	"idmapp-go/internal/password"
+	"idmapp-go/internal/session"
+	"idmapp-go/middleware"
 	"idmapp-go/services"
 
 	"github.com/gin-gonic/gin"
@@ -13,21 +17,23 @@ import (
 )
 
 type UserController struct {
-	userService *UserService
-	pkceService *services.PKCEService
-	logger      *logrus.Logger
+	userService    *UserService
+	pkceService    *services.PKCEService
+	sessionService *session.SessionService
+	logger         *logrus.Logger
 }
 
-func NewUserController(userService *UserService, pkceService *services.PKCEService) *UserController {
+func NewUserController(userService *UserService, pkceService *services.PKCEService, sessionService *session.SessionService) *UserController {
 	return &UserController{
-		userService: userService,
-		pkceService: pkceService,
-		logger:      logrus.New(),
+		userService:    userService,
+		pkceService:    pkceService,
+		sessionService: sessionService,
+		logger:         logrus.New(),
 	}
 }
 
 func (c *UserController) GetAllUsers(ctx *gin.Context) {
-	users, err := c.userService.GetAllUsers()
+	users, err := c.userService.WithContext(ctx).GetAllUsers()
 	if err != nil {
 		c.logger.Errorf("Failed to get users: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
@@ -45,7 +51,7 @@ func (c *UserController) GetUser(ctx *gin.Context) {
 		return
 	}
 
-	user, err := c.userService.GetUser(id)
+	user, err := c.userService.WithContext(ctx).GetUser(id)
 	if err != nil {
 		c.logger.Errorf("Failed to get user: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
@@ -67,8 +73,11 @@ func (c *UserController) CreateUser(ctx *gin.Context) {
 		return
 	}
 
-	user, err := c.userService.CreateUser(req)
+	user, err := c.userService.WithContext(ctx).CreateUser(req)
 	if err != nil {
+		if respondPolicyError(ctx, err) {
+			return
+		}
 		c.logger.Errorf("Failed to create user: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -91,8 +100,15 @@ func (c *UserController) UpdateUser(ctx *gin.Context) {
 		return
 	}
 
-	user, err := c.userService.UpdateUser(id, req)
+	user, err := c.userService.WithContext(ctx).UpdateUser(id, req)
 	if err != nil {
+		if respondPolicyError(ctx, err) {
+			return
+		}
+		if errors.Is(err, ErrInvalidTransition) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.logger.Errorf("Failed to update user: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -114,7 +130,7 @@ func (c *UserController) DeleteUser(ctx *gin.Context) {
 		return
 	}
 
-	err = c.userService.DeleteUser(id)
+	err = c.userService.WithContext(ctx).DeleteUser(id)
 	if err != nil {
 		c.logger.Errorf("Failed to delete user: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -124,6 +140,174 @@ func (c *UserController) DeleteUser(ctx *gin.Context) {
 	ctx.Status(http.StatusNoContent)
 }
 
+func (c *UserController) ActivateUser(ctx *gin.Context) {
+	c.transitionUser(ctx, ActionActivate)
+}
+
+func (c *UserController) SuspendUser(ctx *gin.Context) {
+	c.transitionUser(ctx, ActionSuspend)
+}
+
+func (c *UserController) LockUser(ctx *gin.Context) {
+	c.transitionUser(ctx, ActionLock)
+}
+
+func (c *UserController) UnlockUser(ctx *gin.Context) {
+	c.transitionUser(ctx, ActionUnlock)
+}
+
+func (c *UserController) DeprovisionUser(ctx *gin.Context) {
+	c.transitionUser(ctx, ActionDeprovision)
+}
+
+func (c *UserController) transitionUser(ctx *gin.Context, action LifecycleAction) {
+	idStr := ctx.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req LifecycleTransitionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, schedule, err := c.userService.WithContext(ctx).TransitionUser(id, action, req, middleware.GetUserID(ctx))
+	if err != nil {
+		if errors.Is(err, ErrInvalidTransition) || errors.Is(err, ErrNotSchedulable) {
+			ctx.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.logger.Errorf("Failed to %s user: %v", action, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if schedule != nil {
+		ctx.JSON(http.StatusAccepted, schedule)
+		return
+	}
+
+	if user == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, user)
+}
+
+func (c *UserController) GetLifecycleSchedules(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	schedules, err := c.userService.WithContext(ctx).GetLifecycleSchedules(id)
+	if err != nil {
+		c.logger.Errorf("Failed to get lifecycle schedules: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get lifecycle schedules"})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schedules)
+}
+
+func (c *UserController) CancelLifecycleSchedule(ctx *gin.Context) {
+	id, err := uuid.Parse(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	scheduleID, err := uuid.Parse(ctx.Param("scheduleId"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return
+	}
+
+	cancelled, err := c.userService.WithContext(ctx).CancelLifecycleSchedule(id, scheduleID)
+	if err != nil {
+		c.logger.Errorf("Failed to cancel lifecycle schedule: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !cancelled {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "Lifecycle schedule not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *UserController) ImportUsers(ctx *gin.Context) {
+	var req []UserImportRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results := c.userService.WithContext(ctx).ImportUsers(req)
+	ctx.JSON(http.StatusOK, results)
+}
+
+func (c *UserController) ResetPassword(ctx *gin.Context) {
+	idStr := ctx.Param("id")
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req PasswordResetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := c.userService.WithContext(ctx).ResetPassword(id, req.Password)
+	if err != nil {
+		if respondPolicyError(ctx, err) {
+			return
+		}
+		c.logger.Errorf("Failed to reset password: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if user == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *UserController) ChangePassword(ctx *gin.Context) {
+	var req PasswordChangeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := c.userService.WithContext(ctx).ChangePassword(req); err != nil {
+		if respondPolicyError(ctx, err) {
+			return
+		}
+		if errors.Is(err, ErrInvalidCredentials) {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			return
+		}
+		c.logger.Errorf("Failed to change password: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
 func (c *UserController) Login(ctx *gin.Context) {
 	var req LoginRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
@@ -132,21 +316,42 @@ func (c *UserController) Login(ctx *gin.Context) {
 	}
 
 	// Use the new local authentication method
-	user, err := c.userService.AuthenticateUser(req.Email, req.Password)
+	user, err := c.userService.WithContext(ctx).AuthenticateUser(req.Email, req.Password)
+	if errors.Is(err, ErrPasswordExpired) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": "Password expired", "code": "password_expired"})
+		return
+	}
 	if err != nil {
 		c.logger.Errorf("Authentication failed: %v", err)
 		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
+	info := session.ClientInfoFromRequest(ctx)
+	loginSession, err := c.sessionService.WithContext(ctx).CreateSession(user.ID, session.APIClientID, info)
+	if err != nil {
+		c.logger.Errorf("Failed to create session: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+
 	// Generate a JWT token for the user (using the same method as PKCE service)
-	token, err := c.pkceService.GenerateAccessToken(user.ID.String(), user.Email)
+	token, err := c.pkceService.WithContext(ctx).GenerateAccessToken(user.ID.String(), user.Email, services.AccessTokenOptions{
+		SessionID: loginSession.ID.String(),
+	})
 	if err != nil {
 		c.logger.Errorf("Failed to generate token: %v", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
+	refreshToken, err := c.sessionService.WithContext(ctx).IssueRefreshToken(loginSession, session.APIClientID, "", info)
+	if err != nil {
+		c.logger.Errorf("Failed to issue refresh token: %v", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue refresh token"})
+		return
+	}
+
 	// Create user response
 	userResponse := UserResponse{
 		ID:        user.ID.String(),
@@ -155,13 +360,15 @@ func (c *UserController) Login(ctx *gin.Context) {
 		LastName:  user.LastName,
 		Email:     user.Email,
 		IsActive:  user.IsActive,
+		Status:    string(user.Status),
 		CreatedAt: user.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
 		UpdatedAt: user.UpdatedAt.Format("2006-01-02T15:04:05Z07:00"),
 	}
 
 	response := AuthResponse{
-		Token: token,
-		User:  userResponse,
+		Token:        token,
+		RefreshToken: refreshToken,
+		User:         userResponse,
 	}
 
 	ctx.JSON(http.StatusOK, response)
@@ -176,3 +383,16 @@ func (c *UserController) Login(ctx *gin.Context) {
 		}
 	}
 }
+
+// respondPolicyError writes a 400 response listing the policy violations if err is a *password.PolicyError
+func respondPolicyError(ctx *gin.Context, err error) bool {
+	var policyErr *password.PolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	ctx.JSON(http.StatusBadRequest, gin.H{
+		"error":      "Password does not meet policy",
+		"violations": policyErr.Violations,
+	})
+	return true
+}
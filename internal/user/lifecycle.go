@@ -0,0 +1,416 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/member"
+	"idmapp-go/middleware"
+	"idmapp-go/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Status is the lifecycle state of a user
+type Status string
+
+const (
+	StatusStaged        Status = "staged"
+	StatusActive        Status = "active"
+	StatusSuspended     Status = "suspended"
+	StatusLocked        Status = "locked"
+	StatusDeprovisioned Status = "deprovisioned"
+)
+
+// LifecycleAction is a transition between lifecycle states
+type LifecycleAction string
+
+const (
+	ActionActivate    LifecycleAction = "activate"
+	ActionSuspend     LifecycleAction = "suspend"
+	ActionLock        LifecycleAction = "lock"
+	ActionUnlock      LifecycleAction = "unlock"
+	ActionDeprovision LifecycleAction = "deprovision"
+)
+
+// Schedule states
+const (
+	ScheduleStatePending   = "pending"
+	ScheduleStateCompleted = "completed"
+	ScheduleStateCancelled = "cancelled"
+	ScheduleStateFailed    = "failed"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid lifecycle transition")
+	ErrNotSchedulable    = errors.New("only activation and suspension can be scheduled")
+)
+
+type lifecycleTransition struct {
+	from  []Status
+	to    Status
+	event string
+}
+
+var lifecycleTransitions = map[LifecycleAction]lifecycleTransition{
+	ActionActivate: {
+		from:  []Status{StatusStaged, StatusSuspended},
+		to:    StatusActive,
+		event: "user.activated",
+	},
+	ActionSuspend: {
+		from:  []Status{StatusActive},
+		to:    StatusSuspended,
+		event: "user.suspended",
+	},
+	ActionLock: {
+		from:  []Status{StatusActive, StatusSuspended},
+		to:    StatusLocked,
+		event: "user.locked",
+	},
+	ActionUnlock: {
+		from:  []Status{StatusLocked},
+		to:    StatusActive,
+		event: "user.unlocked",
+	},
+	ActionDeprovision: {
+		from:  []Status{StatusStaged, StatusActive, StatusSuspended, StatusLocked},
+		to:    StatusDeprovisioned,
+		event: "user.deprovisioned",
+	},
+}
+
+func (t lifecycleTransition) allowedFrom(status Status) bool {
+	for _, from := range t.from {
+		if from == status {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitionUser applies action to the user now, or schedules it when
+// effectiveAt is in the future. It returns either the updated user or the
+// created schedule.
+func (s *UserService) TransitionUser(id uuid.UUID, action LifecycleAction, req LifecycleTransitionRequest, actor string) (*User, *LifecycleSchedule, error) {
+	transition, ok := lifecycleTransitions[action]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: unknown action %s", ErrInvalidTransition, action)
+	}
+
+	user, err := s.GetUser(id)
+	if err != nil || user == nil {
+		return nil, nil, err
+	}
+
+	if req.EffectiveAt != nil && req.EffectiveAt.After(time.Now()) {
+		schedule, err := s.scheduleTransition(user, action, req, actor)
+		return nil, schedule, err
+	}
+
+	if !transition.allowedFrom(user.Status) {
+		return nil, nil, fmt.Errorf("%w: cannot %s a %s user", ErrInvalidTransition, action, user.Status)
+	}
+
+	if err := s.applyTransition(user, action, req.Reason, actor, false); err != nil {
+		return nil, nil, err
+	}
+	return user, nil, nil
+}
+
+func (s *UserService) scheduleTransition(user *User, action LifecycleAction, req LifecycleTransitionRequest, actor string) (*LifecycleSchedule, error) {
+	if action != ActionActivate && action != ActionSuspend {
+		return nil, ErrNotSchedulable
+	}
+	if user.Status == StatusDeprovisioned {
+		return nil, fmt.Errorf("%w: user is deprovisioned", ErrInvalidTransition)
+	}
+
+	schedule := LifecycleSchedule{
+		UserID:    user.ID,
+		Action:    action,
+		Reason:    req.Reason,
+		Actor:     actor,
+		ExecuteAt: *req.EffectiveAt,
+		State:     ScheduleStatePending,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := s.db.Create(&schedule).Error; err != nil {
+		return nil, fmt.Errorf("failed to schedule lifecycle transition: %w", err)
+	}
+
+	events.Publish(events.Event{
+		Type:    "user.transition_scheduled",
+		Subject: user.ID.String(),
+		Actor:   actor,
+		Data: map[string]interface{}{
+			"scheduleId": schedule.ID.String(),
+			"action":     string(action),
+			"reason":     req.Reason,
+			"executeAt":  schedule.ExecuteAt,
+		},
+	})
+
+	return &schedule, nil
+}
+
+// applyTransition moves user to the target state of action inside a
+// transaction, running the side effects of deprovisioning, and emits the
+// transition event once committed
+func (s *UserService) applyTransition(user *User, action LifecycleAction, reason, actor string, scheduled bool) error {
+	var event events.Event
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		event, err = s.changeStatus(tx, user, action, reason, actor, scheduled)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	s.publishTransition(event)
+	return nil
+}
+
+// changeStatus moves user to the target state of action in tx, running the
+// side effects of deprovisioning. It returns the transition event, which is
+// only to be published once tx is committed.
+func (s *UserService) changeStatus(tx *gorm.DB, user *User, action LifecycleAction, reason, actor string, scheduled bool) (events.Event, error) {
+	transition := lifecycleTransitions[action]
+	from := user.Status
+	now := time.Now()
+
+	user.Status = transition.to
+	user.IsActive = transition.to == StatusActive
+	user.StatusReason = reason
+	user.StatusChangedAt = &now
+	user.UpdatedAt = now
+
+	if transition.to == StatusDeprovisioned {
+		if err := s.deprovision(tx, user, now); err != nil {
+			return events.Event{}, err
+		}
+	}
+
+	if err := tx.Save(user).Error; err != nil {
+		return events.Event{}, fmt.Errorf("failed to update user status: %w", err)
+	}
+
+	return events.Event{
+		Type:       transition.event,
+		Subject:    user.ID.String(),
+		Actor:      actor,
+		OccurredAt: now,
+		Data: map[string]interface{}{
+			"from":      string(from),
+			"to":        string(transition.to),
+			"reason":    reason,
+			"scheduled": scheduled,
+		},
+	}, nil
+}
+
+// publishTransition emits the event of a committed transition
+func (s *UserService) publishTransition(event events.Event) {
+	events.Publish(event)
+	s.logger.Infof("User %s transitioned %s -> %s (%s)", event.Subject, event.Data["from"], event.Data["to"], event.Data["reason"])
+}
+
+// deprovision removes all memberships of the user, cancels pending
+// schedules and revokes issued tokens
+func (s *UserService) deprovision(tx *gorm.DB, user *User, now time.Time) error {
+	var groups []member.Member
+	if err := tx.Clauses(clause.Returning{}).Where("user_id = ?", user.ID).Delete(&groups).Error; err != nil {
+		return fmt.Errorf("failed to remove group memberships: %w", err)
+	}
+	var roles []models.RoleMember
+	if err := tx.Clauses(clause.Returning{}).Where("entity_id = ? AND type = ?", user.ID, "USER").Delete(&roles).Error; err != nil {
+		return fmt.Errorf("failed to remove role memberships: %w", err)
+	}
+	var orgs []models.OrgMember
+	if err := tx.Clauses(clause.Returning{}).Where("entity_id = ? AND type = ?", user.ID, "USER").Delete(&orgs).Error; err != nil {
+		return fmt.Errorf("failed to remove org memberships: %w", err)
+	}
+	tuples := append(authz.RoleMemberTuples(roles), authz.OrgMemberTuples(orgs)...)
+	for _, m := range groups {
+		tuples = append(tuples, authz.GroupMemberTuple(m.GroupID, m.UserID))
+	}
+	if err := authz.Record(tx, authz.OpDelete, tuples...); err != nil {
+		return err
+	}
+	if err := tx.Model(&LifecycleSchedule{}).
+		Where("user_id = ? AND state = ?", user.ID, ScheduleStatePending).
+		Updates(map[string]interface{}{"state": ScheduleStateCancelled, "updated_at": now}).Error; err != nil {
+		return fmt.Errorf("failed to cancel lifecycle schedules: %w", err)
+	}
+
+	user.TokensRevokedAt = &now
+	return nil
+}
+
+// GetLifecycleSchedules lists the pending scheduled transitions of a user
+func (s *UserService) GetLifecycleSchedules(userID uuid.UUID) ([]LifecycleSchedule, error) {
+	var schedules []LifecycleSchedule
+	result := s.db.Where("user_id = ? AND state = ?", userID, ScheduleStatePending).Order("execute_at").Find(&schedules)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to get lifecycle schedules: %w", result.Error)
+	}
+	return schedules, nil
+}
+
+// CancelLifecycleSchedule cancels a pending scheduled transition and reports whether one was found
+func (s *UserService) CancelLifecycleSchedule(userID, scheduleID uuid.UUID) (bool, error) {
+	result := s.db.Model(&LifecycleSchedule{}).
+		Where("id = ? AND user_id = ? AND state = ?", scheduleID, userID, ScheduleStatePending).
+		Updates(map[string]interface{}{"state": ScheduleStateCancelled, "updated_at": time.Now()})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to cancel lifecycle schedule: %w", result.Error)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ProcessDueTransitions runs every pending schedule whose execution date has
+// passed. Rows are claimed with SKIP LOCKED so several instances can run it;
+// the transitions are applied in the transaction that claimed them, and
+// their events published once it is committed.
+func (s *UserService) ProcessDueTransitions() (int, error) {
+	var due []LifecycleSchedule
+	var changes []events.Event
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("state = ? AND execute_at <= ?", ScheduleStatePending, time.Now()).
+			Order("execute_at").
+			Limit(100).
+			Find(&due).Error; err != nil {
+			return err
+		}
+
+		for i := range due {
+			event, err := s.executeSchedule(tx, &due[i])
+			if err != nil {
+				return err
+			}
+			if event != nil {
+				changes = append(changes, *event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to process lifecycle schedules: %w", err)
+	}
+
+	for _, event := range changes {
+		s.publishTransition(event)
+	}
+	return len(due), nil
+}
+
+// executeSchedule applies the transition of a claimed schedule and marks the
+// schedule done in tx. A transition that fails is rolled back on its own and
+// marks the schedule failed. It returns the event of the transition, if any.
+func (s *UserService) executeSchedule(tx *gorm.DB, schedule *LifecycleSchedule) (*events.Event, error) {
+	now := time.Now()
+	schedule.ExecutedAt = &now
+	schedule.UpdatedAt = now
+	schedule.State = ScheduleStateCompleted
+
+	var event *events.Event
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		var user User
+		if err := tx.First(&user, "id = ?", schedule.UserID).Error; err != nil {
+			return err
+		}
+		if !lifecycleTransitions[schedule.Action].allowedFrom(user.Status) {
+			return fmt.Errorf("%w: cannot %s a %s user", ErrInvalidTransition, schedule.Action, user.Status)
+		}
+		changed, err := s.changeStatus(tx, &user, schedule.Action, schedule.Reason, schedule.Actor, true)
+		if err != nil {
+			return err
+		}
+		event = &changed
+		return nil
+	})
+	if err != nil {
+		s.logger.Errorf("Scheduled %s of user %s failed: %v", schedule.Action, schedule.UserID, err)
+		schedule.State = ScheduleStateFailed
+		schedule.Error = err.Error()
+	}
+
+	if err := tx.Save(schedule).Error; err != nil {
+		return nil, fmt.Errorf("failed to update lifecycle schedule %s: %w", schedule.ID, err)
+	}
+	return event, nil
+}
+
+// RunLifecycleScheduler processes due schedules every interval until ctx is done
+func (s *UserService) RunLifecycleScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			processed, err := s.ProcessDueTransitions()
+			if err != nil {
+				s.logger.Errorf("Lifecycle scheduler run failed: %v", err)
+			} else if processed > 0 {
+				s.logger.Infof("Lifecycle scheduler processed %d transitions", processed)
+			}
+		}
+	}
+}
+
+// ValidateToken implements middleware.TokenValidator
+func (s *UserService) ValidateToken(claims *middleware.Claims) error {
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+	return s.ValidateTokenSubject(claims.Sub, issuedAt)
+}
+
+// ValidateTokenSubject rejects tokens whose subject is not an active user or
+// that were issued before the user's tokens were revoked
+func (s *UserService) ValidateTokenSubject(subject string, issuedAt time.Time) error {
+	id, err := uuid.Parse(subject)
+	if err != nil {
+		return errors.New("invalid token subject")
+	}
+
+	user, err := s.GetUser(id)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return errors.New("user not found")
+	}
+	if user.Status != StatusActive {
+		return fmt.Errorf("user account is %s", user.Status)
+	}
+	if user.TokensRevokedAt != nil && issuedAt.Before(*user.TokensRevokedAt) {
+		return errors.New("token has been revoked")
+	}
+	return nil
+}
+
+// RevokeTokens invalidates every token issued to the user so far
+func (s *UserService) RevokeTokens(id uuid.UUID) error {
+	result := s.db.Model(&User{}).Where("id = ?", id).Update("tokens_revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke tokens: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+	return nil
+}
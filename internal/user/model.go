@@ -1,22 +1,35 @@
 package user
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/tenant"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type User struct {
-	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	Name      string    `json:"name" gorm:"not null"`
-	FirstName string    `json:"firstName" gorm:"column:firstname"`
-	LastName  string    `json:"lastName" gorm:"column:lastname"`
-	Email     string    `json:"email" gorm:"unique;not null"`
-	Password  string    `json:"-" gorm:"not null"` // "-" means don't include in JSON
-	IsActive  bool      `json:"isActive" gorm:"default:true"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	tenant.Scoped
+	ID                uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Name              string     `json:"name" gorm:"not null"`
+	FirstName         string     `json:"firstName" gorm:"column:firstname"`
+	LastName          string     `json:"lastName" gorm:"column:lastname"`
+	Email             string     `json:"email" gorm:"not null"`
+	Password          string     `json:"-" gorm:"not null"` // "-" means don't include in JSON
+	IsActive          bool       `json:"isActive" gorm:"default:true"`
+	Status            Status     `json:"status" gorm:"type:varchar(32);not null;default:'active'"`
+	StatusReason      string     `json:"statusReason,omitempty" gorm:"column:status_reason"`
+	StatusChangedAt   *time.Time `json:"statusChangedAt,omitempty" gorm:"column:status_changed_at"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	UpdatedAt         time.Time  `json:"updatedAt"`
+	PasswordChangedAt *time.Time `json:"passwordChangedAt,omitempty" gorm:"column:password_changed_at"`
+	TokensRevokedAt   *time.Time `json:"-" gorm:"column:tokens_revoked_at"`
+	// ExternalID is the identifier a SCIM client assigned to the user
+	ExternalID string `json:"externalId,omitempty" gorm:"column:external_id;index"`
 }
 
 func (u *User) BeforeCreate(tx *gorm.DB) error {
@@ -26,6 +39,55 @@ func (u *User) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// AfterCreate links the user to the authorization system object
+func (u *User) AfterCreate(tx *gorm.DB) error {
+	return authz.RecordSystemLink(tx, authz.OpWrite, "user", u.ID)
+}
+
+// AfterDelete removes the link of the user to the authorization system
+// object. Delete with the ID set, e.g. Delete(&User{ID: id}), for it to run.
+func (u *User) AfterDelete(tx *gorm.DB) error {
+	return authz.RecordSystemLink(tx, authz.OpDelete, "user", u.ID)
+}
+
 func (u *User) TableName() string {
 	return "users"
 }
+
+// StatusError returns why the user cannot sign in, or nil for active users
+func (u *User) StatusError() error {
+	switch u.Status {
+	case StatusActive:
+		return nil
+	case StatusStaged:
+		return errors.New("user account is not activated yet")
+	default:
+		return fmt.Errorf("user account is %s", u.Status)
+	}
+}
+
+// LifecycleSchedule is a lifecycle transition that runs at a future date
+type LifecycleSchedule struct {
+	ID         uuid.UUID       `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID     uuid.UUID       `json:"userId" gorm:"type:uuid;not null;index;column:user_id"`
+	Action     LifecycleAction `json:"action" gorm:"type:varchar(32);not null"`
+	Reason     string          `json:"reason" gorm:"not null"`
+	Actor      string          `json:"actor,omitempty"`
+	ExecuteAt  time.Time       `json:"executeAt" gorm:"not null;index"`
+	State      string          `json:"state" gorm:"type:varchar(32);not null;index"`
+	Error      string          `json:"error,omitempty"`
+	ExecutedAt *time.Time      `json:"executedAt,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+}
+
+func (s *LifecycleSchedule) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+func (s *LifecycleSchedule) TableName() string {
+	return "user_lifecycle_schedules"
+}
@@ -1,29 +1,69 @@
 package user
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/password"
+	"idmapp-go/internal/tenant"
+
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+// ErrPasswordExpired is returned by AuthenticateUser when the password is older than the policy allows
+var ErrPasswordExpired = errors.New("password has expired")
+
+// ErrInvalidCredentials is returned for unknown emails and wrong passwords,
+// and by ChangePassword for users who can't sign in
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// ErrNotManaged is returned by an Authenticator for users whose password it
+// doesn't hold; they fall back to their local password
+var ErrNotManaged = errors.New("user is not managed by this authenticator")
+
+// Authenticator verifies the passwords of users whose credentials live in an
+// external system such as an LDAP directory
+type Authenticator interface {
+	Authenticate(user *User, password string) error
+}
+
 type UserService struct {
-	db     *gorm.DB
-	logger *logrus.Logger
+	db             *gorm.DB
+	passwordPolicy *password.PolicyService
+	hashes         *password.HashManager
+	authenticators []Authenticator
+	logger         *logrus.Logger
 }
 
-func NewUserService(db *gorm.DB) *UserService {
+func NewUserService(db *gorm.DB, passwordPolicy *password.PolicyService, hashes *password.HashManager) *UserService {
 	return &UserService{
-		db:     db,
-		logger: logrus.New(),
+		db:             db,
+		passwordPolicy: passwordPolicy,
+		hashes:         hashes,
+		logger:         logrus.New(),
 	}
 }
 
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *UserService) WithContext(ctx context.Context) *UserService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
+// AddAuthenticator registers an external authenticator. Authenticators are
+// asked in the order they were added before the local password is checked.
+func (s *UserService) AddAuthenticator(authenticator Authenticator) {
+	s.authenticators = append(s.authenticators, authenticator)
+}
+
 func (s *UserService) GetAllUsers() ([]User, error) {
 	var users []User
 	result := s.db.Find(&users)
@@ -52,27 +92,90 @@ func (s *UserService) CreateUser(req UserCreateRequest) (*User, error) {
 		return nil, errors.New("user with this email already exists")
 	}
 
+	if err := s.passwordPolicy.Validate(uuid.Nil, req.Password); err != nil {
+		return nil, err
+	}
+
 	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hashes.Hash(req.Password)
 	if err != nil {
 		return nil, fmt.Errorf("failed to hash password: %w", err)
 	}
 
+	status := StatusActive
+	if req.Status != "" {
+		status = Status(req.Status)
+	}
+
+	now := time.Now()
 	user := User{
-		Name:      req.Name,
-		FirstName: req.FirstName,
-		LastName:  req.LastName,
-		Email:     req.Email,
-		Password:  string(hashedPassword),
-		IsActive:  true,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:                uuid.New(),
+		Name:              req.Name,
+		FirstName:         req.FirstName,
+		LastName:          req.LastName,
+		Email:             req.Email,
+		Password:          hashedPassword,
+		PasswordChangedAt: &now,
+		IsActive:          status == StatusActive,
+		Status:            status,
+		StatusChangedAt:   &now,
+		CreatedAt:         now,
+		UpdatedAt:         now,
 	}
 
-	result := s.db.Create(&user)
-	if result.Error != nil {
-		return nil, fmt.Errorf("failed to create user: %w", result.Error)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+		return s.passwordPolicy.RecordHistory(tx, user.ID, user.Password)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.Event{
+		Type:    "user.created",
+		Subject: user.ID.String(),
+		Data:    map[string]interface{}{"status": string(user.Status)},
+	})
+
+	return &user, nil
+}
+
+// CreateFederatedUser provisions an active user for an identity verified by an
+// upstream provider. The user has no local password and can only sign in
+// through federation until one is set.
+func (s *UserService) CreateFederatedUser(req FederatedUserRequest) (*User, error) {
+	name := req.Name
+	if name == "" {
+		name = req.Email
+	}
+
+	now := time.Now()
+	user := User{
+		ID:              uuid.New(),
+		Name:            name,
+		FirstName:       req.FirstName,
+		LastName:        req.LastName,
+		Email:           req.Email,
+		IsActive:        true,
+		Status:          StatusActive,
+		StatusChangedAt: &now,
+		CreatedAt:       now,
+		UpdatedAt:       now,
 	}
+	if err := s.db.Create(&user).Error; err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	events.Publish(events.Event{
+		Type:    "user.created",
+		Subject: user.ID.String(),
+		Data: map[string]interface{}{
+			"status":   string(user.Status),
+			"provider": req.ProviderID,
+		},
+	})
 
 	return &user, nil
 }
@@ -106,34 +209,157 @@ func (s *UserService) UpdateUser(id uuid.UUID, req UserUpdateRequest) (*User, er
 		user.Email = req.Email
 	}
 	if req.Password != "" {
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-		if err != nil {
-			return nil, fmt.Errorf("failed to hash password: %w", err)
+		if err := s.passwordPolicy.Validate(user.ID, req.Password); err != nil {
+			return nil, err
 		}
-		user.Password = string(hashedPassword)
 	}
-	if req.IsActive != nil {
-		user.IsActive = *req.IsActive
+
+	// isActive is kept for existing clients and is mapped onto the lifecycle
+	var statusAction LifecycleAction
+	if req.IsActive != nil && *req.IsActive != user.IsActive {
+		statusAction = ActionSuspend
+		if *req.IsActive {
+			statusAction = ActionActivate
+		}
+		if !lifecycleTransitions[statusAction].allowedFrom(user.Status) {
+			return nil, fmt.Errorf("%w: cannot %s a %s user", ErrInvalidTransition, statusAction, user.Status)
+		}
 	}
 
 	user.UpdatedAt = time.Now()
 
-	result = s.db.Save(&user)
+	// The profile and the status change are committed together, and their
+	// events published once they are
+	var transition *events.Event
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if req.Password != "" {
+			if err := s.setPassword(tx, &user, req.Password); err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(&user).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		if statusAction != "" {
+			event, err := s.changeStatus(tx, &user, statusAction, "Updated through the user API", "", false)
+			if err != nil {
+				return err
+			}
+			transition = &event
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.Event{
+		Type:    "user.updated",
+		Subject: user.ID.String(),
+	})
+	if transition != nil {
+		s.publishTransition(*transition)
+	}
+
+	return &user, nil
+}
+
+// ResetPassword sets a new password for the user after checking it against the password policy
+func (s *UserService) ResetPassword(id uuid.UUID, newPassword string) (*User, error) {
+	var user User
+	result := s.db.First(&user, "id = ?", id)
 	if result.Error != nil {
-		return nil, fmt.Errorf("failed to update user: %w", result.Error)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get user: %w", result.Error)
+	}
+
+	if err := s.passwordPolicy.Validate(user.ID, newPassword); err != nil {
+		return nil, err
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.setPassword(tx, &user, newPassword); err != nil {
+			return err
+		}
+		if err := tx.Save(&user).Error; err != nil {
+			return fmt.Errorf("failed to reset password: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &user, nil
 }
 
+// ChangePassword lets a user replace their own password, including an expired one
+func (s *UserService) ChangePassword(req PasswordChangeRequest) error {
+	var user User
+	result := s.db.Where("email = ?", req.Email).First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return ErrInvalidCredentials
+		}
+		return fmt.Errorf("failed to get user: %w", result.Error)
+	}
+	if err := user.StatusError(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	ok, _, err := s.hashes.Verify(req.CurrentPassword, user.Password)
+	if err != nil {
+		return fmt.Errorf("failed to verify password: %w", err)
+	}
+	if !ok {
+		return ErrInvalidCredentials
+	}
+
+	if err := s.passwordPolicy.Validate(user.ID, req.NewPassword); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.setPassword(tx, &user, req.NewPassword); err != nil {
+			return err
+		}
+		if err := tx.Save(&user).Error; err != nil {
+			return fmt.Errorf("failed to change password: %w", err)
+		}
+		return nil
+	})
+}
+
+// setPassword hashes newPassword onto user and records it in the password history.
+// The caller is responsible for validating the password and saving the user.
+func (s *UserService) setPassword(tx *gorm.DB, user *User, newPassword string) error {
+	hashedPassword, err := s.hashes.Hash(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	now := time.Now()
+	user.Password = hashedPassword
+	user.PasswordChangedAt = &now
+	user.UpdatedAt = now
+
+	return s.passwordPolicy.RecordHistory(tx, user.ID, user.Password)
+}
+
 func (s *UserService) DeleteUser(id uuid.UUID) error {
-	result := s.db.Delete(&User{}, "id = ?", id)
+	result := s.db.Delete(&User{ID: id})
 	if result.Error != nil {
 		return fmt.Errorf("failed to delete user: %w", result.Error)
 	}
 	if result.RowsAffected == 0 {
 		return errors.New("user not found")
 	}
+
+	events.Publish(events.Event{
+		Type:    "user.deleted",
+		Subject: id.String(),
+	})
 	return nil
 }
 
@@ -142,19 +368,19 @@ func (s *UserService) Authenticate(req LoginRequest) (*AuthResponse, error) {
 	result := s.db.Where("email = ?", req.Email).First(&user)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.New("invalid credentials")
+			return nil, ErrInvalidCredentials
 		}
 		return nil, fmt.Errorf("failed to get user: %w", result.Error)
 	}
 
 	// Check if user is active
-	if !user.IsActive {
-		return nil, errors.New("user account is deactivated")
+	if err := user.StatusError(); err != nil {
+		return nil, err
 	}
 
 	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
-		return nil, errors.New("invalid credentials")
+	if ok, _, err := s.hashes.Verify(req.Password, user.Password); err != nil || !ok {
+		return nil, ErrInvalidCredentials
 	}
 
 	// Generate local JWT token
@@ -164,8 +390,7 @@ func (s *UserService) Authenticate(req LoginRequest) (*AuthResponse, error) {
 		"exp":   time.Now().Add(1 * time.Hour).Unix(),
 		"iat":   time.Now().Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString([]byte("your-256-bit-secret")) // Use same key as middleware
+	signedToken, err := tenant.SignToken(claims, user.TenantID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate token: %w", err)
 	}
@@ -177,6 +402,7 @@ func (s *UserService) Authenticate(req LoginRequest) (*AuthResponse, error) {
 		LastName:  user.LastName,
 		Email:     user.Email,
 		IsActive:  user.IsActive,
+		Status:    string(user.Status),
 		CreatedAt: user.CreatedAt.Format(time.RFC3339),
 		UpdatedAt: user.UpdatedAt.Format(time.RFC3339),
 	}
@@ -193,16 +419,141 @@ func (s *UserService) AuthenticateUser(email, password string) (*User, error) {
 	result := s.db.Where("email = ?", email).First(&user)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.New("invalid credentials")
+			return nil, ErrInvalidCredentials
 		}
 		return nil, result.Error
 	}
-	if !user.IsActive {
-		return nil, errors.New("user account is deactivated")
+	if err := user.StatusError(); err != nil {
+		return nil, err
+	}
+	external, err := s.authenticateExternally(&user, password)
+	if err != nil {
+		return nil, err
+	}
+	if external {
+		return &user, nil
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, errors.New("invalid credentials")
+	ok, needsRehash, err := s.hashes.Verify(password, user.Password)
+	if err != nil || !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if needsRehash {
+		s.rehashPassword(&user, password)
+	}
+	if user.PasswordChangedAt != nil && s.passwordPolicy.IsExpired(*user.PasswordChangedAt) {
+		return nil, ErrPasswordExpired
+	}
+	return &user, nil
+}
+
+// authenticateExternally checks the password with the first authenticator
+// that manages the user. It reports false when none does.
+func (s *UserService) authenticateExternally(user *User, password string) (bool, error) {
+	for _, authenticator := range s.authenticators {
+		err := authenticator.Authenticate(user, password)
+		if errors.Is(err, ErrNotManaged) {
+			continue
+		}
+		if err != nil {
+			s.logger.Warnf("External authentication failed for user %s: %v", user.ID, err)
+			return true, ErrInvalidCredentials
+		}
+		return true, nil
 	}
+	return false, nil
+}
+
+// rehashPassword upgrades a hash produced with an outdated algorithm or
+// parameters. Failures are logged and don't affect the login.
+func (s *UserService) rehashPassword(user *User, plaintext string) {
+	hashedPassword, err := s.hashes.Hash(plaintext)
+	if err != nil {
+		s.logger.Warnf("Failed to rehash password for user %s: %v", user.ID, err)
+		return
+	}
+
+	result := s.db.Model(&User{}).
+		Where("id = ? AND password = ?", user.ID, user.Password).
+		Update("password", hashedPassword)
+	if result.Error != nil {
+		s.logger.Warnf("Failed to store rehashed password for user %s: %v", user.ID, result.Error)
+		return
+	}
+
+	user.Password = hashedPassword
+	s.logger.Infof("Upgraded password hash for user %s", user.ID)
+}
+
+// ImportUsers creates users with password hashes exported from another
+// system. Each user is imported independently and gets its own result.
+func (s *UserService) ImportUsers(reqs []UserImportRequest) []UserImportResult {
+	results := make([]UserImportResult, 0, len(reqs))
+	for _, req := range reqs {
+		result := UserImportResult{Email: req.Email}
+		user, err := s.importUser(req)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.ID = user.ID.String()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (s *UserService) importUser(req UserImportRequest) (*User, error) {
+	var existingUser User
+	if err := s.db.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+		return nil, errors.New("user with this email already exists")
+	}
+
+	hashedPassword, err := s.hashes.Import(req.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	passwordChangedAt := now
+	if req.PasswordChangedAt != nil {
+		passwordChangedAt = *req.PasswordChangedAt
+	}
+	status := StatusActive
+	if req.IsActive != nil && !*req.IsActive {
+		status = StatusSuspended
+	}
+
+	user := User{
+		ID:                uuid.New(),
+		Name:              req.Name,
+		FirstName:         req.FirstName,
+		LastName:          req.LastName,
+		Email:             req.Email,
+		Password:          hashedPassword,
+		PasswordChangedAt: &passwordChangedAt,
+		IsActive:          status == StatusActive,
+		Status:            status,
+		StatusReason:      "Imported",
+		StatusChangedAt:   &now,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to import user: %w", err)
+		}
+		return s.passwordPolicy.RecordHistory(tx, user.ID, user.Password)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events.Publish(events.Event{
+		Type:    "user.created",
+		Subject: user.ID.String(),
+		Data:    map[string]interface{}{"status": string(user.Status), "imported": true},
+	})
+
 	return &user, nil
 }
 
@@ -216,8 +567,8 @@ func (s *UserService) GetUserByEmail(email string) (*User, error) {
 		}
 		return nil, result.Error
 	}
-	if !user.IsActive {
-		return nil, errors.New("user account is deactivated")
+	if err := user.StatusError(); err != nil {
+		return nil, err
 	}
 	return &user, nil
 }
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"idmapp-go/config"
 	"idmapp-go/database"
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/tenant"
 	"idmapp-go/middleware"
 	"idmapp-go/routes"
 	"idmapp-go/services"
@@ -25,6 +32,16 @@ func main() {
 	// Initialize Fluentd logger
 	services.InitFluentLogger()
 
+	// Forward domain events to the log pipeline
+	events.Subscribe("*", func(event events.Event) {
+		services.GetFluentLogger().Info("Event: "+event.Type, map[string]interface{}{
+			"event_id": event.ID.String(),
+			"subject":  event.Subject,
+			"actor":    event.Actor,
+			"data":     event.Data,
+		})
+	})
+
 	// Set log level
 	level, err := logrus.ParseLevel(cfg.Server.LogLevel)
 	if err != nil {
@@ -37,6 +54,11 @@ func main() {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Maintenance commands run against the database instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "authz-resync" {
+		os.Exit(runAuthzResync(cfg, os.Args[2:]))
+	}
+
 	// Set Gin mode
 	if cfg.Server.LogLevel == "debug" {
 		gin.SetMode(gin.DebugMode)
@@ -69,7 +91,7 @@ func main() {
 	})
 
 	// Setup routes
-	routes.SetupRoutes(router)
+	routes.SetupRoutes(router, cfg)
 
 	// Start server
 	addr := fmt.Sprintf(":%d", cfg.Server.Port)
@@ -83,7 +105,57 @@ func main() {
 		"mode":      gin.Mode(),
 	})
 
-	if err := router.Run(addr); err != nil {
+	// "/t/<slug>/..." URLs are served by the routes of the tenant they name
+	if err := http.ListenAndServe(addr, tenant.StripPathPrefix(router)); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// runAuthzResync implements the authz-resync command. It prints how the
+// OpenFGA store differs from the membership tables, and with -apply rebuilds
+// the store from them. The exit code is 0 when the store is (now) in sync, 1
+// on errors and 2 when drift was found but not applied.
+func runAuthzResync(cfg *config.Config, args []string) int {
+	flags := flag.NewFlagSet("authz-resync", flag.ContinueOnError)
+	apply := flags.Bool("apply", false, "write missing tuples and delete stale ones")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if !cfg.OpenFGA.Enabled {
+		fmt.Fprintln(os.Stderr, "OpenFGA is not configured; set AUTHZ_ENGINE=openfga or OPENFGA_STORE_ID")
+		return 1
+	}
+	// The store holds the relations of every tenant
+	ctx := tenant.Bypass(context.Background())
+	db := database.GetDB().WithContext(ctx)
+	authorizationService, _, err := services.ConnectAuthorization(ctx, db, cfg.OpenFGA, cfg.Authorization.AdminRole)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	syncService := authz.NewSyncService(db, authorizationService)
+
+	var report *authz.DriftReport
+	if *apply {
+		report, err = syncService.Resync(ctx)
+	} else {
+		report, err = syncService.Drift(ctx)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+
+	switch {
+	case len(report.Errors) > 0:
+		return 1
+	case !report.Applied && !report.InSync():
+		return 2
+	}
+	return 0
+}
@@ -5,21 +5,56 @@ import (
 	"net/http"
 	"strings"
 
+	"idmapp-go/dto"
+	"idmapp-go/internal/tenant"
+
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 )
 
 type Claims struct {
-	Sub   string `json:"sub"`
-	Email string `json:"email"`
+	Sub       string     `json:"sub"`
+	Email     string     `json:"email"`
+	SessionID string     `json:"sid,omitempty"`
+	Scope     string     `json:"scope,omitempty"`
+	Act       *dto.Actor `json:"act,omitempty"`
+	ClientID  string     `json:"client_id,omitempty"`
+	// TenantID is the tenant the token was issued in; tokens without one
+	// belong to the default tenant
+	TenantID string `json:"tid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-// Local JWT signing key (same as in PKCE service)
-var jwtSigningKey = []byte("your-256-bit-secret") // Replace with a secure key in production
+// Authentication methods recorded in the request context
+const (
+	AuthMethodJWT = "jwt"
+	AuthMethodPAT = "pat"
+)
+
+// Scopes understood by AuthMiddleware. Tokens that carry a scope may only use
+// safe methods with "read" and need "write" for everything else; tokens
+// without a scope are unrestricted.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// TokenValidator checks that a signature-valid token may still be used, e.g.
+// that the user is active and the token or its session has not been revoked
+type TokenValidator interface {
+	ValidateToken(claims *Claims) error
+}
+
+// TokenAuthenticator authenticates opaque bearer tokens such as personal
+// access tokens. It returns nil claims for tokens it does not recognise so
+// that they are treated as JWTs.
+type TokenAuthenticator interface {
+	AuthenticateToken(token string) (*Claims, error)
+}
 
-func AuthMiddleware() gin.HandlerFunc {
+func AuthMiddleware(authenticator TokenAuthenticator, validators ...TokenValidator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -35,12 +70,18 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// For development/testing, accept a simple test token
-		if tokenString == "test-token" {
-			c.Set("user_id", "test-user-id")
-			c.Set("email", "test@example.com")
-			c.Next()
-			return
+		if authenticator != nil {
+			claims, err := authenticator.AuthenticateToken(tokenString)
+			if err != nil {
+				logrus.Warnf("Failed to authenticate token: %v", err)
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+				c.Abort()
+				return
+			}
+			if claims != nil {
+				authorize(c, claims, AuthMethodPAT, validators)
+				return
+			}
 		}
 
 		// Parse token without validation first to get the algorithm
@@ -68,14 +109,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		}
 
 		// Validate the token with local signing key
-		validatedToken, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			// Validate the signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return jwtSigningKey, nil
-		})
-
+		claims, err := parseJWT(tokenString)
 		if err != nil {
 			logrus.Errorf("Failed to validate token: %v", err)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -83,17 +117,180 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		if claims, ok := validatedToken.Claims.(*Claims); ok && validatedToken.Valid {
-			// Add user info to context
-			c.Set("user_id", claims.Sub)
-			c.Set("email", claims.Email)
-			c.Next()
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-			c.Abort()
-			return
+		authorize(c, claims, AuthMethodJWT, validators)
+	}
+}
+
+// VerifyToken authenticates a token that is not presented as the request's
+// bearer token, such as the subject token of a token exchange. It returns the
+// claims and the authentication method.
+func VerifyToken(tokenString string, authenticator TokenAuthenticator, validators ...TokenValidator) (*Claims, string, error) {
+	method := AuthMethodJWT
+	var claims *Claims
+	if authenticator != nil {
+		authenticated, err := authenticator.AuthenticateToken(tokenString)
+		if err != nil {
+			return nil, "", err
+		}
+		if authenticated != nil {
+			claims, method = authenticated, AuthMethodPAT
+		}
+	}
+
+	if claims == nil {
+		parsed, err := parseJWT(tokenString)
+		if err != nil {
+			return nil, "", err
+		}
+		claims = parsed
+	}
+
+	if err := validateClaims(claims, validators); err != nil {
+		return nil, "", err
+	}
+	return claims, method, nil
+}
+
+// parseJWT validates a token signed with the HS256 key of its tenant
+func parseJWT(tokenString string) (*Claims, error) {
+	validatedToken, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		// Validate the signing method
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		tenantID, err := claimedTenant(token.Claims.(*Claims))
+		if err != nil {
+			return nil, err
+		}
+		return tenant.SigningKey(tenantID)
+	}, jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := validatedToken.Claims.(*Claims)
+	if !ok || !validatedToken.Valid {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+	return claims, nil
+}
+
+// claimedTenant returns the tenant of the token
+func claimedTenant(claims *Claims) (uuid.UUID, error) {
+	if claims.TenantID == "" {
+		return tenant.DefaultID, nil
+	}
+	tenantID, err := uuid.Parse(claims.TenantID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid tenant claim: %w", err)
+	}
+	return tenantID, nil
+}
+
+func validateClaims(claims *Claims, validators []TokenValidator) error {
+	for _, validator := range validators {
+		if err := validator.ValidateToken(claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorize runs the validators and scope check for authenticated claims and
+// adds the user info to the context
+func authorize(c *gin.Context, claims *Claims, method string, validators []TokenValidator) {
+	if err := validateClaims(claims, validators); err != nil {
+		logrus.Warnf("Rejected token for subject %s: %v", claims.Sub, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is no longer valid"})
+		c.Abort()
+		return
+	}
+
+	if !audienceAllows(claims.Audience) {
+		logrus.Warnf("Rejected token for subject %s: audience %v", claims.Sub, claims.Audience)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is not meant for this service"})
+		c.Abort()
+		return
+	}
+
+	if err := BindTenant(c, claims); err != nil {
+		logrus.Warnf("Rejected token for subject %s: %v", claims.Sub, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is not valid for this tenant"})
+		c.Abort()
+		return
+	}
+
+	if !scopeAllows(claims.Scope, c.Request.Method) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Token scope does not allow this request"})
+		c.Abort()
+		return
+	}
+
+	// Add user info to context
+	c.Set("user_id", claims.Sub)
+	c.Set("email", claims.Email)
+	c.Set("session_id", claims.SessionID)
+	c.Set("auth_method", method)
+	c.Set("client_id", claims.ClientID)
+	if claims.Act != nil {
+		c.Set("actor", claims.Act)
+	}
+	c.Next()
+}
+
+// BindTenant serves the request in the tenant of the token. Tokens only work
+// in the tenant they were issued in, so it fails if the request named
+// another one.
+func BindTenant(c *gin.Context, claims *Claims) error {
+	tenantID, err := claimedTenant(claims)
+	if err != nil {
+		return err
+	}
+	ctx := c.Request.Context()
+	if tenant.Explicit(ctx) {
+		if current, _ := tenant.FromContext(ctx); current != tenantID {
+			return fmt.Errorf("token belongs to tenant %s", tenantID)
+		}
+		return nil
+	}
+	c.Request = c.Request.WithContext(tenant.NewContext(ctx, tenantID, tenant.SourceToken))
+	return nil
+}
+
+// audienceAllows reports whether a token with the audience may call the API.
+// Tokens without an audience are for IDMApp; tokens with one must name it,
+// so tokens exchanged for other services can't be used here.
+func audienceAllows(audience jwt.ClaimStrings) bool {
+	if len(audience) == 0 {
+		return true
+	}
+	for _, aud := range audience {
+		if aud == dto.APIAudience {
+			return true
 		}
 	}
+	return false
+}
+
+func scopeAllows(scope string, method string) bool {
+	if scope == "" {
+		return true
+	}
+	scopes := strings.Fields(scope)
+	for _, s := range scopes {
+		if s == ScopeWrite {
+			return true
+		}
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		for _, s := range scopes {
+			if s == ScopeRead {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func GetUserID(c *gin.Context) string {
@@ -109,3 +306,43 @@ func GetUserEmail(c *gin.Context) string {
 	}
 	return ""
 }
+
+func GetSessionID(c *gin.Context) string {
+	if sessionID, exists := c.Get("session_id"); exists {
+		return sessionID.(string)
+	}
+	return ""
+}
+
+func GetAuthMethod(c *gin.Context) string {
+	if method, exists := c.Get("auth_method"); exists {
+		return method.(string)
+	}
+	return ""
+}
+
+// GetClientID returns the client when the token was issued to a client
+// acting for itself, or ""
+func GetClientID(c *gin.Context) string {
+	if clientID, exists := c.Get("client_id"); exists {
+		return clientID.(string)
+	}
+	return ""
+}
+
+// GetActor returns the RFC 8693 actor when the request is made on behalf of
+// the user by someone else, e.g. an admin impersonating them
+func GetActor(c *gin.Context) *dto.Actor {
+	if actor, exists := c.Get("actor"); exists {
+		return actor.(*dto.Actor)
+	}
+	return nil
+}
+
+// GetActorID returns the ID of the party currently acting for the user, or ""
+func GetActorID(c *gin.Context) string {
+	if actor := GetActor(c); actor != nil {
+		return actor.Sub
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"idmapp-go/dto"
+	"idmapp-go/internal/tenant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAuthenticator map[string]*Claims
+
+func (f fakeAuthenticator) AuthenticateToken(token string) (*Claims, error) {
+	return f[token], nil
+}
+
+func newAuthRouter(authenticator TokenAuthenticator) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthMiddleware(authenticator))
+	handler := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"userId": GetUserID(c), "method": GetAuthMethod(c)})
+	}
+	router.GET("/resource", handler)
+	router.POST("/resource", handler)
+	return router
+}
+
+func doRequest(router *gin.Engine, method, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/resource", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuthMiddlewareRejectsTestToken(t *testing.T) {
+	router := newAuthRouter(fakeAuthenticator{})
+	assert.Equal(t, http.StatusUnauthorized, doRequest(router, http.MethodGet, "test-token").Code)
+}
+
+func TestAuthMiddlewareAcceptsAuthenticatedTokens(t *testing.T) {
+	router := newAuthRouter(fakeAuthenticator{
+		"idmpat_read":  {Sub: "user-1", Scope: ScopeRead},
+		"idmpat_write": {Sub: "user-2", Scope: ScopeWrite},
+	})
+
+	w := doRequest(router, http.MethodGet, "idmpat_read")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"userId":"user-1","method":"pat"}`, w.Body.String())
+
+	assert.Equal(t, http.StatusForbidden, doRequest(router, http.MethodPost, "idmpat_read").Code)
+	assert.Equal(t, http.StatusOK, doRequest(router, http.MethodPost, "idmpat_write").Code)
+}
+
+func TestAuthMiddlewareExposesActor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthMiddleware(nil))
+	router.GET("/resource", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"userId": GetUserID(c), "actorId": GetActorID(c)})
+	})
+
+	token, err := tenant.SignToken(jwt.MapClaims{
+		"sub": "user-1",
+		"act": map[string]interface{}{"sub": "admin-1"},
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}, tenant.DefaultID)
+	assert.NoError(t, err)
+
+	w := doRequest(router, http.MethodGet, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"userId":"user-1","actorId":"admin-1"}`, w.Body.String())
+}
+
+func TestAuthMiddlewareBindsTokenTenant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	acme := uuid.New()
+	router := gin.New()
+	// Requests with X-Tenant-Path named the default tenant in their path
+	router.Use(func(c *gin.Context) {
+		if c.GetHeader("X-Tenant-Path") != "" {
+			c.Request = c.Request.WithContext(tenant.NewContext(c.Request.Context(), tenant.DefaultID, tenant.SourcePath))
+		}
+	})
+	router.Use(AuthMiddleware(nil))
+	router.GET("/resource", func(c *gin.Context) {
+		id, _ := tenant.FromContext(c)
+		c.JSON(http.StatusOK, gin.H{"tenant": id.String()})
+	})
+
+	token, err := tenant.SignToken(jwt.MapClaims{
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}, acme)
+	assert.NoError(t, err)
+
+	// Requests that don't name a tenant run in the token's
+	w := doRequest(router, http.MethodGet, token)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"tenant":"`+acme.String()+`"}`, w.Body.String())
+
+	// ...and tokens of other tenants are refused
+	req := httptest.NewRequest(http.MethodGet, "/resource", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Tenant-Path", "default")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAuthMiddlewareChecksAudience(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthMiddleware(nil))
+	router.GET("/resource", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	sign := func(audience ...string) string {
+		claims := jwt.MapClaims{
+			"sub": "user-1",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}
+		if len(audience) > 0 {
+			claims["aud"] = audience
+		}
+		token, err := tenant.SignToken(claims, tenant.DefaultID)
+		assert.NoError(t, err)
+		return token
+	}
+
+	assert.Equal(t, http.StatusOK, doRequest(router, http.MethodGet, sign()).Code)
+	assert.Equal(t, http.StatusOK, doRequest(router, http.MethodGet, sign("web-app", dto.APIAudience)).Code)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(router, http.MethodGet, sign("orders-service")).Code)
+}
@@ -0,0 +1,253 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"idmapp-go/internal/authz"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// SystemObject is the authorization object of the service itself. Relations
+// on it cover collections and settings that belong to no single resource.
+const SystemObject = authz.SystemObject
+
+// Relations checked by route guards
+const (
+	RelationRead   = "read"
+	RelationManage = "manage"
+)
+
+// Authorizer decides whether user (e.g. "user:<id>") has relation to object
+// (e.g. "group:<id>"). An error means no decision could be made.
+type Authorizer interface {
+	Check(ctx context.Context, user, relation, object string) (bool, error)
+}
+
+// TenantGuard tells whether an object (e.g. type "group" and its ID) belongs
+// to the tenant of ctx. Objects of other tenants are reported as missing.
+type TenantGuard interface {
+	Owns(ctx context.Context, objectType, id string) (bool, error)
+}
+
+// maxPermissionBody caps the request body read to resolve body placeholders
+const maxPermissionBody = 1 << 20
+
+var (
+	placeholder = regexp.MustCompile(`\{(\w+)\}`)
+	// objectIDPattern keeps resolved IDs from smuggling type or relation
+	// separators into the object
+	objectIDPattern = regexp.MustCompile(`^[A-Za-z0-9._@|-]+$`)
+)
+
+// Permissions guards routes with relationship checks. Checks fail closed:
+// when the authorizer can't decide, the request is rejected.
+type Permissions struct {
+	authorizer Authorizer
+	tenants    TenantGuard
+	timeout    time.Duration
+	logger     *logrus.Logger
+}
+
+// NewPermissions creates route guards backed by authorizer. A nil
+// authorizer disables the checks.
+func NewPermissions(authorizer Authorizer, timeout time.Duration) *Permissions {
+	return &Permissions{
+		authorizer: authorizer,
+		timeout:    timeout,
+		logger:     logrus.New(),
+	}
+}
+
+// SetTenantGuard makes route guards reject objects of other tenants, whether
+// or not an authorizer is set
+func (p *Permissions) SetTenantGuard(guard TenantGuard) {
+	p.tenants = guard
+}
+
+// Require lets the request through if the authenticated user has relation
+// to object. Placeholders in object such as "group:{id}" are filled from the
+// path parameter of that name, or else from the top-level field of the JSON
+// body, e.g. "group:{groupId}".
+func (p *Permissions) Require(relation, object string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if p.authorizer == nil && p.tenants == nil {
+			c.Next()
+			return
+		}
+
+		userID := GetUserID(c)
+		if userID == "" && p.authorizer != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			c.Abort()
+			return
+		}
+
+		resolved, invalid := resolveObject(c, object)
+		if invalid != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or missing " + invalid})
+			c.Abort()
+			return
+		}
+
+		if p.tenants != nil {
+			objectType, objectID, _ := strings.Cut(resolved, ":")
+			owned, err := p.tenants.Owns(c.Request.Context(), objectType, objectID)
+			if err != nil {
+				p.logger.Errorf("Tenant check of %s failed: %v", resolved, err)
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authorization service unavailable"})
+				c.Abort()
+				return
+			}
+			if !owned {
+				c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+				c.Abort()
+				return
+			}
+		}
+		if p.authorizer == nil {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		if p.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, p.timeout)
+			defer cancel()
+		}
+		allowed, err := p.authorizer.Check(ctx, "user:"+userID, relation, resolved)
+		if err != nil {
+			p.logger.Errorf("Permission check %s %s %s failed: %v", userID, relation, resolved, err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authorization service unavailable"})
+			c.Abort()
+			return
+		}
+		if !allowed {
+			p.logger.Warnf("Denied %s %s: user %s lacks %s on %s", c.Request.Method, c.FullPath(), userID, relation, resolved)
+			c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to " + relation + " " + resolved})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// resolveObject fills the placeholders of object. It returns the name of
+// the first placeholder without a valid value, if any.
+func resolveObject(c *gin.Context, object string) (string, string) {
+	var body map[string]interface{}
+	bodyRead := false
+	invalid := ""
+	resolved := placeholder.ReplaceAllStringFunc(object, func(match string) string {
+		name := match[1 : len(match)-1]
+		value := c.Param(name)
+		if value == "" {
+			if !bodyRead {
+				body = peekJSONBody(c)
+				bodyRead = true
+			}
+			value, _ = body[name].(string)
+		}
+		if invalid == "" && !objectIDPattern.MatchString(value) {
+			invalid = name
+		}
+		return value
+	})
+	return resolved, invalid
+}
+
+// peekJSONBody decodes the JSON request body, if any, and puts it back for
+// the handler
+func peekJSONBody(c *gin.Context) map[string]interface{} {
+	if c.Request.Body == nil {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPermissionBody))
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	var body map[string]interface{}
+	_ = json.Unmarshal(data, &body)
+	return body
+}
+
+// DecisionCache remembers the decisions of an Authorizer for a TTL. Failed
+// checks are not cached, so an unreachable authorizer keeps failing closed.
+type DecisionCache struct {
+	authorizer Authorizer
+	ttl        time.Duration
+	maxEntries int
+	mu         sync.Mutex
+	entries    map[string]cachedDecision
+}
+
+type cachedDecision struct {
+	allowed   bool
+	expiresAt time.Time
+}
+
+// defaultMaxDecisions bounds the memory of a DecisionCache
+const defaultMaxDecisions = 10000
+
+func NewDecisionCache(authorizer Authorizer, ttl time.Duration) *DecisionCache {
+	return &DecisionCache{
+		authorizer: authorizer,
+		ttl:        ttl,
+		maxEntries: defaultMaxDecisions,
+		entries:    make(map[string]cachedDecision),
+	}
+}
+
+func (d *DecisionCache) Check(ctx context.Context, user, relation, object string) (bool, error) {
+	key := strings.Join([]string{user, relation, object}, "\x00")
+	now := time.Now()
+
+	d.mu.Lock()
+	entry, ok := d.entries[key]
+	d.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.allowed, nil
+	}
+
+	allowed, err := d.authorizer.Check(ctx, user, relation, object)
+	if err != nil {
+		return false, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.entries) >= d.maxEntries {
+		d.evictExpired(now)
+	}
+	if len(d.entries) >= d.maxEntries {
+		d.entries = make(map[string]cachedDecision)
+	}
+	d.entries[key] = cachedDecision{allowed: allowed, expiresAt: now.Add(d.ttl)}
+	return allowed, nil
+}
+
+// Invalidate forgets all decisions, e.g. after relationships changed
+func (d *DecisionCache) Invalidate() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries = make(map[string]cachedDecision)
+}
+
+func (d *DecisionCache) evictExpired(now time.Time) {
+	for key, entry := range d.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(d.entries, key)
+		}
+	}
+}
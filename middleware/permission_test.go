@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAuthorizer allows the "user relation object" triples it holds and
+// counts its checks
+type fakeAuthorizer struct {
+	allowed map[string]bool
+	err     error
+	checks  int
+}
+
+func (f *fakeAuthorizer) Check(ctx context.Context, user, relation, object string) (bool, error) {
+	f.checks++
+	if f.err != nil {
+		return false, f.err
+	}
+	return f.allowed[user+" "+relation+" "+object], nil
+}
+
+func newPermissionRouter(authorizer Authorizer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", c.GetHeader("X-User"))
+	})
+	permissions := NewPermissions(authorizer, time.Second)
+	router.GET("/groups/:id", permissions.Require(RelationManage, "group:{id}"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.POST("/members", permissions.Require(RelationManage, "group:{groupId}"), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.String(http.StatusOK, string(body))
+	})
+	return router
+}
+
+func serve(router *gin.Engine, method, path, user, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-User", user)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequirePermission(t *testing.T) {
+	authorizer := &fakeAuthorizer{allowed: map[string]bool{"user:u1 manage group:g1": true}}
+	router := newPermissionRouter(authorizer)
+
+	assert.Equal(t, http.StatusOK, serve(router, http.MethodGet, "/groups/g1", "u1", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(router, http.MethodGet, "/groups/g2", "u1", "").Code)
+	assert.Equal(t, http.StatusForbidden, serve(router, http.MethodGet, "/groups/g1", "u2", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(router, http.MethodGet, "/groups/g1", "", "").Code)
+	// IDs can't carry object separators
+	assert.Equal(t, http.StatusBadRequest, serve(router, http.MethodGet, "/groups/g1%23member", "u1", "").Code)
+}
+
+func TestRequirePermissionFromBody(t *testing.T) {
+	authorizer := &fakeAuthorizer{allowed: map[string]bool{"user:u1 manage group:g1": true}}
+	router := newPermissionRouter(authorizer)
+
+	body := `{"groupId": "g1", "userId": "u2"}`
+	w := serve(router, http.MethodPost, "/members", "u1", body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	// The handler still reads the whole body
+	assert.Equal(t, body, w.Body.String())
+
+	assert.Equal(t, http.StatusForbidden, serve(router, http.MethodPost, "/members", "u1", `{"groupId": "g2"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(router, http.MethodPost, "/members", "u1", `{"userId": "u2"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, serve(router, http.MethodPost, "/members", "u1", `not json`).Code)
+}
+
+func TestRequirePermissionFailsClosed(t *testing.T) {
+	authorizer := &fakeAuthorizer{err: errors.New("connection refused")}
+	router := newPermissionRouter(authorizer)
+
+	w := serve(router, http.MethodGet, "/groups/g1", "u1", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "Authorization service unavailable")
+}
+
+func TestRequirePermissionDisabled(t *testing.T) {
+	router := newPermissionRouter(nil)
+	assert.Equal(t, http.StatusOK, serve(router, http.MethodGet, "/groups/g1", "", "").Code)
+}
+
+// fakeGuard owns the "type:id" objects it holds
+type fakeGuard map[string]bool
+
+func (f fakeGuard) Owns(ctx context.Context, objectType, id string) (bool, error) {
+	return f[objectType+":"+id], nil
+}
+
+func TestRequirePermissionTenantGuard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authorizer := &fakeAuthorizer{allowed: map[string]bool{
+		"user:u1 manage group:g1": true,
+		"user:u1 manage group:g2": true,
+	}}
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set("user_id", c.GetHeader("X-User"))
+	})
+	permissions := NewPermissions(authorizer, time.Second)
+	permissions.SetTenantGuard(fakeGuard{"group:g1": true})
+	router.GET("/groups/:id", permissions.Require(RelationManage, "group:{id}"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	assert.Equal(t, http.StatusOK, serve(router, http.MethodGet, "/groups/g1", "u1", "").Code)
+	// Objects of other tenants are missing, whatever the relations
+	checks := authorizer.checks
+	assert.Equal(t, http.StatusNotFound, serve(router, http.MethodGet, "/groups/g2", "u1", "").Code)
+	assert.Equal(t, checks, authorizer.checks)
+}
+
+func TestDecisionCache(t *testing.T) {
+	authorizer := &fakeAuthorizer{allowed: map[string]bool{"user:u1 manage group:g1": true}}
+	cache := NewDecisionCache(authorizer, time.Hour)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		allowed, err := cache.Check(ctx, "user:u1", RelationManage, "group:g1")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		allowed, err = cache.Check(ctx, "user:u1", RelationManage, "group:g2")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+	}
+	assert.Equal(t, 2, authorizer.checks)
+
+	cache.Invalidate()
+	_, err := cache.Check(ctx, "user:u1", RelationManage, "group:g1")
+	require.NoError(t, err)
+	assert.Equal(t, 3, authorizer.checks)
+}
+
+func TestDecisionCacheExpiry(t *testing.T) {
+	authorizer := &fakeAuthorizer{allowed: map[string]bool{"user:u1 manage group:g1": true}}
+	cache := NewDecisionCache(authorizer, time.Millisecond)
+	ctx := context.Background()
+
+	_, err := cache.Check(ctx, "user:u1", RelationManage, "group:g1")
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	// Errors are passed on rather than answered from the expired entry
+	authorizer.err = errors.New("timeout")
+	allowed, err := cache.Check(ctx, "user:u1", RelationManage, "group:g1")
+	assert.Error(t, err)
+	assert.False(t, allowed)
+
+	authorizer.err = nil
+	cache.maxEntries = 1
+	_, err = cache.Check(ctx, "user:u1", RelationManage, "group:g1")
+	require.NoError(t, err)
+	_, err = cache.Check(ctx, "user:u1", RelationManage, "group:g2")
+	require.NoError(t, err)
+	assert.Len(t, cache.entries, 1)
+}
@@ -3,15 +3,19 @@ package models
 import (
 	"time"
 
+	"idmapp-go/internal/tenant"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type OrgMember struct {
+	tenant.Scoped
 	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	OrgID     uuid.UUID `json:"orgId" gorm:"type:uuid;not null;column:org_id"`
 	EntityID  uuid.UUID `json:"entityId" gorm:"type:uuid;not null;column:entity_id"`
 	Type      string    `json:"type" gorm:"type:varchar(32);not null"` // USER, GROUP, or ROLE
+	Cascade   bool      `json:"cascade" gorm:"not null;default:false"` // Also a member of every org below
 	CreatedAt time.Time `json:"createdAt"`
 }
 
@@ -2,16 +2,20 @@ package models
 
 import (
 	"time"
+	"idmapp-go/internal/tenant"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type RoleMember struct {
+	tenant.Scoped
 	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	RoleID    uuid.UUID `json:"roleId" gorm:"type:uuid;not null;column:role_id"`
 	EntityID  uuid.UUID `json:"entityId" gorm:"type:uuid;not null;column:entity_id"`
-	Type      string    `json:"type" gorm:"type:varchar(32);not null"` // USER or GROUP
+	Type      string    `json:"type" gorm:"type:varchar(32);not null"` // USER, GROUP or ORG
 	CreatedAt time.Time `json:"createdAt"`
+	Validity
 }
 
 func (m *RoleMember) BeforeCreate(tx *gorm.DB) error {
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidValidity is returned for windows that end before they start or
+// that have already ended
+var ErrInvalidValidity = errors.New("validUntil must be in the future and after validFrom")
+
+// Validity limits a group membership or role assignment to a window of
+// time. Grants without ValidFrom take effect when they are added, and those
+// without ValidUntil don't expire.
+type Validity struct {
+	ValidFrom  *time.Time `json:"validFrom,omitempty" gorm:"column:valid_from"`
+	ValidUntil *time.Time `json:"validUntil,omitempty" gorm:"column:valid_until;index"`
+	// ActivatedAt is when the grant took effect and its tuple was written;
+	// it is nil while the grant waits for ValidFrom
+	ActivatedAt *time.Time `json:"activatedAt,omitempty" gorm:"column:activated_at"`
+	// ExpiryNotifiedAt is when the users losing the grant were told it is
+	// about to expire
+	ExpiryNotifiedAt *time.Time `json:"expiryNotifiedAt,omitempty" gorm:"column:expiry_notified_at"`
+}
+
+// NewValidity checks the window of a grant added at now, which takes
+// effect right away unless validFrom is later
+func NewValidity(validFrom, validUntil *time.Time, now time.Time) (Validity, error) {
+	if validUntil != nil && (!validUntil.After(now) || (validFrom != nil && !validUntil.After(*validFrom))) {
+		return Validity{}, ErrInvalidValidity
+	}
+	validity := Validity{ValidFrom: validFrom, ValidUntil: validUntil}
+	if !validity.Scheduled(now) {
+		validity.ActivatedAt = &now
+	}
+	return validity, nil
+}
+
+// Scheduled reports whether the grant only takes effect after now
+func (v Validity) Scheduled(now time.Time) bool {
+	return v.ValidFrom != nil && v.ValidFrom.After(now)
+}
+
+// InEffect reports whether the window of the grant includes now
+func (v Validity) InEffect(now time.Time) bool {
+	return !v.Scheduled(now) && (v.ValidUntil == nil || v.ValidUntil.After(now))
+}
+
+// EventData adds the window, if any, to the data of a membership event
+func (v Validity) EventData(data map[string]interface{}) map[string]interface{} {
+	if v.ValidFrom != nil {
+		data["validFrom"] = v.ValidFrom.Format(time.RFC3339)
+	}
+	if v.ValidUntil != nil {
+		data["validUntil"] = v.ValidUntil.Format(time.RFC3339)
+	}
+	return data
+}
+
+// InEffectSQL is the condition of the rows of table, a membership table or
+// its alias, whose window includes the current time
+func InEffectSQL(table string) string {
+	return fmt.Sprintf("(%[1]s.valid_from IS NULL OR %[1]s.valid_from <= CURRENT_TIMESTAMP) AND (%[1]s.valid_until IS NULL OR %[1]s.valid_until > CURRENT_TIMESTAMP)", table)
+}
@@ -1,10 +1,16 @@
 package repository
 
 import (
+	"context"
+
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/sod"
+	"idmapp-go/internal/tenant"
 	"idmapp-go/models"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type OrgMemberRepository struct {
@@ -15,6 +21,14 @@ func NewOrgMemberRepository(db *gorm.DB) *OrgMemberRepository {
 	return &OrgMemberRepository{db: db}
 }
 
+// WithContext returns a copy of the repository whose statements run with
+// ctx, scoped to its tenant
+func (r *OrgMemberRepository) WithContext(ctx context.Context) *OrgMemberRepository {
+	scoped := *r
+	scoped.db = r.db.WithContext(ctx)
+	return &scoped
+}
+
 func (r *OrgMemberRepository) FindByID(id uuid.UUID) (*models.OrgMember, error) {
 	var orgMember models.OrgMember
 	if err := r.db.First(&orgMember, "id = ?", id).Error; err != nil {
@@ -31,6 +45,23 @@ func (r *OrgMemberRepository) FindByOrgID(orgID uuid.UUID) ([]models.OrgMember,
 	return members, nil
 }
 
+// FindInheritedByOrgID returns the cascading memberships of the orgs above
+// the org, which make their members members of the org too
+func (r *OrgMemberRepository) FindInheritedByOrgID(orgID uuid.UUID) ([]models.OrgMember, error) {
+	var members []models.OrgMember
+	err := r.db.Table("org_members m").
+		Select("m.*").
+		Joins("JOIN orgs a ON a.id = m.org_id").
+		Joins("JOIN orgs o ON o.id = ?", orgID).
+		Where(`m."cascade" AND a.id <> o.id AND o.path LIKE a.path || '%'`).
+		Order("LENGTH(a.path), m.created_at").
+		Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
 func (r *OrgMemberRepository) FindByEntityID(entityID uuid.UUID) ([]models.OrgMember, error) {
 	var members []models.OrgMember
 	if err := r.db.Where("entity_id = ?", entityID).Find(&members).Error; err != nil {
@@ -47,11 +78,47 @@ func (r *OrgMemberRepository) FindAll() ([]models.OrgMember, error) {
 	return members, nil
 }
 
+// Save creates the membership and queues its tuple for the authorization
+// store in the same transaction
 func (r *OrgMemberRepository) Save(orgMember *models.OrgMember) error {
-	return r.db.Create(orgMember).Error
+	tuple, err := authz.OrgMemberTuple(*orgMember)
+	if err != nil {
+		return err
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tenant.CheckOwns(tx, "org", orgMember.OrgID); err != nil {
+			return err
+		}
+		if err := tenant.CheckOwns(tx, orgMember.Type, orgMember.EntityID); err != nil {
+			return err
+		}
+		guard, err := sod.Begin(tx)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(orgMember).Error; err != nil {
+			return err
+		}
+		if err := guard.Check(tx); err != nil {
+			return err
+		}
+		return authz.Record(tx, authz.OpWrite, tuple)
+	})
 }
 
+// DeleteByOrgIDAndEntityID deletes the memberships and queues the removal of
+// their tuples in the same transaction
 func (r *OrgMemberRepository) DeleteByOrgIDAndEntityID(orgID, entityID uuid.UUID) (int64, error) {
-	result := r.db.Where("org_id = ? AND entity_id = ?", orgID, entityID).Delete(&models.OrgMember{})
-	return result.RowsAffected, result.Error
+	var removed []models.OrgMember
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Returning{}).Where("org_id = ? AND entity_id = ?", orgID, entityID).Delete(&removed)
+		if result.Error != nil {
+			return result.Error
+		}
+		return authz.Record(tx, authz.OpDelete, authz.OrgMemberTuples(removed)...)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(removed)), nil
 }
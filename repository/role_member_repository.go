@@ -1,10 +1,16 @@
 package repository
 
 import (
+	"context"
+
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/sod"
+	"idmapp-go/internal/tenant"
 	"idmapp-go/models"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type RoleMemberRepository struct {
@@ -15,6 +21,14 @@ func NewRoleMemberRepository(db *gorm.DB) *RoleMemberRepository {
 	return &RoleMemberRepository{db: db}
 }
 
+// WithContext returns a copy of the repository whose statements run with
+// ctx, scoped to its tenant
+func (r *RoleMemberRepository) WithContext(ctx context.Context) *RoleMemberRepository {
+	scoped := *r
+	scoped.db = r.db.WithContext(ctx)
+	return &scoped
+}
+
 func (r *RoleMemberRepository) FindByID(id uuid.UUID) (*models.RoleMember, error) {
 	var roleMember models.RoleMember
 	if err := r.db.First(&roleMember, "id = ?", id).Error; err != nil {
@@ -47,11 +61,52 @@ func (r *RoleMemberRepository) FindAll() ([]models.RoleMember, error) {
 	return members, nil
 }
 
+// Save creates the membership and queues its tuple for the authorization
+// store in the same transaction. It returns a *sod.ViolationError if the
+// assignment breaks a separation-of-duties policy.
 func (r *RoleMemberRepository) Save(roleMember *models.RoleMember) error {
-	return r.db.Create(roleMember).Error
+	tuple, err := authz.RoleMemberTuple(*roleMember)
+	if err != nil {
+		return err
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tenant.CheckOwns(tx, "role", roleMember.RoleID); err != nil {
+			return err
+		}
+		if err := tenant.CheckOwns(tx, roleMember.Type, roleMember.EntityID); err != nil {
+			return err
+		}
+		guard, err := sod.Begin(tx)
+		if err != nil {
+			return err
+		}
+		if err := tx.Create(roleMember).Error; err != nil {
+			return err
+		}
+		if err := guard.Check(tx); err != nil {
+			return err
+		}
+		// Scheduled assignments get their tuple when they take effect
+		if roleMember.ActivatedAt == nil {
+			return nil
+		}
+		return authz.Record(tx, authz.OpWrite, tuple)
+	})
 }
 
+// DeleteByRoleIDAndEntityID deletes the memberships and queues the removal of
+// their tuples in the same transaction
 func (r *RoleMemberRepository) DeleteByRoleIDAndEntityID(roleID, entityID uuid.UUID) (int64, error) {
-	result := r.db.Where("role_id = ? AND entity_id = ?", roleID, entityID).Delete(&models.RoleMember{})
-	return result.RowsAffected, result.Error
+	var removed []models.RoleMember
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Returning{}).Where("role_id = ? AND entity_id = ?", roleID, entityID).Delete(&removed)
+		if result.Error != nil {
+			return result.Error
+		}
+		return authz.Record(tx, authz.OpDelete, authz.RoleMemberTuples(removed)...)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(removed)), nil
 }
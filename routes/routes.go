@@ -1,32 +1,226 @@
 package routes
 
 import (
+	"context"
+
+	"idmapp-go/config"
 	"idmapp-go/controllers"
 	"idmapp-go/database"
+	"idmapp-go/internal/access"
+	"idmapp-go/internal/accessrequest"
+	"idmapp-go/internal/accesstoken"
+	"idmapp-go/internal/assignment"
+	"idmapp-go/internal/authz"
+	"idmapp-go/internal/certification"
+	"idmapp-go/internal/directory"
+	"idmapp-go/internal/events"
+	"idmapp-go/internal/federation"
 	"idmapp-go/internal/group"
+	"idmapp-go/internal/impersonation"
+	"idmapp-go/internal/keyring"
 	"idmapp-go/internal/member"
+	"idmapp-go/internal/notification"
 	"idmapp-go/internal/org"
+	"idmapp-go/internal/password"
+	"idmapp-go/internal/permission"
+	"idmapp-go/internal/provisioning"
 	"idmapp-go/internal/role"
+	"idmapp-go/internal/samlidp"
+	"idmapp-go/internal/scim"
+	"idmapp-go/internal/session"
+	"idmapp-go/internal/sod"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/internal/tokenexchange"
 	"idmapp-go/internal/user"
 	"idmapp-go/middleware"
 	"idmapp-go/repository"
 	"idmapp-go/services"
 
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
-func SetupRoutes(router *gin.Engine) {
+func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 	// Serve static files
 	router.Static("/static", "./templates")
 	router.StaticFile("/test.html", "./test.html")
 
+	// Background jobs, event handlers and the lookups that find the tenant
+	// of a request see the rows of every tenant
+	jobsCtx := tenant.Bypass(context.Background())
+	jobsDB := database.GetDB().WithContext(jobsCtx)
+
+	// Bind every request to its tenant; tokens are signed with the key of
+	// theirs
+	tenantService := tenant.NewTenantService(jobsDB)
+	if err := tenantService.EnsureDefault(); err != nil {
+		logrus.Fatalf("Failed to initialize tenants: %v", err)
+	}
+	tenant.SetSigningKeys(tenantService)
+	router.Use(tenant.Resolve(tenantService))
+
+	// Federation, the SAML identity provider, directory sync and outbound
+	// provisioning serve the default tenant only
+	defaultCtx := tenant.NewContext(context.Background(), tenant.DefaultID, tenant.SourceDefault)
+	defaultDB := database.GetDB().WithContext(defaultCtx)
+	requireDefault := tenant.RequireDefault()
+
+	// Initialize password policy
+	breachedList, err := password.LoadBreachedList(cfg.Password.BreachedListPath)
+	if err != nil {
+		logrus.Fatalf("Failed to load breached password list: %v", err)
+	}
+	passwordHashes, err := password.NewHashManager(cfg.Hashing)
+	if err != nil {
+		logrus.Fatalf("Failed to initialize password hashing: %v", err)
+	}
+	passwordPolicy := password.NewPolicyService(database.GetDB(), password.PolicyFromConfig(cfg.Password), breachedList, passwordHashes)
+
 	// Initialize services
-	userService := user.NewUserService(database.GetDB())
+	userService := user.NewUserService(database.GetDB(), passwordPolicy, passwordHashes)
 	groupService := group.NewGroupService(database.GetDB())
 	roleService := role.NewRoleService(database.GetDB())
 	orgService := org.NewOrgService(database.GetDB())
+	if err := orgService.WithContext(jobsCtx).EnsurePaths(); err != nil {
+		logrus.Fatalf("Failed to initialize organizations: %v", err)
+	}
 	memberService := member.NewMemberService(database.GetDB())
 	pkceService := services.NewPKCEService(database.GetDB())
+	sessionService := session.NewSessionService(database.GetDB(), cfg.Session.TTL, cfg.Session.RefreshTokenTTL)
+	tokenService := accesstoken.NewTokenService(database.GetDB())
+	tokenExchangeService := tokenexchange.NewTokenExchangeService(database.GetDB(), pkceService, cfg.TokenExchange.TTL, tokenService.WithContext(jobsCtx), userService.WithContext(jobsCtx), sessionService.WithContext(jobsCtx))
+	permissionService := permission.NewPermissionService(database.GetDB())
+	if err := permissionService.WithContext(jobsCtx).EnsureBuiltins(); err != nil {
+		logrus.Fatalf("Failed to initialize permissions: %v", err)
+	}
+	if cfg.Authorization.PermissionsClaim {
+		pkceService.AddClaimsProvider(permissionService)
+	}
+	impersonationService := impersonation.NewImpersonationService(database.GetDB(), sessionService, pkceService, cfg.Impersonation.AdminRole, cfg.Impersonation.TTL)
+
+	// Initialize federated login providers
+	providers, err := federation.LoadProviders(cfg.Federation.ProvidersFile)
+	if err != nil {
+		logrus.Fatalf("Failed to load federation providers: %v", err)
+	}
+	federationService := federation.NewFederationService(defaultDB, userService.WithContext(defaultCtx), providers, cfg.Federation.BaseURL)
+
+	// Initialize the SAML identity provider
+	keys, err := keyring.Load(cfg.KeyRing.Dir)
+	if err != nil {
+		logrus.Fatalf("Failed to load signing keys: %v", err)
+	}
+	samlService := samlidp.NewSAMLService(defaultDB, sessionService, keys, cfg.SAML.BaseURL)
+
+	// Initialize LDAP sign-in and directory sync
+	directoryConfig, err := directory.LoadConfig(cfg.Directory.ConfigFile)
+	if err != nil {
+		logrus.Fatalf("Failed to load directory config: %v", err)
+	}
+	directoryService := directory.NewDirectoryService(defaultDB, directoryConfig, userService.WithContext(defaultCtx), groupService.WithContext(defaultCtx), memberService.WithContext(defaultCtx))
+	userService.AddAuthenticator(directoryService)
+
+	// Initialize SCIM provisioning
+	scimService := scim.NewSCIMService(database.GetDB(), userService, groupService, memberService, cfg.SCIM.BaseURL)
+
+	// Initialize outbound provisioning to downstream SCIM targets
+	provisioningTargets, err := provisioning.LoadTargets(cfg.Provisioning.TargetsFile)
+	if err != nil {
+		logrus.Fatalf("Failed to load provisioning targets: %v", err)
+	}
+	provisioningService := provisioning.NewProvisioningService(defaultDB, provisioningTargets)
+
+	// Sessions end as soon as their user can no longer sign in
+	jobSessions := sessionService.WithContext(jobsCtx)
+	events.Subscribe("user.suspended", jobSessions.HandleUserDisabled)
+	events.Subscribe("user.locked", jobSessions.HandleUserDisabled)
+	events.Subscribe("user.deprovisioned", jobSessions.HandleUserDisabled)
+	events.Subscribe("user.deprovisioned", federationService.HandleUserDeprovisioned)
+	events.Subscribe("role.deleted", permissionService.WithContext(jobsCtx).HandleRoleDeleted)
+	events.Subscribe("group.deleted", memberService.WithContext(jobsCtx).HandleGroupDeleted)
+
+	// Resolved effective access is dropped whenever memberships change
+	accessResolver := access.NewResolver(database.GetDB(), cfg.Authorization.AccessCacheTTL)
+	for _, eventType := range []string{
+		"member.*", "org_member.*", "role_member.*",
+		"user.deprovisioned", "user.deleted", "group.deleted", "role.deleted", "org.deleted",
+		"org.moved", "directory.synced",
+	} {
+		events.Subscribe(eventType, accessResolver.HandleMembershipEvent)
+	}
+
+	// Changed users, groups and memberships are pushed to provisioning targets
+	if provisioningService.Enabled() {
+		events.Subscribe("user.*", provisioningService.HandleUserEvent)
+		events.Subscribe("group.*", provisioningService.HandleGroupEvent)
+		events.Subscribe("member.*", provisioningService.HandleGroupEvent)
+	}
+
+	// Initialize route authorization and the sync of membership tuples
+	var authorizer middleware.Authorizer
+	// relations also lists the users having a relation, uncached
+	var relations authz.Authorizer
+	var tupleSyncService *authz.SyncService
+	if cfg.OpenFGA.Enabled {
+		authorizationService, model, err := services.ConnectAuthorization(jobsCtx, jobsDB, cfg.OpenFGA, cfg.Authorization.AdminRole)
+		if err != nil {
+			logrus.Fatalf("Failed to initialize authorization: %v", err)
+		}
+		logrus.Infof("Using authorization model v%d (%s) in store %s", model.Version, model.ModelID, model.StoreID)
+		tupleSyncService = authz.NewSyncService(jobsDB, authorizationService)
+		if cfg.Authorization.Engine == config.AuthzEngineOpenFGA {
+			authorizer = authorizationService
+			relations = authorizationService
+			if cfg.Authorization.CacheTTL > 0 {
+				decisionCache := middleware.NewDecisionCache(authorizationService, cfg.Authorization.CacheTTL)
+				// Changed relationships apply right away rather than after the TTL
+				events.Subscribe("authz.tuples_synced", func(events.Event) { decisionCache.Invalidate() })
+				authorizer = decisionCache
+			}
+		}
+	}
+	if cfg.Authorization.Engine == config.AuthzEngineNative {
+		nativeEngine := authz.NewNativeEngine(database.GetDB())
+		if err := authz.GrantAdminRole(jobsDB, nativeEngine, cfg.Authorization.AdminRole); err != nil {
+			logrus.Fatalf("Failed to initialize authorization: %v", err)
+		}
+		// Decisions read the membership tables directly, so they aren't cached
+		authorizer = nativeEngine
+		relations = nativeEngine
+	}
+	if authorizer == nil {
+		logrus.Warn("Route authorization is disabled (AUTHZ_ENGINE=none); every authenticated user may call every API")
+	}
+	permissions := middleware.NewPermissions(authorizer, cfg.Authorization.CheckTimeout)
+	// Objects of other tenants are reported missing whatever the relations
+	permissions.SetTenantGuard(tenant.NewGuard(database.GetDB()))
+	readSystem := permissions.Require(middleware.RelationRead, middleware.SystemObject)
+	manageSystem := permissions.Require(middleware.RelationManage, middleware.SystemObject)
+	readUser := permissions.Require(middleware.RelationRead, "user:{id}")
+	manageUser := permissions.Require(middleware.RelationManage, "user:{id}")
+	readGroup := permissions.Require(middleware.RelationRead, "group:{id}")
+	manageGroup := permissions.Require(middleware.RelationManage, "group:{id}")
+	readRole := permissions.Require(middleware.RelationRead, "role:{id}")
+	manageRole := permissions.Require(middleware.RelationManage, "role:{id}")
+	readOrg := permissions.Require(middleware.RelationRead, "org:{id}")
+	manageOrg := permissions.Require(middleware.RelationManage, "org:{id}")
+
+	// Start background jobs
+	go userService.WithContext(jobsCtx).RunLifecycleScheduler(context.Background(), cfg.Lifecycle.SchedulerInterval)
+	assignmentService := assignment.NewAssignmentService(jobsDB, cfg.Assignment.ExpiryNotice)
+	go assignmentService.RunScheduler(context.Background(), cfg.Assignment.SchedulerInterval)
+	if directoryService.Enabled() && cfg.Directory.SyncInterval > 0 {
+		go directoryService.RunSyncScheduler(context.Background(), cfg.Directory.SyncInterval)
+	}
+	if tupleSyncService != nil {
+		go tupleSyncService.RunOutboxWorker(context.Background(), cfg.Authorization.SyncInterval)
+	}
+	if provisioningService.Enabled() {
+		go provisioningService.RunDeliveryWorker(context.Background(), cfg.Provisioning.Interval)
+		if cfg.Provisioning.ReconcileInterval > 0 {
+			go provisioningService.RunReconcileScheduler(context.Background(), cfg.Provisioning.ReconcileInterval)
+		}
+	}
 
 	// Initialize repositories for member services
 	db := database.GetDB()
@@ -38,20 +232,44 @@ func SetupRoutes(router *gin.Engine) {
 	roleMemberService := services.NewRoleMemberService(roleMemberRepo)
 
 	// Initialize controllers
-	userController := user.NewUserController(userService, pkceService)
+	userController := user.NewUserController(userService, pkceService, sessionService)
 	groupController := group.NewGroupController(groupService)
 	roleController := role.NewRoleController(roleService)
 	orgController := org.NewOrgController(orgService)
 	memberController := member.NewMemberController(memberService)
 	orgMemberController := controllers.NewOrgMemberController(orgMemberService)
 	roleMemberController := controllers.NewRoleMemberController(roleMemberService)
-	pkceController := controllers.NewPKCEController(pkceService, userService)
+	pkceController := controllers.NewPKCEController(pkceService, userService, sessionService, tokenExchangeService)
+	loginController := controllers.NewLoginController(userService, sessionService, impersonationService, federationService, samlService, tenantService)
+	sessionController := session.NewSessionController(sessionService)
+	tokenController := accesstoken.NewTokenController(tokenService)
+	impersonationController := impersonation.NewImpersonationController(impersonationService)
+	federationController := federation.NewFederationController(federationService, sessionService)
+	samlController := samlidp.NewSAMLController(samlService, sessionService)
+	directoryController := directory.NewDirectoryController(directoryService)
+	scimController := scim.NewSCIMController(scimService)
+	provisioningController := provisioning.NewProvisioningController(provisioningService)
+	permissionController := permission.NewPermissionController(permissionService)
+	accessController := access.NewAccessController(accessResolver)
+	notificationController := notification.NewNotificationController(notification.NewNotificationService(database.GetDB()))
+	accessRequestService := accessrequest.NewAccessRequestService(database.GetDB(), authorizer, cfg.Authorization.AdminRole, memberService, roleMemberService)
+	accessRequestController := accessrequest.NewAccessRequestController(accessRequestService)
+	events.Subscribe("group.deleted", accessRequestService.WithContext(jobsCtx).HandleResourceDeleted)
+	events.Subscribe("role.deleted", accessRequestService.WithContext(jobsCtx).HandleResourceDeleted)
+	certificationService := certification.NewCertificationService(database.GetDB(), relations, memberService, roleMemberService, orgMemberService, keys)
+	certificationController := certification.NewCertificationController(certificationService)
+	go certificationService.WithContext(jobsCtx).RunScheduler(context.Background(), cfg.Certification.SchedulerInterval)
+	sodService := sod.NewPolicyService(database.GetDB())
+	sodController := controllers.NewSoDPolicyController(sodService)
+	events.Subscribe("role.deleted", sodService.WithContext(jobsCtx).HandleRoleDeleted)
+	tenantController := tenant.NewTenantController(tenantService)
 
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	{
 		// Public routes (no authentication required)
 		v1.POST("/auth/login", userController.Login)
+		v1.POST("/auth/password/change", userController.ChangePassword)
 
 		// PKCE Authentication routes (public)
 		pkce := v1.Group("/auth/pkce")
@@ -64,82 +282,313 @@ func SetupRoutes(router *gin.Engine) {
 		}
 
 		// Login form routes (public)
-		router.GET("/login", controllers.ShowLoginForm)
-		router.POST("/login", controllers.HandleLogin)
-		router.GET("/logout", controllers.Logout)
+		router.GET("/login", loginController.ShowLoginForm)
+		router.POST("/login", loginController.HandleLogin)
+		router.GET("/logout", loginController.Logout)
+		router.GET("/login/federated/:provider", requireDefault, federationController.StartLogin)
+		router.GET("/login/federated/:provider/callback", requireDefault, federationController.Callback)
+
+		// SAML identity provider routes (public)
+		saml := router.Group("/saml", requireDefault)
+		{
+			saml.GET("/metadata", samlController.Metadata)
+			saml.GET("/sso", samlController.SSO)
+			saml.POST("/sso", samlController.SSO)
+			saml.GET("/resume/:requestId", samlController.ResumeSSO)
+			saml.GET("/launch/:id", samlController.Launch)
+			saml.GET("/slo", samlController.SingleLogout)
+			saml.POST("/slo", samlController.SingleLogout)
+			saml.GET("/logout", samlController.Logout)
+		}
+
+		// Permissions registered by clients for themselves, with a client
+		// credentials token
+		clientPermissionRoutes(v1.Group("/client/permissions"), tokenexchange.NewClientValidator(jobsDB), permissionController)
 
 		// Protected routes (authentication required)
 		protected := v1.Group("")
-		protected.Use(middleware.AuthMiddleware())
+		protected.Use(middleware.AuthMiddleware(tokenService.WithContext(jobsCtx), userService.WithContext(jobsCtx), jobSessions))
+		protected.Use(impersonationController.AuditRequests())
 		{
+			// Self-service session and grant routes
+			me := protected.Group("/me")
+			{
+				me.GET("/sessions", sessionController.GetMySessions)
+				me.DELETE("/sessions", sessionController.RevokeMySessions)
+				me.DELETE("/sessions/:sessionId", sessionController.RevokeMySession)
+				me.GET("/grants", sessionController.GetMyGrants)
+				me.DELETE("/grants", sessionController.RevokeMyGrants)
+				me.DELETE("/grants/:grantId", sessionController.RevokeMyGrant)
+				me.GET("/tokens", tokenController.GetMyTokens)
+				me.POST("/tokens", tokenController.CreateMyToken)
+				me.DELETE("/tokens/:tokenId", tokenController.RevokeMyToken)
+				me.DELETE("/impersonation", impersonationController.EndImpersonation)
+				me.GET("/account-links", requireDefault, federationController.GetMyAccountLinks)
+				me.GET("/permissions", permissionController.GetMyPermissions)
+				me.GET("/access", accessController.GetMyAccess)
+				me.GET("/notifications", notificationController.GetMyNotifications)
+				me.POST("/notifications/:id/read", notificationController.MarkMyNotificationRead)
+				me.GET("/access-requests", accessRequestController.GetMyRequests)
+				me.GET("/approvals", accessRequestController.GetMyApprovals)
+				me.GET("/reviews", certificationController.GetMyReviews)
+			}
+
 			// User routes
 			users := protected.Group("/users")
 			{
-				users.GET("", userController.GetAllUsers)
-				users.GET("/:id", userController.GetUser)
-				users.POST("", userController.CreateUser)
-				users.PUT("/:id", userController.UpdateUser)
-				users.DELETE("/:id", userController.DeleteUser)
+				users.GET("", readSystem, userController.GetAllUsers)
+				users.GET("/:id", readUser, userController.GetUser)
+				users.POST("", manageSystem, userController.CreateUser)
+				users.POST("/import", manageSystem, userController.ImportUsers)
+				users.PUT("/:id", manageUser, userController.UpdateUser)
+				users.DELETE("/:id", manageUser, userController.DeleteUser)
+				users.POST("/:id/password", manageUser, userController.ResetPassword)
+
+				// Lifecycle transitions
+				users.POST("/:id/activate", manageUser, userController.ActivateUser)
+				users.POST("/:id/suspend", manageUser, userController.SuspendUser)
+				users.POST("/:id/lock", manageUser, userController.LockUser)
+				users.POST("/:id/unlock", manageUser, userController.UnlockUser)
+				users.POST("/:id/deprovision", manageUser, userController.DeprovisionUser)
+				users.GET("/:id/lifecycle/schedules", manageUser, userController.GetLifecycleSchedules)
+				users.DELETE("/:id/lifecycle/schedules/:scheduleId", manageUser, userController.CancelLifecycleSchedule)
+
+				// Sessions and refresh-token grants
+				users.GET("/:id/sessions", manageUser, sessionController.GetUserSessions)
+				users.DELETE("/:id/sessions", manageUser, sessionController.RevokeUserSessions)
+				users.DELETE("/:id/sessions/:sessionId", manageUser, sessionController.RevokeUserSession)
+				users.GET("/:id/grants", manageUser, sessionController.GetUserGrants)
+				users.DELETE("/:id/grants", manageUser, sessionController.RevokeUserGrants)
+				users.DELETE("/:id/grants/:grantId", manageUser, sessionController.RevokeUserGrant)
+
+				// Personal access tokens
+				users.GET("/:id/tokens", manageUser, tokenController.GetUserTokens)
+				users.DELETE("/:id/tokens/:tokenId", manageUser, tokenController.RevokeUserToken)
+
+				// Impersonation
+				users.POST("/:id/impersonate", manageUser, impersonationController.StartImpersonation)
+
+				// Federated account links
+				users.GET("/:id/account-links", requireDefault, manageUser, federationController.GetUserAccountLinks)
+				users.DELETE("/:id/account-links/:linkId", requireDefault, manageUser, federationController.DeleteUserAccountLink)
+
+				// Effective permissions through role assignments
+				users.GET("/:id/permissions", readUser, permissionController.GetUserPermissions)
+
+				// Effective groups, roles and orgs with how each is held
+				users.GET("/:id/access", readUser, accessController.GetUserAccess)
 			}
 
 			// Group routes
 			groups := protected.Group("/groups")
 			{
-				groups.GET("", groupController.GetAllGroups)
-				groups.GET("/:id", groupController.GetGroup)
-				groups.POST("", groupController.CreateGroup)
-				groups.PUT("/:id", groupController.UpdateGroup)
-				groups.DELETE("/:id", groupController.DeleteGroup)
+				groups.GET("", readSystem, groupController.GetAllGroups)
+				groups.GET("/:id", readGroup, groupController.GetGroup)
+				groups.POST("", manageSystem, groupController.CreateGroup)
+				groups.PUT("/:id", manageGroup, groupController.UpdateGroup)
+				groups.DELETE("/:id", manageGroup, groupController.DeleteGroup)
+				groups.GET("/:id/approval-chain", readGroup, accessRequestController.GetGroupChain)
+				groups.PUT("/:id/approval-chain", manageGroup, accessRequestController.SetGroupChain)
 			}
 
 			// Role routes
 			roles := protected.Group("/roles")
 			{
-				roles.GET("", roleController.GetAllRoles)
-				roles.GET("/:id", roleController.GetRole)
-				roles.POST("", roleController.CreateRole)
-				roles.PUT("/:id", roleController.UpdateRole)
-				roles.DELETE("/:id", roleController.DeleteRole)
+				roles.GET("", readSystem, roleController.GetAllRoles)
+				roles.GET("/:id", readRole, roleController.GetRole)
+				roles.POST("", manageSystem, roleController.CreateRole)
+				roles.PUT("/:id", manageRole, roleController.UpdateRole)
+				roles.DELETE("/:id", manageRole, roleController.DeleteRole)
+
+				// Permissions carried by the role
+				roles.GET("/:id/permissions", readRole, permissionController.GetRolePermissions)
+				roles.POST("/:id/permissions", manageRole, permissionController.AddRolePermissions)
+				roles.PUT("/:id/permissions", manageRole, permissionController.SetRolePermissions)
+				roles.DELETE("/:id/permissions/:permissionId", manageRole, permissionController.RemoveRolePermission)
+
+				// Approval chain of access requests for the role
+				roles.GET("/:id/approval-chain", readRole, accessRequestController.GetRoleChain)
+				roles.PUT("/:id/approval-chain", manageRole, accessRequestController.SetRoleChain)
+			}
+
+			// Access requests; approvers are checked against the approval
+			// chain of each request
+			accessRequests := protected.Group("/access-requests")
+			{
+				accessRequests.POST("", accessRequestController.CreateRequest)
+				accessRequests.GET("", readSystem, accessRequestController.GetRequests)
+				accessRequests.GET("/:id", accessRequestController.GetRequest)
+				accessRequests.GET("/:id/audit", accessRequestController.GetAudit)
+				accessRequests.POST("/:id/approve", accessRequestController.Approve)
+				accessRequests.POST("/:id/deny", accessRequestController.Deny)
+				accessRequests.POST("/:id/escalate", accessRequestController.Escalate)
+				accessRequests.POST("/:id/cancel", accessRequestController.CancelRequest)
+			}
+
+			// Access certification campaigns; reviewers are checked per item
+			certifications := protected.Group("/certifications")
+			{
+				certifications.GET("", readSystem, certificationController.GetCampaigns)
+				certifications.POST("", manageSystem, certificationController.CreateCampaign)
+				certifications.GET("/report-keys", readSystem, certificationController.GetReportKeys)
+				certifications.GET("/:id", readSystem, certificationController.GetCampaign)
+				certifications.GET("/:id/items", readSystem, certificationController.GetItems)
+				certifications.GET("/:id/report", readSystem, certificationController.GetReport)
+				certifications.POST("/:id/items/:itemId/decision", certificationController.DecideItem)
+			}
+
+			// Separation-of-duties policies, their exceptions and the scan
+			// for users breaking them
+			sodPolicies := protected.Group("/sod")
+			{
+				sodPolicies.GET("/policies", readSystem, sodController.GetPolicies)
+				sodPolicies.POST("/policies", manageSystem, sodController.CreatePolicy)
+				sodPolicies.GET("/policies/:id", readSystem, sodController.GetPolicy)
+				sodPolicies.PUT("/policies/:id", manageSystem, sodController.UpdatePolicy)
+				sodPolicies.DELETE("/policies/:id", manageSystem, sodController.DeletePolicy)
+				sodPolicies.GET("/policies/:id/exceptions", readSystem, sodController.GetExceptions)
+				sodPolicies.POST("/policies/:id/exceptions", manageSystem, sodController.GrantException)
+				sodPolicies.DELETE("/policies/:id/exceptions/:exceptionId", manageSystem, sodController.RevokeException)
+				sodPolicies.GET("/violations", readSystem, sodController.GetViolations)
+			}
+
+			// Permissions catalog
+			permissionCatalog := protected.Group("/permissions")
+			{
+				permissionCatalog.GET("", readSystem, permissionController.GetAllPermissions)
+				permissionCatalog.GET("/:id", readSystem, permissionController.GetPermission)
+				permissionCatalog.POST("", requireDefault, manageSystem, permissionController.CreatePermission)
+				permissionCatalog.PUT("/:id", requireDefault, manageSystem, permissionController.UpdatePermission)
+				permissionCatalog.DELETE("/:id", requireDefault, manageSystem, permissionController.DeletePermission)
+			}
+
+			// SAML service provider registration
+			serviceProviders := protected.Group("/saml/service-providers", requireDefault)
+			{
+				serviceProviders.GET("", readSystem, samlController.GetAllServiceProviders)
+				serviceProviders.GET("/:id", readSystem, samlController.GetServiceProvider)
+				serviceProviders.POST("", manageSystem, samlController.CreateServiceProvider)
+				serviceProviders.PUT("/:id", manageSystem, samlController.UpdateServiceProvider)
+				serviceProviders.DELETE("/:id", manageSystem, samlController.DeleteServiceProvider)
+			}
+
+			// LDAP directory sync
+			directorySync := protected.Group("/directory/sync", requireDefault)
+			{
+				directorySync.POST("", manageSystem, directoryController.Sync)
+				directorySync.GET("/runs", readSystem, directoryController.GetSyncRuns)
+				directorySync.GET("/runs/:id", readSystem, directoryController.GetSyncRun)
+			}
+
+			// Outbound SCIM provisioning targets
+			provisioningTargets := protected.Group("/provisioning/targets", requireDefault)
+			{
+				provisioningTargets.GET("", readSystem, provisioningController.GetTargets)
+				provisioningTargets.GET("/:id", readSystem, provisioningController.GetTarget)
+				provisioningTargets.GET("/:id/jobs", readSystem, provisioningController.GetJobs)
+				provisioningTargets.POST("/:id/retry", manageSystem, provisioningController.Retry)
+				provisioningTargets.POST("/:id/reconcile", manageSystem, provisioningController.Reconcile)
+				provisioningTargets.GET("/:id/runs", readSystem, provisioningController.GetReconcileRuns)
+				provisioningTargets.GET("/:id/runs/:runId", readSystem, provisioningController.GetReconcileRun)
+			}
+
+			// Sync of membership tuples to OpenFGA
+			if tupleSyncService != nil {
+				tupleSyncController := authz.NewSyncController(tupleSyncService)
+				tupleSync := protected.Group("/authz/sync", requireDefault)
+				{
+					tupleSync.GET("", readSystem, tupleSyncController.GetStatus)
+					tupleSync.GET("/failed", readSystem, tupleSyncController.GetFailedChanges)
+					tupleSync.POST("/resync", manageSystem, tupleSyncController.Resync)
+				}
+			}
+
+			// Tenants, administered from the default tenant
+			tenants := protected.Group("/tenants", requireDefault)
+			{
+				tenants.GET("", readSystem, tenantController.GetAllTenants)
+				tenants.GET("/:id", readSystem, tenantController.GetTenant)
+				tenants.POST("", manageSystem, tenantController.CreateTenant)
+				tenants.PUT("/:id", manageSystem, tenantController.UpdateTenant)
+				tenants.DELETE("/:id", manageSystem, tenantController.DeleteTenant)
+				tenants.POST("/:id/signing-key", manageSystem, tenantController.RotateSigningKey)
 			}
 
 			// Organization routes
 			orgs := protected.Group("/orgs")
 			{
-				orgs.GET("", orgController.GetAllOrgs)
-				orgs.GET("/:id", orgController.GetOrg)
-				orgs.POST("", orgController.CreateOrg)
-				orgs.PUT("/:id", orgController.UpdateOrg)
-				orgs.DELETE("/:id", orgController.DeleteOrg)
+				orgs.GET("", readSystem, orgController.GetAllOrgs)
+				orgs.GET("/:id", readOrg, orgController.GetOrg)
+				orgs.POST("", manageSystem, orgController.CreateOrg)
+				orgs.PUT("/:id", manageOrg, orgController.UpdateOrg)
+				orgs.DELETE("/:id", manageOrg, orgController.DeleteOrg)
+				// Org tree; admins of an org manage the orgs below it
+				orgs.GET("/:id/children", readOrg, orgController.GetChildren)
+				orgs.POST("/:id/children", manageOrg, orgController.CreateChildOrg)
+				orgs.GET("/:id/subtree", readOrg, orgController.GetSubtree)
+				orgs.GET("/:id/ancestors", readOrg, orgController.GetAncestors)
+				orgs.PUT("/:id/parent", manageOrg, permissions.Require(middleware.RelationManage, "org:{parentId}"), orgController.MoveOrg)
+				orgs.DELETE("/:id/parent", manageSystem, orgController.MakeRootOrg)
 			}
 
 			// Member routes (User-Group management)
 			members := protected.Group("/groupmembers")
 			{
-				members.GET("", memberController.GetAllMembers)
-				members.GET("/group/:groupId", memberController.GetMembersByGroupID)
-				members.GET("/user/:userId", memberController.GetMembersByUserID)
-				members.POST("", memberController.AddMember)
+				members.GET("", readSystem, memberController.GetAllMembers)
+				members.GET("/group/:groupId", permissions.Require(middleware.RelationRead, "group:{groupId}"), memberController.GetMembersByGroupID)
+				members.GET("/user/:userId", permissions.Require(middleware.RelationRead, "user:{userId}"), memberController.GetMembersByUserID)
+				members.POST("", permissions.Require(middleware.RelationManage, "group:{groupId}"), memberController.AddMember)
+				// Groups in groups
+				members.GET("/group/:groupId/groups", permissions.Require(middleware.RelationRead, "group:{groupId}"), memberController.GetNestedGroups)
+				members.POST("/groups", permissions.Require(middleware.RelationManage, "group:{groupId}"), memberController.HandleNestedGroupOperation)
 			}
 
 			// Organization Member routes
 			orgMembers := protected.Group("/orgmembers")
 			{
-				orgMembers.GET("", orgMemberController.GetAllMembers)
-				orgMembers.GET("/org/:orgId", orgMemberController.GetMembersByOrgID)
-				orgMembers.POST("", orgMemberController.HandleMemberOperation)
+				orgMembers.GET("", readSystem, orgMemberController.GetAllMembers)
+				orgMembers.GET("/org/:orgId", permissions.Require(middleware.RelationRead, "org:{orgId}"), orgMemberController.GetMembersByOrgID)
+				orgMembers.POST("", permissions.Require(middleware.RelationManage, "org:{orgId}"), orgMemberController.HandleMemberOperation)
 			}
 
 			// Role Member routes
 			roleMembers := protected.Group("/rolemembers")
 			{
-				roleMembers.GET("", roleMemberController.GetAllMembers)
-				roleMembers.GET("/role/:roleId", roleMemberController.GetMembersByRoleID)
-				roleMembers.GET("/entity/:entityId", roleMemberController.GetMembersByEntityID)
-				roleMembers.POST("", roleMemberController.HandleMemberOperation)
+				roleMembers.GET("", readSystem, roleMemberController.GetAllMembers)
+				roleMembers.GET("/role/:roleId", permissions.Require(middleware.RelationRead, "role:{roleId}"), roleMemberController.GetMembersByRoleID)
+				roleMembers.GET("/entity/:entityId", readSystem, roleMemberController.GetMembersByEntityID)
+				roleMembers.POST("", permissions.Require(middleware.RelationManage, "role:{roleId}"), roleMemberController.HandleMemberOperation)
 			}
 		}
 	}
 
+	// SCIM 2.0 provisioning routes (client credentials tokens with the scim scope)
+	scimRoutes := router.Group("/scim/v2")
+	scimRoutes.Use(scim.Authenticate(tokenexchange.NewClientValidator(jobsDB)))
+	{
+		scimRoutes.GET("/ServiceProviderConfig", scimController.GetServiceProviderConfig)
+		scimRoutes.GET("/ResourceTypes", scimController.GetResourceTypes)
+		scimRoutes.GET("/ResourceTypes/:id", scimController.GetResourceType)
+		scimRoutes.GET("/Schemas", scimController.GetSchemas)
+		scimRoutes.GET("/Schemas/:id", scimController.GetSchema)
+
+		scimRoutes.GET("/Users", scimController.GetUsers)
+		scimRoutes.GET("/Users/:id", scimController.GetUser)
+		scimRoutes.POST("/Users", scimController.CreateUser)
+		scimRoutes.PUT("/Users/:id", scimController.ReplaceUser)
+		scimRoutes.PATCH("/Users/:id", scimController.PatchUser)
+		scimRoutes.DELETE("/Users/:id", scimController.DeleteUser)
+
+		scimRoutes.GET("/Groups", scimController.GetGroups)
+		scimRoutes.GET("/Groups/:id", scimController.GetGroup)
+		scimRoutes.POST("/Groups", scimController.CreateGroup)
+		scimRoutes.PUT("/Groups/:id", scimController.ReplaceGroup)
+		scimRoutes.PATCH("/Groups/:id", scimController.PatchGroup)
+		scimRoutes.DELETE("/Groups/:id", scimController.DeleteGroup)
+
+		scimRoutes.POST("/Bulk", scimController.Bulk)
+	}
+
 	// Health check endpoint
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -151,3 +600,14 @@ func SetupRoutes(router *gin.Engine) {
 	// OIDC Discovery endpoint (well-known)
 	router.GET("/.well-known/openid-configuration", pkceController.GetOIDCConfig)
 }
+
+// clientPermissionRoutes mounts the routes clients manage their own
+// permissions with. Client credentials tokens have no user as subject, so
+// they are checked against the active clients instead of the users.
+func clientPermissionRoutes(clientPermissions *gin.RouterGroup, clients middleware.TokenValidator, permissionController *permission.PermissionController) {
+	clientPermissions.Use(middleware.AuthMiddleware(nil, clients))
+	clientPermissions.GET("", permissionController.GetClientPermissions)
+	clientPermissions.POST("", permissionController.RegisterClientPermission)
+	clientPermissions.PUT("/:id", permissionController.UpdateClientPermission)
+	clientPermissions.DELETE("/:id", permissionController.DeleteClientPermission)
+}
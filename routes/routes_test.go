@@ -0,0 +1,125 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"idmapp-go/controllers"
+	"idmapp-go/dto"
+	"idmapp-go/internal/client"
+	"idmapp-go/internal/permission"
+	"idmapp-go/internal/tenant"
+	"idmapp-go/internal/tokenexchange"
+	"idmapp-go/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newClientPermissionsRouter serves the token endpoint and the client
+// permission routes the way SetupRoutes does
+func newClientPermissionsRouter(t *testing.T) http.Handler {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "routes.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(&tenant.Plugin{}))
+	for _, ddl := range []string{
+		"CREATE TABLE tenants (id TEXT PRIMARY KEY, slug TEXT NOT NULL UNIQUE, name TEXT NOT NULL, host TEXT UNIQUE, active BOOLEAN NOT NULL DEFAULT TRUE, displayname TEXT, logo_url TEXT, primary_color TEXT, signing_key BLOB NOT NULL, created_at DATETIME, updated_at DATETIME)",
+		"CREATE TABLE clients (id TEXT PRIMARY KEY, tenant_id TEXT NOT NULL, client_id TEXT NOT NULL UNIQUE, client_secret TEXT NOT NULL, name TEXT NOT NULL, redirect_uris TEXT, scopes TEXT NOT NULL, token_exchange_audiences TEXT, active BOOLEAN NOT NULL DEFAULT TRUE, created_at DATETIME, updated_at DATETIME)",
+		"CREATE TABLE permissions (id TEXT PRIMARY KEY, name TEXT NOT NULL UNIQUE, description TEXT, client_id TEXT, builtin BOOLEAN NOT NULL DEFAULT FALSE, created_at DATETIME, updated_at DATETIME)",
+	} {
+		require.NoError(t, db.Exec(ddl).Error)
+	}
+
+	jobsDB := db.WithContext(tenant.Bypass(context.Background()))
+	tenantService := tenant.NewTenantService(jobsDB)
+	require.NoError(t, tenantService.EnsureDefault())
+	tenant.SetSigningKeys(tenantService)
+	t.Cleanup(func() { tenant.SetSigningKeys(nil) })
+
+	defaultDB := db.WithContext(tenant.NewContext(context.Background(), tenant.DefaultID, tenant.SourceDefault))
+	require.NoError(t, defaultDB.Create(&client.Client{
+		ID:           uuid.New(),
+		ClientID:     "billing",
+		ClientSecret: "billing-secret",
+		Name:         "Billing",
+		Scopes:       pq.StringArray{"read", "write"},
+		Active:       true,
+	}).Error)
+
+	pkceService := services.NewPKCEService(db)
+	tokenExchangeService := tokenexchange.NewTokenExchangeService(db, pkceService, time.Minute, nil)
+	pkceController := controllers.NewPKCEController(pkceService, nil, nil, tokenExchangeService)
+	permissionController := permission.NewPermissionController(permission.NewPermissionService(db))
+
+	router := gin.New()
+	router.Use(tenant.Resolve(tenantService))
+	v1 := router.Group("/api/v1")
+	v1.POST("/auth/pkce/token", pkceController.ExchangeCodeForToken)
+	clientPermissionRoutes(v1.Group("/client/permissions"), tokenexchange.NewClientValidator(jobsDB), permissionController)
+	return router
+}
+
+func TestClientPermissionsWithClientCredentials(t *testing.T) {
+	router := newClientPermissionsRouter(t)
+
+	form := url.Values{
+		"grant_type":    {tokenexchange.ClientCredentialsGrantType},
+		"client_id":     {"billing"},
+		"client_secret": {"billing-secret"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/auth/pkce/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var token dto.PKCETokenResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &token))
+
+	serve := func(method, path, bearer, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w = serve(http.MethodPost, "/api/v1/client/permissions", token.AccessToken, `{"name": "billing:invoices.approve"}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var registered permission.Permission
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &registered))
+	assert.Equal(t, "billing", registered.ClientID)
+
+	w = serve(http.MethodGet, "/api/v1/client/permissions", token.AccessToken, "")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var listed []permission.Permission
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	require.Len(t, listed, 1)
+	assert.Equal(t, registered.ID, listed[0].ID)
+
+	// User tokens can't manage client permissions
+	userToken, err := tenant.SignToken(jwt.MapClaims{
+		"sub": uuid.New().String(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}, tenant.DefaultID)
+	require.NoError(t, err)
+	w = serve(http.MethodGet, "/api/v1/client/permissions", userToken, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -2,58 +2,202 @@ package services
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
+	"idmapp-go/config"
+	"idmapp-go/internal/authz"
+
+	openfga "github.com/openfga/go-sdk"
 	"github.com/openfga/go-sdk/client"
 	"github.com/openfga/go-sdk/credentials"
 	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
 )
 
 type AuthorizationService struct {
+	apiURL    string
+	apiToken  string
 	fgaClient *client.OpenFgaClient
 	logger    *logrus.Logger
 }
 
+// NewAuthorizationService connects to OpenFGA. storeID may be empty until
+// UseStore is called, e.g. to find or create the store first.
 func NewAuthorizationService(apiURL, storeID, apiToken string) (*AuthorizationService, error) {
-	fgaClient, err := client.NewSdkClient(&client.ClientConfiguration{
-		ApiUrl:  apiURL,
-		StoreId: storeID,
-		Credentials: &credentials.Credentials{
+	s := &AuthorizationService{
+		apiURL:   apiURL,
+		apiToken: apiToken,
+		logger:   logrus.New(),
+	}
+	if err := s.UseStore(storeID, ""); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ConnectAuthorization connects to the configured OpenFGA store, creating it
+// and writing the current authorization model when missing, and pins the
+// model the service runs. Assignees of adminRole become system admins.
+func ConnectAuthorization(ctx context.Context, db *gorm.DB, cfg config.OpenFGAConfig, adminRole string) (*AuthorizationService, *authz.AuthorizationModel, error) {
+	s, err := NewAuthorizationService(cfg.APIURL, "", cfg.APIToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	models := authz.NewModelService(db, s)
+	model, err := models.Ensure(ctx, cfg.StoreID, cfg.StoreName)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to set up authorization model: %w", err)
+	}
+	if err := authz.GrantAdminRole(db, s, adminRole); err != nil {
+		return nil, nil, err
+	}
+	return s, model, nil
+}
+
+// UseStore points the service at the store and, if modelID is set, at that
+// model rather than the latest one of the store. Not safe for concurrent use
+// with other calls; it is meant for setup.
+func (s *AuthorizationService) UseStore(storeID, modelID string) error {
+	creds := &credentials.Credentials{Method: credentials.CredentialsMethodNone}
+	if s.apiToken != "" {
+		creds = &credentials.Credentials{
 			Method: credentials.CredentialsMethodApiToken,
 			Config: &credentials.Config{
-				ApiToken: apiToken,
+				ApiToken: s.apiToken,
 			},
-		},
+		}
+	}
+	fgaClient, err := client.NewSdkClient(&client.ClientConfiguration{
+		ApiUrl:               s.apiURL,
+		StoreId:              storeID,
+		AuthorizationModelId: modelID,
+		Credentials:          creds,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create OpenFGA client: %w", err)
+		return fmt.Errorf("failed to create OpenFGA client: %w", err)
 	}
+	s.fgaClient = fgaClient
+	return nil
+}
 
-	return &AuthorizationService{
-		fgaClient: fgaClient,
-		logger:    logrus.New(),
-	}, nil
+// FindStore returns the ID of the store with the name, or "" if there is none
+func (s *AuthorizationService) FindStore(ctx context.Context, name string) (string, error) {
+	pageSize := int32(readPageSize)
+	continuationToken := ""
+	for {
+		options := client.ClientListStoresOptions{PageSize: &pageSize}
+		if continuationToken != "" {
+			options.ContinuationToken = &continuationToken
+		}
+		resp, err := s.fgaClient.ListStores(ctx).Options(options).Execute()
+		if err != nil {
+			return "", fmt.Errorf("failed to list stores: %w", err)
+		}
+		for _, store := range resp.GetStores() {
+			if store.GetName() == name {
+				return store.GetId(), nil
+			}
+		}
+		continuationToken = resp.GetContinuationToken()
+		if continuationToken == "" {
+			return "", nil
+		}
+	}
 }
 
-func (s *AuthorizationService) CheckAccess(userID, relation, resourceID string) bool {
+func (s *AuthorizationService) CreateStore(ctx context.Context, name string) (string, error) {
+	resp, err := s.fgaClient.CreateStore(ctx).Body(client.ClientCreateStoreRequest{Name: name}).Execute()
+	if err != nil {
+		return "", fmt.Errorf("failed to create store: %w", err)
+	}
+	return resp.GetId(), nil
+}
+
+// WriteModel writes an authorization model given as JSON to the store and
+// returns its ID
+func (s *AuthorizationService) WriteModel(ctx context.Context, model []byte) (string, error) {
+	var body client.ClientWriteAuthorizationModelRequest
+	if err := json.Unmarshal(model, &body); err != nil {
+		return "", fmt.Errorf("failed to parse authorization model: %w", err)
+	}
+	resp, err := s.fgaClient.WriteAuthorizationModel(ctx).Body(body).Execute()
+	if err != nil {
+		return "", fmt.Errorf("failed to write authorization model: %w", err)
+	}
+	return resp.GetAuthorizationModelId(), nil
+}
+
+// Check implements middleware.Authorizer. Unlike CheckAccess it reports
+// errors, so that callers can fail closed instead of treating them as denials.
+func (s *AuthorizationService) Check(ctx context.Context, user, relation, object string) (bool, error) {
 	body := client.ClientCheckRequest{
-		User:     userID,
+		User:     user,
 		Relation: relation,
-		Object:   resourceID,
+		Object:   object,
 	}
 
-	resp, err := s.fgaClient.Check(context.Background()).Body(body).Execute()
+	resp, err := s.fgaClient.Check(ctx).Body(body).Execute()
 	if err != nil {
-		s.logger.Errorf("Error checking permission for user: %s, relation: %s, resource: %s, error: %v", userID, relation, resourceID, err)
-		return false
+		return false, fmt.Errorf("failed to check permission: %w", err)
 	}
 
 	allowed := resp.GetAllowed()
-	s.logger.Debugf("Permission check: %s %s %s -> %s", userID, relation, resourceID, map[bool]string{true: "ALLOWED", false: "DENIED"}[allowed])
+	s.logger.Debugf("Permission check: %s %s %s -> %s", user, relation, object, map[bool]string{true: "ALLOWED", false: "DENIED"}[allowed])
+	return allowed, nil
+}
+
+// ListObjects implements authz.Authorizer
+func (s *AuthorizationService) ListObjects(ctx context.Context, user, relation, objectType string) ([]string, error) {
+	resp, err := s.fgaClient.ListObjects(ctx).Body(client.ClientListObjectsRequest{
+		User:     user,
+		Relation: relation,
+		Type:     objectType,
+	}).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	objects := resp.GetObjects()
+	sort.Strings(objects)
+	return objects, nil
+}
+
+// ListUsers implements authz.Authorizer
+func (s *AuthorizationService) ListUsers(ctx context.Context, object, relation, userType string) ([]string, error) {
+	objectType, objectID, _ := strings.Cut(object, ":")
+	resp, err := s.fgaClient.ListUsers(ctx).Body(client.ClientListUsersRequest{
+		Object:      openfga.FgaObject{Type: objectType, Id: objectID},
+		Relation:    relation,
+		UserFilters: []openfga.UserTypeFilter{{Type: userType}},
+	}).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	users := []string{}
+	for _, user := range resp.GetUsers() {
+		if fgaObject, ok := user.GetObjectOk(); ok {
+			users = append(users, fgaObject.GetType()+":"+fgaObject.GetId())
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+func (s *AuthorizationService) CheckAccess(userID, relation, resourceID string) bool {
+	allowed, err := s.Check(context.Background(), userID, relation, resourceID)
+	if err != nil {
+		s.logger.Errorf("Error checking permission for user: %s, relation: %s, resource: %s, error: %v", userID, relation, resourceID, err)
+		return false
+	}
 	return allowed
 }
 
-func (s *AuthorizationService) GrantPermission(userID, relation, resourceID string) bool {
+// GrantPermission writes the tuple. Writing a tuple that already exists
+// succeeds, so grants can be retried.
+func (s *AuthorizationService) GrantPermission(userID, relation, resourceID string) error {
 	writes := []client.ClientTupleKey{{
 		User:     userID,
 		Relation: relation,
@@ -65,15 +209,20 @@ func (s *AuthorizationService) GrantPermission(userID, relation, resourceID stri
 
 	_, err := s.fgaClient.Write(context.Background()).Body(body).Execute()
 	if err != nil {
+		if isWriteConflict(err, "already exists") {
+			return nil
+		}
 		s.logger.Errorf("Error granting permission for user: %s, relation: %s, resource: %s, error: %v", userID, relation, resourceID, err)
-		return false
+		return writeError("grant permission", err)
 	}
 
 	s.logger.Infof("Granted permission: %s %s %s", userID, relation, resourceID)
-	return true
+	return nil
 }
 
-func (s *AuthorizationService) RevokePermission(userID, relation, resourceID string) bool {
+// RevokePermission deletes the tuple. Deleting a tuple that doesn't exist
+// succeeds, so revocations can be retried.
+func (s *AuthorizationService) RevokePermission(userID, relation, resourceID string) error {
 	deletes := []client.ClientTupleKeyWithoutCondition{{
 		User:     userID,
 		Relation: relation,
@@ -85,12 +234,63 @@ func (s *AuthorizationService) RevokePermission(userID, relation, resourceID str
 
 	_, err := s.fgaClient.Write(context.Background()).Body(body).Execute()
 	if err != nil {
+		if isWriteConflict(err, "does not exist") || isWriteConflict(err, "did not exist") {
+			return nil
+		}
 		s.logger.Errorf("Error revoking permission for user: %s, relation: %s, resource: %s, error: %v", userID, relation, resourceID, err)
-		return false
+		return writeError("revoke permission", err)
 	}
 
 	s.logger.Infof("Revoked permission: %s %s %s", userID, relation, resourceID)
-	return true
+	return nil
+}
+
+// readPageSize is the number of tuples read per request
+const readPageSize = 100
+
+// ReadTuples reads every tuple in the store
+func (s *AuthorizationService) ReadTuples(ctx context.Context) ([]authz.Tuple, error) {
+	var tuples []authz.Tuple
+	pageSize := int32(readPageSize)
+	continuationToken := ""
+	for {
+		options := client.ClientReadOptions{PageSize: &pageSize}
+		if continuationToken != "" {
+			options.ContinuationToken = &continuationToken
+		}
+		resp, err := s.fgaClient.Read(ctx).Body(client.ClientReadRequest{}).Options(options).Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tuples: %w", err)
+		}
+		for _, tuple := range resp.GetTuples() {
+			key := tuple.GetKey()
+			tuples = append(tuples, authz.Tuple{User: key.GetUser(), Relation: key.GetRelation(), Object: key.GetObject()})
+		}
+		continuationToken = resp.GetContinuationToken()
+		if continuationToken == "" {
+			return tuples, nil
+		}
+	}
+}
+
+// isWriteConflict reports whether OpenFGA refused a write because the tuple
+// is already in the state asked for
+func isWriteConflict(err error, reason string) bool {
+	var validationErr openfga.FgaApiValidationError
+	if !errors.As(err, &validationErr) {
+		return false
+	}
+	return strings.Contains(string(validationErr.Body()), reason)
+}
+
+// writeError marks writes that OpenFGA refused as invalid, as they fail the
+// same way when retried
+func writeError(action string, err error) error {
+	var validationErr openfga.FgaApiValidationError
+	if errors.As(err, &validationErr) {
+		return fmt.Errorf("failed to %s: %w: %v", action, authz.ErrRejected, err)
+	}
+	return fmt.Errorf("failed to %s: %w", action, err)
 }
 
 // Convenience methods for common authorization checks
@@ -1,12 +1,19 @@
 package services
 
 import (
+	"context"
+
+	"idmapp-go/internal/events"
 	"idmapp-go/models"
 	"idmapp-go/repository"
 
 	"github.com/google/uuid"
 )
 
+// ExpandInherited is the expand option adding the members an org inherits
+// from the orgs above it
+const ExpandInherited = "inherited"
+
 type OrgMemberService struct {
 	repo *repository.OrgMemberRepository
 }
@@ -15,15 +22,32 @@ func NewOrgMemberService(repo *repository.OrgMemberRepository) *OrgMemberService
 	return &OrgMemberService{repo: repo}
 }
 
-func (s *OrgMemberService) AddMember(orgId, entityId uuid.UUID, memberType string) (*models.OrgMember, error) {
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *OrgMemberService) WithContext(ctx context.Context) *OrgMemberService {
+	scoped := *s
+	scoped.repo = s.repo.WithContext(ctx)
+	return &scoped
+}
+
+// AddMember adds the entity to the org, and with cascade to every org below
+// it as well
+func (s *OrgMemberService) AddMember(orgId, entityId uuid.UUID, memberType string, cascade bool) (*models.OrgMember, error) {
 	orgMember := &models.OrgMember{
 		OrgID:    orgId,
 		EntityID: entityId,
 		Type:     memberType,
+		Cascade:  cascade,
 	}
 	if err := s.repo.Save(orgMember); err != nil {
 		return nil, err
 	}
+
+	events.Publish(events.Event{
+		Type:    "org_member.added",
+		Subject: orgId.String(),
+		Data:    map[string]interface{}{"entityId": entityId.String(), "type": memberType, "cascade": cascade},
+	})
 	return orgMember, nil
 }
 
@@ -32,6 +56,13 @@ func (s *OrgMemberService) RemoveMember(orgId, entityId uuid.UUID) (bool, error)
 	if err != nil {
 		return false, err
 	}
+	if affected > 0 {
+		events.Publish(events.Event{
+			Type:    "org_member.removed",
+			Subject: orgId.String(),
+			Data:    map[string]interface{}{"entityId": entityId.String()},
+		})
+	}
 	return affected > 0, nil
 }
 
@@ -39,6 +70,21 @@ func (s *OrgMemberService) GetMembersByOrgID(orgId uuid.UUID) ([]models.OrgMembe
 	return s.repo.FindByOrgID(orgId)
 }
 
+// GetInheritedMembersByOrgID returns the members of the org followed by the
+// cascading members of the orgs above it, nearest last. Inherited
+// memberships keep the ID of the org that holds them.
+func (s *OrgMemberService) GetInheritedMembersByOrgID(orgId uuid.UUID) ([]models.OrgMember, error) {
+	members, err := s.repo.FindByOrgID(orgId)
+	if err != nil {
+		return nil, err
+	}
+	inherited, err := s.repo.FindInheritedByOrgID(orgId)
+	if err != nil {
+		return nil, err
+	}
+	return append(members, inherited...), nil
+}
+
 func (s *OrgMemberService) GetMembersByEntityID(entityId uuid.UUID) ([]models.OrgMember, error) {
 	return s.repo.FindByEntityID(entityId)
 }
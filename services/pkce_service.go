@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
@@ -10,6 +11,7 @@ import (
 	"idmapp-go/dto"
 	"idmapp-go/internal/client"
 	"idmapp-go/internal/pkce"
+	"idmapp-go/internal/tenant"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -17,11 +19,17 @@ import (
 	"gorm.io/gorm"
 )
 
-var jwtSigningKey = []byte("your-256-bit-secret") // Replace with a secure key in production
+// ClaimsProvider adds claims to the access tokens issued for a user, such
+// as the user's permissions. Subjects it doesn't know get no claims. Its
+// statements run with ctx, scoped to the tenant the token is issued in.
+type ClaimsProvider interface {
+	TokenClaims(ctx context.Context, subject string) (map[string]interface{}, error)
+}
 
 type PKCEService struct {
-	logger *logrus.Logger
-	db     *gorm.DB
+	logger         *logrus.Logger
+	db             *gorm.DB
+	claimProviders []ClaimsProvider
 }
 
 func NewPKCEService(db *gorm.DB) *PKCEService {
@@ -31,6 +39,37 @@ func NewPKCEService(db *gorm.DB) *PKCEService {
 	}
 }
 
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *PKCEService) WithContext(ctx context.Context) *PKCEService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
+// AddClaimsProvider registers a provider of extra access token claims. Its
+// claims never replace the standard ones.
+func (s *PKCEService) AddClaimsProvider(provider ClaimsProvider) {
+	s.claimProviders = append(s.claimProviders, provider)
+}
+
+// addProvidedClaims adds the claims of the registered providers for the
+// subject
+func (s *PKCEService) addProvidedClaims(claims jwt.MapClaims, subject string) error {
+	for _, provider := range s.claimProviders {
+		extra, err := provider.TokenClaims(s.db.Statement.Context, subject)
+		if err != nil {
+			return fmt.Errorf("failed to get token claims: %w", err)
+		}
+		for name, value := range extra {
+			if _, exists := claims[name]; !exists {
+				claims[name] = value
+			}
+		}
+	}
+	return nil
+}
+
 // GenerateCodeVerifier generates a random code verifier for PKCE
 func (s *PKCEService) GenerateCodeVerifier() (string, error) {
 	bytes := make([]byte, 32)
@@ -55,8 +94,10 @@ func (s *PKCEService) GenerateState() (string, error) {
 	return base64.RawURLEncoding.EncodeToString(bytes), nil
 }
 
-// CreateAuthorizationCode stores a PKCE code in the DB and returns the code and state
-func (s *PKCEService) CreateAuthorizationCode(req dto.PKCEAuthRequest, userID *uuid.UUID) (string, string, string, error) {
+// CreateAuthorizationCode stores a PKCE code in the DB and returns the code and state.
+// Tokens issued for the code belong to the given login session and name
+// actorID as the acting party when the session is an impersonation.
+func (s *PKCEService) CreateAuthorizationCode(req dto.PKCEAuthRequest, userID, sessionID, actorID *uuid.UUID) (string, string, string, error) {
 	// For PKCE, the client generates the code_challenge
 	// We store the challenge and will validate it later when the client sends the code_verifier
 
@@ -82,6 +123,8 @@ func (s *PKCEService) CreateAuthorizationCode(req dto.PKCEAuthRequest, userID *u
 		RedirectURI:         req.RedirectURI,
 		State:               &state, // Store the state parameter as pointer
 		UserID:              userID,
+		SessionID:           sessionID,
+		ActorID:             actorID,
 		ExpiresAt:           time.Now().Add(10 * time.Minute),
 		Used:                false,
 	}
@@ -91,22 +134,23 @@ func (s *PKCEService) CreateAuthorizationCode(req dto.PKCEAuthRequest, userID *u
 	return code, state, "", nil
 }
 
-// ExchangeCodeForToken validates the code and code_verifier, then issues a JWT
-func (s *PKCEService) ExchangeCodeForToken(req dto.PKCETokenRequest) (*dto.PKCETokenResponse, error) {
+// ExchangeCodeForToken validates the code and code_verifier, then issues a JWT.
+// It also returns the login session the code was issued for, if any.
+func (s *PKCEService) ExchangeCodeForToken(req dto.PKCETokenRequest) (*dto.PKCETokenResponse, *uuid.UUID, error) {
 	s.logger.Info("=== EXCHANGE CODE FOR TOKEN CALLED ===")
 	s.logger.Infof("Received request: %+v", req)
 
 	var pkceCode pkce.PKCECode
 	if err := s.db.Where("code = ? AND client_id = ? AND redirect_uri = ? AND used = false", req.Code, req.ClientID, req.RedirectURI).First(&pkceCode).Error; err != nil {
-		return nil, fmt.Errorf("invalid or expired authorization code")
+		return nil, nil, fmt.Errorf("invalid or expired authorization code")
 	}
 	if time.Now().After(pkceCode.ExpiresAt) {
-		return nil, fmt.Errorf("authorization code expired")
+		return nil, nil, fmt.Errorf("authorization code expired")
 	}
 
 	// Validate state parameter if provided
 	if req.State != "" && (pkceCode.State == nil || *pkceCode.State != req.State) {
-		return nil, fmt.Errorf("invalid state parameter")
+		return nil, nil, fmt.Errorf("invalid state parameter")
 	}
 
 	if pkceCode.CodeChallengeMethod == "S256" {
@@ -115,14 +159,14 @@ func (s *PKCEService) ExchangeCodeForToken(req dto.PKCETokenRequest) (*dto.PKCET
 		s.logger.Debugf("Generated challenge: %s", challenge)
 		s.logger.Debugf("Stored challenge: %s", pkceCode.CodeChallenge)
 		if challenge != pkceCode.CodeChallenge {
-			return nil, fmt.Errorf("invalid code_verifier for S256")
+			return nil, nil, fmt.Errorf("invalid code_verifier for S256")
 		}
 	} else if pkceCode.CodeChallengeMethod == "plain" {
 		if req.CodeVerifier != pkceCode.CodeChallenge {
-			return nil, fmt.Errorf("invalid code_verifier for plain method")
+			return nil, nil, fmt.Errorf("invalid code_verifier for plain method")
 		}
 	} else {
-		return nil, fmt.Errorf("unsupported code_challenge_method")
+		return nil, nil, fmt.Errorf("unsupported code_challenge_method")
 	}
 	// Mark code as used
 	pkceCode.Used = true
@@ -130,21 +174,38 @@ func (s *PKCEService) ExchangeCodeForToken(req dto.PKCETokenRequest) (*dto.PKCET
 	// Issue JWT
 	claims := jwt.MapClaims{
 		"sub": pkceCode.UserID,
-		"aud": req.ClientID,
+		"aud": []string{req.ClientID, dto.APIAudience},
 		"exp": time.Now().Add(1 * time.Hour).Unix(),
 		"iat": time.Now().Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString(jwtSigningKey)
+	if pkceCode.SessionID != nil {
+		claims["sid"] = pkceCode.SessionID.String()
+	}
+	if pkceCode.ActorID != nil {
+		claims["act"] = dto.Actor{Sub: pkceCode.ActorID.String()}
+	}
+	subject := ""
+	if pkceCode.UserID != nil {
+		subject = pkceCode.UserID.String()
+		if err := s.addProvidedClaims(claims, subject); err != nil {
+			return nil, nil, err
+		}
+	}
+	tenantID, err := s.tokenTenant(subject, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	signedToken, err := tenant.SignToken(claims, tenantID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to sign token: %w", err)
+		return nil, nil, fmt.Errorf("failed to sign token: %w", err)
 	}
+
 	return &dto.PKCETokenResponse{
 		AccessToken: signedToken,
 		TokenType:   "Bearer",
 		ExpiresIn:   3600,
 		Scope:       "openid profile email",
-	}, nil
+	}, pkceCode.SessionID, nil
 }
 
 // ValidatePKCEFlow validates the PKCE flow parameters
@@ -179,59 +240,78 @@ func (s *PKCEService) ValidatePKCEFlow(req dto.PKCEAuthRequest) error {
 	return nil
 }
 
-// RefreshToken refreshes an access token using a refresh token
-func (s *PKCEService) RefreshToken(refreshToken string, clientID string) (*dto.PKCETokenResponse, error) {
-	// Parse and validate the refresh token
-	token, err := jwt.Parse(refreshToken, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return jwtSigningKey, nil
-	})
-
-	if err != nil || !token.Valid {
-		return nil, fmt.Errorf("invalid refresh token")
-	}
-
-	// Extract claims from refresh token
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("invalid token claims")
-	}
-
-	// Get user ID from claims
-	userID, ok := claims["sub"].(string)
-	if !ok {
-		return nil, fmt.Errorf("invalid user ID in token")
-	}
-
-	// Generate new access token
-	accessToken, err := s.GenerateAccessToken(userID, claims["email"].(string))
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate access token: %w", err)
-	}
-
-	return &dto.PKCETokenResponse{
-		AccessToken: accessToken,
-		TokenType:   "Bearer",
-		ExpiresIn:   3600,
-		Scope:       "openid profile email",
-	}, nil
+// AccessTokenOptions customises the tokens issued by GenerateAccessToken
+type AccessTokenOptions struct {
+	// SessionID is the server-side session the token belongs to
+	SessionID string
+	// Actor is set when someone else acts on behalf of the user
+	Actor *dto.Actor
+	// TTL defaults to one hour
+	TTL time.Duration
+	// Audience restricts the services the token is meant for
+	Audience []string
+	// Scope is a space-delimited list of scopes granted to the token
+	Scope string
+	// ClientID is set on tokens issued to a client acting for itself
+	ClientID string
 }
 
 // GenerateAccessToken generates a JWT access token for a user
-func (s *PKCEService) GenerateAccessToken(userID string, email string) (string, error) {
+func (s *PKCEService) GenerateAccessToken(userID string, email string, opts AccessTokenOptions) (string, error) {
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
 	claims := jwt.MapClaims{
 		"sub":   userID,
 		"email": email,
-		"exp":   time.Now().Add(1 * time.Hour).Unix(),
+		"exp":   time.Now().Add(ttl).Unix(),
 		"iat":   time.Now().Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	signedToken, err := token.SignedString(jwtSigningKey)
+	if opts.SessionID != "" {
+		claims["sid"] = opts.SessionID
+	}
+	if opts.Actor != nil {
+		claims["act"] = opts.Actor
+	}
+	if len(opts.Audience) > 0 {
+		claims["aud"] = opts.Audience
+	}
+	if opts.Scope != "" {
+		claims["scope"] = opts.Scope
+	}
+	if opts.ClientID != "" {
+		claims["client_id"] = opts.ClientID
+	} else if err := s.addProvidedClaims(claims, userID); err != nil {
+		return "", err
+	}
+	tenantID, err := s.tokenTenant(userID, opts.ClientID)
+	if err != nil {
+		return "", err
+	}
+	signedToken, err := tenant.SignToken(claims, tenantID)
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
 	return signedToken, nil
 }
+
+// tokenTenant returns the tenant a token is issued in: the one of the
+// user, or of the client for tokens clients get for themselves. Tokens
+// without a known subject are issued in the default tenant.
+func (s *PKCEService) tokenTenant(userID, clientID string) (uuid.UUID, error) {
+	query := s.db.Table("users").Where("id = ?", userID)
+	if clientID != "" {
+		query = s.db.Table("clients").Where("client_id = ?", clientID)
+	} else if userID == "" {
+		return tenant.DefaultID, nil
+	}
+	var tenantIDs []uuid.UUID
+	if err := query.Pluck("tenant_id", &tenantIDs).Error; err != nil {
+		return uuid.Nil, fmt.Errorf("failed to get tenant of token subject: %w", err)
+	}
+	if len(tenantIDs) == 0 {
+		return tenant.DefaultID, nil
+	}
+	return tenantIDs[0], nil
+}
@@ -1,6 +1,10 @@
 package services
 
 import (
+	"context"
+	"time"
+
+	"idmapp-go/internal/events"
 	"idmapp-go/models"
 	"idmapp-go/repository"
 
@@ -15,15 +19,42 @@ func NewRoleMemberService(repo *repository.RoleMemberRepository) *RoleMemberServ
 	return &RoleMemberService{repo: repo}
 }
 
-func (s *RoleMemberService) AddMember(roleId, entityId uuid.UUID, memberType string) (*models.RoleMember, error) {
+// WithContext returns a copy of the service whose statements run with ctx,
+// scoped to its tenant
+func (s *RoleMemberService) WithContext(ctx context.Context) *RoleMemberService {
+	scoped := *s
+	scoped.repo = s.repo.WithContext(ctx)
+	return &scoped
+}
+
+// AddMember assigns the role to the entity, from validFrom until validUntil
+// when they are set
+func (s *RoleMemberService) AddMember(roleId, entityId uuid.UUID, memberType string, validFrom, validUntil *time.Time) (*models.RoleMember, error) {
+	now := time.Now()
+	validity, err := models.NewValidity(validFrom, validUntil, now)
+	if err != nil {
+		return nil, err
+	}
 	roleMember := &models.RoleMember{
-		RoleID:   roleId,
-		EntityID: entityId,
-		Type:     memberType,
+		RoleID:    roleId,
+		EntityID:  entityId,
+		Type:      memberType,
+		CreatedAt: now,
+		Validity:  validity,
 	}
 	if err := s.repo.Save(roleMember); err != nil {
 		return nil, err
 	}
+
+	eventType := "role_member.added"
+	if validity.Scheduled(now) {
+		eventType = "role_member.scheduled"
+	}
+	events.Publish(events.Event{
+		Type:    eventType,
+		Subject: roleId.String(),
+		Data:    validity.EventData(map[string]interface{}{"entityId": entityId.String(), "type": memberType}),
+	})
 	return roleMember, nil
 }
 
@@ -32,6 +63,13 @@ func (s *RoleMemberService) RemoveMember(roleId, entityId uuid.UUID) (bool, erro
 	if err != nil {
 		return false, err
 	}
+	if affected > 0 {
+		events.Publish(events.Event{
+			Type:    "role_member.removed",
+			Subject: roleId.String(),
+			Data:    map[string]interface{}{"entityId": entityId.String()},
+		})
+	}
 	return affected > 0, nil
 }
 
@@ -18,9 +18,8 @@ BASE_URL="http://localhost:8080/api/v1"
 # Get local JWT token
 echo -e "${YELLOW}Getting local JWT token...${NC}"
 
-# For testing purposes, use a simple test token
-# In production, you would get this from your local authentication endpoint
-TOKEN="test-token"
+# Use a personal access token created with POST /api/v1/me/tokens
+TOKEN="${IDM_TOKEN:?Set IDM_TOKEN to a personal access token}"
 
 echo -e "${GREEN}Local JWT token obtained successfully${NC}"
 
@@ -21,9 +21,8 @@ curl -s -X POST $BASE_URL/auth/login \
 # First, let's create a proper JWT token for testing
 echo -e "\n3. Testing with a mock JWT token:"
 
-# Create a simple JWT token (this is just for testing)
-# In production, you would get this from your local authentication endpoint
-TOKEN="test-token"
+# Use a personal access token created with POST /api/v1/me/tokens
+TOKEN="${IDM_TOKEN:?Set IDM_TOKEN to a personal access token}"
 
 echo "Using mock token: $TOKEN"
 
@@ -21,11 +21,11 @@ curl -s -X POST $BASE_URL/users \
     "password": "password123"
   }' | jq .
 
-# Test 3: Try with a simple test token
-echo -e "\n3. Try with a simple test token:"
+# Test 3: Try with a personal access token (IDM_TOKEN)
+echo -e "\n3. Try with a personal access token (IDM_TOKEN):"
 curl -s -X POST $BASE_URL/users \
   -H "Content-Type: application/json" \
-  -H "Authorization: Bearer test-token" \
+  -H "Authorization: Bearer $IDM_TOKEN" \
   -d '{
     "name": "test_user",
     "firstName": "Test",
@@ -34,9 +34,9 @@ curl -s -X POST $BASE_URL/users \
     "password": "password123"
   }' | jq .
 
-# Test 4: Get all users with test token
-echo -e "\n4. Get all users with test token:"
+# Test 4: Get all users with personal access token
+echo -e "\n4. Get all users with personal access token:"
 curl -s -X GET $BASE_URL/users \
-  -H "Authorization: Bearer test-token" | jq .
+  -H "Authorization: Bearer $IDM_TOKEN" | jq .
 
 echo -e "\nSimple test completed!" 
\ No newline at end of file